@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"squ1d++/token"
+	"strconv"
 	"strings"
 )
 
@@ -37,6 +38,28 @@ type LetStatement struct {
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 
+// DestructureStatement is `var (a, b) = value`, which assigns the elements
+// of a tuple or an array to the names in order.
+type DestructureStatement struct {
+	Token token.Token // the var token
+	Names []*Identifier
+	Value Expression
+}
+
+func (ds *DestructureStatement) statementNode()       {}
+func (ds *DestructureStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DestructureStatement) String() string {
+	names := []string{}
+	for _, name := range ds.Names {
+		names = append(names, name.String())
+	}
+	out := ds.TokenLiteral() + " (" + strings.Join(names, ", ") + ") = "
+	if ds.Value != nil {
+		out += ds.Value.String()
+	}
+	return out + ";"
+}
+
 type ReturnStatement struct {
 	Token       token.Token
 	ReturnValue Expression
@@ -125,6 +148,26 @@ func (fs *ForStatement) String() string {
 	return out.String()
 }
 
+// ForInStatement is `for (x in collection) { ... }` or
+// `for (key, value in collection) { ... }`; see object.Iterator for what the
+// names get.
+type ForInStatement struct {
+	Token    token.Token // the for token
+	Names    []*Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) String() string {
+	names := []string{}
+	for _, name := range fs.Names {
+		names = append(names, name.String())
+	}
+	return "for(" + strings.Join(names, ", ") + " in " + fs.Iterable.String() + ") " + fs.Body.String()
+}
+
 func (p *Program) TokenLiteral() string {
 	if len(p.Statements) > 0 {
 		return p.Statements[0].TokenLiteral()
@@ -165,6 +208,12 @@ type FunctionLiteral struct {
 	Parameters []*Identifier
 	Body       *BlockStatement
 	Name       string
+	// ReturnType is the annotation after the parameter list, as in
+	// `def(a: int): int { ... }`; nil when there is none.
+	ReturnType *TypeAnnotation
+	// Async is set for `async def`: calling the function spawns a task
+	// running it and returns the task's handle.
+	Async bool
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -174,16 +223,23 @@ func (fl *FunctionLiteral) String() string {
 
 	params := []string{}
 	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+		params = append(params, p.annotated())
 	}
 
+	if fl.Async {
+		out.WriteString("async ")
+	}
 	out.WriteString(fl.TokenLiteral())
 	if fl.Name != "" {
 		out.WriteString(fmt.Sprintf("<%s>", fl.Name))
 	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(": " + fl.ReturnType.String())
+	}
+	out.WriteString(" ")
 	out.WriteString(fl.Body.String())
 
 	return out.String()
@@ -240,6 +296,35 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression is `left[start:end]`. Start and End are nil when they
+// are left out.
+type SliceExpression struct {
+	Token token.Token // the [ token
+	Left  Expression
+	Start Expression
+	End   Expression
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 type DotExpression struct {
 	Token token.Token
 	Left  Expression
@@ -260,9 +345,48 @@ func (de *DotExpression) String() string {
 	return out.String()
 }
 
+// SetLiteral is a set written as {a, b, c}: like a hash literal, but
+// without values.
+type SetLiteral struct {
+	Token    token.Token
+	Elements []Expression
+}
+
+func (sl *SetLiteral) expressionNode()      {}
+func (sl *SetLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *SetLiteral) String() string {
+	elements := []string{}
+	for _, el := range sl.Elements {
+		elements = append(elements, el.String())
+	}
+	return "{" + strings.Join(elements, ", ") + "}"
+}
+
+// TupleLiteral is a tuple written as (a, b). A single element needs a
+// trailing comma, (a,), to tell it from a parenthesized expression.
+type TupleLiteral struct {
+	Token    token.Token // the ( token
+	Elements []Expression
+}
+
+func (tl *TupleLiteral) expressionNode()      {}
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TupleLiteral) String() string {
+	elements := []string{}
+	for _, el := range tl.Elements {
+		elements = append(elements, el.String())
+	}
+	if len(elements) == 1 {
+		return "(" + elements[0] + ",)"
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+	// Keys are the keys of Pairs in source order.
+	Keys []Expression
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -270,8 +394,8 @@ func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -283,6 +407,9 @@ type PrefixExpression struct {
 	Token    token.Token
 	Operator string
 	Right    Expression
+	// Grouped is set when the source wrapped the expression in
+	// parentheses, so the formatter can keep them.
+	Grouped bool
 }
 
 func (pe *PrefixExpression) expressionNode()      {}
@@ -298,11 +425,40 @@ func (pe *PrefixExpression) String() string {
 	return out.String()
 }
 
+// AwaitExpression waits for the task Value evaluates to and gives its
+// result. Values that aren't tasks are given as they are.
+type AwaitExpression struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ae *AwaitExpression) expressionNode()      {}
+func (ae *AwaitExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AwaitExpression) String() string {
+	return "(await " + ae.Value.String() + ")"
+}
+
+// SpawnExpression, `spawn def() { ... }`, runs Function as a task and gives
+// the task's handle, like spawn(Function).
+type SpawnExpression struct {
+	Token    token.Token
+	Function Expression
+}
+
+func (se *SpawnExpression) expressionNode()      {}
+func (se *SpawnExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpawnExpression) String() string {
+	return "(spawn " + se.Function.String() + ")"
+}
+
 type InfixExpression struct {
 	Token    token.Token
 	Left     Expression
 	Operator string
 	Right    Expression
+	// Grouped is set when the source wrapped the expression in
+	// parentheses, so the formatter can keep them.
+	Grouped bool
 }
 
 func (oe *InfixExpression) expressionNode()      {}
@@ -388,6 +544,81 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// MatchExpression is `match (subject) { case 1, 2 { ... } el { ... } }`.
+// Its value is the value of the block of the first arm with a pattern
+// matching the subject, of the el block when none has one, or null.
+//
+// A pattern is a literal, which matches an equal value; a name, which
+// matches anything and binds it (`_` binds nothing); or an array, tuple or
+// hash literal of patterns, which matches a value of that shape whose
+// elements match: `case {type: "user", name: n} { ... }`.
+type MatchExpression struct {
+	Token   token.Token // the match token
+	Subject Expression
+	Arms    []*MatchArm
+	Default *BlockStatement
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("match(" + me.Subject.String() + ") {")
+	for _, arm := range me.Arms {
+		out.WriteString(" " + arm.String())
+	}
+	if me.Default != nil {
+		out.WriteString(" el " + me.Default.String())
+	}
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// MatchArm is one `case pattern, ... if (guard) { ... }` of a match
+// expression. The arm is taken when one of its patterns matches and the
+// optional guard is truthy.
+type MatchArm struct {
+	Token    token.Token // the case token
+	Patterns []Expression
+	Guard    Expression
+	Body     *BlockStatement
+}
+
+func (ma *MatchArm) TokenLiteral() string { return ma.Token.Literal }
+func (ma *MatchArm) String() string {
+	patterns := []string{}
+	for _, p := range ma.Patterns {
+		patterns = append(patterns, p.String())
+	}
+	guard := ""
+	if ma.Guard != nil {
+		guard = " if " + ma.Guard.String()
+	}
+	return "case " + strings.Join(patterns, ", ") + guard + " " + ma.Body.String()
+}
+
+// AttemptExpression is `attempt { ... } rescue (e) { ... }`. Its value is
+// the value of the attempt block or, when an error is raised while it runs,
+// of the rescue block, with the error bound to Name if there is one.
+type AttemptExpression struct {
+	Token  token.Token // the attempt token
+	Body   *BlockStatement
+	Name   *Identifier
+	Rescue *BlockStatement
+}
+
+func (ae *AttemptExpression) expressionNode()      {}
+func (ae *AttemptExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AttemptExpression) String() string {
+	name := ""
+	if ae.Name != nil {
+		name = "(" + ae.Name.String() + ") "
+	}
+	return "attempt " + ae.Body.String() + " rescue " + name + ae.Rescue.String()
+}
+
 type WhileExpression struct {
 	Token     token.Token
 	Condition Expression
@@ -427,12 +658,33 @@ func (bs *BlockStatement) String() string {
 type Identifier struct {
 	Token token.Token
 	Value string
+	// Type is the annotation of a variable or a parameter, as in
+	// `var x: int = 5`; nil when there is none.
+	Type *TypeAnnotation
 }
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
 
+// annotated writes the identifier with its type annotation, if any.
+func (i *Identifier) annotated() string {
+	if i.Type == nil {
+		return i.Value
+	}
+	return i.Value + ": " + i.Type.String()
+}
+
+// TypeAnnotation is the `: type` after a variable, a parameter or the
+// parameter list of a function.
+type TypeAnnotation struct {
+	Token token.Token // the type name
+	Name  string
+}
+
+func (ta *TypeAnnotation) TokenLiteral() string { return ta.Token.Literal }
+func (ta *TypeAnnotation) String() string       { return ta.Name }
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -449,7 +701,7 @@ func (ls *LetStatement) String() string {
 		out.WriteString("unblock ")
 	}
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	out.WriteString(ls.Name.annotated())
 	out.WriteString(" = ")
 	if ls.ErrorPipe {
 		out.WriteString("<< ")
@@ -539,3 +791,109 @@ func (ss *SuppressStatement) StatementTokenString() string {
 		return "<stmt>"
 	}
 }
+
+// BenchStatement is a `bench "name" { ... }` block. Benchmarks are skipped
+// when a program runs normally; `squ1dcc bench` runs their bodies repeatedly
+// and reports timings.
+type BenchStatement struct {
+	Token token.Token // the `bench` identifier
+	Name  string
+	Body  *BlockStatement
+}
+
+func (bs *BenchStatement) statementNode()       {}
+func (bs *BenchStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BenchStatement) String() string {
+	return "bench " + strconv.Quote(bs.Name) + " " + bs.Body.String()
+}
+
+// ClassStatement is a `class Name { def method(params) { ... } ... }`
+// definition. The methods' Name is the name they are defined under.
+type ClassStatement struct {
+	Token   token.Token // the `class` identifier
+	Name    *Identifier
+	Methods []*FunctionLiteral
+}
+
+func (cs *ClassStatement) statementNode()       {}
+func (cs *ClassStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ClassStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("class " + cs.Name.String() + " {")
+	for _, m := range cs.Methods {
+		params := []string{}
+		for _, p := range m.Parameters {
+			params = append(params, p.annotated())
+		}
+		out.WriteString(" def " + m.Name + "(" + strings.Join(params, ", ") + ")")
+		if m.ReturnType != nil {
+			out.WriteString(": " + m.ReturnType.String())
+		}
+		out.WriteString(" " + m.Body.String())
+	}
+	out.WriteString(" }")
+	return out.String()
+}
+
+// ExportStatement is `export` before a definition, as in `export var x = 1`
+// or `export f >> (a) { ... }`, or before a list of names defined
+// earlier, as in `export f, g`. A file with exports only exposes those
+// names through the namespace it is included under.
+type ExportStatement struct {
+	Token token.Token // the `export` identifier
+	// Statement is the definition, nil for a list of names.
+	Statement Statement
+	// Names are the exported names: those Statement defines or the listed
+	// ones.
+	Names []*Identifier
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) String() string {
+	if es.Statement != nil {
+		return "export " + es.Statement.String()
+	}
+	names := make([]string, len(es.Names))
+	for i, name := range es.Names {
+		names[i] = name.Value
+	}
+	return "export " + strings.Join(names, ", ")
+}
+
+// StatementLine returns the line stmt starts on, or 0 when unknown.
+func StatementLine(stmt Statement) int {
+	switch s := stmt.(type) {
+	case *LetStatement:
+		// Shorthand function definitions carry their closing brace as
+		// token, so use the name.
+		return s.Name.Token.Line
+	case *DestructureStatement:
+		return s.Token.Line
+	case *ReturnStatement:
+		return s.Token.Line
+	case *ExpressionStatement:
+		return s.Token.Line
+	case *WhileStatement:
+		return s.Token.Line
+	case *ForStatement:
+		return s.Token.Line
+	case *BreakStatement:
+		return s.Token.Line
+	case *ContinueStatement:
+		return s.Token.Line
+	case *BlockStatement:
+		return s.Token.Line
+	case *BlockDirective:
+		return s.Token.Line
+	case *SuppressStatement:
+		return s.Token.Line
+	case *BenchStatement:
+		return s.Token.Line
+	case *ClassStatement:
+		return s.Token.Line
+	case *ExportStatement:
+		return s.Token.Line
+	}
+	return 0
+}
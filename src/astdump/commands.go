@@ -0,0 +1,81 @@
+package astdump
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"squ1d++/lexer"
+	"squ1d++/parser"
+	"strings"
+)
+
+// Run implements `squ1dcc ast [--json] file.sqd`, printing the parsed tree
+// of the file (or stdin when the file is "-") as an indented outline, or as
+// JSON with --json. Flags may come before or after the file.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ast", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(stderr) }
+
+	asJSON := fs.Bool("json", false, "Print the tree as JSON")
+
+	var files []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		files = append(files, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(files) != 1 {
+		printUsage(stderr)
+		return 2
+	}
+
+	var src []byte
+	var err error
+	if files[0] == "-" {
+		src, err = io.ReadAll(stdin)
+	} else {
+		src, err = os.ReadFile(files[0])
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "ast: %v\n", err)
+		return 1
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprintf(stderr, "%s: %s\n", files[0], strings.Join(p.Errors(), "\n"))
+		return 1
+	}
+
+	tree := Dump(program)
+	if !*asJSON {
+		fmt.Fprint(stdout, Text(tree))
+		return 0
+	}
+
+	out, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		fmt.Fprintf(stderr, "ast: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, string(out))
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: squ1dcc ast [--json] file.sqd")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Prints the parsed syntax tree of file.sqd (or stdin for -) with")
+	fmt.Fprintln(w, "the position of every token.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  --json   print the tree as JSON")
+}
@@ -0,0 +1,239 @@
+// Package astdump turns parsed SQU1DLang programs into a structured tree
+// that can be printed as text or JSON, so tools can inspect the parse
+// without re-implementing the parser.
+//
+// Every AST node becomes a Node named after its Go type (LetStatement,
+// InfixExpression, ...) with its token and its fields in declaration order.
+// Field names are the Go field names with a lower-case first letter.
+package astdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"squ1d++/ast"
+	"squ1d++/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Token is a token with its position. Line and Column are 1-based; both are
+// 0 for tokens the parser made up, such as the name in a.b.
+type Token struct {
+	Type    string `json:"type"`
+	Literal string `json:"literal"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+// Field is one named field of a Node. Value is a *Node, a []interface{} of
+// values, a *Token, a Pair list, a string, bool or number, or nil.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Pair is one key/value entry of a hash literal.
+type Pair struct {
+	Key   *Node `json:"key"`
+	Value *Node `json:"value"`
+}
+
+// Node is one AST node.
+type Node struct {
+	Type   string
+	Token  *Token
+	Fields []Field
+}
+
+// MarshalJSON writes the node as {"node": type, "token": ..., fields...},
+// keeping fields in declaration order.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"node":`)
+	typ, _ := json.Marshal(n.Type)
+	buf.Write(typ)
+	if n.Token != nil {
+		tok, err := json.Marshal(n.Token)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"token":`)
+		buf.Write(tok)
+	}
+	for _, field := range n.Fields {
+		name, _ := json.Marshal(field.Name)
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var (
+	nodeType  = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	tokenType = reflect.TypeOf(token.Token{})
+)
+
+// Dump converts an AST node, usually an *ast.Program, into a Node. It
+// returns nil for a nil node.
+func Dump(node ast.Node) *Node {
+	v := reflect.ValueOf(node)
+	if node == nil || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return nil
+	}
+	v = reflect.Indirect(v)
+
+	n := &Node{Type: v.Type().Name()}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Name == "Token" && f.Type == tokenType {
+			n.Token = dumpToken(v.Field(i).Interface().(token.Token))
+			continue
+		}
+		n.Fields = append(n.Fields, Field{Name: fieldName(f.Name), Value: dumpValue(v.Field(i))})
+	}
+	return n
+}
+
+func dumpValue(v reflect.Value) interface{} {
+	if v.Type() == tokenType {
+		return dumpToken(v.Interface().(token.Token))
+	}
+	if v.Type().Implements(nodeType) || v.Type() == nodeType {
+		if v.IsNil() {
+			return nil
+		}
+		return Dump(v.Interface().(ast.Node))
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return dumpValue(v.Elem())
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = dumpValue(v.Index(i))
+		}
+		return items
+	case reflect.Map:
+		// Hash literal pairs, ordered by where their keys appear.
+		pairs := []Pair{}
+		for _, key := range v.MapKeys() {
+			k, _ := key.Interface().(ast.Node)
+			val, _ := v.MapIndex(key).Interface().(ast.Node)
+			pairs = append(pairs, Pair{Key: Dump(k), Value: Dump(val)})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return before(pairs[i].Key, pairs[j].Key)
+		})
+		return pairs
+	}
+	return v.Interface()
+}
+
+func dumpToken(tok token.Token) *Token {
+	return &Token{Type: string(tok.Type), Literal: tok.Literal, Line: tok.Line, Column: tok.Column}
+}
+
+// before orders hash keys by their token position, then by literal for keys
+// without one.
+func before(a, b *Node) bool {
+	if a == nil || b == nil || a.Token == nil || b.Token == nil {
+		return b != nil && a == nil
+	}
+	if a.Token.Line != b.Token.Line {
+		return a.Token.Line < b.Token.Line
+	}
+	if a.Token.Column != b.Token.Column {
+		return a.Token.Column < b.Token.Column
+	}
+	return a.Token.Literal < b.Token.Literal
+}
+
+func fieldName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
+
+// Text renders n as an indented tree, one node per line with its token
+// literal and line:column.
+func Text(n *Node) string {
+	var out strings.Builder
+	writeText(&out, "", "", n)
+	return out.String()
+}
+
+func writeText(out *strings.Builder, indent, label string, value interface{}) {
+	out.WriteString(indent)
+	if label != "" {
+		out.WriteString(label + ": ")
+	}
+
+	switch v := value.(type) {
+	case *Node:
+		if v == nil {
+			out.WriteString("nil\n")
+			return
+		}
+		out.WriteString(v.Type)
+		if v.Token != nil {
+			out.WriteString(" " + tokenText(v.Token))
+		}
+		out.WriteString("\n")
+		for _, field := range v.Fields {
+			writeText(out, indent+"  ", field.Name, field.Value)
+		}
+	case []interface{}:
+		out.WriteString("[")
+		if len(v) == 0 {
+			out.WriteString("]\n")
+			return
+		}
+		out.WriteString("\n")
+		for _, item := range v {
+			writeText(out, indent+"  ", "", item)
+		}
+		out.WriteString(indent + "]\n")
+	case []Pair:
+		out.WriteString("{")
+		if len(v) == 0 {
+			out.WriteString("}\n")
+			return
+		}
+		out.WriteString("\n")
+		for _, pair := range v {
+			writeText(out, indent+"  ", "key", pair.Key)
+			writeText(out, indent+"  ", "value", pair.Value)
+		}
+		out.WriteString(indent + "}\n")
+	case *Token:
+		out.WriteString(tokenText(v) + "\n")
+	case nil:
+		out.WriteString("nil\n")
+	default:
+		b, _ := json.Marshal(v)
+		out.Write(b)
+		out.WriteString("\n")
+	}
+}
+
+func tokenText(tok *Token) string {
+	literal, _ := json.Marshal(tok.Literal)
+	return string(literal) + " " + strconv.Itoa(tok.Line) + ":" + strconv.Itoa(tok.Column)
+}
@@ -0,0 +1,102 @@
+package astdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"squ1d++/lexer"
+	"squ1d++/parser"
+	"strings"
+	"testing"
+)
+
+func dump(t *testing.T, input string) *Node {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return Dump(program)
+}
+
+func TestDumpJSON(t *testing.T) {
+	out, err := json.Marshal(dump(t, "x + 1"))
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+
+	expected := `{"node":"Program","statements":[{"node":"ExpressionStatement",` +
+		`"token":{"type":"IDENT","literal":"x","line":1,"column":1},` +
+		`"expression":{"node":"InfixExpression","token":{"type":"+","literal":"+","line":1,"column":3},` +
+		`"left":{"node":"Identifier","token":{"type":"IDENT","literal":"x","line":1,"column":1},"value":"x","type":null},` +
+		`"operator":"+",` +
+		`"right":{"node":"IntegerLiteral","token":{"type":"INT","literal":"1","line":1,"column":5},"value":1},` +
+		`"grouped":false}}]}`
+	if string(out) != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestDumpOrdersHashPairsBySource(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		text := Text(dump(t, "{b: 1, a: 2, c: 3}"))
+		b, a, c := strings.Index(text, `"b"`), strings.Index(text, `"a"`), strings.Index(text, `"c"`)
+		if !(b < a && a < c) {
+			t.Fatalf("expected pairs in source order, got:\n%s", text)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	expected := `Program
+  statements: [
+    LetStatement "var" 1:1
+      name: Identifier "f" 1:5
+        value: "f"
+        type: nil
+      value: FunctionLiteral "def" 1:9
+        parameters: [
+          Identifier "a" 1:13
+            value: "a"
+            type: TypeAnnotation "int" 1:16
+              name: "int"
+        ]
+        body: BlockStatement "{" 1:21
+          statements: []
+        name: "f"
+        returnType: nil
+        async: false
+      unblock: false
+      errorPipe: false
+  ]
+`
+	if got := Text(dump(t, "var f = def(a: int) {}")); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestRunAcceptsFlagAfterFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.sqd")
+	if err := os.WriteFile(file, []byte("io.echo(1)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{file, "--json"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+	}
+	var tree map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &tree); err != nil {
+		t.Fatalf("output isn't JSON: %v\n%s", err, stdout.String())
+	}
+	if tree["node"] != "Program" {
+		t.Fatalf("expected a Program node, got %v", tree["node"])
+	}
+
+	stdout.Reset()
+	if code := Run([]string{"-"}, strings.NewReader("var = 1"), &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1 for a parse error, got %d", code)
+	}
+}
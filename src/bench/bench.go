@@ -0,0 +1,155 @@
+// Package bench runs the `bench "name" { ... }` blocks of a SQU1DLang
+// program. The program runs once to set up its globals, then each benchmark
+// body is called repeatedly on the same VM until it has run for long enough
+// to time reliably.
+package bench
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"squ1d++/ast"
+	"squ1d++/builder"
+	"squ1d++/compiler"
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"squ1d++/vm"
+	"strings"
+	"time"
+)
+
+// Options control a benchmark run.
+type Options struct {
+	// Time is how long each benchmark should run for at least.
+	Time time.Duration
+	// Run selects benchmarks by name; nil runs all of them.
+	Run *regexp.Regexp
+}
+
+// maxIterations caps the iterations of a single benchmark.
+const maxIterations = 1e9
+
+// Result is the outcome of one benchmark.
+type Result struct {
+	Name string
+	// N is the number of iterations timed.
+	N int
+	// NsPerOp is the average time per iteration.
+	NsPerOp float64
+	// InstructionsPerOp is the average number of VM instructions per
+	// iteration, a machine-independent measure of the work done.
+	InstructionsPerOp float64
+}
+
+// Benchmarks returns the top-level benchmarks of program, in order.
+func Benchmarks(program *ast.Program) []*ast.BenchStatement {
+	var benches []*ast.BenchStatement
+	for _, stmt := range program.Statements {
+		if b, ok := stmt.(*ast.BenchStatement); ok {
+			benches = append(benches, b)
+		}
+	}
+	return benches
+}
+
+// Source parses, compiles and runs src, then runs its benchmarks. report is
+// called with each result as soon as it is measured.
+func Source(src string, opts Options, report func(Result)) error {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return fmt.Errorf("parse error: %s", strings.Join(p.Errors(), "\n"))
+	}
+
+	var benches []*ast.BenchStatement
+	for _, b := range Benchmarks(program) {
+		if opts.Run == nil || opts.Run.MatchString(b.Name) {
+			benches = append(benches, b)
+		}
+	}
+	if len(benches) == 0 {
+		return nil
+	}
+
+	// The program's last value is an array holding every benchmark body as
+	// a function, so the bodies see all of the program's globals.
+	fns := &ast.ArrayLiteral{}
+	for _, b := range benches {
+		fns.Elements = append(fns.Elements, &ast.FunctionLiteral{Token: b.Token, Body: b.Body})
+	}
+	program.Statements = append(program.Statements, &ast.ExpressionStatement{Token: benches[0].Token, Expression: fns})
+
+	comp := compiler.New()
+	comp.SetOptimizationLevel(builder.OptimizationLevel)
+	if err := comp.Compile(program); err != nil {
+		return err
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return err
+	}
+	closures, ok := machine.LastPoppedStackElem().(*object.Array)
+	if !ok || len(closures.Elements) != len(benches) {
+		return fmt.Errorf("could not set up benchmarks")
+	}
+
+	// Benchmarks run far past the usual instruction limit.
+	limit := object.SysMaxInstructionCount
+	object.SysMaxInstructionCount = math.MaxInt
+	defer func() { object.SysMaxInstructionCount = limit }()
+
+	for i, b := range benches {
+		cl, ok := closures.Elements[i].(*object.Closure)
+		if !ok {
+			return fmt.Errorf("bench %q: could not set up benchmark", b.Name)
+		}
+		result, err := run(machine, cl, opts.Time)
+		if err != nil {
+			return fmt.Errorf("bench %q: %v", b.Name, err)
+		}
+		result.Name = b.Name
+		report(result)
+	}
+	return nil
+}
+
+// run times cl, growing the number of iterations until a round takes at
+// least d, and returns the figures of the last round.
+func run(machine *vm.VM, cl *object.Closure, d time.Duration) (Result, error) {
+	n := 1
+	for {
+		instructions := machine.InstructionCount()
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			value, err := machine.Call(cl)
+			if err != nil {
+				return Result{}, err
+			}
+			if e, ok := value.(*object.Error); ok {
+				return Result{}, fmt.Errorf("%s", e.Message)
+			}
+		}
+		elapsed := time.Since(start)
+
+		if elapsed >= d || n >= maxIterations {
+			return Result{
+				N:                 n,
+				NsPerOp:           float64(elapsed.Nanoseconds()) / float64(n),
+				InstructionsPerOp: float64(machine.InstructionCount()-instructions) / float64(n),
+			}, nil
+		}
+
+		// Aim 20% past d, growing at least by one and at most 100 times.
+		next := n * 100
+		if elapsed > 0 {
+			next = int(float64(n) * 1.2 * float64(d) / float64(elapsed))
+		}
+		n = max(min(next, n*100, maxIterations), n+1)
+	}
+}
+
+// String formats r's figures in aligned columns, like `go test -bench`.
+func (r Result) String() string {
+	return fmt.Sprintf("%10d %12.0f ns/op %10.0f instructions/op", r.N, r.NsPerOp, r.InstructionsPerOp)
+}
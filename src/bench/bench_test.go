@@ -0,0 +1,89 @@
+package bench
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"squ1d++/object"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceRunsBenchmarks(t *testing.T) {
+	var out bytes.Buffer
+	object.OutWriter = &out
+	defer func() { object.OutWriter = os.Stdout }()
+
+	src := `var xs = [1, 2, 3]
+io.echo("setup")
+bench "first" {
+    xs[0] + xs[1]
+}
+bench "loop" {
+    var i = 0
+    while (i < 10) {
+        i = i + 1
+    }
+}
+`
+	var results []Result
+	err := Source(src, Options{Time: time.Millisecond}, func(r Result) {
+		results = append(results, r)
+	})
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+
+	if len(results) != 2 || results[0].Name != "first" || results[1].Name != "loop" {
+		t.Fatalf("expected results for first and loop, got %+v", results)
+	}
+	for _, r := range results {
+		if r.N < 1 || r.NsPerOp <= 0 || r.InstructionsPerOp <= 0 {
+			t.Fatalf("expected positive figures, got %+v", r)
+		}
+	}
+	if results[1].InstructionsPerOp <= results[0].InstructionsPerOp {
+		t.Fatalf("expected the loop to run more instructions than the addition, got %+v", results)
+	}
+	if got := strings.Count(out.String(), "setup"); got != 1 {
+		t.Fatalf("expected the program to run once, ran %d times", got)
+	}
+}
+
+func TestSourceFiltersBenchmarks(t *testing.T) {
+	src := "bench \"a\" { 1 }\nbench \"b\" { 2 }\n"
+	var names []string
+	err := Source(src, Options{Time: time.Millisecond, Run: regexp.MustCompile("^b$")}, func(r Result) {
+		names = append(names, r.Name)
+	})
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	if len(names) != 1 || names[0] != "b" {
+		t.Fatalf("expected only b to run, got %v", names)
+	}
+}
+
+func TestSourceReportsFailingBenchmark(t *testing.T) {
+	err := Source("f >> (a) { a }\nbench \"bad\" { f() }\n", Options{Time: time.Millisecond}, func(Result) {})
+	if err == nil || !strings.Contains(err.Error(), `bench "bad"`) {
+		t.Fatalf("expected an error naming the benchmark, got %v", err)
+	}
+}
+
+func TestRunPrintsResults(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.sqd")
+	if err := os.WriteFile(file, []byte("bench \"add\" { 1 + 2 }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"--time", "1ms", file}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+	}
+	if !regexp.MustCompile(`^add +\d+ +\d+ ns/op +\d+ instructions/op\n$`).MatchString(stdout.String()) {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+}
@@ -0,0 +1,70 @@
+package bench
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"squ1d++/builder"
+	"time"
+)
+
+// Run implements `squ1dcc bench [--run regexp] [--time d] file.sqd`,
+// printing one line per benchmark with its iterations, ns/op and VM
+// instructions/op.
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(stderr) }
+
+	pattern := fs.String("run", "", "Only run benchmarks whose name matches this regular expression")
+	d := fs.Duration("time", time.Second, "Minimum time to run each benchmark for")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		printUsage(stderr)
+		return 2
+	}
+	file := fs.Arg(0)
+
+	opts := Options{Time: *d}
+	if *pattern != "" {
+		re, err := regexp.Compile(*pattern)
+		if err != nil {
+			fmt.Fprintf(stderr, "bench: invalid --run pattern: %v\n", err)
+			return 2
+		}
+		opts.Run = re
+	}
+
+	src, err := builder.ExpandSource(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "bench: %v\n", err)
+		return 1
+	}
+
+	count := 0
+	err = Source(src, opts, func(r Result) {
+		fmt.Fprintf(stdout, "%-24s %s\n", r.Name, r)
+		count++
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", file, err)
+		return 1
+	}
+	if count == 0 {
+		fmt.Fprintf(stderr, "%s: no benchmarks to run\n", file)
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: squ1dcc bench [--run regexp] [--time 1s] file.sqd")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Runs the program once, then times each `bench \"name\" { ... }` block.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  --run regexp   only run benchmarks whose name matches")
+	fmt.Fprintln(w, "  --time d       run each benchmark for at least d (default 1s)")
+}
@@ -4,15 +4,20 @@ import (
 	"bufio"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"squ1d++/ast"
+	"runtime"
 	"squ1d++/bytecode"
 	"squ1d++/compiler"
 	"squ1d++/lexer"
 	"squ1d++/parser"
+	"squ1d++/pkg"
+	"squ1d++/std"
+	"squ1d++/token"
+	"strconv"
 	"strings"
 )
 
@@ -28,6 +33,63 @@ func SetVerbosity(v int) {
 	Verbosity = v
 }
 
+// RuntimeBinary, when set, is the prebuilt runtime copied into standalone
+// executables instead of the running compiler (CLI: --runtime).
+var RuntimeBinary = ""
+
+func SetRuntimeBinary(path string) {
+	RuntimeBinary = path
+}
+
+// Target is the platform standalone executables are built for, as os/arch
+// like "linux/arm64", or "" for the platform the compiler runs on (CLI:
+// --target).
+var Target = ""
+
+// SetTarget sets Target, checking that it has the form os/arch.
+func SetTarget(target string) error {
+	if target != "" {
+		goos, goarch, ok := strings.Cut(target, "/")
+		if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+			return fmt.Errorf("invalid target %q; use os/arch, like linux/arm64", target)
+		}
+	}
+	Target = target
+	return nil
+}
+
+// targetPlatform returns the GOOS and GOARCH executables are built for.
+func targetPlatform() (goos, goarch string) {
+	if goos, goarch, ok := strings.Cut(Target, "/"); ok {
+		return goos, goarch
+	}
+	return runtime.GOOS, runtime.GOARCH
+}
+
+// crossCompiling reports whether Target is another platform than the one
+// the compiler runs on.
+func crossCompiling() bool {
+	goos, goarch := targetPlatform()
+	return goos != runtime.GOOS || goarch != runtime.GOARCH
+}
+
+// ExecutableName returns outputFile with the .exe extension added when
+// building for Windows and it doesn't have it yet.
+func ExecutableName(outputFile string) string {
+	if goos, _ := targetPlatform(); goos == "windows" && !strings.HasSuffix(outputFile, ".exe") {
+		return outputFile + ".exe"
+	}
+	return outputFile
+}
+
+// Strict compiles programs in the compiler's strict mode, where names a
+// function uses must be defined somewhere in the program (CLI: --strict).
+var Strict = true
+
+func SetStrict(strict bool) {
+	Strict = strict
+}
+
 func logf(level int, format string, a ...interface{}) {
 	if Verbosity >= level {
 		fmt.Fprintf(os.Stderr, format+"\n", a...)
@@ -49,35 +111,41 @@ func BuildStandalone(inputFile, outputFile string) error {
 	baseDir := filepath.Dir(inputFile)
 
 	// Expand includes inline
-	expandedCode, err := expandIncludes(string(source), baseDir)
+	includes := newExpansion()
+	includes.Enter(inputFile, 0, 0)
+	expanded, err := expandIncludesWithStack(string(source), inputFile, baseDir, includes)
 	if err != nil {
 		return fmt.Errorf("include expansion error: %v", err)
 	}
+	expandedCode := expanded.code()
 	logf(1, "Expanded includes: %d bytes", len(expandedCode))
 	if Verbosity >= 3 {
 		logf(3, "Expanded code:\n%s", expandedCode)
 	}
 
-	// Pre-process pkg.include() calls to build namespace objects
-	// This would evaluate included files and prepare globals.
-	// For now, we keep pkg.include() for REPL mode only
+	// Skip the rebuild entirely when the output was produced from the same
+	// expanded source by the same compiler.
+	cacheKey := buildCacheKey(expandedCode)
+	if !ForceRebuild && isBuildUpToDate(cacheKey, outputFile) {
+		logf(1, "Build cache hit: %s is up to date (use --force to rebuild)", outputFile)
+		return nil
+	}
+
+	// Pre-process pkg.include() calls left after include expansion
 	modifiedCode, err := processPkgIncludes(expandedCode, baseDir)
 	if err != nil {
 		return fmt.Errorf("include processing error: %v", err)
 	}
 
-	// Parse and compile the modified code
-	compiledCode, err := compileSourceWithNamespaces(modifiedCode)
+	// Parse and compile the modified code, its lines naming the files they
+	// came from relative to the input file's directory.
+	compiledCode, err := compileSources(modifiedCode, expanded.sources(builtName(baseDir)), true)
 	if err != nil {
 		return fmt.Errorf("compilation error: %v", err)
 	}
 
 	// Serialize bytecode
-	pkg := &bytecode.Package{
-		Version:      bytecode.VERSION,
-		Instructions: compiledCode.Instructions,
-		Constants:    compiledCode.Constants,
-	}
+	pkg := bytecode.NewPackage(compiledCode)
 
 	// Create bytecode data
 	tempFile := filepath.Join(filepath.Dir(outputFile), ".squ1d_temp.byc")
@@ -109,9 +177,7 @@ import (
 	"fmt"
 	"os"
 	"squ1d++/bytecode"
-	"squ1d++/vm"
-	"squ1d++/object"
-	"squ1d++/compiler"
+	"squ1d++/runner"
 )
 
 const bytecodeHex = %q
@@ -131,57 +197,45 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Initialize VM state
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-
-	// Register builtins
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
-
-	// Register class objects
-	classes := object.CreateClassObjects()
-	for name, classObj := range classes {
-		sym := symbolTable.Define(name)
-		globals[sym.Index] = classObj
-	}
-
-	// Create and run VM
-	machine := vm.NewWithGlobalsStore(&compiler.Bytecode{
-		Instructions: pkg.Instructions,
-		Constants:    pkg.Constants,
-	}, globals)
-
-	if err := machine.Run(); err != nil {
+	// Run the whole program at once, the way the runtime binary runs
+	// embedded programs
+	if err := runner.New(os.Stdout).RunBytecode(pkg.Bytecode()); err != nil {
 		fmt.Fprintf(os.Stderr, "Runtime error: %%s\n", err)
 		os.Exit(1)
 	}
-
-	// Print result if any
-	lastPopped := machine.LastPoppedStackElem()
-	if lastPopped != nil && lastPopped.Type() != object.NULL_OBJ {
-		fmt.Println(lastPopped.Inspect())
-	}
 }
 `, hex.EncodeToString(bcData))
 
-	// First, attempt to write a fully embedded executable from the current compiler binary.
-	if currentExe, err := findSourceExecutable(); err == nil {
+	// First, attempt to write a fully embedded executable from the runtime
+	// binary. This path needs no Go toolchain.
+	currentExe, embedErr := findSourceExecutable()
+	if embedErr == nil {
 		logf(2, "Trying embedded executable path: %s", currentExe)
-		if err := writeEmbeddedExecutable(outputFile, currentExe, bcData); err == nil {
+		if embedErr = writeEmbeddedExecutable(outputFile, currentExe, bcData); embedErr == nil {
 			logf(1, "Wrote embedded executable to %s", outputFile)
 			if Verbosity >= 3 {
 				logf(3, "Embedded bytecode size: %d bytes", len(bcData))
 			}
+			recordBuild(cacheKey, outputFile)
 			return nil
 		}
 	}
+	logf(1, "Embedded build failed: %v", embedErr)
 
+	// An explicitly requested runtime must not be silently replaced by a
+	// toolchain build for the host platform.
+	if RuntimeBinary != "" {
+		return fmt.Errorf("could not use runtime %s: %v", RuntimeBinary, embedErr)
+	}
 	// Fallback path (legacy): build a generated Go temporary main via `go build`.
-	projectRoot, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("could not get working directory: %v", err)
+	// That only works from the compiler's sources.
+	projectRoot, ok := findModuleRoot()
+	if !ok {
+		goos, goarch := targetPlatform()
+		return fmt.Errorf("no runtime for %s/%s; rebuild from source (%v)", goos, goarch, embedErr)
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		return fmt.Errorf("could not write embedded executable (%v) and no Go toolchain is available for the fallback build", embedErr)
 	}
 
 	// Create a temporary Go file to compile (in a temp dir)
@@ -203,10 +257,16 @@ func main() {
 		return fmt.Errorf("could not resolve output path: %v", err)
 	}
 
-	// Compile with go build from the PROJECT ROOT directory
-	// This ensures go.mod is found and can resolve packages
+	// Compile with go build from the module root, so go.mod is found and
+	// the generated main can import the runtime's packages
 	cmd := exec.Command("go", "build", "-o", absOutputFile, tempGoFile)
-	cmd.Dir = projectRoot // Run from project root so go.mod is found
+	cmd.Dir = projectRoot
+	if crossCompiling() {
+		// cgo would need a C cross compiler for the target.
+		goos, goarch := targetPlatform()
+		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0")
+		logf(1, "Cross-compiling the runtime for %s/%s", goos, goarch)
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -214,45 +274,119 @@ func main() {
 		return fmt.Errorf("compilation failed: %v", err)
 	}
 
+	recordBuild(cacheKey, outputFile)
 	return nil
 }
 
+// findModuleRoot returns the directory of the squ1d++ module's go.mod,
+// looking in the working directory and the ones above it.
+func findModuleRoot() (string, bool) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	for {
+		if data, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "module" {
+					return dir, strings.Trim(fields[1], `"`) == "squ1d++"
+				}
+			}
+			return "", false
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// findSourceExecutable returns the runtime binary that embedded executables
+// are copied from: --runtime, then $SQU1D_SOURCE_BINARY, then this binary.
+// For another Target it is the runtime for that platform installed next to
+// this one, named like squ1dcc-linux-arm64.
 func findSourceExecutable() (string, error) {
-	if override := os.Getenv("SQU1D_SOURCE_BINARY"); override != "" {
-		return override, nil
+	if RuntimeBinary != "" {
+		return RuntimeBinary, nil
+	}
+	exe := os.Getenv("SQU1D_SOURCE_BINARY")
+	if exe == "" {
+		var err error
+		if exe, err = os.Executable(); err != nil {
+			return "", err
+		}
 	}
-	return os.Executable()
+	if !crossCompiling() {
+		return exe, nil
+	}
+
+	goos, goarch := targetPlatform()
+	name := strings.TrimSuffix(filepath.Base(exe), ".exe") + "-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	path := filepath.Join(filepath.Dir(exe), name)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf("no runtime for %s: %s not found", Target, path)
+	}
+	return path, nil
 }
 
+// writeEmbeddedExecutable writes a copy of the runtime sourceExe with the
+// program bcData appended to it. The executable is written next to
+// outputFile and renamed over it once complete, so a failed build never
+// leaves a truncated executable behind and a running one can be replaced.
 func writeEmbeddedExecutable(outputFile, sourceExe string, bcData []byte) error {
 	input, err := os.ReadFile(sourceExe)
 	if err != nil {
 		return err
 	}
+	input = stripEmbeddedPayload(input)
 
-	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	out, err := os.CreateTemp(filepath.Dir(outputFile), ".squ1d_build_*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(out.Name())
 	defer out.Close()
 
-	if _, err := out.Write(input); err != nil {
+	footer := make([]byte, 8+len(embeddedMarker))
+	binary.LittleEndian.PutUint64(footer[:8], uint64(len(bcData)))
+	copy(footer[8:], []byte(embeddedMarker))
+
+	for _, part := range [][]byte{input, bcData, footer} {
+		if _, err := out.Write(part); err != nil {
+			return err
+		}
+	}
+	if err := out.Sync(); err != nil {
 		return err
 	}
-
-	if _, err := out.Write(bcData); err != nil {
+	if err := out.Close(); err != nil {
 		return err
 	}
+	if err := os.Chmod(out.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), outputFile)
+}
 
-	footer := make([]byte, 8+len(embeddedMarker))
-	binary.LittleEndian.PutUint64(footer[:8], uint64(len(bcData)))
-	copy(footer[8:], []byte(embeddedMarker))
+// stripEmbeddedPayload drops a program already appended to runtime, so that
+// using a previously built executable as the runtime doesn't stack payloads.
+func stripEmbeddedPayload(runtime []byte) []byte {
+	footerLen := 8 + len(embeddedMarker)
+	if len(runtime) < footerLen || string(runtime[len(runtime)-len(embeddedMarker):]) != embeddedMarker {
+		return runtime
+	}
 
-	if _, err := out.Write(footer); err != nil {
-		return err
+	markerStart := len(runtime) - footerLen
+	bcLen := binary.LittleEndian.Uint64(runtime[markerStart : markerStart+8])
+	if bcLen > uint64(markerStart) {
+		return runtime
 	}
 
-	return out.Sync()
+	return runtime[:markerStart-int(bcLen)]
 }
 
 // compileSource parses and compiles SQU1D++ source code
@@ -275,7 +409,107 @@ func compileSource(source string) (*compiler.Bytecode, error) {
 
 // expandIncludes recursively expands pkg.include() calls
 func expandIncludes(code string, baseDir string) (string, error) {
-	var result []string
+	expanded, err := expandIncludesWithStack(code, "", baseDir, newExpansion())
+	if err != nil {
+		return "", err
+	}
+	return expanded.code(), nil
+}
+
+// expanded is expanded code line by line, with the file and line each line
+// came from.
+type expanded struct {
+	lines   []string
+	origins []origin
+}
+
+// origin is a line of a source file.
+type origin struct {
+	file string
+	line int
+}
+
+// add adds the lines of text, all coming from at.
+func (e *expanded) add(text string, at origin) {
+	for _, line := range strings.Split(text, "\n") {
+		e.lines = append(e.lines, line)
+		e.origins = append(e.origins, at)
+	}
+}
+
+// append adds the lines of more.
+func (e *expanded) append(more *expanded) {
+	e.lines = append(e.lines, more.lines...)
+	e.origins = append(e.origins, more.origins...)
+}
+
+func (e *expanded) code() string {
+	return strings.Join(e.lines, "\n")
+}
+
+// sources returns the segments of the expanded code for the compiler, with
+// the files it came from named by name.
+func (e *expanded) sources(name func(string) string) compiler.Sources {
+	var sources compiler.Sources
+	for i, at := range e.origins {
+		if i > 0 && at.file == e.origins[i-1].file && at.line == e.origins[i-1].line+1 {
+			continue
+		}
+		sources = append(sources, compiler.Segment{Start: i + 1, File: name(at.file), Line: at.line})
+	}
+	return sources
+}
+
+// builtName names the files of a program built from baseDir the way built
+// programs report them: relative to baseDir, by their base name when
+// outside it, or as standard library modules.
+func builtName(baseDir string) func(string) string {
+	return func(file string) string {
+		if _, ok := std.Resolve(file); ok {
+			return file
+		}
+		if rel, err := filepath.Rel(baseDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+		return filepath.Base(file)
+	}
+}
+
+// pathName names files by the path they were read from.
+func pathName(file string) string {
+	return file
+}
+
+// expansion is the state of expanding the includes of one program: the
+// files being expanded, so include cycles are reported instead of recursing
+// forever, and the files already expanded.
+type expansion struct {
+	pkg.IncludeStack
+	// files and modules hold the files already included without and with a
+	// namespace, by moduleKey. Like the file runner, a program runs each
+	// file once however often it is included.
+	files, modules map[string]bool
+}
+
+func newExpansion() *expansion {
+	return &expansion{files: map[string]bool{}, modules: map[string]bool{}}
+}
+
+// moduleKey identifies an included file independently of the path used to
+// reach it.
+func moduleKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// expandIncludesWithStack expands the includes of code, read from file,
+// tracking the files being expanded in includes so include cycles are
+// reported instead of recursing forever.
+func expandIncludesWithStack(code, file, baseDir string, includes *expansion) (*expanded, error) {
+	result := &expanded{}
+	calls := findIncludes(code)
 	scanner := bufio.NewScanner(strings.NewReader(code))
 	row := 0
 
@@ -287,30 +521,10 @@ func expandIncludes(code string, baseDir string) (string, error) {
 			logf(3, "expandIncludes row %d -> %q", row, trimmed)
 		}
 
-		// Check for include() or pkg.include() calls
-		includeCall := ""
-		if strings.Contains(trimmed, "pkg.include(") {
-			includeCall = "pkg.include("
-		} else if strings.Contains(trimmed, "include(") {
-			includeCall = "include("
-		}
+		at := origin{file, row}
 
-		if includeCall != "" {
-			// Extract filename from include
-			// Simple parser for include("filename") or include("filename", "namespace")
-			startIdx := strings.Index(trimmed, `"`)
-			if startIdx == -1 {
-				result = append(result, line)
-				continue
-			}
-
-			endIdx := strings.Index(trimmed[startIdx+1:], `"`)
-			if endIdx == -1 {
-				result = append(result, line)
-				continue
-			}
-
-			filename := trimmed[startIdx+1 : startIdx+1+endIdx]
+		if call, ok := calls[row]; ok {
+			filename, ns := call.filename, call.namespace
 
 			// Try to find the file
 			candidates := []string{
@@ -318,10 +532,24 @@ func expandIncludes(code string, baseDir string) (string, error) {
 				filepath.Join(baseDir, filename),
 				filepath.Join("lib", filename),
 			}
+			if pkgMain, ok, err := pkg.GlobalManager.ResolveInclude(filename); err != nil {
+				return nil, err
+			} else if ok {
+				candidates = append(candidates, pkgMain)
+			}
 
-			var found string
+			// Standard library modules are embedded and win over files on
+			// disk. Directory packages can only be included under a namespace.
+			found, isStd := std.Resolve(filename)
 			for _, candidate := range candidates {
-				if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				if isStd {
+					break
+				}
+				info, err := os.Stat(candidate)
+				if err != nil {
+					continue
+				}
+				if _, isPackage := pkg.PackageDir(candidate); !info.IsDir() || (isPackage && ns != "") {
 					found = candidate
 					break
 				}
@@ -329,110 +557,330 @@ func expandIncludes(code string, baseDir string) (string, error) {
 
 			if found == "" {
 				// If not found, keep the original line (runtime will handle it)
-				result = append(result, line)
+				result.add(line, at)
 				continue
 			}
 
-			// Check if a namespace was provided in the include call
-			// Look for a second string argument after the filename
-			rest := trimmed[startIdx+1+endIdx+1:]
-			ns := ""
-			if idx := strings.Index(rest, `"`); idx != -1 {
-				idx2 := strings.Index(rest[idx+1:], `"`)
-				if idx2 != -1 {
-					ns = rest[idx+1 : idx+1+idx2]
-				}
-			}
-
 			// SQX plugins are not SQU1DLang source files, so they can't be inlined.
 			if strings.EqualFold(filepath.Ext(found), ".sqx") {
 				if ns == "" {
 					// Keep one-arg form untouched (returns raw content semantics).
-					result = append(result, line)
+					result.add(line, at)
 					continue
 				}
 
 				// For non-registered SQX files, use legacy pkg.load_sqx path inlining.
 				absPath, err := filepath.Abs(found)
 				if err != nil {
-					return "", fmt.Errorf("could not resolve SQX path %s: %v", found, err)
+					return nil, fmt.Errorf("could not resolve SQX path %s: %v", found, err)
 				}
-				result = append(result, fmt.Sprintf("var %s = pkg.load_sqx(%q)", ns, filepath.ToSlash(absPath)))
+				result.add(fmt.Sprintf("var %s = pkg.load_sqx(%q)", ns, filepath.ToSlash(absPath)), at)
 				continue
 			}
 
-			// Read and recursively expand the included file
-			includedCode, err := os.ReadFile(found)
-			if err != nil {
-				return "", fmt.Errorf("could not read include file %s: %v", found, err)
+			column := call.column
+			key := moduleKey(found)
+			if ns == "" {
+				// No namespace requested — inline the expanded include,
+				// unless an earlier include already did
+				if includes.files[key] {
+					result.add("", at)
+					continue
+				}
+				expandedInclude, err := expandIncludeFile(found, row, column, includes)
+				if err != nil {
+					return nil, err
+				}
+				includes.files[key] = true
+				result.append(expandedInclude)
+				continue
 			}
 
-			expandedInclude, err := expandIncludes(string(includedCode), filepath.Dir(found))
+			// A module included before is bound to ns by the compiler
+			// rather than run again.
+			if includes.modules[key] {
+				result.append(inlineModule(nil, ns, key, at))
+				continue
+			}
+			module, err := expandModule(found, row, column, includes)
 			if err != nil {
-				return "", fmt.Errorf("error expanding include %s: %v", found, err)
+				return nil, err
 			}
+			includes.modules[key] = true
+			result.append(inlineModule(module, ns, key, at))
+			continue
+		}
 
-			if ns == "" {
-				// No namespace requested — inline the expanded include
-				result = append(result, expandedInclude)
-				continue
-			}
+		result.add(line, at)
+	}
 
-			// Build a namespaced wrapper that executes the included file in a local
-			// function scope and returns an object/hash containing exported symbols.
-			exported := findTopLevelVars(expandedInclude)
+	return result, scanner.Err()
+}
 
-			wrapper := "var " + ns + " = (def() {\n"
-			wrapper += expandedInclude + "\n"
-			wrapper += "return {"
-			for i, name := range exported {
-				if i > 0 {
-					wrapper += ","
-				}
-				wrapper += fmt.Sprintf("%s: %s", name, name)
-			}
-			wrapper += "}\n})()"
+// includeCall is an include("file") or pkg.include("file", "namespace")
+// call in a source file.
+type includeCall struct {
+	column    int
+	filename  string
+	namespace string
+}
 
-			result = append(result, wrapper)
+// findIncludes returns the include calls in code by line. It reads the
+// code's tokens, so include( inside a string or a comment isn't taken for a
+// call.
+func findIncludes(code string) map[int]includeCall {
+	var toks []token.Token
+	l := lexer.New(code)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		toks = append(toks, tok)
+	}
+
+	calls := map[int]includeCall{}
+	for i, tok := range toks {
+		if tok.Type != token.IDENT || tok.Literal != "include" || i+2 >= len(toks) {
+			continue
+		}
+		if toks[i+1].Type != token.LPAREN || toks[i+2].Type != token.STRING {
 			continue
 		}
+		call := includeCall{column: tok.Column, filename: toks[i+2].Literal}
+		if i >= 2 && toks[i-1].Type == token.DOT && toks[i-2].Type == token.IDENT && toks[i-2].Literal == "pkg" {
+			call.column = toks[i-2].Column
+		}
+		if i+4 < len(toks) && toks[i+3].Type == token.COMMA && toks[i+4].Type == token.STRING {
+			call.namespace = toks[i+4].Literal
+		}
+		if _, seen := calls[tok.Line]; !seen {
+			calls[tok.Line] = call
+		}
+	}
+	return calls
+}
 
-		result = append(result, line)
+// expandIncludeFile reads and recursively expands the included file at path,
+// included from row:column of the file being expanded.
+func expandIncludeFile(path string, row, column int, includes *expansion) (*expanded, error) {
+	includedCode, err := std.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read include file %s: %v", path, err)
 	}
 
-	return strings.Join(result, "\n"), scanner.Err()
+	if err := includes.Enter(path, row, column); err != nil {
+		return nil, err
+	}
+	expanded, err := expandIncludesWithStack(string(includedCode), path, filepath.Dir(path), includes)
+	includes.Leave()
+	if err != nil {
+		var cycle *pkg.IncludeCycleError
+		if errors.As(err, &cycle) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error expanding include %s: %v", path, err)
+	}
+	return expanded, nil
+}
+
+// expandModule returns the expanded body of the module included from path.
+// For a directory package that is each of its files as a nested inline
+// module, followed by its __init__.sqd.
+func expandModule(path string, row, column int, includes *expansion) (*expanded, error) {
+	dir, ok := pkg.PackageDir(path)
+	if !ok {
+		return expandIncludeFile(path, row, column, includes)
+	}
+
+	if err := includes.Enter(dir, row, column); err != nil {
+		return nil, err
+	}
+	defer includes.Leave()
+
+	submodules, err := pkg.Submodules(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read package directory %s: %v", dir, err)
+	}
+	result := &expanded{}
+	for _, sub := range submodules {
+		module, err := expandModule(sub.Path, 0, 0, includes)
+		if err != nil {
+			return nil, err
+		}
+		result.append(inlineModule(module, sub.Namespace, "", origin{sub.Path, 1}))
+	}
+	init, err := expandIncludeFile(pkg.PackageInit(dir), 0, 0, includes)
+	if err != nil {
+		return nil, err
+	}
+	result.append(init)
+	return result, nil
+}
+
+// inlineModule wraps an expanded module so the compiler runs it in its own
+// scope and binds it to ns as a hash of its public top-level definitions,
+// exactly as pkg.include(path, ns) does at runtime. A module included again
+// under the same key isn't run again: ns is bound to the hash built the
+// first time. The lines wrapping the module come from at.
+func inlineModule(module *expanded, ns, key string, at origin) *expanded {
+	call := &expanded{}
+	call.add("pkg.include(def() {", at)
+	if module != nil {
+		call.append(module)
+	} else {
+		call.add("", at)
+	}
+	end := "}, " + strconv.Quote(ns)
+	if key != "" {
+		end += ", " + strconv.Quote(key)
+	}
+	call.add(end+")", at)
+	return call
 }
 
 // processPkgIncludes extracts pkg.include() directives and tracks imported libraries
 func processPkgIncludes(code string, baseDir string) (string, error) {
 	_ = baseDir // used for resolving library paths
 
-	// For now, in standalone builds we inline pkg.include() calls
-	// The bytecode will contain the expanded code, so pkg.include()
-	// namespaces won't be available at runtime in compiled mode
-	// (They work in REPL/evaluation mode)
+	// Includes that could be resolved were already expanded, namespaced ones
+	// into inline modules the compiler turns into namespace hashes; the rest
+	// are left for the runtime to resolve
 	return code, nil
 }
 
-// compileSourceWithNamespaces compiles code
-func compileSourceWithNamespaces(source string) (*compiler.Bytecode, error) {
+// compileSourceWithNamespaces compiles code, naming filename as its source
+// in the line tables when it isn't empty. With echo, the program prints the
+// values of its top-level statements as it runs, like a file run by the
+// REPL does.
+func compileSourceWithNamespaces(source, filename string, echo bool) (*compiler.Bytecode, error) {
+	var sources compiler.Sources
+	if filename != "" {
+		sources = compiler.Sources{{Start: 1, File: filename, Line: 1}}
+	}
+	return compileSources(source, sources, echo)
+}
+
+// compileSources compiles source like compileSourceWithNamespaces, naming
+// the files its lines came from, as given by sources, in its line tables
+// and errors.
+func compileSources(source string, sources compiler.Sources, echo bool) (*compiler.Bytecode, error) {
 	l := lexer.New(source)
 	p := parser.New(l)
 	program := p.ParseProgram()
 
 	if len(p.Errors()) > 0 {
-		return nil, fmt.Errorf("parse error: %v", p.Errors())
+		errs := make([]string, len(p.Errors()))
+		for i, msg := range p.Errors() {
+			errs[i] = sources.Locate(msg)
+		}
+		return nil, fmt.Errorf("parse error: %v", errs)
 	}
 
 	comp := compiler.New()
+	comp.Sources = sources
+	if len(sources) > 0 {
+		comp.Filename = sources[0].File
+	}
+	comp.SetOptimizationLevel(OptimizationLevel)
+	comp.Strict = Strict
+	comp.Echo = echo
+	logf(2, "Compiling with optimization level -O%d", comp.OptimizationLevel())
 
 	if err := comp.Compile(program); err != nil {
+		if msg := sources.Locate(err.Error()); msg != err.Error() {
+			return nil, errors.New(msg)
+		}
 		return nil, err
 	}
 
 	return comp.Bytecode(), nil
 }
 
+// ExpandSource reads inputFile and returns it with its includes inlined: the
+// program BuildStandalone compiles, for tools that need the whole program in
+// one piece.
+func ExpandSource(inputFile string) (string, error) {
+	source, err := os.ReadFile(inputFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read input file: %v", err)
+	}
+	expanded, err := expandSource(inputFile, source)
+	if err != nil {
+		return "", err
+	}
+	return expanded.code(), nil
+}
+
+func expandSource(inputFile string, source []byte) (*expanded, error) {
+	baseDir := filepath.Dir(inputFile)
+	includes := newExpansion()
+	includes.Enter(inputFile, 0, 0)
+	expanded, err := expandIncludesWithStack(string(source), inputFile, baseDir, includes)
+	if err != nil {
+		return nil, fmt.Errorf("include expansion error: %v", err)
+	}
+	return expanded, nil
+}
+
+// Check compiles inputFile the way BuildStandalone does, without writing
+// anything, and returns the first error found.
+func Check(inputFile string) error {
+	source, err := os.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("could not read input file: %v", err)
+	}
+	expanded, err := expandSource(inputFile, source)
+	if err != nil {
+		return err
+	}
+	if _, err := compileSources(expanded.code(), expanded.sources(pathName), false); err != nil {
+		return fmt.Errorf("compilation error: %v", err)
+	}
+	return nil
+}
+
+// Compile compiles inputFile, includes and all, into the program
+// BuildStandalone would embed, for running it without building it.
+func Compile(inputFile string) (*compiler.Bytecode, error) {
+	source, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read input file: %v", err)
+	}
+	expanded, err := expandSource(inputFile, source)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike a built executable, the program runs where the file is, so
+	// its lines name the files by the paths they were read from.
+	return compileSources(expanded.code(), expanded.sources(pathName), true)
+}
+
+// BuildBytecode compiles inputFile, includes and all, like BuildStandalone,
+// but writes just the serialized program to outputFile, a .byc file that
+// `squ1dcc run` runs.
+func BuildBytecode(inputFile, outputFile string) error {
+	logf(1, "BuildBytecode: input=%s output=%s", inputFile, outputFile)
+	source, err := os.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("could not read input file: %v", err)
+	}
+	expanded, err := expandSource(inputFile, source)
+	if err != nil {
+		return err
+	}
+	compiled, err := compileSources(expanded.code(), expanded.sources(builtName(filepath.Dir(inputFile))), true)
+	if err != nil {
+		return fmt.Errorf("compilation error: %v", err)
+	}
+
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("could not create bytecode file: %v", err)
+	}
+	defer out.Close()
+	if err := bytecode.NewPackage(compiled).Serialize(out); err != nil {
+		return fmt.Errorf("could not serialize bytecode: %v", err)
+	}
+	return out.Close()
+}
+
 // findLibrary searches for a library file in standard locations
 func findLibrary(libPath string, baseDir string) string {
 	candidates := []string{
@@ -449,32 +897,3 @@ func findLibrary(libPath string, baseDir string) string {
 	}
 	return ""
 }
-
-// findTopLevelVars returns names introduced by top-level let/function-definition
-// statements in the provided source. This correctly handles shorthand function
-// syntax like `name >> (...) { ... }`, which the parser lowers to LetStatement.
-func findTopLevelVars(src string) []string {
-	l := lexer.New(src)
-	p := parser.New(l)
-	program := p.ParseProgram()
-
-	names := []string{}
-	seen := make(map[string]bool)
-
-	for _, stmt := range program.Statements {
-		ls, ok := stmt.(*ast.LetStatement)
-		if !ok || ls.Name == nil {
-			continue
-		}
-
-		name := ls.Name.Value
-		if name == "" || seen[name] {
-			continue
-		}
-
-		names = append(names, name)
-		seen[name] = true
-	}
-
-	return names
-}
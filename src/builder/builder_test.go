@@ -9,6 +9,7 @@ import (
 	"squ1d++/object"
 	"strings"
 	"testing"
+	"time"
 
 	"squ1d++/vm"
 )
@@ -56,18 +57,10 @@ func TestBuildStandaloneWithEmbeddedBinary(t *testing.T) {
 }
 
 func TestExpandIncludesExportsTopLevelFunctionDefinitions(t *testing.T) {
-	root := t.TempDir()
-	libDir := filepath.Join(root, "lib")
-	if err := os.MkdirAll(libDir, 0o755); err != nil {
-		t.Fatalf("could not create lib directory: %v", err)
-	}
-
 	// Shorthand function definition (`name >> (...)`) is lowered by parser to a
 	// let-style statement; export detection must include it.
 	libSource := "sort >> (x) { return x }\n"
-	if err := os.WriteFile(filepath.Join(libDir, "sort.sqd"), []byte(libSource), 0o644); err != nil {
-		t.Fatalf("could not write library file: %v", err)
-	}
+	root := writeFiles(t, map[string]string{"lib/sort.sqd": libSource})
 
 	mainSource := "pkg.include(\"lib/sort.sqd\", \"sort\")\nsort.sort(42)\n"
 
@@ -76,7 +69,7 @@ func TestExpandIncludesExportsTopLevelFunctionDefinitions(t *testing.T) {
 		t.Fatalf("expandIncludes returned error: %v", err)
 	}
 
-	bc, err := compileSourceWithNamespaces(expanded)
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
 	if err != nil {
 		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
 	}
@@ -95,6 +88,200 @@ func TestExpandIncludesExportsTopLevelFunctionDefinitions(t *testing.T) {
 	}
 }
 
+func TestCheck(t *testing.T) {
+	files := map[string]string{
+		"lib.sqd":  "shout >> (s) { return s + suffx }\nvar suffix = \"!\"\n",
+		"main.sqd": "pkg.include(\"lib.sqd\", \"lib\")\nio.echo(lib.shout(\"hi\"))\n",
+	}
+	root := writeFiles(t, files)
+	defer SetStrict(true)
+
+	err := Check(filepath.Join(root, "main.sqd"))
+	if err == nil || !strings.Contains(err.Error(), "Undefined variable suffx") {
+		t.Fatalf("expected the misspelled name to fail the check, got %v", err)
+	}
+	if want := filepath.Join(root, "lib.sqd") + ", line 1, column "; !strings.Contains(err.Error(), want) {
+		t.Fatalf("expected the error to point into the included file, got %v", err)
+	}
+
+	SetStrict(false)
+	if err := Check(filepath.Join(root, "main.sqd")); err != nil {
+		t.Fatalf("expected the check to pass without strict mode, got %v", err)
+	}
+}
+
+func TestExpandIncludesNamespacedModuleKeepsState(t *testing.T) {
+	files := map[string]string{
+		"counter.sqd": "pkg.include(\"fmt.sqd\", \"fmt\")\nvar count = 0\nbump >> () {\n    count = count + 1\n    return fmt.show(count)\n}\n",
+		"fmt.sqd":     "var count = 100\nshow >> (n) { return n + count }\n",
+	}
+	root := writeFiles(t, files)
+
+	mainSource := "var count = 7\npkg.include(\"counter.sqd\", \"counter\")\ncounter.bump()\ncounter.bump() + count\n"
+	expanded, err := expandIncludes(mainSource, root)
+	if err != nil {
+		t.Fatalf("expandIncludes returned error: %v", err)
+	}
+
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
+	if err != nil {
+		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
+	}
+	machine := vm.New(bc)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM runtime error: %v\nexpanded source:\n%s", err, expanded)
+	}
+
+	// Each module keeps its own count; the second bump sees the first.
+	got, ok := machine.LastPoppedStackElem().(*object.Integer)
+	if !ok || got.Value != 109 {
+		t.Fatalf("expected 109, got %v\nexpanded source:\n%s", machine.LastPoppedStackElem(), expanded)
+	}
+}
+
+func TestExpandIncludesRunsEachFileOnce(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"helpers.sqd": "var loads = 0\n",
+		"counter.sqd": "var count = 0\nbump >> () {\n    count = count + 1\n    return count\n}\n",
+		"user.sqd":    "pkg.include(\"counter.sqd\", \"c\")\nbump >> () { return c.bump() }\n",
+	})
+
+	mainSource := "include(\"helpers.sqd\")\ninclude(\"./helpers.sqd\")\n" +
+		"pkg.include(\"counter.sqd\", \"a\")\npkg.include(\"user.sqd\", \"user\")\na.bump()\nuser.bump() + loads\n"
+	expanded, err := expandIncludes(mainSource, root)
+	if err != nil {
+		t.Fatalf("expandIncludes returned error: %v", err)
+	}
+	for _, definition := range []string{"var loads = 0", "var count = 0"} {
+		if n := strings.Count(expanded, definition); n != 1 {
+			t.Fatalf("expected %q to be expanded once, got %d times:\n%s", definition, n, expanded)
+		}
+	}
+
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
+	if err != nil {
+		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
+	}
+	machine := vm.New(bc)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM runtime error: %v\nexpanded source:\n%s", err, expanded)
+	}
+
+	// The module included again inside user.sqd is the one bound to a.
+	got, ok := machine.LastPoppedStackElem().(*object.Integer)
+	if !ok || got.Value != 2 {
+		t.Fatalf("expected 2, got %v\nexpanded source:\n%s", machine.LastPoppedStackElem(), expanded)
+	}
+}
+
+func TestExpandIncludesNamespacedModuleHidesPrivateNames(t *testing.T) {
+	libSource := "var _scale = 10\n_helper >> (n) { return n * _scale }\nscale >> (n) { return _helper(n) }\n"
+	root := writeFiles(t, map[string]string{"lib.sqd": libSource})
+
+	mainSource := "pkg.include(\"lib.sqd\", \"lib\")\nvar got = [lib.scale(4), lib._helper, lib._scale]\ngot\n"
+	expanded, err := expandIncludes(mainSource, root)
+	if err != nil {
+		t.Fatalf("expandIncludes returned error: %v", err)
+	}
+
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
+	if err != nil {
+		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
+	}
+	machine := vm.New(bc)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM runtime error: %v\nexpanded source:\n%s", err, expanded)
+	}
+
+	if got := machine.LastPoppedStackElem().Inspect(); got != "[40, null, null]" {
+		t.Fatalf("expected private names to stay out of the namespace, got %s", got)
+	}
+}
+
+func TestExpandIncludesNamespacedModuleHonorsExports(t *testing.T) {
+	libSource := "var scale = 10\nhelper >> (n) { return n * scale }\nexport times >> (n) { return helper(n) }\nexport var version = \"2.0\"\nexport scale\n"
+	root := writeFiles(t, map[string]string{"lib.sqd": libSource})
+
+	mainSource := "pkg.include(\"lib.sqd\", \"lib\")\nvar got = [lib.times(4), lib.version, lib.scale, lib.helper]\ngot\n"
+	expanded, err := expandIncludes(mainSource, root)
+	if err != nil {
+		t.Fatalf("expandIncludes returned error: %v", err)
+	}
+
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
+	if err != nil {
+		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
+	}
+	machine := vm.New(bc)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM runtime error: %v\nexpanded source:\n%s", err, expanded)
+	}
+
+	if got := machine.LastPoppedStackElem().Inspect(); got != `[40, "2.0", 10, null]` {
+		t.Fatalf("expected only the exported names in the namespace, got %s", got)
+	}
+}
+
+func TestExpandIncludesDirectoryPackage(t *testing.T) {
+	files := map[string]string{
+		"utils/__init__.sqd":      "double >> (n) { return math.twice(n) }\n",
+		"utils/math.sqd":          "twice >> (n) { return n * 2 }\n",
+		"utils/text/__init__.sqd": "",
+		"utils/text/count.sqd":    "var base = 1\n",
+	}
+	root := writeFiles(t, files)
+
+	mainSource := "pkg.include(\"utils\", \"utils\")\nutils.double(20) + utils.math.twice(0) + utils.text.count.base + 1\n"
+	expanded, err := expandIncludes(mainSource, root)
+	if err != nil {
+		t.Fatalf("expandIncludes returned error: %v", err)
+	}
+
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
+	if err != nil {
+		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
+	}
+	machine := vm.New(bc)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM runtime error: %v\nexpanded source:\n%s", err, expanded)
+	}
+
+	got, ok := machine.LastPoppedStackElem().(*object.Integer)
+	if !ok || got.Value != 42 {
+		t.Fatalf("expected 42, got %v\nexpanded source:\n%s", machine.LastPoppedStackElem(), expanded)
+	}
+}
+
+func TestExpandIncludesInlinesStandardLibrary(t *testing.T) {
+	for _, source := range []string{
+		"pkg.include(\"std/sort\", \"sort\")\nsort.sort([3, 1, 2])\n",
+		"include(\"std/sort.sqd\")\nsort([3, 1, 2])\n",
+	} {
+		expandsStandardLibrary(t, source)
+	}
+}
+
+func expandsStandardLibrary(t *testing.T, source string) {
+	t.Helper()
+	expanded, err := expandIncludes(source, t.TempDir())
+	if err != nil {
+		t.Fatalf("expandIncludes returned error: %v", err)
+	}
+
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
+	if err != nil {
+		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
+	}
+	machine := vm.New(bc)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM runtime error: %v\nexpanded source:\n%s", err, expanded)
+	}
+
+	if got := machine.LastPoppedStackElem().Inspect(); got != "[1, 2, 3]" {
+		t.Fatalf("%q: expected [1, 2, 3], got %s", source, got)
+	}
+}
+
 func TestExpandIncludesRewritesSQXNamespaceInclude(t *testing.T) {
 	root := t.TempDir()
 	libDir := filepath.Join(root, "lib")
@@ -143,7 +330,7 @@ esac
 		t.Fatalf("expected load_sqx path to be absolute, got %q", match[1])
 	}
 
-	bc, err := compileSourceWithNamespaces(expanded)
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
 	if err != nil {
 		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
 	}
@@ -206,7 +393,7 @@ esac
 		t.Fatalf("expected expanded source to contain pkg.load_sqx rewrite, got:\n%s", expanded)
 	}
 
-	bc, err := compileSourceWithNamespaces(expanded)
+	bc, err := compileSourceWithNamespaces(expanded, "", false)
 	if err != nil {
 		t.Fatalf("compileSourceWithNamespaces returned error: %v\nexpanded source:\n%s", err, expanded)
 	}
@@ -224,3 +411,245 @@ esac
 		t.Fatalf("expected 13, got %d", got.Value)
 	}
 }
+
+func TestBuildStandaloneSkipsUpToDateOutput(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(root, "cache"))
+	t.Setenv("HOME", root)
+
+	// Any file works as the runtime for the embedded path; it is only copied.
+	sourceBin := filepath.Join(root, "runtime")
+	if err := os.WriteFile(sourceBin, []byte("runtime"), 0o755); err != nil {
+		t.Fatalf("could not write fake runtime: %v", err)
+	}
+	t.Setenv("SQU1D_SOURCE_BINARY", sourceBin)
+
+	inputFile := filepath.Join(root, "main.sqd")
+	if err := os.WriteFile(inputFile, []byte("1 + 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outputFile := filepath.Join(root, "main")
+
+	if err := BuildStandalone(inputFile, outputFile); err != nil {
+		t.Fatalf("first build failed: %v", err)
+	}
+
+	old := time.Unix(1000000000, 0)
+	if err := os.Chtimes(outputFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := BuildStandalone(inputFile, outputFile); err != nil {
+		t.Fatalf("cached build failed: %v", err)
+	}
+	info, err := os.Stat(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Fatalf("expected up-to-date output to be left untouched")
+	}
+
+	SetForceRebuild(true)
+	defer SetForceRebuild(false)
+	if err := BuildStandalone(inputFile, outputFile); err != nil {
+		t.Fatalf("forced build failed: %v", err)
+	}
+	info, err = os.Stat(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModTime().Equal(old) {
+		t.Fatalf("expected --force to rewrite the output")
+	}
+	SetForceRebuild(false)
+
+	if err := os.WriteFile(inputFile, []byte("3 + 4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(outputFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := BuildStandalone(inputFile, outputFile); err != nil {
+		t.Fatalf("rebuild after source change failed: %v", err)
+	}
+	info, err = os.Stat(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModTime().Equal(old) {
+		t.Fatalf("expected a source change to invalidate the cache")
+	}
+}
+
+func TestBuildStandaloneWithPrebuiltRuntime(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(root, "cache"))
+	t.Setenv("HOME", root)
+
+	inputFile := filepath.Join(root, "main.sqd")
+	if err := os.WriteFile(inputFile, []byte("1 + 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A runtime that is itself a built program: its payload must be replaced,
+	// not stacked under the new one.
+	runtimeBin := filepath.Join(root, "runtime")
+	if err := os.WriteFile(runtimeBin, []byte("runtime"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	SetRuntimeBinary(runtimeBin)
+	defer SetRuntimeBinary("")
+
+	first := filepath.Join(root, "first")
+	if err := BuildStandalone(inputFile, first); err != nil {
+		t.Fatalf("build with prebuilt runtime failed: %v", err)
+	}
+	firstData, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(firstData, []byte("runtime")) || !bytes.HasSuffix(firstData, []byte(embeddedMarker)) {
+		t.Fatalf("output is not runtime + payload: %q", firstData)
+	}
+	if info, err := os.Stat(first); err != nil || info.Mode().Perm()&0o111 == 0 {
+		t.Fatalf("expected an executable output, got %v, %v", info, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(root, ".squ1d_build_*")); len(leftovers) > 0 {
+		t.Fatalf("expected the build to leave no temporary files, got %v", leftovers)
+	}
+
+	SetRuntimeBinary(first)
+	second := filepath.Join(root, "second")
+	if err := BuildStandalone(inputFile, second); err != nil {
+		t.Fatalf("build with a built program as runtime failed: %v", err)
+	}
+	secondData, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(firstData, secondData) {
+		t.Fatalf("expected the old payload to be stripped from the runtime")
+	}
+
+	SetRuntimeBinary(filepath.Join(root, "missing"))
+	if err := BuildStandalone(inputFile, filepath.Join(root, "third")); err == nil {
+		t.Fatalf("expected a missing --runtime to fail instead of falling back to go build")
+	}
+}
+
+func TestBuildStandaloneForTarget(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(root, "cache"))
+	t.Setenv("HOME", root)
+
+	inputFile := filepath.Join(root, "main.sqd")
+	if err := os.WriteFile(inputFile, []byte("1 + 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The runtime for another platform is found next to this one.
+	runtimes := map[string]string{"squ1dcc": "host", "squ1dcc-windows-arm64.exe": "windows"}
+	for name, content := range runtimes {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("SQU1D_SOURCE_BINARY", filepath.Join(root, "squ1dcc"))
+
+	if err := SetTarget("windows/arm64"); err != nil {
+		t.Fatalf("SetTarget returned error: %v", err)
+	}
+	defer SetTarget("")
+
+	output := ExecutableName(filepath.Join(root, "main"))
+	if output != filepath.Join(root, "main.exe") {
+		t.Fatalf("expected .exe to be added for a windows target, got %q", output)
+	}
+	if err := BuildStandalone(inputFile, output); err != nil {
+		t.Fatalf("build for windows/arm64 failed: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("windows")) {
+		t.Fatalf("expected the windows runtime to be used, got %q", data)
+	}
+
+	for _, target := range []string{"linux", "linux/", "/arm64", "linux/arm/v7"} {
+		if err := SetTarget(target); err == nil {
+			t.Errorf("expected target %q to be rejected", target)
+		}
+	}
+}
+
+func TestBuildStandaloneWithoutRuntimeOutsideSources(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(root, "cache"))
+	t.Setenv("HOME", root)
+	t.Setenv("SQU1D_SOURCE_BINARY", filepath.Join(root, "squ1dcc"))
+	t.Chdir(root)
+
+	inputFile := filepath.Join(root, "main.sqd")
+	if err := os.WriteFile(inputFile, []byte("1 + 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTarget("linux/riscv64"); err != nil {
+		t.Fatalf("SetTarget returned error: %v", err)
+	}
+	defer SetTarget("")
+
+	// Without the compiler's sources there is nothing to build a runtime
+	// from, so the toolchain isn't tried.
+	err := BuildStandalone(inputFile, filepath.Join(root, "main"))
+	if err == nil || !strings.Contains(err.Error(), "no runtime for linux/riscv64; rebuild from source") {
+		t.Fatalf("expected the missing runtime to be reported, got: %v", err)
+	}
+}
+
+func TestExpandIncludesReportsCircularInclude(t *testing.T) {
+	files := map[string]string{
+		"a.sqd": "var a = 1\ninclude(\"b.sqd\")\n",
+		"b.sqd": "  include(\"a.sqd\")\n",
+	}
+	root := writeFiles(t, files)
+
+	_, err := expandIncludes("include(\"a.sqd\")\n", root)
+	if err == nil {
+		t.Fatalf("expected a circular include error")
+	}
+	a, b := filepath.Join(root, "a.sqd"), filepath.Join(root, "b.sqd")
+	want := a + " -> " + b + " -> " + a + " (" + a + ":2:1 includes " + b + ", " + b + ":1:3 includes " + a + ")"
+	if !strings.HasSuffix(err.Error(), want) {
+		t.Fatalf("expected chain %q, got: %v", want, err)
+	}
+}
+
+func TestExpandIncludesIgnoresStringsAndComments(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.sqd": "var a = 1\n"})
+	source := "# include(\"a.sqd\")\nio.echo(\"include(\\\"a.sqd\\\")\")\n"
+	expanded, err := expandIncludes(source, root)
+	if err != nil {
+		t.Fatalf("expandIncludes returned error: %v", err)
+	}
+	if expanded != strings.TrimSuffix(source, "\n") {
+		t.Fatalf("expected the source unchanged, got %q", expanded)
+	}
+}
+
+// writeFiles writes files, keyed by slash-separated paths, into a new
+// temporary directory and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("could not create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("could not write %s: %v", name, err)
+		}
+	}
+	return root
+}
@@ -0,0 +1,120 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"squ1d++/compiler"
+	"strings"
+)
+
+// CompilerVersion is mixed into build cache keys so outputs produced by an
+// older compiler are never reused after an upgrade.
+const CompilerVersion = "1.9.0"
+
+// ForceRebuild disables the build cache when set (CLI: --force).
+var ForceRebuild = false
+
+func SetForceRebuild(force bool) {
+	ForceRebuild = force
+}
+
+// OptimizationLevel is passed to the compiler for standalone builds
+// (CLI: -O0, -O1, -O2). See the compiler.Opt* constants.
+var OptimizationLevel = compiler.OptBasic
+
+func SetOptimizationLevel(level int) {
+	OptimizationLevel = level
+}
+
+// buildCacheKey hashes everything that affects the produced executable: the
+// expanded program source, the compiler version and optimization level, and
+// the target platform and the identity of the runtime binary that gets
+// copied into embedded executables.
+func buildCacheKey(expandedCode string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "squ1dcc %s\n", CompilerVersion)
+	fmt.Fprintf(h, "opt %d\n", OptimizationLevel)
+	fmt.Fprintf(h, "strict %t\n", Strict)
+	fmt.Fprintf(h, "target %s\n", Target)
+
+	if exe, err := findSourceExecutable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			fmt.Fprintf(h, "runtime %d %d\n", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	h.Write([]byte(expandedCode))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// buildCacheStampPath returns where the cache stamp for outputFile is kept.
+// Stamps live in the user cache directory (keyed by the absolute output path)
+// so builds don't litter the project directory.
+func buildCacheStampPath(outputFile string) (string, error) {
+	absOutput, err := filepath.Abs(outputFile)
+	if err != nil {
+		return "", err
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(absOutput))
+	return filepath.Join(cacheDir, "squ1dlang", "build", hex.EncodeToString(sum[:])+".stamp"), nil
+}
+
+// isBuildUpToDate reports whether outputFile was produced from the same cache
+// key and hasn't been replaced since.
+func isBuildUpToDate(key, outputFile string) bool {
+	info, err := os.Stat(outputFile)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	stampPath, err := buildCacheStampPath(outputFile)
+	if err != nil {
+		return false
+	}
+
+	data, err := os.ReadFile(stampPath)
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return false
+	}
+
+	return fields[0] == key && fields[1] == fmt.Sprint(info.Size())
+}
+
+// recordBuild writes the cache stamp for a freshly built outputFile. Failures
+// are only logged: a missing stamp just means the next build isn't skipped.
+func recordBuild(key, outputFile string) {
+	info, err := os.Stat(outputFile)
+	if err != nil {
+		return
+	}
+
+	stampPath, err := buildCacheStampPath(outputFile)
+	if err != nil {
+		logf(2, "Build cache disabled: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(stampPath), 0755); err != nil {
+		logf(2, "Build cache disabled: %v", err)
+		return
+	}
+
+	stamp := fmt.Sprintf("%s %d\n", key, info.Size())
+	if err := os.WriteFile(stampPath, []byte(stamp), 0644); err != nil {
+		logf(2, "Could not write build cache stamp: %v", err)
+	}
+}
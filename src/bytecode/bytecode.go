@@ -4,18 +4,64 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sort"
 	"squ1d++/code"
+	"squ1d++/compiler"
 	"squ1d++/object"
 )
 
-// Format version for bytecode compatibility checking
-const VERSION = 1
+// Format version for bytecode compatibility checking. Version 2 added the
+// line tables of the main program and of compiled functions, version 3 the
+// symbol table, version 4 the file tables of code from several files.
+const VERSION = 4
 
 // Package represents a compiled SQU1D++ package that can be serialized
 type Package struct {
 	Version      int
 	Instructions code.Instructions
 	Constants    []object.Object
+	// Lines, Positions, Filename and Files map the main program's
+	// instructions back to the source, like the fields of the same name in
+	// object.CompiledFunction, so errors can say where they happened.
+	Lines     map[int][]int
+	Positions map[int]object.Position
+	Filename  string
+	Files     map[int]string
+	// Symbols names the builtins and classes the program uses and its
+	// globals, so a package can be run by a runtime that numbers its
+	// builtins differently. Undefined holds the errors for the globals the
+	// program uses but never defines, by slot.
+	Symbols   []Symbol
+	Undefined map[int]*object.Error
+}
+
+// NewPackage packs a program compiled as a whole.
+func NewPackage(program *compiler.Bytecode) *Package {
+	return &Package{
+		Version:      VERSION,
+		Instructions: program.Instructions,
+		Constants:    program.Constants,
+		Lines:        program.Lines,
+		Positions:    program.Positions,
+		Filename:     program.Filename,
+		Files:        program.Files,
+		Symbols:      symbolTable(program),
+		Undefined:    program.Undefined,
+	}
+}
+
+// Bytecode returns the packed program, to be run in one go on a single VM.
+func (p *Package) Bytecode() *compiler.Bytecode {
+	return &compiler.Bytecode{
+		Instructions: p.Instructions,
+		Constants:    p.Constants,
+		Lines:        p.Lines,
+		Positions:    p.Positions,
+		Filename:     p.Filename,
+		Files:        p.Files,
+		GlobalNames:  p.globalNames(),
+		Undefined:    p.Undefined,
+	}
 }
 
 // Serialize writes a Package to bytecode format
@@ -45,6 +91,14 @@ func (p *Package) Serialize(w io.Writer) error {
 		}
 	}
 
+	if err := writeDebugInfo(w, p.Filename, p.Lines, p.Positions, p.Files); err != nil {
+		return fmt.Errorf("failed to write line tables: %v", err)
+	}
+
+	if err := writeSymbols(w, p.Symbols, p.Undefined); err != nil {
+		return fmt.Errorf("failed to write symbol table: %v", err)
+	}
+
 	return nil
 }
 
@@ -88,9 +142,178 @@ func Deserialize(r io.Reader) (*Package, error) {
 		pkg.Constants[i] = const_
 	}
 
+	var err error
+	pkg.Filename, pkg.Lines, pkg.Positions, pkg.Files, err = readDebugInfo(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read line tables: %v", err)
+	}
+
+	pkg.Symbols, pkg.Undefined, err = readSymbols(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symbol table: %v", err)
+	}
+	if err := pkg.relocate(); err != nil {
+		return nil, err
+	}
+
 	return pkg, nil
 }
 
+// writeDebugInfo writes the name of a source file followed by the line,
+// position and file tables of code compiled from it, in offset order so the
+// same program always serializes the same way.
+func writeDebugInfo(w io.Writer, filename string, lines map[int][]int, positions map[int]object.Position, files map[int]string) error {
+	if err := writeString(w, filename); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, int32(len(lines))); err != nil {
+		return err
+	}
+	offsets := make([]int, 0, len(lines))
+	for offset := range lines {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	for _, offset := range offsets {
+		if err := binary.Write(w, binary.LittleEndian, int32(offset)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, int32(len(lines[offset]))); err != nil {
+			return err
+		}
+		for _, line := range lines[offset] {
+			if err := binary.Write(w, binary.LittleEndian, int32(line)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, int32(len(positions))); err != nil {
+		return err
+	}
+	offsets = offsets[:0]
+	for offset := range positions {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	for _, offset := range offsets {
+		pos := positions[offset]
+		for _, v := range []int{offset, pos.Line, pos.Column} {
+			if err := binary.Write(w, binary.LittleEndian, int32(v)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, int32(len(files))); err != nil {
+		return err
+	}
+	offsets = offsets[:0]
+	for offset := range files {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	for _, offset := range offsets {
+		if err := binary.Write(w, binary.LittleEndian, int32(offset)); err != nil {
+			return err
+		}
+		if err := writeString(w, files[offset]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDebugInfo reads what writeDebugInfo wrote. Empty tables are read as
+// nil, like the compiler leaves them.
+func readDebugInfo(r io.Reader) (string, map[int][]int, map[int]object.Position, map[int]string, error) {
+	filename, err := readString(r)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return "", nil, nil, nil, err
+	}
+	var lines map[int][]int
+	for i := 0; i < int(count); i++ {
+		var offset, n int32
+		if err := binary.Read(r, binary.LittleEndian, &offset); err != nil {
+			return "", nil, nil, nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", nil, nil, nil, err
+		}
+		marked := make([]int32, n)
+		if err := binary.Read(r, binary.LittleEndian, marked); err != nil {
+			return "", nil, nil, nil, err
+		}
+		if lines == nil {
+			lines = map[int][]int{}
+		}
+		for _, line := range marked {
+			lines[int(offset)] = append(lines[int(offset)], int(line))
+		}
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return "", nil, nil, nil, err
+	}
+	var positions map[int]object.Position
+	for i := 0; i < int(count); i++ {
+		var entry [3]int32
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			return "", nil, nil, nil, err
+		}
+		if positions == nil {
+			positions = map[int]object.Position{}
+		}
+		positions[int(entry[0])] = object.Position{Line: int(entry[1]), Column: int(entry[2])}
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return "", nil, nil, nil, err
+	}
+	var files map[int]string
+	for i := 0; i < int(count); i++ {
+		var offset int32
+		if err := binary.Read(r, binary.LittleEndian, &offset); err != nil {
+			return "", nil, nil, nil, err
+		}
+		file, err := readString(r)
+		if err != nil {
+			return "", nil, nil, nil, err
+		}
+		if files == nil {
+			files = map[int]string{}
+		}
+		files[int(offset)] = file
+	}
+	return filename, lines, positions, files, nil
+}
+
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, int32(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 // Constant type markers for serialization
 const (
 	constTypeNil        = 0
@@ -101,6 +324,9 @@ const (
 	constTypeArray      = 5
 	constTypeHash       = 6
 	constTypeCompiledFn = 7
+	constTypeHex        = 8
+	constTypeSet        = 9
+	constTypeTuple      = 10
 )
 
 func serializeConstant(w io.Writer, obj object.Object) error {
@@ -120,6 +346,12 @@ func serializeConstant(w io.Writer, obj object.Object) error {
 		}
 		return binary.Write(w, binary.LittleEndian, obj.Value)
 
+	case *object.Hex:
+		if err := binary.Write(w, binary.LittleEndian, int8(constTypeHex)); err != nil {
+			return err
+		}
+		return binary.Write(w, binary.LittleEndian, obj.Value)
+
 	case *object.String:
 		if err := binary.Write(w, binary.LittleEndian, int8(constTypeString)); err != nil {
 			return err
@@ -157,7 +389,7 @@ func serializeConstant(w io.Writer, obj object.Object) error {
 		if err := binary.Write(w, binary.LittleEndian, int32(len(obj.Pairs))); err != nil {
 			return err
 		}
-		for _, pair := range obj.Pairs {
+		for _, pair := range obj.Ordered() {
 			// Serialize key
 			if err := serializeConstant(w, pair.Key); err != nil {
 				return err
@@ -169,6 +401,35 @@ func serializeConstant(w io.Writer, obj object.Object) error {
 		}
 		return nil
 
+	case *object.Set:
+		if err := binary.Write(w, binary.LittleEndian, int8(constTypeSet)); err != nil {
+			return err
+		}
+		items := obj.Items()
+		if err := binary.Write(w, binary.LittleEndian, int32(len(items))); err != nil {
+			return err
+		}
+		for _, elem := range items {
+			if err := serializeConstant(w, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *object.Tuple:
+		if err := binary.Write(w, binary.LittleEndian, int8(constTypeTuple)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, int32(len(obj.Elements))); err != nil {
+			return err
+		}
+		for _, elem := range obj.Elements {
+			if err := serializeConstant(w, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+
 	case *object.CompiledFunction:
 		if err := binary.Write(w, binary.LittleEndian, int8(constTypeCompiledFn)); err != nil {
 			return err
@@ -182,7 +443,13 @@ func serializeConstant(w io.Writer, obj object.Object) error {
 		if err := binary.Write(w, binary.LittleEndian, int32(obj.NumLocals)); err != nil {
 			return err
 		}
-		return binary.Write(w, binary.LittleEndian, int32(obj.NumParameters))
+		if err := binary.Write(w, binary.LittleEndian, int32(obj.NumParameters)); err != nil {
+			return err
+		}
+		if err := writeString(w, obj.Name); err != nil {
+			return err
+		}
+		return writeDebugInfo(w, obj.Filename, obj.Lines, obj.Positions, obj.Files)
 
 	default:
 		return fmt.Errorf("cannot serialize object type: %T", obj)
@@ -213,6 +480,13 @@ func deserializeConstant(r io.Reader) (object.Object, error) {
 		}
 		return &object.Float{Value: val}, nil
 
+	case constTypeHex:
+		var val int64
+		if err := binary.Read(r, binary.LittleEndian, &val); err != nil {
+			return nil, err
+		}
+		return &object.Hex{Value: val}, nil
+
 	case constTypeString:
 		var len int32
 		if err := binary.Read(r, binary.LittleEndian, &len); err != nil {
@@ -251,7 +525,7 @@ func deserializeConstant(r io.Reader) (object.Object, error) {
 		if err := binary.Read(r, binary.LittleEndian, &len); err != nil {
 			return nil, err
 		}
-		pairs := make(map[object.HashKey]object.HashPair)
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
 		for i := 0; i < int(len); i++ {
 			key, err := deserializeConstant(r)
 			if err != nil {
@@ -261,13 +535,45 @@ func deserializeConstant(r io.Reader) (object.Object, error) {
 			if err != nil {
 				return nil, err
 			}
-			hashable, ok := key.(object.Hashable)
+			hashKey, ok := object.HashKeyOf(key)
 			if !ok {
 				return nil, fmt.Errorf("unhashable key type: %T", key)
 			}
-			pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+			hash.Set(hashKey, object.HashPair{Key: key, Value: value})
+		}
+		return hash, nil
+
+	case constTypeSet:
+		var len int32
+		if err := binary.Read(r, binary.LittleEndian, &len); err != nil {
+			return nil, err
+		}
+		set := object.NewSet()
+		for i := 0; i < int(len); i++ {
+			elem, err := deserializeConstant(r)
+			if err != nil {
+				return nil, err
+			}
+			if err := set.Add(elem); err != nil {
+				return nil, err
+			}
 		}
-		return &object.Hash{Pairs: pairs}, nil
+		return set, nil
+
+	case constTypeTuple:
+		var len int32
+		if err := binary.Read(r, binary.LittleEndian, &len); err != nil {
+			return nil, err
+		}
+		elements := make([]object.Object, len)
+		for i := range elements {
+			elem, err := deserializeConstant(r)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = elem
+		}
+		return &object.Tuple{Elements: elements}, nil
 
 	case constTypeCompiledFn:
 		var insLen int32
@@ -285,10 +591,23 @@ func deserializeConstant(r io.Reader) (object.Object, error) {
 		if err := binary.Read(r, binary.LittleEndian, &numParams); err != nil {
 			return nil, err
 		}
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		filename, lines, positions, files, err := readDebugInfo(r)
+		if err != nil {
+			return nil, err
+		}
 		return &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     int(numLocals),
 			NumParameters: int(numParams),
+			Name:          name,
+			Lines:         lines,
+			Positions:     positions,
+			Filename:      filename,
+			Files:         files,
 		}, nil
 
 	default:
@@ -0,0 +1,172 @@
+package bytecode
+
+import (
+	"bytes"
+	"reflect"
+	"squ1d++/code"
+	"squ1d++/compiler"
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"strings"
+	"testing"
+)
+
+func TestLineTablesRoundTrip(t *testing.T) {
+	input := "var h = def(x) {\n\t10 / x\n}\nh(0)"
+	comp := compiler.New()
+	comp.Filename = "main.sqd"
+	if err := comp.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bc := comp.Bytecode()
+
+	var buf bytes.Buffer
+	pkg := NewPackage(bc)
+	if err := pkg.Serialize(&buf); err != nil {
+		t.Fatalf("serialize: %s", err)
+	}
+	loaded, err := Deserialize(&buf)
+	if err != nil {
+		t.Fatalf("deserialize: %s", err)
+	}
+
+	program := loaded.Bytecode()
+	if !bytes.Equal(program.Instructions, bc.Instructions) {
+		t.Errorf("instructions changed: got %v, want %v", program.Instructions, bc.Instructions)
+	}
+	if program.Filename != "main.sqd" || !reflect.DeepEqual(program.Lines, bc.Lines) ||
+		!reflect.DeepEqual(program.Positions, bc.Positions) {
+		t.Errorf("main program tables changed: got %q %v %v, want %q %v %v",
+			program.Filename, program.Lines, program.Positions, bc.Filename, bc.Lines, bc.Positions)
+	}
+
+	var fn, original *object.CompiledFunction
+	for i, constant := range bc.Constants {
+		if f, ok := constant.(*object.CompiledFunction); ok {
+			original, fn = f, loaded.Constants[i].(*object.CompiledFunction)
+		}
+	}
+	if fn == nil {
+		t.Fatalf("no function among the constants")
+	}
+	if fn.Name != "h" || fn.Filename != "main.sqd" || !reflect.DeepEqual(fn.Lines, original.Lines) ||
+		!reflect.DeepEqual(fn.Positions, original.Positions) {
+		t.Errorf("function tables changed: got %+v, want %+v", fn, original)
+	}
+}
+
+func compileProgram(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func roundTrip(t *testing.T, pkg *Package) (*Package, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := pkg.Serialize(&buf); err != nil {
+		t.Fatalf("serialize: %s", err)
+	}
+	return Deserialize(&buf)
+}
+
+func TestSymbolTableRoundTrip(t *testing.T) {
+	bc := compileProgram(t, "var x = type.tp(1)\nvar f = def() { x }")
+	pkg := NewPackage(bc)
+
+	typeIndex := indexOf(builtinNames(), "type")
+	want := []Symbol{
+		{Name: "type", Scope: compiler.BuiltinScope, Index: typeIndex},
+		{Name: "x", Scope: compiler.GlobalScope, Index: 0},
+		{Name: "f", Scope: compiler.GlobalScope, Index: 1},
+	}
+	if !reflect.DeepEqual(pkg.Symbols, want) {
+		t.Fatalf("wrong symbols: got %+v, want %+v", pkg.Symbols, want)
+	}
+
+	pkg.Undefined = map[int]*object.Error{2: {Message: "Undefined variable y", Line: 3, Column: 1}}
+	loaded, err := roundTrip(t, pkg)
+	if err != nil {
+		t.Fatalf("deserialize: %s", err)
+	}
+	if !reflect.DeepEqual(loaded.Symbols, want) {
+		t.Errorf("symbols changed: got %+v, want %+v", loaded.Symbols, want)
+	}
+	if !reflect.DeepEqual(loaded.Undefined, pkg.Undefined) {
+		t.Errorf("undefined globals changed: got %+v, want %+v", loaded.Undefined, pkg.Undefined)
+	}
+	names := loaded.Bytecode().GlobalNames
+	if names[0] != "x" || names[1] != "f" {
+		t.Errorf("wrong global names: %v", names)
+	}
+}
+
+func TestRelocateBuiltins(t *testing.T) {
+	bc := compileProgram(t, "var f = def() { type.tp(1) }\nf()")
+	pkg := NewPackage(bc)
+	original := bc.Instructions.String()
+	var fn *object.CompiledFunction
+	for _, constant := range pkg.Constants {
+		if f, ok := constant.(*object.CompiledFunction); ok {
+			fn = f
+		}
+	}
+	wantFn := fn.Instructions.String()
+
+	// Pretend the package was written by a compiler that numbered type 7.
+	for i := range pkg.Symbols {
+		if pkg.Symbols[i].Name == "type" {
+			pkg.Symbols[i].Index = 7
+		}
+	}
+	fn.Instructions[0] = byte(code.OpGetBuiltin)
+	fn.Instructions[1] = 7
+
+	loaded, err := roundTrip(t, pkg)
+	if err != nil {
+		t.Fatalf("deserialize: %s", err)
+	}
+	if got := loaded.Instructions.String(); got != original {
+		t.Errorf("main program changed:\n%s\nwant:\n%s", got, original)
+	}
+	for _, constant := range loaded.Constants {
+		if f, ok := constant.(*object.CompiledFunction); ok {
+			if got := f.Instructions.String(); got != wantFn {
+				t.Errorf("builtin not relocated:\n%s\nwant:\n%s", got, wantFn)
+			}
+		}
+	}
+}
+
+func TestRelocateUnknownBuiltin(t *testing.T) {
+	pkg := NewPackage(compileProgram(t, "type.tp(1)"))
+	pkg.Symbols[0].Name = "nosuchbuiltin"
+
+	_, err := roundTrip(t, pkg)
+	if err == nil || !strings.Contains(err.Error(), "nosuchbuiltin") {
+		t.Fatalf("expected an error naming the missing builtin, got %v", err)
+	}
+}
+
+func TestBuiltinNamesAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, name := range builtinNames() {
+		if seen[name] {
+			t.Errorf("two builtins are named %s", name)
+		}
+		seen[name] = true
+	}
+}
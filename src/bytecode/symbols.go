@@ -0,0 +1,226 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"squ1d++/code"
+	"squ1d++/compiler"
+	"squ1d++/object"
+)
+
+// Symbol is an entry of a package's symbol table: a builtin or class the
+// program refers to by index, or one of its global variables.
+type Symbol struct {
+	Name  string
+	Scope compiler.SymbolScope
+	Index int
+}
+
+// builtinNames returns the names of the builtins and classes in the order
+// the compiler numbers them. Builtins of a class are named class.name, as
+// several classes have builtins of the same name.
+func builtinNames() []string {
+	names := make([]string, 0, len(object.Builtins)+len(object.ClassNames))
+	for _, def := range object.Builtins {
+		if def.Builtin.Class != "" {
+			names = append(names, def.Builtin.Class+"."+def.Name)
+		} else {
+			names = append(names, def.Name)
+		}
+	}
+	classes := object.ClassObjects()
+	for _, name := range object.ClassNames {
+		if _, ok := classes[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// eachInstruction calls f with the offset and opcode of each instruction of
+// the program and of its functions.
+func eachInstruction(instructions code.Instructions, constants []object.Object, f func(ins code.Instructions, offset int, op code.Opcode)) {
+	walk := func(ins code.Instructions) {
+		for i := 0; i < len(ins); {
+			def, err := code.Lookup(ins[i])
+			if err != nil {
+				return
+			}
+			f(ins, i, code.Opcode(ins[i]))
+			_, read := code.ReadOperands(def, ins[i+1:])
+			i += 1 + read
+		}
+	}
+	walk(instructions)
+	for _, constant := range constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			walk(fn.Instructions)
+		}
+	}
+}
+
+// symbolTable lists the builtins program uses and its globals, by index.
+func symbolTable(program *compiler.Bytecode) []Symbol {
+	names := builtinNames()
+	used := map[int]bool{}
+	eachInstruction(program.Instructions, program.Constants, func(ins code.Instructions, offset int, op code.Opcode) {
+		if op == code.OpGetBuiltin {
+			used[int(code.ReadUint8(ins[offset+1:]))] = true
+		}
+	})
+
+	var symbols []Symbol
+	for index := range used {
+		if index < len(names) {
+			symbols = append(symbols, Symbol{Name: names[index], Scope: compiler.BuiltinScope, Index: index})
+		}
+	}
+	for index, name := range program.GlobalNames {
+		symbols = append(symbols, Symbol{Name: name, Scope: compiler.GlobalScope, Index: index})
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Scope != symbols[j].Scope {
+			return symbols[i].Scope < symbols[j].Scope
+		}
+		return symbols[i].Index < symbols[j].Index
+	})
+	return symbols
+}
+
+// relocate renumbers the builtins the program refers to by the names its
+// symbol table gives them, for a runtime that numbers its builtins
+// differently than the compiler that wrote the package.
+func (p *Package) relocate() error {
+	current := map[string]int{}
+	for i, name := range builtinNames() {
+		current[name] = i
+	}
+	moved := map[int]int{}
+	for _, sym := range p.Symbols {
+		if sym.Scope != compiler.BuiltinScope {
+			continue
+		}
+		index, ok := current[sym.Name]
+		if !ok {
+			return fmt.Errorf("the program uses %s, which this runtime doesn't have", sym.Name)
+		}
+		if index != sym.Index {
+			moved[sym.Index] = index
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+
+	eachInstruction(p.Instructions, p.Constants, func(ins code.Instructions, offset int, op code.Opcode) {
+		if op != code.OpGetBuiltin {
+			return
+		}
+		if index, ok := moved[int(code.ReadUint8(ins[offset+1:]))]; ok {
+			ins[offset+1] = byte(index)
+		}
+	})
+	return nil
+}
+
+// globalNames returns the names of the program's globals by slot.
+func (p *Package) globalNames() map[int]string {
+	var names map[int]string
+	for _, sym := range p.Symbols {
+		if sym.Scope == compiler.GlobalScope {
+			if names == nil {
+				names = map[int]string{}
+			}
+			names[sym.Index] = sym.Name
+		}
+	}
+	return names
+}
+
+func writeSymbols(w io.Writer, symbols []Symbol, undefined map[int]*object.Error) error {
+	if err := binary.Write(w, binary.LittleEndian, int32(len(symbols))); err != nil {
+		return err
+	}
+	for _, sym := range symbols {
+		if err := writeString(w, sym.Name); err != nil {
+			return err
+		}
+		if err := writeString(w, string(sym.Scope)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, int32(sym.Index)); err != nil {
+			return err
+		}
+	}
+
+	indexes := make([]int, 0, len(undefined))
+	for index := range undefined {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	if err := binary.Write(w, binary.LittleEndian, int32(len(indexes))); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		e := undefined[index]
+		if err := binary.Write(w, binary.LittleEndian, int32(index)); err != nil {
+			return err
+		}
+		if err := writeString(w, e.Message); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, [2]int32{int32(e.Line), int32(e.Column)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSymbols(r io.Reader) ([]Symbol, map[int]*object.Error, error) {
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, nil, err
+	}
+	var symbols []Symbol
+	for i := 0; i < int(count); i++ {
+		name, err := readString(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		scope, err := readString(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		var index int32
+		if err := binary.Read(r, binary.LittleEndian, &index); err != nil {
+			return nil, nil, err
+		}
+		symbols = append(symbols, Symbol{Name: name, Scope: compiler.SymbolScope(scope), Index: int(index)})
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, nil, err
+	}
+	var undefined map[int]*object.Error
+	for i := 0; i < int(count); i++ {
+		var index int32
+		if err := binary.Read(r, binary.LittleEndian, &index); err != nil {
+			return nil, nil, err
+		}
+		message, err := readString(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		var position [2]int32
+		if err := binary.Read(r, binary.LittleEndian, &position); err != nil {
+			return nil, nil, err
+		}
+		if undefined == nil {
+			undefined = map[int]*object.Error{}
+		}
+		undefined[int(index)] = &object.Error{Message: message, Line: int(position[0]), Column: int(position[1])}
+	}
+	return symbols, undefined, nil
+}
@@ -43,6 +43,10 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	// OpAnd and OpOr short-circuit `and` and `or`. OpAnd pops a value and,
+	// when it is falsy, pushes false and jumps to its operand; OpOr does the
+	// same for a truthy value and true. Otherwise execution carries on with
+	// the next instruction.
 	OpAnd
 	OpOr
 	OpSuppress
@@ -52,6 +56,85 @@ const (
 	OpErrorExit
 	OpExtractErrorField
 	OpExtractOkField
+	OpSet
+	OpTuple
+	OpUnpack
+	// OpSetGlobalWide and OpGetGlobalWide are OpSetGlobal and OpGetGlobal
+	// for slots past 65535.
+	OpSetGlobalWide
+	OpGetGlobalWide
+	// OpCheckType fails unless the value on top of the stack matches a type
+	// annotation. Its operands are the constants holding the type name and
+	// the name of what is annotated.
+	OpCheckType
+	// OpLoopLocal and OpLoopGlobal end an iteration of a counted loop: they
+	// add a step to the counter, compare it with the limit on top of the
+	// stack and jump back to the body while the condition holds. The
+	// operands are the counter's slot, the constant holding the step, the
+	// comparison (LoopLess and co.) and the position of the body.
+	OpLoopLocal
+	OpLoopGlobal
+	// OpMatch pops the subject of a match expression and skips to the
+	// OpJump of its arm in the jump table that follows. The operands are the
+	// constant mapping each pattern to its arm and the number of arms; a
+	// subject no pattern matches takes the last jump, the el branch.
+	OpMatch
+	// OpGetLocalCell, OpGetFreeCell and OpSetFree give closures shared
+	// access to the locals they capture. OpGetLocalCell moves a local into a
+	// cell (object.Cell) the first time it is captured and pushes the cell;
+	// OpGetFreeCell pushes the cell of a variable the current closure
+	// captured, for a closure nested in it; OpSetFree assigns to a captured
+	// variable. OpGetLocal, OpSetLocal and OpGetFree see through cells.
+	OpGetLocalCell
+	OpGetFreeCell
+	OpSetFree
+	// OpDup pushes the value on top of the stack again.
+	OpDup
+	// OpMatchValue, OpMatchArray, OpMatchTuple and OpMatchHash test a value
+	// against a pattern of a match arm: they pop it and push whether it
+	// equals a constant, is an array or a tuple of a given length, or is a
+	// hash holding all the keys in a constant array.
+	OpMatchValue
+	OpMatchArray
+	OpMatchTuple
+	OpMatchHash
+	// OpIter replaces the collection on top of the stack with an iterator
+	// (object.Iterator) for a for-in loop. OpIterNext pushes the next value
+	// of the iterator below it, or the next key and value when its second
+	// operand is 2; once the iterator is exhausted it jumps to its first
+	// operand instead, leaving the iterator for an OpSuppress there.
+	OpIter
+	OpIterNext
+	// OpAttempt starts the attempt block of an attempt expression: an error
+	// raised before the matching OpEndAttempt unwinds the stack to where it
+	// was and jumps to the operand, the rescue block, with the error pushed.
+	// OpCheckError raises the value on top of the stack if it is an error
+	// value, and leaves it there otherwise.
+	OpAttempt
+	OpEndAttempt
+	OpCheckError
+	// OpClass makes a class named by the constant operand from the second
+	// operand's pairs of method name and closure on the stack.
+	// OpSetField pops an object, a field name and a value, sets the field
+	// and pushes null.
+	OpClass
+	OpSetField
+	// OpSlice pops a collection and the start and end of a slice of it,
+	// either of which can be null, and pushes the slice.
+	OpSlice
+	// OpEcho ends a top-level statement of a program compiled to run as a
+	// whole: it prints the statement's value, as the REPL would, unless it
+	// is null or was assigned.
+	OpEcho
+)
+
+// The comparisons of OpLoopLocal and OpLoopGlobal: the loop runs while
+// counter < limit, counter <= limit, and so on.
+const (
+	LoopLess = iota
+	LoopLessEqual
+	LoopGreater
+	LoopGreaterEqual
 )
 
 type Definition struct {
@@ -92,8 +175,8 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:           {"OpClosure", []int{2, 1}},
 	OpGetFree:           {"OpGetFree", []int{1}},
 	OpCurrentClosure:    {"OpCurrentClosure", []int{}},
-	OpAnd:               {"OpAnd", []int{}},
-	OpOr:                {"OpOr", []int{}},
+	OpAnd:               {"OpAnd", []int{2}},
+	OpOr:                {"OpOr", []int{2}},
 	OpSuppress:          {"OpSuppress", []int{}},
 	OpBreak:             {"OpBreak", []int{}},
 	OpContinue:          {"OpContinue", []int{}},
@@ -101,6 +184,32 @@ var definitions = map[Opcode]*Definition{
 	OpErrorExit:         {"OpErrorExit", []int{}},
 	OpExtractErrorField: {"OpExtractErrorField", []int{}},
 	OpExtractOkField:    {"OpExtractOkField", []int{}},
+	OpSet:               {"OpSet", []int{2}},
+	OpTuple:             {"OpTuple", []int{2}},
+	OpUnpack:            {"OpUnpack", []int{2}},
+	OpSetGlobalWide:     {"OpSetGlobalWide", []int{4}},
+	OpGetGlobalWide:     {"OpGetGlobalWide", []int{4}},
+	OpCheckType:         {"OpCheckType", []int{2, 2}},
+	OpLoopLocal:         {"OpLoopLocal", []int{1, 2, 1, 2}},
+	OpLoopGlobal:        {"OpLoopGlobal", []int{2, 2, 1, 2}},
+	OpMatch:             {"OpMatch", []int{2, 2}},
+	OpGetLocalCell:      {"OpGetLocalCell", []int{1}},
+	OpGetFreeCell:       {"OpGetFreeCell", []int{1}},
+	OpSetFree:           {"OpSetFree", []int{1}},
+	OpDup:               {"OpDup", []int{}},
+	OpMatchValue:        {"OpMatchValue", []int{2}},
+	OpMatchArray:        {"OpMatchArray", []int{2}},
+	OpMatchTuple:        {"OpMatchTuple", []int{2}},
+	OpMatchHash:         {"OpMatchHash", []int{2}},
+	OpIter:              {"OpIter", []int{}},
+	OpIterNext:          {"OpIterNext", []int{2, 1}},
+	OpAttempt:           {"OpAttempt", []int{2}},
+	OpEndAttempt:        {"OpEndAttempt", []int{}},
+	OpCheckError:        {"OpCheckError", []int{}},
+	OpClass:             {"OpClass", []int{2, 1}},
+	OpSetField:          {"OpSetField", []int{}},
+	OpSlice:             {"OpSlice", []int{}},
+	OpEcho:              {"OpEcho", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -130,6 +239,8 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, o := range operands {
 		width := def.OperandWidths[i]
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
@@ -179,6 +290,8 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
 	case 2:
 		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	case 4:
+		return fmt.Sprintf("%s %d %d %d %d", def.Name, operands[0], operands[1], operands[2], operands[3])
 	}
 
 	return fmt.Sprintf("ERROR: Unhandled operandCount for %s\n", def.Name)
@@ -193,6 +306,8 @@ func ReadOperands(
 
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
 		case 1:
@@ -209,6 +324,10 @@ func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
 
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint8(ins Instructions) uint8 {
 	return uint8(ins[0])
 }
@@ -14,6 +14,7 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpGetGlobalWide, []int{65536}, []byte{byte(OpGetGlobalWide), 0, 1, 0, 0}},
 	}
 
 	for _, tt := range tests {
@@ -40,6 +41,7 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
 		Make(OpClosure, 65535, 255),
+		Make(OpLoopGlobal, 3, 4, LoopLessEqual, 9),
 	}
 
 	expected := `0000 OpAdd
@@ -47,6 +49,7 @@ func TestInstructionsString(t *testing.T) {
 0003 OpConstant 2
 0006 OpConstant 65535
 0009 OpClosure 65535 255
+0013 OpLoopGlobal 3 4 1 9
 `
 
 	concatted := Instructions{}
@@ -70,6 +73,8 @@ func TestReadOperands(t *testing.T) {
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
 		{OpClosure, []int{65535, 255}, 3},
+		{OpSetGlobalWide, []int{70000}, 4},
+		{OpLoopLocal, []int{255, 65535, LoopGreater, 1024}, 6},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"squ1d++/astdump"
+	"squ1d++/bench"
+	"squ1d++/builder"
+	"squ1d++/compiler"
+	"squ1d++/coverage"
+	"squ1d++/debug"
+	"squ1d++/format"
+	"squ1d++/lint"
+	"squ1d++/object"
+	"squ1d++/pkg"
+	"squ1d++/profile"
+	"squ1d++/repl"
+	"squ1d++/runner"
+	"squ1d++/sqxdev"
+	"squ1d++/vm"
+	"strings"
+)
+
+// command is a subcommand of squ1dcc. run gets the arguments after the
+// command's name and returns the exit status: 0 on success, 1 when the
+// command failed and 2 when it was used wrongly.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands in the order `squ1dcc help` shows them.
+// It is filled in by init, as help itself is one of them.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "Run a .sqd file", runCommand},
+		{"build", "Build a .sqd file into a standalone executable", buildCommand},
+		{"check", "Compile .sqd files as a build would, without running them", checkCommand},
+		{"repl", "Start an interactive session", replCommand},
+		{"fmt", "Format .sqd files", func(args []string) int { return format.Run(args, os.Stdin, os.Stdout, os.Stderr) }},
+		{"lint", "Report likely mistakes in .sqd files", func(args []string) int { return lint.Run(args, os.Stdout, os.Stderr) }},
+		{"ast", "Print the syntax tree of a file", func(args []string) int { return astdump.Run(args, os.Stdin, os.Stdout, os.Stderr) }},
+		{"bench", "Run the benchmarks of a file", func(args []string) int { return bench.Run(args, os.Stdout, os.Stderr) }},
+		{"cover", "Run a program and report the statements it ran", func(args []string) int { return coverage.Run(args, os.Stdout, os.Stderr) }},
+		{"debug", "Run a program under the interactive debugger", func(args []string) int { return debug.Run(args, os.Stdin, os.Stdout, os.Stderr) }},
+		{"profile", "Run a program and report where it spends its time", func(args []string) int { return profile.Run(args, os.Stdout, os.Stderr) }},
+		{"pkg", "Install, publish and manage packages", func(args []string) int { return pkg.Run(args, os.Stdout, os.Stderr) }},
+		{"sqx", "Create and build SQX plugins", func(args []string) int { return sqxdev.Run(args, os.Stdout, os.Stderr) }},
+		{"help", "Show this help, or the help of a command", helpCommand},
+	}
+}
+
+// lookupCommand returns the subcommand called name.
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// dispatch runs the command named by args[0]. Without one it keeps the
+// forms from before there were subcommands: no arguments start the REPL,
+// -B builds, -check checks and anything else runs a file.
+func dispatch(args []string) int {
+	name, rest := route(args)
+	if name == "" {
+		return runOrRepl(rest, true)
+	}
+	cmd, _ := lookupCommand(name)
+	return cmd.run(rest)
+}
+
+// route returns the name of the command dispatch runs for args and the
+// arguments it passes it, or "" when args run a file or start the REPL.
+func route(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "repl", nil
+	}
+	if cmd, ok := lookupCommand(args[0]); ok {
+		return cmd.name, args[1:]
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		return "help", nil
+	}
+
+	// Like the flag parsing of old, the legacy flags only count before the
+	// file: what follows it is left to the run command.
+	for i := 0; i < len(args) && strings.HasPrefix(args[i], "-") && args[i] != "-"; i++ {
+		rest := append(append([]string(nil), args[:i]...), args[i+1:]...)
+		switch args[i] {
+		case "-B", "--B":
+			return "build", rest
+		case "-check", "--check":
+			return "check", rest
+		default:
+			if valueFlags[strings.TrimLeft(args[i], "-")] {
+				i++
+			}
+		}
+	}
+	return "", args
+}
+
+// valueFlags are the flags of run and build that take the next argument as
+// their value.
+var valueFlags = map[string]bool{
+	"o": true, "runtime": true, "target": true, "sqx-session": true, "stack-size": true,
+	"max-frames": true, "plugin": true, "record": true, "replay": true,
+}
+
+func printHelp(w io.Writer) {
+	fmt.Fprintln(w, "Usage: squ1dcc <command> [arguments]")
+	fmt.Fprintln(w, "       squ1dcc file.sqd        (same as squ1dcc run file.sqd)")
+	fmt.Fprintln(w, "       squ1dcc                 (same as squ1dcc repl)")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Run 'squ1dcc help <command>' for the flags of a command.")
+}
+
+// helpCommand implements `squ1dcc help [command]`.
+func helpCommand(args []string) int {
+	if len(args) == 0 {
+		printHelp(os.Stdout)
+		return 0
+	}
+	cmd, ok := lookupCommand(args[0])
+	if !ok || cmd.name == "help" {
+		fmt.Fprintf(os.Stderr, "help: unknown command %q\n", args[0])
+		printHelp(os.Stderr)
+		return 2
+	}
+	cmd.run([]string{"-h"})
+	return 0
+}
+
+// newFlagSet returns a flag set for the command name that prints usage,
+// a description and the flags on -h or a wrong flag.
+func newFlagSet(name, usage, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: squ1dcc %s\n\n%s\n\n", usage, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFiles parses args with fs, letting flags come before or after the
+// files, and returns the files. ok is false when a flag is wrong.
+func parseFiles(fs *flag.FlagSet, args []string) (files []string, ok bool) {
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, false
+		}
+		if fs.NArg() == 0 {
+			return files, true
+		}
+		files = append(files, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// verbosityFlags adds -v, -vv and -vvv, which make builds log what they do,
+// and returns a function reading the level they chose.
+func verbosityFlags(fs *flag.FlagSet) func() int {
+	v := fs.Bool("v", false, "Log the main steps of the build")
+	vv := fs.Bool("vv", false, "Log the build in more detail")
+	vvv := fs.Bool("vvv", false, "Log every step of the build")
+	return func() int {
+		switch {
+		case *vvv:
+			return 3
+		case *vv:
+			return 2
+		case *v:
+			return 1
+		}
+		return 0
+	}
+}
+
+// optimizationFlags adds -O, -O0, -O1 and -O2 and returns a function
+// reading the level they chose, -O1 by default.
+func optimizationFlags(fs *flag.FlagSet) func() int {
+	o := fs.Bool("O", false, "Optimize fully, the same as -O2")
+	o0 := fs.Bool("O0", false, "Compile without optimizations")
+	fs.Bool("O1", false, "Fold constants and deduplicate the constant pool (default)")
+	o2 := fs.Bool("O2", false, "Also remove dead code and run the peephole pass")
+	return func() int {
+		switch {
+		case *o, *o2:
+			return compiler.OptFull
+		case *o0:
+			return compiler.OptNone
+		}
+		return compiler.OptBasic
+	}
+}
+
+// strictFlag adds --strict and returns a function that applies it to builds
+// and checks when it was given.
+func strictFlag(fs *flag.FlagSet, usage string) (strict *bool, apply func()) {
+	strict = fs.Bool("strict", false, usage)
+	return strict, func() {
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "strict" {
+				builder.SetStrict(*strict)
+			}
+		})
+	}
+}
+
+// sessionFlags adds the flags the run and repl commands share and returns
+// a function that applies them. It reports whether plugins could be loaded.
+func sessionFlags(fs *flag.FlagSet) func() bool {
+	sqxSession := fs.String("sqx-session", "auto", "SQX session mode: auto, always, legacy")
+	trace := fs.Bool("trace", false, "Log every VM instruction to stderr (sys.trace toggles it at runtime)")
+	warnings := fs.Bool("W", false, "Print compiler warnings (unused and shadowing locals) to stderr")
+	stackSize := fs.Int("stack-size", 0, "Most values the VM stack grows to, at least 1024 (default 65536; sys.set_overflow_size changes it at runtime)")
+	maxFrames := fs.Int("max-frames", 0, "How deeply function calls can nest, at least 1 (default 1024)")
+	var plugins []string
+	fs.Func("plugin", "Load builtins from a Go plugin (.so) before running; can be repeated", func(path string) error {
+		plugins = append(plugins, path)
+		return nil
+	})
+
+	return func() bool {
+		if err := loadPlugins(plugins); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return false
+		}
+		if *stackSize != 0 {
+			if *stackSize < 1024 {
+				fmt.Fprintf(os.Stderr, "Error: --stack-size must be at least 1024\n")
+				return false
+			}
+			object.SysMaxStackSize = *stackSize
+		}
+		if *maxFrames != 0 {
+			if *maxFrames < 1 {
+				fmt.Fprintf(os.Stderr, "Error: --max-frames must be at least 1\n")
+				return false
+			}
+			vm.DefaultOptions.MaxFrames = *maxFrames
+		}
+		object.Tracing = *trace
+		if *warnings {
+			runner.Warnings = os.Stderr
+		}
+		switch strings.ToLower(*sqxSession) {
+		case "always", "on", "true", "yes", "1":
+			object.CurrentSessionMode = object.SessionModeAlways
+		case "legacy", "off", "false", "no", "0":
+			object.CurrentSessionMode = object.SessionModeLegacy
+		default:
+			object.CurrentSessionMode = object.SessionModeAuto
+		}
+		return true
+	}
+}
+
+// runCommand implements `squ1dcc run [flags] file.sqd`.
+func runCommand(args []string) int {
+	return runOrRepl(args, false)
+}
+
+// runOrRepl runs the file args name or, when they name none and orRepl
+// is set, starts the REPL with the same flags.
+func runOrRepl(args []string, orRepl bool) int {
+	fs := newFlagSet("run", "run [flags] file.sqd",
+		"Compiles file.sqd, includes and all, and runs it on the VM, reporting\nruntime errors with a traceback. Arguments after the file are ignored.")
+	apply := sessionFlags(fs)
+	eval := fs.Bool("eval", false, "Run one statement at a time, with included files on the evaluator, as before")
+	stats := fs.Bool("stats", false, "Print execution statistics to stderr after running")
+	record := fs.String("record", "", "Record the results of nondeterministic builtins to this trace file")
+	replay := fs.String("replay", "", "Replay a run from a trace file written by --record")
+	strict := fs.Bool("strict", false, "Make names that functions use but the program never defines compile errors")
+	level := optimizationFlags(fs)
+	verbosity := verbosityFlags(fs)
+	// The flags come before the file. What follows it is ignored, as it
+	// always was, rather than taken for more files or flags.
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 && !orRepl {
+		fs.Usage()
+		return 2
+	}
+	if !apply() {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		return startRepl()
+	}
+	defer object.CloseAllSessions()
+	builder.SetVerbosity(verbosity())
+	builder.SetOptimizationLevel(level())
+	builder.SetStrict(*strict)
+
+	filename := fs.Arg(0)
+	if *eval && *strict {
+		// --eval compiles one statement at a time, so names are only
+		// known to be undefined after checking the whole program.
+		if err := builder.Check(filename); err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking file %s: %v\n", filename, err)
+			return 1
+		}
+	}
+	if *stats {
+		vm.CurrentStats = vm.NewStats()
+	}
+	stopTrace, err := startTrace(*record, *replay)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if strings.HasSuffix(filename, ".byc") {
+		err = repl.ExecuteBytecodeFile(filename, os.Stdout)
+	} else if *eval {
+		err = repl.ExecuteFile(filename, os.Stdout)
+	} else {
+		err = repl.ExecuteCompiledFile(filename, os.Stdout)
+	}
+	if traceErr := stopTrace(); traceErr != nil {
+		fmt.Fprintf(os.Stderr, "Error writing trace %s: %v\n", *record, traceErr)
+	}
+	if vm.CurrentStats != nil {
+		fmt.Fprintln(os.Stderr)
+		vm.CurrentStats.Write(os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error executing file %s: %v\n\t", filename, err)
+		saveCrashReport(err)
+		return 1
+	}
+	return 0
+}
+
+// replCommand implements `squ1dcc repl [flags]`.
+func replCommand(args []string) int {
+	fs := newFlagSet("repl", "repl [flags]",
+		"Starts an interactive session. Each line is compiled and run as it is entered.")
+	apply := sessionFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	if !apply() {
+		return 1
+	}
+	return startRepl()
+}
+
+func startRepl() int {
+	defer object.CloseAllSessions()
+	name := "there"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	fmt.Printf("Hello %s! This is the SQU1D++ SQU1DLang compiler, version %s written by Quan Thai.\n", name, builder.CompilerVersion)
+	fmt.Printf("Available classes: %s\n\n", object.ListDefinedClasses())
+	repl.Start(os.Stdin, os.Stdout)
+	return 0
+}
+
+// buildCommand implements `squ1dcc build [flags] file.sqd`.
+func buildCommand(args []string) int {
+	fs := newFlagSet("build", "build [flags] file.sqd",
+		"Compiles file.sqd, with the files it includes, into a standalone executable.")
+	output := fs.String("o", "", "Output executable name (default: the input file without .sqd)")
+	force := fs.Bool("force", false, "Rebuild even if the cached output is up to date")
+	runtimeBinary := fs.String("runtime", "", "Prebuilt runtime binary to embed the program into (default: this binary)")
+	target := fs.String("target", "", "Platform to build for, as os/arch like linux/arm64 (default: this one)")
+	bytecodeOnly := fs.Bool("c", false, "Write just the compiled program, a .byc file for squ1dcc run, instead of an executable")
+	_, applyStrict := strictFlag(fs, "Make names that functions use but the program never defines compile errors (default true)")
+	level := optimizationFlags(fs)
+	verbosity := verbosityFlags(fs)
+	files, ok := parseFiles(fs, args)
+	if !ok {
+		return 2
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	if len(loadedPlugins) > 0 {
+		// The built executable runs without the plugins, so the
+		// program's builtin indexes wouldn't match its runtime's.
+		fmt.Fprintf(os.Stderr, "Error: build can't be used with plugins loaded\n")
+		return 1
+	}
+	builder.SetVerbosity(verbosity())
+	builder.SetForceRebuild(*force)
+	builder.SetOptimizationLevel(level())
+	builder.SetRuntimeBinary(*runtimeBinary)
+	if err := builder.SetTarget(*target); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	applyStrict()
+
+	inputFile := files[0]
+	outputFile := *output
+	if outputFile == "" {
+		outputFile = strings.TrimSuffix(inputFile, ".sqd")
+		if *bytecodeOnly {
+			outputFile += ".byc"
+		}
+	}
+	build := builder.BuildBytecode
+	if !*bytecodeOnly {
+		outputFile = builder.ExecutableName(outputFile)
+		build = builder.BuildStandalone
+	}
+
+	if err := build(inputFile, outputFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error compiling %s: %v\n", inputFile, err)
+		return 1
+	}
+	fmt.Printf("Successfully compiled %s to %s\n", inputFile, outputFile)
+	return 0
+}
+
+// checkCommand implements `squ1dcc check [flags] files...`.
+func checkCommand(args []string) int {
+	fs := newFlagSet("check", "check [flags] file.sqd ...",
+		"Compiles each file the way build would, includes and all, without running\nit or writing anything, and reports the errors.")
+	_, applyStrict := strictFlag(fs, "Make names that functions use but the program never defines compile errors (default true)")
+	level := optimizationFlags(fs)
+	verbosity := verbosityFlags(fs)
+	files, ok := parseFiles(fs, args)
+	if !ok {
+		return 2
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		return 2
+	}
+	builder.SetVerbosity(verbosity())
+	builder.SetOptimizationLevel(level())
+	applyStrict()
+
+	status := 0
+	for _, inputFile := range files {
+		if err := builder.Check(inputFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", inputFile, err)
+			status = 1
+		}
+	}
+	return status
+}
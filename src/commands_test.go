@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"squ1d++/vm"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+		rest     []string
+	}{
+		{nil, "repl", nil},
+		{[]string{"run", "main.sqd"}, "run", []string{"main.sqd"}},
+		{[]string{"build", "-o", "app", "main.sqd"}, "build", []string{"-o", "app", "main.sqd"}},
+		{[]string{"-h"}, "help", nil},
+		{[]string{"--help"}, "help", nil},
+		{[]string{"main.sqd"}, "", []string{"main.sqd"}},
+		{[]string{"-strict"}, "", []string{"-strict"}},
+		{[]string{"-B", "main.sqd"}, "build", []string{"main.sqd"}},
+		{[]string{"--B", "main.sqd"}, "build", []string{"main.sqd"}},
+		{[]string{"-O2", "-B", "main.sqd"}, "build", []string{"-O2", "main.sqd"}},
+		{[]string{"-o", "app", "-B", "main.sqd"}, "build", []string{"-o", "app", "main.sqd"}},
+		{[]string{"-check", "a.sqd", "b.sqd"}, "check", []string{"a.sqd", "b.sqd"}},
+		// A value that looks like a legacy flag isn't one.
+		{[]string{"-o", "-B", "main.sqd"}, "", []string{"-o", "-B", "main.sqd"}},
+		// Legacy flags after the file are left to run.
+		{[]string{"main.sqd", "-B"}, "", []string{"main.sqd", "-B"}},
+		{[]string{"-strict", "main.sqd", "-check"}, "", []string{"-strict", "main.sqd", "-check"}},
+		{[]string{"-", "-B"}, "", []string{"-", "-B"}},
+	}
+
+	for _, tt := range tests {
+		name, rest := route(tt.args)
+		if name != tt.expected || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("route(%q): expected %q %q, got %q %q", tt.args, tt.expected, tt.rest, name, rest)
+		}
+	}
+}
+
+func TestRoutedCommandsExist(t *testing.T) {
+	for _, name := range []string{"repl", "help", "build", "check", "run"} {
+		if _, ok := lookupCommand(name); !ok {
+			t.Errorf("expected a %s command", name)
+		}
+	}
+}
+
+func TestRunIgnoresArgumentsAfterTheFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.sqd")
+	if err := os.WriteFile(file, []byte("var x = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status := runCommand([]string{file, "extra.sqd", "--stats"}); status != 0 {
+		t.Errorf("expected the file to run, got status %d", status)
+	}
+	if vm.CurrentStats != nil {
+		t.Errorf("expected the flag after the file to be ignored")
+	}
+}
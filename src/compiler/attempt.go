@@ -0,0 +1,73 @@
+package compiler
+
+import (
+	"squ1d++/ast"
+	"squ1d++/code"
+)
+
+// compileAttempt compiles an attempt expression:
+//
+//	OpAttempt -> rescue;
+//	body or null;
+//	OpEndAttempt;
+//	OpJump -> end;
+//	[rescue:] set name or OpPop;
+//	rescue body or null;
+//	[end:]
+//
+// Runtime errors raised while the body runs, in it or in the functions it
+// calls, unwind to the rescue block. Error values reach it through the
+// OpCheckError the body's statements emit.
+func (c *Compiler) compileAttempt(node *ast.AttemptExpression) error {
+	attempt := c.emit(code.OpAttempt, 9999)
+
+	c.scopes[c.scopeIndex].attempts++
+	err := c.compileBlockValue(node.Body)
+	c.scopes[c.scopeIndex].attempts--
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpEndAttempt)
+	end := c.emit(code.OpJump, 9999)
+
+	c.patchJumps([]int{attempt})
+	if node.Name == nil {
+		c.emit(code.OpPop)
+	} else {
+		symbol := c.define(node.Name)
+		c.markDefined(symbol)
+		if symbol.Scope == GlobalScope {
+			c.setGlobal(symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+	}
+	if err := c.compileBlockValue(node.Rescue); err != nil {
+		return err
+	}
+
+	c.patchJumps([]int{end})
+	return nil
+}
+
+// checkError emits an OpCheckError inside attempt blocks, so an error value
+// a statement produces is raised.
+func (c *Compiler) checkError() {
+	if c.scopes[c.scopeIndex].attempts > 0 {
+		c.emit(code.OpCheckError)
+	}
+}
+
+// leaveAttempts ends the attempt blocks a break or continue jumps out of.
+func (c *Compiler) leaveAttempts() {
+	if len(c.loopContexts) == 0 {
+		return
+	}
+	loop := c.loopContexts[len(c.loopContexts)-1]
+	if loop.scopeIndex != c.scopeIndex {
+		return
+	}
+	for i := loop.attempts; i < c.scopes[c.scopeIndex].attempts; i++ {
+		c.emit(code.OpEndAttempt)
+	}
+}
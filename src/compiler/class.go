@@ -0,0 +1,70 @@
+package compiler
+
+import (
+	"fmt"
+	"squ1d++/ast"
+	"squ1d++/code"
+	"squ1d++/object"
+	"squ1d++/token"
+)
+
+// compileClass compiles a class definition:
+//
+//	name 0; method 0;
+//	...
+//	OpClass class name, n;
+//	set class name
+//
+// Each method is compiled as a function taking self before its parameters,
+// which a call through an instance passes.
+func (c *Compiler) compileClass(node *ast.ClassStatement) error {
+	symbol := c.define(node.Name)
+	c.markDefined(symbol)
+
+	seen := map[string]bool{}
+	for _, method := range node.Methods {
+		if seen[method.Name] {
+			return fmt.Errorf("line %d, column %d: method %s is defined more than once in class %s",
+				method.Token.Line+c.LineOffset, method.Token.Column, method.Name, node.Name.Value)
+		}
+		seen[method.Name] = true
+
+		self := &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: "self",
+			Line: method.Token.Line, Column: method.Token.Column}, Value: "self"}
+		fn := *method
+		fn.Name = node.Name.Value + "." + method.Name
+		fn.Parameters = append([]*ast.Identifier{self}, method.Parameters...)
+
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: method.Name}))
+		if err := c.Compile(&fn); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpClass, c.addConstant(&object.String{Value: node.Name.Value}), len(node.Methods))
+	if symbol.Scope == GlobalScope {
+		c.setGlobal(symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+	return nil
+}
+
+// compileFieldAssignment compiles `object.field = value`, which evaluates
+// to null so a top-level assignment prints nothing.
+func (c *Compiler) compileFieldAssignment(node *ast.InfixExpression, target *ast.DotExpression) error {
+	if err := c.Compile(target.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(target.Right); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.checkError()
+
+	c.markPosition(node.Token)
+	c.emit(code.OpSetField)
+	return nil
+}
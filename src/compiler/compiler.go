@@ -2,10 +2,13 @@ package compiler
 
 import (
 	"fmt"
+	"math"
 	"sort"
 	"squ1d++/ast"
 	"squ1d++/code"
 	"squ1d++/object"
+	"squ1d++/token"
+	"strings"
 )
 
 type LoopContext struct {
@@ -14,6 +17,8 @@ type LoopContext struct {
 	breakJumps      []int
 	continueJumps   []int
 	scopeIndex      int
+	// attempts is the number of attempt blocks open around the loop.
+	attempts int
 }
 
 type Compiler struct {
@@ -35,9 +40,52 @@ type Compiler struct {
 	// a compile-time error. This is used for `suppress` so suppressed
 	// statements don't abort compilation on undefined names.
 	allowDeferredUndefinedGlobals bool
-	// LineOffset is added to any line numbers reported in compile errors.
+	// LineOffset is added to any line numbers reported in compile errors
+	// and recorded in line tables.
 	// The file executor sets this so errors point to the correct file line.
 	LineOffset int
+	// Filename is recorded with the compiled code so runtime tools can
+	// tell which file a line belongs to.
+	Filename string
+	// Sources, when set, say which files the lines of a program assembled
+	// from several of them came from. Line tables then hold the lines of
+	// those files, and file tables the file of each stretch of code (see
+	// object.CompiledFunction).
+	Sources Sources
+	// optLevel selects the optimization passes (see SetOptimizationLevel).
+	optLevel int
+	// constantIndex maps deduplication keys to constant pool indexes for
+	// constants added by this compiler (OptBasic and above).
+	constantIndex map[string]int
+	// definedGlobals records the global slots given a value by a `var` or
+	// function definition, as opposed to deferred undefined references.
+	definedGlobals map[int]bool
+	// modules maps the key of each included module compiled so far to the
+	// global slot holding its namespace hash (see compileModule).
+	modules map[string]int
+	// warnings holds the warnings found so far (see Warnings).
+	warnings []Warning
+	// assigning is true while the target of an assignment is compiled.
+	assigning bool
+	// Strict makes a function's references to names the program never
+	// defines compile errors, rather than globals that hold an error at
+	// run time. Names are only known to be undefined once the whole
+	// program is compiled, so the check runs at the end of a Program.
+	Strict bool
+	// Echo ends each top-level statement of a Program with OpEcho, so a
+	// program compiled as a whole prints the values a file run one
+	// statement at a time would.
+	Echo bool
+	// deferred records the unknown names functions referenced, for Strict.
+	deferred []deferredName
+}
+
+// deferredName is a reference to a name not defined yet, auto-defined as a
+// global of table.
+type deferredName struct {
+	name  string
+	pos   object.Position
+	table *SymbolTable
 }
 
 type EmittedInstruction struct {
@@ -49,6 +97,24 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	// lines and positions are the line and position tables of the scope
+	// (see object.CompiledFunction).
+	lines     map[int][]int
+	positions map[int]object.Position
+	// files is the scope's file table (see object.CompiledFunction): start
+	// is the file its code starts in and file the one of the code marked
+	// last, once marked is set.
+	files  map[int]string
+	start  string
+	file   string
+	marked bool
+	// result is the return type annotation of the function being compiled,
+	// and resultSubject how messages about it name the function.
+	result        *ast.TypeAnnotation
+	resultSubject string
+	// attempts is the number of attempt blocks being compiled in the
+	// scope, whose statements check for error values.
+	attempts int
 }
 
 func New() *Compiler {
@@ -67,10 +133,9 @@ func New() *Compiler {
 	// Also define class objects (so code compiled with a fresh compiler can
 	// reference class names like `array.cat`). Use the same order as the VM
 	// / REPL expects.
-	classes := object.CreateClassObjects()
+	classes := object.ClassObjects()
 	builtinCount := len(object.Builtins)
-	classNames := []string{"io", "type", "time", "os", "math", "string", "file", "pkg", "array", "sys", "keyboard"}
-	for _, className := range classNames {
+	for _, className := range object.ClassNames {
 		if _, ok := classes[className]; ok {
 			symbolTable.DefineBuiltin(builtinCount, className)
 			builtinCount++
@@ -95,6 +160,7 @@ func (c *Compiler) enterLoop(loopStartPos int) {
 		breakJumps:      []int{},
 		continueJumps:   []int{},
 		scopeIndex:      c.scopeIndex,
+		attempts:        c.scopes[c.scopeIndex].attempts,
 	})
 }
 
@@ -163,33 +229,53 @@ func (c *Compiler) Compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
+			c.markLine(s)
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
+			if c.Echo {
+				c.emit(code.OpEcho)
+			}
+		}
+		if c.Strict {
+			return c.checkDeferred()
 		}
 
 	case *ast.ExpressionStatement:
+		if body, namespace, key, ok := inlineModule(node); ok {
+			return c.compileModule(body, namespace, key)
+		}
 		err := c.Compile(node.Expression)
 		if err != nil {
 			return err
 		}
+		c.checkError()
 		c.emit(code.OpPop)
 
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
+			c.markLine(s)
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
+			if c.optLevel >= OptFull && endsControlFlow(s) {
+				break
+			}
 		}
 
 	case *ast.PrefixExpression:
+		if c.tryFold(node) {
+			return nil
+		}
+
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
 		}
 
+		c.markPosition(node.Token)
 		switch node.Operator {
 		case "!":
 			c.emit(code.OpBang)
@@ -207,7 +293,17 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("line %d, column %d: Unknown operator: %s", node.Token.Line, node.Token.Column, node.Operator)
 		}
 
+	case *ast.AwaitExpression:
+		return c.compileBuiltinCall("await", node.Token, node.Value)
+
+	case *ast.SpawnExpression:
+		return c.compileBuiltinCall("spawn", node.Token, node.Function)
+
 	case *ast.InfixExpression:
+		if c.tryFold(node) {
+			return nil
+		}
+
 		if node.Operator == "<" {
 			err := c.Compile(node.Right)
 			if err != nil {
@@ -219,6 +315,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
+			c.markPosition(node.Token)
 			c.emit(code.OpGreaterThan)
 			return nil
 		}
@@ -234,6 +331,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
+			c.markPosition(node.Token)
 			c.emit(code.OpGreaterThan)
 			c.emit(code.OpBang)
 			return nil
@@ -250,12 +348,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
+			c.markPosition(node.Token)
 			c.emit(code.OpGreaterThan)
 			c.emit(code.OpBang)
 			return nil
 		}
 
+		if node.Operator == "and" || node.Operator == "or" {
+			return c.compileLogical(node)
+		}
+
+		if target, ok := node.Left.(*ast.DotExpression); ok && node.Operator == "=" {
+			return c.compileFieldAssignment(node, target)
+		}
+
+		// The target of an assignment is compiled like any identifier, but
+		// isn't a read.
+		_, isIdent := node.Left.(*ast.Identifier)
+		c.assigning = isIdent && node.Operator == "="
 		err := c.Compile(node.Left)
+		c.assigning = false
 		if err != nil {
 			return err
 		}
@@ -265,6 +377,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		c.markPosition(node.Token)
 		switch node.Operator {
 		case "+":
 			c.emit(code.OpAdd)
@@ -282,10 +395,6 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
-		case "and":
-			c.emit(code.OpAnd)
-		case "or":
-			c.emit(code.OpOr)
 		case "=":
 			// Handle assignment
 			if ident, ok := node.Left.(*ast.Identifier); ok {
@@ -293,10 +402,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 				if !ok {
 					return fmt.Errorf("line %d, column %d: Undefined variable %s", ident.Token.Line, ident.Token.Column, ident.Value)
 				}
-
-				if symbol.Scope == GlobalScope {
-					c.emit(code.OpSetGlobal, symbol.Index)
-				} else {
+				want := c.symbolTable.typeOf(ident.Value).name
+				if err := c.checkType(want, node.Right, ident.Value, ident.Token); err != nil {
+					return err
+				}
+				c.symbolTable.assigned(ident.Value, node.Right)
+				c.checkError()
+
+				switch symbol.Scope {
+				case GlobalScope:
+					c.setGlobal(symbol.Index)
+				case FreeScope:
+					c.emit(code.OpSetFree, symbol.Index)
+				default:
 					c.emit(code.OpSetLocal, symbol.Index)
 				}
 			} else {
@@ -307,6 +425,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.IfExpression:
+		if truthy, ok := c.constantCondition(node.Condition); ok {
+			return c.compileConstantIf(node, truthy)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -343,6 +465,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
+	case *ast.AttemptExpression:
+		return c.compileAttempt(node)
+
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+
 	case *ast.WhileExpression:
 		loopStart := len(c.currentInstructions())
 		c.enterLoop(loopStart)
@@ -373,6 +501,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpNull)
 
 	case *ast.WhileStatement:
+		if truthy, ok := c.constantCondition(node.Condition); ok && !truthy {
+			return nil
+		}
+
 		loopStart := len(c.currentInstructions())
 		c.enterLoop(loopStart)
 
@@ -402,11 +534,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// (WhileExpression handles the value-producing case)
 
 	case *ast.LetStatement:
-		symbol := c.symbolTable.Define(node.Name.Value)
+		if err := c.checkAnnotation(node.Name.Type); err != nil {
+			return err
+		}
+		symbol := c.define(node.Name)
+		c.markDefined(symbol)
+		c.symbolTable.assigned(node.Name.Value, node.Value)
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
+		err = c.checkType(annotationName(node.Name.Type), node.Value, node.Name.Value, node.Name.Token)
+		if err != nil {
+			return err
+		}
 
 		// Special handling for error pipe (<<) and unblock semantics
 		if node.ErrorPipe {
@@ -417,7 +558,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 			// True branch: value is an Error -> assign it directly
 			if symbol.Scope == GlobalScope {
-				c.emit(code.OpSetGlobal, symbol.Index)
+				c.setGlobal(symbol.Index)
 			} else {
 				c.emit(code.OpSetLocal, symbol.Index)
 			}
@@ -431,7 +572,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpPop)
 			c.emit(code.OpNull)
 			if symbol.Scope == GlobalScope {
-				c.emit(code.OpSetGlobal, symbol.Index)
+				c.setGlobal(symbol.Index)
 			} else {
 				c.emit(code.OpSetLocal, symbol.Index)
 			}
@@ -450,7 +591,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpPop)
 			c.emit(code.OpNull)
 			if symbol.Scope == GlobalScope {
-				c.emit(code.OpSetGlobal, symbol.Index)
+				c.setGlobal(symbol.Index)
 			} else {
 				c.emit(code.OpSetLocal, symbol.Index)
 			}
@@ -461,7 +602,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			afterTruePos := len(c.currentInstructions())
 			c.changeOperand(jumpNotErrPos, afterTruePos)
 			if symbol.Scope == GlobalScope {
-				c.emit(code.OpSetGlobal, symbol.Index)
+				c.setGlobal(symbol.Index)
 			} else {
 				c.emit(code.OpSetLocal, symbol.Index)
 			}
@@ -472,12 +613,31 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		// Default behavior: assign the evaluated value
+		c.checkError()
 		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
+			c.setGlobal(symbol.Index)
 		} else {
 			c.emit(code.OpSetLocal, symbol.Index)
 		}
 
+	case *ast.DestructureStatement:
+		// The value is compiled before the names are defined, so
+		// var (a, b) = (b, a) swaps.
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpUnpack, len(node.Names))
+		for _, name := range node.Names {
+			symbol := c.define(name)
+			c.markDefined(symbol)
+			if symbol.Scope == GlobalScope {
+				c.setGlobal(symbol.Index)
+			} else {
+				c.emit(code.OpSetLocal, symbol.Index)
+			}
+		}
+
 	case *ast.SuppressStatement:
 		// Suppress supports wrapping either an expression or a statement.
 		if node.Statement != nil {
@@ -504,7 +664,18 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpSuppress)
 
+	case *ast.BenchStatement:
+		// Benchmarks only run under `squ1dcc bench`.
+		return nil
+
+	case *ast.ClassStatement:
+		return c.compileClass(node)
+
+	case *ast.ExportStatement:
+		return c.compileExport(node)
+
 	case *ast.BreakStatement:
+		c.leaveAttempts()
 		jumpPos := c.emit(code.OpJump, 9999)
 		err := c.addBreakJump(jumpPos)
 		if err != nil {
@@ -512,12 +683,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.ContinueStatement:
+		c.leaveAttempts()
 		jumpPos := c.emit(code.OpJump, 9999)
 		err := c.addContinueJump(jumpPos)
 		if err != nil {
 			return err
 		}
 
+	case *ast.ForInStatement:
+		return c.compileForIn(node)
+
 	case *ast.ForStatement:
 		// For loops are compiled as:
 		//   init;
@@ -540,6 +715,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
+		if loop, ok := c.countedLoop(node); ok {
+			return c.compileCountedLoop(node, loop)
+		}
+
 		// Emit jump to bypass update on first iteration (patched later)
 		bypassUpdatePos := c.emit(code.OpJump, 9999)
 
@@ -598,34 +777,95 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// ForStatement is a statement form — no value pushed on stack
 
 	case *ast.FunctionLiteral:
+		if node.Async {
+			// async def ... is async(def ...).
+			fn := *node
+			fn.Async = false
+			return c.compileBuiltinCall("async", node.Token, &fn)
+		}
+
 		c.enterScope()
 
 		if node.Name != "" {
 			c.symbolTable.DefineFunctionName(node.Name)
+			c.symbolTable.setType(node.Name, declaredType{name: "func", fn: node})
 		}
 
+		subject := object.FunctionSubject(node.Name)
 		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
+			if err := c.checkAnnotation(p.Type); err != nil {
+				return err
+			}
+			symbol := c.define(p)
+			if want := annotationName(p.Type); want != "" && want != "any" {
+				// The argument is checked in place: OpSetLocal puts it back.
+				c.emit(code.OpGetLocal, symbol.Index)
+				if err := c.checkType(want, nil, "Argument "+p.Value+" to "+subject, p.Token); err != nil {
+					return err
+				}
+				c.emit(code.OpSetLocal, symbol.Index)
+			}
+		}
+
+		if err := c.checkAnnotation(node.ReturnType); err != nil {
+			return err
 		}
+		result := annotationName(node.ReturnType)
+		c.scopes[c.scopeIndex].result = node.ReturnType
+		c.scopes[c.scopeIndex].resultSubject = "The result of " + subject
 
 		err := c.Compile(node.Body)
 		if err != nil {
 			return err
 		}
+		c.warnUnread(node.Parameters)
 
 		if c.lastInstructionIs(code.OpPop) {
-			c.replaceLastPopWithReturn()
+			if result == "" {
+				c.replaceLastPopWithReturn()
+			} else {
+				// The value of the last expression is returned, so it is
+				// checked first.
+				c.removeLastPop()
+				var last ast.Expression
+				if n := len(node.Body.Statements); n > 0 {
+					if es, ok := node.Body.Statements[n-1].(*ast.ExpressionStatement); ok {
+						last = es.Expression
+					}
+				}
+				if err := c.checkType(result, last, "The result of "+subject, node.ReturnType.Token); err != nil {
+					return err
+				}
+				c.emit(code.OpReturnValue)
+			}
 		}
 		if !c.lastInstructionIs(code.OpReturnValue) {
-			c.emit(code.OpReturn)
+			if result == "" {
+				c.emit(code.OpReturn)
+			} else {
+				c.emit(code.OpNull)
+				if err := c.checkType(result, nil, "The result of "+subject, node.ReturnType.Token); err != nil {
+					return err
+				}
+				c.emit(code.OpReturnValue)
+			}
 		}
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.LocalNames()
+		lines := c.scopes[c.scopeIndex].lines
+		positions := c.scopes[c.scopeIndex].positions
+		filename, files := c.scopes[c.scopeIndex].fileTable(c.Filename)
 		instructions := c.leaveScope()
+		if c.optLevel >= OptFull {
+			instructions, lines, positions, files = peephole(instructions, c.constants, lines, positions, files)
+		}
 
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			c.captureSymbol(s)
+			freeNames[i] = s.Name
 		}
 
 		compiledFn := &object.CompiledFunction{
@@ -633,6 +873,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
 			Name:          node.Name,
+			Lines:         lines,
+			Positions:     positions,
+			Filename:      filename,
+			Files:         files,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
@@ -642,8 +888,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// `block` wrapping a LET needs special handling so we can check the
 		// RHS for an Error and abort immediately if so.
 		if ls, ok := node.Statement.(*ast.LetStatement); ok {
+			if err := c.checkAnnotation(ls.Name.Type); err != nil {
+				return err
+			}
 			// Define symbol as usual
-			symbol := c.symbolTable.Define(ls.Name.Value)
+			symbol := c.define(ls.Name)
+			c.symbolTable.assigned(ls.Name.Value, ls.Value)
 
 			// Compile the RHS expression. Snapshot any existing undefined globals
 			// so we only consider undefined identifiers that were recorded by
@@ -657,6 +907,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
+			err = c.checkType(annotationName(ls.Name.Type), ls.Value, ls.Name.Value, ls.Name.Token)
+			if err != nil {
+				return err
+			}
 
 			// If compilation recorded any newly-discovered undefined globals on
 			// the same line as this statement, treat that as an immediate error
@@ -687,7 +941,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			afterTruePos := len(c.currentInstructions())
 			c.changeOperand(jumpNotErrPos, afterTruePos)
 			if symbol.Scope == GlobalScope {
-				c.emit(code.OpSetGlobal, symbol.Index)
+				c.setGlobal(symbol.Index)
 			} else {
 				c.emit(code.OpSetLocal, symbol.Index)
 			}
@@ -712,11 +966,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		scope := c.scopes[c.scopeIndex]
+		err = c.checkType(annotationName(scope.result), node.ReturnValue, scope.resultSubject, node.Token)
+		if err != nil {
+			return err
+		}
 
 		c.emit(code.OpReturnValue)
 
 	case *ast.CallExpression:
-		err := c.Compile(node.Function)
+		err := c.checkArguments(node)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Function)
 		if err != nil {
 			return err
 		}
@@ -746,6 +1009,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			argumentCount++
 		}
 
+		c.markPosition(node.Token)
 		c.emit(code.OpCall, argumentCount)
 
 	case *ast.IntegerLiteral:
@@ -784,16 +1048,30 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpArray, len(node.Elements))
 
-	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
+	case *ast.SetLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSet, len(node.Elements))
+
+	case *ast.TupleLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
 		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
 
-		for _, k := range keys {
+		c.emit(code.OpTuple, len(node.Elements))
+
+	case *ast.HashLiteral:
+		// Keys are compiled in source order, which is the order the hash
+		// keeps them in.
+		for _, k := range node.Keys {
 			err := c.Compile(k)
 			if err != nil {
 				return err
@@ -817,8 +1095,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		c.markPosition(node.Token)
 		c.emit(code.OpIndex)
 
+	case *ast.SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+
+		c.markPosition(node.Token)
+		c.emit(code.OpSlice)
+
 	case *ast.DotExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -830,6 +1126,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		c.markPosition(node.Token)
 		c.emit(code.OpDot)
 
 	case *ast.Identifier:
@@ -847,6 +1144,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 				}
 				symbol = top.Define(node.Value)
 				ok = true
+				if !c.allowDeferredUndefinedGlobals {
+					c.deferred = append(c.deferred, deferredName{
+						name:  node.Value,
+						pos:   object.Position{Line: node.Token.Line + c.LineOffset, Column: node.Token.Column},
+						table: top,
+					})
+				}
 
 				if c.undefinedGlobals == nil {
 					c.undefinedGlobals = map[int]*object.Error{}
@@ -873,6 +1177,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
+		if !c.assigning {
+			c.symbolTable.MarkRead(node.Value)
+		}
 		c.loadSymbol(symbol)
 
 	}
@@ -881,10 +1188,40 @@ func (c *Compiler) Compile(node ast.Node) error {
 }
 
 func (c *Compiler) addConstant(obj object.Object) int {
+	if c.optLevel >= OptBasic {
+		if key, ok := constantKey(obj); ok {
+			if idx, found := c.constantIndex[key]; found {
+				return idx
+			}
+			c.constants = append(c.constants, obj)
+			if c.constantIndex == nil {
+				c.constantIndex = map[string]int{}
+			}
+			c.constantIndex[key] = len(c.constants) - 1
+			return len(c.constants) - 1
+		}
+	}
+
 	c.constants = append(c.constants, obj)
 	return len(c.constants) - 1
 }
 
+// setGlobal and getGlobal emit the instruction for global slot index,
+// switching to the wide form for slots a 2-byte operand can't hold.
+func (c *Compiler) setGlobal(index int) int {
+	if index > math.MaxUint16 {
+		return c.emit(code.OpSetGlobalWide, index)
+	}
+	return c.emit(code.OpSetGlobal, index)
+}
+
+func (c *Compiler) getGlobal(index int) int {
+	if index > math.MaxUint16 {
+		return c.emit(code.OpGetGlobalWide, index)
+	}
+	return c.emit(code.OpGetGlobal, index)
+}
+
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
@@ -975,10 +1312,62 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+// compileBuiltinCall compiles a call of the builtin called name with arg,
+// for keywords implemented by builtins.
+func (c *Compiler) compileBuiltinCall(name string, tok token.Token, arg ast.Expression) error {
+	index := -1
+	for i, def := range object.Builtins {
+		if def.Name == name {
+			index = i
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("line %d, column %d: Unknown builtin %s", tok.Line+c.LineOffset, tok.Column, name)
+	}
+
+	c.emit(code.OpGetBuiltin, index)
+	if err := c.Compile(arg); err != nil {
+		return err
+	}
+	c.markPosition(tok)
+	c.emit(code.OpCall, 1)
+	return nil
+}
+
+// compileLogical compiles `and` and `or` so the right operand only runs when
+// the left one doesn't decide the result:
+//
+//	left; OpAnd -> end; right; OpAnd -> end; OpTrue; [end:]
+//
+// and the same with OpOr and OpFalse for `or`. Either way the result is a
+// boolean.
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	op, otherwise := code.OpAnd, code.OpTrue
+	if node.Operator == "or" {
+		op, otherwise = code.OpOr, code.OpFalse
+	}
+
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	c.markPosition(node.Token)
+	leftJump := c.emit(op, 9999)
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.markPosition(node.Token)
+	rightJump := c.emit(op, 9999)
+	c.emit(otherwise)
+
+	c.patchJumps([]int{leftJump, rightJump})
+	return nil
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
-		c.emit(code.OpGetGlobal, s.Index)
+		c.getGlobal(s.Index)
 	case LocalScope:
 		c.emit(code.OpGetLocal, s.Index)
 	case BuiltinScope:
@@ -990,11 +1379,168 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
+// captureSymbol pushes what a closure keeps of the variable s it captures:
+// the cell of a local or of a variable the enclosing closure captured, so
+// assignments are shared, and the value of anything else.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
 func (c *Compiler) Bytecode() *Bytecode {
+	if c.optLevel >= OptFull {
+		c.Optimize()
+	}
+
+	filename, files := c.scopes[c.scopeIndex].fileTable(c.Filename)
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Filename:     filename,
+		Files:        files,
+		Globals:      c.symbolTable.NumGlobals(),
+		Undefined:    c.undefinedGlobals,
+		GlobalNames:  c.globalNames(),
+	}
+}
+
+// globalNames returns the names of the program's globals by slot.
+func (c *Compiler) globalNames() map[int]string {
+	names := map[int]string{}
+	for _, sym := range c.symbolTable.GlobalSymbols() {
+		names[sym.Index] = sym.Name
+	}
+	return names
+}
+
+// markLine records that stmt, about to be compiled, starts at the current
+// instruction.
+func (c *Compiler) markLine(stmt ast.Statement) {
+	line := ast.StatementLine(stmt)
+	if line == 0 {
+		return
+	}
+	file, line := c.source(line + c.LineOffset)
+
+	scope := &c.scopes[c.scopeIndex]
+	if scope.lines == nil {
+		scope.lines = map[int][]int{}
+	}
+	pos := len(scope.instructions)
+	scope.markFile(pos, file)
+	if marked := scope.lines[pos]; len(marked) > 0 && marked[len(marked)-1] == line {
+		return
+	}
+	scope.lines[pos] = append(scope.lines[pos], line)
+}
+
+// markPosition records that the instruction about to be emitted was
+// compiled from the expression at tok, so runtime errors can point at it.
+func (c *Compiler) markPosition(tok token.Token) {
+	if tok.Line == 0 {
+		return
+	}
+
+	file, line := c.source(tok.Line + c.LineOffset)
+
+	scope := &c.scopes[c.scopeIndex]
+	if scope.positions == nil {
+		scope.positions = map[int]object.Position{}
+	}
+	pos := len(scope.instructions)
+	scope.markFile(pos, file)
+	scope.positions[pos] = object.Position{Line: line, Column: tok.Column}
+}
+
+// Segment is a run of lines of a program assembled from several files:
+// line Start of the program, and each line after it up to the next
+// segment, is a line of File counting on from Line.
+type Segment struct {
+	Start int
+	File  string
+	Line  int
+}
+
+// Sources are the segments of a program, in order of Start.
+type Sources []Segment
+
+// Source returns the file and line that line of the program came from, or
+// ok false for a line before the first segment.
+func (s Sources) Source(line int) (file string, fileLine int, ok bool) {
+	i := sort.Search(len(s), func(i int) bool { return s[i].Start > line }) - 1
+	if i < 0 {
+		return "", line, false
+	}
+	return s[i].File, s[i].Line + line - s[i].Start, true
+}
+
+// Locate turns a compile or parse error message of the form "line L,
+// column C: message" about the program into one naming the file and line
+// it is about. Other messages are returned as they are.
+func (s Sources) Locate(message string) string {
+	var line, column int
+	if n, _ := fmt.Sscanf(message, "line %d, column %d:", &line, &column); n != 2 {
+		return message
 	}
+	file, line, ok := s.Source(line)
+	if !ok || file == "" {
+		return message
+	}
+	return fmt.Sprintf("%s, line %d, column %d:%s", file, line, column, message[strings.Index(message, ":")+1:])
+}
+
+// source returns the file and line that line of the program came from.
+func (c *Compiler) source(line int) (string, int) {
+	if file, fileLine, ok := c.Sources.Source(line); ok {
+		return file, fileLine
+	}
+	return c.Filename, line
+}
+
+// markFile records that the code at pos comes from file.
+func (s *CompilationScope) markFile(pos int, file string) {
+	switch {
+	case !s.marked:
+		s.marked, s.start, s.file = true, file, file
+	case file != s.file:
+		if s.files == nil {
+			s.files = map[int]string{}
+		}
+		s.file = file
+		s.files[pos] = file
+	}
+}
+
+// fileTable returns the file the scope's code starts in, or filename if no
+// code was marked, and the files it moves on to.
+func (s *CompilationScope) fileTable(filename string) (string, map[int]string) {
+	if !s.marked {
+		return filename, nil
+	}
+	return s.start, s.files
+}
+
+// checkDeferred returns an error for the first name a function referenced
+// that the program never went on to define.
+func (c *Compiler) checkDeferred() error {
+	for _, ref := range c.deferred {
+		if _, ok := ref.table.DefinedAt(ref.name); ok {
+			continue
+		}
+		if sym, ok := ref.table.store[ref.name]; ok && c.definedGlobals[sym.Index] {
+			continue
+		}
+		return fmt.Errorf("line %d, column %d: Undefined variable %s", ref.pos.Line, ref.pos.Column, ref.name)
+	}
+	return nil
 }
 
 // UndefinedGlobals returns the map of global index -> *object.Error for
@@ -1008,4 +1554,18 @@ func (c *Compiler) UndefinedGlobals() map[int]*object.Error {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	// Lines, Positions, Filename and Files describe the main program like
+	// the fields of the same name in object.CompiledFunction.
+	Lines     map[int][]int
+	Positions map[int]object.Position
+	Filename  string
+	Files     map[int]string
+	// Globals is the number of global slots the program defines, which the
+	// machine running it makes room for.
+	Globals int
+	// Undefined holds the errors for the globals the program uses but
+	// never defines, by slot (see UndefinedGlobals), and GlobalNames the
+	// names of its globals.
+	Undefined   map[int]*object.Error
+	GlobalNames map[int]string
 }
@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"sort"
 	"squ1d++/ast"
 	"squ1d++/code"
 	"squ1d++/lexer"
@@ -215,6 +216,42 @@ func TestBooleanExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "true and false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpAnd, 9),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpAnd, 9),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "false or true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpFalse),
+				// 0001
+				code.Make(code.OpOr, 9),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpOr, 9),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -288,6 +325,56 @@ func TestArrayLiterals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestSetLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "{1, 2 + 3}",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSet, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTupleLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "(1, 2 + 3)",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpTuple, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "var (a, b) = (1, 2);",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpTuple, 2),
+				code.Make(code.OpUnpack, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestHashLiterals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -328,6 +415,19 @@ func TestHashLiterals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// Keys stay in source order.
+			input:             `{"b": 1, "a": 2}`,
+			expectedConstants: []interface{}{"b", 1, "a", 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -364,6 +464,18 @@ func TestIndexExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "[1][:1]",
+			expectedConstants: []interface{}{1, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -639,7 +751,7 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -669,13 +781,13 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -723,15 +835,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -748,6 +860,42 @@ func TestClosures(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestCapturedAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			def() {
+				var a = 1;
+				def() { a = 2 }
+			}
+			`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestBuiltins(t *testing.T) {
 	// Tests for builtins now use class-qualified names (dot notation). We
 	// only verify that the compiler can compile these inputs without error
@@ -1188,3 +1336,661 @@ func TestCompilerScopes(t *testing.T) {
 			code.OpMul, previous.Opcode)
 	}
 }
+
+func TestLineTables(t *testing.T) {
+	input := "var x = 1\nf >> () {\n    x\n    return 2\n}\nf()"
+
+	for _, level := range []int{OptNone, OptFull} {
+		comp := New()
+		comp.SetOptimizationLevel(level)
+		comp.Filename = "main.sqd"
+		comp.LineOffset = 10
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+
+		if bytecode.Filename != "main.sqd" {
+			t.Errorf("-O%d: wrong filename. got=%q", level, bytecode.Filename)
+		}
+		if got := sortedLines(bytecode.Lines); fmt.Sprint(got) != "[11 12 16]" {
+			t.Errorf("-O%d: wrong program lines. got=%v", level, got)
+		}
+		if bytecode.Lines[0][0] != 11 {
+			t.Errorf("-O%d: expected line 11 at offset 0, got %v", level, bytecode.Lines[0])
+		}
+
+		var fn *object.CompiledFunction
+		for _, c := range bytecode.Constants {
+			if f, ok := c.(*object.CompiledFunction); ok {
+				fn = f
+			}
+		}
+		if fn == nil {
+			t.Fatalf("-O%d: no compiled function in constants", level)
+		}
+		if got := sortedLines(fn.Lines); fmt.Sprint(got) != "[13 14]" {
+			t.Errorf("-O%d: wrong function lines. got=%v", level, got)
+		}
+		if fn.Filename != "main.sqd" {
+			t.Errorf("-O%d: wrong function filename. got=%q", level, fn.Filename)
+		}
+	}
+}
+
+func TestVariableNames(t *testing.T) {
+	input := "def(a, b) { var c = a + b; def() { c } }"
+
+	comp := New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var outer, inner *object.CompiledFunction
+	for _, c := range comp.Bytecode().Constants {
+		if f, ok := c.(*object.CompiledFunction); ok {
+			if f.NumParameters == 2 {
+				outer = f
+			} else {
+				inner = f
+			}
+		}
+	}
+	if outer == nil || inner == nil {
+		t.Fatalf("expected two compiled functions")
+	}
+	if fmt.Sprint(outer.LocalNames) != "[a b c]" || len(outer.FreeNames) != 0 {
+		t.Errorf("wrong outer names. locals=%v free=%v", outer.LocalNames, outer.FreeNames)
+	}
+	if len(inner.LocalNames) != 0 || fmt.Sprint(inner.FreeNames) != "[c]" {
+		t.Errorf("wrong inner names. locals=%v free=%v", inner.LocalNames, inner.FreeNames)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "var a = [1, 2]\nwhile (false) { }\nio.echo(a[0] + 1)"
+
+	for _, level := range []int{OptNone, OptFull} {
+		comp := New()
+		comp.SetOptimizationLevel(level)
+		comp.LineOffset = 10
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+
+		got := map[string]object.Position{}
+		for offset, pos := range bytecode.Positions {
+			def, err := code.Lookup(bytecode.Instructions[offset])
+			if err != nil {
+				t.Fatalf("position at offset %d is not an instruction", offset)
+			}
+			got[def.Name] = pos
+		}
+		expected := map[string]object.Position{
+			"OpIndex": {Line: 13, Column: 10},
+			"OpAdd":   {Line: 13, Column: 14},
+			"OpCall":  {Line: 13, Column: 8},
+			"OpDot":   {Line: 13, Column: 3},
+		}
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("level %d: wrong positions.\nwant=%v\ngot= %v", level, expected, got)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"var total = 0; var f = def(x) { var total = x; total }",
+			[]string{"line 1, column 37: warning: total shadows the global defined at line 1"},
+		},
+		{
+			"def(a, b) { var c = a; var d = 1; var _e = 2; def() { c } }",
+			[]string{"line 1, column 28: warning: d is defined but never used"},
+		},
+		{
+			"var n = 1; def(n) { n }",
+			[]string{"line 1, column 16: warning: n shadows the global defined at line 1"},
+		},
+		{
+			"def() { var x = 1; x = 2 }",
+			[]string{"line 1, column 13: warning: x is defined but never used"},
+		},
+		{
+			"var n = 1; def(_n) { var m = n; m }",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		var got []string
+		for _, w := range comp.Warnings() {
+			got = append(got, w.String())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("%q: wrong warnings.\nwant=%q\ngot= %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var f = def() { g() }; var g = def() { 1 }", ""},
+		{"var f = def() { missing + 1 }", "line 1, column 17: Undefined variable missing"},
+		{"var f = def() { def() { tyop } }; var typo = 1", "line 1, column 25: Undefined variable tyop"},
+		{"suppress var x = later; var later = 1", ""},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("%q: compiler error without Strict: %s", tt.input, err)
+		}
+
+		comp = New()
+		comp.Strict = true
+		err := comp.Compile(parse(tt.input))
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func sortedLines(table map[int][]int) []int {
+	var lines []int
+	for _, marked := range table {
+		lines = append(lines, marked...)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var x: int = 5; var y: float = 1.5; x = 0x1F", ""},
+		{"var f = def(a: int, b: string): bool { true }; var ok: bool = f(1, \"b\")", ""},
+		{"var x: any = 1; x = \"a\"", ""},
+		{`var x: int = "a"`, "line 1, column 5: x must be int, got string"},
+		{"var x: string = \"a\"\nx = 2", "line 2, column 1: x must be string, got int"},
+		{`var f = def(a: int) { a }; f("a")`, "line 1, column 29: Argument a to f must be int, got string"},
+		{"var f = def(): string { 1 }", "line 1, column 16: The result of f must be string, got int"},
+		{"var f = def(): int { return [] }", "line 1, column 22: The result of f must be int, got array"},
+		{"var f = def(): int { 1 }; var s: string = f()", "line 1, column 31: s must be string, got int"},
+		{"var x: number = 1", "line 1, column 8: Unknown type number"},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		err := comp.Compile(parse(tt.input))
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestCountedLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "for (var i = 0; i < 10; i = i + 1) { i }",
+			expectedConstants: []interface{}{0, 10, 10, 1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGreaterThan),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 31),
+				// 0016
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpConstant, 2),
+				// 0023
+				code.Make(code.OpLoopGlobal, 0, 3, code.LoopLess, 16),
+			},
+		},
+		{
+			input: "def(n) { for (var i = n; i >= 0; i = i + -2) { } }",
+			expectedConstants: []interface{}{0, 0, -2, []code.Instructions{
+				// 0000
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpSetLocal, 1),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpBang),
+				// 0011
+				code.Make(code.OpJumpNotTruthy, 24),
+				// 0014
+				code.Make(code.OpConstant, 1),
+				// 0017
+				code.Make(code.OpLoopLocal, 1, 2, code.LoopGreaterEqual, 14),
+				// 0024
+				code.Make(code.OpReturn),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatch(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `match (1) { case 1, 2 { 10 } el { 20 } }`,
+			// The second constant is the table of patterns, checked below.
+			expectedConstants: []interface{}{1, nil, 10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpMatch, 1, 1),
+				// 0008
+				code.Make(code.OpJump, 14),
+				// 0011
+				code.Make(code.OpJump, 20),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
+				code.Make(code.OpJump, 23),
+				// 0020
+				code.Make(code.OpConstant, 3),
+				// 0023
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `match (1) { case "a" { } }`,
+			expectedConstants: []interface{}{1, nil},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpMatch, 1, 1),
+				// 0008
+				code.Make(code.OpJump, 14),
+				// 0011
+				code.Make(code.OpJump, 18),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpJump, 19),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	comp := New()
+	if err := comp.Compile(parse(`match (0) { case 1, "a" { 1 } case -1.5, null { 2 } }`)); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	table, ok := comp.Bytecode().Constants[1].(*object.Hash)
+	if !ok {
+		t.Fatalf("constant 1 is not a hash: %T", comp.Bytecode().Constants[1])
+	}
+	expected := map[object.HashKey]int64{
+		(&object.Integer{Value: 1}).HashKey():  0,
+		(&object.String{Value: "a"}).HashKey(): 0,
+		(&object.Float{Value: -1.5}).HashKey(): 1,
+		(&object.Null{}).HashKey():             1,
+	}
+	if len(table.Pairs) != len(expected) {
+		t.Fatalf("wrong number of patterns. want=%d, got=%d", len(expected), len(table.Pairs))
+	}
+	for key, arm := range expected {
+		if err := testIntegerObject(arm, table.Pairs[key].Value); err != nil {
+			t.Errorf("pattern %v: %s", key, err)
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"var x = 0; match (x) { case x + 1 { 1 } }", "line 1, column 24: (x + 1) can't be used as a pattern"},
+		{"match (0) { case {x + 1: a} { a } }", "line 1, column 13: hash pattern keys must be constants, got (x + 1)"},
+		{"match (0) { case 1 { 1 } case 2, 1 { 2 } }", "line 1, column 26: 1 is matched by more than one case"},
+	}
+	for _, tt := range errors {
+		err := New().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestMatchPatterns(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `match (1) { case [a] if (a) { a } }`,
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpDup),
+				// 0004
+				code.Make(code.OpMatchArray, 1),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 31),
+				// 0010
+				code.Make(code.OpDup),
+				// 0011
+				code.Make(code.OpConstant, 1),
+				// 0014
+				code.Make(code.OpIndex),
+				// 0015
+				code.Make(code.OpSetGlobal, 0),
+				// 0018
+				code.Make(code.OpGetGlobal, 0),
+				// 0021
+				code.Make(code.OpJumpNotTruthy, 31),
+				// 0024
+				code.Make(code.OpPop),
+				// 0025
+				code.Make(code.OpGetGlobal, 0),
+				// 0028
+				code.Make(code.OpJump, 33),
+				// 0031
+				code.Make(code.OpPop),
+				// 0032
+				code.Make(code.OpNull),
+				// 0033
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Each pattern of an arm tests the subject in turn.
+			input:             `match (1) { case "a", _ { 2 } }`,
+			expectedConstants: []interface{}{1, "a", 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpDup),
+				// 0004
+				code.Make(code.OpMatchValue, 1),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 13),
+				// 0010
+				code.Make(code.OpJump, 13),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
+				code.Make(code.OpJump, 22),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpNull),
+				// 0022
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestForIn(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "for (x in [1]) { x }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 21, 1),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpGetGlobal, 0),
+				// 0017
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpJump, 7),
+				// 0021
+				code.Make(code.OpSuppress),
+			},
+		},
+		{
+			input: "def(h) { for (k, v in h) { break } }",
+			expectedConstants: []interface{}{[]code.Instructions{
+				// 0000
+				code.Make(code.OpGetLocal, 0),
+				// 0002
+				code.Make(code.OpIter),
+				// 0003
+				code.Make(code.OpIterNext, 17, 2),
+				// 0007
+				code.Make(code.OpSetLocal, 2),
+				// 0009
+				code.Make(code.OpSetLocal, 1),
+				// 0011
+				code.Make(code.OpJump, 17),
+				// 0014
+				code.Make(code.OpJump, 3),
+				// 0017
+				code.Make(code.OpSuppress),
+				// 0018
+				code.Make(code.OpReturn),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAttempt(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "attempt { 1 } rescue (e) { e }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpAttempt, 11),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpCheckError),
+				// 0007
+				code.Make(code.OpEndAttempt),
+				// 0008
+				code.Make(code.OpJump, 17),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpGetGlobal, 0),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Leaving the loop ends the attempt block first.
+			input:             "var i = 0; while (i < 1) { attempt { break } rescue { 0 } }",
+			expectedConstants: []interface{}{0, 1, 0},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpGetGlobal, 0),
+				// 0012
+				code.Make(code.OpGreaterThan),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 36),
+				// 0016
+				code.Make(code.OpAttempt, 28),
+				// 0019
+				code.Make(code.OpEndAttempt),
+				// 0020
+				code.Make(code.OpJump, 36),
+				// 0023
+				code.Make(code.OpNull),
+				// 0024
+				code.Make(code.OpEndAttempt),
+				// 0025
+				code.Make(code.OpJump, 32),
+				// 0028
+				code.Make(code.OpPop),
+				// 0029
+				code.Make(code.OpConstant, 2),
+				// 0032
+				code.Make(code.OpPop),
+				// 0033
+				code.Make(code.OpJump, 6),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClasses(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "class P { def get() { self.x } }; var p = P.new(); p.x = 1",
+			expectedConstants: []interface{}{
+				"get",
+				"x",
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpDot),
+					code.Make(code.OpReturnValue),
+				},
+				"P",
+				"new",
+				"x",
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClass, 3, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpDot),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpConstant, 6),
+				code.Make(code.OpSetField),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	program := parse("class P { def f() { 1 } def f() { 2 } }")
+	err := New().Compile(program)
+	expected := "line 1, column 25: method f is defined more than once in class P"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected an error for the repeated method, got %v", err)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"def() { export x }", "line 1, column 9: export is only allowed at the top level of a file"},
+		{"export var _x = 1", "line 1, column 12: _x is private and can't be exported"},
+		{"var a = 1; export a, b", "line 1, column 22: can't export b; it isn't defined"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+
+	if err := New().Compile(parse("var a = 1; export a; export f >> () { a }")); err != nil {
+		t.Errorf("expected exports to compile, got %v", err)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	comp := New()
+	comp.Echo = true
+	if err := comp.Compile(parse("1; f >> () { 2; 3 }; f()")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	// Only the statements of the program end with OpEcho, not those of
+	// the function.
+	expected := []code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpEcho),
+		code.Make(code.OpClosure, 3, 0),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpEcho),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpCall, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpEcho),
+	}
+	if err := testInstructions(expected, bytecode.Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
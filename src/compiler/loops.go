@@ -0,0 +1,195 @@
+package compiler
+
+import (
+	"math"
+	"squ1d++/ast"
+	"squ1d++/code"
+	"squ1d++/object"
+)
+
+// countedLoop is a for loop of the form
+//
+//	for (var i = start; i < limit; i = i + step) { ... }
+//
+// with an integer literal step and a limit that is an integer literal or
+// another name. Its update and condition compile to one OpLoopLocal or
+// OpLoopGlobal instead of the seven or so instructions they take otherwise.
+type countedLoop struct {
+	counter Symbol
+	limit   ast.Expression
+	step    int64
+	cmp     int
+}
+
+// loopComparisons maps the operators of a counted loop's condition to the
+// comparisons of OpLoopLocal and OpLoopGlobal.
+var loopComparisons = map[string]int{
+	"<":  code.LoopLess,
+	"<=": code.LoopLessEqual,
+	">":  code.LoopGreater,
+	">=": code.LoopGreaterEqual,
+}
+
+// countedLoop reports whether node, whose Init has just been compiled, is a
+// counted loop.
+func (c *Compiler) countedLoop(node *ast.ForStatement) (countedLoop, bool) {
+	init, ok := node.Init.(*ast.LetStatement)
+	if !ok || init.ErrorPipe || init.Unblock {
+		return countedLoop{}, false
+	}
+	// An annotated counter keeps its checks, unless they can't fail.
+	if want := annotationName(init.Name.Type); want != "" && want != "int" && want != "any" {
+		return countedLoop{}, false
+	}
+	name := init.Name.Value
+
+	cond, ok := node.Condition.(*ast.InfixExpression)
+	if !ok || !isName(cond.Left, name) {
+		return countedLoop{}, false
+	}
+	cmp, ok := loopComparisons[cond.Operator]
+	if !ok {
+		return countedLoop{}, false
+	}
+	switch limit := cond.Right.(type) {
+	case *ast.IntegerLiteral:
+	case *ast.Identifier:
+		if limit.Value == name {
+			return countedLoop{}, false
+		}
+	default:
+		return countedLoop{}, false
+	}
+
+	update, ok := node.Update.(*ast.InfixExpression)
+	if !ok || update.Operator != "=" || !isName(update.Left, name) {
+		return countedLoop{}, false
+	}
+	sum, ok := update.Right.(*ast.InfixExpression)
+	if !ok || sum.Operator != "+" || !isName(sum.Left, name) {
+		return countedLoop{}, false
+	}
+	step, ok := integerLiteral(sum.Right)
+	if !ok {
+		return countedLoop{}, false
+	}
+
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok || (symbol.Scope == GlobalScope && symbol.Index > math.MaxUint16) ||
+		(symbol.Scope != GlobalScope && symbol.Scope != LocalScope) {
+		return countedLoop{}, false
+	}
+	return countedLoop{counter: symbol, limit: cond.Right, step: step, cmp: cmp}, true
+}
+
+// compileCountedLoop compiles the rest of a counted loop as:
+//
+//	condition;
+//	OpJumpNotTruthy -> afterLoop;  // the first check
+//	[body:] body;
+//	[continueTarget:] limit;
+//	OpLoopLocal/OpLoopGlobal counter, step, comparison -> body;
+//	[afterLoop:]
+func (c *Compiler) compileCountedLoop(node *ast.ForStatement, loop countedLoop) error {
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	bodyStart := len(c.currentInstructions())
+	c.enterLoop(bodyStart)
+
+	err = c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	c.loopContexts[len(c.loopContexts)-1].continueJumpPos = len(c.currentInstructions())
+	err = c.Compile(loop.limit)
+	if err != nil {
+		return err
+	}
+
+	step := c.addConstant(&object.Integer{Value: loop.step})
+	c.markPosition(node.Update.(*ast.InfixExpression).Token)
+	if loop.counter.Scope == GlobalScope {
+		c.emit(code.OpLoopGlobal, loop.counter.Index, step, loop.cmp, bodyStart)
+	} else {
+		c.emit(code.OpLoopLocal, loop.counter.Index, step, loop.cmp, bodyStart)
+	}
+
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.exitLoop()
+	return nil
+}
+
+// isName reports whether node is the identifier name.
+func isName(node ast.Expression, name string) bool {
+	ident, ok := node.(*ast.Identifier)
+	return ok && ident.Value == name
+}
+
+// integerLiteral returns the value of an integer literal, negated or not.
+func integerLiteral(node ast.Expression) (int64, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return node.Value, true
+	case *ast.PrefixExpression:
+		if lit, ok := node.Right.(*ast.IntegerLiteral); ok && node.Operator == "-" {
+			return -lit.Value, true
+		}
+	}
+	return 0, false
+}
+
+// compileForIn compiles a for-in loop as:
+//
+//	collection;
+//	OpIter;
+//	[next:] OpIterNext -> done, len(names);  // continue jumps here
+//	set names;
+//	body;
+//	OpJump -> next;
+//	[done:] OpSuppress;                      // break jumps here
+//
+// OpSuppress drops the iterator where OpPop would be taken for the pop of an
+// expression statement, whose value a function returns.
+func (c *Compiler) compileForIn(node *ast.ForInStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.markPosition(node.Token)
+	c.emit(code.OpIter)
+
+	next := len(c.currentInstructions())
+	c.enterLoop(next)
+	iterNextPos := c.emit(code.OpIterNext, 9999, len(node.Names))
+
+	symbols := make([]Symbol, len(node.Names))
+	for i, name := range node.Names {
+		symbols[i] = c.define(name)
+		c.markDefined(symbols[i])
+	}
+	// The key is pushed before the value, so the names are set last first.
+	for i := len(symbols) - 1; i >= 0; i-- {
+		symbol := symbols[i]
+		if symbol.Scope == GlobalScope {
+			c.setGlobal(symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+	}
+
+	err = c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, next)
+
+	c.replaceInstruction(iterNextPos, code.Make(code.OpIterNext, len(c.currentInstructions()), len(node.Names)))
+	c.exitLoop()
+	c.emit(code.OpSuppress)
+	return nil
+}
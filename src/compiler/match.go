@@ -0,0 +1,302 @@
+package compiler
+
+import (
+	"fmt"
+	"squ1d++/ast"
+	"squ1d++/code"
+	"squ1d++/object"
+)
+
+// compileMatch compiles a match expression. When every pattern is a
+// constant and no arm has a guard it becomes a jump table:
+//
+//	subject;
+//	OpMatch table, n;       // jumps to the OpJump of the matching arm
+//	OpJump -> arm 0;
+//	...
+//	OpJump -> arm n-1;
+//	OpJump -> el;
+//	[arm 0:] body; OpJump -> end;
+//	...
+//	[el:] body or null;
+//	[end:]
+//
+// table is a hash constant mapping every pattern to the index of its arm, so
+// picking an arm takes one lookup however many arms there are. Other matches
+// test the arms in turn (see compileArms).
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	if !constantArms(node) {
+		return c.compileArms(node)
+	}
+
+	table := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	for i, arm := range node.Arms {
+		for _, pattern := range arm.Patterns {
+			value, _ := matchPattern(pattern)
+			key, _ := object.HashKeyOf(value)
+			if _, ok := table.Pairs[key]; ok {
+				return c.matchError(arm, "%s is matched by more than one case", pattern.String())
+			}
+			table.Set(key, object.HashPair{Key: value, Value: &object.Integer{Value: int64(i)}})
+		}
+	}
+
+	err := c.Compile(node.Subject)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpMatch, c.addConstant(table), len(node.Arms))
+
+	jumpTable := make([]int, len(node.Arms)+1)
+	for i := range jumpTable {
+		jumpTable[i] = c.emit(code.OpJump, 9999)
+	}
+
+	var endJumps []int
+	for i, arm := range node.Arms {
+		c.changeOperand(jumpTable[i], len(c.currentInstructions()))
+		if err := c.compileBlockValue(arm.Body); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+	}
+
+	c.changeOperand(jumpTable[len(node.Arms)], len(c.currentInstructions()))
+	if err := c.compileBlockValue(node.Default); err != nil {
+		return err
+	}
+
+	end := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, end)
+	}
+	return nil
+}
+
+// constantArms reports whether a match can be compiled to a jump table.
+func constantArms(node *ast.MatchExpression) bool {
+	for _, arm := range node.Arms {
+		if arm.Guard != nil {
+			return false
+		}
+		for _, pattern := range arm.Patterns {
+			if _, ok := matchPattern(pattern); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compileArms compiles a match whose arms are tested in turn. The subject
+// stays on the stack while they are, and each pattern tests a copy of it:
+//
+//	subject;
+//	[arm:] pattern 1 -> next pattern; OpJump -> body;
+//	       ...
+//	       last pattern -> next arm;
+//	[body:] guard; OpJumpNotTruthy -> next arm;
+//	       OpPop; body; OpJump -> end;
+//	...
+//	OpPop; el body or null;
+//	[end:]
+func (c *Compiler) compileArms(node *ast.MatchExpression) error {
+	err := c.Compile(node.Subject)
+	if err != nil {
+		return err
+	}
+
+	var endJumps []int
+	for _, arm := range node.Arms {
+		var nextArm, toBody []int
+		for i, pattern := range arm.Patterns {
+			var fails []int
+			if err := c.compilePattern(arm, pattern, nil, &fails); err != nil {
+				return err
+			}
+			if i == len(arm.Patterns)-1 {
+				nextArm = append(nextArm, fails...)
+				break
+			}
+			toBody = append(toBody, c.emit(code.OpJump, 9999))
+			c.patchJumps(fails)
+		}
+		c.patchJumps(toBody)
+
+		if arm.Guard != nil {
+			if err := c.Compile(arm.Guard); err != nil {
+				return err
+			}
+			nextArm = append(nextArm, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+
+		c.emit(code.OpPop)
+		if err := c.compileBlockValue(arm.Body); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		c.patchJumps(nextArm)
+	}
+
+	c.emit(code.OpPop)
+	if err := c.compileBlockValue(node.Default); err != nil {
+		return err
+	}
+	c.patchJumps(endJumps)
+	return nil
+}
+
+// compilePattern tests the part of the subject on top of the stack found by
+// indexing it with the constants in path, leaving the subject in place. A
+// failed test jumps to one of the positions added to fails; names in the
+// pattern are bound as they are reached.
+func (c *Compiler) compilePattern(arm *ast.MatchArm, pattern ast.Expression, path []int, fails *[]int) error {
+	if value, ok := matchPattern(pattern); ok {
+		c.loadPath(path)
+		c.emit(code.OpMatchValue, c.addConstant(value))
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+		return nil
+	}
+
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value == "_" {
+			return nil
+		}
+		c.loadPath(path)
+		symbol := c.define(pattern)
+		c.markDefined(symbol)
+		if symbol.Scope == GlobalScope {
+			c.setGlobal(symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+		return nil
+
+	case *ast.ArrayLiteral:
+		return c.compileElementPatterns(arm, code.OpMatchArray, pattern.Elements, path, fails)
+
+	case *ast.TupleLiteral:
+		return c.compileElementPatterns(arm, code.OpMatchTuple, pattern.Elements, path, fails)
+
+	case *ast.HashLiteral:
+		keys := make([]object.Object, len(pattern.Keys))
+		for i, key := range pattern.Keys {
+			value, ok := matchPattern(key)
+			if !ok {
+				return c.matchError(arm, "hash pattern keys must be constants, got %s", key.String())
+			}
+			keys[i] = value
+		}
+		c.loadPath(path)
+		c.emit(code.OpMatchHash, c.addConstant(&object.Array{Elements: keys}))
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for i, key := range pattern.Keys {
+			err := c.compilePattern(arm, pattern.Pairs[key], appendPath(path, c.addConstant(keys[i])), fails)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return c.matchError(arm, "%s can't be used as a pattern", pattern.String())
+}
+
+// compileElementPatterns tests for an array or a tuple with one element
+// matching each of elements.
+func (c *Compiler) compileElementPatterns(arm *ast.MatchArm, op code.Opcode, elements []ast.Expression, path []int, fails *[]int) error {
+	c.loadPath(path)
+	c.emit(op, len(elements))
+	*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+	for i, element := range elements {
+		index := c.addConstant(&object.Integer{Value: int64(i)})
+		if err := c.compilePattern(arm, element, appendPath(path, index), fails); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPath pushes the part of the subject on top of the stack that path
+// leads to.
+func (c *Compiler) loadPath(path []int) {
+	c.emit(code.OpDup)
+	for _, index := range path {
+		c.emit(code.OpConstant, index)
+		c.emit(code.OpIndex)
+	}
+}
+
+// appendPath returns path followed by index, without sharing the backing
+// array of path between siblings.
+func appendPath(path []int, index int) []int {
+	return append(path[:len(path):len(path)], index)
+}
+
+// patchJumps makes the jumps at positions jump to the next instruction.
+func (c *Compiler) patchJumps(positions []int) {
+	target := len(c.currentInstructions())
+	for _, pos := range positions {
+		c.changeOperand(pos, target)
+	}
+}
+
+// compileBlockValue compiles a block, such as the block of a match arm, so it
+// leaves its value on the stack, or null for a missing or empty block.
+func (c *Compiler) compileBlockValue(body *ast.BlockStatement) error {
+	if body == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	start := len(c.currentInstructions())
+	if err := c.Compile(body); err != nil {
+		return err
+	}
+
+	last := c.scopes[c.scopeIndex].lastInstruction
+	if len(c.currentInstructions()) > start && last.Position >= start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) matchError(arm *ast.MatchArm, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d, column %d: %s", arm.Token.Line+c.LineOffset, arm.Token.Column,
+		fmt.Sprintf(format, args...))
+}
+
+// matchPattern returns the value of a constant pattern: a literal number,
+// string, boolean or null.
+func matchPattern(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.HexLiteral:
+		return &object.Hex{Value: node.Value}, true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}, true
+	case *ast.Null:
+		return &object.Null{}, true
+	case *ast.PrefixExpression:
+		if node.Operator != "-" {
+			return nil, false
+		}
+		switch right := node.Right.(type) {
+		case *ast.IntegerLiteral:
+			return &object.Integer{Value: -right.Value}, true
+		case *ast.FloatLiteral:
+			return &object.Float{Value: -right.Value}, true
+		}
+	}
+	return nil, false
+}
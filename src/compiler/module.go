@@ -0,0 +1,145 @@
+package compiler
+
+import (
+	"fmt"
+	"squ1d++/ast"
+	"squ1d++/code"
+	"squ1d++/object"
+)
+
+// inlineModule matches `pkg.include(def() { ... }, "namespace")`, the form
+// the builder expands namespaced includes into, and returns the module body.
+// The builder adds a third argument, the key of the file included, so a
+// file included under several namespaces runs once; key is "" without it.
+func inlineModule(stmt *ast.ExpressionStatement) (*ast.BlockStatement, string, string, bool) {
+	call, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok || len(call.Arguments) < 2 || len(call.Arguments) > 3 {
+		return nil, "", "", false
+	}
+	dot, ok := call.Function.(*ast.DotExpression)
+	if !ok {
+		return nil, "", "", false
+	}
+	class, ok := dot.Left.(*ast.Identifier)
+	if !ok || class.Value != "pkg" {
+		return nil, "", "", false
+	}
+	method, ok := dot.Right.(*ast.StringLiteral)
+	if !ok || method.Value != "include" {
+		return nil, "", "", false
+	}
+
+	fn, ok := call.Arguments[0].(*ast.FunctionLiteral)
+	if !ok || len(fn.Parameters) != 0 || fn.Body == nil {
+		return nil, "", "", false
+	}
+	namespace, ok := call.Arguments[1].(*ast.StringLiteral)
+	if !ok {
+		return nil, "", "", false
+	}
+	key := ""
+	if len(call.Arguments) == 3 {
+		k, ok := call.Arguments[2].(*ast.StringLiteral)
+		if !ok {
+			return nil, "", "", false
+		}
+		key = k.Value
+	}
+	return fn.Body, namespace.Value, key, true
+}
+
+// compileModule compiles an included module in its own scope, the way the
+// file runner executes pkg.include(path, namespace): top-level names are
+// private to the module, its globals live in the program's global store so
+// exported functions keep working, and a Hash of its exported definitions
+// (see SymbolTable.Exported) is bound to namespace in the current scope once
+// the module has run. A module whose key was compiled before isn't compiled
+// again: namespace is bound to the hash the first one built.
+func (c *Compiler) compileModule(body *ast.BlockStatement, namespace, key string) error {
+	if index, ok := c.modules[key]; ok {
+		c.getGlobal(index)
+		symbol := c.symbolTable.Define(namespace)
+		c.markDefined(symbol)
+		c.setGlobal(symbol.Index)
+		return nil
+	}
+
+	outer := c.symbolTable
+	module := NewModuleSymbolTable(outer)
+
+	c.symbolTable = module
+	for _, stmt := range body.Statements {
+		if err := c.Compile(stmt); err != nil {
+			c.symbolTable = outer
+			return err
+		}
+	}
+	c.symbolTable = outer
+
+	exports := 0
+	for _, sym := range module.GlobalSymbols() {
+		if !c.definedGlobals[sym.Index] || !module.Exported(sym.Name) {
+			continue
+		}
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: sym.Name}))
+		c.getGlobal(sym.Index)
+		exports++
+	}
+	c.emit(code.OpHash, exports*2)
+
+	symbol := c.symbolTable.Define(namespace)
+	c.markDefined(symbol)
+	c.setGlobal(symbol.Index)
+	if key != "" && symbol.Scope == GlobalScope {
+		if c.modules == nil {
+			c.modules = map[string]int{}
+		}
+		c.modules[key] = symbol.Index
+	}
+	return nil
+}
+
+// compileExport compiles the definition of an export statement and marks
+// its names as exported from the file. Outside of an included module it is
+// just the definition.
+func (c *Compiler) compileExport(node *ast.ExportStatement) error {
+	if c.symbolTable.Outer != nil {
+		return fmt.Errorf("line %d, column %d: export is only allowed at the top level of a file",
+			node.Token.Line+c.LineOffset, node.Token.Column)
+	}
+	for _, name := range node.Names {
+		if object.IsPrivateName(name.Value) {
+			return fmt.Errorf("line %d, column %d: %s is private and can't be exported",
+				name.Token.Line+c.LineOffset, name.Token.Column, name.Value)
+		}
+	}
+
+	if node.Statement != nil {
+		if err := c.Compile(node.Statement); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range node.Names {
+			if sym, ok := c.symbolTable.Resolve(name.Value); !ok || sym.Scope != GlobalScope {
+				return fmt.Errorf("line %d, column %d: can't export %s; it isn't defined",
+					name.Token.Line+c.LineOffset, name.Token.Column, name.Value)
+			}
+		}
+	}
+
+	for _, name := range node.Names {
+		c.symbolTable.Export(name.Value)
+	}
+	return nil
+}
+
+// markDefined records that a global symbol is given a value by the program.
+func (c *Compiler) markDefined(symbol Symbol) {
+	if symbol.Scope != GlobalScope {
+		return
+	}
+	if c.definedGlobals == nil {
+		c.definedGlobals = map[int]bool{}
+	}
+	c.definedGlobals[symbol.Index] = true
+}
@@ -0,0 +1,659 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"squ1d++/ast"
+	"squ1d++/code"
+	"squ1d++/object"
+)
+
+// Optimization levels understood by SetOptimizationLevel (CLI: -O0/-O1/-O2).
+const (
+	// OptNone compiles the program exactly as written.
+	OptNone = 0
+	// OptBasic folds constant expressions and deduplicates the constant pool.
+	OptBasic = 1
+	// OptFull additionally drops unreachable code and runs the peephole pass.
+	OptFull = 2
+)
+
+// SetOptimizationLevel selects which optimization passes run. Levels outside
+// the supported range are clamped.
+func (c *Compiler) SetOptimizationLevel(level int) {
+	if level < OptNone {
+		level = OptNone
+	}
+	if level > OptFull {
+		level = OptFull
+	}
+	c.optLevel = level
+}
+
+// OptimizationLevel returns the level set by SetOptimizationLevel.
+func (c *Compiler) OptimizationLevel() int {
+	return c.optLevel
+}
+
+// Optimize runs the peephole pass over the program compiled so far. Bytecode
+// runs it at OptFull, which also runs it over each function as it is
+// compiled; at lower levels it can be called after Compile instead.
+func (c *Compiler) Optimize() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions, scope.lines, scope.positions, scope.files = peephole(scope.instructions, c.constants, scope.lines, scope.positions, scope.files)
+	scope.lastInstruction = EmittedInstruction{}
+	scope.previousInstruction = EmittedInstruction{}
+}
+
+// foldConstant evaluates expressions made only of literals at compile time:
+// arithmetic on integers and floats, concatenation of strings and the
+// comparisons and negations the VM gives a fixed answer for. The arithmetic
+// mirrors the VM (int64 wrap-around, integers widened to floats next to a
+// float); anything that would raise a runtime error, such as division by
+// zero, is left unfolded.
+func foldConstant(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}, true
+
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+
+	case *ast.Boolean:
+		return nativeBool(node.Value), true
+
+	case *ast.PrefixExpression:
+		right, ok := foldConstant(node.Right)
+		if !ok {
+			return nil, false
+		}
+
+		switch node.Operator {
+		case "-":
+			switch right := right.(type) {
+			case *object.Integer:
+				return &object.Integer{Value: -right.Value}, true
+			case *object.Float:
+				return &object.Float{Value: -right.Value}, true
+			}
+		case "!":
+			// Only false and null are falsy, so ! of anything but a
+			// boolean is false.
+			if b, ok := right.(*object.Boolean); ok {
+				return nativeBool(!b.Value), true
+			}
+			return nativeBool(false), true
+		}
+
+	case *ast.InfixExpression:
+		left, ok := foldConstant(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := foldConstant(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right)
+	}
+
+	return nil, false
+}
+
+// foldInfix applies an infix operator to two folded values.
+func foldInfix(operator string, left, right object.Object) (object.Object, bool) {
+	switch l := left.(type) {
+	case *object.Integer:
+		switch r := right.(type) {
+		case *object.Integer:
+			return foldIntegers(operator, l.Value, r.Value)
+		case *object.Float:
+			return foldFloats(operator, float64(l.Value), r.Value)
+		}
+
+	case *object.Float:
+		switch r := right.(type) {
+		case *object.Integer:
+			return foldFloats(operator, l.Value, float64(r.Value))
+		case *object.Float:
+			return foldFloats(operator, l.Value, r.Value)
+		}
+
+	case *object.String:
+		r, ok := right.(*object.String)
+		if !ok {
+			return nil, false
+		}
+		switch operator {
+		case "+":
+			return &object.String{Value: l.Value + r.Value}, true
+		case "==":
+			return nativeBool(l.Value == r.Value), true
+		case "!=":
+			return nativeBool(l.Value != r.Value), true
+		}
+
+	case *object.Boolean:
+		r, ok := right.(*object.Boolean)
+		if !ok {
+			return nil, false
+		}
+		switch operator {
+		case "==":
+			return nativeBool(l.Value == r.Value), true
+		case "!=":
+			return nativeBool(l.Value != r.Value), true
+		}
+	}
+
+	return nil, false
+}
+
+func foldIntegers(operator string, l, r int64) (object.Object, bool) {
+	switch operator {
+	case "+":
+		return &object.Integer{Value: l + r}, true
+	case "-":
+		return &object.Integer{Value: l - r}, true
+	case "*":
+		return &object.Integer{Value: l * r}, true
+	case "/":
+		if r != 0 {
+			return &object.Integer{Value: l / r}, true
+		}
+	case "%":
+		if r != 0 {
+			return &object.Integer{Value: l % r}, true
+		}
+	case "==":
+		return nativeBool(l == r), true
+	case "!=":
+		return nativeBool(l != r), true
+	case ">":
+		return nativeBool(l > r), true
+	case "<":
+		return nativeBool(l < r), true
+	case ">=":
+		return nativeBool(l >= r), true
+	case "<=":
+		return nativeBool(l <= r), true
+	}
+	return nil, false
+}
+
+// foldFloats folds float arithmetic. Floats aren't compared by value in the
+// VM, so comparisons are left alone.
+func foldFloats(operator string, l, r float64) (object.Object, bool) {
+	switch operator {
+	case "+":
+		return &object.Float{Value: l + r}, true
+	case "-":
+		return &object.Float{Value: l - r}, true
+	case "*":
+		return &object.Float{Value: l * r}, true
+	case "/":
+		if r != 0 {
+			return &object.Float{Value: l / r}, true
+		}
+	case "%":
+		if r != 0 {
+			return &object.Float{Value: math.Mod(l, r)}, true
+		}
+	}
+	return nil, false
+}
+
+func nativeBool(value bool) *object.Boolean {
+	return &object.Boolean{Value: value}
+}
+
+// tryFold compiles node as a single constant if it can be folded. It only
+// folds operator expressions; bare literals go through the normal path.
+func (c *Compiler) tryFold(node ast.Expression) bool {
+	if c.optLevel < OptBasic {
+		return false
+	}
+
+	obj, ok := foldConstant(node)
+	if !ok {
+		return false
+	}
+
+	c.emitConstantValue(obj)
+	return true
+}
+
+// emitConstantValue pushes a folded value. Booleans must use OpTrue/OpFalse:
+// the VM compares against its True/False singletons.
+func (c *Compiler) emitConstantValue(obj object.Object) {
+	if b, ok := obj.(*object.Boolean); ok {
+		if b.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+		return
+	}
+
+	c.emit(code.OpConstant, c.addConstant(obj))
+}
+
+// constantKey identifies immutable constants that can share a pool slot.
+func constantKey(obj object.Object) (string, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return fmt.Sprintf("i:%d", obj.Value), true
+	case *object.Float:
+		return fmt.Sprintf("f:%b", obj.Value), true
+	case *object.Hex:
+		return fmt.Sprintf("h:%d", obj.Value), true
+	case *object.String:
+		return "s:" + obj.Value, true
+	}
+	return "", false
+}
+
+// constantCondition reports whether cond always evaluates to the same truth
+// value. Only consulted for dead-code elimination at OptFull.
+func (c *Compiler) constantCondition(cond ast.Expression) (truthy bool, ok bool) {
+	if c.optLevel < OptFull {
+		return false, false
+	}
+
+	if _, isNull := cond.(*ast.Null); isNull {
+		return false, true
+	}
+
+	obj, ok := foldConstant(cond)
+	if !ok {
+		return false, false
+	}
+
+	if b, isBool := obj.(*object.Boolean); isBool {
+		return b.Value, true
+	}
+	return true, true
+}
+
+// compileConstantIf compiles only the branch an always-true or always-false
+// condition selects, leaving the same value on the stack as the full form.
+func (c *Compiler) compileConstantIf(node *ast.IfExpression, truthy bool) error {
+	var branch *ast.BlockStatement
+	if truthy {
+		branch = node.Consequence
+	} else {
+		branch = node.Alternative
+	}
+
+	if branch == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	start := len(c.currentInstructions())
+	if err := c.Compile(branch); err != nil {
+		return err
+	}
+
+	// Only strip a pop emitted by the branch itself, never one belonging to
+	// the preceding statement.
+	last := c.scopes[c.scopeIndex].lastInstruction
+	if last.Position >= start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	}
+	return nil
+}
+
+// endsControlFlow reports whether nothing after stmt in the same block can
+// run.
+func endsControlFlow(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	}
+	return false
+}
+
+// decodedInstruction is an instruction as the peephole pass sees it.
+type decodedInstruction struct {
+	op       code.Opcode
+	operands []int
+	pos      int
+	width    int
+	removed  bool
+	// fixed is set on the entries of a match jump table, which OpMatch
+	// finds by counting and so must stay even when they look redundant.
+	fixed bool
+}
+
+// peephole removes obvious waste from ins: jumps that land on the very next
+// instruction, nulls that are pushed only to be popped, double negations of
+// booleans, and negations and comparisons of constants. It then re-targets
+// every remaining jump, and the line, position and file tables, to the
+// shifted positions.
+func peephole(ins code.Instructions, constants []object.Object, lines map[int][]int, positions map[int]object.Position, files map[int]string) (code.Instructions, map[int][]int, map[int]object.Position, map[int]string) {
+	var decoded []*decodedInstruction
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			// Unknown opcode: leave the stream untouched.
+			return ins, lines, positions, files
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		decoded = append(decoded, &decodedInstruction{
+			op:       code.Opcode(ins[i]),
+			operands: operands,
+			pos:      i,
+			width:    1 + read,
+		})
+		i += 1 + read
+	}
+	targets := map[int]bool{}
+	for idx, in := range decoded {
+		if at, ok := jumpOperand(in.op); ok {
+			targets[in.operands[at]] = true
+		}
+		if in.op != code.OpMatch {
+			continue
+		}
+		if idx+2+in.operands[1] > len(decoded) {
+			return ins, lines, positions, files
+		}
+		for _, entry := range decoded[idx+1 : idx+2+in.operands[1]] {
+			entry.fixed = true
+		}
+	}
+
+	// Removing or rewriting one instruction can expose more waste, so
+	// repeat until nothing changes.
+	for changed := true; changed; {
+		changed = removeJumpsToNext(decoded)
+		if rewriteInstructions(decoded, constants, targets) {
+			changed = true
+		}
+	}
+
+	// Map every old position to its new one. Removed instructions map to
+	// the next surviving instruction, so jumps into them stay valid.
+	newPos := make(map[int]int, len(decoded)+1)
+	offset := 0
+	for _, in := range decoded {
+		newPos[in.pos] = offset
+		if !in.removed {
+			offset += len(code.Make(in.op, in.operands...))
+		}
+	}
+	newPos[len(ins)] = offset
+
+	out := make(code.Instructions, 0, offset)
+	for _, in := range decoded {
+		if in.removed {
+			continue
+		}
+
+		operands := in.operands
+		if at, ok := jumpOperand(in.op); ok {
+			target, ok := newPos[operands[at]]
+			if !ok {
+				// Jump into the middle of an instruction: not ours to fix.
+				return ins, lines, positions, files
+			}
+			operands = append([]int(nil), operands...)
+			operands[at] = target
+		}
+		out = append(out, code.Make(in.op, operands...)...)
+	}
+
+	var moved map[int][]int
+	for pos, marked := range lines {
+		if moved == nil {
+			moved = map[int][]int{}
+		}
+		moved[newPos[pos]] = append(moved[newPos[pos]], marked...)
+	}
+	for _, marked := range moved {
+		sort.Ints(marked)
+	}
+
+	var movedPositions map[int]object.Position
+	for pos, position := range positions {
+		if movedPositions == nil {
+			movedPositions = map[int]object.Position{}
+		}
+		movedPositions[newPos[pos]] = position
+	}
+
+	var movedFiles map[int]string
+	for pos, file := range files {
+		if movedFiles == nil {
+			movedFiles = map[int]string{}
+		}
+		movedFiles[newPos[pos]] = file
+	}
+
+	return out, moved, movedPositions, movedFiles
+}
+
+// removeJumpsToNext removes the jumps everything between which and their
+// target has already been removed.
+func removeJumpsToNext(decoded []*decodedInstruction) bool {
+	changed := false
+	for idx, in := range decoded {
+		if in.removed || in.fixed || in.op != code.OpJump {
+			continue
+		}
+
+		target := in.operands[0]
+		if target < in.pos+in.width {
+			continue
+		}
+
+		redundant := true
+		for _, next := range decoded[idx+1:] {
+			if next.pos >= target {
+				break
+			}
+			if !next.removed {
+				redundant = false
+				break
+			}
+		}
+
+		if redundant {
+			in.removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rewriteInstructions applies the peephole rules other than jump removal to
+// the instructions still in place. A rule only applies to a run of
+// instructions nothing jumps into the middle of.
+func rewriteInstructions(decoded []*decodedInstruction, constants []object.Object, targets map[int]bool) bool {
+	var live []*decodedInstruction
+	for _, in := range decoded {
+		if !in.removed {
+			live = append(live, in)
+		}
+	}
+
+	window := func(i, n int) []*decodedInstruction {
+		if i+n > len(live) {
+			return nil
+		}
+		for _, in := range live[i+1 : i+n] {
+			if targets[in.pos] {
+				return nil
+			}
+		}
+		return live[i : i+n]
+	}
+
+	changed := false
+	for i := 0; i < len(live); i++ {
+		if w := window(i, 2); w != nil && w[0].op == code.OpNull && w[1].op == code.OpPop {
+			// A null nobody looks at.
+			w[0].removed, w[1].removed = true, true
+			changed = true
+			i++
+			continue
+		}
+
+		if w := window(i, 3); w != nil && pushesBoolean(w[0].op) && w[1].op == code.OpBang && w[2].op == code.OpBang {
+			w[1].removed, w[2].removed = true, true
+			changed = true
+			i += 2
+			continue
+		}
+
+		if w := window(i, 2); w != nil && w[1].op == code.OpBang {
+			if value, ok := pushedConstant(w[0], constants); ok {
+				w[0].removed = true
+				setBoolean(w[1], !isTruthyConstant(value))
+				changed = true
+				i++
+				continue
+			}
+		}
+
+		if w := window(i, 3); w != nil {
+			left, lok := pushedConstant(w[0], constants)
+			right, rok := pushedConstant(w[1], constants)
+			if lok && rok {
+				if result, ok := foldComparison(w[2].op, left, right); ok {
+					w[0].removed, w[1].removed = true, true
+					setBoolean(w[2], result)
+					changed = true
+					i += 2
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// pushesBoolean reports whether op always leaves true or false on the stack.
+func pushesBoolean(op code.Opcode) bool {
+	switch op {
+	case code.OpTrue, code.OpFalse, code.OpBang, code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		return true
+	}
+	return false
+}
+
+// pushedConstant returns the value in pushes when it is an integer or string
+// constant, true, false or null.
+func pushedConstant(in *decodedInstruction, constants []object.Object) (object.Object, bool) {
+	switch in.op {
+	case code.OpConstant:
+		if in.operands[0] >= len(constants) {
+			return nil, false
+		}
+		switch value := constants[in.operands[0]].(type) {
+		case *object.Integer, *object.String:
+			return value, true
+		}
+	case code.OpTrue:
+		return nativeBool(true), true
+	case code.OpFalse:
+		return nativeBool(false), true
+	case code.OpNull:
+		return &object.Null{}, true
+	}
+	return nil, false
+}
+
+// isTruthyConstant mirrors the VM's OpBang: only false and null are falsy.
+func isTruthyConstant(value object.Object) bool {
+	switch value := value.(type) {
+	case *object.Boolean:
+		return value.Value
+	case *object.Null:
+		return false
+	}
+	return true
+}
+
+// foldComparison evaluates the comparison op of two constants where the VM
+// is certain to give the same answer. Integers and strings compare by value;
+// true, false and null are singletons in the VM, so they compare by identity.
+func foldComparison(op code.Opcode, left, right object.Object) (bool, bool) {
+	switch l := left.(type) {
+	case *object.Integer:
+		r, ok := right.(*object.Integer)
+		if !ok {
+			return false, false
+		}
+		switch op {
+		case code.OpEqual:
+			return l.Value == r.Value, true
+		case code.OpNotEqual:
+			return l.Value != r.Value, true
+		case code.OpGreaterThan:
+			return l.Value > r.Value, true
+		}
+
+	case *object.String:
+		r, ok := right.(*object.String)
+		if !ok {
+			return false, false
+		}
+		switch op {
+		case code.OpEqual:
+			return l.Value == r.Value, true
+		case code.OpNotEqual:
+			return l.Value != r.Value, true
+		}
+
+	case *object.Boolean, *object.Null:
+		same, ok := sameSingleton(left, right)
+		if !ok {
+			return false, false
+		}
+		switch op {
+		case code.OpEqual:
+			return same, true
+		case code.OpNotEqual:
+			return !same, true
+		}
+	}
+	return false, false
+}
+
+// sameSingleton reports whether two values pushed by OpTrue, OpFalse or
+// OpNull are the same singleton.
+func sameSingleton(left, right object.Object) (bool, bool) {
+	switch r := right.(type) {
+	case *object.Boolean:
+		l, ok := left.(*object.Boolean)
+		return ok && l.Value == r.Value, true
+	case *object.Null:
+		_, ok := left.(*object.Null)
+		return ok, true
+	}
+	return false, false
+}
+
+// setBoolean turns in into the instruction pushing value.
+func setBoolean(in *decodedInstruction, value bool) {
+	in.op, in.operands = code.OpFalse, nil
+	if value {
+		in.op = code.OpTrue
+	}
+}
+
+// jumpOperand returns which operand of op is a jump target.
+func jumpOperand(op code.Opcode) (int, bool) {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpAnd, code.OpOr, code.OpIterNext, code.OpAttempt:
+		return 0, true
+	case code.OpLoopLocal, code.OpLoopGlobal:
+		return 3, true
+	}
+	return 0, false
+}
@@ -0,0 +1,440 @@
+package compiler
+
+import (
+	"squ1d++/code"
+	"squ1d++/object"
+	"testing"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-5 % 3",
+			expectedConstants: []interface{}{-2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 <= 1",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 * 3 + 4",
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1.5 * 2 + 7 % 2.5",
+			expectedConstants: []interface{}{-1.0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"squ" + "1d" + "++"`,
+			expectedConstants: []interface{}{"squ1d++"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `("a" == "b") != (true == !0)`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Integers wrap around, as in the VM.
+			input:             "9223372036854775807 + 1",
+			expectedConstants: []interface{}{-9223372036854775808},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The VM compares floats by identity, not value.
+			input:             "1.5 == 1.5",
+			expectedConstants: []interface{}{1.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Mixing types is left to the VM.
+			input:             `"a" + 1`,
+			expectedConstants: []interface{}{"a", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Division by zero stays a runtime error.
+			input:             "1 / 0",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAtLevel(t, OptBasic, tests)
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a"; 10; "a"; 10`,
+			expectedConstants: []interface{}{"a", 10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAtLevel(t, OptBasic, tests)
+}
+
+func TestDeadCodeElimination(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (1 > 2) { 10 } el { 20 }; 3333;",
+			expectedConstants: []interface{}{20, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The null the missing el branch leaves goes unused.
+			input:                "if (false) { 10 }",
+			expectedConstants:    []interface{}{},
+			expectedInstructions: []code.Instructions{},
+		},
+		{
+			input: "def() { return 1; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAtLevel(t, OptFull, tests)
+}
+
+func TestOptimizationLevelNoneLeavesCodeAlone(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 1",
+			expectedConstants: []interface{}{1, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAtLevel(t, OptNone, tests)
+}
+
+func TestPeepholeRemovesJumpToNext(t *testing.T) {
+	ins := concatInstructions([]code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpNotTruthy, 11),
+		code.Make(code.OpJump, 7),
+		code.Make(code.OpNull),
+		code.Make(code.OpJump, 0),
+		code.Make(code.OpPop),
+	})
+
+	expected := []code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpNotTruthy, 8),
+		code.Make(code.OpNull),
+		code.Make(code.OpJump, 0),
+		code.Make(code.OpPop),
+	}
+
+	out, _, _, _ := peephole(ins, nil, nil, nil, nil)
+	if err := testInstructions(expected, out); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestPeepholeRetargetsCountedLoops(t *testing.T) {
+	ins := concatInstructions([]code.Instructions{
+		code.Make(code.OpJump, 3),
+		code.Make(code.OpTrue),
+		code.Make(code.OpPop),
+		code.Make(code.OpLoopLocal, 0, 0, code.LoopLess, 3),
+	})
+
+	expected := []code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpPop),
+		code.Make(code.OpLoopLocal, 0, 0, code.LoopLess, 0),
+	}
+
+	out, _, _, _ := peephole(ins, nil, nil, nil, nil)
+	if err := testInstructions(expected, out); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestPeepholeKeepsMatchJumpTables(t *testing.T) {
+	// OpMatch finds its arm by counting entries, so the el entry of a match
+	// without cases stays even though it jumps to the next instruction.
+	ins := concatInstructions([]code.Instructions{
+		code.Make(code.OpNull),
+		code.Make(code.OpMatch, 0, 0),
+		code.Make(code.OpJump, 9),
+		code.Make(code.OpNull),
+		code.Make(code.OpJump, 13),
+		code.Make(code.OpNull),
+	})
+
+	expected := []code.Instructions{
+		code.Make(code.OpNull),
+		code.Make(code.OpMatch, 0, 0),
+		code.Make(code.OpJump, 9),
+		code.Make(code.OpNull),
+		code.Make(code.OpNull),
+	}
+
+	out, _, _, _ := peephole(ins, nil, nil, nil, nil)
+	if err := testInstructions(expected, out); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestPeepholeRewrites(t *testing.T) {
+	tests := []struct {
+		name      string
+		constants []object.Object
+		input     []code.Instructions
+		expected  []code.Instructions
+	}{
+		{
+			name: "unused null",
+			input: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpTrue),
+			},
+		},
+		{
+			name: "double negation of a boolean",
+			input: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpBang),
+				code.Make(code.OpBang),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGreaterThan),
+			},
+		},
+		{
+			// !!x turns any value into a boolean, so it stays.
+			name: "double negation of anything else",
+			input: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpBang),
+				code.Make(code.OpBang),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpBang),
+				code.Make(code.OpBang),
+			},
+		},
+		{
+			name:      "negated constants",
+			constants: []object.Object{&object.String{Value: ""}},
+			input: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpBang),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBang),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+			},
+		},
+		{
+			name:      "constant comparisons",
+			constants: []object.Object{&object.String{Value: "a"}, &object.String{Value: "b"}, &object.Integer{Value: 2}},
+			input: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEqual),
+				code.Make(code.OpTrue),
+				code.Make(code.OpNull),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpGreaterThan),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+			},
+		},
+		{
+			// Strings can't be ordered, so the error is left for run time.
+			name:      "comparisons that fail",
+			constants: []object.Object{&object.String{Value: "a"}},
+			input: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGreaterThan),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGreaterThan),
+			},
+		},
+		{
+			name: "folding exposes more",
+			input: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpTrue),
+				code.Make(code.OpEqual),
+				code.Make(code.OpBang),
+				code.Make(code.OpBang),
+				code.Make(code.OpBang),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpFalse),
+			},
+		},
+		{
+			// The pop is where the if lands when its condition is false, so
+			// the null can't be dropped with it.
+			name: "jump into the middle",
+			input: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotTruthy, 7),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotTruthy, 7),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		out, _, _, _ := peephole(concatInstructions(tt.input), tt.constants, nil, nil, nil)
+		if err := testInstructions(tt.expected, out); err != nil {
+			t.Errorf("%s: testInstructions failed: %s", tt.name, err)
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	program := parse(`var a = "x" == "x"; !(a <= 2)`)
+	compiler := New()
+	compiler.SetOptimizationLevel(OptNone)
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	compiler.Optimize()
+
+	expected := []code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpGreaterThan),
+		code.Make(code.OpPop),
+	}
+	if err := testInstructions(expected, compiler.Bytecode().Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func runCompilerTestsAtLevel(t *testing.T, level int, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		compiler.SetOptimizationLevel(level)
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", tt.input, err)
+		}
+
+		if err := testConstants(t, tt.expectedConstants, bytecode.Constants); err != nil {
+			t.Fatalf("testConstants failed for %q: %s", tt.input, err)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package compiler
 
+import (
+	"sort"
+	"squ1d++/object"
+)
+
 type SymbolScope string
 
 const (
@@ -21,6 +26,19 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 	FreeSymbols    []Symbol
+	// globals is the table whose global store a module table allocates its
+	// globals from; nil for ordinary tables.
+	globals *SymbolTable
+	// definedAt records where the names defined with DefineAt were first
+	// defined, and read which names of the table have been read since.
+	definedAt map[string]object.Position
+	read      map[string]bool
+	// types holds the declared types of the names of the table that have
+	// one (see declaredType).
+	types map[string]declaredType
+	// exports holds the names exported with export statements; nil when
+	// there were none.
+	exports map[string]bool
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -35,6 +53,25 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// NewModuleSymbolTable returns the global scope of an included module. Names
+// defined in it are private to the module, but their slots are allocated in
+// the global store of the program parent belongs to, so functions exported
+// from the module keep working when the including program calls them.
+// Builtins resolve through that program's table.
+func NewModuleSymbolTable(parent *SymbolTable) *SymbolTable {
+	root := parent
+	for root.Outer != nil {
+		root = root.Outer
+	}
+	if root.globals != nil {
+		root = root.globals
+	}
+
+	s := NewSymbolTable()
+	s.globals = root
+	return s
+}
+
 func (s *SymbolTable) Define(name string) Symbol {
 	// Redeclaring a variable in the same scope should reuse the same slot.
 	// This keeps branch-local `var x = ...` declarations aligned when both
@@ -53,6 +90,10 @@ func (s *SymbolTable) Define(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
+		if s.globals != nil {
+			symbol.Index = s.globals.numDefinitions
+			s.globals.numDefinitions++
+		}
 	} else {
 		symbol.Scope = LocalScope
 	}
@@ -62,8 +103,82 @@ func (s *SymbolTable) Define(name string) Symbol {
 	return symbol
 }
 
+// DefineAt is Define, recording pos as where name is defined unless it was
+// defined before.
+func (s *SymbolTable) DefineAt(name string, pos object.Position) Symbol {
+	symbol := s.Define(name)
+	if s.definedAt == nil {
+		s.definedAt = map[string]object.Position{}
+	}
+	if _, ok := s.definedAt[name]; !ok {
+		s.definedAt[name] = pos
+	}
+	return symbol
+}
+
+// DefinedAt returns where name was defined in s, if it was defined with
+// DefineAt.
+func (s *SymbolTable) DefinedAt(name string) (object.Position, bool) {
+	pos, ok := s.definedAt[name]
+	return pos, ok
+}
+
+// MarkRead records that name was read. A function reading a variable of an
+// enclosing function or of the program marks it in the table defining it.
+func (s *SymbolTable) MarkRead(name string) {
+	for table := s; table != nil; table = table.Outer {
+		sym, ok := table.store[name]
+		if !ok || sym.Scope == FreeScope {
+			continue
+		}
+		if sym.Scope == LocalScope || sym.Scope == GlobalScope {
+			if table.read == nil {
+				table.read = map[string]bool{}
+			}
+			table.read[name] = true
+		}
+		return
+	}
+}
+
+// UnreadLocals returns the locals of s defined with DefineAt that were never
+// read, ordered by slot.
+func (s *SymbolTable) UnreadLocals() []Symbol {
+	var unread []Symbol
+	for name := range s.definedAt {
+		if sym := s.store[name]; sym.Scope == LocalScope && !s.read[name] {
+			unread = append(unread, sym)
+		}
+	}
+	sort.Slice(unread, func(i, j int) bool { return unread[i].Index < unread[j].Index })
+	return unread
+}
+
+// NumGlobals returns the number of global slots allocated in the global
+// store s belongs to.
+func (s *SymbolTable) NumGlobals() int {
+	root := s.Root()
+	if root.globals != nil {
+		root = root.globals
+	}
+	return root.numDefinitions
+}
+
+// Root returns the program's table: the outermost table of s.
+func (s *SymbolTable) Root() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
+	if !ok && s.globals != nil {
+		if sym, found := s.globals.Resolve(name); found && sym.Scope == BuiltinScope {
+			return sym, true
+		}
+	}
 	if !ok && s.Outer != nil {
 		obj, ok = s.Outer.Resolve(name)
 		if !ok {
@@ -102,3 +217,44 @@ func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	s.store[name] = symbol
 	return symbol
 }
+
+// LocalNames returns the names of the locals defined in s, indexed by slot.
+func (s *SymbolTable) LocalNames() []string {
+	names := make([]string, s.numDefinitions)
+	for _, sym := range s.store {
+		if sym.Scope == LocalScope && sym.Index < len(names) {
+			names[sym.Index] = sym.Name
+		}
+	}
+	return names
+}
+
+// Export marks name as exported from the file s is the global scope of.
+func (s *SymbolTable) Export(name string) {
+	if s.exports == nil {
+		s.exports = map[string]bool{}
+	}
+	s.exports[name] = true
+}
+
+// Exported reports whether name belongs in the namespace of the file s is
+// the global scope of: when the file exports names, whether it is one of
+// them, and otherwise whether it is public.
+func (s *SymbolTable) Exported(name string) bool {
+	if s.exports != nil {
+		return s.exports[name]
+	}
+	return !object.IsPrivateName(name)
+}
+
+// GlobalSymbols returns the globals defined in s, ordered by slot.
+func (s *SymbolTable) GlobalSymbols() []Symbol {
+	var symbols []Symbol
+	for _, sym := range s.store {
+		if sym.Scope == GlobalScope {
+			symbols = append(symbols, sym)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Index < symbols[j].Index })
+	return symbols
+}
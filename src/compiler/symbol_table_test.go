@@ -360,3 +360,33 @@ func TestShadowingFunctionName(t *testing.T) {
 			expected.Name, expected, result)
 	}
 }
+
+func TestModuleSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "io")
+	global.Define("a")
+
+	module := NewModuleSymbolTable(global)
+	b := module.Define("b")
+	if b != (Symbol{Name: "b", Scope: GlobalScope, Index: 1}) {
+		t.Fatalf("expected module global in the next program slot, got %+v", b)
+	}
+	if next := global.Define("c"); next.Index != 2 {
+		t.Fatalf("expected program globals to skip module slots, got %+v", next)
+	}
+
+	if _, ok := module.Resolve("a"); ok {
+		t.Fatalf("expected program globals to be hidden from the module")
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Fatalf("expected module globals to be hidden from the program")
+	}
+	if io, ok := module.Resolve("io"); !ok || io.Scope != BuiltinScope {
+		t.Fatalf("expected builtins to resolve in the module, got %+v", io)
+	}
+
+	nested := NewModuleSymbolTable(module)
+	if d := nested.Define("d"); d.Index != 3 {
+		t.Fatalf("expected nested module globals in the program store, got %+v", d)
+	}
+}
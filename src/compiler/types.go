@@ -0,0 +1,205 @@
+package compiler
+
+import (
+	"fmt"
+	"squ1d++/ast"
+	"squ1d++/code"
+	"squ1d++/object"
+	"squ1d++/token"
+)
+
+// declaredType is what the compiler knows about the type of a name: the
+// type name of its annotation, and the function literal it was bound to by
+// var or >>, whose annotations calls are checked against.
+type declaredType struct {
+	name string
+	fn   *ast.FunctionLiteral
+}
+
+// setType records the declared type of name, which s defines.
+func (s *SymbolTable) setType(name string, t declaredType) {
+	if s.types == nil {
+		s.types = map[string]declaredType{}
+	}
+	s.types[name] = t
+}
+
+// assigned records that value was assigned to name. A function literal
+// becomes the signature calls of name are checked against; any other value
+// drops it.
+func (s *SymbolTable) assigned(name string, value ast.Expression) {
+	for table := s; table != nil; table = table.Outer {
+		sym, ok := table.store[name]
+		if !ok || sym.Scope == FreeScope {
+			continue
+		}
+		t := table.types[name]
+		t.fn, _ = value.(*ast.FunctionLiteral)
+		table.setType(name, t)
+		return
+	}
+}
+
+// typeOf returns the declared type of name in the table defining it.
+func (s *SymbolTable) typeOf(name string) declaredType {
+	for table := s; table != nil; table = table.Outer {
+		sym, ok := table.store[name]
+		if !ok || sym.Scope == FreeScope {
+			continue
+		}
+		return table.types[name]
+	}
+	return declaredType{}
+}
+
+// annotationName is the type name of annotation, or "" without one.
+func annotationName(annotation *ast.TypeAnnotation) string {
+	if annotation == nil {
+		return ""
+	}
+	return annotation.Name
+}
+
+// checkAnnotation reports an annotation naming an unknown type.
+func (c *Compiler) checkAnnotation(annotation *ast.TypeAnnotation) error {
+	if annotation == nil || object.IsAnnotationType(annotation.Name) {
+		return nil
+	}
+	return fmt.Errorf("line %d, column %d: Unknown type %s",
+		annotation.Token.Line+c.LineOffset, annotation.Token.Column, annotation.Name)
+}
+
+// checkType makes sure value, which is on top of the stack, has the type
+// called want. A value whose type is known here is checked now and a
+// mismatch reported at tok; any other value is checked when the program
+// runs. value is nil when only the runtime knows it. An empty want checks
+// nothing.
+func (c *Compiler) checkType(want string, value ast.Expression, subject string, tok token.Token) error {
+	if want == "" || want == "any" {
+		return nil
+	}
+
+	if value != nil {
+		if got := c.staticType(value); got != "" {
+			if got == want {
+				return nil
+			}
+			return fmt.Errorf("line %d, column %d: %s", tok.Line+c.LineOffset, tok.Column,
+				object.AnnotationMismatch(subject, want, got))
+		}
+	}
+
+	c.markPosition(tok)
+	c.emit(code.OpCheckType,
+		c.addConstant(&object.String{Value: want}),
+		c.addConstant(&object.String{Value: subject}))
+	return nil
+}
+
+// checkArguments checks the arguments of a call to a function whose
+// parameters are annotated, as far as their types are known here.
+func (c *Compiler) checkArguments(node *ast.CallExpression) error {
+	callee, ok := node.Function.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+	fn := c.symbolTable.typeOf(callee.Value).fn
+	if fn == nil {
+		return nil
+	}
+
+	for i, arg := range node.Arguments {
+		if i >= len(fn.Parameters) {
+			break
+		}
+		param := fn.Parameters[i]
+		want := annotationName(param.Type)
+		if want == "" || want == "any" {
+			continue
+		}
+		if got := c.staticType(arg); got != "" && got != want {
+			return fmt.Errorf("line %d, column %d: %s", node.Token.Line+c.LineOffset, node.Token.Column,
+				object.AnnotationMismatch("Argument "+param.Value+" to "+callee.Value, want, got))
+		}
+	}
+	return nil
+}
+
+// staticType returns the annotation type name node is known to have before
+// the program runs, or "" when only the runtime knows.
+func (c *Compiler) staticType(node ast.Expression) string {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.HexLiteral:
+		return "int"
+	case *ast.FloatLiteral:
+		return "float"
+	case *ast.StringLiteral:
+		return "string"
+	case *ast.Boolean:
+		return "bool"
+	case *ast.Null:
+		return "null"
+	case *ast.ArrayLiteral:
+		return "array"
+	case *ast.HashLiteral:
+		return "hash"
+	case *ast.SetLiteral:
+		return "set"
+	case *ast.TupleLiteral:
+		return "tuple"
+	case *ast.FunctionLiteral:
+		if node.Async {
+			return ""
+		}
+		return "func"
+
+	case *ast.Identifier:
+		t := c.symbolTable.typeOf(node.Value)
+		if t.name == "" && t.fn != nil && !t.fn.Async {
+			return "func"
+		}
+		if t.name == "any" {
+			return ""
+		}
+		return t.name
+
+	case *ast.CallExpression:
+		callee, ok := node.Function.(*ast.Identifier)
+		if !ok {
+			return ""
+		}
+		if fn := c.symbolTable.typeOf(callee.Value).fn; fn != nil && !fn.Async {
+			if result := annotationName(fn.ReturnType); result != "any" {
+				return result
+			}
+		}
+		return ""
+
+	case *ast.PrefixExpression:
+		switch node.Operator {
+		case "!":
+			return "bool"
+		case "-":
+			if t := c.staticType(node.Right); t == "int" || t == "float" {
+				return t
+			}
+		}
+		return ""
+
+	case *ast.InfixExpression:
+		switch node.Operator {
+		case "==", "!=", "<", ">", "<=", ">=", "and", "or":
+			return "bool"
+		case "+", "-", "*", "/", "%":
+			left, right := c.staticType(node.Left), c.staticType(node.Right)
+			if left != right {
+				return ""
+			}
+			if left == "int" || left == "float" || (left == "string" && node.Operator == "+") {
+				return left
+			}
+		}
+		return ""
+	}
+	return ""
+}
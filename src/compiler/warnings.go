@@ -0,0 +1,79 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"squ1d++/ast"
+	"squ1d++/object"
+)
+
+// Warning is a likely mistake in a program that still compiles: a local
+// variable that is never read, or one hiding a global of the same name.
+type Warning struct {
+	Filename string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (w Warning) String() string {
+	if w.Filename != "" {
+		return fmt.Sprintf("%s, line %d, column %d: warning: %s", w.Filename, w.Line, w.Column, w.Message)
+	}
+	return fmt.Sprintf("line %d, column %d: warning: %s", w.Line, w.Column, w.Message)
+}
+
+// Warnings returns the warnings found so far, ordered by position.
+func (c *Compiler) Warnings() []Warning {
+	warnings := append([]Warning(nil), c.warnings...)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].Line != warnings[j].Line {
+			return warnings[i].Line < warnings[j].Line
+		}
+		return warnings[i].Column < warnings[j].Column
+	})
+	return warnings
+}
+
+func (c *Compiler) warn(pos object.Position, format string, a ...interface{}) {
+	file, line := c.source(pos.Line)
+	c.warnings = append(c.warnings, Warning{
+		Filename: file,
+		Line:     line,
+		Column:   pos.Column,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// define defines ident in the current scope, warning when a local hides a
+// global the program defined. Names starting with an underscore are never
+// warned about.
+func (c *Compiler) define(ident *ast.Identifier) Symbol {
+	pos := object.Position{Line: ident.Token.Line + c.LineOffset, Column: ident.Token.Column}
+	if c.symbolTable.Outer != nil && !object.IsPrivateName(ident.Value) {
+		if _, defined := c.symbolTable.store[ident.Value]; !defined {
+			if global, ok := c.symbolTable.Root().DefinedAt(ident.Value); ok {
+				c.warn(pos, "%s shadows the global defined at line %d", ident.Value, global.Line)
+			}
+		}
+	}
+	symbol := c.symbolTable.DefineAt(ident.Value, pos)
+	c.symbolTable.setType(ident.Value, declaredType{name: annotationName(ident.Type)})
+	return symbol
+}
+
+// warnUnread warns about the locals of the function being compiled that
+// are never read. Parameters are left out: callbacks often ignore some.
+func (c *Compiler) warnUnread(params []*ast.Identifier) {
+	isParam := map[string]bool{}
+	for _, p := range params {
+		isParam[p.Value] = true
+	}
+	for _, sym := range c.symbolTable.UnreadLocals() {
+		if isParam[sym.Name] || object.IsPrivateName(sym.Name) {
+			continue
+		}
+		pos, _ := c.symbolTable.DefinedAt(sym.Name)
+		c.warn(pos, "%s is defined but never used", sym.Name)
+	}
+}
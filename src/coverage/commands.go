@@ -0,0 +1,125 @@
+package coverage
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"squ1d++/repl"
+	"squ1d++/std"
+	"squ1d++/vm"
+)
+
+// Run implements `squ1dcc cover [--annotate] [--html out.html] file.sqd`:
+// it runs the file, recording the statements that execute in it and in the
+// files it includes, then prints a summary per file. The exit status is 1
+// when the program fails, after the report is printed.
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cover", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(stderr) }
+
+	annotate := fs.Bool("annotate", false, "Print the source with the hit count of every line")
+	htmlFile := fs.String("html", "", "Write an HTML report to this file")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		printUsage(stderr)
+		return 2
+	}
+	file := fs.Arg(0)
+
+	profile := NewProfile()
+	vm.LineHook = func(_ *vm.VM, file string, line int) {
+		profile.Record(file, line)
+	}
+	runErr := repl.ExecuteFile(file, stdout)
+	vm.LineHook = nil
+	if runErr != nil {
+		fmt.Fprintf(stderr, "Error executing file %s: %v\n", file, runErr)
+	}
+
+	reports, err := reportFiles(file, profile)
+	if err != nil {
+		fmt.Fprintf(stderr, "cover: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintln(stdout)
+	if *annotate {
+		for _, r := range reports {
+			WriteAnnotated(stdout, r)
+			fmt.Fprintln(stdout)
+		}
+	}
+	WriteSummary(stdout, reports)
+
+	if *htmlFile != "" {
+		f, err := os.Create(*htmlFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "cover: %v\n", err)
+			return 1
+		}
+		err = WriteHTML(f, reports)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "cover: %v\n", err)
+			return 1
+		}
+	}
+
+	if runErr != nil {
+		return 1
+	}
+	return 0
+}
+
+// reportFiles builds the reports for file and every included file that ran,
+// leaving out the standard library.
+func reportFiles(file string, profile *Profile) ([]*FileReport, error) {
+	files := []string{file}
+	for _, f := range profile.Files() {
+		if _, isStd := std.Resolve(f); !isStd && !sameFile(f, file) {
+			files = append(files, f)
+		}
+	}
+
+	var reports []*FileReport
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		hits := profile.Hits(f)
+		if sameFile(f, file) {
+			hits = profile.Hits(file)
+		}
+		r, err := Report(f, src, hits)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: squ1dcc cover [--annotate] [--html out.html] file.sqd")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Runs file.sqd and reports which of its statements, and of the files")
+	fmt.Fprintln(w, "it includes, were executed.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  --annotate     print each file with the hit count of every line")
+	fmt.Fprintln(w, "  --html file    write an HTML report")
+}
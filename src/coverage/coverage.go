@@ -0,0 +1,168 @@
+// Package coverage records which statements of a SQU1DLang program run and
+// reports them per source line, as a summary, an annotated listing or an
+// HTML page.
+package coverage
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"squ1d++/ast"
+	"squ1d++/lexer"
+	"squ1d++/parser"
+	"strings"
+	"sync"
+)
+
+// Profile counts how often each line of each file started a statement. Its
+// Record method is meant to be called from vm.LineHook.
+type Profile struct {
+	mu   sync.Mutex
+	hits map[string]map[int]int
+}
+
+// NewProfile returns an empty profile.
+func NewProfile() *Profile {
+	return &Profile{hits: map[string]map[int]int{}}
+}
+
+// Record counts one execution of the statement at file:line.
+func (p *Profile) Record(file string, line int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines := p.hits[file]
+	if lines == nil {
+		lines = map[int]int{}
+		p.hits[file] = lines
+	}
+	lines[line]++
+}
+
+// Files returns the files with recorded statements, sorted.
+func (p *Profile) Files() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	files := make([]string, 0, len(p.hits))
+	for file := range p.hits {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Hits returns the execution count of every recorded line of file.
+func (p *Profile) Hits(file string) map[int]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hits := make(map[int]int, len(p.hits[file]))
+	for line, n := range p.hits[file] {
+		hits[line] = n
+	}
+	return hits
+}
+
+// Line is one source line of a FileReport.
+type Line struct {
+	Number int
+	Text   string
+	// Executable is true when a statement starts on the line.
+	Executable bool
+	// Hits is how often those statements ran.
+	Hits int
+}
+
+// FileReport is the coverage of one source file.
+type FileReport struct {
+	File  string
+	Lines []Line
+	// Statements is the number of executable lines and Covered the number
+	// of them that ran.
+	Statements int
+	Covered    int
+}
+
+// Percent returns the share of executable lines that ran, 100 for a file
+// without any.
+func (r *FileReport) Percent() float64 {
+	if r.Statements == 0 {
+		return 100
+	}
+	return 100 * float64(r.Covered) / float64(r.Statements)
+}
+
+// Report matches the hits recorded for file against its source. Every line
+// a statement starts on is executable, including statements in functions
+// that were never called; bench blocks don't count, as they only run under
+// `squ1dcc bench`.
+func Report(file string, src []byte, hits map[int]int) (*FileReport, error) {
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", strings.Join(p.Errors(), "\n"))
+	}
+
+	executable := map[int]bool{}
+	statementLines(reflect.ValueOf(program), executable)
+
+	report := &FileReport{File: file}
+	text := strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n"), "\n")
+	for i, t := range text {
+		line := Line{Number: i + 1, Text: t, Executable: executable[i+1], Hits: hits[i+1]}
+		if line.Executable {
+			report.Statements++
+			if line.Hits > 0 {
+				report.Covered++
+			}
+		}
+		report.Lines = append(report.Lines, line)
+	}
+	return report, nil
+}
+
+// statementLines adds the line of every statement in the tree under v.
+func statementLines(v reflect.Value, lines map[int]bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			statementLines(v.Elem(), lines)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if stmt, ok := v.Interface().(ast.Statement); ok {
+			if _, isBench := stmt.(*ast.BenchStatement); isBench {
+				return
+			}
+			// Blocks aren't statements of their own: their statements are.
+			if _, isBlock := stmt.(*ast.BlockStatement); isBlock {
+				statementLines(v.Elem(), lines)
+				return
+			}
+			if line := ast.StatementLine(stmt); line > 0 {
+				lines[line] = true
+			}
+		}
+		statementLines(v.Elem(), lines)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				statementLines(v.Field(i), lines)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			statementLines(v.Index(i), lines)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			statementLines(key, lines)
+			statementLines(v.MapIndex(key), lines)
+		}
+	}
+}
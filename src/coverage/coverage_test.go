@@ -0,0 +1,76 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	src := "var x = 1\nf >> () {\n    return 2\n}\n\nif (x == 1) {\n    io.echo(x)\n} el {\n    io.echo(0)\n}\nbench \"b\" {\n    f()\n}\n"
+	r, err := Report("main.sqd", []byte(src), map[int]int{1: 1, 2: 1, 6: 1, 7: 1})
+	if err != nil {
+		t.Fatalf("Report returned error: %v", err)
+	}
+
+	var executable []int
+	for _, line := range r.Lines {
+		if line.Executable {
+			executable = append(executable, line.Number)
+		}
+	}
+	if fmt.Sprint(executable) != "[1 2 3 6 7 9]" {
+		t.Fatalf("expected lines 1 2 3 6 7 9 to be executable, got %v", executable)
+	}
+	if r.Statements != 6 || r.Covered != 4 {
+		t.Fatalf("expected 4/6 statements covered, got %d/%d", r.Covered, r.Statements)
+	}
+	if len(r.Lines) != 13 {
+		t.Fatalf("expected 13 lines, got %d", len(r.Lines))
+	}
+
+	var out bytes.Buffer
+	WriteAnnotated(&out, r)
+	for _, want := range []string{"        1:    1:var x = 1\n", "    #####:    3:    return 2\n", "        -:    4:}\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected annotated output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunReportsIncludedFiles(t *testing.T) {
+	dir := t.TempDir()
+	lib := "double >> (x) {\n    return x * 2\n}\ntriple >> (x) {\n    return x * 3\n}\n"
+	main := "pkg.include(\"lib.sqd\", \"lib\")\nvar total = 0\nfor (var i = 0; i < 3; i = i + 1) {\n    total = total + lib.double(i)\n}\nif (total > 100) {\n    io.echo(\"big\")\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.sqd"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(dir, "main.sqd")
+	if err := os.WriteFile(mainFile, []byte(main), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	htmlFile := filepath.Join(dir, "cover.html")
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"--html", htmlFile, mainFile}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+	}
+
+	expected := mainFile + ": 83.3% of statements (5/6)\n" +
+		filepath.Join(dir, "lib.sqd") + ": 75.0% of statements (3/4)\n" +
+		"total: 80.0% of statements (8/10)\n"
+	if !strings.HasSuffix(stdout.String(), expected) {
+		t.Fatalf("expected summary\n%s\ngot\n%s", expected, stdout.String())
+	}
+
+	page, err := os.ReadFile(htmlFile)
+	if err != nil {
+		t.Fatalf("HTML report wasn't written: %v", err)
+	}
+	if !strings.Contains(string(page), `<tr class="miss"><td class="n">5</td><td class="c">#####</td><td class="src">    return x * 3</td></tr>`) {
+		t.Fatalf("expected the uncalled function to be marked in the HTML report, got:\n%s", page)
+	}
+}
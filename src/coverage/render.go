@@ -0,0 +1,86 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+)
+
+// WriteSummary prints the coverage of every report and, for several files,
+// of all of them together.
+func WriteSummary(w io.Writer, reports []*FileReport) {
+	total := &FileReport{}
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s: %.1f%% of statements (%d/%d)\n", r.File, r.Percent(), r.Covered, r.Statements)
+		total.Statements += r.Statements
+		total.Covered += r.Covered
+	}
+	if len(reports) > 1 {
+		fmt.Fprintf(w, "total: %.1f%% of statements (%d/%d)\n", total.Percent(), total.Covered, total.Statements)
+	}
+}
+
+// WriteAnnotated prints r's source in the layout of gcov: each line is
+// prefixed with its hit count, "-" when no statement starts on it, or
+// "#####" when its statements never ran.
+func WriteAnnotated(w io.Writer, r *FileReport) {
+	fmt.Fprintf(w, "%9s:%5d:Source:%s\n", "-", 0, r.File)
+	for _, line := range r.Lines {
+		fmt.Fprintf(w, "%9s:%5d:%s\n", lineCount(line), line.Number, line.Text)
+	}
+}
+
+func lineCount(line Line) string {
+	switch {
+	case !line.Executable:
+		return "-"
+	case line.Hits == 0:
+		return "#####"
+	default:
+		return strconv.Itoa(line.Hits)
+	}
+}
+
+var page = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"class": func(line Line) string {
+		switch {
+		case !line.Executable:
+			return ""
+		case line.Hits == 0:
+			return "miss"
+		default:
+			return "hit"
+		}
+	},
+	"count":   lineCount,
+	"percent": func(r *FileReport) string { return fmt.Sprintf("%.1f%%", r.Percent()) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>SQU1D++ coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; font-family: monospace; }
+td { padding: 0 0.5em; white-space: pre; }
+td.n, td.c { text-align: right; color: #888; }
+tr.hit td.src { background: #dfd; }
+tr.miss td.src { background: #fdd; }
+</style>
+</head>
+<body>
+{{range .}}<h2 id="{{.File}}">{{.File}}</h2>
+<p>{{percent .}} of statements ({{.Covered}}/{{.Statements}})</p>
+<table>
+{{range .Lines}}<tr class="{{class .}}"><td class="n">{{.Number}}</td><td class="c">{{count .}}</td><td class="src">{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the reports as an HTML page with covered lines in green
+// and lines that never ran in red.
+func WriteHTML(w io.Writer, reports []*FileReport) error {
+	return page.Execute(w, reports)
+}
@@ -0,0 +1,128 @@
+// Package crash records unrecoverable faults, panics inside the VM or the
+// evaluator, in a report file users can attach to bug reports.
+package crash
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Global is a global variable holding a value when the fault happened.
+type Global struct {
+	Index int
+	// Name is "" when the symbol table isn't known.
+	Name  string
+	Type  string
+	Value string
+}
+
+// Fault is an unrecoverable error: a panic of the VM or the evaluator,
+// with what is known of the program's state at the time.
+type Fault struct {
+	// Value is what was passed to panic and GoStack the Go call stack.
+	Value   interface{}
+	GoStack []byte
+	// Engine is "vm" or "evaluator".
+	Engine string
+	// File and Line locate the running statement, when known.
+	File string
+	Line int
+	// Offset is the bytecode offset of the faulting instruction and
+	// Instruction the instruction decoded. They are only set in the VM.
+	Offset      int
+	Instruction string
+	// Frames is the call stack, innermost first, and Stack the values on
+	// the VM's stack, topmost first.
+	Frames []string
+	Stack  []string
+	// Globals holds the globals that had a value, builtins excepted.
+	Globals []Global
+}
+
+func (f *Fault) Error() string {
+	if f.File != "" && f.Line > 0 {
+		return fmt.Sprintf("internal error in the %s at %s, line %d: %v", f.Engine, f.File, f.Line, f.Value)
+	}
+	return fmt.Sprintf("internal error in the %s: %v", f.Engine, f.Value)
+}
+
+// IsFault reports whether a recovered panic value is a fault, rather than a
+// panic used on purpose to unwind the program, such as the debugger's when
+// the user quits. Faults panic with an error, like Go's runtime errors, or
+// with a string.
+func IsFault(r interface{}) bool {
+	switch r.(type) {
+	case error, string:
+		return true
+	}
+	return false
+}
+
+// WriteReport writes the report of f, for a program run by the given
+// version of the compiler.
+func (f *Fault) WriteReport(w io.Writer, version string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "SQU1D++ crash report\n\n")
+	fmt.Fprintf(&b, "Error:       %v\n", f.Value)
+	fmt.Fprintf(&b, "Engine:      %s\n", f.Engine)
+	if f.File != "" {
+		fmt.Fprintf(&b, "File:        %s\n", f.File)
+	}
+	if f.Line > 0 {
+		fmt.Fprintf(&b, "Line:        %d\n", f.Line)
+	}
+	if f.Instruction != "" {
+		fmt.Fprintf(&b, "Offset:      %04d\n", f.Offset)
+		fmt.Fprintf(&b, "Instruction: %s\n", f.Instruction)
+	}
+	fmt.Fprintf(&b, "Version:     squ1d++ %s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "Time:        %s\n", time.Now().Format(time.RFC3339))
+
+	section(&b, "Call stack (innermost first)", f.Frames)
+	section(&b, "VM stack (topmost first)", f.Stack)
+
+	globals := make([]string, len(f.Globals))
+	for i, g := range f.Globals {
+		name := g.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", g.Index)
+		}
+		globals[i] = fmt.Sprintf("%-16s %-12s %s", name, g.Type, g.Value)
+	}
+	section(&b, fmt.Sprintf("Globals (%d)", len(f.Globals)), globals)
+
+	fmt.Fprintf(&b, "\nGo stack:\n%s", f.GoStack)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func section(b *strings.Builder, title string, lines []string) {
+	fmt.Fprintf(b, "\n%s:\n", title)
+	if len(lines) == 0 {
+		b.WriteString("  (empty)\n")
+	}
+	for _, line := range lines {
+		fmt.Fprintf(b, "  %s\n", line)
+	}
+}
+
+// Save writes the report of f to a new file in dir, the system's temporary
+// directory when dir is "", and returns its path.
+func (f *Fault) Save(dir, version string) (string, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	file, err := os.CreateTemp(dir, "squ1d++-crash-*.txt")
+	if err != nil {
+		return "", err
+	}
+	if err := f.WriteReport(file, version); err != nil {
+		file.Close()
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
@@ -0,0 +1,60 @@
+package crash
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIsFault(t *testing.T) {
+	type unwind struct{}
+	for _, tt := range []struct {
+		value interface{}
+		fault bool
+	}{
+		{errors.New("runtime error"), true},
+		{"assertion failed", true},
+		{unwind{}, false},
+		{42, false},
+	} {
+		if got := IsFault(tt.value); got != tt.fault {
+			t.Errorf("IsFault(%#v) = %t, want %t", tt.value, got, tt.fault)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	fault := &Fault{
+		Value:   "boom",
+		GoStack: []byte("goroutine 1 [running]:\n"),
+		Engine:  "evaluator",
+		File:    "main.sqd",
+		Globals: []Global{{Index: 3, Name: "count", Type: "INTEGER", Value: "7"}, {Index: 4, Type: "STRING", Value: "x"}},
+	}
+	if got := fault.Error(); got != "internal error in the evaluator: boom" {
+		t.Errorf("wrong Error(): %q", got)
+	}
+
+	path, err := fault.Save(t.TempDir(), "1.2.3")
+	if err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+	report, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Error:       boom\nEngine:      evaluator\nFile:        main.sqd\nVersion:     squ1d++ 1.2.3",
+		"Call stack (innermost first):\n  (empty)\n",
+		"Globals (2):\n  count            INTEGER      7\n  #4               STRING       x\n",
+		"Go stack:\ngoroutine 1 [running]:\n",
+	} {
+		if !strings.Contains(string(report), want) {
+			t.Errorf("expected the report to contain %q, got:\n%s", want, report)
+		}
+	}
+	if strings.Contains(string(report), "Offset:") {
+		t.Errorf("expected no bytecode offset for an evaluator fault, got:\n%s", report)
+	}
+}
@@ -0,0 +1,64 @@
+package debug
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Run implements `squ1dcc debug [--break loc]... file.sqd`: it runs the file
+// under the debugger, reading commands from stdin. The program pauses before
+// its first statement, or runs to the first breakpoint when some are given.
+// The exit status is 1 when the program fails.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(stderr) }
+
+	var breaks locations
+	fs.Var(&breaks, "break", "Set a breakpoint at `[file:]line` (repeatable)")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		printUsage(stderr)
+		return 2
+	}
+	file := fs.Arg(0)
+
+	d := New(file, stdin, stdout)
+	for _, loc := range breaks {
+		if _, err := d.Break(loc); err != nil {
+			fmt.Fprintf(stderr, "debug: %v\n", err)
+			return 2
+		}
+		d.mode = modeContinue
+	}
+	if err := d.Run(); err != nil {
+		fmt.Fprintf(stderr, "Error executing file %s: %v\n", file, err)
+		return 1
+	}
+	return 0
+}
+
+// locations collects repeated --break flags.
+type locations []string
+
+func (l *locations) String() string { return strings.Join(*l, ",") }
+
+func (l *locations) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: squ1dcc debug [--break [file:]line]... file.sqd")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Runs file.sqd under the debugger. The program pauses before its first")
+	fmt.Fprintln(w, "statement, or at the first breakpoint when --break is given, and at every")
+	fmt.Fprintln(w, "breakpoint after that; type help at the (debug) prompt for the commands.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  --break loc    set a breakpoint at line, or file:line (repeatable)")
+}
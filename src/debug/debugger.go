@@ -0,0 +1,436 @@
+// Package debug is an interactive debugger for SQU1DLang programs. It runs a
+// file the way `squ1dcc file.sqd` does and pauses it through vm.LineHook on
+// breakpoints and while stepping, reading commands from its input to step
+// through the program and inspect its call stack, locals and globals.
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"squ1d++/object"
+	"squ1d++/repl"
+	"squ1d++/runner"
+	"squ1d++/std"
+	"squ1d++/vm"
+	"strconv"
+	"strings"
+)
+
+// Breakpoint pauses the program when a statement starting on Line of File
+// runs. File is matched against the path of the running file or, when it
+// has no directory, against its base name.
+type Breakpoint struct {
+	File string
+	Line int
+}
+
+func (b Breakpoint) String() string {
+	return fmt.Sprintf("%s:%d", b.File, b.Line)
+}
+
+// mode is what the debugger does after a command resumes the program.
+type mode int
+
+const (
+	// modeContinue runs until a breakpoint.
+	modeContinue mode = iota
+	// modeStep stops at the next statement, entering calls.
+	modeStep
+	// modeNext stops at the next statement of the same function or a
+	// caller, stepping over calls.
+	modeNext
+	// modeOut stops once the current function has returned.
+	modeOut
+)
+
+// errQuit unwinds the program when the user quits.
+type errQuit struct{}
+
+// Debugger runs one program under the debugger.
+type Debugger struct {
+	file string
+	in   *bufio.Scanner
+	out  io.Writer
+
+	breakpoints []Breakpoint
+	mode        mode
+	// depth and stepFile are the frame depth and file the program was paused
+	// at when stepping began.
+	depth    int
+	stepFile string
+	// lastCommand is repeated when an empty line is entered.
+	lastCommand string
+
+	state   *runner.Session
+	sources map[string][]string
+
+	// Set while paused: the call stack, the frame selected for locals and
+	// print, and the location.
+	frames []vm.FrameInfo
+	frame  int
+	at     Breakpoint
+}
+
+// New returns a debugger for file that reads commands from in and writes to
+// out, where the program's output goes as well. The program pauses before
+// its first statement.
+func New(file string, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		file:    file,
+		in:      bufio.NewScanner(in),
+		out:     out,
+		mode:    modeStep,
+		sources: map[string][]string{},
+	}
+}
+
+// Break adds a breakpoint given as "line" in the debugged file or
+// "file:line".
+func (d *Debugger) Break(spec string) (Breakpoint, error) {
+	bp, err := d.parseLocation(spec)
+	if err != nil {
+		return Breakpoint{}, err
+	}
+	for _, existing := range d.breakpoints {
+		if existing == bp {
+			return bp, nil
+		}
+	}
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp, nil
+}
+
+// Run runs the program under the debugger. It returns the program's error,
+// or nil when it finishes or the user quits.
+func (d *Debugger) Run() (err error) {
+	vm.LineHook = d.hook
+	defer func() {
+		vm.LineHook = nil
+		if r := recover(); r != nil {
+			if _, ok := r.(errQuit); !ok {
+				panic(r)
+			}
+			err = nil
+		}
+	}()
+
+	return repl.ExecuteFileWithState(d.file, d.out, func(state *runner.Session) {
+		d.state = state
+	})
+}
+
+func (d *Debugger) hook(machine *vm.VM, file string, line int) {
+	depth := 1
+	if machine != nil {
+		depth = machine.Depth()
+	}
+
+	stop := false
+	switch d.mode {
+	case modeStep:
+		stop = true
+	case modeNext:
+		stop = depth < d.depth || (depth == d.depth && file == d.stepFile)
+	case modeOut:
+		stop = depth < d.depth
+	}
+	if !stop {
+		for _, bp := range d.breakpoints {
+			if bp.Line == line && matchFile(bp.File, file) {
+				stop = true
+				break
+			}
+		}
+	}
+	if !stop {
+		return
+	}
+
+	d.frames = nil
+	if machine != nil {
+		d.frames = machine.Frames()
+	}
+	d.frame = 0
+	d.at = Breakpoint{File: file, Line: line}
+	d.pause(depth)
+}
+
+// pause reports where the program stopped and runs commands until one
+// resumes it.
+func (d *Debugger) pause(depth int) {
+	function := ""
+	if len(d.frames) > 0 {
+		function = " in " + d.frames[0].Function
+	}
+	fmt.Fprintf(d.out, "Paused at %s%s\n", d.at, function)
+	d.printLines(d.at.File, d.at.Line, d.at.Line)
+
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			panic(errQuit{})
+		}
+		command := strings.TrimSpace(d.in.Text())
+		if command == "" {
+			command = d.lastCommand
+		}
+		d.lastCommand = command
+
+		if resume := d.execute(command); resume != nil {
+			d.mode = *resume
+			d.depth = depth
+			d.stepFile = d.at.File
+			return
+		}
+	}
+}
+
+// execute runs one command. It returns the mode to resume the program in,
+// or nil to keep it paused.
+func (d *Debugger) execute(command string) *mode {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil
+	}
+	name, args := fields[0], fields[1:]
+
+	resume := func(m mode) *mode { return &m }
+	switch name {
+	case "s", "step":
+		return resume(modeStep)
+	case "n", "next":
+		return resume(modeNext)
+	case "o", "out":
+		return resume(modeOut)
+	case "c", "continue":
+		return resume(modeContinue)
+	case "q", "quit":
+		panic(errQuit{})
+	case "b", "break":
+		if len(args) != 1 {
+			fmt.Fprintln(d.out, "Usage: break [file:]line")
+			return nil
+		}
+		bp, err := d.Break(args[0])
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			return nil
+		}
+		fmt.Fprintf(d.out, "Breakpoint at %s\n", bp)
+	case "d", "delete":
+		d.delete(args)
+	case "breakpoints":
+		if len(d.breakpoints) == 0 {
+			fmt.Fprintln(d.out, "No breakpoints.")
+		}
+		for _, bp := range d.breakpoints {
+			fmt.Fprintln(d.out, bp)
+		}
+	case "bt", "backtrace", "where":
+		d.backtrace()
+	case "f", "frame":
+		d.selectFrame(args)
+	case "locals":
+		d.locals()
+	case "globals":
+		for _, v := range d.globals() {
+			fmt.Fprintf(d.out, "%s = %s\n", v.Name, v.Value.Inspect())
+		}
+	case "p", "print":
+		if len(args) != 1 {
+			fmt.Fprintln(d.out, "Usage: print name")
+			return nil
+		}
+		d.print(args[0])
+	case "l", "list":
+		d.printLines(d.at.File, d.at.Line-5, d.at.Line+5)
+	case "h", "help":
+		printHelp(d.out)
+	default:
+		fmt.Fprintf(d.out, "Unknown command %q; type help for a list of commands\n", name)
+	}
+	return nil
+}
+
+func (d *Debugger) delete(args []string) {
+	if len(args) == 0 {
+		d.breakpoints = nil
+		fmt.Fprintln(d.out, "Deleted all breakpoints.")
+		return
+	}
+	bp, err := d.parseLocation(args[0])
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	for i, existing := range d.breakpoints {
+		if existing == bp {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			fmt.Fprintf(d.out, "Deleted breakpoint at %s\n", bp)
+			return
+		}
+	}
+	fmt.Fprintf(d.out, "No breakpoint at %s\n", bp)
+}
+
+func (d *Debugger) backtrace() {
+	if len(d.frames) == 0 {
+		fmt.Fprintf(d.out, "#0  <main> at %s\n", d.at)
+		return
+	}
+	for i, f := range d.frames {
+		marker := " "
+		if i == d.frame {
+			marker = "*"
+		}
+		fmt.Fprintf(d.out, "%s#%d  %s at %s:%d\n", marker, i, f.Function, f.File, f.Line)
+	}
+}
+
+func (d *Debugger) selectFrame(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "Usage: frame n")
+		return
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n >= len(d.frames) {
+		fmt.Fprintf(d.out, "No frame %s\n", args[0])
+		return
+	}
+	d.frame = n
+	f := d.frames[n]
+	fmt.Fprintf(d.out, "#%d  %s at %s:%d\n", n, f.Function, f.File, f.Line)
+}
+
+func (d *Debugger) locals() {
+	if d.frame >= len(d.frames) || len(d.frames[d.frame].Locals) == 0 {
+		fmt.Fprintln(d.out, "No locals.")
+		return
+	}
+	for _, v := range d.frames[d.frame].Locals {
+		fmt.Fprintf(d.out, "%s = %s\n", v.Name, v.Value.Inspect())
+	}
+}
+
+// print shows the value name has in the selected frame, falling back to
+// the globals of the debugged file.
+func (d *Debugger) print(name string) {
+	if d.frame < len(d.frames) {
+		for _, v := range d.frames[d.frame].Locals {
+			if v.Name == name {
+				fmt.Fprintln(d.out, v.Value.Inspect())
+				return
+			}
+		}
+	}
+	for _, v := range d.globals() {
+		if v.Name == name {
+			fmt.Fprintln(d.out, v.Value.Inspect())
+			return
+		}
+	}
+	fmt.Fprintf(d.out, "No variable %s\n", name)
+}
+
+// globals returns the globals the debugged file has defined so far, sorted
+// by name, leaving out the builtin classes.
+func (d *Debugger) globals() []vm.Variable {
+	if d.state == nil {
+		return nil
+	}
+	classes := object.CreateClassObjects()
+	var globals []vm.Variable
+	for _, sym := range d.state.Symbols.GlobalSymbols() {
+		if _, isClass := classes[sym.Name]; isClass || sym.Index >= d.state.Globals.Len() {
+			continue
+		}
+		value := d.state.Globals.Get(sym.Index)
+		if value == nil {
+			continue
+		}
+		// Names used before they are defined hold a placeholder error.
+		if e, ok := value.(*object.Error); ok && e.Message == "Undefined variable "+sym.Name {
+			continue
+		}
+		globals = append(globals, vm.Variable{Name: sym.Name, Value: value})
+	}
+	sort.Slice(globals, func(i, j int) bool { return globals[i].Name < globals[j].Name })
+	return globals
+}
+
+// printLines prints lines from to to of file, marking the line the program
+// is paused at.
+func (d *Debugger) printLines(file string, from, to int) {
+	lines := d.source(file)
+	from = max(from, 1)
+	to = min(to, len(lines))
+	for n := from; n <= to; n++ {
+		marker := " "
+		if n == d.at.Line && file == d.at.File {
+			marker = ">"
+		}
+		fmt.Fprintf(d.out, "%s%5d  %s\n", marker, n, lines[n-1])
+	}
+}
+
+// source returns the lines of file, or nil when it can't be read.
+func (d *Debugger) source(file string) []string {
+	lines, ok := d.sources[file]
+	if !ok {
+		if content, err := std.ReadFile(file); err == nil {
+			text := strings.TrimSuffix(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+			lines = strings.Split(text, "\n")
+		}
+		d.sources[file] = lines
+	}
+	return lines
+}
+
+// parseLocation parses "line" or "file:line".
+func (d *Debugger) parseLocation(spec string) (Breakpoint, error) {
+	file, lineText := d.file, spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		file, lineText = spec[:i], spec[i+1:]
+	}
+	line, err := strconv.Atoi(lineText)
+	if err != nil || line < 1 || file == "" {
+		return Breakpoint{}, fmt.Errorf("invalid location %q; want line or file:line", spec)
+	}
+	return Breakpoint{File: file, Line: line}, nil
+}
+
+// matchFile reports whether the breakpoint file pattern names file.
+func matchFile(pattern, file string) bool {
+	if pattern == file {
+		return true
+	}
+	if filepath.Base(pattern) == pattern {
+		return filepath.Base(file) == pattern
+	}
+	absPattern, errPattern := filepath.Abs(pattern)
+	absFile, errFile := filepath.Abs(file)
+	return errPattern == nil && errFile == nil && absPattern == absFile
+}
+
+func printHelp(w io.Writer) {
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  s, step              run to the next statement, entering calls")
+	fmt.Fprintln(w, "  n, next              run to the next statement, stepping over calls")
+	fmt.Fprintln(w, "  o, out               run until the current function returns")
+	fmt.Fprintln(w, "  c, continue          run until a breakpoint")
+	fmt.Fprintln(w, "  b, break [file:]line set a breakpoint")
+	fmt.Fprintln(w, "  d, delete [location] delete a breakpoint, or all of them")
+	fmt.Fprintln(w, "  breakpoints          list breakpoints")
+	fmt.Fprintln(w, "  bt, backtrace        show the call stack")
+	fmt.Fprintln(w, "  f, frame n           select frame n of the call stack")
+	fmt.Fprintln(w, "  locals               show the variables of the selected frame")
+	fmt.Fprintln(w, "  globals              show the globals of the program")
+	fmt.Fprintln(w, "  p, print name        show a variable")
+	fmt.Fprintln(w, "  l, list              show the source around the current line")
+	fmt.Fprintln(w, "  q, quit              stop the program and exit")
+	fmt.Fprintln(w, "An empty line repeats the last command.")
+}
@@ -0,0 +1,135 @@
+package debug
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const program = `var total = 0
+add >> (a, b) {
+    var s = a + b
+    return s
+}
+for (var i = 0; i < 2; i = i + 1) {
+    total = add(total, i)
+}
+io.echo(total)
+`
+
+func debugProgram(t *testing.T, commands string, breaks ...string) (string, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "main.sqd")
+	if err := os.WriteFile(file, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	d := New(file, strings.NewReader(commands), &out)
+	for _, b := range breaks {
+		if _, err := d.Break(b); err != nil {
+			t.Fatalf("Break(%q) returned error: %v", b, err)
+		}
+	}
+	err := d.Run()
+	return out.String(), err
+}
+
+func TestStepping(t *testing.T) {
+	out, err := debugProgram(t, "next\nnext\nstep\nstep\nout\nquit\n")
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	var paused []string
+	for _, line := range strings.Split(out, "\n") {
+		if i := strings.Index(line, "Paused at "); i >= 0 {
+			paused = append(paused, line[i+len("Paused at "):])
+		}
+	}
+	want := []string{"1 in <main>", "2 in <main>", "6 in <main>", "7 in <main>", "3 in add", "7 in <main>"}
+	if len(paused) != len(want) {
+		t.Fatalf("expected %d pauses, got %d:\n%s", len(want), len(paused), out)
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(paused[i], w) {
+			t.Fatalf("pause %d: expected %q, got %q", i, w, paused[i])
+		}
+	}
+	if strings.Contains(out, "(debug) 1") {
+		t.Fatalf("expected quit to stop the program, got:\n%s", out)
+	}
+}
+
+func TestBreakpointInspection(t *testing.T) {
+	out, err := debugProgram(t, "continue\nbt\nlocals\nprint total\nprint s\nframe 1\nlocals\ncontinue\nlocals\ndelete\ncontinue\n", "3")
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		"Paused at ",
+		">    3      var s = a + b\n",
+		"*#0  add at ",
+		" #1  <main> at ",
+		"(debug) a = 0\nb = 0\ns = null\n",
+		"(debug) 0\n(debug) null\n",
+		"(debug) No locals.\n",
+		"(debug) a = 0\nb = 1\ns = null\n",
+		"Deleted all breakpoints.\n(debug) 1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "Paused at "); n != 3 {
+		t.Fatalf("expected to pause 3 times, got %d:\n%s", n, out)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	out, err := debugProgram(t, "continue\nglobals\nquit\n", "9")
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if !strings.Contains(out, "(debug) add = Closure[") || !strings.Contains(out, "i = 2\ntotal = 1\n") {
+		t.Fatalf("expected the program's globals, got:\n%s", out)
+	}
+	if strings.Contains(out, "io = ") {
+		t.Fatalf("expected builtin classes to be left out, got:\n%s", out)
+	}
+}
+
+func TestMatchFile(t *testing.T) {
+	tests := []struct {
+		pattern, file string
+		want          bool
+	}{
+		{"main.sqd", "/tmp/x/main.sqd", true},
+		{"lib.sqd", "/tmp/x/main.sqd", false},
+		{"/tmp/x/main.sqd", "/tmp/x/main.sqd", true},
+		{"/tmp/y/main.sqd", "/tmp/x/main.sqd", false},
+	}
+	for _, tt := range tests {
+		if got := matchFile(tt.pattern, tt.file); got != tt.want {
+			t.Errorf("matchFile(%q, %q) = %v, want %v", tt.pattern, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	d := New("main.sqd", strings.NewReader(""), &bytes.Buffer{})
+	if bp, err := d.parseLocation("12"); err != nil || bp != (Breakpoint{"main.sqd", 12}) {
+		t.Fatalf("parseLocation(12) = %v, %v", bp, err)
+	}
+	if bp, err := d.parseLocation("lib/util.sqd:4"); err != nil || bp != (Breakpoint{"lib/util.sqd", 4}) {
+		t.Fatalf("parseLocation(lib/util.sqd:4) = %v, %v", bp, err)
+	}
+	for _, bad := range []string{"x", "main.sqd:", "0", ":3"} {
+		if _, err := d.parseLocation(bad); err == nil {
+			t.Fatalf("expected parseLocation(%q) to fail", bad)
+		}
+	}
+}
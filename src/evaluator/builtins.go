@@ -2,12 +2,14 @@ package evaluator
 
 import (
 	"squ1d++/object"
+	"sync"
 )
 
-var builtins map[string]*object.Builtin
-
-func init() {
-	builtins = make(map[string]*object.Builtin)
+// builtins maps the names of the builtins, bare and prefixed with their
+// class, to them. It is built on first use, after any builtins registered
+// by the host were added.
+var builtins = sync.OnceValue(func() map[string]*object.Builtin {
+	builtins := make(map[string]*object.Builtin)
 
 	for _, def := range object.Builtins {
 		builtins[def.Name] = def.Builtin
@@ -17,10 +19,11 @@ func init() {
 			builtins[classPrefix+def.Name] = def.Builtin
 		}
 	}
-}
+	return builtins
+})
 
 func GetBuiltin(name string) (*object.Builtin, bool) {
-	builtin, exists := builtins[name]
+	builtin, exists := builtins()[name]
 	return builtin, exists
 }
 
@@ -28,7 +31,7 @@ func GetBuiltinsByClass(class string) map[string]*object.Builtin {
 	classBuiltins := make(map[string]*object.Builtin)
 	classPrefix := class + "."
 
-	for name, builtin := range builtins {
+	for name, builtin := range builtins() {
 		if len(name) > len(classPrefix) && name[:len(classPrefix)] == classPrefix {
 			funcName := name[len(classPrefix):]
 			classBuiltins[funcName] = builtin
@@ -0,0 +1,39 @@
+package evaluator
+
+import (
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"testing"
+)
+
+func TestBuiltinsCallFunctions(t *testing.T) {
+	t.Cleanup(func() { object.EventHandlers = nil })
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"array.map([1, 2, 3], def(x) { x * 2 })", "[2, 4, 6]"},
+		{"var k = 1\narray.filter([1, 2, 3], def(x) { x > k })", "[2, 3]"},
+		{"array.reduce([1, 2, 3], def(s, x) { s + x }, 10)", "16"},
+		{"array.sort([3, 1, 2], def(a, b) { a > b })", "[3, 2, 1]"},
+		{"array.find([1, 2, 3], def(x) { x == 2 })", "2"},
+		{"var e = << array.map([1], def(x) { return y })\nerror.msg(e)", "Undefined variable y"},
+		{"sync.with(sync.mutex(), def() { 5 })", "5"},
+		{"var seen = {}\nevent.on(\"e\", def(x) { hash.set(seen, \"x\", x) })\nevent.emit(\"e\", 3)\nseen.x", "3"},
+		{"event.on(\"f\", def(a, b) { a })\nevent.emit(\"f\", 1)", "ERROR: Handler parameter mismatch for event 'f': expected 2, got 1"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		for name, class := range object.CreateClassObjects() {
+			env.Set(name, class)
+		}
+		evaluated := Eval(program, env)
+		if got := inspect(evaluated); got != tt.expected {
+			t.Errorf("%q: got %s, want %s", tt.input, got, tt.expected)
+		}
+	}
+}
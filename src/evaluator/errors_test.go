@@ -0,0 +1,45 @@
+package evaluator
+
+import (
+	"squ1d++/crash"
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"testing"
+)
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input        string
+		line, column int
+	}{
+		{"var a = 1\nvar b = a + missing", 2, 13},
+		{"var a = [1]\na[\"x\"]", 2, 2},
+		{"var f = def(x) { x + true }\nf(1)", 1, 20},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, object.NewEnvironment())
+		e, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("%q: expected an error, got %T", tt.input, evaluated)
+		}
+		if e.Line != tt.line || e.Column != tt.column {
+			t.Errorf("%q: error %q at line %d, column %d, want line %d, column %d",
+				tt.input, e.Message, e.Line, e.Column, tt.line, tt.column)
+		}
+	}
+}
+
+func TestEvalFileFault(t *testing.T) {
+	program := parser.New(lexer.New("var f = def(a) { a / 0 }\nf(2)")).ParseProgram()
+	_, err := EvalFile(program, object.NewEnvironment(), "div.sqd")
+	fault, ok := err.(*crash.Fault)
+	if !ok {
+		t.Fatalf("expected a *crash.Fault, got %T (%v)", err, err)
+	}
+	if fault.Engine != "evaluator" || fault.File != "div.sqd" || len(fault.GoStack) == 0 {
+		t.Errorf("wrong fault: engine=%s file=%s", fault.Engine, fault.File)
+	}
+}
@@ -3,10 +3,16 @@ package evaluator
 import (
 	"fmt"
 	"os"
+	"runtime/debug"
+	"sort"
 	"squ1d++/ast"
+	"squ1d++/crash"
 	"squ1d++/lexer"
 	"squ1d++/object"
 	"squ1d++/parser"
+	"squ1d++/pkg"
+	"squ1d++/std"
+	"squ1d++/token"
 	"strings"
 )
 
@@ -14,8 +20,25 @@ var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.BreakSignal{}
+	CONTINUE = &object.ContinueSignal{}
 )
 
+// EvalFile evaluates the program parsed from file. A panic while
+// evaluating it, such as a Go runtime error, is returned as a *crash.Fault.
+func EvalFile(program *ast.Program, env *object.Environment, file string) (result object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if !crash.IsFault(r) {
+				panic(r)
+			}
+			err = &crash.Fault{Value: r, GoStack: debug.Stack(), Engine: "evaluator", File: file}
+		}
+	}()
+	return Eval(program, env), nil
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
@@ -66,9 +89,26 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return nil
 		}
 
+		if err := checkAnnotation(node.Name.Type, node.Name.Value, val); err != nil {
+			return at(err, node.Name.Token)
+		}
 		env.Set(node.Name.Value, val)
 		return nil
 
+	case *ast.DestructureStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		elements, err := object.Unpack(val, len(node.Names))
+		if err != nil {
+			return newError("%s", err)
+		}
+		for i, name := range node.Names {
+			env.Set(name.Value, elements[i])
+		}
+		return nil
+
 	case *ast.SuppressStatement:
 		// Evaluate the inner statement or expression but always suppress
 		// any output or error (compiler/VM uses OpSuppress for this).
@@ -86,6 +126,29 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.WhileStatement:
 		return evalWhileLoop(node.Condition, node.Body, env)
 
+	case *ast.ForInStatement:
+		return evalForInLoop(node, env)
+
+	case *ast.ForStatement:
+		return evalForLoop(node, env)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
+	case *ast.BenchStatement:
+		// Benchmarks only run under `squ1dcc bench`.
+		return nil
+
+	case *ast.ClassStatement:
+		env.Set(node.Name.Value, evalClassStatement(node, env))
+		return nil
+
+	case *ast.ExportStatement:
+		return evalExportStatement(node, env)
+
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
@@ -110,7 +173,23 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) && node.Operator != "<<" && node.Operator != "<<<" {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return at(evalPrefixExpression(node.Operator, right), node.Token)
+
+	case *ast.AwaitExpression:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		await, _ := GetBuiltin("task.await")
+		return await.Fn(value)
+
+	case *ast.SpawnExpression:
+		fn := Eval(node.Function, env)
+		if isError(fn) {
+			return fn
+		}
+		spawn, _ := GetBuiltin("spawn")
+		return at(spawn.Fn(fn), node.Token)
 
 	case *ast.InfixExpression:
 		// Assignment operator: handle specially so we can set identifiers
@@ -142,31 +221,40 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 					return value
 				}
 
+				if err := object.CheckMutable(leftObj); err != nil {
+					return err
+				}
+
 				switch leftObj.Type() {
 				case object.ARRAY_OBJ:
 					arr := leftObj.(*object.Array)
 					if index.Type() != object.INTEGER_OBJ {
 						return newError("Index operator requires integer for arrays, got %s", index.Type())
 					}
-					idx := int(index.(*object.Integer).Value)
+					idx := object.NormalizeIndex(index.(*object.Integer).Value, len(arr.Elements))
 					if idx < 0 || idx >= len(arr.Elements) {
-						return newError("Index out of bounds: %d", idx)
+						return newError("Index out of bounds: %d", index.(*object.Integer).Value)
 					}
 					arr.Elements[idx] = value
 					return nil
 				case object.HASH_OBJ:
 					h := leftObj.(*object.Hash)
-					key, ok := index.(object.Hashable)
+					key, ok := object.HashKeyOf(index)
 					if !ok {
 						return newError("%s is unusable as a hash key", index.Type())
 					}
-					h.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+					h.Set(key, object.HashPair{Key: index, Value: value})
 					return nil
 				default:
 					return newError("Index operator is not supported: %s", leftObj.Type())
 				}
 			}
 
+			// Field assignment: e.g. self.x = x
+			if dotExpr, ok := node.Left.(*ast.DotExpression); ok {
+				return evalFieldAssignment(dotExpr, node.Right, env)
+			}
+
 			return newError("Invalid assignment target: %T", node.Left)
 		}
 
@@ -175,16 +263,35 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return left
 		}
 
+		// The right operand of and/or only runs when the left one doesn't
+		// decide the result.
+		switch node.Operator {
+		case "and", "ac":
+			if !isTruthy(left) {
+				return FALSE
+			}
+		case "or", "aut":
+			if isTruthy(left) {
+				return TRUE
+			}
+		}
+
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return at(evalInfixExpression(node.Operator, left, right), node.Token)
 
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+
+	case *ast.AttemptExpression:
+		return evalAttemptExpression(node, env)
+
 	case *ast.WhileExpression:
 		return evalWhileLoop(node.Condition, node.Body, env)
 
@@ -227,9 +334,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalIdentifier(node, env)
 
 	case *ast.FunctionLiteral:
+		if node.Async {
+			// Tasks run on the VM, which can't call evaluator functions.
+			return at(newError("async functions need compiled code; they can't be used in included files"), node.Token)
+		}
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, ReturnType: node.ReturnType, Env: env, Body: body}
 
 	case *ast.CallExpression:
 		// Special handling for pkg.include(filename, namespace)
@@ -257,7 +368,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 		args := evalExpressions(node.Arguments, env)
 
-		return applyFunction(function, args)
+		return at(applyFunction(function, args), node.Token)
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
@@ -272,11 +383,24 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return at(evalIndexExpression(left, index), node.Token)
+
+	case *ast.SliceExpression:
+		return at(evalSliceExpression(node, env), node.Token)
 
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 
+	case *ast.SetLiteral:
+		return evalSetLiteral(node, env)
+
+	case *ast.TupleLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &object.Tuple{Elements: elements}
+
 	case *ast.DotExpression:
 		return evalDotExpression(node, env)
 
@@ -296,6 +420,8 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.BreakSignal, *object.ContinueSignal:
+			return outsideLoop(result)
 		}
 	}
 
@@ -312,8 +438,8 @@ func evalBlockStatement(
 		result = Eval(statement, env)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_SIGNAL_OBJ, object.CONTINUE_SIGNAL_OBJ:
 				return result
 			}
 		}
@@ -560,6 +686,119 @@ func evalIfExpression(
 	}
 }
 
+func evalAttemptExpression(ae *ast.AttemptExpression, env *object.Environment) object.Object {
+	result := Eval(ae.Body, env)
+	if !isError(result) {
+		return result
+	}
+
+	if ae.Name != nil {
+		env.Set(ae.Name.Value, result)
+	}
+	return Eval(ae.Rescue, env)
+}
+
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		for _, pattern := range arm.Patterns {
+			matched, err := matchPattern(pattern, subject, env)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+			if arm.Guard != nil {
+				guard := Eval(arm.Guard, env)
+				if isError(guard) {
+					return guard
+				}
+				if !isTruthy(guard) {
+					break
+				}
+			}
+			return Eval(arm.Body, env)
+		}
+	}
+
+	if me.Default != nil {
+		return Eval(me.Default, env)
+	}
+	return NULL
+}
+
+// matchPattern reports whether value matches the pattern of a match arm,
+// binding the names in the pattern in env as it goes.
+func matchPattern(pattern ast.Expression, value object.Object, env *object.Environment) (bool, *object.Error) {
+	switch p := pattern.(type) {
+	case *ast.Identifier:
+		if p.Value != "_" {
+			env.Set(p.Value, value)
+		}
+		return true, nil
+
+	case *ast.ArrayLiteral:
+		array, ok := value.(*object.Array)
+		if !ok || len(array.Elements) != len(p.Elements) {
+			return false, nil
+		}
+		return matchElements(p.Elements, array.Elements, env)
+
+	case *ast.TupleLiteral:
+		tuple, ok := value.(*object.Tuple)
+		if !ok || len(tuple.Elements) != len(p.Elements) {
+			return false, nil
+		}
+		return matchElements(p.Elements, tuple.Elements, env)
+
+	case *ast.HashLiteral:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false, nil
+		}
+		for _, key := range p.Keys {
+			k := Eval(key, env)
+			hashKey, ok := object.HashKeyOf(k)
+			if !ok {
+				return false, newError("hash pattern keys must be constants, got %s", key.String())
+			}
+			pair, ok := hash.Pairs[hashKey]
+			if !ok {
+				return false, nil
+			}
+			if matched, err := matchPattern(p.Pairs[key], pair.Value, env); !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	want := Eval(pattern, env)
+	if isError(want) {
+		return false, want.(*object.Error)
+	}
+	wantKey, ok := object.HashKeyOf(want)
+	if !ok {
+		return false, newError("%s can't be used as a pattern", pattern.String())
+	}
+	key, ok := object.HashKeyOf(value)
+	return ok && key == wantKey && want.Inspect() == value.Inspect(), nil
+}
+
+func matchElements(patterns []ast.Expression, values []object.Object, env *object.Environment) (bool, *object.Error) {
+	for i, pattern := range patterns {
+		if matched, err := matchPattern(pattern, values[i], env); !matched || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 func evalWhileLoop(condition ast.Expression, body *ast.BlockStatement, env *object.Environment) object.Object {
 	for iteration := 0; ; iteration++ {
 		if iteration > object.SysMaxLoopIterations {
@@ -575,13 +814,97 @@ func evalWhileLoop(condition ast.Expression, body *ast.BlockStatement, env *obje
 			return NULL
 		}
 
-		result := Eval(body, env)
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result
+		if result, ok := evalLoopBody(body, env); !ok {
+			return result
+		}
+	}
+}
+
+// evalForLoop runs a `for (init; condition; update)` loop. A missing
+// condition is always true.
+func evalForLoop(node *ast.ForStatement, env *object.Environment) object.Object {
+	if node.Init != nil {
+		if init := Eval(node.Init, env); isError(init) {
+			return init
+		}
+	}
+
+	for iteration := 0; ; iteration++ {
+		if iteration > object.SysMaxLoopIterations {
+			return newError("Exceeded maximum loop iterations (%d). Possible infinite loop", object.SysMaxLoopIterations)
+		}
+
+		if node.Condition != nil {
+			cond := Eval(node.Condition, env)
+			if isError(cond) {
+				return cond
+			}
+			if !isTruthy(cond) {
+				return NULL
 			}
 		}
+
+		if result, ok := evalLoopBody(node.Body, env); !ok {
+			return result
+		}
+
+		if node.Update != nil {
+			if update := Eval(node.Update, env); isError(update) {
+				return update
+			}
+		}
+	}
+}
+
+// evalLoopBody runs one iteration of a loop body. It reports whether the
+// loop goes on and, when it stops, the value of the loop: null after a
+// break, or the return value or error that ended it.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := Eval(body, env)
+	if result == nil {
+		return nil, true
+	}
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, false
+	case object.BREAK_SIGNAL_OBJ:
+		return NULL, false
+	}
+	return nil, true
+}
+
+// outsideLoop is the error for a break or continue that no loop caught.
+func outsideLoop(signal object.Object) *object.Error {
+	return newError("%s statement not inside a loop", signal.Inspect())
+}
+
+func evalForInLoop(node *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	iterator, err := object.NewIterator(iterable)
+	if err != nil {
+		return at(newError("%s", err), node.Token)
+	}
+
+	for {
+		key, value, ok := iterator.Next()
+		if !ok {
+			return NULL
+		}
+		if len(node.Names) == 2 {
+			env.Set(node.Names[0].Value, key)
+			env.Set(node.Names[1].Value, value)
+		} else if iterator.Keyed {
+			env.Set(node.Names[0].Value, key)
+		} else {
+			env.Set(node.Names[0].Value, value)
+		}
+
+		if result, ok := evalLoopBody(node.Body, env); !ok {
+			return result
+		}
 	}
 }
 
@@ -598,10 +921,10 @@ func evalIdentifier(
 		if builtin.Class == "" {
 			return builtin
 		}
-		return newError("Builtin '%s' is in a class. Maybe use %s.%s instead.", node.Value, builtin.Class, node.Value)
+		return at(newError("Builtin '%s' is in a class. Maybe use %s.%s instead.", node.Value, builtin.Class, node.Value), node.Token)
 	}
 
-	return newError("Undefined variable %s", node.Value)
+	return at(newError("Undefined variable %s", node.Value), node.Token)
 }
 
 func isTruthy(obj object.Object) bool {
@@ -621,6 +944,16 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// at gives obj the position of tok when it is an error without one, so the
+// error points at the innermost expression that raised it.
+func at(obj object.Object, tok token.Token) object.Object {
+	if err, ok := obj.(*object.Error); ok && err.Line == 0 {
+		err.Line = tok.Line
+		err.Column = tok.Column
+	}
+	return obj
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -642,6 +975,15 @@ func evalExpressions(
 	return result
 }
 
+// Apply calls fn with args. It is the object.Caller of builtins run by the
+// evaluator; closures of compiled code run on the VM running it.
+func Apply(fn object.Object, args ...object.Object) object.Object {
+	if _, ok := fn.(*object.Closure); ok {
+		return object.ForkCall(fn, args...)
+	}
+	return applyFunction(fn, args)
+}
+
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 
@@ -651,16 +993,60 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 			return newError("Wrong number of arguments: expected %d, got %d", len(fn.Parameters), len(args))
 		}
 
+		for i, param := range fn.Parameters {
+			if err := checkAnnotation(param.Type, "Argument "+param.Value+" to function", args[i]); err != nil {
+				return err
+			}
+		}
+
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		result := Eval(fn.Body, extendedEnv)
+		switch result.(type) {
+		case *object.BreakSignal, *object.ContinueSignal:
+			return outsideLoop(result)
+		}
+		evaluated := unwrapReturnValue(result)
+		if err := checkAnnotation(fn.ReturnType, "The result of function", evaluated); err != nil {
+			return err
+		}
+		return evaluated
 
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
+		var result object.Object
+		if fn.Calling != nil {
+			result = fn.Calling(Apply, args...)
+		} else {
+			result = fn.Fn(args...)
+		}
+		if result != nil {
 			return result
 		}
 		return NULL
 
+	case *object.BoundMethod:
+		method, ok := fn.Method.(*object.Function)
+		if !ok {
+			return newError("%s is not a function.", fn.Method.Type())
+		}
+		if len(args) != len(method.Parameters)-1 {
+			return newError("Wrong number of arguments: expected %d, got %d", len(method.Parameters)-1, len(args))
+		}
+		return applyFunction(method, append([]object.Object{fn.Receiver}, args...))
+
+	case *object.Class:
+		instance := object.NewInstance(fn)
+		init, ok := instance.Get("init")
+		if !ok {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments: expected 0, got %d", len(args))
+			}
+			return instance
+		}
+		if result := applyFunction(init, args); isError(result) {
+			return result
+		}
+		return instance
+
 	default:
 		return newError("%s is not a function.", fn.Type())
 	}
@@ -679,6 +1065,21 @@ func extendFunctionEnv(
 	return env
 }
 
+// checkAnnotation returns an error when val doesn't match the type
+// annotation of subject, and nil when it does or there is no annotation.
+func checkAnnotation(annotation *ast.TypeAnnotation, subject string, val object.Object) object.Object {
+	if annotation == nil {
+		return nil
+	}
+	if !object.IsAnnotationType(annotation.Name) {
+		return at(newError("Unknown type %s", annotation.Name), annotation.Token)
+	}
+	if object.MatchesAnnotation(annotation.Name, val) {
+		return nil
+	}
+	return newError("%s", object.AnnotationMismatch(subject, annotation.Name, object.AnnotationOf(val)))
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -691,6 +1092,12 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalTupleIndexExpression(left, index)
+	case left.Type() == object.BYTES_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalBytesIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -698,56 +1105,130 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+// evalArrayIndexExpression returns an element of an array, counting from
+// the end for negative indexes, or null when there is no such element.
+// Tuples, bytes and strings are indexed the same way.
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+	idx := object.NormalizeIndex(index.(*object.Integer).Value, len(arrayObject.Elements))
 
-	if idx < 0 || idx > max {
+	if idx < 0 || idx >= len(arrayObject.Elements) {
 		return NULL
 	}
 
 	return arrayObject.Elements[idx]
 }
 
+// evalStringIndexExpression returns the character at index as a string.
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	runes := []rune(str.(*object.String).Value)
+	idx := object.NormalizeIndex(index.(*object.Integer).Value, len(runes))
+
+	if idx < 0 || idx >= len(runes) {
+		return NULL
+	}
+
+	return &object.String{Value: string(runes[idx])}
+}
+
+// evalSliceExpression evaluates left[start:end].
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	bounds := []object.Object{NULL, NULL}
+	for i, bound := range []ast.Expression{node.Start, node.End} {
+		if bound == nil {
+			continue
+		}
+		bounds[i] = Eval(bound, env)
+		if isError(bounds[i]) {
+			return bounds[i]
+		}
+	}
+
+	slice, err := object.Slice(left, bounds[0], bounds[1])
+	if err != nil {
+		return newError("%s", err)
+	}
+	return slice
+}
+
 func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
 
-	for keyNode, valueNode := range node.Pairs {
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashed, ok := object.HashKeyOf(key)
 		if !ok {
 			return newError("%s is unusable as a hash key", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashed, object.HashPair{Key: key, Value: value})
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
+}
+
+func evalSetLiteral(node *ast.SetLiteral, env *object.Environment) object.Object {
+	elements := evalExpressions(node.Elements, env)
+	if len(elements) == 1 && isError(elements[0]) {
+		return elements[0]
+	}
+
+	set := object.NewSet()
+	for _, el := range elements {
+		if err := set.Add(el); err != nil {
+			return newError("%s is unusable as a set element", el.Type())
+		}
+	}
+	return set
+}
+
+func evalTupleIndexExpression(tuple, index object.Object) object.Object {
+	tupleObject := tuple.(*object.Tuple)
+	idx := object.NormalizeIndex(index.(*object.Integer).Value, len(tupleObject.Elements))
+
+	if idx < 0 || idx >= len(tupleObject.Elements) {
+		return NULL
+	}
+
+	return tupleObject.Elements[idx]
+}
+
+func evalBytesIndexExpression(bytes, index object.Object) object.Object {
+	bytesObject := bytes.(*object.Bytes)
+	idx := object.NormalizeIndex(index.(*object.Integer).Value, len(bytesObject.Value))
+
+	if idx < 0 || idx >= len(bytesObject.Value) {
+		return NULL
+	}
+
+	return &object.Integer{Value: int64(bytesObject.Value[idx])}
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.HashKeyOf(index)
 	if !ok {
 		return newError("%s is unusable as a hash key", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Pairs[key]
 	if !ok {
 		return NULL
 	}
@@ -794,6 +1275,26 @@ func evalDotExpression(node *ast.DotExpression, env *object.Environment) object.
 		return newError("Property '%s' not found", key)
 	}
 
+	// Fields and methods of instances; like hashes, missing names give null.
+	if instance, ok := left.(*object.Instance); ok {
+		name, ok := node.Right.(*ast.StringLiteral)
+		if !ok {
+			return newError("Expected identifier or string after dot, got %T", node.Right)
+		}
+		if value, ok := instance.Get(name.Value); ok {
+			return value
+		}
+		return NULL
+	}
+
+	// Name.new is the class itself: calling a class creates an instance.
+	if class, ok := left.(*object.Class); ok {
+		if name, ok := node.Right.(*ast.StringLiteral); ok && name.Value == "new" {
+			return class
+		}
+		return newError("Class %s has no field %s; only %s.new", class.Name, node.Right.String(), class.Name)
+	}
+
 	// Handle direct builtin access like math.abs
 	if left.Type() == object.STRING_OBJ {
 		leftStr := left.(*object.String).Value
@@ -820,6 +1321,53 @@ func evalDotExpression(node *ast.DotExpression, env *object.Environment) object.
 	return newError("Dot operator not supported for type %s", left.Type())
 }
 
+// evalClassStatement makes the class a class statement defines. Its methods
+// take self before their parameters.
+func evalClassStatement(node *ast.ClassStatement, env *object.Environment) *object.Class {
+	class := &object.Class{Name: node.Name.Value, Methods: map[string]object.Object{}}
+	for _, m := range node.Methods {
+		self := &ast.Identifier{Token: m.Token, Value: "self"}
+		class.Methods[m.Name] = &object.Function{
+			Parameters: append([]*ast.Identifier{self}, m.Parameters...),
+			ReturnType: m.ReturnType,
+			Env:        env,
+			Body:       m.Body,
+		}
+	}
+	return class
+}
+
+func evalFieldAssignment(target *ast.DotExpression, value ast.Expression, env *object.Environment) object.Object {
+	left := Eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+	name, ok := target.Right.(*ast.StringLiteral)
+	if !ok {
+		return newError("Expected identifier or string after dot, got %T", target.Right)
+	}
+	val := Eval(value, env)
+	if isError(val) {
+		return val
+	}
+
+	switch left := left.(type) {
+	case *object.Instance:
+		if err := left.SetField(name.Value, val); err != nil {
+			return err
+		}
+	case *object.Hash:
+		if err := object.CheckMutable(left); err != nil {
+			return err
+		}
+		key := &object.String{Value: name.Value}
+		left.Set(key.HashKey(), object.HashPair{Key: key, Value: val})
+	default:
+		return newError("Cannot set field %s of %s", name.Value, left.Type())
+	}
+	return nil
+}
+
 func isErrorPipeExpression(node ast.Node) bool {
 	if prefix, ok := node.(*ast.PrefixExpression); ok {
 		return prefix.Operator == "<<"
@@ -858,6 +1406,10 @@ func findUndefinedInNode(node ast.Node, env *object.Environment, params map[stri
 		return findUndefinedInNode(n.ReturnValue, env, params)
 	case *ast.PrefixExpression:
 		return findUndefinedInNode(n.Right, env, params)
+	case *ast.AwaitExpression:
+		return findUndefinedInNode(n.Value, env, params)
+	case *ast.SpawnExpression:
+		return findUndefinedInNode(n.Function, env, params)
 	case *ast.InfixExpression:
 		if err := findUndefinedInNode(n.Left, env, params); err != nil {
 			return err
@@ -873,6 +1425,44 @@ func findUndefinedInNode(node ast.Node, env *object.Environment, params map[stri
 		if n.Alternative != nil {
 			return findUndefinedInNode(n.Alternative, env, params)
 		}
+	case *ast.MatchExpression:
+		if err := findUndefinedInNode(n.Subject, env, params); err != nil {
+			return err
+		}
+		for _, arm := range n.Arms {
+			// Names in the patterns are bound by the arm.
+			bound := make(map[string]bool, len(params))
+			for name := range params {
+				bound[name] = true
+			}
+			for _, pattern := range arm.Patterns {
+				patternNames(pattern, bound)
+			}
+			if arm.Guard != nil {
+				if err := findUndefinedInNode(arm.Guard, env, bound); err != nil {
+					return err
+				}
+			}
+			if err := findUndefinedInNode(arm.Body, env, bound); err != nil {
+				return err
+			}
+		}
+		if n.Default != nil {
+			return findUndefinedInNode(n.Default, env, params)
+		}
+	case *ast.AttemptExpression:
+		if err := findUndefinedInNode(n.Body, env, params); err != nil {
+			return err
+		}
+		bound := params
+		if n.Name != nil {
+			bound = make(map[string]bool, len(params)+1)
+			for name := range params {
+				bound[name] = true
+			}
+			bound[n.Name.Value] = true
+		}
+		return findUndefinedInNode(n.Rescue, env, bound)
 	case *ast.FunctionLiteral:
 		// For nested functions, we don't treat identifiers in the body as
 		// undefined here because they may be resolved when the nested
@@ -892,12 +1482,33 @@ func findUndefinedInNode(node ast.Node, env *object.Environment, params map[stri
 			return err
 		}
 		return findUndefinedInNode(n.Index, env, params)
+	case *ast.SliceExpression:
+		for _, e := range []ast.Expression{n.Left, n.Start, n.End} {
+			if e == nil {
+				continue
+			}
+			if err := findUndefinedInNode(e, env, params); err != nil {
+				return err
+			}
+		}
 	case *ast.ArrayLiteral:
 		for _, el := range n.Elements {
 			if err := findUndefinedInNode(el, env, params); err != nil {
 				return err
 			}
 		}
+	case *ast.SetLiteral:
+		for _, el := range n.Elements {
+			if err := findUndefinedInNode(el, env, params); err != nil {
+				return err
+			}
+		}
+	case *ast.TupleLiteral:
+		for _, el := range n.Elements {
+			if err := findUndefinedInNode(el, env, params); err != nil {
+				return err
+			}
+		}
 	case *ast.HashLiteral:
 		for k, v := range n.Pairs {
 			if err := findUndefinedInNode(k, env, params); err != nil {
@@ -911,6 +1522,53 @@ func findUndefinedInNode(node ast.Node, env *object.Environment, params map[stri
 	return nil
 }
 
+// patternNames adds the names bound by a match pattern to names.
+func patternNames(pattern ast.Expression, names map[string]bool) {
+	switch p := pattern.(type) {
+	case *ast.Identifier:
+		names[p.Value] = true
+	case *ast.ArrayLiteral:
+		for _, el := range p.Elements {
+			patternNames(el, names)
+		}
+	case *ast.TupleLiteral:
+		for _, el := range p.Elements {
+			patternNames(el, names)
+		}
+	case *ast.HashLiteral:
+		for _, value := range p.Pairs {
+			patternNames(value, names)
+		}
+	}
+}
+
+// evalExportStatement runs the definition of an export statement and marks
+// its names as exported from the file env belongs to.
+func evalExportStatement(node *ast.ExportStatement, env *object.Environment) object.Object {
+	for _, name := range node.Names {
+		if object.IsPrivateName(name.Value) {
+			return at(newError("%s is private and can't be exported", name.Value), name.Token)
+		}
+	}
+
+	if node.Statement != nil {
+		if result := Eval(node.Statement, env); isError(result) {
+			return result
+		}
+	} else {
+		for _, name := range node.Names {
+			if _, ok := env.GetStore()[name.Value]; !ok {
+				return at(newError("can't export %s; it isn't defined", name.Value), name.Token)
+			}
+		}
+	}
+
+	for _, name := range node.Names {
+		env.Export(name.Value)
+	}
+	return nil
+}
+
 // evalPkgInclude evaluates pkg.include(filename, namespace) which loads a file
 // and makes its functions available under a namespace
 func evalPkgInclude(node *ast.CallExpression, env *object.Environment) object.Object {
@@ -952,12 +1610,33 @@ func evalPkgInclude(node *ast.CallExpression, env *object.Environment) object.Ob
 		return &object.Null{}
 	}
 
+	// Installed packages ("name" or "name@constraint") resolve to their
+	// main.sqd when no such file exists locally.
+	// Standard library modules ("std/name") are embedded in the binary.
+	path := filename.Value
+	if name, ok := std.Resolve(path); ok {
+		path = name
+	} else if _, statErr := os.Stat(path); statErr != nil {
+		pkgMain, found, err := pkg.GlobalManager.ResolveInclude(path)
+		if err != nil {
+			return newError("%v", err)
+		}
+		if found {
+			path = pkgMain
+		}
+	}
+
 	// Read the file
-	content, err := os.ReadFile(filename.Value)
+	content, err := std.ReadFile(path)
 	if err != nil {
 		return newError("Failed to read file '%s': %v", filename.Value, err)
 	}
 
+	if err := pkg.Includes.Enter(path, node.Token.Line, node.Token.Column); err != nil {
+		return newError("%v", err)
+	}
+	defer pkg.Includes.Leave()
+
 	// Parse the file
 	l := lexer.New(string(content))
 	p := parser.New(l)
@@ -981,14 +1660,40 @@ func evalPkgInclude(node *ast.CallExpression, env *object.Environment) object.Ob
 	// and create a Hash to represent the namespace
 	nsHash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
 
+	// A file with export statements exposes exactly the names it exports
+	if exports := includeEnv.Exports(); exports != nil {
+		names := make([]string, 0, len(exports))
+		for name := range exports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key := &object.String{Value: name}
+			value, _ := includeEnv.Get(name)
+			nsHash.Set(key.HashKey(), object.HashPair{Key: key, Value: value})
+		}
+		env.Set(namespace.Value, nsHash)
+		return &object.Null{}
+	}
+
 	// Get all keys from the include environment's store (not outer)
-	for name, obj := range includeEnv.GetStore() {
+	store := includeEnv.GetStore()
+	names := make([]string, 0, len(store))
+	for name := range store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		obj := store[name]
+		if object.IsPrivateName(name) {
+			continue
+		}
 		// Skip internal/builtin variables - only include user-defined functions
 		// We check if it's a Function or Builtin that was defined in this file
 		switch obj.(type) {
 		case *object.Function, *object.Builtin:
 			key := &object.String{Value: name}
-			nsHash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: obj}
+			nsHash.Set(key.HashKey(), object.HashPair{Key: key, Value: obj})
 		}
 	}
 
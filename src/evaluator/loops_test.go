@@ -0,0 +1,54 @@
+package evaluator
+
+import (
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"testing"
+)
+
+func TestLoopBreakContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"var i = 0\nwhile (true) { i = i + 1; if (i == 5) { break } }\ni", 5},
+		{"var s = 0\nvar i = 0\nwhile (i < 10) { i = i + 1; if (i == 2 or i == 4) { continue }; s = s + i }\ns", 49},
+		{"var s = 0\nfor (var i = 0; i < 10; i = i + 1) { if (i == 3) { continue }; if (i == 6) { break }; s = s + i }\ns", 12},
+		{"var s = 0\nfor (x in [1, 2, 3, 4]) { if (x == 2) { continue }; if (x == 4) { break }; s = s + x }\ns", 4},
+		{"var n = 0\nfor (var i = 0; i < 3; i = i + 1) { for (var j = 0; j < 3; j = j + 1) { if (j == 1) { break }; n = n + 1 } }\nn", 3},
+		{"var f = def() { while (true) { return 7 } }\nf()", 7},
+		{"var n = 0\nfor (; n < 4; ) { n = n + 1 }\nn", 4},
+		{"var n = 0\nfor (;;) { n = n + 1; if (n == 3) { break } }\nn", 3},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, object.NewEnvironment())
+		integer, ok := evaluated.(*object.Integer)
+		if !ok {
+			t.Errorf("%q: expected an integer, got %T (%s)", tt.input, evaluated, inspect(evaluated))
+			continue
+		}
+		if integer.Value != tt.expected {
+			t.Errorf("%q: got %d, want %d", tt.input, integer.Value, tt.expected)
+		}
+	}
+}
+
+func TestBreakOutsideLoop(t *testing.T) {
+	for _, input := range []string{"break", "var f = def() { continue }\nf()"} {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		evaluated := Eval(program, object.NewEnvironment())
+		if _, ok := evaluated.(*object.Error); !ok {
+			t.Errorf("%q: expected an error, got %T (%s)", input, evaluated, inspect(evaluated))
+		}
+	}
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "nil"
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,137 @@
+package format
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Run implements `squ1dcc fmt [--check] [paths...]`. Files are rewritten in
+// place and the names of changed files printed; directories are searched
+// for .sqd files. Without paths, stdin is formatted to stdout. With --check
+// nothing is written: a diff is printed for every file that isn't formatted
+// and the exit status is 1 if there was any.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(stderr) }
+
+	check := fs.Bool("check", false, "Print a diff for unformatted files instead of rewriting them")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		src, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "fmt: %v\n", err)
+			return 1
+		}
+		return formatOne("<stdin>", src, *check, stdout, stderr, func(out []byte) error {
+			_, err := stdout.Write(out)
+			return err
+		})
+	}
+
+	files, err := sourceFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "fmt: %v\n", err)
+		return 1
+	}
+
+	status := 0
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "fmt: %v\n", err)
+			status = 1
+			continue
+		}
+		code := formatOne(file, src, *check, stdout, stderr, func(out []byte) error {
+			if string(out) == string(src) {
+				return nil
+			}
+			if err := os.WriteFile(file, out, 0644); err != nil {
+				return err
+			}
+			fmt.Fprintln(stdout, file)
+			return nil
+		})
+		status = max(status, code)
+	}
+	return status
+}
+
+// formatOne formats src, named name in messages, and hands the result to
+// write, or prints its diff in check mode.
+func formatOne(name string, src []byte, check bool, stdout, stderr io.Writer, write func([]byte) error) int {
+	out, err := Source(src)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	if check {
+		if diff := Diff(name, src, out); diff != "" {
+			fmt.Fprint(stdout, diff)
+			return 1
+		}
+		return 0
+	}
+
+	if err := write(out); err != nil {
+		fmt.Fprintf(stderr, "fmt: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// sourceFiles expands paths into .sqd files, walking directories and
+// skipping hidden ones.
+func sourceFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if p != path && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(p, ".sqd") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: squ1dcc fmt [--check] [file.sqd | dir ...]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Reformats .sqd files in place and lists the files it changed.")
+	fmt.Fprintln(w, "Directories are searched for .sqd files; without paths, stdin is")
+	fmt.Fprintln(w, "formatted to stdout.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  --check   print a diff for files that aren't formatted and exit 1")
+}
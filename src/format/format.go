@@ -0,0 +1,653 @@
+// Package format reprints SQU1DLang source in its canonical layout: four
+// space indentation, one statement per line, braces on the line that opens
+// them and only the parentheses and semicolons the parser needs. Comments
+// and single blank lines between statements are kept.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"squ1d++/ast"
+	"squ1d++/lexer"
+	"squ1d++/parser"
+	"squ1d++/token"
+	"strings"
+)
+
+const indentUnit = "    "
+
+// Source formats a .sqd program. Formatting is idempotent: formatting the
+// result again returns it unchanged. It fails when src doesn't parse.
+func Source(src []byte) ([]byte, error) {
+	l := lexer.New(string(src))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", strings.Join(p.Errors(), "\n"))
+	}
+
+	pr := &printer{
+		lines:    strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n"),
+		comments: l.Comments(),
+		closing:  closingLines(string(src)),
+	}
+	pr.statements(program.Statements, math.MaxInt)
+
+	out := bytes.TrimLeft(pr.buf.Bytes(), "\n")
+	if len(out) == 0 {
+		return []byte{}, nil
+	}
+	return append(out, '\n'), nil
+}
+
+// closingLines maps the position of every { in src to the line of its }.
+func closingLines(src string) map[[2]int]int {
+	closing := map[[2]int]int{}
+	var open [][2]int
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LBRACE:
+			open = append(open, [2]int{tok.Line, tok.Column})
+		case token.RBRACE:
+			if n := len(open); n > 0 {
+				closing[open[n-1]] = tok.Line
+				open = open[:n-1]
+			}
+		}
+	}
+	return closing
+}
+
+type printer struct {
+	buf      bytes.Buffer
+	lines    []string
+	comments []token.Token
+	closing  map[[2]int]int
+	indent   int
+	// blockStart is set until the first line of a block has been written,
+	// so blocks never start with a blank line.
+	blockStart bool
+	// lastLine is the source line of the last statement or comment written.
+	lastLine int
+}
+
+func (p *printer) write(s string) {
+	p.buf.WriteString(s)
+}
+
+// newline ends the current line and indents the next one.
+func (p *printer) newline() {
+	p.write("\n" + strings.Repeat(indentUnit, p.indent))
+}
+
+// blankBefore reports whether the source line before line is empty.
+func (p *printer) blankBefore(line int) bool {
+	return line >= 2 && line-2 < len(p.lines) && strings.TrimSpace(p.lines[line-2]) == ""
+}
+
+// startLine starts the output line for the statement or comment on the
+// given source line, keeping one blank line where the source had any.
+func (p *printer) startLine(line int) {
+	if !p.blockStart && p.buf.Len() > 0 && line > p.lastLine && p.blankBefore(line) {
+		p.write("\n")
+	}
+	p.newline()
+	p.blockStart = false
+	p.lastLine = line
+}
+
+// flushComments writes the comments that start before line. Comments that
+// followed code on their line stay at the end of the last line written.
+func (p *printer) flushComments(line int) {
+	for len(p.comments) > 0 && p.comments[0].Line < line {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		text := strings.TrimRight(c.Literal, " \t\r")
+
+		if p.trailing(c) && p.buf.Len() > 0 {
+			p.write(" " + text)
+			continue
+		}
+		p.startLine(c.Line)
+		p.write(text)
+	}
+}
+
+// trailing reports whether code precedes comment c on its line.
+func (p *printer) trailing(c token.Token) bool {
+	if c.Line < 1 || c.Line > len(p.lines) {
+		return false
+	}
+	src := strings.TrimRight(p.lines[c.Line-1], "\r")
+	idx := strings.LastIndex(src, strings.TrimRight(c.Literal, "\r"))
+	return idx > 0 && strings.TrimSpace(src[:idx]) != ""
+}
+
+// statements writes a statement list followed by the comments before end,
+// the line of the } closing the list.
+func (p *printer) statements(stmts []ast.Statement, end int) {
+	for i, stmt := range stmts {
+		line := statementToken(stmt).Line
+		p.flushComments(line)
+		p.startLine(line)
+		p.statement(stmt)
+		if i+1 < len(stmts) && needsSemicolon(stmts[i+1]) {
+			p.write(";")
+		}
+	}
+	p.flushComments(end)
+}
+
+// needsSemicolon reports whether stmt would continue the statement before it
+// if they were only separated by a newline, e.g. `(a)` turning into a call.
+func needsSemicolon(stmt ast.Statement) bool {
+	switch statementToken(stmt).Type {
+	case token.LPAREN, token.LBRACKET, token.LBRACE, token.MINUS:
+		return true
+	}
+	return false
+}
+
+// statementToken returns the first token of stmt.
+func statementToken(stmt ast.Statement) token.Token {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		if fn, ok := s.Value.(*ast.FunctionLiteral); ok && s.Token.Type != token.LET {
+			return fn.Token
+		}
+		return s.Token
+	case *ast.DestructureStatement:
+		return s.Token
+	case *ast.ReturnStatement:
+		return s.Token
+	case *ast.ExpressionStatement:
+		return s.Token
+	case *ast.WhileStatement:
+		return s.Token
+	case *ast.ForStatement:
+		return s.Token
+	case *ast.ForInStatement:
+		return s.Token
+	case *ast.BreakStatement:
+		return s.Token
+	case *ast.ContinueStatement:
+		return s.Token
+	case *ast.SuppressStatement:
+		return s.Token
+	case *ast.BlockDirective:
+		return s.Token
+	case *ast.BenchStatement:
+		return s.Token
+	case *ast.ClassStatement:
+		return s.Token
+	case *ast.ExportStatement:
+		return s.Token
+	case *ast.BlockStatement:
+		return s.Token
+	}
+	return token.Token{}
+}
+
+func (p *printer) statement(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		p.let(s)
+	case *ast.DestructureStatement:
+		p.write("var (")
+		for i, name := range s.Names {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(name.Value)
+		}
+		p.write(") = ")
+		p.expression(s.Value, parser.LOWEST)
+	case *ast.ReturnStatement:
+		p.write("return")
+		if s.ReturnValue != nil {
+			p.write(" ")
+			p.expression(s.ReturnValue, parser.LOWEST)
+		}
+	case *ast.ExpressionStatement:
+		if s.Expression != nil {
+			p.expression(s.Expression, parser.LOWEST)
+		}
+	case *ast.WhileStatement:
+		p.write("while ")
+		if b, ok := s.Condition.(*ast.Boolean); !ok || b.Token.Type != token.WHILE {
+			p.write("(")
+			p.expression(s.Condition, parser.LOWEST)
+			p.write(") ")
+		}
+		p.block(s.Body)
+	case *ast.ForStatement:
+		p.write("for (")
+		if s.Init != nil {
+			p.statement(s.Init)
+		}
+		p.write("; ")
+		if s.Condition != nil {
+			p.expression(s.Condition, parser.LOWEST)
+		}
+		p.write("; ")
+		if s.Update != nil {
+			p.expression(s.Update, parser.LOWEST)
+		}
+		p.write(") ")
+		p.block(s.Body)
+	case *ast.ForInStatement:
+		p.write("for (")
+		for i, name := range s.Names {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(name.Value)
+		}
+		p.write(" in ")
+		p.expression(s.Iterable, parser.LOWEST)
+		p.write(") ")
+		p.block(s.Body)
+	case *ast.BreakStatement:
+		p.write("break")
+	case *ast.ContinueStatement:
+		p.write("continue")
+	case *ast.SuppressStatement:
+		p.write("suppress ")
+		p.wrapped(s.Statement, s.Expression)
+	case *ast.BlockDirective:
+		p.write("block ")
+		p.wrapped(s.Statement, s.Expression)
+	case *ast.BenchStatement:
+		p.write("bench " + quote(&ast.StringLiteral{Token: token.Token{Type: token.STRING}, Value: s.Name}) + " ")
+		p.block(s.Body)
+	case *ast.ClassStatement:
+		p.class(s)
+	case *ast.ExportStatement:
+		p.write("export ")
+		if s.Statement != nil {
+			p.statement(s.Statement)
+			break
+		}
+		for i, name := range s.Names {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(name.Value)
+		}
+	case *ast.BlockStatement:
+		p.block(s)
+	default:
+		p.write(stmt.String())
+	}
+}
+
+// wrapped writes the statement or expression a suppress or block prefix
+// applies to.
+func (p *printer) wrapped(stmt ast.Statement, expr ast.Expression) {
+	if stmt != nil {
+		p.statement(stmt)
+	} else if expr != nil {
+		p.expression(expr, parser.LOWEST)
+	}
+}
+
+func (p *printer) let(s *ast.LetStatement) {
+	if fn, ok := s.Value.(*ast.FunctionLiteral); ok && s.Token.Type != token.LET {
+		// name >> (params) { ... }
+		if fn.Async {
+			p.write("async ")
+		}
+		p.write(s.Name.Value + " >> ")
+		p.parameters(fn.Parameters, fn.ReturnType)
+		p.write(" ")
+		p.block(fn.Body)
+		return
+	}
+
+	if s.Unblock {
+		p.write("unblock ")
+	}
+	p.write("var " + annotated(s.Name) + " = ")
+	if s.ErrorPipe {
+		p.write("<< ")
+	}
+	if s.Value != nil {
+		p.expression(s.Value, parser.LOWEST)
+	}
+}
+
+// class writes a class definition with one method per line.
+func (p *printer) class(s *ast.ClassStatement) {
+	p.write("class " + s.Name.Value + " ")
+	if len(s.Methods) == 0 {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.indent++
+	p.blockStart = true
+	for _, m := range s.Methods {
+		p.flushComments(m.Token.Line)
+		p.startLine(m.Token.Line)
+		p.write("def " + m.Name)
+		p.parameters(m.Parameters, m.ReturnType)
+		p.write(" ")
+		p.block(m.Body)
+	}
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+// block writes a braced statement list, ending on the closing brace.
+func (p *printer) block(b *ast.BlockStatement) {
+	if b == nil {
+		p.write("{}")
+		return
+	}
+	end := p.closing[[2]int{b.Token.Line, b.Token.Column}]
+	hasComments := len(p.comments) > 0 && p.comments[0].Line < end
+	if len(b.Statements) == 0 && !hasComments {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.indent++
+	p.blockStart = true
+	p.statements(b.Statements, end)
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+func (p *printer) parameters(params []*ast.Identifier, result *ast.TypeAnnotation) {
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = annotated(param)
+	}
+	p.write("(" + strings.Join(names, ", ") + ")")
+	if result != nil {
+		p.write(": " + result.Name)
+	}
+}
+
+// annotated is the name of ident followed by its type annotation, if any.
+func annotated(ident *ast.Identifier) string {
+	if ident.Type == nil {
+		return ident.Value
+	}
+	return ident.Value + ": " + ident.Type.Name
+}
+
+// postfix is the binding of member access, calls and indexing, which chain
+// left to right.
+const postfix = parser.DOT
+
+// atom is the binding of expressions that never need parentheses.
+const atom = math.MaxInt
+
+var infixPrecedence = map[string]int{
+	"or":  parser.OR,
+	"and": parser.AND,
+	"=":   parser.EQUALS,
+	"==":  parser.EQUALS,
+	"!=":  parser.EQUALS,
+	"<":   parser.LESSGREATER,
+	">":   parser.LESSGREATER,
+	"<=":  parser.LESSGREATER,
+	">=":  parser.LESSGREATER,
+	"+":   parser.SUM,
+	"-":   parser.SUM,
+	"*":   parser.PRODUCT,
+	"/":   parser.PRODUCT,
+	"%":   parser.PRODUCT,
+}
+
+// grouped reports whether the source wrapped expr in parentheses.
+func grouped(expr ast.Expression) bool {
+	switch e := expr.(type) {
+	case *ast.InfixExpression:
+		return e.Grouped
+	case *ast.PrefixExpression:
+		return e.Grouped
+	}
+	return false
+}
+
+// precedence returns how tightly expr binds.
+func precedence(expr ast.Expression) int {
+	switch e := expr.(type) {
+	case *ast.InfixExpression:
+		if prec, ok := infixPrecedence[e.Operator]; ok {
+			return prec
+		}
+		return parser.LOWEST
+	case *ast.PrefixExpression, *ast.AwaitExpression, *ast.SpawnExpression:
+		return parser.PREFIX
+	case *ast.DotExpression, *ast.CallExpression, *ast.IndexExpression, *ast.SliceExpression:
+		return postfix
+	}
+	return atom
+}
+
+// expression writes expr, parenthesized when it binds less tightly than
+// its context requires or the source grouped it.
+func (p *printer) expression(expr ast.Expression, context int) {
+	if precedence(expr) < context || grouped(expr) {
+		p.write("(")
+		defer p.write(")")
+	}
+
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.HexLiteral, *ast.Boolean, *ast.Null:
+		p.write(e.TokenLiteral())
+	case *ast.StringLiteral:
+		p.write(quote(e))
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		if e.Operator == "<<" || e.Operator == "<<<" {
+			p.write(" ")
+		}
+		p.expression(e.Right, parser.PREFIX)
+	case *ast.AwaitExpression:
+		p.write("await ")
+		p.expression(e.Value, parser.PREFIX)
+	case *ast.SpawnExpression:
+		p.write("spawn ")
+		p.expression(e.Function, parser.PREFIX)
+	case *ast.InfixExpression:
+		prec := precedence(e)
+		p.expression(e.Left, prec)
+		p.write(" " + e.Operator + " ")
+		p.expression(e.Right, prec+1)
+	case *ast.DotExpression:
+		p.expression(e.Left, postfix)
+		p.write(".")
+		if name, ok := e.Right.(*ast.StringLiteral); ok && name.Token.Line == 0 {
+			// The parser stores `a.name` as a synthetic string literal.
+			p.write(name.Value)
+		} else {
+			p.expression(e.Right, atom)
+		}
+	case *ast.CallExpression:
+		p.expression(e.Function, postfix)
+		p.list("(", e.Arguments, ")")
+		if e.Block != nil {
+			p.write(" ")
+			p.block(e.Block)
+		}
+	case *ast.IndexExpression:
+		p.expression(e.Left, postfix)
+		p.write("[")
+		p.expression(e.Index, parser.LOWEST)
+		p.write("]")
+	case *ast.SliceExpression:
+		p.expression(e.Left, postfix)
+		p.write("[")
+		if e.Start != nil {
+			p.expression(e.Start, parser.LOWEST)
+		}
+		p.write(":")
+		if e.End != nil {
+			p.expression(e.End, parser.LOWEST)
+		}
+		p.write("]")
+	case *ast.ArrayLiteral:
+		p.list("[", e.Elements, "]")
+	case *ast.HashLiteral:
+		p.hash(e)
+	case *ast.SetLiteral:
+		p.list("{", e.Elements, "}")
+	case *ast.TupleLiteral:
+		if len(e.Elements) == 1 {
+			p.list("(", e.Elements, ",)")
+		} else {
+			p.list("(", e.Elements, ")")
+		}
+	case *ast.FunctionLiteral:
+		if e.Async {
+			p.write("async ")
+		}
+		p.write("def")
+		p.parameters(e.Parameters, e.ReturnType)
+		p.write(" ")
+		p.block(e.Body)
+	case *ast.IfExpression:
+		p.ifExpression(e)
+	case *ast.MatchExpression:
+		p.matchExpression(e)
+	case *ast.AttemptExpression:
+		p.write("attempt ")
+		p.block(e.Body)
+		p.write(" rescue ")
+		if e.Name != nil {
+			p.write("(" + e.Name.Value + ") ")
+		}
+		p.block(e.Rescue)
+	case *ast.WhileExpression:
+		p.write("while (")
+		p.expression(e.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(e.Body)
+	default:
+		p.write(expr.String())
+	}
+}
+
+func (p *printer) list(open string, items []ast.Expression, close string) {
+	p.write(open)
+	for i, item := range items {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expression(item, parser.LOWEST)
+	}
+	p.write(close)
+}
+
+// hash writes a hash literal with its pairs in source order.
+func (p *printer) hash(h *ast.HashLiteral) {
+	p.write("{")
+	for i, key := range h.Keys {
+		if i > 0 {
+			p.write(", ")
+		}
+		if name, ok := key.(*ast.StringLiteral); ok && name.Token.Type == token.IDENT {
+			// Bare keys ({name: 1}) are stored as string literals.
+			p.write(name.Value)
+		} else {
+			p.expression(key, parser.LOWEST)
+		}
+		p.write(": ")
+		p.expression(h.Pairs[key], parser.LOWEST)
+	}
+	p.write("}")
+}
+
+func (p *printer) ifExpression(e *ast.IfExpression) {
+	p.write("if (")
+	p.expression(e.Condition, parser.LOWEST)
+	p.write(") ")
+	p.block(e.Consequence)
+
+	for alt := e.Alternative; alt != nil; {
+		// elif branches are parsed into an else block holding a single if
+		// expression; its block token is the } before the elif.
+		if elif := elifBranch(alt); elif != nil {
+			p.write(" elif (")
+			p.expression(elif.Condition, parser.LOWEST)
+			p.write(") ")
+			p.block(elif.Consequence)
+			alt = elif.Alternative
+			continue
+		}
+		p.write(" el ")
+		p.block(alt)
+		break
+	}
+}
+
+// matchExpression writes a match with each case and the el branch on lines
+// of their own.
+func (p *printer) matchExpression(e *ast.MatchExpression) {
+	p.write("match (")
+	p.expression(e.Subject, parser.LOWEST)
+	p.write(") {")
+	p.indent++
+	for _, arm := range e.Arms {
+		p.flushComments(arm.Token.Line)
+		p.startLine(arm.Token.Line)
+		p.write("case ")
+		for i, pattern := range arm.Patterns {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(pattern, parser.LOWEST)
+		}
+		if arm.Guard != nil {
+			p.write(" if (")
+			p.expression(arm.Guard, parser.LOWEST)
+			p.write(")")
+		}
+		p.write(" ")
+		p.block(arm.Body)
+	}
+	if e.Default != nil {
+		p.flushComments(e.Default.Token.Line)
+		p.startLine(e.Default.Token.Line)
+		p.write("el ")
+		p.block(e.Default)
+	}
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+func elifBranch(alt *ast.BlockStatement) *ast.IfExpression {
+	if alt.Token.Type == token.LBRACE || len(alt.Statements) != 1 {
+		return nil
+	}
+	stmt, ok := alt.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return nil
+	}
+	elif, _ := stmt.Expression.(*ast.IfExpression)
+	return elif
+}
+
+// quote writes a string literal back in source form. Single-quoted strings
+// are normalized to double quotes.
+func quote(s *ast.StringLiteral) string {
+	if s.Token.Type == token.BACKTICK {
+		r := strings.NewReplacer(`\`, `\\`, "`", "\\`", "\t", `\t`, "\r", `\r`)
+		return "`" + r.Replace(s.Value) + "`"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `"` + r.Replace(s.Value) + `"`
+}
@@ -0,0 +1,265 @@
+package format
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"squ1d++/lexer"
+	"squ1d++/parser"
+)
+
+func TestSourceFormatsCanonically(t *testing.T) {
+	input := "x=1   # one\n" +
+		"add >> (a,b){return a+b}\n" +
+		"\n\n" +
+		"# note\n" +
+		"if (x==1){io.echo(\"a\")} elif (x==2) {io.echo(`b`)} el {io.echo(\"c\")}\n" +
+		"var h={b:1,a:2}\n" +
+		"var y = (1+2)*3\n" +
+		"while {break}\n"
+
+	expected := "x = 1 # one\n" +
+		"add >> (a, b) {\n" +
+		"    return a + b\n" +
+		"}\n" +
+		"\n" +
+		"# note\n" +
+		"if (x == 1) {\n" +
+		"    io.echo(\"a\")\n" +
+		"} elif (x == 2) {\n" +
+		"    io.echo(`b`)\n" +
+		"} el {\n" +
+		"    io.echo(\"c\")\n" +
+		"}\n" +
+		"var h = {b: 1, a: 2}\n" +
+		"var y = (1 + 2) * 3\n" +
+		"while {\n" +
+		"    break\n" +
+		"}\n"
+
+	got, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	if string(got) != expected {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestSourceSeparatesStatementsThatWouldMerge(t *testing.T) {
+	got, err := Source([]byte("var a = [1, 2]; [3, 4]\n"))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	expected := "var a = [1, 2];\n[3, 4]\n"
+	if string(got) != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSourceFormatsAsyncAndAwait(t *testing.T) {
+	got, err := Source([]byte("var f=async def(x){x}\nasync g>>(y){await   f(y)}\n"))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	expected := "var f = async def(x) {\n    x\n}\nasync g >> (y) {\n    await f(y)\n}\n"
+	if string(got) != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSourceFormatsSpawn(t *testing.T) {
+	got, err := Source([]byte("var t=spawn   def(){work()}\nspawn(f,1)\n"))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	expected := "var t = spawn def() {\n    work()\n}\nspawn(f, 1)\n"
+	if string(got) != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSourceFormatsSets(t *testing.T) {
+	got, err := Source([]byte("var s={1,x,\"a\",}\nvar h={x:1}\n"))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	expected := "var s = {1, x, \"a\"}\nvar h = {x: 1}\n"
+	if string(got) != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSourceFormatsClasses(t *testing.T) {
+	input := "class Point {\n" +
+		"  # new point\n" +
+		"  def init(x,y) {self.x=x}\n" +
+		"\n" +
+		"  def len() {self.x}}\n" +
+		"class Empty {}\n"
+	expected := "class Point {\n" +
+		"    # new point\n" +
+		"    def init(x, y) {\n" +
+		"        self.x = x\n" +
+		"    }\n" +
+		"\n" +
+		"    def len() {\n" +
+		"        self.x\n" +
+		"    }\n" +
+		"}\n" +
+		"class Empty {}\n"
+
+	got, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	if string(got) != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSourceFormatsExports(t *testing.T) {
+	input := "export var version=\"1.0\"\nexport f>>(a){a}\nexport f,version\n"
+	expected := "export var version = \"1.0\"\n" +
+		"export f >> (a) {\n" +
+		"    a\n" +
+		"}\n" +
+		"export f, version\n"
+
+	got, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	if string(got) != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSourceFormatsTuples(t *testing.T) {
+	got, err := Source([]byte("var(a,b)=(1,(2,),())\nvar x=(a)\n"))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	expected := "var (a, b) = (1, (2,), ())\nvar x = a\n"
+	if string(got) != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSourceKeepsGroupingParentheses(t *testing.T) {
+	input := "var z=a+(b*c)\nvar w=(a and b) or c\nvar p=(-x)*2\nreturn ((a+b))\n"
+	expected := "var z = a + (b * c)\nvar w = (a and b) or c\nvar p = (-x) * 2\nreturn (a + b)\n"
+
+	once, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("Source returned error: %v", err)
+	}
+	if string(once) != expected {
+		t.Fatalf("expected %q, got %q", expected, once)
+	}
+	twice, err := Source(once)
+	if err != nil {
+		t.Fatalf("formatted output doesn't parse: %v", err)
+	}
+	if !bytes.Equal(once, twice) {
+		t.Fatalf("formatting isn't idempotent: %q became %q", once, twice)
+	}
+	if before, after := programString(t, []byte(input)), programString(t, once); before != after {
+		t.Fatalf("formatting changed the program:\n%s\n%s", before, after)
+	}
+}
+
+func TestSourceReportsParseErrors(t *testing.T) {
+	if _, err := Source([]byte("var = 1\n")); err == nil {
+		t.Fatalf("expected a parse error")
+	}
+}
+
+func TestSourceIsIdempotentAndKeepsProgram(t *testing.T) {
+	files, err := sourceFiles([]string{"../../examples", "../std/lib"})
+	if err != nil {
+		t.Fatalf("sourceFiles returned error: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("expected .sqd files to format")
+	}
+
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := Source(src)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		twice, err := Source(once)
+		if err != nil {
+			t.Fatalf("%s: formatted output doesn't parse: %v", file, err)
+		}
+		if !bytes.Equal(once, twice) {
+			t.Fatalf("%s: formatting isn't idempotent:\n%s", file, Diff(file, once, twice))
+		}
+		if before, after := programString(t, src), programString(t, once); before != after {
+			t.Fatalf("%s: formatting changed the program:\n%s\n%s", file, before, after)
+		}
+	}
+}
+
+func TestRunCheckReportsUnformattedFiles(t *testing.T) {
+	dir := t.TempDir()
+	messy := filepath.Join(dir, "messy.sqd")
+	tidy := filepath.Join(dir, "tidy.sqd")
+	if err := os.WriteFile(messy, []byte("x=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tidy, []byte("x = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"--check", dir}, nil, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d (stderr %q)", code, stderr.String())
+	}
+	expected := "--- " + messy + "\n+++ " + messy + " (formatted)\n@@ -1,1 +1,1 @@\n-x=1\n+x = 1\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected diff %q, got %q", expected, stdout.String())
+	}
+	if src, _ := os.ReadFile(messy); string(src) != "x=1\n" {
+		t.Fatalf("--check rewrote %s", messy)
+	}
+
+	stdout.Reset()
+	if code := Run([]string{dir}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+	}
+	if stdout.String() != messy+"\n" {
+		t.Fatalf("expected only %s to be listed, got %q", messy, stdout.String())
+	}
+	if src, _ := os.ReadFile(messy); string(src) != "x = 1\n" {
+		t.Fatalf("expected %s to be rewritten, got %q", messy, src)
+	}
+}
+
+func TestRunFormatsStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := Run(nil, strings.NewReader("io.echo( 1 )"), &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+	}
+	if stdout.String() != "io.echo(1)\n" {
+		t.Fatalf("expected formatted stdin, got %q", stdout.String())
+	}
+}
+
+func programString(t *testing.T, src []byte) string {
+	t.Helper()
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return program.String()
+}
@@ -8,6 +8,8 @@ import (
 	"squ1d++/lexer"
 	"squ1d++/object"
 	"squ1d++/parser"
+	"squ1d++/pkg"
+	"squ1d++/std"
 	"squ1d++/vm"
 	"strings"
 )
@@ -17,13 +19,13 @@ type Loader struct {
 	searchPaths []string
 }
 
+// NewLoader returns a loader resolving "std/..." to the embedded standard
+// library, then searching the working directory, ./lib and ./packages, then
+// the installed packages of pkg.GlobalManager.
 func NewLoader() *Loader {
-	homeDir, _ := os.UserHomeDir()
-	packageDir := filepath.Join(homeDir, ".cache", "squ1dlang")
-
 	return &Loader{
 		loadedFiles: make(map[string]bool),
-		searchPaths: []string{".", "./lib", "./packages", packageDir},
+		searchPaths: []string{".", "./lib", "./packages"},
 	}
 }
 
@@ -48,8 +50,13 @@ func (l *Loader) LoadFile(filename string, symbolTable *compiler.SymbolTable, co
 	// Mark as loaded
 	l.loadedFiles[resolvedPath] = true
 
+	if err := pkg.Includes.Enter(resolvedPath, 0, 0); err != nil {
+		return nil, err
+	}
+	defer pkg.Includes.Leave()
+
 	// Read file content
-	content, err := os.ReadFile(resolvedPath)
+	content, err := std.ReadFile(resolvedPath)
 	if err != nil {
 		return nil, fmt.Errorf("could not read file '%s': %v", resolvedPath, err)
 	}
@@ -60,6 +67,11 @@ func (l *Loader) LoadFile(filename string, symbolTable *compiler.SymbolTable, co
 
 // resolvePath resolves a file path, checking search paths and extensions
 func (l *Loader) resolvePath(filename string) (string, error) {
+	// Standard library modules are embedded and win over files on disk
+	if name, ok := std.Resolve(filename); ok {
+		return name, nil
+	}
+
 	// If it's an absolute path or already has .sqd/.sqx extension, use as-is
 	if filepath.IsAbs(filename) || strings.HasSuffix(filename, ".sqd") || strings.HasSuffix(filename, ".sqx") {
 		if _, err := os.Stat(filename); err == nil {
@@ -83,6 +95,13 @@ func (l *Loader) resolvePath(filename string) (string, error) {
 		}
 	}
 
+	// Installed packages, including "name@constraint" specs
+	if pkgMain, found, err := pkg.GlobalManager.ResolveInclude(filename); err != nil {
+		return "", err
+	} else if found {
+		return pkgMain, nil
+	}
+
 	return "", fmt.Errorf("file or package '%s' not found in search paths", filename)
 }
 
@@ -106,7 +125,7 @@ func (l *Loader) executeContent(content string, symbolTable *compiler.SymbolTabl
 
 	// Execute the program
 	code := comp.Bytecode()
-	machine := vm.NewWithGlobalsStore(code, globals.([]object.Object))
+	machine := vm.NewWithGlobalsStore(code, globals.(*vm.Globals))
 
 	err = machine.Run()
 	if err != nil {
@@ -0,0 +1,263 @@
+// Package interp embeds SQU1D++ in Go programs. An Engine holds the globals
+// of one program: it evaluates source or compiled bytecode in them, reads
+// and sets them, and calls the functions they hold. Go functions are
+// exposed to scripts with Register, and values cross between Go and
+// scripts with ToValue, FromValue and Native.
+//
+//	interp.Register("calc", "double", func(n int) int { return 2 * n })
+//	engine := interp.New()
+//	engine.Eval(`var answer = calc.double(21)`)
+//	answer, _ := engine.Get("answer")
+package interp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"squ1d++/bytecode"
+	"squ1d++/compiler"
+	"squ1d++/object"
+	"squ1d++/runner"
+	"squ1d++/vm"
+)
+
+// Engine runs the code of one program. Its methods can be called from
+// several goroutines: like every host of the language, they take turns
+// running code.
+type Engine struct {
+	// IO is where the engine's scripts read their input and write their
+	// output and errors. Streams left nil are the process's.
+	IO object.RuntimeIO
+
+	session *runner.Session
+	// compiled holds the names of the globals of the program EvalBytecode
+	// ran, by slot; nil until it has run one.
+	compiled map[string]int
+}
+
+// New returns an engine whose globals hold just the builtins and classes.
+// Builtins can't be registered once the first engine is created.
+func New() *Engine {
+	return &Engine{session: runner.New(io.Discard)}
+}
+
+// Register exposes fn to scripts as class.name, or as a bare builtin when
+// class is "". It must be called before any engine is created.
+//
+// fn is an object.BuiltinFunction, which gets the arguments as they are;
+// an object.CallingFunction, which also gets a Caller to call the functions
+// scripts pass it; or any other Go function: its arguments are converted
+// with FromValue and its result with ToValue. A function returning an error
+// as its last result makes the call evaluate to an error value when the
+// error isn't nil.
+func Register(class, name string, fn interface{}) error {
+	switch fn := fn.(type) {
+	case object.CallingFunction:
+		return object.RegisterCallingBuiltin(class, name, fn)
+	case func(object.Caller, ...object.Object) object.Object:
+		return object.RegisterCallingBuiltin(class, name, fn)
+	}
+	builtin, err := builtinFunction(name, fn)
+	if err != nil {
+		return fmt.Errorf("builtin %s: %v", qualifiedName(class, name), err)
+	}
+	return object.RegisterBuiltin(class, name, builtin)
+}
+
+// Eval evaluates source in the engine's globals and returns the value of
+// its last statement, NULL when it has none. Syntax, compilation and
+// runtime errors are returned as errors; an error value the code evaluates
+// to, such as the result of `error.new`, is returned as its value.
+func (e *Engine) Eval(source string) (object.Object, error) {
+	if e.compiled != nil {
+		return nil, fmt.Errorf("the engine runs a compiled program; use a new engine to evaluate source")
+	}
+	defer e.enter()()
+	program, err := e.session.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return e.session.Eval(program)
+}
+
+// EvalBytecode runs a whole program compiled ahead of time, as read from a
+// .byc file written by `squ1dcc build -c`, and returns the value of its
+// last statement. The program starts from empty globals, dropping those of
+// the code the engine ran before, and source can't be evaluated after it.
+func (e *Engine) EvalBytecode(r io.Reader) (object.Object, error) {
+	pkg, err := bytecode.Deserialize(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("could not load bytecode: %v", err)
+	}
+	program := pkg.Bytecode()
+	e.compiled = map[string]int{}
+	for index, name := range program.GlobalNames {
+		e.compiled[name] = index
+	}
+	defer e.enter()()
+	return e.session.EvalBytecode(program)
+}
+
+// Get returns the value of the global name and whether it is defined.
+func (e *Engine) Get(name string) (object.Object, bool) {
+	defer e.enter()()
+	index, ok := e.global(name)
+	if !ok {
+		return nil, false
+	}
+	value := e.session.Globals.Get(index)
+	if value == nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Set converts value with ToValue and stores it in the global name,
+// defining it if needed, so the code the engine evaluates next can use it.
+func (e *Engine) Set(name string, value interface{}) error {
+	o, err := ToValue(value)
+	if err != nil {
+		return fmt.Errorf("global %s: %v", name, err)
+	}
+	defer e.enter()()
+	index, ok := e.global(name)
+	if !ok {
+		if e.compiled != nil {
+			return fmt.Errorf("the compiled program has no global %s", name)
+		}
+		index = e.session.Symbols.Define(name).Index
+	}
+	e.session.Globals.Set(index, o)
+	return nil
+}
+
+// Call calls the function held by the global name with args, converted
+// with ToValue, and returns its result. Runtime errors raised by the call
+// are returned as errors.
+func (e *Engine) Call(name string, args ...interface{}) (object.Object, error) {
+	fn, ok := e.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("undefined function %s", name)
+	}
+	values := make([]object.Object, len(args))
+	for i, arg := range args {
+		value, err := ToValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %v", i, name, err)
+		}
+		values[i] = value
+	}
+	defer e.enter()()
+	machine := vm.NewWithGlobalsStore(&compiler.Bytecode{Constants: e.session.Constants}, e.session.Globals)
+	machine.Entered = true
+	return machine.Apply(fn, values...)
+}
+
+// enter takes the interpreter and directs the input and output of scripts
+// to the engine's streams, returning the function undoing both.
+func (e *Engine) enter() (leave func()) {
+	leaveInterpreter := object.Enter()
+	restore := object.SetIO(e.IO)
+	return func() {
+		restore()
+		leaveInterpreter()
+	}
+}
+
+// global returns the slot of the global name.
+func (e *Engine) global(name string) (int, bool) {
+	if e.compiled != nil {
+		index, ok := e.compiled[name]
+		return index, ok
+	}
+	symbol, ok := e.session.Symbols.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return 0, false
+	}
+	return symbol.Index, true
+}
+
+// ToValue converts a Go value into the object scripts see. See
+// object.ToObject for how each kind of value is converted.
+func ToValue(v interface{}) (object.Object, error) {
+	return object.ToObject(v)
+}
+
+// FromValue stores o in the Go value target points to, converting it to
+// target's type. See object.FromObject for the conversions.
+func FromValue(o object.Object, target interface{}) error {
+	return object.FromObject(o, target)
+}
+
+// Native returns o as a plain Go value: nil, bool, int64, float64, string,
+// []interface{} or map[string]interface{}.
+func Native(o object.Object) interface{} {
+	return object.ToNative(o)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// builtinFunction returns fn as the function of the builtin name.
+func builtinFunction(name string, fn interface{}) (object.BuiltinFunction, error) {
+	switch fn := fn.(type) {
+	case nil:
+		return nil, fmt.Errorf("no function")
+	case object.BuiltinFunction:
+		return fn, nil
+	case func(...object.Object) object.Object:
+		return fn, nil
+	}
+
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("expected a function, got %s", t)
+	}
+	if t.IsVariadic() {
+		return nil, fmt.Errorf("variadic functions other than object.BuiltinFunction aren't supported")
+	}
+	results := t.NumOut()
+	returnsError := results > 0 && t.Out(results-1) == errorType
+	if returnsError {
+		results--
+	}
+	if results > 1 {
+		return nil, fmt.Errorf("a function can return at most one value and an error, %s returns %d values", t, t.NumOut())
+	}
+
+	return func(args ...object.Object) object.Object {
+		if len(args) != t.NumIn() {
+			return &object.Error{Message: fmt.Sprintf("Wrong number of arguments to `%s`. Expected %d, got %d", name, t.NumIn(), len(args))}
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			target := reflect.New(t.In(i))
+			if err := object.FromObject(arg, target.Interface()); err != nil {
+				return &object.Error{Message: fmt.Sprintf("Argument %d to `%s`: %v", i, name, err)}
+			}
+			in[i] = target.Elem()
+		}
+		out := v.Call(in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+		}
+		if results == 0 {
+			return &object.Null{}
+		}
+		result, err := object.ToObject(out[0].Interface())
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("Result of `%s`: %v", name, err)}
+		}
+		return result
+	}, nil
+}
+
+func qualifiedName(class, name string) string {
+	if class == "" {
+		return name
+	}
+	return class + "." + name
+}
@@ -0,0 +1,175 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"squ1d++/bytecode"
+	"squ1d++/compiler"
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	registrations := []struct {
+		class, name string
+		fn          interface{}
+	}{
+		{"calc", "double", func(n int) int { return 2 * n }},
+		{"calc", "div", func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		}},
+		{"", "shout", func(args ...object.Object) object.Object {
+			return &object.String{Value: strings.ToUpper(args[0].Inspect())}
+		}},
+		{"os", "retry", func(call object.Caller, args ...object.Object) object.Object {
+			var result object.Object
+			for attempt := 1; attempt <= 3; attempt++ {
+				result = call(args[0], &object.Integer{Value: int64(attempt)})
+				if result.Type() != object.ERROR_OBJ {
+					break
+				}
+			}
+			return result
+		}},
+	}
+	for _, r := range registrations {
+		if err := Register(r.class, r.name, r.fn); err != nil {
+			panic(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+func TestEngine(t *testing.T) {
+	engine := New()
+	var out bytes.Buffer
+	engine.IO.Out = &out
+
+	if err := engine.Set("config", map[string]interface{}{"name": "api", "ports": []int{80, 443}}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	result, err := engine.Eval(`
+var total = calc.double(config["ports"][0])
+var greet = def(who) { io.echo(shout("hi ") + who); return total + 1 }
+total`)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if result.Inspect() != "160" {
+		t.Errorf("expected the value of the last statement, 160, got %s", result.Inspect())
+	}
+
+	total, ok := engine.Get("total")
+	var n int
+	if !ok || FromValue(total, &n) != nil || n != 160 {
+		t.Errorf("expected the global total to be 160, got %v", total)
+	}
+	if _, ok := engine.Get("missing"); ok {
+		t.Errorf("expected an undefined global to be missing")
+	}
+
+	result, err = engine.Call("greet", "there")
+	if err != nil || result.Inspect() != "161" {
+		t.Errorf("Call: expected 161, got %v (%v)", result, err)
+	}
+	if out.String() != "HI there\n" {
+		t.Errorf("expected the script's output in the engine's IO, got %q", out.String())
+	}
+	result, err = engine.Call("calc", 1)
+	if err != nil || result.Type() != object.ERROR_OBJ {
+		t.Errorf("expected calling a class to be an error value, got %v (%v)", result, err)
+	}
+
+	result, err = engine.Eval(`var q = calc.div(1, 0)
+q`)
+	if err != nil || result.(*object.Error).Message != "division by zero" {
+		t.Errorf("expected the Go error as an error value, got %v (%v)", result, err)
+	}
+	result, _ = engine.Eval(`calc.double("x")`)
+	if e, ok := result.(*object.Error); !ok || e.Message != "Argument 0 to `double`: can't store STRING in int" {
+		t.Errorf("expected a conversion error, got %v", result)
+	}
+	result, _ = engine.Eval(`calc.double()`)
+	if e, ok := result.(*object.Error); !ok || e.Message != "Wrong number of arguments to `double`. Expected 1, got 0" {
+		t.Errorf("expected an arity error, got %v", result)
+	}
+
+	result, err = engine.Eval(`os.retry(def(n) { if (n < 3) { return error.new("Flaky", "try again") }; return n })`)
+	if err != nil || result.Inspect() != "3" {
+		t.Errorf("expected os.retry to call the function until it succeeds, got %v (%v)", result, err)
+	}
+
+	if _, err := engine.Eval(`var = 1`); err == nil {
+		t.Errorf("expected a syntax error")
+	}
+	if _, err := engine.Eval(`var f = def() { return 1 / 0 }`); err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if _, err := engine.Call("f"); err == nil || !strings.Contains(err.Error(), "Division by zero") {
+		t.Errorf("expected the runtime error of the call, got %v", err)
+	}
+	if _, err := engine.Call("nothing"); err == nil || err.Error() != "undefined function nothing" {
+		t.Errorf("expected calling an undefined function to fail, got %v", err)
+	}
+}
+
+func TestEvalBytecode(t *testing.T) {
+	p := parser.New(lexer.New(`var scale = 3
+var apply = def(x) { return x * scale }
+apply(2)`))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var compiled bytes.Buffer
+	if err := bytecode.NewPackage(comp.Bytecode()).Serialize(&compiled); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	engine := New()
+	result, err := engine.EvalBytecode(&compiled)
+	if err != nil || result.Inspect() != "6" {
+		t.Fatalf("EvalBytecode: expected 6, got %v (%v)", result, err)
+	}
+	if err := engine.Set("scale", 10); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	result, err = engine.Call("apply", 4)
+	if err != nil || !reflect.DeepEqual(Native(result), int64(40)) {
+		t.Errorf("Call: expected 40, got %v (%v)", result, err)
+	}
+	if err := engine.Set("other", 1); err == nil {
+		t.Errorf("expected defining a global of a compiled program to fail")
+	}
+	if _, err := engine.Eval(`1`); err == nil {
+		t.Errorf("expected evaluating source after bytecode to fail")
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	for _, tt := range []struct {
+		fn       interface{}
+		expected string
+	}{
+		{42, "builtin x.y: expected a function, got int"},
+		{func() (int, int) { return 1, 2 }, "builtin x.y: a function can return at most one value and an error, func() (int, int) returns 2 values"},
+		{func(int) {}, "builtin x.y can't be registered after code was compiled"},
+	} {
+		New()
+		if err := Register("x", "y", tt.fn); err == nil || err.Error() != tt.expected {
+			t.Errorf("expected %q, got %v", tt.expected, err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package lexer
 
 import (
+	"iter"
+
 	"squ1d++/token"
 )
 
@@ -11,6 +13,9 @@ type Lexer struct {
 	ch           byte
 	line         int
 	column       int
+	comments     []token.Token
+	// start is the offset of the token being read.
+	start int
 }
 
 func New(input string) *Lexer {
@@ -39,10 +44,47 @@ func (l *Lexer) readChar() {
 	l.readPosition += 1
 }
 
+// NextToken returns the next token of the input, skipping whitespace and
+// comments. At the end of the input it returns EOF.
 func (l *Lexer) NextToken() token.Token {
+	tok := l.nextToken()
+	tok.Offset, tok.End = l.start, l.offset()
+	return tok
+}
+
+// Tokens returns the tokens of input one at a time, in source order, up to
+// and including EOF. Unlike NextToken it also yields comments, as COMMENT
+// tokens, so tools such as highlighters see all of the source. Malformed
+// input yields ILLEGAL tokens rather than stopping.
+func Tokens(input string) iter.Seq[token.Token] {
+	return func(yield func(token.Token) bool) {
+		l := New(input)
+		for {
+			seen := len(l.comments)
+			tok := l.NextToken()
+			for _, comment := range l.comments[seen:] {
+				if !yield(comment) {
+					return
+				}
+			}
+			if !yield(tok) || tok.Type == token.EOF {
+				return
+			}
+		}
+	}
+}
+
+// offset returns the offset of the current character, len(l.input) at the
+// end of the input.
+func (l *Lexer) offset() int {
+	return min(l.position, len(l.input))
+}
+
+func (l *Lexer) nextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	l.start = l.offset()
 	startLine := l.line
 	startCol := l.column
 	if startCol > 0 {
@@ -261,8 +303,10 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Line = startLine
 		tok.Column = startCol
 	case '#':
+		start := l.position
 		l.skipComment()
-		return l.NextToken()
+		l.comments = append(l.comments, token.Token{Type: token.COMMENT, Literal: l.input[start:l.position], Line: startLine, Column: startCol, Offset: start, End: l.offset()})
+		return l.nextToken()
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -470,6 +514,12 @@ func (l *Lexer) skipComment() {
 	}
 }
 
+// Comments returns the comments skipped so far, in source order. Tools that
+// reprint source (like the formatter) use them to keep comments.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
 // GetInput returns the original input string
 func (l *Lexer) GetInput() string {
 	return l.input
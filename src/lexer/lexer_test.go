@@ -194,3 +194,62 @@ var a1b2c3 = 789;
 		}
 	}
 }
+
+func TestTokens(t *testing.T) {
+	input := "var s = \"a b\"; # note\nx >= 0x1F <<  f(2.5, 'c')\n# end"
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedSource string
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, "var", 1, 1},
+		{token.IDENT, "s", 1, 5},
+		{token.ASSIGN, "=", 1, 7},
+		{token.STRING, `"a b"`, 1, 9},
+		{token.SEMICOLON, ";", 1, 14},
+		{token.COMMENT, "# note", 1, 16},
+		{token.IDENT, "x", 2, 1},
+		{token.GE, ">=", 2, 3},
+		{token.HEX, "0x1F", 2, 6},
+		{token.ERROR_PIPE, "<<", 2, 11},
+		{token.IDENT, "f", 2, 15},
+		{token.LPAREN, "(", 2, 16},
+		{token.FLOAT, "2.5", 2, 17},
+		{token.COMMA, ",", 2, 20},
+		{token.STRING, "'c'", 2, 22},
+		{token.RPAREN, ")", 2, 25},
+		{token.COMMENT, "# end", 3, 1},
+		{token.EOF, "", 0, 0},
+	}
+
+	i := 0
+	for tok := range Tokens(input) {
+		if i >= len(tests) {
+			t.Fatalf("unexpected token %+v", tok)
+		}
+		tt := tests[i]
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Tests[%d] - Tokentype wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if source := input[tok.Offset:tok.End]; source != tt.expectedSource {
+			t.Errorf("Tests[%d] - Source wrong. Expected %q, got %q", i, tt.expectedSource, source)
+		}
+		if tt.expectedLine != 0 && (tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn) {
+			t.Errorf("Tests[%d] - Position wrong. Expected %d:%d, got %d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+		i++
+	}
+	if i != len(tests) {
+		t.Fatalf("expected %d tokens, got %d", len(tests), i)
+	}
+
+	// Stopping early stops the lexer.
+	for tok := range Tokens(input) {
+		if tok.Type != token.LET {
+			t.Fatalf("expected the first token, got %q", tok.Type)
+		}
+		break
+	}
+}
@@ -0,0 +1,129 @@
+package lint
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileDiagnostic is a Diagnostic with the file it was found in, as printed
+// by `squ1dcc lint --json`.
+type FileDiagnostic struct {
+	File string `json:"file"`
+	Diagnostic
+}
+
+// Run implements `squ1dcc lint [--json] paths...`. Directories are searched
+// for .sqd files. Diagnostics are printed as file:line:column: rule: message,
+// or as a JSON array with --json for editors. The exit status is 1 when
+// anything was reported or a file couldn't be linted.
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(stderr) }
+
+	asJSON := fs.Bool("json", false, "Print diagnostics as a JSON array")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		printUsage(stderr)
+		return 2
+	}
+
+	files, err := sourceFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "lint: %v\n", err)
+		return 1
+	}
+
+	status := 0
+	found := []FileDiagnostic{}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "lint: %v\n", err)
+			status = 1
+			continue
+		}
+		diagnostics, err := Source(src)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			status = 1
+			continue
+		}
+		for _, d := range diagnostics {
+			found = append(found, FileDiagnostic{File: file, Diagnostic: d})
+		}
+	}
+
+	if *asJSON {
+		out, err := json.MarshalIndent(found, "", "  ")
+		if err != nil {
+			fmt.Fprintf(stderr, "lint: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, string(out))
+	} else {
+		for _, d := range found {
+			fmt.Fprintf(stdout, "%s:%s\n", d.File, d.Diagnostic)
+		}
+	}
+
+	if len(found) > 0 {
+		status = 1
+	}
+	return status
+}
+
+// sourceFiles expands paths into .sqd files, walking directories and
+// skipping hidden ones.
+func sourceFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if p != path && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(p, ".sqd") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: squ1dcc lint [--json] file.sqd | dir ...")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Reports unused variables, shadowed names, unreachable code,")
+	fmt.Fprintln(w, "assignments used as conditions and class builtins used by bare name.")
+	fmt.Fprintln(w, "Directories are searched for .sqd files.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  --json   print diagnostics as a JSON array of")
+	fmt.Fprintln(w, "           {file, line, column, rule, message} objects")
+}
@@ -0,0 +1,473 @@
+// Package lint reports likely mistakes in SQU1DLang programs: unused
+// variables, shadowed names, unreachable code, assignments used as
+// conditions and class builtins called by their bare name. It works on the
+// AST with a symbol table that follows the compiler's scoping, where only
+// functions open a new scope.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"squ1d++/ast"
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"squ1d++/token"
+	"strings"
+)
+
+// Rule names, as reported in Diagnostic.Rule.
+const (
+	RuleUnused            = "unused"
+	RuleShadow            = "shadow"
+	RuleUnreachable       = "unreachable"
+	RuleAssignInCondition = "assign-in-condition"
+	RuleBareClassBuiltin  = "bare-builtin"
+)
+
+// Diagnostic is one problem found in a program.
+type Diagnostic struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Rule, d.Message)
+}
+
+// Source parses src and lints it. It fails when src doesn't parse.
+func Source(src []byte) ([]Diagnostic, error) {
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", strings.Join(p.Errors(), "\n"))
+	}
+	return Program(program), nil
+}
+
+// Program lints a parsed program and returns its diagnostics sorted by
+// position.
+//
+// Unused variables are only reported inside functions: top-level variables
+// are exported when the file is included, so they may be used elsewhere.
+// Names starting with an underscore are never reported as unused or
+// shadowing.
+func Program(program *ast.Program) []Diagnostic {
+	c := &checker{
+		scope:   newScope(nil),
+		late:    map[string]bool{},
+		globals: map[string]bool{},
+	}
+	c.statements(program.Statements)
+	c.closeScope()
+
+	for _, use := range c.bare {
+		if c.globals[use.name] {
+			continue
+		}
+		c.report(use.tok, RuleBareClassBuiltin, "builtin %s belongs to the %s class; use %s.%s", use.name, use.class, use.class, use.name)
+	}
+
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		a, b := c.diagnostics[i], c.diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return c.diagnostics
+}
+
+// variable is a name defined in a scope.
+type variable struct {
+	name  string
+	tok   token.Token
+	param bool
+	used  bool
+}
+
+// scope is one level of the symbol table: the program, or a function body.
+type scope struct {
+	outer *scope
+	vars  map[string]*variable
+	order []*variable
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, vars: map[string]*variable{}}
+}
+
+func (s *scope) resolve(name string) (*variable, bool) {
+	for ; s != nil; s = s.outer {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// bareUse is a class builtin referenced without its class.
+type bareUse struct {
+	name  string
+	class string
+	tok   token.Token
+}
+
+type checker struct {
+	scope       *scope
+	diagnostics []Diagnostic
+	// late holds names read before any definition was in scope, such as a
+	// function using a variable its caller defines afterwards. Variables
+	// with these names are never reported as unused.
+	late map[string]bool
+	// globals holds every name defined at the top level, so bare builtin
+	// uses can be dropped when the program defines the name itself.
+	globals map[string]bool
+	bare    []bareUse
+}
+
+func (c *checker) report(tok token.Token, rule, format string, args ...interface{}) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// define adds name to the current scope, reporting it when it hides a
+// variable of an enclosing scope or a builtin.
+func (c *checker) define(ident *ast.Identifier, param bool) {
+	name := ident.Value
+	if c.scope.outer == nil {
+		c.globals[name] = true
+	}
+	if _, ok := c.scope.vars[name]; ok {
+		// Redefining a name in the same scope reuses the variable.
+		return
+	}
+
+	if !object.IsPrivateName(name) {
+		if outer, ok := c.scope.outer.resolve(name); ok {
+			c.report(ident.Token, RuleShadow, "%s shadows the variable defined at line %d", name, outer.tok.Line)
+		} else if class, ok := builtinClass(name); ok {
+			if class == "" {
+				c.report(ident.Token, RuleShadow, "%s shadows the builtin %s", name, name)
+			} else {
+				c.report(ident.Token, RuleShadow, "%s shadows the builtin %s.%s", name, class, name)
+			}
+		} else if isClassName(name) {
+			c.report(ident.Token, RuleShadow, "%s shadows the %s class", name, name)
+		}
+	}
+
+	v := &variable{name: name, tok: ident.Token, param: param}
+	c.scope.vars[name] = v
+	c.scope.order = append(c.scope.order, v)
+}
+
+// use records a read of ident.
+func (c *checker) use(ident *ast.Identifier) {
+	if v, ok := c.scope.resolve(ident.Value); ok {
+		v.used = true
+		return
+	}
+	if class, ok := builtinClass(ident.Value); ok && class != "" {
+		c.bare = append(c.bare, bareUse{name: ident.Value, class: class, tok: ident.Token})
+		return
+	}
+	c.late[ident.Value] = true
+}
+
+func (c *checker) openScope() {
+	c.scope = newScope(c.scope)
+}
+
+// closeScope leaves the current scope, reporting its unused variables
+// unless it is the program's.
+func (c *checker) closeScope() {
+	s := c.scope
+	c.scope = s.outer
+	if s.outer == nil {
+		return
+	}
+	for _, v := range s.order {
+		if v.used || v.param || c.late[v.name] || object.IsPrivateName(v.name) {
+			continue
+		}
+		c.report(v.tok, RuleUnused, "%s is defined but never used", v.name)
+	}
+}
+
+// statements checks a statement list, reporting the first statement that
+// follows a return, break or continue.
+func (c *checker) statements(stmts []ast.Statement) {
+	var exit string
+	for _, stmt := range stmts {
+		if exit != "" {
+			c.report(statementToken(stmt), RuleUnreachable, "unreachable code after %s", exit)
+			exit = ""
+		}
+		c.statement(stmt)
+		switch stmt.(type) {
+		case *ast.ReturnStatement:
+			exit = "return"
+		case *ast.BreakStatement:
+			exit = "break"
+		case *ast.ContinueStatement:
+			exit = "continue"
+		}
+	}
+}
+
+func (c *checker) statement(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		// Define before checking the value so functions can call themselves.
+		c.define(s.Name, false)
+		c.expression(s.Value)
+	case *ast.DestructureStatement:
+		c.expression(s.Value)
+		for _, name := range s.Names {
+			c.define(name, false)
+		}
+	case *ast.ReturnStatement:
+		c.expression(s.ReturnValue)
+	case *ast.ExpressionStatement:
+		c.expression(s.Expression)
+	case *ast.BlockStatement:
+		c.statements(s.Statements)
+	case *ast.WhileStatement:
+		c.condition(s.Condition)
+		c.block(s.Body)
+	case *ast.ForStatement:
+		if s.Init != nil {
+			c.statement(s.Init)
+		}
+		c.condition(s.Condition)
+		c.expression(s.Update)
+		c.block(s.Body)
+	case *ast.ForInStatement:
+		c.expression(s.Iterable)
+		for _, name := range s.Names {
+			c.define(name, false)
+		}
+		c.block(s.Body)
+	case *ast.BlockDirective:
+		if s.Statement != nil {
+			c.statement(s.Statement)
+		}
+		c.expression(s.Expression)
+	case *ast.SuppressStatement:
+		if s.Statement != nil {
+			c.statement(s.Statement)
+		}
+		c.expression(s.Expression)
+	case *ast.BenchStatement:
+		// Benchmark bodies run as functions of their own.
+		c.function(nil, s.Body)
+	case *ast.ClassStatement:
+		c.define(s.Name, false)
+		for _, m := range s.Methods {
+			self := &ast.Identifier{Token: m.Token, Value: "self"}
+			c.function(append([]*ast.Identifier{self}, m.Parameters...), m.Body)
+		}
+	case *ast.ExportStatement:
+		if s.Statement != nil {
+			c.statement(s.Statement)
+			return
+		}
+		for _, name := range s.Names {
+			c.use(name)
+		}
+	}
+}
+
+func (c *checker) block(block *ast.BlockStatement) {
+	if block != nil {
+		c.statements(block.Statements)
+	}
+}
+
+// condition checks the condition of an if, while or for, reporting a plain
+// assignment where a comparison was probably meant.
+func (c *checker) condition(cond ast.Expression) {
+	if infix, ok := cond.(*ast.InfixExpression); ok && infix.Operator == "=" {
+		c.report(infix.Token, RuleAssignInCondition, "assignment used as a condition; did you mean ==?")
+	}
+	c.expression(cond)
+}
+
+func (c *checker) expression(expr ast.Expression) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		c.use(e)
+	case *ast.PrefixExpression:
+		c.expression(e.Right)
+	case *ast.AwaitExpression:
+		c.expression(e.Value)
+	case *ast.SpawnExpression:
+		c.expression(e.Function)
+	case *ast.InfixExpression:
+		if ident, ok := e.Left.(*ast.Identifier); ok && e.Operator == "=" {
+			// Assigning isn't a use, but still resolves the name.
+			if _, ok := c.scope.resolve(ident.Value); !ok {
+				c.late[ident.Value] = true
+			}
+		} else {
+			c.expression(e.Left)
+		}
+		c.expression(e.Right)
+	case *ast.IfExpression:
+		c.condition(e.Condition)
+		c.block(e.Consequence)
+		c.block(e.Alternative)
+	case *ast.MatchExpression:
+		c.expression(e.Subject)
+		for _, arm := range e.Arms {
+			for _, pattern := range arm.Patterns {
+				c.pattern(pattern)
+			}
+			c.expression(arm.Guard)
+			c.block(arm.Body)
+		}
+		c.block(e.Default)
+	case *ast.AttemptExpression:
+		c.block(e.Body)
+		if e.Name != nil {
+			c.define(e.Name, false)
+		}
+		c.block(e.Rescue)
+	case *ast.WhileExpression:
+		c.condition(e.Condition)
+		c.block(e.Body)
+	case *ast.FunctionLiteral:
+		c.function(e.Parameters, e.Body)
+	case *ast.CallExpression:
+		c.expression(e.Function)
+		for _, arg := range e.Arguments {
+			c.expression(arg)
+		}
+		if e.Block != nil {
+			// Callback blocks compile to functions of their own.
+			c.function(nil, e.Block)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			c.expression(el)
+		}
+	case *ast.SetLiteral:
+		for _, el := range e.Elements {
+			c.expression(el)
+		}
+	case *ast.TupleLiteral:
+		for _, el := range e.Elements {
+			c.expression(el)
+		}
+	case *ast.HashLiteral:
+		for _, key := range e.Keys {
+			c.expression(key)
+			c.expression(e.Pairs[key])
+		}
+	case *ast.IndexExpression:
+		c.expression(e.Left)
+		c.expression(e.Index)
+	case *ast.SliceExpression:
+		c.expression(e.Left)
+		c.expression(e.Start)
+		c.expression(e.End)
+	case *ast.DotExpression:
+		c.expression(e.Left)
+		c.expression(e.Right)
+	}
+}
+
+// pattern defines the names bound by a pattern of a match arm.
+func (c *checker) pattern(pattern ast.Expression) {
+	switch p := pattern.(type) {
+	case *ast.Identifier:
+		if p.Value != "_" {
+			c.define(p, false)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range p.Elements {
+			c.pattern(el)
+		}
+	case *ast.TupleLiteral:
+		for _, el := range p.Elements {
+			c.pattern(el)
+		}
+	case *ast.HashLiteral:
+		for _, key := range p.Keys {
+			c.pattern(p.Pairs[key])
+		}
+	}
+}
+
+func (c *checker) function(params []*ast.Identifier, body *ast.BlockStatement) {
+	c.openScope()
+	for _, param := range params {
+		c.define(param, true)
+	}
+	c.block(body)
+	c.closeScope()
+}
+
+// statementToken returns the token a statement is reported at.
+func statementToken(stmt ast.Statement) token.Token {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		if s.Token.Type == token.RBRACE {
+			// Shorthand functions start at their name.
+			return s.Name.Token
+		}
+		return s.Token
+	case *ast.DestructureStatement:
+		return s.Token
+	case *ast.ReturnStatement:
+		return s.Token
+	case *ast.ExpressionStatement:
+		return s.Token
+	case *ast.WhileStatement:
+		return s.Token
+	case *ast.ForStatement:
+		return s.Token
+	case *ast.ForInStatement:
+		return s.Token
+	case *ast.BreakStatement:
+		return s.Token
+	case *ast.ContinueStatement:
+		return s.Token
+	case *ast.BlockDirective:
+		return s.Token
+	case *ast.SuppressStatement:
+		return s.Token
+	case *ast.BenchStatement:
+		return s.Token
+	case *ast.ClassStatement:
+		return s.Token
+	case *ast.ExportStatement:
+		return s.Token
+	case *ast.BlockStatement:
+		return s.Token
+	}
+	return token.Token{}
+}
+
+// builtinClass reports whether name is a builtin, and the class it belongs
+// to ("" for builtins callable by bare name).
+func builtinClass(name string) (string, bool) {
+	for _, def := range object.Builtins {
+		if def.Name == name && def.Builtin != nil {
+			return def.Builtin.Class, true
+		}
+	}
+	return "", false
+}
+
+func isClassName(name string) bool {
+	_, ok := object.CreateClassObjects()[name]
+	return ok
+}
@@ -0,0 +1,129 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSourceReportsProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []Diagnostic
+	}{
+		{
+			"unused local",
+			"f >> () {\n    var x = 1\n    var _y = 2\n    return 3\n}\n",
+			[]Diagnostic{{2, 9, RuleUnused, "x is defined but never used"}},
+		},
+		{
+			"unused global is exported",
+			"var x = 1\n",
+			nil,
+		},
+		{
+			"variable used by a later function",
+			"f >> () {\n    var n = 1\n    g >> () { return n }\n    return g()\n}\n",
+			nil,
+		},
+		{
+			"shadowed variable",
+			"var total = 0\nf >> (total) {\n    return total\n}\n",
+			[]Diagnostic{{2, 7, RuleShadow, "total shadows the variable defined at line 1"}},
+		},
+		{
+			"shadowed builtin",
+			"var append = 1\nvar io = 2\n",
+			[]Diagnostic{
+				{1, 5, RuleShadow, "append shadows the builtin array.append"},
+				{2, 5, RuleShadow, "io shadows the io class"},
+			},
+		},
+		{
+			"redefinition in the same scope",
+			"var x = 1\nif (x == 1) {\n    var x = 2\n}\n",
+			nil,
+		},
+		{
+			"unreachable code",
+			"f >> () {\n    return 1\n    io.echo(2)\n}\nwhile {\n    break\n    io.echo(3)\n}\n",
+			[]Diagnostic{
+				{3, 5, RuleUnreachable, "unreachable code after return"},
+				{7, 5, RuleUnreachable, "unreachable code after break"},
+			},
+		},
+		{
+			"assignment in condition",
+			"var x = 1\nif (x = 2) {\n    io.echo(x)\n}\n",
+			[]Diagnostic{{2, 7, RuleAssignInCondition, "assignment used as a condition; did you mean ==?"}},
+		},
+		{
+			"class methods",
+			"class P {\n    def init(x) {\n        var y = x\n    }\n    def get() { return 1 }\n}\n",
+			[]Diagnostic{{3, 13, RuleUnused, "y is defined but never used"}},
+		},
+		{
+			"bare class builtin",
+			"var xs = cat([1], [2])\nvar ys = array.cat(xs, [3])\n",
+			[]Diagnostic{{1, 10, RuleBareClassBuiltin, "builtin cat belongs to the array class; use array.cat"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Source([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Source returned error: %v", err)
+			}
+			if len(got) == 0 && len(tt.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSourceReportsParseErrors(t *testing.T) {
+	if _, err := Source([]byte("var = 1\n")); err == nil {
+		t.Fatalf("expected a parse error")
+	}
+}
+
+func TestRunPrintsJSON(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.sqd")
+	if err := os.WriteFile(file, []byte("f >> () {\n    return 1\n    io.echo(2)\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "clean.sqd"), []byte("var x = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"--json", dir}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d (stderr %q)", code, stderr.String())
+	}
+
+	var got []FileDiagnostic
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("output isn't JSON: %v\n%s", err, stdout.String())
+	}
+	expected := []FileDiagnostic{{File: file, Diagnostic: Diagnostic{3, 5, RuleUnreachable, "unreachable code after return"}}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	stdout.Reset()
+	if code := Run([]string{filepath.Join(dir, "clean.sqd")}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0 for a clean file, got %d", code)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("expected no output for a clean file, got %q", stdout.String())
+	}
+}
@@ -3,19 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"flag"
+	"errors"
 	"fmt"
 	"os"
-	"os/user"
-	"runtime"
+	"path/filepath"
 	"squ1d++/builder"
 	"squ1d++/bytecode"
-	"squ1d++/compiler"
+	"squ1d++/crash"
 	"squ1d++/object"
-	"squ1d++/repl"
-	"squ1d++/sqxdev"
-	"squ1d++/vm"
-	"strings"
+	"squ1d++/runner"
 )
 
 const embeddedMarker = "SQU1D++EMBED"
@@ -29,103 +25,85 @@ func main() {
 		return
 	}
 
-	if len(os.Args) > 1 && os.Args[1] == "sqx" {
-		code := sqxdev.Run(os.Args[2:], os.Stdout, os.Stderr)
-		if code != 0 {
-			os.Exit(code)
-		}
-		return
+	// Plugins listed in SQU1D_PLUGINS are loaded for every command.
+	if err := loadPlugins(filepath.SplitList(os.Getenv("SQU1D_PLUGINS"))); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 
-	user, err := user.Current()
-	if err != nil {
-		panic(err)
+	if code := dispatch(os.Args[1:]); code != 0 {
+		os.Exit(code)
 	}
+}
 
-	// custom verbosity parsing: -v, -vv, -vvv
-	verbosity := 0
-	cleanArgs := []string{os.Args[0]}
-	for _, arg := range os.Args[1:] {
-		switch arg {
-		case "-v":
-			if verbosity < 1 {
-				verbosity = 1
-			}
-			continue
-		case "-vv":
-			if verbosity < 2 {
-				verbosity = 2
-			}
-			continue
-		case "-vvv":
-			if verbosity < 3 {
-				verbosity = 3
-			}
+// loadedPlugins are the paths of the plugins loaded so far.
+var loadedPlugins []string
+
+// loadPlugins loads the Go plugins at paths, skipping empty ones.
+func loadPlugins(paths []string) error {
+	for _, path := range paths {
+		if path == "" {
 			continue
-		default:
-			cleanArgs = append(cleanArgs, arg)
 		}
+		if err := object.LoadPlugin(path); err != nil {
+			return err
+		}
+		loadedPlugins = append(loadedPlugins, path)
 	}
-	os.Args = cleanArgs
-
-	compileFlag := flag.Bool("B", false, "Build .sqd file to executable")
-	outputFlag := flag.String("o", "", "Output executable name (default: same as input file)")
-	sqxSessionFlag := flag.String("sqx-session", "auto", "SQX session mode: auto, always, legacy")
-	flag.Parse()
+	return nil
+}
 
-	// Configure SQX session mode based on CLI flag
-	switch strings.ToLower(*sqxSessionFlag) {
-	case "always", "on", "true", "yes", "1":
-		object.CurrentSessionMode = object.SessionModeAlways
-	case "legacy", "off", "false", "no", "0":
-		object.CurrentSessionMode = object.SessionModeLegacy
-	default:
-		object.CurrentSessionMode = object.SessionModeAuto
+// saveCrashReport writes a crash report when err is an internal fault
+// rather than an error in the program, and tells the user where it is.
+func saveCrashReport(err error) {
+	var fault *crash.Fault
+	if !errors.As(err, &fault) {
+		return
 	}
+	path, saveErr := fault.Save("", builder.CompilerVersion)
+	if saveErr != nil {
+		fmt.Fprintf(os.Stderr, "\nCould not write a crash report: %v\n", saveErr)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nThis is a bug in SQU1D++. A crash report was written to %s\nPlease attach it when reporting the bug.\n", path)
+}
 
-	// Ensure SQX sessions are cleaned up on exit
-	defer object.CloseAllSessions()
-
-	args := flag.Args()
-	builder.SetVerbosity(verbosity)
-
-	if *compileFlag {
-		if len(args) == 0 {
-			fmt.Fprintf(os.Stderr, "Error: No input file specified for compilation\n")
-			fmt.Fprintf(os.Stderr, "Usage: %s -B <input.sqd> [-o output]\n", os.Args[0])
-			os.Exit(1)
-		}
-
-		inputFile := args[0]
-		outputFile := *outputFlag
-		if outputFile == "" {
-			outputFile = strings.TrimSuffix(inputFile, ".sqd")
-		}
-		// Add .exe extension on Windows if not already present
-		if !strings.HasSuffix(outputFile, ".exe") && runtime.GOOS == "windows" {
-			outputFile = outputFile + ".exe"
+// startTrace starts recording the nondeterministic inputs of the run to
+// the record file or replaying them from the replay file, if either is set.
+// stop finishes the recording.
+func startTrace(record, replay string) (stop func() error, err error) {
+	switch {
+	case record != "" && replay != "":
+		return nil, fmt.Errorf("--record and --replay can't be used together")
+	case record != "":
+		f, err := os.Create(record)
+		if err != nil {
+			return nil, err
 		}
-
-		err := builder.BuildStandalone(inputFile, outputFile)
+		stopRecording := object.StartRecording(f)
+		return func() error {
+			err := stopRecording()
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}, nil
+	case replay != "":
+		f, err := os.Open(replay)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error compiling %s: %v\n", inputFile, err)
-			os.Exit(1)
+			return nil, err
 		}
-		fmt.Printf("Successfully compiled %s to %s\n", inputFile, outputFile)
-	} else if len(args) > 0 {
-		// Execute file mode
-		filename := args[0]
-		err := repl.ExecuteFile(filename, os.Stdout)
+		defer f.Close()
+		stopReplay, err := object.StartReplay(f)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error executing file %s: %v\n\t", filename, err)
-			os.Exit(1)
+			return nil, fmt.Errorf("%s: %v", replay, err)
 		}
-	} else {
-		// Interactive REPL mode
-		fmt.Printf("Hello %s! This is the SQU1D++ SQU1DLang compiler, version 1.9.0 written by Quan Thai.\n", user.Username)
-		fmt.Printf("Available classes: %s\n\n", object.ListDefinedClasses())
-		repl.Start(os.Stdin, os.Stdout)
+		return func() error {
+			stopReplay()
+			return nil
+		}, nil
 	}
+	return func() error { return nil }, nil
 }
 
 func tryRunEmbedded() (bool, error) {
@@ -134,27 +112,44 @@ func tryRunEmbedded() (bool, error) {
 		return false, nil
 	}
 
-	data, err := os.ReadFile(exe)
+	f, err := os.Open(exe)
+	if err != nil {
+		return false, nil
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
 	if err != nil {
 		return false, nil
 	}
 
-	if len(data) < 8+len(embeddedMarker) {
+	// Only the footer is read up front so a plain compiler start doesn't
+	// load its whole binary into memory.
+	footerLen := int64(8 + len(embeddedMarker))
+	if info.Size() < footerLen {
 		return false, nil
 	}
 
-	markerStart := len(data) - len(embeddedMarker) - 8
-	if string(data[markerStart+8:]) != embeddedMarker {
+	footer := make([]byte, footerLen)
+	markerStart := info.Size() - footerLen
+	if _, err := f.ReadAt(footer, markerStart); err != nil {
+		return false, nil
+	}
+	if string(footer[8:]) != embeddedMarker {
 		return false, nil
 	}
 
-	bcLen := int(binary.LittleEndian.Uint64(data[markerStart : markerStart+8]))
+	bcLen := int64(binary.LittleEndian.Uint64(footer[:8]))
 	payloadStart := markerStart - bcLen
-	if payloadStart < 0 {
+	if bcLen < 0 || payloadStart < 0 {
 		return false, fmt.Errorf("invalid embedded bytecode payload length")
 	}
 
-	bcData := data[payloadStart:markerStart]
+	bcData := make([]byte, bcLen)
+	if _, err := f.ReadAt(bcData, payloadStart); err != nil {
+		return true, fmt.Errorf("could not read embedded bytecode: %v", err)
+	}
+
 	pkg, err := bytecode.Deserialize(bytes.NewReader(bcData))
 	if err != nil {
 		return true, err
@@ -164,32 +159,5 @@ func tryRunEmbedded() (bool, error) {
 }
 
 func runEmbeddedBytecode(pkg *bytecode.Package) error {
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
-
-	classes := object.CreateClassObjects()
-	for name, classObj := range classes {
-		sym := symbolTable.Define(name)
-		globals[sym.Index] = classObj
-	}
-
-	machine := vm.NewWithGlobalsStore(&compiler.Bytecode{
-		Instructions: pkg.Instructions,
-		Constants:    pkg.Constants,
-	}, globals)
-
-	if err := machine.Run(); err != nil {
-		return err
-	}
-
-	lastPopped := machine.LastPoppedStackElem()
-	if lastPopped != nil && lastPopped.Type() != object.NULL_OBJ {
-		fmt.Println(lastPopped.Inspect())
-	}
-
-	return nil
+	return runner.New(os.Stdout).RunBytecode(pkg.Bytecode())
 }
@@ -0,0 +1,77 @@
+package object
+
+import "fmt"
+
+// annotationTypes maps the type names allowed in annotations such as
+// `var x: int = 5` to the object types they accept.
+var annotationTypes = map[string][]ObjectType{
+	"int":    {INTEGER_OBJ, HEX_OBJ},
+	"float":  {FLOAT_OBJ},
+	"string": {STRING_OBJ},
+	"bool":   {BOOLEAN_OBJ},
+	"array":  {ARRAY_OBJ},
+	"hash":   {HASH_OBJ},
+	"set":    {SET_OBJ},
+	"tuple":  {TUPLE_OBJ},
+	"bytes":  {BYTES_OBJ},
+	"func":   {CLOSURE_OBJ, COMPILED_FUNCTION_OBJ, FUNCTION_OBJ, BUILTIN_OBJ},
+	"null":   {NULL_OBJ},
+	"any":    nil,
+}
+
+// IsAnnotationType reports whether name can be used in a type annotation.
+func IsAnnotationType(name string) bool {
+	_, ok := annotationTypes[name]
+	return ok
+}
+
+// MatchesAnnotation reports whether o may be held by a name annotated with
+// the type name. Errors match every type, so an annotated function can still
+// return an error for its caller to handle.
+func MatchesAnnotation(name string, o Object) bool {
+	if name == "any" {
+		return true
+	}
+	if o == nil {
+		return name == "null"
+	}
+	if o.Type() == ERROR_OBJ {
+		return true
+	}
+	for _, t := range annotationTypes[name] {
+		if o.Type() == t {
+			return true
+		}
+	}
+	return false
+}
+
+// AnnotationOf returns the annotation type name matching o, for messages
+// about values that don't match their annotation.
+func AnnotationOf(o Object) string {
+	if o == nil {
+		return "null"
+	}
+	for name, types := range annotationTypes {
+		for _, t := range types {
+			if o.Type() == t {
+				return name
+			}
+		}
+	}
+	return string(o.Type())
+}
+
+// AnnotationMismatch is the message for a value of type got given to
+// subject, which is annotated with want: "x must be int, got string".
+func AnnotationMismatch(subject, want, got string) string {
+	return fmt.Sprintf("%s must be %s, got %s", subject, want, got)
+}
+
+// FunctionSubject names a function in annotation messages.
+func FunctionSubject(name string) string {
+	if name == "" {
+		return "function"
+	}
+	return name
+}
@@ -0,0 +1,238 @@
+package object
+
+import (
+	"cmp"
+	"sort"
+	"strings"
+)
+
+// arrayMap is array.map: it returns the results of calling fn with each
+// element of an array.
+func arrayMap(call Caller, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("map", args)
+	if err != nil {
+		return err
+	}
+	results := make([]Object, len(arr.Elements))
+	for i, el := range arr.Elements {
+		result := call(fn, el)
+		if isError(result) {
+			return result
+		}
+		results[i] = result
+	}
+	return &Array{Elements: results}
+}
+
+// arrayFilter is array.filter: it returns the elements of an array for
+// which fn returns a truthy value.
+func arrayFilter(call Caller, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("filter", args)
+	if err != nil {
+		return err
+	}
+	kept := []Object{}
+	for _, el := range arr.Elements {
+		result := call(fn, el)
+		if isError(result) {
+			return result
+		}
+		if truthy(result) {
+			kept = append(kept, el)
+		}
+	}
+	return &Array{Elements: kept}
+}
+
+// arrayFind is array.find: it returns the first element of an array for
+// which fn returns a truthy value, or null when there is none.
+func arrayFind(call Caller, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("find", args)
+	if err != nil {
+		return err
+	}
+	for _, el := range arr.Elements {
+		result := call(fn, el)
+		if isError(result) {
+			return result
+		}
+		if truthy(result) {
+			return el
+		}
+	}
+	return &Null{}
+}
+
+// arrayReduce is array.reduce: it calls fn with the result so far and each
+// element of an array in turn, starting from initial, or from the first
+// element when initial is left out.
+func arrayReduce(call Caller, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+	}
+	arr, fn, err := arrayAndFunction("reduce", args[:2])
+	if err != nil {
+		return err
+	}
+	elements := arr.Elements
+	var acc Object
+	if len(args) == 3 {
+		acc = args[2]
+	} else {
+		if len(elements) == 0 {
+			return newError("reduce of an empty array needs an initial value")
+		}
+		acc, elements = elements[0], elements[1:]
+	}
+	for _, el := range elements {
+		acc = call(fn, acc, el)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+// arraySort is array.sort: it returns the elements of an array sorted, in
+// their natural order or, given less, so that less(a, b) is true when a
+// goes before b. Equal elements keep their order.
+func arraySort(call Caller, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return typeError("Argument 0 to `sort` must be ARRAY, got %s", args[0].Type())
+	}
+
+	var failed Object
+	less := func(a, b Object) bool {
+		n, err := compareValues(a, b)
+		if err != nil {
+			failed = err
+		}
+		return n < 0
+	}
+	if len(args) == 2 {
+		if err := checkFunction("sort", 1, args[1]); err != nil {
+			return err
+		}
+		less = func(a, b Object) bool {
+			result := call(args[1], a, b)
+			if isError(result) {
+				failed = result
+				return false
+			}
+			before, ok := result.(*Boolean)
+			if !ok {
+				failed = typeError("The function passed to `sort` must return BOOLEAN, got %s", result.Type())
+				return false
+			}
+			return before.Value
+		}
+	}
+
+	sorted := append([]Object{}, arr.Elements...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return failed == nil && less(sorted[i], sorted[j])
+	})
+	if failed != nil {
+		return failed
+	}
+	return &Array{Elements: sorted}
+}
+
+// compareValues orders two integers, floats or strings, or an integer and
+// a float, returning -1, 0 or 1.
+func compareValues(a, b Object) (int, *Error) {
+	switch a := a.(type) {
+	case *String:
+		if b, ok := b.(*String); ok {
+			return strings.Compare(a.Value, b.Value), nil
+		}
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return cmp.Compare(a.Value, b.Value), nil
+		case *Float:
+			return cmp.Compare(float64(a.Value), b.Value), nil
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return cmp.Compare(a.Value, float64(b.Value)), nil
+		case *Float:
+			return cmp.Compare(a.Value, b.Value), nil
+		}
+	}
+	return 0, newError("Can't sort %s and %s without a function to compare them", a.Type(), b.Type())
+}
+
+// arrayAndFunction checks the arguments of the array builtin name: an array
+// and a function to call with its elements.
+func arrayAndFunction(name string, args []Object) (*Array, Object, *Error) {
+	if len(args) != 2 {
+		return nil, nil, newError("Wrong number of arguments. Expected 2, got %d", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, nil, typeError("Argument 0 to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	if err := checkFunction(name, 1, args[1]); err != nil {
+		return nil, nil, err
+	}
+	return arr, args[1], nil
+}
+
+func checkFunction(name string, i int, arg Object) *Error {
+	switch arg.(type) {
+	case *Closure, *Function, *Builtin, *BoundMethod:
+		return nil
+	}
+	return typeError("Argument %d to `%s` must be FUNCTION, got %s", i, name, arg.Type())
+}
+
+// checkCallback checks that argument i to the builtin name is a function
+// without parameters.
+func checkCallback(name string, i int, arg Object) *Error {
+	if err := checkFunction(name, i, arg); err != nil {
+		return err
+	}
+	if parameterCount(arg) > 0 {
+		return typeError("Argument %d to `%s` must be a FUNCTION without parameters, got %s", i, name, arg.Type())
+	}
+	return nil
+}
+
+// parameterCount returns the number of parameters fn takes, or -1 for a
+// builtin, which checks its own arguments.
+func parameterCount(fn Object) int {
+	switch fn := fn.(type) {
+	case *Closure:
+		return fn.Fn.NumParameters
+	case *Function:
+		return len(fn.Parameters)
+	case *BoundMethod:
+		if method, ok := fn.Method.(*Function); ok {
+			return len(method.Parameters) - 1
+		}
+	}
+	return -1
+}
+
+func isError(obj Object) bool {
+	_, ok := obj.(*Error)
+	return ok
+}
+
+// truthy is the truth value of obj in a condition: false and null are
+// false and everything else is true.
+func truthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	}
+	return true
+}
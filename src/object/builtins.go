@@ -1,7 +1,7 @@
 package object
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/term"
@@ -35,19 +36,29 @@ var (
 	keyboardListeners = make(map[string]*KeyboardListener)
 	keyboardEvents    = make(chan KeyboardEvent, 100)
 	keyboardMutex     sync.RWMutex
-	rawModeActive     = false
+	rawModeActive     atomic.Bool
 	originalTermios   *term.State
-	keyboardActive    = false
+	rawModeTerminal   *os.File
+	keyboardActive    atomic.Bool
 	pendingCallbacks  []Object
 )
 
 // OutWriter is the writer used by builtins for printing side-effects (e.g., io.echo).
 // It defaults to os.Stdout but can be overridden by callers (like the REPL) so
-// tests and embedded runners can capture output.
+// tests and embedded runners can capture output. SetIO sets it along with
+// the other streams of scripts.
 var OutWriter io.Writer = os.Stdout
 
+// Tracing makes the VM log every instruction it executes to TraceWriter, for
+// diagnosing miscompilations. sys.trace turns it on and off at runtime.
+var Tracing bool
+
+// TraceWriter receives the instruction trace. It defaults to os.Stderr.
+var TraceWriter io.Writer = os.Stderr
+
 // ImportedNamespaces tracks user-defined classes/namespaces imported via pkg.include()
-// Maps namespace name to its Hash containing exported functions/variables
+// Maps namespace name to its Hash containing exported functions/variables.
+// Only the goroutine holding the interpreter (see Enter) uses it.
 var ImportedNamespaces = make(map[string]*Hash)
 
 // RegisterNamespace registers an imported namespace so it appears in sys.list()
@@ -58,20 +69,27 @@ func RegisterNamespace(name string, ns *Hash) {
 	ImportedNamespaces[name] = ns
 }
 
+// IsPrivateName reports whether a top-level name is private to the file that
+// defines it. Names starting with an underscore are never exported through a
+// pkg.include() namespace.
+func IsPrivateName(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
 // termios structure for raw mode
 
 // enableRawMode switches terminal to raw mode for immediate key detection
 func enableRawMode() error {
-	if rawModeActive {
+	if rawModeActive.Load() {
 		return nil
 	}
 
-	fd := int(os.Stdin.Fd())
-
-	// Check if stdin is a terminal
-	if !term.IsTerminal(fd) {
-		return fmt.Errorf("stdin is not a terminal")
+	// Check if the input is a terminal
+	terminal, ok := inputTerminal()
+	if !ok {
+		return fmt.Errorf("input is not a terminal")
 	}
+	fd := int(terminal.Fd())
 
 	// Use golang.org/x/term for cross-platform terminal handling
 	state, err := term.MakeRaw(fd)
@@ -79,31 +97,31 @@ func enableRawMode() error {
 		return fmt.Errorf("failed to enable raw mode: %v", err)
 	}
 
-	originalTermios = state
-	rawModeActive = true
+	originalTermios, rawModeTerminal = state, terminal
+	rawModeActive.Store(true)
 	return nil
 }
 
 // disableRawMode restores terminal to original state
 func disableRawMode() error {
-	if !rawModeActive || originalTermios == nil {
+	if !rawModeActive.Load() || originalTermios == nil {
 		return nil
 	}
 
-	fd := int(os.Stdin.Fd())
+	fd := int(rawModeTerminal.Fd())
 	err := term.Restore(fd, originalTermios)
 	if err != nil {
 		return fmt.Errorf("failed to restore terminal: %v", err)
 	}
 
-	rawModeActive = false
+	rawModeActive.Store(false)
 	return nil
 }
 
-// readKey reads a single keypress in raw mode
+// readKey reads a single keypress from the terminal in raw mode
 func readKey() ([]byte, error) {
 	buf := make([]byte, 4)
-	n, err := os.Stdin.Read(buf)
+	n, err := rawModeTerminal.Read(buf)
 	if err != nil {
 		return nil, err
 	}
@@ -180,16 +198,13 @@ func checkKeyMatch(pressedKey string, targetKeys []string) bool {
 
 // startKeyboardListener starts a background goroutine to listen for keyboard input
 func startKeyboardListener() {
-	if keyboardActive {
+	if !keyboardActive.CompareAndSwap(false, true) {
 		return
 	}
 
 	go func() {
-		keyboardActive = true
-		defer func() { keyboardActive = false }()
-
-		for keyboardActive {
-			if !rawModeActive {
+		for keyboardActive.Load() {
+			if !rawModeActive.Load() {
 				time.Sleep(50 * time.Millisecond)
 				continue
 			}
@@ -217,7 +232,7 @@ func startKeyboardListener() {
 
 // stopKeyboardListener stops the background keyboard listener
 func stopKeyboardListener() {
-	keyboardActive = false
+	keyboardActive.Store(false)
 	disableRawMode()
 }
 
@@ -256,6 +271,27 @@ func createBuiltin(fn BuiltinFunction, class string) *Builtin {
 	}
 }
 
+// createCallingBuiltin makes a builtin that calls the functions it is given
+// with the Caller of the backend running it. Its Fn calls them with
+// ForkCall.
+func createCallingBuiltin(fn CallingFunction, class string) *Builtin {
+	builtin := createBuiltin(func(args ...Object) Object {
+		return fn(ForkCall, args...)
+	}, class)
+	builtin.Calling = fn
+	return builtin
+}
+
+// createMachineBuiltin makes a builtin that asks the VM running it about
+// itself. Its Fn asks RunningVM.
+func createMachineBuiltin(fn MachineFunction, class string) *Builtin {
+	builtin := createBuiltin(func(args ...Object) Object {
+		return fn(RunningVM, args...)
+	}, class)
+	builtin.Machine = fn
+	return builtin
+}
+
 var Builtins = []struct {
 	Name    string
 	Builtin *Builtin
@@ -286,6 +322,24 @@ var Builtins = []struct {
 				return &String{Value: "Builtin"}
 			case *Function:
 				return &String{Value: "Function"}
+			case *Channel:
+				return &String{Value: "Channel"}
+			case *Mutex:
+				return &String{Value: "Mutex"}
+			case *WaitGroup:
+				return &String{Value: "WaitGroup"}
+			case *Counter:
+				return &String{Value: "Counter"}
+			case *Stream:
+				return &String{Value: "Stream"}
+			case *Widget:
+				return &String{Value: "Widget"}
+			case *Set:
+				return &String{Value: "Set"}
+			case *Tuple:
+				return &String{Value: "Tuple"}
+			case *Bytes:
+				return &String{Value: "Bytes"}
 			case *Error:
 				return &String{Value: "Error"}
 			default:
@@ -307,7 +361,7 @@ var Builtins = []struct {
 
 			int, ok := args[0].(*Integer)
 			if !ok {
-				return newError("Argument 0 to `i2fl` must be INTEGER, got %s", args[0].Type())
+				return typeError("Argument 0 to `i2fl` must be INTEGER, got %s", args[0].Type())
 			}
 
 			return &Float{Value: float64(int.Value)}
@@ -326,7 +380,7 @@ var Builtins = []struct {
 
 			fl, ok := args[0].(*Float)
 			if !ok {
-				return newError("Argument 0 to `fl2i` must be FLOAT, got %s", args[0].Type())
+				return typeError("Argument 0 to `fl2i` must be FLOAT, got %s", args[0].Type())
 			}
 
 			return &Integer{Value: int64(fl.Value)}
@@ -345,12 +399,12 @@ var Builtins = []struct {
 
 			strInteger, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `s2i` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `s2i` must be STRING, got %s", args[0].Type())
 			}
 
-			numInteger, err := strconv.ParseInt(strInteger.Value, 10, 0)
+			numInteger, err := strconv.ParseInt(strings.TrimSpace(strInteger.Value), 10, 64)
 			if err != nil {
-				return newError("Failed to convert string to integer: %s", err)
+				return conversionError(strInteger.Value, "an integer", err)
 			}
 
 			return &Integer{Value: numInteger}
@@ -360,7 +414,7 @@ var Builtins = []struct {
 		"s2fl",
 		createBuiltin(func(args ...Object) Object {
 			if len(args) != 1 {
-				return newError("Wrong number of arguments. Expeceted 1, got %d", len(args))
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
 			}
 
 			if args[0].Type() == FLOAT_OBJ {
@@ -369,17 +423,56 @@ var Builtins = []struct {
 
 			stringFloat, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `s2fl` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `s2fl` must be STRING, got %s", args[0].Type())
 			}
 
-			numFloat, err := strconv.ParseFloat(stringFloat.Value, 64)
+			numFloat, err := strconv.ParseFloat(strings.TrimSpace(stringFloat.Value), 64)
 			if err != nil {
-				return newError("Failed to convert string to integer: %s", err)
+				return conversionError(stringFloat.Value, "a float", err)
 			}
 
 			return &Float{Value: numFloat}
 		}, "type"),
 	},
+	{
+		"i2s",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			if args[0].Type() == STRING_OBJ {
+				return args[0]
+			}
+
+			switch v := args[0].(type) {
+			case *Integer:
+				return &String{Value: strconv.FormatInt(v.Value, 10)}
+			case *Hex:
+				return &String{Value: strconv.FormatInt(v.Value, 10)}
+			}
+			return typeError("Argument 0 to `i2s` must be INTEGER, got %s", args[0].Type())
+		}, "type"),
+	},
+	{
+		"fl2s",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			if args[0].Type() == STRING_OBJ {
+				return args[0]
+			}
+
+			fl, ok := args[0].(*Float)
+			if !ok {
+				return typeError("Argument 0 to `fl2s` must be FLOAT, got %s", args[0].Type())
+			}
+
+			return &String{Value: formatFloat(fl.Value)}
+		}, "type"),
+	},
 	{
 		"d2s",
 		createBuiltin(func(args ...Object) Object {
@@ -393,11 +486,11 @@ var Builtins = []struct {
 			case *Integer:
 				stringValue = fmt.Sprint(v.Value)
 			case *Float:
-				stringValue = fmt.Sprint(v.Value)
+				stringValue = formatFloat(v.Value)
 			case *String:
 				return args[0]
 			default:
-				return newError("Argument to `d2s` must be FLOAT or INTEGER, got %s", args[0].Type())
+				return typeError("Argument to `d2s` must be FLOAT or INTEGER, got %s", args[0].Type())
 			}
 
 			return &String{Value: stringValue}
@@ -416,7 +509,7 @@ var Builtins = []struct {
 
 			intVal, ok := args[0].(*Integer)
 			if !ok {
-				return newError("Argument 0 to `hex` must be INTEGER, got %s", args[0].Type())
+				return typeError("Argument 0 to `hex` must be INTEGER, got %s", args[0].Type())
 			}
 
 			return &Hex{Value: intVal.Value}
@@ -435,7 +528,7 @@ var Builtins = []struct {
 
 			hex, ok := args[0].(*Hex)
 			if !ok {
-				return newError("Argument 0 to `h2i` must be HEX, got %s", args[0].Type())
+				return typeError("Argument 0 to `h2i` must be HEX, got %s", args[0].Type())
 			}
 
 			return &Integer{Value: hex.Value}
@@ -450,7 +543,7 @@ var Builtins = []struct {
 
 			arr, ok := args[0].(*Array)
 			if !ok {
-				return newError("Argument 0 to `hex2s` must be ARRAY, got %s", args[0].Type())
+				return typeError("Argument 0 to `hex2s` must be ARRAY, got %s", args[0].Type())
 			}
 
 			// Convert array of hex values to string
@@ -469,6 +562,34 @@ var Builtins = []struct {
 			return &String{Value: string(bytesSlice)}
 		}, "type"),
 	},
+	{
+		"freeze",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			Freeze(args[0])
+			return args[0]
+		}, "type"),
+	},
+	{
+		"frozen",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			return &Boolean{Value: IsFrozen(args[0])}
+		}, "type"),
+	},
+	{
+		"clone",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			return Clone(args[0])
+		}, "type"),
+	},
 	{
 		"append",
 		createBuiltin(func(args ...Object) Object {
@@ -478,7 +599,7 @@ var Builtins = []struct {
 
 			arr, ok := args[0].(*Array)
 			if !ok {
-				return newError("Argument 0 to `append` must be ARRAY, got %s", args[0].Type())
+				return typeError("Argument 0 to `append` must be ARRAY, got %s", args[0].Type())
 			}
 
 			length := len(arr.Elements)
@@ -499,15 +620,15 @@ var Builtins = []struct {
 			if len(args) == 1 {
 				prompt, ok := args[0].(*String)
 				if !ok {
-					return newError("Argument 0 to `read` must be STRING, got %s", args[0].Type())
+					return typeError("Argument 0 to `read` must be STRING, got %s", args[0].Type())
 				}
-				fmt.Print(prompt.Value)
+				fmt.Fprint(OutWriter, prompt.Value)
 			}
 
-			reader := bufio.NewReader(os.Stdin)
-			input, err := reader.ReadString('\n')
-			if err != nil {
-				return newError("Failed to read input: %s", err)
+			// The last line of the input needn't end in a newline.
+			input, err := stdinStream.r.ReadString('\n')
+			if err != nil && input == "" {
+				return ioError("Failed to read input: %s", err)
 			}
 
 			input = strings.TrimSpace(input)
@@ -529,7 +650,7 @@ var Builtins = []struct {
 		createBuiltin(func(args ...Object) Object {
 			var elements []string
 			for _, arg := range args {
-				elements = append(elements, arg.Inspect())
+				elements = append(elements, Format(arg))
 			}
 
 			return &String{Value: strings.Join(elements, " ")}
@@ -540,11 +661,23 @@ var Builtins = []struct {
 		createBuiltin(func(args ...Object) Object {
 			var elements []string
 			for _, arg := range args {
-				elements = append(elements, arg.Inspect())
+				elements = append(elements, Format(arg))
 			}
 
 			output := strings.Join(elements, " ")
-			fmt.Fprint(OutWriter, output)
+			fmt.Fprintln(OutWriter, output)
+			return &Null{}
+		}, "io"),
+	},
+	{
+		"print",
+		createBuiltin(func(args ...Object) Object {
+			elements := make([]string, len(args))
+			for i, arg := range args {
+				elements[i] = Format(arg)
+			}
+
+			fmt.Fprint(OutWriter, strings.Join(elements, " "))
 			return &Null{}
 		}, "io"),
 	},
@@ -560,7 +693,7 @@ var Builtins = []struct {
 			for i := 0; i < len(args)-1; i++ {
 				key, ok := args[i].(*String)
 				if !ok {
-					return newError("Argument %d to `keyboard.on` must be STRING, got %s", i, args[i].Type())
+					return typeError("Argument %d to `keyboard.on` must be STRING, got %s", i, args[i].Type())
 				}
 				keys = append(keys, key.Value)
 			}
@@ -571,7 +704,7 @@ var Builtins = []struct {
 			case *CompiledFunction, *Closure, *String:
 				// Valid callback types
 			default:
-				return newError("Last argument to `keyboard.on` must be FUNCTION or STRING, got %s", callback.Type())
+				return typeError("Last argument to `keyboard.on` must be FUNCTION or STRING, got %s", callback.Type())
 			}
 
 			// Register the listener
@@ -601,19 +734,17 @@ var Builtins = []struct {
 			}
 
 			// If a background listener is active, prefer reading events from it
-			if keyboardActive {
+			if keyboardActive.Load() {
 				// Block until an event is available
 				e := <-keyboardEvents
 				return &String{Value: e.Key}
 			}
 
 			// Otherwise fall back to single-key raw read
-			fd := int(os.Stdin.Fd())
-			if !term.IsTerminal(fd) {
-				reader := bufio.NewReader(os.Stdin)
-				input, err := reader.ReadString('\n')
-				if err != nil {
-					return newError("Failed to read input: %v", err)
+			if !inputIsTerminal() {
+				input, err := stdinStream.r.ReadString('\n')
+				if err != nil && input == "" {
+					return ioError("Failed to read input: %v", err)
 				}
 				input = strings.TrimSpace(input)
 				if len(input) > 0 {
@@ -629,7 +760,7 @@ var Builtins = []struct {
 
 			keyBytes, err := readKey()
 			if err != nil {
-				return newError("Failed to read key: %v", err)
+				return ioError("Failed to read key: %v", err)
 			}
 
 			keyName := normalizeKeyName(keyBytes)
@@ -680,7 +811,7 @@ var Builtins = []struct {
 
 			idStr, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `keyboard.off` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `keyboard.off` must be STRING, got %s", args[0].Type())
 			}
 
 			keyboardMutex.Lock()
@@ -697,20 +828,20 @@ var Builtins = []struct {
 		"env",
 		createBuiltin(func(args ...Object) Object {
 			if len(args) == 0 {
-				env := make(map[HashKey]HashPair)
+				env := &Hash{Pairs: make(map[HashKey]HashPair)}
 				for _, e := range os.Environ() {
 					parts := strings.SplitN(e, "=", 2)
 					if len(parts) == 2 {
 						key := &String{Value: parts[0]}
 						value := &String{Value: parts[1]}
-						env[key.HashKey()] = HashPair{Key: key, Value: value}
+						env.Set(key.HashKey(), HashPair{Key: key, Value: value})
 					}
 				}
-				return &Hash{Pairs: env}
+				return env
 			} else if len(args) == 1 {
 				key, ok := args[0].(*String)
 				if !ok {
-					return newError("Argument 0 to `env` must be STRING, got %s", args[0].Type())
+					return typeError("Argument 0 to `env` must be STRING, got %s", args[0].Type())
 				}
 				value := os.Getenv(key.Value)
 				if value == "" {
@@ -730,16 +861,22 @@ var Builtins = []struct {
 			}
 			command, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `exec` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `exec` must be STRING, got %s", args[0].Type())
 			}
 
 			seprcommand := strings.Split(command.Value, " ")
 
 			args_ := seprcommand[1:]
 
-			output, err := exec.Command(seprcommand[0], args_...).Output()
+			var output []byte
+			var err error
+			ctx := currentContext
+			unlocked(func() { output, err = exec.CommandContext(ctx, seprcommand[0], args_...).Output() })
+			if ctx.Err() != nil {
+				return cancelled(ctx)
+			}
 			if err != nil {
-				return newError("Failed to execute command: %s", err)
+				return ioError("Failed to execute command: %s", err)
 			}
 
 			return &String{Value: string(output)}
@@ -755,7 +892,7 @@ var Builtins = []struct {
 			status, ok := args[0].(*Integer)
 
 			if !ok {
-				return newError("Argument 0 to `exit` must be INTEGER, got %s", args[0].Type())
+				return typeError("Argument 0 to `exit` must be INTEGER, got %s", args[0].Type())
 			}
 
 			os.Exit(int(status.Value))
@@ -773,7 +910,7 @@ var Builtins = []struct {
 			info, ok := args[0].(*String)
 
 			if !ok {
-				return newError("Argument 0 to `iRuntime` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `iRuntime` must be STRING, got %s", args[0].Type())
 			}
 
 			switch info.Value {
@@ -802,10 +939,23 @@ var Builtins = []struct {
 			case *Float:
 				duration = time.Duration(arg.Value*1000) * time.Millisecond
 			default:
-				return newError("Argument 0 to `sleep` must be INTEGER or FLOAT, got %s", args[0].Type())
+				return typeError("Argument 0 to `sleep` must be INTEGER or FLOAT, got %s", args[0].Type())
+			}
+
+			ctx := currentContext
+			timer := time.NewTimer(duration)
+			defer timer.Stop()
+			slept := false
+			unlocked(func() {
+				select {
+				case <-timer.C:
+					slept = true
+				case <-ctx.Done():
+				}
+			})
+			if !slept {
+				return cancelled(ctx)
 			}
-
-			time.Sleep(duration)
 			return &Null{}
 		}, "time"),
 	},
@@ -828,7 +978,7 @@ var Builtins = []struct {
 
 			sizeObj, ok := args[0].(*Integer)
 			if !ok {
-				return newError("Argument 0 to `set_overflow_size` must be INTEGER, got %s", args[0].Type())
+				return typeError("Argument 0 to `set_overflow_size` must be INTEGER, got %s", args[0].Type())
 			}
 
 			if sizeObj.Value < 1024 {
@@ -859,6 +1009,140 @@ var Builtins = []struct {
 			return &Null{}
 		}, "sys"),
 	},
+	{
+		"trace",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			on, ok := args[0].(*Boolean)
+			if !ok {
+				return typeError("Argument 0 to `trace` must be BOOLEAN, got %s", args[0].Type())
+			}
+			previous := Tracing
+			Tracing = on.Value
+			return &Boolean{Value: previous}
+		}, "sys"),
+	},
+	// Runtime introspection builtins
+	{
+		"memory",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			return memoryHash()
+		}, "runtime"),
+	},
+	{
+		"gc_stats",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			return gcStatsHash()
+		}, "runtime"),
+	},
+	{
+		"objects",
+		createMachineBuiltin(func(vm VMInfo, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			if vm == nil {
+				return newError("`objects` needs a running program")
+			}
+			counts := map[string]Object{}
+			for typ, n := range objectCounts(vm.Roots()) {
+				counts[typ] = &Integer{Value: int64(n)}
+			}
+			return stringHash(counts)
+		}, "runtime"),
+	},
+	{
+		"stack_depth",
+		createMachineBuiltin(func(vm VMInfo, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			if vm == nil {
+				return &Integer{Value: 0}
+			}
+			return &Integer{Value: int64(vm.Depth())}
+		}, "runtime"),
+	},
+	{
+		"instructions",
+		createMachineBuiltin(func(vm VMInfo, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			if vm == nil {
+				return &Integer{Value: 0}
+			}
+			return &Integer{Value: int64(vm.ExecutedInstructions())}
+		}, "runtime"),
+	},
+	// Logging builtins
+	{"debug", logBuiltin(LogDebug)},
+	{"info", logBuiltin(LogInfo)},
+	{"warn", logBuiltin(LogWarn)},
+	{"error", logBuiltin(LogError)},
+	{
+		"set_level",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			name, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `set_level` must be STRING, got %s", args[0].Type())
+			}
+			level, ok := parseLogLevel(name.Value)
+			if !ok {
+				return newError("Unknown log level %q; use debug, info, warn or error", name.Value)
+			}
+			logger.Lock()
+			logger.level = level
+			logger.Unlock()
+			return &Null{}
+		}, "log"),
+	},
+	{
+		"set_format",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			format, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `set_format` must be STRING, got %s", args[0].Type())
+			}
+			if format.Value != "text" && format.Value != "json" {
+				return newError("Unknown log format %q; use text or json", format.Value)
+			}
+			logger.Lock()
+			logger.json = format.Value == "json"
+			logger.Unlock()
+			return &Null{}
+		}, "log"),
+	},
+	{
+		"set_output",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			dest, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `set_output` must be STRING, got %s", args[0].Type())
+			}
+			if err := setLogOutput(dest.Value); err != nil {
+				return ioError("Could not open log output: %s", err)
+			}
+			return &Null{}
+		}, "log"),
+	},
 	// Math builtins
 	{
 		"rand",
@@ -870,7 +1154,7 @@ var Builtins = []struct {
 			minimum, ok1 := args[0].(*Integer)
 			maximum, ok2 := args[1].(*Integer)
 			if !ok1 || !ok2 {
-				return newError("Arguments to `rand` must be INTEGER and INTEGER, got %s and %s", args[0].Type(), args[1].Type())
+				return typeError("Arguments to `rand` must be INTEGER and INTEGER, got %s and %s", args[0].Type(), args[1].Type())
 			}
 
 			min := minimum.Value
@@ -897,7 +1181,7 @@ var Builtins = []struct {
 			case *Float:
 				return &Float{Value: math.Abs(arg.Value)}
 			default:
-				return newError("Argument 0 to `abs` must be INTEGER or FLOAT, got %s", args[0].Type())
+				return typeError("Argument 0 to `abs` must be INTEGER or FLOAT, got %s", args[0].Type())
 			}
 		}, "math"),
 	},
@@ -915,7 +1199,7 @@ var Builtins = []struct {
 			case *Float:
 				value = arg.Value
 			default:
-				return newError("Argument 0 to `sqrt` must be INTEGER or FLOAT, got %s", args[0].Type())
+				return typeError("Argument 0 to `sqrt` must be INTEGER or FLOAT, got %s", args[0].Type())
 			}
 
 			if value < 0 {
@@ -940,7 +1224,7 @@ var Builtins = []struct {
 			case *Float:
 				base = arg.Value
 			default:
-				return newError("Argument 0 to `pow` must be INTEGER or FLOAT, got %s", args[0].Type())
+				return typeError("Argument 0 to `pow` must be INTEGER or FLOAT, got %s", args[0].Type())
 			}
 
 			switch arg := args[1].(type) {
@@ -949,7 +1233,7 @@ var Builtins = []struct {
 			case *Float:
 				exponent = arg.Value
 			default:
-				return newError("Argument 1 to `pow` must be INTEGER or FLOAT, got %s", args[0].Type())
+				return typeError("Argument 1 to `pow` must be INTEGER or FLOAT, got %s", args[0].Type())
 			}
 
 			return &Float{Value: math.Pow(base, exponent)}
@@ -969,7 +1253,7 @@ var Builtins = []struct {
 			case *Float:
 				value = arg.Value
 			default:
-				return newError("Argument 0 to `sin` must be INTEGER or FLOAT, got %s", args[0].Type())
+				return typeError("Argument 0 to `sin` must be INTEGER or FLOAT, got %s", args[0].Type())
 			}
 
 			return &Float{Value: math.Sin(value)}
@@ -989,7 +1273,7 @@ var Builtins = []struct {
 			case *Float:
 				value = arg.Value
 			default:
-				return newError("Argument 0 to `cos` must be INTEGER or FLOAT, got %s", args[0].Type())
+				return typeError("Argument 0 to `cos` must be INTEGER or FLOAT, got %s", args[0].Type())
 			}
 
 			return &Float{Value: math.Cos(value)}
@@ -1022,7 +1306,7 @@ var Builtins = []struct {
 
 			path, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `include` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `include` must be STRING, got %s", args[0].Type())
 			}
 
 			// If only 1 argument, read and return file contents (backward compat)
@@ -1032,7 +1316,7 @@ var Builtins = []struct {
 				}
 				content, err := os.ReadFile(path.Value)
 				if err != nil {
-					return newError("Could not read file '%s': %v", path.Value, err)
+					return ioError("Could not read file '%s': %v", path.Value, err)
 				}
 				return &String{Value: string(content)}
 			}
@@ -1041,7 +1325,7 @@ var Builtins = []struct {
 			// let the runtime resolve the filename relative to the calling file.
 			namespace, ok := args[1].(*String)
 			if !ok {
-				return newError("Argument 1 to `include` must be STRING, got %s", args[1].Type())
+				return typeError("Argument 1 to `include` must be STRING, got %s", args[1].Type())
 			}
 
 			return &IncludeDirective{
@@ -1060,7 +1344,7 @@ var Builtins = []struct {
 
 			path, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `load_sqx` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `load_sqx` must be STRING, got %s", args[0].Type())
 			}
 
 			ns, err := LoadSQXNamespace(path.Value)
@@ -1080,18 +1364,19 @@ var Builtins = []struct {
 
 			name, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `pkg_create` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `pkg_create` must be STRING, got %s", args[0].Type())
 			}
 
 			description := ""
 			if len(args) == 2 {
 				desc, ok := args[1].(*String)
 				if !ok {
-					return newError("Argument 1 to `pkg_create` must be STRING, got %s", args[1].Type())
+					return typeError("Argument 1 to `pkg_create` must be STRING, got %s", args[1].Type())
 				}
 				description = desc.Value
 			}
 
+			defer packageOutput()()
 			err := pkg.GlobalManager.CreatePackage(name.Value, description)
 			if err != nil {
 				return newError("Failed to create package: %v", err)
@@ -1107,6 +1392,7 @@ var Builtins = []struct {
 				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
 			}
 
+			defer packageOutput()()
 			packages, err := pkg.GlobalManager.ListPackages()
 			if err != nil {
 				return newError("Failed to list packages: %v", err)
@@ -1116,21 +1402,21 @@ var Builtins = []struct {
 			elements := make([]Object, len(packages))
 			for i, pkg := range packages {
 				// Create a hash for each package
-				pairs := make(map[HashKey]HashPair)
+				hash := &Hash{Pairs: make(map[HashKey]HashPair)}
 
 				nameKey := &String{Value: "name"}
 				nameValue := &String{Value: pkg.Name}
-				pairs[nameKey.HashKey()] = HashPair{Key: nameKey, Value: nameValue}
+				hash.Set(nameKey.HashKey(), HashPair{Key: nameKey, Value: nameValue})
 
 				versionKey := &String{Value: "version"}
 				versionValue := &String{Value: pkg.Version}
-				pairs[versionKey.HashKey()] = HashPair{Key: versionKey, Value: versionValue}
+				hash.Set(versionKey.HashKey(), HashPair{Key: versionKey, Value: versionValue})
 
 				descKey := &String{Value: "description"}
 				descValue := &String{Value: pkg.Description}
-				pairs[descKey.HashKey()] = HashPair{Key: descKey, Value: descValue}
+				hash.Set(descKey.HashKey(), HashPair{Key: descKey, Value: descValue})
 
-				elements[i] = &Hash{Pairs: pairs}
+				elements[i] = hash
 			}
 
 			return &Array{Elements: elements}
@@ -1145,9 +1431,10 @@ var Builtins = []struct {
 
 			name, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `pkg_remove` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `pkg_remove` must be STRING, got %s", args[0].Type())
 			}
 
+			defer packageOutput()()
 			err := pkg.GlobalManager.RemovePackage(name.Value)
 			if err != nil {
 				return newError("Failed to remove package: %v", err)
@@ -1156,269 +1443,1828 @@ var Builtins = []struct {
 			return &String{Value: "Package '" + name.Value + "' removed successfully"}
 		}, "pkg"),
 	},
-	// String builtins
 	{
-		"upper",
+		"install",
 		createBuiltin(func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			if len(args) < 1 || len(args) > 2 {
+				return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
 			}
 
-			str, ok := args[0].(*String)
+			source, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `upper` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `pkg_install` must be STRING, got %s", args[0].Type())
 			}
 
-			return &String{Value: strings.ToUpper(str.Value)}
-		}, "string"),
+			constraint := ""
+			if len(args) == 2 {
+				c, ok := args[1].(*String)
+				if !ok {
+					return typeError("Argument 1 to `pkg_install` must be STRING, got %s", args[1].Type())
+				}
+				constraint = c.Value
+			}
+
+			defer packageOutput()()
+			installed, err := pkg.GlobalManager.InstallPackage(source.Value, constraint)
+			if err != nil {
+				return newError("Failed to install package: %v", err)
+			}
+
+			return &String{Value: "Package '" + installed.Name + "' installed successfully"}
+		}, "pkg"),
 	},
 	{
-		"lower",
+		"search",
 		createBuiltin(func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			if len(args) > 1 {
+				return newError("Wrong number of arguments. Expected 0 or 1, got %d", len(args))
 			}
 
-			str, ok := args[0].(*String)
-			if !ok {
-				return newError("Argument 0 to `lower` must be STRING, got %s", args[0].Type())
+			term := ""
+			if len(args) == 1 {
+				str, ok := args[0].(*String)
+				if !ok {
+					return typeError("Argument 0 to `pkg_search` must be STRING, got %s", args[0].Type())
+				}
+				term = str.Value
 			}
 
-			return &String{Value: strings.ToLower(str.Value)}
-		}, "string"),
+			entries, err := pkg.GlobalRegistry.Search(term)
+			if err != nil {
+				return newError("Failed to search packages: %v", err)
+			}
+
+			elements := make([]Object, len(entries))
+			for i, entry := range entries {
+				elements[i] = registryEntryHash(entry, false)
+			}
+			return &Array{Elements: elements}
+		}, "pkg"),
 	},
 	{
-		"trim",
+		"info",
 		createBuiltin(func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
 			}
 
-			str, ok := args[0].(*String)
+			name, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `trim` must be STRING, got %s", args[0].Type())
+				return typeError("Argument 0 to `pkg_info` must be STRING, got %s", args[0].Type())
 			}
 
-			return &String{Value: strings.TrimSpace(str.Value)}
-		}, "string"),
+			entry, err := pkg.GlobalRegistry.Info(name.Value)
+			if err != nil {
+				return newError("Failed to get package info: %v", err)
+			}
+
+			return registryEntryHash(*entry, true)
+		}, "pkg"),
 	},
 	{
-		"sepr",
+		"registry",
 		createBuiltin(func(args ...Object) Object {
-			if len(args) < 1 || len(args) > 2 {
-				return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
-			}
-
-			str, ok := args[0].(*String)
-			if !ok {
-				return newError("Argument 0 to `sepr` must be STRING, got %s", args[0].Type())
+			if len(args) > 1 {
+				return newError("Wrong number of arguments. Expected 0 or 1, got %d", len(args))
 			}
 
-			sep := ""
-			if len(args) == 2 {
-				s, ok := args[1].(*String)
+			if len(args) == 1 {
+				url, ok := args[0].(*String)
 				if !ok {
-					return newError("Argument 1 to `sepr` must be STRING, got %s", args[1].Type())
+					return typeError("Argument 0 to `pkg_registry` must be STRING, got %s", args[0].Type())
 				}
-				sep = s.Value
+				pkg.SetRegistry(url.Value)
 			}
 
-			var parts []string
-			if sep == "" {
-				// Split into individual characters
-				for _, r := range str.Value {
-					parts = append(parts, string(r))
-				}
-			} else {
-				parts = strings.Split(str.Value, sep)
+			return &String{Value: pkg.CurrentRegistryURL()}
+		}, "pkg"),
+	},
+	{
+		"root",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("Wrong number of arguments. Expected 0 or 1, got %d", len(args))
 			}
 
-			elements := make([]Object, len(parts))
-			for i, p := range parts {
-				elements[i] = &String{Value: p}
+			if len(args) == 1 {
+				dir, ok := args[0].(*String)
+				if !ok {
+					return typeError("Argument 0 to `pkg_root` must be STRING, got %s", args[0].Type())
+				}
+				pkg.SetPackageDir(dir.Value)
 			}
 
-			return &Array{Elements: elements}
-		}, "string"),
+			return &String{Value: pkg.GlobalManager.PackageDir()}
+		}, "pkg"),
 	},
-	// File builtins
 	{
-		"read",
+		"publish",
 		createBuiltin(func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			if len(args) > 1 {
+				return newError("Wrong number of arguments. Expected 0 or 1, got %d", len(args))
 			}
 
-			fileName, ok := args[0].(*String)
-			if !ok {
-				return newError("Argument 0 to `read` must be STRING, got %s", args[0].Type())
+			dir := "."
+			if len(args) == 1 {
+				str, ok := args[0].(*String)
+				if !ok {
+					return typeError("Argument 0 to `pkg_publish` must be STRING, got %s", args[0].Type())
+				}
+				dir = str.Value
 			}
 
-			content, err := os.ReadFile(fileName.Value)
-
+			defer packageOutput()()
+			entry, err := pkg.GlobalRegistry.Publish(dir)
 			if err != nil {
-				return newError("Failed to read file: %s", err)
+				return newError("Failed to publish package: %v", err)
 			}
 
-			return &String{Value: string(content)}
-		}, "file"),
+			return &String{Value: "Package '" + entry.Name + "' published successfully"}
+		}, "pkg"),
 	},
 	{
-		"write",
+		"outdated",
 		createBuiltin(func(args ...Object) Object {
-			if len(args) != 2 && len(args) != 3 {
-				return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
-			}
-
-			filepath, ok1 := args[0].(*String)
-			data, ok2 := args[1].(*String)
-
-			if !ok1 || !ok2 {
-				return newError("Arguments 0 and 1 to `write` must be STRING and STRING, got %s and %s", args[0].Type(), args[1].Type())
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
 			}
 
-			if len(args) == 3 {
-				permissions, ok := args[2].(*Integer)
-				if !ok {
-					return newError("Argument 2 to `write` must be INTEGER, got %s", args[2].Type())
+			entries, err := pkg.GlobalManager.Outdated()
+			if err != nil {
+				return newError("Failed to check for updates: %v", err)
+			}
+
+			elements := make([]Object, len(entries))
+			for i, entry := range entries {
+				hash := &Hash{Pairs: make(map[HashKey]HashPair)}
+				for _, field := range [][2]string{
+					{"name", entry.Name},
+					{"current", entry.Current},
+					{"wanted", entry.Wanted},
+					{"latest", entry.Latest},
+				} {
+					key := &String{Value: field[0]}
+					hash.Set(key.HashKey(), HashPair{Key: key, Value: &String{Value: field[1]}})
 				}
-
+				elements[i] = hash
+			}
+			return &Array{Elements: elements}
+		}, "pkg"),
+	},
+	{
+		"update",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("Wrong number of arguments. Expected 0 or 1, got %d", len(args))
+			}
+
+			defer packageOutput()()
+			var updated []string
+			if len(args) == 1 {
+				name, ok := args[0].(*String)
+				if !ok {
+					return typeError("Argument 0 to `pkg_update` must be STRING, got %s", args[0].Type())
+				}
+				changed, err := pkg.GlobalManager.UpdatePackage(name.Value)
+				if err != nil {
+					return newError("Failed to update package: %v", err)
+				}
+				if changed {
+					updated = append(updated, name.Value)
+				}
+			} else {
+				var err error
+				if updated, err = pkg.GlobalManager.UpdateAll(); err != nil {
+					return newError("Failed to update packages: %v", err)
+				}
+			}
+
+			elements := make([]Object, len(updated))
+			for i, name := range updated {
+				elements[i] = &String{Value: name}
+			}
+			return &Array{Elements: elements}
+		}, "pkg"),
+	},
+	// String builtins
+	{
+		"upper",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			str, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `upper` must be STRING, got %s", args[0].Type())
+			}
+
+			return &String{Value: strings.ToUpper(str.Value)}
+		}, "string"),
+	},
+	{
+		"lower",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			str, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `lower` must be STRING, got %s", args[0].Type())
+			}
+
+			return &String{Value: strings.ToLower(str.Value)}
+		}, "string"),
+	},
+	{
+		"trim",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			str, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `trim` must be STRING, got %s", args[0].Type())
+			}
+
+			return &String{Value: strings.TrimSpace(str.Value)}
+		}, "string"),
+	},
+	{
+		"sepr",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+			}
+
+			str, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `sepr` must be STRING, got %s", args[0].Type())
+			}
+
+			sep := ""
+			if len(args) == 2 {
+				s, ok := args[1].(*String)
+				if !ok {
+					return typeError("Argument 1 to `sepr` must be STRING, got %s", args[1].Type())
+				}
+				sep = s.Value
+			}
+
+			var parts []string
+			if sep == "" {
+				// Split into individual characters
+				for _, r := range str.Value {
+					parts = append(parts, string(r))
+				}
+			} else {
+				parts = strings.Split(str.Value, sep)
+			}
+
+			elements := make([]Object, len(parts))
+			for i, p := range parts {
+				elements[i] = &String{Value: p}
+			}
+
+			return &Array{Elements: elements}
+		}, "string"),
+	},
+	{
+		"fmt",
+		createBuiltin(stringFmt, "string"),
+	},
+	// File builtins
+	{
+		"read",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			fileName, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `read` must be STRING, got %s", args[0].Type())
+			}
+
+			content, err := os.ReadFile(fileName.Value)
+
+			if err != nil {
+				return ioError("Failed to read file: %s", err)
+			}
+
+			return &String{Value: string(content)}
+		}, "file"),
+	},
+	{
+		"read_bytes",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			fileName, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `read_bytes` must be STRING, got %s", args[0].Type())
+			}
+
+			content, err := os.ReadFile(fileName.Value)
+			if err != nil {
+				return ioError("Failed to read file: %s", err)
+			}
+
+			return &Bytes{Value: content}
+		}, "file"),
+	},
+	{
+		"write",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+			}
+
+			filepath, ok1 := args[0].(*String)
+			data, ok2 := bytesData(args[1])
+
+			if !ok1 || !ok2 {
+				return typeError("Arguments 0 and 1 to `write` must be STRING and STRING or BYTES, got %s and %s", args[0].Type(), args[1].Type())
+			}
+
+			if len(args) == 3 {
+				permissions, ok := args[2].(*Integer)
+				if !ok {
+					return typeError("Argument 2 to `write` must be INTEGER, got %s", args[2].Type())
+				}
+
 				_, err2 := os.Stat(filepath.Value)
 				if err2 != nil {
 					if os.IsNotExist(err2) {
 						newfile, err3 := os.Create(filepath.Value)
 						if err3 != nil {
-							return newError("Error writing out file: %s", err3)
+							return ioError("Error writing out file: %s", err3)
 						}
 						defer newfile.Close()
 					} else {
-						return newError("Error opening file: %s", err2)
+						return ioError("Error opening file: %s", err2)
+					}
+				}
+
+				err := os.WriteFile(filepath.Value, data, os.FileMode(permissions.Value))
+				if err != nil {
+					return ioError("Error writing file: %s", err)
+				}
+				return &Null{}
+			}
+
+			_, err2 := os.Stat(filepath.Value)
+			if err2 != nil {
+				if os.IsNotExist(err2) {
+					newfile, err3 := os.Create(filepath.Value)
+					if err3 != nil {
+						return ioError("Error writing out file: %s", err3)
 					}
+					defer newfile.Close()
+				} else {
+					return ioError("Error opening file: %s", err2)
+				}
+			}
+
+			err := os.WriteFile(filepath.Value, data, 0755)
+			if err != nil {
+				return ioError("Error writing file: %s", err)
+			}
+			return &Null{}
+		}, "file"),
+	},
+	{
+		"pop",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			array, ok := args[0].(*Array)
+			if !ok {
+				return typeError("Argument 0 to `pop` must be ARRAY, got %s", args[0].Type())
+			}
+
+			if err := CheckMutable(array); err != nil {
+				return err
+			}
+
+			length := len(array.Elements)
+			if length == 0 {
+				return &Null{}
+			}
+
+			array.Elements = array.Elements[:length-1]
+			return array
+		}, "array"),
+	},
+	{
+		"remove",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+
+			array, ok := args[0].(*Array)
+			if !ok {
+				return typeError("Argument 0 to `remove` must be ARRAY, got %s", args[0].Type())
+			}
+
+			indexObj, ok := args[1].(*Integer)
+			if !ok {
+				return typeError("Argument 1 to `remove` must be INTEGER, got %s", args[1].Type())
+			}
+
+			index := int(indexObj.Value)
+			if index < 0 || index >= len(array.Elements) {
+				return indexError("Index %d is out of range (array length is %d)", index, len(array.Elements))
+			}
+
+			newElements := make([]Object, len(array.Elements)-1)
+			copy(newElements, append(array.Elements[:index], array.Elements[index+1:]...))
+			return NewArray(newElements)
+		}, "array"),
+	},
+	{
+		"cat",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			case *Set:
+				return &Integer{Value: int64(arg.Len())}
+			case *Tuple:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Bytes:
+				return &Integer{Value: int64(len(arg.Value))}
+			default:
+				return newError("Argument 0 to `cat` is not supported, got %s", args[0].Type())
+			}
+		}, "array"),
+	},
+	{
+		"join",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return typeError("Argument 0 to `join` must be ARRAY, got %s", args[0].Type())
+			}
+
+			sep, ok := args[1].(*String)
+			if !ok {
+				return typeError("Argument 1 to `join` must be STRING, got %s", args[1].Type())
+			}
+
+			strs := make([]string, len(arr.Elements))
+			for i, el := range arr.Elements {
+				strs[i] = el.Inspect()
+			}
+
+			return &String{Value: strings.Join(strs, sep.Value)}
+		}, "array"),
+	},
+	{
+		"pmap",
+		createMachineBuiltin(func(vm VMInfo, args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return typeError("Argument 0 to `pmap` must be ARRAY, got %s", args[0].Type())
+			}
+			fn, ok := args[1].(*Closure)
+			if !ok {
+				return typeError("Argument 1 to `pmap` must be CLOSURE, got %s", args[1].Type())
+			}
+			if fn.Fn.NumParameters != 1 {
+				return typeError("The function passed to `pmap` must take 1 argument, not %d", fn.Fn.NumParameters)
+			}
+			workers := runtime.NumCPU()
+			if len(args) == 3 {
+				n, ok := args[2].(*Integer)
+				if !ok {
+					return typeError("Argument 2 to `pmap` must be INTEGER, got %s", args[2].Type())
+				}
+				if n.Value < 1 {
+					return newError("pmap needs at least 1 worker, got %d", n.Value)
+				}
+				workers = int(min(n.Value, 1024))
+			}
+			if vm == nil {
+				return newError("pmap needs compiled code; it can't be used in included files")
+			}
+
+			results, err := vm.Map(fn, arr.Elements, workers)
+			if err != nil {
+				return &Error{Message: err.Error()}
+			}
+			return NewArray(results)
+		}, "array"),
+	},
+	{
+		"map",
+		createCallingBuiltin(arrayMap, "array"),
+	},
+	{
+		"filter",
+		createCallingBuiltin(arrayFilter, "array"),
+	},
+	{
+		"reduce",
+		createCallingBuiltin(arrayReduce, "array"),
+	},
+	{
+		"sort",
+		createCallingBuiltin(arraySort, "array"),
+	},
+	{
+		"find",
+		createCallingBuiltin(arrayFind, "array"),
+	},
+	// Concurrency builtins
+	{
+		"spawn",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) == 0 {
+				return newError("Wrong number of arguments. Expected at least 1, got 0")
+			}
+			fn, ok := args[0].(*Closure)
+			if !ok {
+				return typeError("Argument 0 to `spawn` must be CLOSURE, got %s", args[0].Type())
+			}
+			return spawnChecked(fn, args[1:])
+		}, ""),
+	},
+	{
+		"with_timeout",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			ms, ok := args[0].(*Integer)
+			if !ok {
+				return typeError("Argument 0 to `with_timeout` must be INTEGER, got %s", args[0].Type())
+			}
+			fn, ok := args[1].(*Closure)
+			if !ok || fn.Fn.NumParameters != 0 {
+				return typeError("Argument 1 to `with_timeout` must be a CLOSURE without parameters, got %s", args[1].Type())
+			}
+			return withTimeout(ms.Value, fn)
+		}, ""),
+	},
+	// The compiler calls async and await for the keywords of the same
+	// names, so code can't refer to them.
+	{
+		"async",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			fn, ok := args[0].(*Closure)
+			if !ok {
+				return typeError("Argument 0 to `async` must be CLOSURE, got %s", args[0].Type())
+			}
+			return createBuiltin(func(args ...Object) Object {
+				return spawnChecked(fn, args)
+			}, "task")
+		}, "task"),
+	},
+	{
+		"await",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			if wait := taskWait(args[0]); wait != nil {
+				return wait.Fn()
+			}
+			return args[0]
+		}, "task"),
+	},
+	// The builtins of task handles, taking the handle: task.wait(t) is
+	// t.wait().
+	{"wait", taskFunction("wait")},
+	{"result", taskFunction("result")},
+	{"done", taskFunction("done")},
+	{"cancel", taskFunction("cancel")},
+	// Channel builtins
+	{
+		"new",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("Wrong number of arguments. Expected 0 or 1, got %d", len(args))
+			}
+			capacity := int64(0)
+			if len(args) == 1 {
+				n, ok := args[0].(*Integer)
+				if !ok {
+					return typeError("Argument 0 to `new` must be INTEGER, got %s", args[0].Type())
+				}
+				if n.Value < 0 {
+					return newError("Channel capacity must not be negative, got %d", n.Value)
+				}
+				capacity = n.Value
+			}
+			return NewChannel(int(capacity))
+		}, "chan"),
+	},
+	{
+		"send",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return typeError("Argument 0 to `send` must be CHANNEL, got %s", args[0].Type())
+			}
+			return ch.send(args[1])
+		}, "chan"),
+	},
+	{
+		"recv",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return typeError("Argument 0 to `recv` must be CHANNEL, got %s", args[0].Type())
+			}
+			value, ok, err := ch.recv()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return &Null{}
+			}
+			return value
+		}, "chan"),
+	},
+	{
+		"close",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return typeError("Argument 0 to `close` must be CHANNEL, got %s", args[0].Type())
+			}
+			if err := ch.close(); err != nil {
+				return err
+			}
+			return &Null{}
+		}, "chan"),
+	},
+	{
+		"closed",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return typeError("Argument 0 to `closed` must be CHANNEL, got %s", args[0].Type())
+			}
+			return &Boolean{Value: ch.isClosed()}
+		}, "chan"),
+	},
+	{
+		"select",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+			}
+			cases, ok := args[0].(*Array)
+			if !ok {
+				return typeError("Argument 0 to `select` must be ARRAY, got %s", args[0].Type())
+			}
+			timeout := int64(-1)
+			if len(args) == 2 {
+				ms, ok := args[1].(*Integer)
+				if !ok {
+					return typeError("Argument 1 to `select` must be INTEGER, got %s", args[1].Type())
+				}
+				timeout = max(ms.Value, 0)
+			}
+			return selectChannels(cases.Elements, timeout)
+		}, "chan"),
+	},
+	// Mutex builtins
+	{
+		"mutex",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			return NewMutex()
+		}, "sync"),
+	},
+	{
+		"lock",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			m, ok := args[0].(*Mutex)
+			if !ok {
+				return typeError("Argument 0 to `lock` must be MUTEX, got %s", args[0].Type())
+			}
+			if err := m.lock(); err != nil {
+				return err
+			}
+			return &Null{}
+		}, "sync"),
+	},
+	{
+		"try_lock",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			m, ok := args[0].(*Mutex)
+			if !ok {
+				return typeError("Argument 0 to `try_lock` must be MUTEX, got %s", args[0].Type())
+			}
+			return &Boolean{Value: m.tryLock()}
+		}, "sync"),
+	},
+	{
+		"unlock",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			m, ok := args[0].(*Mutex)
+			if !ok {
+				return typeError("Argument 0 to `unlock` must be MUTEX, got %s", args[0].Type())
+			}
+			if err := m.unlock(); err != nil {
+				return err
+			}
+			return &Null{}
+		}, "sync"),
+	},
+	{
+		"with",
+		createCallingBuiltin(func(call Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			m, ok := args[0].(*Mutex)
+			if !ok {
+				return typeError("Argument 0 to `with` must be MUTEX, got %s", args[0].Type())
+			}
+			if err := checkCallback("with", 1, args[1]); err != nil {
+				return err
+			}
+			return m.withLock(call, args[1])
+		}, "sync"),
+	},
+	{
+		"waitgroup",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			return NewWaitGroup()
+		}, "sync"),
+	},
+	{
+		"add",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+			}
+			wg, ok := args[0].(*WaitGroup)
+			if !ok {
+				return typeError("Argument 0 to `add` must be WAITGROUP, got %s", args[0].Type())
+			}
+			delta := int64(1)
+			if len(args) == 2 {
+				n, ok := args[1].(*Integer)
+				if !ok {
+					return typeError("Argument 1 to `add` must be INTEGER, got %s", args[1].Type())
+				}
+				delta = n.Value
+			}
+			if err := wg.add(delta); err != nil {
+				return err
+			}
+			return &Null{}
+		}, "sync"),
+	},
+	{
+		"done",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			wg, ok := args[0].(*WaitGroup)
+			if !ok {
+				return typeError("Argument 0 to `done` must be WAITGROUP, got %s", args[0].Type())
+			}
+			if err := wg.add(-1); err != nil {
+				return err
+			}
+			return &Null{}
+		}, "sync"),
+	},
+	{
+		"wait",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			wg, ok := args[0].(*WaitGroup)
+			if !ok {
+				return typeError("Argument 0 to `wait` must be WAITGROUP, got %s", args[0].Type())
+			}
+			if err := wg.wait(); err != nil {
+				return err
+			}
+			return &Null{}
+		}, "sync"),
+	},
+	{
+		"counter",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("Wrong number of arguments. Expected 0 or 1, got %d", len(args))
+			}
+			c := &Counter{}
+			if len(args) == 1 {
+				n, ok := args[0].(*Integer)
+				if !ok {
+					return typeError("Argument 0 to `counter` must be INTEGER, got %s", args[0].Type())
+				}
+				c.n.Store(n.Value)
+			}
+			return c
+		}, "sync"),
+	},
+	{"inc", counterAdd("inc", true)},
+	{"atomic_add", counterAdd("atomic_add", false)},
+	{
+		"get",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			c, ok := args[0].(*Counter)
+			if !ok {
+				return typeError("Argument 0 to `get` must be COUNTER, got %s", args[0].Type())
+			}
+			return &Integer{Value: c.n.Load()}
+		}, "sync"),
+	},
+	// Template builtins
+	{
+		"render",
+		createBuiltin(templateBuiltin("render", false, false), "tmpl"),
+	},
+	{
+		"html",
+		createBuiltin(templateBuiltin("html", true, false), "tmpl"),
+	},
+	{
+		"render_file",
+		createBuiltin(templateBuiltin("render_file", false, true), "tmpl"),
+	},
+	{
+		"html_file",
+		createBuiltin(templateBuiltin("html_file", true, true), "tmpl"),
+	},
+	// Stream builtins
+	{
+		"open",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `open` must be STRING, got %s", args[0].Type())
+			}
+			mode := "r"
+			if len(args) == 2 {
+				m, ok := args[1].(*String)
+				if !ok {
+					return typeError("Argument 1 to `open` must be STRING, got %s", args[1].Type())
+				}
+				mode = m.Value
+			}
+			return openFileStream(path.Value, mode)
+		}, "stream"),
+	},
+	{
+		"exec",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			command, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `exec` must be STRING, got %s", args[0].Type())
+			}
+			return execStream(command.Value)
+		}, "stream"),
+	},
+	{
+		"connect",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			address, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `connect` must be STRING, got %s", args[0].Type())
+			}
+			return connectStream(address.Value)
+		}, "stream"),
+	},
+	{
+		"stdin",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			return stdinStream
+		}, "stream"),
+	},
+	{
+		"stdout",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			return stdoutStream
+		}, "stream"),
+	},
+	{
+		"read",
+		createBuiltin(func(args ...Object) Object {
+			s, n, err := readArgs("read", args)
+			if err != nil {
+				return err
+			}
+			return s.read(n, false)
+		}, "stream"),
+	},
+	{
+		"read_bytes",
+		createBuiltin(func(args ...Object) Object {
+			s, n, err := readArgs("read_bytes", args)
+			if err != nil {
+				return err
+			}
+			return s.read(n, true)
+		}, "stream"),
+	},
+	{
+		"read_line",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			s, ok := args[0].(*Stream)
+			if !ok {
+				return typeError("Argument 0 to `read_line` must be STREAM, got %s", args[0].Type())
+			}
+			return s.readLine()
+		}, "stream"),
+	},
+	{
+		"write",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			s, ok := args[0].(*Stream)
+			if !ok {
+				return typeError("Argument 0 to `write` must be STREAM, got %s", args[0].Type())
+			}
+			data, ok := bytesData(args[1])
+			if !ok {
+				return typeError("Argument 1 to `write` must be STRING or BYTES, got %s", args[1].Type())
+			}
+			return s.write(data)
+		}, "stream"),
+	},
+	{
+		"pipe",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			src, ok := args[0].(*Stream)
+			if !ok {
+				return typeError("Argument 0 to `pipe` must be STREAM, got %s", args[0].Type())
+			}
+			dst, ok := args[1].(*Stream)
+			if !ok {
+				return typeError("Argument 1 to `pipe` must be STREAM, got %s", args[1].Type())
+			}
+			return pipe(src, dst)
+		}, "stream"),
+	},
+	{
+		"close",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			s, ok := args[0].(*Stream)
+			if !ok {
+				return typeError("Argument 0 to `close` must be STREAM, got %s", args[0].Type())
+			}
+			return s.closeStream()
+		}, "stream"),
+	},
+	{
+		"close_write",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			s, ok := args[0].(*Stream)
+			if !ok {
+				return typeError("Argument 0 to `close_write` must be STREAM, got %s", args[0].Type())
+			}
+			return s.closeStreamWrite()
+		}, "stream"),
+	},
+	// Set builtins
+	{
+		"new",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("Wrong number of arguments. Expected 0 or 1, got %d", len(args))
+			}
+			if len(args) == 0 {
+				return NewSet()
+			}
+			return setOf(args[0])
+		}, "set"),
+	},
+	{
+		"add",
+		createBuiltin(func(args ...Object) Object {
+			sets, err := setArgs("add", args, 1, 1)
+			if err != nil {
+				return err
+			}
+			out := sets[0].clone()
+			if err := out.Add(args[1]); err != nil {
+				return newError("%s", err)
+			}
+			return out
+		}, "set"),
+	},
+	{
+		"remove",
+		createBuiltin(func(args ...Object) Object {
+			sets, err := setArgs("remove", args, 1, 1)
+			if err != nil {
+				return err
+			}
+			removed := NewSet()
+			if err := removed.Add(args[1]); err != nil {
+				return newError("%s", err)
+			}
+			return sets[0].filter(func(el Object) bool { return !removed.Has(el) })
+		}, "set"),
+	},
+	{
+		"has",
+		createBuiltin(func(args ...Object) Object {
+			sets, err := setArgs("has", args, 1, 1)
+			if err != nil {
+				return err
+			}
+			return &Boolean{Value: sets[0].Has(args[1])}
+		}, "set"),
+	},
+	{
+		"union",
+		createBuiltin(func(args ...Object) Object {
+			sets, err := setArgs("union", args, 2, 0)
+			if err != nil {
+				return err
+			}
+			out := sets[0].clone()
+			for _, el := range sets[1].Items() {
+				out.Add(el)
+			}
+			return out
+		}, "set"),
+	},
+	{
+		"intersect",
+		createBuiltin(func(args ...Object) Object {
+			sets, err := setArgs("intersect", args, 2, 0)
+			if err != nil {
+				return err
+			}
+			return sets[0].filter(sets[1].Has)
+		}, "set"),
+	},
+	{
+		"difference",
+		createBuiltin(func(args ...Object) Object {
+			sets, err := setArgs("difference", args, 2, 0)
+			if err != nil {
+				return err
+			}
+			return sets[0].filter(func(el Object) bool { return !sets[1].Has(el) })
+		}, "set"),
+	},
+	{
+		"items",
+		createBuiltin(func(args ...Object) Object {
+			sets, err := setArgs("items", args, 1, 0)
+			if err != nil {
+				return err
+			}
+			return NewArray(sets[0].Items())
+		}, "set"),
+	},
+	// Tuple builtins
+	{
+		"new",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			return tupleOf(args[0])
+		}, "tuple"),
+	},
+	{
+		"items",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			t, ok := args[0].(*Tuple)
+			if !ok {
+				return typeError("Argument 0 to `items` must be TUPLE, got %s", args[0].Type())
+			}
+			return NewArray(append([]Object(nil), t.Elements...))
+		}, "tuple"),
+	},
+	// Hash builtins
+	{
+		"get",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+			}
+			h, key, err := hashArgs("get", args)
+			if err != nil {
+				return err
+			}
+			if pair, ok := h.Pairs[key]; ok {
+				return pair.Value
+			}
+			if len(args) == 3 {
+				return args[2]
+			}
+			return &Null{}
+		}, "hash"),
+	},
+	{
+		"set",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("Wrong number of arguments. Expected 3, got %d", len(args))
+			}
+			h, key, err := hashArgs("set", args)
+			if err != nil {
+				return err
+			}
+			if err := CheckMutable(h); err != nil {
+				return err
+			}
+			h.Set(key, HashPair{Key: args[1], Value: args[2]})
+			return h
+		}, "hash"),
+	},
+	{
+		"keys",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			h, ok := args[0].(*Hash)
+			if !ok {
+				return typeError("Argument 0 to `keys` must be HASH, got %s", args[0].Type())
+			}
+			pairs := h.Ordered()
+			keys := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				keys[i] = pair.Key
+			}
+			return &Array{Elements: keys}
+		}, "hash"),
+	},
+	{
+		"values",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			h, ok := args[0].(*Hash)
+			if !ok {
+				return typeError("Argument 0 to `values` must be HASH, got %s", args[0].Type())
+			}
+			pairs := h.Ordered()
+			values := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				values[i] = pair.Value
+			}
+			return &Array{Elements: values}
+		}, "hash"),
+	},
+	{
+		"has",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			h, key, err := hashArgs("has", args)
+			if err != nil {
+				return err
+			}
+			_, ok := h.Pairs[key]
+			return &Boolean{Value: ok}
+		}, "hash"),
+	},
+	{
+		"del",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			h, key, err := hashArgs("del", args)
+			if err != nil {
+				return err
+			}
+			if err := CheckMutable(h); err != nil {
+				return err
+			}
+			h.Delete(key)
+			return h
+		}, "hash"),
+	},
+	{
+		"merge",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			merged := &Hash{}
+			for i, arg := range args {
+				h, ok := arg.(*Hash)
+				if !ok {
+					return typeError("Argument %d to `merge` must be HASH, got %s", i, arg.Type())
+				}
+				for _, pair := range h.Ordered() {
+					key, _ := HashKeyOf(pair.Key)
+					merged.Set(key, pair)
+				}
+			}
+			return merged
+		}, "hash"),
+	},
+	// JSON builtins
+	{
+		"parse",
+		createBuiltin(jsonParse, "json"),
+	},
+	{
+		"write",
+		createBuiltin(jsonWrite, "json"),
+	},
+	// HTTP builtins
+	{
+		"get",
+		createBuiltin(httpGet, "http"),
+	},
+	{
+		"post",
+		createBuiltin(httpPost, "http"),
+	},
+	{
+		"request",
+		createBuiltin(httpRequest, "http"),
+	},
+	// Bytes builtins
+	{
+		"new",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			return bytesOf(args[0])
+		}, "bytes"),
+	},
+	{
+		"string",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+			}
+			b, err := bytesArg("string", args, 0)
+			if err != nil {
+				return err
+			}
+			return &String{Value: string(b.Value)}
+		}, "bytes"),
+	},
+	{
+		"slice",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+			}
+			b, err := bytesArg("slice", args, 0)
+			if err != nil {
+				return err
+			}
+			start, ok := args[1].(*Integer)
+			if !ok {
+				return typeError("Argument 1 to `slice` must be INTEGER, got %s", args[1].Type())
+			}
+			end := int64(len(b.Value))
+			if len(args) == 3 {
+				n, ok := args[2].(*Integer)
+				if !ok {
+					return typeError("Argument 2 to `slice` must be INTEGER, got %s", args[2].Type())
+				}
+				end = n.Value
+			}
+			return sliceBytes(b, start.Value, end)
+		}, "bytes"),
+	},
+	{
+		"append",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			b, err := bytesArg("append", args, 0)
+			if err != nil {
+				return err
+			}
+			if err := CheckMutable(b); err != nil {
+				return err
+			}
+			if data, ok := bytesData(args[1]); ok {
+				b.Value = append(b.Value, data...)
+				return b
+			}
+			value, ok := byteValue(args[1])
+			if !ok {
+				return typeError("Argument 1 to `append` must be STRING, BYTES or an INTEGER from 0 to 255, got %s", Brief(args[1]))
+			}
+			b.Value = append(b.Value, value)
+			return b
+		}, "bytes"),
+	},
+	{
+		"set",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("Wrong number of arguments. Expected 3, got %d", len(args))
+			}
+			b, err := bytesArg("set", args, 0)
+			if err != nil {
+				return err
+			}
+			if err := CheckMutable(b); err != nil {
+				return err
+			}
+			index, ok := args[1].(*Integer)
+			if !ok {
+				return typeError("Argument 1 to `set` must be INTEGER, got %s", args[1].Type())
+			}
+			if index.Value < 0 || index.Value >= int64(len(b.Value)) {
+				return indexError("Index %d is out of range (buffer length is %d)", index.Value, len(b.Value))
+			}
+			value, ok := byteValue(args[2])
+			if !ok {
+				return newError("Argument 2 to `set` must be an INTEGER from 0 to 255, got %s", Brief(args[2]))
+			}
+			b.Value[index.Value] = value
+			return b
+		}, "bytes"),
+	},
+	// GUI builtins
+	{
+		"window",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 3 {
+				return newError("Wrong number of arguments. Expected 1 or 3, got %d", len(args))
+			}
+			title, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `window` must be STRING, got %s", args[0].Type())
+			}
+			var size [2]int
+			for i := 1; i < len(args); i++ {
+				n, ok := args[i].(*Integer)
+				if !ok || n.Value <= 0 {
+					return newError("Argument %d to `window` must be a positive INTEGER, got %s", i, Brief(args[i]))
 				}
-
-				err := os.WriteFile(filepath.Value, []byte(data.Value), os.FileMode(permissions.Value))
-				if err != nil {
-					return newError("Error writing file: %s", err)
+				size[i-1] = int(n.Value)
+			}
+			return newWindow(title.Value, size[0], size[1])
+		}, "gui"),
+	},
+	{
+		"label",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			window, err := widgetArg("label", args, 0, "window")
+			if err != nil {
+				return err
+			}
+			text, ok := args[1].(*String)
+			if !ok {
+				return typeError("Argument 1 to `label` must be STRING, got %s", args[1].Type())
+			}
+			return addWidget(window, newWidget("label", text.Value))
+		}, "gui"),
+	},
+	{
+		"button",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+			}
+			window, err := widgetArg("button", args, 0, "window")
+			if err != nil {
+				return err
+			}
+			text, ok := args[1].(*String)
+			if !ok {
+				return typeError("Argument 1 to `button` must be STRING, got %s", args[1].Type())
+			}
+			var fn *Closure
+			if len(args) == 3 {
+				if fn, err = handlerArg("button", args, 2, 0); err != nil {
+					return err
 				}
-				return &Null{}
 			}
-
-			_, err2 := os.Stat(filepath.Value)
-			if err2 != nil {
-				if os.IsNotExist(err2) {
-					newfile, err3 := os.Create(filepath.Value)
-					if err3 != nil {
-						return newError("Error writing out file: %s", err3)
-					}
-					defer newfile.Close()
-				} else {
-					return newError("Error opening file: %s", err2)
+			button := addWidget(window, newWidget("button", text.Value))
+			if fn != nil {
+				onEvent(guiEventName(button, "click"), fn)
+			}
+			return button
+		}, "gui"),
+	},
+	{
+		"input",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+			}
+			window, err := widgetArg("input", args, 0, "window")
+			if err != nil {
+				return err
+			}
+			placeholder := ""
+			if len(args) == 2 {
+				s, ok := args[1].(*String)
+				if !ok {
+					return typeError("Argument 1 to `input` must be STRING, got %s", args[1].Type())
 				}
+				placeholder = s.Value
 			}
-
-			err := os.WriteFile(filepath.Value, []byte(data.Value), 0755)
+			input := newWidget("input", "")
+			input.placeholder = placeholder
+			return addWidget(window, input)
+		}, "gui"),
+	},
+	{
+		"on_click",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			button, err := widgetArg("on_click", args, 0, "button")
+			if err != nil {
+				return err
+			}
+			fn, err := handlerArg("on_click", args, 1, 0)
 			if err != nil {
-				return newError("Error writing file: %s", err)
+				return err
 			}
+			onEvent(guiEventName(button, "click"), fn)
 			return &Null{}
-		}, "file"),
+		}, "gui"),
 	},
 	{
-		"pop",
+		"on_change",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			input, err := widgetArg("on_change", args, 0, "input")
+			if err != nil {
+				return err
+			}
+			fn, err := handlerArg("on_change", args, 1, 1)
+			if err != nil {
+				return err
+			}
+			onEvent(guiEventName(input, "change"), fn)
+			return &Null{}
+		}, "gui"),
+	},
+	{
+		"text",
 		createBuiltin(func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
 			}
-
-			array, ok := args[0].(*Array)
+			w, err := widgetArg("text", args, 0, "")
+			if err != nil {
+				return err
+			}
+			return &String{Value: w.text}
+		}, "gui"),
+	},
+	{
+		"set_text",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			}
+			w, err := widgetArg("set_text", args, 0, "")
+			if err != nil {
+				return err
+			}
+			text, ok := args[1].(*String)
 			if !ok {
-				return newError("Argument 0 to `pop` must be ARRAY, got %s", args[0].Type())
+				return typeError("Argument 1 to `set_text` must be STRING, got %s", args[1].Type())
 			}
-
-			length := len(array.Elements)
-			if length == 0 {
-				return &Null{}
+			setWidgetText(w, text.Value)
+			return &Null{}
+		}, "gui"),
+	},
+	{
+		"run",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
 			}
-
-			array.Elements = array.Elements[:length-1]
-			return array
-		}, "array"),
+			return runGUI()
+		}, "gui"),
 	},
 	{
-		"remove",
+		"quit",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			quitGUI()
+			return &Null{}
+		}, "gui"),
+	},
+	// Event builtins
+	{
+		"on",
 		createBuiltin(func(args ...Object) Object {
 			if len(args) != 2 {
 				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
 			}
-
-			array, ok := args[0].(*Array)
+			name, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 0 to `remove` must be ARRAY, got %s", args[0].Type())
+				return typeError("Argument 0 to `on` must be STRING, got %s", args[0].Type())
 			}
-
-			indexObj, ok := args[1].(*Integer)
+			if err := checkFunction("on", 1, args[1]); err != nil {
+				return err
+			}
+			onEvent(name.Value, args[1])
+			return &Null{}
+		}, "event"),
+	},
+	{
+		"off",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+			}
+			name, ok := args[0].(*String)
 			if !ok {
-				return newError("Argument 1 to `remove` must be INTEGER, got %s", args[1].Type())
+				return typeError("Argument 0 to `off` must be STRING, got %s", args[0].Type())
 			}
-
-			index := int(indexObj.Value)
-			if index < 0 || index >= len(array.Elements) {
-				return newError("Index %d is out of range (array length is %d)", index, len(array.Elements))
+			var fn Object
+			if len(args) == 2 {
+				if err := checkFunction("off", 1, args[1]); err != nil {
+					return err
+				}
+				fn = args[1]
 			}
-
-			newElements := make([]Object, len(array.Elements)-1)
-			copy(newElements, append(array.Elements[:index], array.Elements[index+1:]...))
-			return NewArray(newElements)
-		}, "array"),
+			return &Boolean{Value: offEvent(name.Value, fn)}
+		}, "event"),
 	},
 	{
-		"cat",
+		"emit",
+		createCallingBuiltin(func(call Caller, args ...Object) Object {
+			if len(args) < 1 {
+				return newError("Wrong number of arguments. Expected at least 1, got %d", len(args))
+			}
+			name, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `emit` must be STRING, got %s", args[0].Type())
+			}
+			return emitEvent(call, name.Value, append([]Object(nil), args[1:]...))
+		}, "event"),
+	},
+	{
+		"post",
+		createBuiltin(func(args ...Object) Object {
+			if len(args) < 1 {
+				return newError("Wrong number of arguments. Expected at least 1, got %d", len(args))
+			}
+			name, ok := args[0].(*String)
+			if !ok {
+				return typeError("Argument 0 to `post` must be STRING, got %s", args[0].Type())
+			}
+			PostEvent(name.Value, append([]Object(nil), args[1:]...)...)
+			return &Null{}
+		}, "event"),
+	},
+	{
+		"after",
+		createBuiltin(func(args ...Object) Object {
+			return scheduleTimer("after", args, false)
+		}, "event"),
+	},
+	{
+		"every",
+		createBuiltin(func(args ...Object) Object {
+			return scheduleTimer("every", args, true)
+		}, "event"),
+	},
+	{
+		"cancel",
 		createBuiltin(func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("Wrong number of arguments. Expected 1, got %d", len(args))
 			}
-
-			switch arg := args[0].(type) {
-			case *Array:
-				return &Integer{Value: int64(len(arg.Elements))}
-			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
-			default:
-				return newError("Argument 0 to `cat` is not supported, got %s", args[0].Type())
+			id, ok := args[0].(*Integer)
+			if !ok {
+				return typeError("Argument 0 to `cancel` must be INTEGER, got %s", args[0].Type())
 			}
-		}, "array"),
+			return &Boolean{Value: cancelTimer(id.Value)}
+		}, "event"),
 	},
 	{
-		"join",
+		"run",
+		createCallingBuiltin(func(call Caller, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+			}
+			return runEvents(call)
+		}, "event"),
+	},
+	{
+		"stop",
 		createBuiltin(func(args ...Object) Object {
-			if len(args) != 2 {
-				return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+			if len(args) != 0 {
+				return newError("Wrong number of arguments. Expected 0, got %d", len(args))
 			}
+			stopEvents()
+			return &Null{}
+		}, "event"),
+	},
+	// Error builtins
+	{
+		"new",
+		createBuiltin(errorNew, "error"),
+	},
+	{
+		"kind",
+		createBuiltin(errorKind, "error"),
+	},
+	{
+		"msg",
+		createBuiltin(errorMsg, "error"),
+	},
+	{
+		"data",
+		createBuiltin(errorData, "error"),
+	},
+}
 
-			arr, ok := args[0].(*Array)
-			if !ok {
-				return newError("Argument 0 to `join` must be ARRAY, got %s", args[0].Type())
-			}
+// registryEntryHash converts a registry entry for pkg.search/pkg.info. The
+// detailed form adds every known field plus the locally installed version.
+func registryEntryHash(entry pkg.RegistryEntry, detailed bool) *Hash {
+	hash := &Hash{Pairs: make(map[HashKey]HashPair)}
+	set := func(key string, value Object) {
+		k := &String{Value: key}
+		hash.Set(k.HashKey(), HashPair{Key: k, Value: value})
+	}
+	stringArray := func(values []string) *Array {
+		elements := make([]Object, len(values))
+		for i, v := range values {
+			elements[i] = &String{Value: v}
+		}
+		return &Array{Elements: elements}
+	}
 
-			sep, ok := args[1].(*String)
-			if !ok {
-				return newError("Argument 1 to `join` must be STRING, got %s", args[1].Type())
+	set("name", &String{Value: entry.Name})
+	set("version", &String{Value: entry.Version})
+	set("description", &String{Value: entry.Description})
+	set("source", &String{Value: entry.Source})
+
+	if detailed {
+		set("versions", stringArray(entry.Versions))
+		set("keywords", stringArray(entry.Keywords))
+		set("author", &String{Value: entry.Author})
+		set("homepage", &String{Value: entry.Homepage})
+		set("license", &String{Value: entry.License})
+
+		installed := Object(&Null{})
+		defer packageOutput()()
+		if packages, err := pkg.GlobalManager.ListPackages(); err == nil {
+			for _, p := range packages {
+				if p.Name == entry.Name {
+					installed = &String{Value: p.Version}
+				}
 			}
+		}
+		set("installed", installed)
+	}
 
-			strs := make([]string, len(arr.Elements))
-			for i, el := range arr.Elements {
-				strs[i] = el.Inspect()
-			}
+	return hash
+}
 
-			return &String{Value: strings.Join(strs, sep.Value)}
-		}, "array"),
-	},
+// packageOutput directs the messages of the package manager and registry,
+// such as the confirmation of an installed package, to OutWriter and
+// returns the function restoring where they went before.
+func packageOutput() (restore func()) {
+	manager, registry := pkg.GlobalManager.Out, pkg.GlobalRegistry.Out
+	pkg.GlobalManager.Out, pkg.GlobalRegistry.Out = OutWriter, OutWriter
+	return func() {
+		pkg.GlobalManager.Out, pkg.GlobalRegistry.Out = manager, registry
+	}
 }
 
 func newError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// typeError is the error of an argument of the wrong type. Its kind is
+// "TypeError".
+func typeError(format string, a ...interface{}) *Error {
+	return &Error{Kind: "TypeError", Message: fmt.Sprintf(format, a...)}
+}
+
+// ioError is the error of reading or writing a file, stream, connection
+// or the terminal that failed. Its kind is "IOError".
+func ioError(format string, a ...interface{}) *Error {
+	return &Error{Kind: "IOError", Message: fmt.Sprintf(format, a...)}
+}
+
+// indexError is the error of an index or slice outside the value it
+// indexes. Its kind is "IndexError".
+func indexError(format string, a ...interface{}) *Error {
+	return &Error{Kind: "IndexError", Message: fmt.Sprintf(format, a...)}
+}
+
+// conversionError is the error of type.s2i and type.s2fl for text that
+// isn't a number of the kind they read. Its kind is "ValueError".
+func conversionError(text, what string, err error) *Error {
+	e := newError("Can't convert %s to %s", Brief(&String{Value: text}), what)
+	if errors.Is(err, strconv.ErrRange) {
+		e.Message += ": out of range"
+	}
+	e.Kind = "ValueError"
+	return e
+}
+
 func GetBuiltinByName(name string) *Builtin {
 	for _, def := range Builtins {
 		if def.Name == name {
@@ -1429,51 +3275,61 @@ func GetBuiltinByName(name string) *Builtin {
 	return nil
 }
 
-var GUIEventHandlers map[string][]Object
+// namesHash returns a hash mapping each key of h to itself, to list the
+// names h holds.
+func namesHash(h *Hash) *Hash {
+	names := &Hash{Pairs: make(map[HashKey]HashPair)}
+	for _, pair := range h.Ordered() {
+		key, _ := HashKeyOf(pair.Key)
+		names.Set(key, HashPair{Key: pair.Key, Value: pair.Key})
+	}
+	return names
+}
+
+// sortedNames returns the keys of names in order.
+func sortedNames[V any](names map[string]V) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
 
 // buildSystemList builds the sys.list hash at runtime
 func buildSystemList() *Hash {
 	result := &Hash{Pairs: make(map[HashKey]HashPair)}
 	classes := CreateClassObjects()
-	classOrder := []string{"io", "type", "time", "os", "math", "string", "file", "pkg", "array", "sys", "keyboard"}
-
 	// Add built-in classes and their methods (level 1 - core functionality)
-	for _, className := range classOrder {
+	for _, className := range ClassNames {
 		if classHash, ok := classes[className]; ok {
 			// Create a hash of method names in this class
-			methodsHash := &Hash{Pairs: make(map[HashKey]HashPair)}
-			for key, pair := range classHash.Pairs {
-				// Store method name as both key and value for visibility
-				methodsHash.Pairs[key] = HashPair{Key: pair.Key, Value: pair.Key}
-			}
+			methodsHash := namesHash(classHash)
 
 			// Add the class to result
 			classNameStr := &String{Value: className}
-			result.Pairs[classNameStr.HashKey()] = HashPair{
+			result.Set(classNameStr.HashKey(), HashPair{
 				Key:   classNameStr,
 				Value: methodsHash,
-			}
+			})
 		}
 	}
 
 	// Add user-defined imported namespaces (level 2 - from pkg.include())
-	for nsName, nsHash := range ImportedNamespaces {
+	for _, nsName := range sortedNames(ImportedNamespaces) {
+		nsHash := ImportedNamespaces[nsName]
 		if nsHash == nil {
 			continue
 		}
 		// Create a hash of variable/function names in this namespace
-		varsHash := &Hash{Pairs: make(map[HashKey]HashPair)}
-		for key, pair := range nsHash.Pairs {
-			// Store name as both key and value for visibility
-			varsHash.Pairs[key] = HashPair{Key: pair.Key, Value: pair.Key}
-		}
+		varsHash := namesHash(nsHash)
 
 		// Add the namespace to result
 		nsNameStr := &String{Value: nsName}
-		result.Pairs[nsNameStr.HashKey()] = HashPair{
+		result.Set(nsNameStr.HashKey(), HashPair{
 			Key:   nsNameStr,
 			Value: varsHash,
-		}
+		})
 	}
 
 	// Note: Local variables (level 3) would need to be added by the runtime/environment
@@ -1482,50 +3338,27 @@ func buildSystemList() *Hash {
 	return result
 }
 
+// ClassNames lists the built-in classes in the order their objects follow
+// the builtins in the compiler's and VM's builtin indexes.
+var ClassNames = []string{"io", "type", "time", "os", "math", "string", "file", "pkg", "array", "sys", "keyboard", "runtime", "log", "chan", "sync", "event", "tmpl", "stream", "gui", "set", "tuple", "bytes", "hash", "json", "http", "error", "task"}
+
+// CreateClassObjects builds a hash for each built-in class mapping the
+// names of its builtins to them.
 func CreateClassObjects() map[string]*Hash {
-	classes := make(map[string]*Hash)
-
-	ioClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	typeClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	timeClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	osClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	mathClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	stringClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	fileClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	pkgClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	arrayClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	sysClass := &Hash{Pairs: make(map[HashKey]HashPair)}
-	keyboardClass := &Hash{Pairs: make(map[HashKey]HashPair)}
+	sealBuiltins()
+	classes := make(map[string]*Hash, len(ClassNames))
+	for _, className := range ClassNames {
+		classes[className] = &Hash{Pairs: make(map[HashKey]HashPair)}
+	}
 
 	for _, def := range Builtins {
-		if def.Builtin.Class != "" {
+		if def.Builtin.Class == "task" && (def.Name == "async" || def.Name == "await") {
+			// Only the keywords call these.
+			continue
+		}
+		if class, ok := classes[def.Builtin.Class]; ok {
 			funcName := &String{Value: def.Name}
-			key := funcName.HashKey()
-
-			switch def.Builtin.Class {
-			case "io":
-				ioClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "type":
-				typeClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "time":
-				timeClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "os":
-				osClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "math":
-				mathClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "string":
-				stringClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "file":
-				fileClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "pkg":
-				pkgClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "array":
-				arrayClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "sys":
-				sysClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			case "keyboard":
-				keyboardClass.Pairs[key] = HashPair{Key: funcName, Value: def.Builtin}
-			}
+			class.Set(funcName.HashKey(), HashPair{Key: funcName, Value: def.Builtin})
 		}
 	}
 
@@ -1541,23 +3374,21 @@ func CreateClassObjects() map[string]*Hash {
 		Attributes: make(map[string]Object),
 	}
 	listFuncName := &String{Value: "list"}
-	sysClass.Pairs[listFuncName.HashKey()] = HashPair{Key: listFuncName, Value: listBuiltin}
-
-	classes["io"] = ioClass
-	classes["type"] = typeClass
-	classes["time"] = timeClass
-	classes["os"] = osClass
-	classes["math"] = mathClass
-	classes["string"] = stringClass
-	classes["file"] = fileClass
-	classes["pkg"] = pkgClass
-	classes["array"] = arrayClass
-	classes["sys"] = sysClass
-	classes["keyboard"] = keyboardClass
+	classes["sys"].Set(listFuncName.HashKey(), HashPair{Key: listFuncName, Value: listBuiltin})
 
 	return classes
 }
 
+// classObjects is built once by ClassObjects.
+var classObjects = sync.OnceValue(CreateClassObjects)
+
+// ClassObjects returns the class hashes of CreateClassObjects, built once
+// and shared by every VM. Nothing changes them afterwards, so VMs on
+// different goroutines can use them at once.
+func ClassObjects() map[string]*Hash {
+	return classObjects()
+}
+
 func ListDefinedClasses() string {
 	classes := CreateClassObjects()
 	var classNames []string
@@ -1575,63 +3406,53 @@ func BuildSysList(userDefinedClasses map[string]*Hash, userDefinedVariables map[
 
 	// Get all built-in classes
 	classes := CreateClassObjects()
-	classOrder := []string{"io", "type", "time", "os", "math", "string", "file", "pkg", "array", "sys", "keyboard"}
-
 	// Add built-in classes and their methods
-	for _, className := range classOrder {
+	for _, className := range ClassNames {
 		if classHash, ok := classes[className]; ok {
 			// Create a hash of method names in this class
-			methodsHash := &Hash{Pairs: make(map[HashKey]HashPair)}
-			for key, pair := range classHash.Pairs {
-				// Store method name as both key and value for visibility
-				methodsHash.Pairs[key] = HashPair{Key: pair.Key, Value: pair.Key}
-			}
+			methodsHash := namesHash(classHash)
 
 			// Add the class to result
 			classNameStr := &String{Value: className}
-			result.Pairs[classNameStr.HashKey()] = HashPair{
+			result.Set(classNameStr.HashKey(), HashPair{
 				Key:   classNameStr,
 				Value: methodsHash,
-			}
+			})
 		}
 	}
 
 	// Add user-defined imported classes (from pkg.include)
-	for className, classHash := range userDefinedClasses {
+	for _, className := range sortedNames(userDefinedClasses) {
 		// Create a hash of variable names in this class
-		varsHash := &Hash{Pairs: make(map[HashKey]HashPair)}
-		for key, pair := range classHash.Pairs {
-			// Store variable name as both key and value for visibility
-			varsHash.Pairs[key] = HashPair{Key: pair.Key, Value: pair.Key}
-		}
+		varsHash := namesHash(userDefinedClasses[className])
 
 		// Add the imported class to result
 		classNameStr := &String{Value: className}
-		result.Pairs[classNameStr.HashKey()] = HashPair{
+		result.Set(classNameStr.HashKey(), HashPair{
 			Key:   classNameStr,
 			Value: varsHash,
-		}
+		})
 	}
 
 	// Add user-defined local variables (capital letters = global)
 	if userDefinedVariables != nil {
 		varsHash := &Hash{Pairs: make(map[HashKey]HashPair)}
-		for varName := range userDefinedVariables {
+		for _, varName := range sortedNames(userDefinedVariables) {
 			// Only include global variables (starting with capital letter)
 			if len(varName) > 0 && varName[0] >= 'A' && varName[0] <= 'Z' {
 				varNameStr := &String{Value: varName}
-				varsHash.Pairs[varNameStr.HashKey()] = HashPair{
+				varsHash.Set(varNameStr.HashKey(), HashPair{
 					Key:   varNameStr,
 					Value: varNameStr, // Show variable name
-				}
+				})
 			}
 		}
 		if len(varsHash.Pairs) > 0 {
 			localStr := &String{Value: "locals"}
-			result.Pairs[localStr.HashKey()] = HashPair{
+			result.Set(localStr.HashKey(), HashPair{
 				Key:   localStr,
 				Value: varsHash,
-			}
+			})
 		}
 	}
 
@@ -0,0 +1,85 @@
+package object
+
+import (
+	"strconv"
+)
+
+// Bytes is a byte buffer for binary data, which strings would mangle when
+// printed or split. Unlike arrays, bytes.set and bytes.append change the
+// buffer in place.
+type Bytes struct {
+	Value []byte
+	// Frozen is set by type.freeze.
+	Frozen bool
+}
+
+func (b *Bytes) Type() ObjectType { return BYTES_OBJ }
+func (b *Bytes) Inspect() string {
+	return "Bytes(" + strconv.Quote(string(b.Value)) + ")"
+}
+
+// bytesOf builds a buffer from the argument of bytes.new: a size, a string,
+// an array of byte values or another buffer, which is copied.
+func bytesOf(o Object) Object {
+	switch o := o.(type) {
+	case *Integer:
+		if o.Value < 0 {
+			return newError("Argument 0 to `new` must not be negative, got %d", o.Value)
+		}
+		return &Bytes{Value: make([]byte, o.Value)}
+	case *String:
+		return &Bytes{Value: []byte(o.Value)}
+	case *Bytes:
+		return &Bytes{Value: append([]byte(nil), o.Value...)}
+	case *Array:
+		data := make([]byte, len(o.Elements))
+		for i, el := range o.Elements {
+			b, ok := byteValue(el)
+			if !ok {
+				return newError("Element %d of argument 0 to `new` must be an INTEGER from 0 to 255, got %s", i, Brief(el))
+			}
+			data[i] = b
+		}
+		return &Bytes{Value: data}
+	default:
+		return typeError("Argument 0 to `new` must be INTEGER, STRING, ARRAY or BYTES, got %s", o.Type())
+	}
+}
+
+// byteValue returns o as a byte if it is an integer from 0 to 255.
+func byteValue(o Object) (byte, bool) {
+	n, ok := o.(*Integer)
+	if !ok || n.Value < 0 || n.Value > 255 {
+		return 0, false
+	}
+	return byte(n.Value), true
+}
+
+// bytesData returns the content of a string or a buffer, which the file and
+// stream builtins accept alike.
+func bytesData(o Object) ([]byte, bool) {
+	switch o := o.(type) {
+	case *String:
+		return []byte(o.Value), true
+	case *Bytes:
+		return o.Value, true
+	}
+	return nil, false
+}
+
+// bytesArg returns argument i of the bytes builtin name.
+func bytesArg(name string, args []Object, i int) (*Bytes, *Error) {
+	b, ok := args[i].(*Bytes)
+	if !ok {
+		return nil, typeError("Argument %d to `%s` must be BYTES, got %s", i, name, args[i].Type())
+	}
+	return b, nil
+}
+
+// sliceBytes returns a copy of b[start:end].
+func sliceBytes(b *Bytes, start, end int64) Object {
+	if start < 0 || start > end || end > int64(len(b.Value)) {
+		return indexError("Slice %d:%d is out of range (buffer length is %d)", start, end, len(b.Value))
+	}
+	return &Bytes{Value: append([]byte(nil), b.Value[start:end]...)}
+}
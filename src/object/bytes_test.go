@@ -0,0 +1,58 @@
+package object
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestBytesFilesAndStreams(t *testing.T) {
+	call := func(class, name string, args ...Object) Object {
+		t.Helper()
+		builtin := CreateClassObjects()[class].Pairs[(&String{Value: name}).HashKey()].Value.(*Builtin)
+		return builtin.Fn(args...)
+	}
+	str := func(s string) *String { return &String{Value: s} }
+
+	// Bytes that aren't valid UTF-8 must come back unchanged.
+	data := []byte{0x00, 0xff, 0xfe, '\n', 0x80}
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if got := call("file", "write", str(path), &Bytes{Value: data}); got.Type() == ERROR_OBJ {
+		t.Fatalf("file.write failed: %s", got.Inspect())
+	}
+	read, ok := call("file", "read_bytes", str(path)).(*Bytes)
+	if !ok || !bytes.Equal(read.Value, data) {
+		t.Fatalf("expected file.read_bytes to return %v, got %v", data, read)
+	}
+
+	out := call("stream", "open", str(path), str("a"))
+	if got := call("stream", "write", out, &Bytes{Value: []byte{0x01, 0x02}}); got.Inspect() != "2" {
+		t.Errorf("expected 2 bytes written, got %s", got.Inspect())
+	}
+	call("stream", "close", out)
+
+	in := call("stream", "open", str(path))
+	first, ok := call("stream", "read_bytes", in, &Integer{Value: 2}).(*Bytes)
+	if !ok || !bytes.Equal(first.Value, data[:2]) {
+		t.Errorf("expected the first two bytes, got %v", first)
+	}
+	rest, ok := call("stream", "read_bytes", in).(*Bytes)
+	if !ok || !bytes.Equal(rest.Value, []byte{0xfe, '\n', 0x80, 0x01, 0x02}) {
+		t.Errorf("expected the rest of the file, got %v", rest)
+	}
+	if got := call("stream", "read_bytes", in); got.Type() != NULL_OBJ {
+		t.Errorf("expected null at the end of the stream, got %s", got.Inspect())
+	}
+	if got := call("stream", "read_bytes", in, &Integer{Value: 0}); got.Inspect() != "ERROR: Argument 1 to `read_bytes` must be a positive INTEGER, got 0" {
+		t.Errorf("unexpected result for a zero size: %s", got.Inspect())
+	}
+	call("stream", "close", in)
+
+	if got := call("file", "read_bytes", str(filepath.Join(t.TempDir(), "missing"))); got.Type() != ERROR_OBJ {
+		t.Errorf("expected an error reading a missing file, got %s", got.Inspect())
+	}
+
+	if got := (&Bytes{Value: []byte("a\x00")}).Inspect(); got != `Bytes("a\x00")` {
+		t.Errorf("unexpected Inspect output %s", got)
+	}
+}
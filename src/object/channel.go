@@ -0,0 +1,198 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Channel carries values between spawned tasks, like a Go channel.
+type Channel struct {
+	ch chan Object
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewChannel(capacity int) *Channel {
+	return &Channel{ch: make(chan Object, capacity)}
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+func (c *Channel) Inspect() string {
+	return fmt.Sprintf("Channel[%d/%d]", len(c.ch), cap(c.ch))
+}
+
+func (c *Channel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// errDeadlock is returned instead of blocking when no task could ever make
+// a channel operation go through.
+func errDeadlock(op string) *Error {
+	return newError("Deadlock: %s would wait forever, no task is running", op)
+}
+
+// send puts value on the channel, waiting for room while other tasks run.
+func (c *Channel) send(value Object) Object {
+	if c.isClosed() {
+		return newError("Send on closed channel")
+	}
+	select {
+	case c.ch <- value:
+		return &Null{}
+	default:
+	}
+	if !othersCanRun() {
+		return errDeadlock("chan.send")
+	}
+
+	ctx := currentContext
+	sent := false
+	if err := recoverClosed(func() {
+		unlocked(func() {
+			select {
+			case c.ch <- value:
+				sent = true
+			case <-ctx.Done():
+			}
+		})
+	}); err != nil {
+		return err
+	}
+	if !sent {
+		return cancelled(ctx)
+	}
+	return &Null{}
+}
+
+// recv takes the next value off the channel, waiting for one while other
+// tasks run. ok is false once the channel is closed and drained.
+func (c *Channel) recv() (value Object, ok bool, err *Error) {
+	select {
+	case value, ok = <-c.ch:
+		return value, ok, nil
+	default:
+	}
+	if !othersCanRun() {
+		return nil, false, errDeadlock("chan.recv")
+	}
+	ctx := currentContext
+	received := false
+	unlocked(func() {
+		select {
+		case value, ok = <-c.ch:
+			received = true
+		case <-ctx.Done():
+		}
+	})
+	if !received {
+		return nil, false, cancelled(ctx)
+	}
+	return value, ok, nil
+}
+
+func (c *Channel) close() *Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return newError("Close of closed channel")
+	}
+	c.closed = true
+	close(c.ch)
+	return nil
+}
+
+// selectChannels waits until one of cases can go through and runs it. A
+// case is a channel to receive from or a [channel, value] array to send
+// value on. timeout is in milliseconds; a negative one waits forever and 0
+// doesn't wait at all. The result is a hash holding the index of the case
+// that ran, -1 on timeout, the value received and ok, which is false when
+// the channel received from is closed.
+func selectChannels(cases []Object, timeout int64) Object {
+	selectCases := make([]reflect.SelectCase, 0, len(cases)+1)
+	for i, c := range cases {
+		switch c := c.(type) {
+		case *Channel:
+			selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ch)})
+		case *Array:
+			var ch *Channel
+			if len(c.Elements) == 2 {
+				ch, _ = c.Elements[0].(*Channel)
+			}
+			if ch == nil {
+				return typeError("Case %d to `select` must be a CHANNEL or a [CHANNEL, value] array", i)
+			}
+			if ch.isClosed() {
+				return newError("Case %d to `select` sends on a closed channel", i)
+			}
+			send := reflect.ValueOf(&c.Elements[1]).Elem()
+			selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch.ch), Send: send})
+		default:
+			return typeError("Case %d to `select` must be a CHANNEL or a [CHANNEL, value] array, got %s", i, c.Type())
+		}
+	}
+
+	result := func(index int, received reflect.Value, ok bool) Object {
+		var value Object = &Null{}
+		if received.IsValid() && !received.IsNil() {
+			value = received.Interface().(Object)
+		}
+		return stringHash(map[string]Object{
+			"index": &Integer{Value: int64(index)},
+			"value": value,
+			"ok":    &Boolean{Value: ok},
+		})
+	}
+
+	// Try the cases without waiting first.
+	ready := append(selectCases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	if index, received, ok := reflect.Select(ready); index < len(selectCases) {
+		return result(index, received, ok)
+	}
+	if timeout == 0 {
+		return result(-1, reflect.Value{}, false)
+	}
+	if timeout < 0 && !othersCanRun() {
+		return errDeadlock("chan.select")
+	}
+
+	// After the cases come the running code's context and the timer.
+	ctx := currentContext
+	selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer timer.Stop()
+		selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)})
+	}
+	var index int
+	var received reflect.Value
+	var ok bool
+	if err := recoverClosed(func() {
+		unlocked(func() { index, received, ok = reflect.Select(selectCases) })
+	}); err != nil {
+		return err
+	}
+	switch index {
+	case len(cases):
+		return cancelled(ctx)
+	case len(cases) + 1:
+		return result(-1, reflect.Value{}, false)
+	}
+	return result(index, received, ok)
+}
+
+// recoverClosed runs fn, turning the panic of sending on a channel another
+// task closed meanwhile into an error.
+func recoverClosed(fn func()) (err *Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newError("Send on closed channel")
+		}
+	}()
+	fn()
+	return nil
+}
@@ -0,0 +1,63 @@
+package object
+
+// Class is a class defined with `class Name { ... }`. Calling Name.new(...)
+// creates an instance and runs its init method, if it has one, with the
+// arguments.
+type Class struct {
+	Name string
+	// Methods holds closures in compiled code and functions in included
+	// files. Either way their first parameter is self.
+	Methods map[string]Object
+}
+
+func (c *Class) Type() ObjectType { return CLASS_OBJ }
+func (c *Class) Inspect() string  { return "class " + c.Name }
+
+// Instance is a value created from a class. Its fields are set by
+// assigning to them, as in self.x = x.
+type Instance struct {
+	Class  *Class
+	Fields *Hash
+}
+
+func NewInstance(class *Class) *Instance {
+	return &Instance{Class: class, Fields: &Hash{Pairs: map[HashKey]HashPair{}}}
+}
+
+func (i *Instance) Type() ObjectType { return INSTANCE_OBJ }
+func (i *Instance) Inspect() string  { return Format(i) }
+
+// Get returns the field name or, when there is no such field, the method
+// name bound to i. ok is false when there is neither.
+func (i *Instance) Get(name string) (Object, bool) {
+	key := &String{Value: name}
+	if pair, ok := i.Fields.Pairs[key.HashKey()]; ok {
+		return pair.Value, true
+	}
+	if method, ok := i.Class.Methods[name]; ok {
+		return &BoundMethod{Receiver: i, Method: method}, true
+	}
+	return nil, false
+}
+
+// SetField sets the field name, failing when i is frozen.
+func (i *Instance) SetField(name string, value Object) *Error {
+	if err := CheckMutable(i); err != nil {
+		return err
+	}
+	key := &String{Value: name}
+	i.Fields.Set(key.HashKey(), HashPair{Key: key, Value: value})
+	return nil
+}
+
+// BoundMethod is a method read from an instance. Calling it passes the
+// instance as self.
+type BoundMethod struct {
+	Receiver *Instance
+	Method   Object
+}
+
+func (b *BoundMethod) Type() ObjectType { return BOUND_METHOD_OBJ }
+func (b *BoundMethod) Inspect() string {
+	return "method of " + b.Receiver.Class.Name
+}
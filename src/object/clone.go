@@ -0,0 +1,51 @@
+package object
+
+// Clone returns a deep copy of o: arrays, hashes, instances and buffers are
+// copied, along with the ones inside them, so changing the copy never
+// changes o. Values shared within o, including cycles, are shared the same
+// way in the copy. Copies are never frozen. Immutable values are returned as
+// they are.
+func Clone(o Object) Object {
+	return clone(o, map[Object]Object{})
+}
+
+func clone(o Object, copies map[Object]Object) Object {
+	if c, ok := copies[o]; ok {
+		return c
+	}
+	switch o := o.(type) {
+	case *Array:
+		c := &Array{Elements: make([]Object, len(o.Elements))}
+		copies[o] = c
+		for i, el := range o.Elements {
+			c.Elements[i] = clone(el, copies)
+		}
+		return c
+	case *Hash:
+		c := &Hash{Pairs: make(map[HashKey]HashPair, len(o.Pairs))}
+		copies[o] = c
+		for _, pair := range o.Ordered() {
+			key, _ := HashKeyOf(pair.Key)
+			c.Set(key, HashPair{Key: pair.Key, Value: clone(pair.Value, copies)})
+		}
+		return c
+	case *Tuple:
+		// A tuple can't change, but the arrays in it can.
+		c := &Tuple{Elements: make([]Object, len(o.Elements))}
+		copies[o] = c
+		for i, el := range o.Elements {
+			c.Elements[i] = clone(el, copies)
+		}
+		return c
+	case *Bytes:
+		c := &Bytes{Value: append([]byte(nil), o.Value...)}
+		copies[o] = c
+		return c
+	case *Instance:
+		c := &Instance{Class: o.Class}
+		copies[o] = c
+		c.Fields = clone(o.Fields, copies).(*Hash)
+		return c
+	}
+	return o
+}
@@ -0,0 +1,310 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// ToObject converts a Go value into the object a program sees, so hosts
+// embedding the language can hand it configuration and arguments:
+//
+//   - nil and nil pointers, maps, slices and interfaces become null
+//   - bools, integers, floats and strings become BOOLEAN, INTEGER, FLOAT
+//     and STRING; []byte becomes a STRING too
+//   - slices and arrays become arrays
+//   - maps become hashes; their keys must convert to strings, integers or
+//     booleans
+//   - structs become hashes of their exported fields, named by their
+//     `squ1d` tag or else their Go name. A tag of "-" skips the field and
+//     ",omitempty" skips it when it holds its zero value
+//   - objects are returned as they are
+//
+// Pointers and interfaces are followed to the value they hold.
+func ToObject(v interface{}) (Object, error) {
+	return toObject(reflect.ValueOf(v))
+}
+
+func toObject(v reflect.Value) (Object, error) {
+	if !v.IsValid() {
+		return &Null{}, nil
+	}
+	if v.CanInterface() {
+		if o, ok := v.Interface().(Object); ok && (v.Kind() != reflect.Pointer || !v.IsNil()) {
+			return o, nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		return toObject(v.Elem())
+	case reflect.Bool:
+		return &Boolean{Value: v.Bool()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d doesn't fit an INTEGER", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return &Null{}, nil
+			}
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				return &String{Value: string(v.Bytes())}, nil
+			}
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element, err := toObject(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		pairs := make(map[HashKey]HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := toObject(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := toObject(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("[%s]: %w", Brief(key), err)
+			}
+			pairs[hashable.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		return NewHash(pairs), nil
+	case reflect.Struct:
+		// Fields keep their declaration order.
+		hash := &Hash{Pairs: make(map[HashKey]HashPair)}
+		for _, field := range structFields(v.Type()) {
+			fv := v.FieldByIndex(field.index)
+			if field.omitEmpty && fv.IsZero() {
+				continue
+			}
+			value, err := toObject(fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", field.name, err)
+			}
+			key := &String{Value: field.name}
+			hash.Set(key.HashKey(), HashPair{Key: key, Value: value})
+		}
+		return hash, nil
+	}
+	return nil, fmt.Errorf("can't convert %s to an object", v.Type())
+}
+
+// FromObject stores o in the Go value target points to, so hosts can read
+// the results of a program. It is the reverse of ToObject: arrays fill
+// slices and arrays, hashes fill maps and structs, and null leaves the
+// zero value. A hash key matches a struct field by its `squ1d` tag or its
+// name, ignoring case; keys without a field are ignored. INTEGERs fill
+// integer and float values, and an interface{} target gets the plain Go
+// value: int64, float64, string, bool, nil, []interface{} or
+// map[string]interface{}.
+func FromObject(o Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("FromObject needs a non-nil pointer, got %T", target)
+	}
+	return fromObject(o, v.Elem())
+}
+
+func fromObject(o Object, v reflect.Value) error {
+	if _, ok := o.(*Null); ok || o == nil {
+		v.SetZero()
+		return nil
+	}
+	// Object and *String targets, say, take the object itself. interface{}
+	// ones get the plain Go value below.
+	if reflect.TypeOf(o).AssignableTo(v.Type()) && (v.Kind() != reflect.Interface || v.NumMethod() > 0) {
+		v.Set(reflect.ValueOf(o))
+		return nil
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("can't store %s in %s", o.Type(), v.Type())
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return fromObject(o, v.Elem())
+	case reflect.Interface:
+		native := reflect.ValueOf(ToNative(o))
+		if !native.Type().AssignableTo(v.Type()) {
+			return mismatch()
+		}
+		v.Set(native)
+		return nil
+	case reflect.Bool:
+		b, ok := o.(*Boolean)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b.Value)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := integerValue(o)
+		if !ok {
+			return mismatch()
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := integerValue(o)
+		if !ok {
+			return mismatch()
+		}
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%d overflows %s", n, v.Type())
+		}
+		v.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch n := o.(type) {
+		case *Float:
+			v.SetFloat(n.Value)
+		case *Integer:
+			v.SetFloat(float64(n.Value))
+		default:
+			return mismatch()
+		}
+		return nil
+	case reflect.String:
+		s, ok := o.(*String)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s.Value)
+		return nil
+	case reflect.Slice, reflect.Array:
+		if s, ok := o.(*String); ok && v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s.Value))
+			return nil
+		}
+		array, ok := o.(*Array)
+		if !ok {
+			return mismatch()
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(array.Elements), len(array.Elements)))
+		} else if v.Len() != len(array.Elements) {
+			return fmt.Errorf("can't store an array of %d elements in %s", len(array.Elements), v.Type())
+		}
+		for i, element := range array.Elements {
+			if err := fromObject(element, v.Index(i)); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		hash, ok := o.(*Hash)
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.MakeMapWithSize(v.Type(), len(hash.Pairs)))
+		for _, pair := range hash.Pairs {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := fromObject(pair.Key, key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := fromObject(pair.Value, value); err != nil {
+				return fmt.Errorf("[%s]: %w", Brief(pair.Key), err)
+			}
+			v.SetMapIndex(key, value)
+		}
+		return nil
+	case reflect.Struct:
+		hash, ok := o.(*Hash)
+		if !ok {
+			return mismatch()
+		}
+		fields := structFields(v.Type())
+		for _, pair := range hash.Pairs {
+			key, ok := pair.Key.(*String)
+			if !ok {
+				continue
+			}
+			for _, field := range fields {
+				if strings.EqualFold(field.name, key.Value) {
+					if err := fromObject(pair.Value, v.FieldByIndex(field.index)); err != nil {
+						return fmt.Errorf("%s: %w", field.name, err)
+					}
+					break
+				}
+			}
+		}
+		return nil
+	}
+	return mismatch()
+}
+
+// ToNative returns the plain Go value of o: int64, float64, string, bool,
+// nil, []interface{} or map[string]interface{}. Hash keys that aren't
+// strings are skipped and other objects are returned as they print.
+func ToNative(o Object) interface{} {
+	return sqxObjectToNative(o)
+}
+
+func integerValue(o Object) (int64, bool) {
+	switch n := o.(type) {
+	case *Integer:
+		return n.Value, true
+	case *Hex:
+		return n.Value, true
+	}
+	return 0, false
+}
+
+// structField is an exported field of a struct converted by ToObject and
+// FromObject.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, options, _ := strings.Cut(f.Tag.Get("squ1d"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{name: name, index: f.Index, omitEmpty: options == "omitempty"})
+	}
+	return fields
+}
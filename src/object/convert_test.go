@@ -0,0 +1,103 @@
+package object
+
+import (
+	"reflect"
+	"testing"
+)
+
+type convertConfig struct {
+	Name    string            `squ1d:"name"`
+	Port    int               `squ1d:"port"`
+	Ratio   float64           `squ1d:"ratio"`
+	Tags    []string          `squ1d:"tags"`
+	Limits  map[string]uint16 `squ1d:"limits"`
+	Debug   bool              `squ1d:"debug,omitempty"`
+	Parent  *convertConfig    `squ1d:"parent"`
+	Secret  string            `squ1d:"-"`
+	Enabled bool
+	hidden  int
+}
+
+func TestToObject(t *testing.T) {
+	config := convertConfig{
+		Name:   "api",
+		Port:   8080,
+		Ratio:  0.5,
+		Tags:   []string{"a", "b"},
+		Limits: map[string]uint16{"rps": 100},
+		Secret: "x",
+		hidden: 1,
+	}
+	o, err := ToObject(config)
+	if err != nil {
+		t.Fatalf("ToObject: %v", err)
+	}
+	want := map[string]interface{}{
+		"name": "api", "port": int64(8080), "ratio": 0.5, "tags": []interface{}{"a", "b"},
+		"limits": map[string]interface{}{"rps": int64(100)}, "parent": nil, "Enabled": false,
+	}
+	if got := ToNative(o); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, tt := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{[]byte("hi"), "hi"},
+		{map[int]bool{1: true}, "{1: true}"},
+		{[2]interface{}{int8(-1), &Float{Value: 1.5}}, "[-1, 1.5]"},
+	} {
+		o, err := ToObject(tt.value)
+		if err != nil || o.Inspect() != tt.expected {
+			t.Errorf("ToObject(%#v): expected %s, got %v (%v)", tt.value, tt.expected, o, err)
+		}
+	}
+
+	if _, err := ToObject(map[string]interface{}{"f": func() {}}); err == nil || err.Error() != `["f"]: can't convert func() to an object` {
+		t.Errorf("expected an error converting a func, got %v", err)
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	in := convertConfig{Name: "api", Port: 8080, Ratio: 2, Tags: []string{"a"}, Limits: map[string]uint16{"rps": 7},
+		Debug: true, Parent: &convertConfig{Name: "root"}, Enabled: true}
+	o, err := ToObject(in)
+	if err != nil {
+		t.Fatalf("ToObject: %v", err)
+	}
+	var out convertConfig
+	if err := FromObject(o, &out); err != nil {
+		t.Fatalf("FromObject: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip: expected %+v, got %+v", in, out)
+	}
+
+	var native interface{}
+	if err := FromObject(&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "x"}}}, &native); err != nil ||
+		!reflect.DeepEqual(native, []interface{}{int64(1), "x"}) {
+		t.Errorf("expected a native slice, got %#v (%v)", native, err)
+	}
+	var obj Object
+	if err := FromObject(&String{Value: "x"}, &obj); err != nil || obj.Inspect() != "x" {
+		t.Errorf("expected the object itself, got %v (%v)", obj, err)
+	}
+
+	for _, tt := range []struct {
+		o        Object
+		target   interface{}
+		expected string
+	}{
+		{&String{Value: "x"}, new(int), "can't store STRING in int"},
+		{&Integer{Value: 300}, new(uint8), "300 overflows uint8"},
+		{&Array{Elements: []Object{&Boolean{Value: true}}}, new([]string), "[0]: can't store BOOLEAN in string"},
+		{&Integer{Value: 1}, 0, "FromObject needs a non-nil pointer, got int"},
+	} {
+		err := FromObject(tt.o, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("FromObject(%s): expected %q, got %v", tt.o.Inspect(), tt.expected, err)
+		}
+	}
+}
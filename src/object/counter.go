@@ -0,0 +1,42 @@
+package object
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Counter is an integer tasks can update without a mutex: each update is
+// a single atomic step.
+type Counter struct {
+	n atomic.Int64
+}
+
+func (c *Counter) Type() ObjectType { return COUNTER_OBJ }
+func (c *Counter) Inspect() string  { return fmt.Sprintf("Counter[%d]", c.n.Load()) }
+
+// counterAdd returns the builtin sync.name, which adds an integer to a
+// counter and returns the new value. The integer is 1 when it is optional
+// and left out.
+func counterAdd(name string, optional bool) *Builtin {
+	return createBuiltin(func(args ...Object) Object {
+		if optional && len(args) != 1 && len(args) != 2 {
+			return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+		}
+		if !optional && len(args) != 2 {
+			return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+		}
+		c, ok := args[0].(*Counter)
+		if !ok {
+			return typeError("Argument 0 to `%s` must be COUNTER, got %s", name, args[0].Type())
+		}
+		delta := int64(1)
+		if len(args) == 2 {
+			n, ok := args[1].(*Integer)
+			if !ok {
+				return typeError("Argument 1 to `%s` must be INTEGER, got %s", name, args[1].Type())
+			}
+			delta = n.Value
+		}
+		return &Integer{Value: c.n.Add(delta)}
+	}, "sync")
+}
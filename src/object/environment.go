@@ -14,6 +14,9 @@ func NewEnvironment() *Environment {
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	// exports holds the names exported with export statements; nil when
+	// there were none.
+	exports map[string]bool
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -32,3 +35,16 @@ func (e *Environment) Set(name string, val Object) Object {
 func (e *Environment) GetStore() map[string]Object {
 	return e.store
 }
+
+// Export marks name as exported from the file e is the environment of.
+func (e *Environment) Export(name string) {
+	if e.exports == nil {
+		e.exports = map[string]bool{}
+	}
+	e.exports[name] = true
+}
+
+// Exports returns the names marked with Export, nil when there are none.
+func (e *Environment) Exports() map[string]bool {
+	return e.exports
+}
@@ -0,0 +1,69 @@
+package object
+
+// errorNew is error.new: it makes an error value of the given kind, with an
+// optional hash of data for whoever handles it.
+func errorNew(args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+	}
+	kind, ok := args[0].(*String)
+	if !ok {
+		return typeError("Argument 0 to `new` must be STRING, got %s", args[0].Type())
+	}
+	message, ok := args[1].(*String)
+	if !ok {
+		return typeError("Argument 1 to `new` must be STRING, got %s", args[1].Type())
+	}
+
+	value := &Error{Kind: kind.Value, Message: message.Value}
+	if len(args) == 3 {
+		data, ok := args[2].(*Hash)
+		if !ok {
+			return typeError("Argument 2 to `new` must be HASH, got %s", args[2].Type())
+		}
+		value.Data = data
+	}
+	return value
+}
+
+// errorKind is error.kind. Errors without a kind of their own report
+// "Error".
+func errorKind(args ...Object) Object {
+	e, err := errorArgument("kind", args)
+	if err != nil {
+		return err
+	}
+	return &String{Value: e.KindName()}
+}
+
+// errorMsg is error.msg.
+func errorMsg(args ...Object) Object {
+	e, err := errorArgument("msg", args)
+	if err != nil {
+		return err
+	}
+	return &String{Value: e.Message}
+}
+
+// errorData is error.data: the hash given to error.new, or null.
+func errorData(args ...Object) Object {
+	e, err := errorArgument("data", args)
+	if err != nil {
+		return err
+	}
+	if e.Data == nil {
+		return &Null{}
+	}
+	return e.Data
+}
+
+func errorArgument(name string, args []Object) (*Error, *Error) {
+	if len(args) != 1 {
+		return nil, newError("Wrong number of arguments. Expected 1, got %d", len(args))
+	}
+	e, ok := args[0].(*Error)
+	if !ok {
+		return nil, typeError("Argument 0 to `%s` must be ERROR, got %s", name, args[0].Type())
+	}
+	return e, nil
+}
@@ -0,0 +1,118 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StackFrame is a call that was running when an error was raised.
+type StackFrame struct {
+	// Function is the name of the function, "<main>" for top-level code
+	// and "<anonymous>" for unnamed functions.
+	Function string
+	File     string
+	// Line is the line the call was at, 0 when it isn't known.
+	Line int
+}
+
+func (f StackFrame) String() string {
+	switch {
+	case f.File != "" && f.Line > 0:
+		return fmt.Sprintf("in %s (%s:%d)", f.Function, f.File, f.Line)
+	case f.Line > 0:
+		return fmt.Sprintf("in %s (line %d)", f.Function, f.Line)
+	}
+	return "in " + f.Function
+}
+
+// RuntimeError is an error raised while running compiled code. Its message
+// names the position, when the code comes from a known file, and quotes the
+// source line with a caret under the failing expression, like parser errors
+// do. Errors raised inside a function call list the calls that led to it,
+// outermost first.
+type RuntimeError struct {
+	Err      error
+	Filename string
+	Line     int
+	// Column is 0 when only the statement's line is known.
+	Column int
+	Stack  []StackFrame
+}
+
+func (e *RuntimeError) Error() string {
+	message := e.Err.Error()
+	if e.Filename != "" {
+		position := fmt.Sprintf("%s, line %d", e.Filename, e.Line)
+		if e.Column > 0 {
+			position += fmt.Sprintf(", column %d", e.Column)
+		}
+		message = WithSourceContext(position+": "+message, e.Filename, e.Line, e.Column)
+	}
+
+	// A lone frame is the position the message already names.
+	if len(e.Stack) < 2 {
+		return message
+	}
+	frames := make([]string, len(e.Stack))
+	for i, frame := range e.Stack {
+		frames[i] = frame.String()
+	}
+	var out strings.Builder
+	out.WriteString(message)
+	out.WriteString("\n\nTraceback:")
+	for _, line := range TracebackLines(frames) {
+		out.WriteString("\n  " + line)
+	}
+	return out.String()
+}
+
+// MaxTracebackLines is how many lines a traceback lists at most. Longer
+// ones keep their outermost and innermost calls.
+const MaxTracebackLines = 40
+
+// TracebackLines returns the lines that list frames in a traceback. Runs of
+// the same frame, as left by deep recursion, are listed once and followed
+// by how often they repeat, and at most MaxTracebackLines lines are listed.
+func TracebackLines(frames []string) []string {
+	var lines []string
+	for i := 0; i < len(frames); {
+		run := 1
+		for i+run < len(frames) && frames[i+run] == frames[i] {
+			run++
+		}
+		lines = append(lines, frames[i])
+		switch {
+		case run == 2:
+			lines = append(lines, frames[i])
+		case run > 2:
+			lines = append(lines, fmt.Sprintf("... repeated %d more times", run-1))
+		}
+		i += run
+	}
+
+	if len(lines) <= MaxTracebackLines {
+		return lines
+	}
+	keep := (MaxTracebackLines - 1) / 2
+	omitted := len(lines) - 2*keep
+	capped := append([]string(nil), lines[:keep]...)
+	capped = append(capped, fmt.Sprintf("... %d more lines", omitted))
+	return append(capped, lines[len(lines)-keep:]...)
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// KindError is a runtime error of a kind, such as "TypeError". Rescue
+// blocks get it as an error value of that kind.
+type KindError struct {
+	Kind    string
+	Message string
+}
+
+func (e *KindError) Error() string { return e.Kind + ": " + e.Message }
+
+// NewTypeError returns the KindError of a value of the wrong type for an
+// operation, like indexing an integer.
+func NewTypeError(format string, a ...interface{}) error {
+	return &KindError{Kind: "TypeError", Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,356 @@
+package object
+
+import (
+	"bufio"
+	"sync"
+	"time"
+)
+
+// EventHandlers maps event names to the functions registered for them with
+// event.on, in the order they were registered. event.emit, the event loop
+// and VM.TriggerEvent call them.
+var EventHandlers map[string][]Object
+
+// eventTimer is a callback scheduled with event.after or event.every.
+type eventTimer struct {
+	id  int64
+	due time.Time
+	// every is 0 for a timer that fires once.
+	every time.Duration
+	fn    Object
+}
+
+// queuedEvent is an event posted with event.post or PostEvent, waiting
+// for the event loop to dispatch it.
+type queuedEvent struct {
+	name string
+	args []Object
+}
+
+var events struct {
+	sync.Mutex
+	timers  []*eventTimer
+	queue   []queuedEvent
+	nextID  int64
+	running bool
+	stopped bool
+}
+
+// eventPosted wakes the event loop when an event is posted.
+var eventPosted = make(chan struct{}, 1)
+
+// taskPoll is how often the event loop, with nothing else to wait for,
+// checks whether the tasks that could still post events have finished.
+const taskPoll = 10 * time.Millisecond
+
+// stdinLines receives the lines of stdin once a "line" handler made the
+// event loop start reading it. It is closed at the end of the input.
+var (
+	stdinLines     chan string
+	stdinLinesOnce sync.Once
+)
+
+func onEvent(name string, fn Object) {
+	events.Lock()
+	defer events.Unlock()
+	if EventHandlers == nil {
+		EventHandlers = map[string][]Object{}
+	}
+	EventHandlers[name] = append(EventHandlers[name], fn)
+}
+
+// offEvent removes fn from the handlers of name, or all of them when fn is
+// nil, and reports whether any were removed.
+func offEvent(name string, fn Object) bool {
+	events.Lock()
+	defer events.Unlock()
+	handlers := EventHandlers[name]
+	if fn == nil {
+		delete(EventHandlers, name)
+		return len(handlers) > 0
+	}
+	for i, h := range handlers {
+		if h == fn {
+			EventHandlers[name] = append(handlers[:i:i], handlers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func eventHandlers(name string) []Object {
+	events.Lock()
+	defer events.Unlock()
+	return append([]Object(nil), EventHandlers[name]...)
+}
+
+// Handlers returns the handlers of the event name, in the order they were
+// registered.
+func Handlers(name string) []Object {
+	return eventHandlers(name)
+}
+
+// PostEvent queues the event name with args for the event loop, which
+// calls its handlers after the events posted before it. It doesn't wait
+// for them, so Go code can call it from any goroutine, like a server
+// handing requests to a script running event.run.
+func PostEvent(name string, args ...Object) {
+	events.Lock()
+	events.queue = append(events.queue, queuedEvent{name: name, args: args})
+	events.Unlock()
+	select {
+	case eventPosted <- struct{}{}:
+	default:
+	}
+}
+
+// nextQueued takes the event posted first off the queue.
+func nextQueued() (queuedEvent, bool) {
+	events.Lock()
+	defer events.Unlock()
+	if len(events.queue) == 0 {
+		return queuedEvent{}, false
+	}
+	e := events.queue[0]
+	events.queue = events.queue[1:]
+	return e, true
+}
+
+// emitEvent calls the handlers of name with args, one after the other, and
+// returns how many ran. It stops at the first handler that fails.
+func emitEvent(call Caller, name string, args []Object) Object {
+	handlers := eventHandlers(name)
+	for _, h := range handlers {
+		if n := parameterCount(h); n >= 0 && n != len(args) {
+			return newError("Handler parameter mismatch for event '%s': expected %d, got %d", name, n, len(args))
+		}
+		if result := call(h, args...); isError(result) {
+			return result
+		}
+	}
+	return &Integer{Value: int64(len(handlers))}
+}
+
+// addTimer schedules fn to run after d, and then every d when repeat is set,
+// and returns the timer's id.
+func addTimer(d time.Duration, fn Object, repeat bool) int64 {
+	events.Lock()
+	defer events.Unlock()
+	events.nextID++
+	t := &eventTimer{id: events.nextID, due: time.Now().Add(d), fn: fn}
+	if repeat {
+		t.every = d
+	}
+	events.timers = append(events.timers, t)
+	return t.id
+}
+
+func cancelTimer(id int64) bool {
+	events.Lock()
+	defer events.Unlock()
+	for i, t := range events.timers {
+		if t.id == id {
+			events.timers = append(events.timers[:i], events.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// nextTimer returns the timer due first, nil when none are scheduled.
+func nextTimer() *eventTimer {
+	events.Lock()
+	defer events.Unlock()
+	var next *eventTimer
+	for _, t := range events.timers {
+		if next == nil || t.due.Before(next.due) {
+			next = t
+		}
+	}
+	return next
+}
+
+// fireTimer reschedules t when it repeats and removes it otherwise. It
+// reports whether t was still scheduled.
+func fireTimer(t *eventTimer) bool {
+	events.Lock()
+	defer events.Unlock()
+	for i, scheduled := range events.timers {
+		if scheduled != t {
+			continue
+		}
+		if t.every > 0 {
+			t.due = t.due.Add(t.every)
+			if now := time.Now(); t.due.Before(now) {
+				// Don't try to catch up on missed ticks.
+				t.due = now.Add(t.every)
+			}
+		} else {
+			events.timers = append(events.timers[:i], events.timers[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
+
+func readStdinLines() chan string {
+	stdinLinesOnce.Do(func() {
+		stdinLines = make(chan string, 16)
+		go func() {
+			defer close(stdinLines)
+			scanner := bufio.NewScanner(stdinStream.r)
+			for scanner.Scan() {
+				stdinLines <- scanner.Text()
+			}
+		}()
+	})
+	return stdinLines
+}
+
+// runEvents runs the event loop: it dispatches posted events in order,
+// calls timer callbacks when they are due, "key" handlers with each key
+// pressed and "line" handlers with each line of stdin. It returns once no
+// events are queued, no timers are left, no handlers wait for keys or lines
+// and no tasks that could post events are running, after event.stop, or
+// with the error of a failing callback.
+func runEvents(call Caller) Object {
+	events.Lock()
+	if events.running {
+		events.Unlock()
+		return newError("The event loop is already running")
+	}
+	events.running, events.stopped = true, false
+	events.Unlock()
+	defer func() {
+		events.Lock()
+		events.running = false
+		events.Unlock()
+	}()
+
+	keyboardStarted := false
+	defer func() {
+		if keyboardStarted {
+			stopKeyboardListener()
+		}
+	}()
+	stdinDone := false
+
+	for {
+		events.Lock()
+		stopped := events.stopped
+		events.Unlock()
+		if stopped {
+			return &Null{}
+		}
+
+		if e, ok := nextQueued(); ok {
+			if err, ok := emitEvent(call, e.name, e.args).(*Error); ok {
+				return err
+			}
+			continue
+		}
+
+		timer := nextTimer()
+		var keys <-chan KeyboardEvent
+		if len(eventHandlers("key")) > 0 {
+			if !keyboardStarted {
+				_ = enableRawMode()
+				startKeyboardListener()
+				keyboardStarted = true
+			}
+			keys = keyboardEvents
+		}
+		var lines <-chan string
+		if !stdinDone && len(eventHandlers("line")) > 0 {
+			lines = readStdinLines()
+		}
+		if timer == nil && keys == nil && lines == nil && !TasksRunning() {
+			return &Null{}
+		}
+
+		var due <-chan time.Time
+		var wait *time.Timer
+		if timer != nil {
+			wait = time.NewTimer(time.Until(timer.due))
+			due = wait.C
+		} else if keys == nil && lines == nil {
+			// Only tasks keep the loop running: look again when they may
+			// have finished.
+			wait = time.NewTimer(taskPoll)
+			due = wait.C
+		}
+
+		ctx := currentContext
+		var (
+			fired           bool
+			woken           bool
+			key             KeyboardEvent
+			gotKey          bool
+			line            string
+			gotLine, lineOK bool
+		)
+		unlocked(func() {
+			select {
+			case <-due:
+				fired = true
+			case <-eventPosted:
+				woken = true
+			case key = <-keys:
+				gotKey = true
+			case line, lineOK = <-lines:
+				gotLine = true
+			case <-ctx.Done():
+			}
+		})
+		if wait != nil {
+			wait.Stop()
+		}
+
+		var result Object
+		switch {
+		case woken, fired && timer == nil:
+			// Dispatch the posted events, or look at the tasks again.
+		case fired:
+			if fireTimer(timer) {
+				result = call(timer.fn)
+			}
+		case gotKey:
+			result = emitEvent(call, "key", []Object{&String{Value: key.Key}})
+		case gotLine && lineOK:
+			result = emitEvent(call, "line", []Object{&String{Value: line}})
+		case gotLine:
+			stdinDone = true
+		default:
+			return cancelled(ctx)
+		}
+		if err, ok := result.(*Error); ok {
+			return err
+		}
+	}
+}
+
+func stopEvents() {
+	events.Lock()
+	defer events.Unlock()
+	events.stopped = true
+}
+
+// scheduleTimer is event.after and event.every: it checks their arguments,
+// a delay in milliseconds and a function without parameters, and returns
+// the id of the new timer.
+func scheduleTimer(name string, args []Object, repeat bool) Object {
+	if len(args) != 2 {
+		return newError("Wrong number of arguments. Expected 2, got %d", len(args))
+	}
+	ms, ok := args[0].(*Integer)
+	if !ok {
+		return typeError("Argument 0 to `%s` must be INTEGER, got %s", name, args[0].Type())
+	}
+	if ms.Value < 0 || (repeat && ms.Value == 0) {
+		return newError("Argument 0 to `%s` must be a positive number of milliseconds, got %d", name, ms.Value)
+	}
+	if err := checkCallback(name, 1, args[1]); err != nil {
+		return err
+	}
+	return &Integer{Value: addTimer(time.Duration(ms.Value)*time.Millisecond, args[1], repeat)}
+}
@@ -0,0 +1,151 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Formatter turns values into text. Strings are written raw at the top
+// level and quoted inside containers, floats always use formatFloat, and a
+// container that holds itself is written as [...] instead of recursing.
+type Formatter struct {
+	// MaxDepth is how deep containers are expanded; deeper ones are
+	// written as [...]. 0 means no limit.
+	MaxDepth int
+	// MaxItems is how many elements of a container are written before the
+	// rest is summed up as "... (n more)". 0 means no limit.
+	MaxItems int
+	// MaxLength cuts the whole text down to that many runes. 0 means no
+	// limit.
+	MaxLength int
+	// Quote quotes a string even at the top level.
+	Quote bool
+}
+
+var (
+	// DisplayFormat is used by Inspect, io.print and the REPL.
+	DisplayFormat = Formatter{MaxDepth: 64}
+	// BriefFormat keeps values short enough to quote in error messages and
+	// traces.
+	BriefFormat = Formatter{MaxDepth: 3, MaxItems: 8, MaxLength: 60, Quote: true}
+)
+
+// Format writes o the way io.print and the REPL show it.
+func Format(o Object) string { return DisplayFormat.Format(o) }
+
+// Brief writes o for an error message: strings are quoted and long values
+// are cut short.
+func Brief(o Object) string { return BriefFormat.Format(o) }
+
+// Format writes o within the limits of f.
+func (f Formatter) Format(o Object) string {
+	var sb strings.Builder
+	if s, ok := o.(*String); ok && !f.Quote {
+		sb.WriteString(s.Value)
+	} else {
+		w := &formatWriter{f: f, sb: &sb, seen: map[Object]bool{}}
+		w.write(o, 0)
+	}
+	text := sb.String()
+	if f.MaxLength > 0 {
+		if runes := []rune(text); len(runes) > f.MaxLength {
+			text = string(runes[:f.MaxLength-3]) + "..."
+		}
+	}
+	return text
+}
+
+type formatWriter struct {
+	f  Formatter
+	sb *strings.Builder
+	// seen holds the containers being written, so one that holds itself is
+	// caught. Sharing a container in two places is fine.
+	seen map[Object]bool
+}
+
+func (w *formatWriter) write(o Object, depth int) {
+	switch o := o.(type) {
+	case *String:
+		w.sb.WriteString(strconv.Quote(o.Value))
+	case *Array:
+		w.container(o, depth, "[", "]", len(o.Elements), func(i int) {
+			w.write(o.Elements[i], depth+1)
+		})
+	case *Tuple:
+		close := ")"
+		if len(o.Elements) == 1 {
+			close = ",)"
+		}
+		w.container(o, depth, "(", close, len(o.Elements), func(i int) {
+			w.write(o.Elements[i], depth+1)
+		})
+	case *Hash:
+		pairs := o.Ordered()
+		w.container(o, depth, "{", "}", len(pairs), func(i int) {
+			w.write(pairs[i].Key, depth+1)
+			w.sb.WriteString(": ")
+			w.write(pairs[i].Value, depth+1)
+		})
+	case *Set:
+		if len(o.order) == 0 {
+			// {} is an empty hash.
+			w.sb.WriteString("set.new()")
+			return
+		}
+		items := o.Items()
+		w.container(o, depth, "{", "}", len(items), func(i int) {
+			w.write(items[i], depth+1)
+		})
+	case *Instance:
+		pairs := o.Fields.Ordered()
+		w.sb.WriteString(o.Class.Name)
+		w.container(o, depth, "{", "}", len(pairs), func(i int) {
+			w.sb.WriteString(pairs[i].Key.(*String).Value + ": ")
+			w.write(pairs[i].Value, depth+1)
+		})
+	case nil:
+		w.sb.WriteString("null")
+	default:
+		w.sb.WriteString(o.Inspect())
+	}
+}
+
+// container writes the n elements of o between open and close, stopping at
+// cycles and at the limits of the formatter.
+func (w *formatWriter) container(o Object, depth int, open, close string, n int, element func(i int)) {
+	if w.seen[o] || (w.f.MaxDepth > 0 && depth >= w.f.MaxDepth && n > 0) {
+		w.sb.WriteString(open + "..." + strings.TrimPrefix(close, ","))
+		return
+	}
+	w.seen[o] = true
+	defer delete(w.seen, o)
+
+	w.sb.WriteString(open)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			w.sb.WriteString(", ")
+		}
+		if w.f.MaxItems > 0 && i == w.f.MaxItems {
+			fmt.Fprintf(w.sb, "... (%d more)", n-i)
+			break
+		}
+		element(i)
+	}
+	w.sb.WriteString(close)
+}
+
+// formatFloat writes a float with up to 15 significant digits, which hides
+// rounding noise such as 0.1 + 0.2, and switches to an exponent for very
+// large and very small numbers. Whole numbers have no decimal point.
+func formatFloat(v float64) string {
+	if v == 0 {
+		// Also turns -0 into 0.
+		return "0"
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 15, 64)
+}
@@ -0,0 +1,63 @@
+package object
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	self := &Array{Elements: []Object{&Integer{Value: 1}}}
+	self.Elements = append(self.Elements, self)
+	shared := &Array{Elements: []Object{&String{Value: "x"}}}
+	nested := &Array{Elements: []Object{&Array{Elements: []Object{&Array{Elements: []Object{&Integer{Value: 1}}}}}}}
+	long := &Array{}
+	for i := 0; i < 10; i++ {
+		long.Elements = append(long.Elements, &Integer{Value: int64(i)})
+	}
+	h := NewHash(nil)
+	k := &String{Value: "say \"hi\"\n"}
+	h.Set(k.HashKey(), HashPair{Key: k, Value: &Float{Value: 0.1 + 0.2}})
+
+	tests := []struct {
+		f        Formatter
+		value    Object
+		expected string
+	}{
+		{DisplayFormat, &String{Value: "a\tb"}, "a\tb"},
+		{DisplayFormat, &Array{Elements: []Object{&String{Value: "a\tb"}, &Null{}}}, `["a\tb", null]`},
+		{DisplayFormat, h, `{"say \"hi\"\n": 0.3}`},
+		{DisplayFormat, &Tuple{Elements: []Object{&String{Value: "a"}}}, `("a",)`},
+		{DisplayFormat, self, "[1, [...]]"},
+		{DisplayFormat, &Array{Elements: []Object{shared, shared}}, `[["x"], ["x"]]`},
+		{Formatter{MaxDepth: 2}, nested, "[[[...]]]"},
+		{Formatter{MaxItems: 3}, long, "[0, 1, 2, ... (7 more)]"},
+		{Formatter{MaxLength: 10}, long, "[0, 1, ..."},
+		{BriefFormat, &String{Value: "a"}, `"a"`},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Format(tt.value); got != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, got)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2"},
+		{-1.5, "-1.5"},
+		{0.1 + 0.2, "0.3"},
+		{1.0 / 3, "0.333333333333333"},
+		{1e20, "1e+20"},
+		{1e-7, "1e-07"},
+		{math.Copysign(0, -1), "0"},
+		{math.Inf(-1), "-Inf"},
+	}
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, got)
+		}
+	}
+}
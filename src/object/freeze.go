@@ -0,0 +1,141 @@
+package object
+
+// Freeze makes o immutable, along with the arrays, hashes, instances and
+// buffers inside it, so a shared constant can't be changed through one of
+// its references. Index assignment and the builtins that change values in
+// place return an error for frozen values. Other values never change and
+// are left alone.
+func Freeze(o Object) {
+	switch o := o.(type) {
+	case *Array:
+		if o.Frozen {
+			return
+		}
+		o.Frozen = true
+		for _, el := range o.Elements {
+			Freeze(el)
+		}
+	case *Hash:
+		if o.Frozen {
+			return
+		}
+		o.Frozen = true
+		for _, pair := range o.Pairs {
+			Freeze(pair.Value)
+		}
+	case *Bytes:
+		o.Frozen = true
+	case *Instance:
+		Freeze(o.Fields)
+	}
+}
+
+// IsFrozen reports whether o was frozen by type.freeze.
+func IsFrozen(o Object) bool {
+	switch o := o.(type) {
+	case *Array:
+		return o.Frozen
+	case *Hash:
+		return o.Frozen
+	case *Bytes:
+		return o.Frozen
+	case *Instance:
+		return o.Fields.Frozen
+	}
+	return false
+}
+
+// CheckMutable returns an error if o is frozen, or if it is shared by the
+// workers of array.pmap.
+func CheckMutable(o Object) *Error {
+	if IsFrozen(o) {
+		return newError("Cannot modify a frozen %s", o.Type())
+	}
+	if isShared(o) {
+		return newError("Cannot modify a %s shared by array.pmap workers; return the changes instead", o.Type())
+	}
+	return nil
+}
+
+// Share makes the arrays, hashes and captured variables reachable from
+// roots read-only until the returned function is called, so the goroutines
+// of a parallel section can read them but none can change them while
+// another reads. Values already shared by an enclosing section are left to
+// it.
+func Share(roots ...Object) (release func()) {
+	var arrays []*Array
+	var hashes []*Hash
+	var cells []*Cell
+	seen := map[*Closure]bool{}
+	var share func(o Object)
+	share = func(o Object) {
+		switch o := o.(type) {
+		case *Array:
+			if o.Frozen || o.shared {
+				return
+			}
+			o.shared = true
+			arrays = append(arrays, o)
+			for _, el := range o.Elements {
+				share(el)
+			}
+		case *Hash:
+			if o.Frozen || o.shared {
+				return
+			}
+			o.shared = true
+			hashes = append(hashes, o)
+			for _, pair := range o.Pairs {
+				share(pair.Value)
+			}
+		case *Instance:
+			share(o.Fields)
+		case *Tuple:
+			for _, el := range o.Elements {
+				share(el)
+			}
+		case *Closure:
+			if seen[o] {
+				return
+			}
+			seen[o] = true
+			for _, free := range o.Free {
+				share(free)
+			}
+		case *Cell:
+			if o.shared {
+				return
+			}
+			o.shared = true
+			cells = append(cells, o)
+			share(o.Value)
+		}
+	}
+	for _, root := range roots {
+		share(root)
+	}
+
+	return func() {
+		for _, a := range arrays {
+			a.shared = false
+		}
+		for _, h := range hashes {
+			h.shared = false
+		}
+		for _, c := range cells {
+			c.shared = false
+		}
+	}
+}
+
+func isShared(o Object) bool {
+	switch o := o.(type) {
+	case *Array:
+		return o.shared
+	case *Hash:
+		return o.shared
+	case *Instance:
+		return o.Fields.shared
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package object
+
+import (
+	"context"
+	"fmt"
+)
+
+// Widget is a window or a control in one, created by the gui class. It
+// only describes the widget; the toolkit builds the real one when gui.run
+// shows its window.
+type Widget struct {
+	// kind is "window", "label", "button" or "input".
+	kind string
+	id   int64
+	// text is a window's title, a label's or button's text and what was
+	// typed into an input.
+	text        string
+	placeholder string
+	// width and height are a window's size, 0 for the toolkit's default.
+	width, height int
+	// children are the widgets of a window, top to bottom.
+	children []*Widget
+	// native is the toolkit's widget once it was built.
+	native interface{}
+}
+
+func (w *Widget) Type() ObjectType { return WIDGET_OBJ }
+func (w *Widget) Inspect() string {
+	return fmt.Sprintf("Widget[%s %q]", w.kind, w.text)
+}
+
+// guiToolkit shows widgets on screen. It is nil unless the interpreter was
+// built with the gui tag.
+type guiToolkit interface {
+	// build builds windows and their widgets. gui.run calls it before run,
+	// still holding the interpreter, so the widgets don't change meanwhile.
+	build(windows []*Widget)
+	// run shows the windows and handles their events until all of them are
+	// closed or quit is called. It calls guiEvent for clicks and typing.
+	run()
+	// show shows a window created after run started.
+	show(window *Widget)
+	// add shows w, added to window after run started.
+	add(window, w *Widget)
+	// setText shows the new text of w.
+	setText(w *Widget, text string)
+	quit()
+}
+
+var toolkit guiToolkit
+
+var gui struct {
+	windows []*Widget
+	nextID  int64
+	started bool
+	running bool
+	// vm and ctx are those of the code that called gui.run, which the
+	// handlers run with.
+	vm  VMInfo
+	ctx context.Context
+	// err is the error of the first handler that failed.
+	err *Error
+}
+
+func newWidget(kind, text string) *Widget {
+	gui.nextID++
+	return &Widget{kind: kind, id: gui.nextID, text: text}
+}
+
+func newWindow(title string, width, height int) *Widget {
+	w := newWidget("window", title)
+	w.width, w.height = width, height
+	gui.windows = append(gui.windows, w)
+	if gui.running {
+		toolkit.show(w)
+	}
+	return w
+}
+
+// addWidget puts w at the bottom of window.
+func addWidget(window, w *Widget) *Widget {
+	window.children = append(window.children, w)
+	if gui.running {
+		toolkit.add(window, w)
+	}
+	return w
+}
+
+// guiEventName is the name of the event a widget's handlers are
+// registered for.
+func guiEventName(w *Widget, event string) string {
+	return fmt.Sprintf("gui.%s.%d", event, w.id)
+}
+
+func setWidgetText(w *Widget, text string) {
+	w.text = text
+	if gui.running {
+		toolkit.setText(w, text)
+	}
+}
+
+// guiEvent is called by the toolkit, on its own goroutine, when a button is
+// clicked ("click") or the text of an input changes ("change"). It runs
+// the handlers of the widget like event.emit. The first handler that fails
+// ends gui.run with its error.
+func guiEvent(w *Widget, event, text string) {
+	leave := Enter()
+	defer leave()
+	RunningVM, currentContext = gui.vm, gui.ctx
+
+	var args []Object
+	if event == "change" {
+		w.text = text
+		args = []Object{&String{Value: text}}
+	}
+	if err, ok := emitEvent(ForkCall, guiEventName(w, event), args).(*Error); ok && gui.err == nil {
+		gui.err = err
+		toolkit.quit()
+	}
+}
+
+// runGUI shows the windows created so far and runs until they are all
+// closed, gui.quit is called or a handler fails.
+func runGUI() Object {
+	if toolkit == nil {
+		return newError("GUI support isn't built in; build the interpreter with `go build -tags gui`")
+	}
+	if RunningVM == nil {
+		return newError("gui.run needs compiled code; it can't be used in included files")
+	}
+	if gui.started {
+		return newError("gui.run can only be called once")
+	}
+	if len(gui.windows) == 0 {
+		return newError("gui.run needs a window; create one with gui.window")
+	}
+	gui.started, gui.running = true, true
+	gui.vm, gui.ctx = RunningVM, currentContext
+	ctx := currentContext
+	toolkit.build(gui.windows)
+	done := make(chan struct{})
+	unlocked(func() {
+		go func() {
+			select {
+			case <-ctx.Done():
+				toolkit.quit()
+			case <-done:
+			}
+		}()
+		toolkit.run()
+		close(done)
+	})
+	gui.running = false
+	if ctx.Err() != nil {
+		return cancelled(ctx)
+	}
+	if gui.err != nil {
+		return gui.err
+	}
+	return &Null{}
+}
+
+func quitGUI() {
+	if gui.running {
+		toolkit.quit()
+	}
+}
+
+// widgetArg returns argument i of the gui builtin name, which must be a
+// widget of kind, or any widget when kind is empty.
+func widgetArg(name string, args []Object, i int, kind string) (*Widget, *Error) {
+	w, ok := args[i].(*Widget)
+	if !ok {
+		return nil, typeError("Argument %d to `%s` must be WIDGET, got %s", i, name, args[i].Type())
+	}
+	if kind != "" && w.kind != kind {
+		return nil, typeError("Argument %d to `%s` must be a %s WIDGET, got %s", i, name, kind, Brief(w))
+	}
+	return w, nil
+}
+
+// handlerArg returns argument i of the gui builtin name, which must be a
+// closure with params parameters.
+func handlerArg(name string, args []Object, i, params int) (*Closure, *Error) {
+	fn, ok := args[i].(*Closure)
+	if !ok || fn.Fn.NumParameters != params {
+		return nil, typeError("Argument %d to `%s` must be a CLOSURE with %d parameters, got %s", i, name, params, Brief(args[i]))
+	}
+	return fn, nil
+}
@@ -0,0 +1,102 @@
+//go:build gui
+
+package object
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// fyneToolkit shows the gui class's widgets with Fyne. Fyne needs its event
+// loop to run on the main goroutine, which the file runner and the REPL
+// run programs on, and calls the handlers there too.
+type fyneToolkit struct {
+	app fyne.App
+}
+
+// fyneWindow is the native widget of a window.
+type fyneWindow struct {
+	window fyne.Window
+	box    *fyne.Container
+}
+
+func init() {
+	toolkit = &fyneToolkit{}
+}
+
+func (t *fyneToolkit) build(windows []*Widget) {
+	t.app = app.New()
+	for _, w := range windows {
+		t.buildWindow(w)
+	}
+}
+
+func (t *fyneToolkit) buildWindow(w *Widget) {
+	native := &fyneWindow{window: t.app.NewWindow(w.text), box: container.NewVBox()}
+	for _, child := range w.children {
+		native.box.Add(t.buildWidget(child))
+	}
+	native.window.SetContent(native.box)
+	if w.width > 0 {
+		native.window.Resize(fyne.NewSize(float32(w.width), float32(w.height)))
+	}
+	native.window.Show()
+	w.native = native
+}
+
+func (t *fyneToolkit) buildWidget(w *Widget) fyne.CanvasObject {
+	switch w.kind {
+	case "label":
+		w.native = widget.NewLabel(w.text)
+	case "button":
+		w.native = widget.NewButton(w.text, func() { guiEvent(w, "click", "") })
+	case "input":
+		entry := widget.NewEntry()
+		entry.SetPlaceHolder(w.placeholder)
+		entry.SetText(w.text)
+		entry.OnChanged = func(text string) { guiEvent(w, "change", text) }
+		w.native = entry
+	}
+	return w.native.(fyne.CanvasObject)
+}
+
+func (t *fyneToolkit) run() {
+	t.app.Run()
+}
+
+func (t *fyneToolkit) show(window *Widget) {
+	fyne.Do(func() { t.buildWindow(window) })
+}
+
+func (t *fyneToolkit) add(window, w *Widget) {
+	fyne.Do(func() {
+		if native, ok := window.native.(*fyneWindow); ok {
+			native.box.Add(t.buildWidget(w))
+		}
+	})
+}
+
+func (t *fyneToolkit) setText(w *Widget, text string) {
+	fyne.Do(func() {
+		switch native := w.native.(type) {
+		case *fyneWindow:
+			native.window.SetTitle(text)
+		case *widget.Label:
+			native.SetText(text)
+		case *widget.Button:
+			native.SetText(text)
+		case *widget.Entry:
+			// The program changed the text; don't report it as typing.
+			onChanged := native.OnChanged
+			native.OnChanged = nil
+			native.SetText(text)
+			native.OnChanged = onChanged
+		}
+	})
+}
+
+func (t *fyneToolkit) quit() {
+	fyne.Do(t.app.Quit)
+}
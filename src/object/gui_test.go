@@ -0,0 +1,157 @@
+package object
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeToolkit records what gui asks of a toolkit; run plays the events in
+// script.
+type fakeToolkit struct {
+	built  []*Widget
+	calls  []string
+	script func()
+}
+
+func (t *fakeToolkit) build(windows []*Widget) { t.built = windows }
+func (t *fakeToolkit) run()                    { t.script() }
+func (t *fakeToolkit) show(window *Widget)     { t.calls = append(t.calls, "show "+window.text) }
+func (t *fakeToolkit) add(window, w *Widget) {
+	t.calls = append(t.calls, "add "+w.kind+" to "+window.text)
+}
+func (t *fakeToolkit) setText(w *Widget, text string) { t.calls = append(t.calls, "set "+text) }
+func (t *fakeToolkit) quit()                          { t.calls = append(t.calls, "quit") }
+
+// handlerVM runs the handlers of gui events as Go functions standing for
+// closures.
+type handlerVM struct {
+	VMInfo
+	handlers map[*Closure]func(args []Object) Object
+}
+
+func (vm *handlerVM) Fork(fn *Closure, args []Object) (Object, error) {
+	if result, ok := vm.handlers[fn](args).(*Error); ok {
+		return nil, errors.New(result.Message)
+	}
+	return &Null{}, nil
+}
+
+func TestGUI(t *testing.T) {
+	call := func(name string, args ...Object) Object {
+		t.Helper()
+		builtin := CreateClassObjects()["gui"].Pairs[(&String{Value: name}).HashKey()].Value.(*Builtin)
+		return builtin.Fn(args...)
+	}
+	str := func(s string) *String { return &String{Value: s} }
+	closure := func(params int) *Closure {
+		return &Closure{Fn: &CompiledFunction{NumParameters: params}}
+	}
+	saved := toolkit
+	t.Cleanup(func() {
+		toolkit, RunningVM, EventHandlers = saved, nil, nil
+		gui.windows, gui.started, gui.err = nil, false, nil
+	})
+
+	toolkit = nil
+	RunningVM = &handlerVM{}
+	if got := call("run"); !strings.Contains(got.Inspect(), "go build -tags gui") {
+		t.Errorf("expected gui.run to need the gui tag, got %s", got.Inspect())
+	}
+	fake := &fakeToolkit{}
+	toolkit = fake
+	if got := call("run").Inspect(); got != "ERROR: gui.run needs a window; create one with gui.window" {
+		t.Errorf("unexpected error without windows: %s", got)
+	}
+
+	window := call("window", str("Counter"), &Integer{Value: 200}, &Integer{Value: 100}).(*Widget)
+	label := call("label", window, str("0"))
+	onClick, onChange := closure(0), closure(1)
+	button := call("button", window, str("+1"), onClick)
+	input := call("input", window, str("name"))
+	if got := call("on_change", input, onChange); got.Type() != NULL_OBJ {
+		t.Fatalf("on_change failed: %s", got.Inspect())
+	}
+	clicks, typed := 0, ""
+	RunningVM = &handlerVM{handlers: map[*Closure]func([]Object) Object{
+		onClick: func([]Object) Object {
+			clicks++
+			return call("set_text", label, str("1"))
+		},
+		onChange: func(args []Object) Object {
+			typed = args[0].Inspect()
+			call("label", window, str("later"))
+			return &Null{}
+		},
+	}}
+
+	fake.script = func() {
+		guiEvent(button.(*Widget), "click", "")
+		guiEvent(input.(*Widget), "change", "ann")
+		call("quit")
+	}
+	if got := call("run"); got.Type() != NULL_OBJ {
+		t.Fatalf("gui.run failed: %s", got.Inspect())
+	}
+	if len(fake.built) != 1 || len(window.children) != 4 {
+		t.Errorf("expected 1 window of 4 widgets, got %d windows and %d widgets", len(fake.built), len(window.children))
+	}
+	if clicks != 1 || typed != "ann" {
+		t.Errorf("expected 1 click and ann typed, got %d and %q", clicks, typed)
+	}
+	if got := strings.Join(fake.calls, ", "); got != "set 1, add label to Counter, quit" {
+		t.Errorf("unexpected toolkit calls: %s", got)
+	}
+	for w, want := range map[Object]string{label: "1", input: "ann", window: "Counter"} {
+		if got := call("text", w).Inspect(); got != want {
+			t.Errorf("expected text %s, got %s", want, got)
+		}
+	}
+	if got := call("run").Inspect(); got != "ERROR: gui.run can only be called once" {
+		t.Errorf("unexpected error running twice: %s", got)
+	}
+
+	errorTests := []struct {
+		name     string
+		args     []Object
+		expected string
+	}{
+		{"window", []Object{str("x"), &Integer{Value: 0}, &Integer{Value: 10}}, "Argument 1 to `window` must be a positive INTEGER, got 0"},
+		{"label", []Object{label, str("x")}, "TypeError: Argument 0 to `label` must be a window WIDGET, got Widget[label \"1\"]"},
+		{"button", []Object{window, str("x"), closure(1)}, "TypeError: Argument 2 to `button` must be a CLOSURE with 0 parameters, got Closure[0x"},
+		{"on_click", []Object{input, closure(0)}, "TypeError: Argument 0 to `on_click` must be a button WIDGET, got Widget[input \"ann\"]"},
+		{"text", []Object{str("x")}, "TypeError: Argument 0 to `text` must be WIDGET, got STRING"},
+	}
+	for _, tt := range errorTests {
+		if got := call(tt.name, tt.args...).Inspect(); !strings.HasPrefix(got, "ERROR: "+tt.expected) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestGUIHandlerError(t *testing.T) {
+	saved := toolkit
+	t.Cleanup(func() {
+		toolkit, RunningVM, EventHandlers = saved, nil, nil
+		gui.windows, gui.started, gui.err = nil, false, nil
+	})
+	fn := &Closure{Fn: &CompiledFunction{}}
+	RunningVM = &handlerVM{handlers: map[*Closure]func([]Object) Object{
+		fn: func([]Object) Object { return newError("boom") },
+	}}
+	fake := &fakeToolkit{}
+	toolkit = fake
+	window := newWindow("w", 0, 0)
+	button := addWidget(window, newWidget("button", "b"))
+	onEvent(guiEventName(button, "click"), fn)
+	fake.script = func() {
+		guiEvent(button, "click", "")
+		guiEvent(button, "click", "")
+	}
+	if got := runGUI().Inspect(); got != "ERROR: boom" {
+		t.Errorf("expected the handler's error, got %s", got)
+	}
+	if got := strings.Join(fake.calls, ", "); got != "quit" {
+		t.Errorf("expected a single quit, got %s", got)
+	}
+}
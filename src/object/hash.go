@@ -0,0 +1,15 @@
+package object
+
+// hashArgs checks the first two arguments of the hash builtin name: a hash
+// and a key, which it returns hashed.
+func hashArgs(name string, args []Object) (*Hash, HashKey, *Error) {
+	h, ok := args[0].(*Hash)
+	if !ok {
+		return nil, HashKey{}, typeError("Argument 0 to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+	key, ok := HashKeyOf(args[1])
+	if !ok {
+		return nil, HashKey{}, newError("Argument 1 to `%s` is unusable as a hash key: %s", name, args[1].Type())
+	}
+	return h, key, nil
+}
@@ -0,0 +1,212 @@
+package object
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultHTTPTimeout bounds requests made without a timeout option.
+const defaultHTTPTimeout = 30 * time.Second
+
+// httpOptions are the settings an options hash can give a request.
+type httpOptions struct {
+	url     string
+	headers map[string]string
+	body    []byte
+	timeout time.Duration
+}
+
+// httpGet is http.get: http.get(url, options?).
+func httpGet(args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+	}
+	opts, err := httpArgs("get", args[0], 0, args[1:])
+	if err != nil {
+		return err
+	}
+	return sendHTTP("GET", opts)
+}
+
+// httpPost is http.post: http.post(url, body, options?). The body given
+// here replaces one in the options.
+func httpPost(args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+	}
+	opts, err := httpArgs("post", args[0], 0, args[2:])
+	if err != nil {
+		return err
+	}
+	if opts.body, err = httpBody("post", 1, args[1]); err != nil {
+		return err
+	}
+	return sendHTTP("POST", opts)
+}
+
+// httpRequest is http.request: http.request(method, url, options?).
+func httpRequest(args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+	}
+	method, ok := args[0].(*String)
+	if !ok {
+		return typeError("Argument 0 to `request` must be STRING, got %s", args[0].Type())
+	}
+	if method.Value == "" || strings.ContainsAny(method.Value, " \t\r\n") {
+		return newError("Invalid HTTP method %q", method.Value)
+	}
+	opts, err := httpArgs("request", args[1], 1, args[2:])
+	if err != nil {
+		return err
+	}
+	return sendHTTP(strings.ToUpper(method.Value), opts)
+}
+
+// httpArgs checks the url given as argument index to the builtin name and
+// reads the options hash after it, if there is one.
+func httpArgs(name string, url Object, index int, options []Object) (*httpOptions, *Error) {
+	u, ok := url.(*String)
+	if !ok {
+		return nil, typeError("Argument %d to `%s` must be STRING, got %s", index, name, url.Type())
+	}
+	opts := &httpOptions{url: u.Value, timeout: defaultHTTPTimeout}
+	if len(options) == 1 {
+		if err := readHTTPOptions(name, options[0], opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// sendHTTP sends a request and returns the response as a hash of its
+// status, headers and body. A response with an error status is still a
+// response; only failing to get one is an error.
+func sendHTTP(method string, opts *httpOptions) Object {
+	ctx := currentContext
+	req, err := http.NewRequestWithContext(ctx, method, opts.url, bytes.NewReader(opts.body))
+	if err != nil {
+		return newError("Invalid HTTP request: %s", err)
+	}
+	for key, value := range opts.headers {
+		req.Header.Set(key, value)
+	}
+
+	var resp *http.Response
+	var body []byte
+	unlocked(func() {
+		client := &http.Client{Timeout: opts.timeout}
+		resp, err = client.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		body, err = io.ReadAll(resp.Body)
+	})
+	if ctx.Err() != nil {
+		return cancelled(ctx)
+	}
+	if err != nil {
+		return ioError("HTTP request failed: %s", err)
+	}
+	return responseHash(resp, body)
+}
+
+// readHTTPOptions reads an options hash, which can set headers (a hash of
+// strings), body (a string or bytes) and timeout (in milliseconds).
+func readHTTPOptions(name string, o Object, opts *httpOptions) *Error {
+	h, ok := o.(*Hash)
+	if !ok {
+		return typeError("Options to `%s` must be HASH, got %s", name, o.Type())
+	}
+	for _, pair := range h.Ordered() {
+		key, ok := pair.Key.(*String)
+		if !ok {
+			return typeError("Option names to `%s` must be STRING, got %s", name, pair.Key.Type())
+		}
+		switch key.Value {
+		case "headers":
+			headers, ok := pair.Value.(*Hash)
+			if !ok {
+				return typeError("Option headers to `%s` must be HASH, got %s", name, pair.Value.Type())
+			}
+			opts.headers = map[string]string{}
+			for _, header := range headers.Ordered() {
+				k, kok := header.Key.(*String)
+				v, vok := header.Value.(*String)
+				if !kok || !vok {
+					return newError("Headers to `%s` must map STRING to STRING, got %s: %s", name, header.Key.Type(), header.Value.Type())
+				}
+				opts.headers[k.Value] = v.Value
+			}
+		case "body":
+			body, err := httpBody(name, -1, pair.Value)
+			if err != nil {
+				return err
+			}
+			opts.body = body
+		case "timeout":
+			ms, ok := pair.Value.(*Integer)
+			if !ok {
+				return typeError("Option timeout to `%s` must be INTEGER, got %s", name, pair.Value.Type())
+			}
+			if ms.Value <= 0 {
+				return newError("Option timeout to `%s` must be positive, got %d", name, ms.Value)
+			}
+			opts.timeout = time.Duration(ms.Value) * time.Millisecond
+		default:
+			return newError("Unknown option %s to `%s`; use headers, body or timeout", key.Value, name)
+		}
+	}
+	return nil
+}
+
+// httpBody reads a request body, a string or bytes. index is the argument
+// it was given as, or -1 for the body option.
+func httpBody(name string, index int, o Object) ([]byte, *Error) {
+	switch o := o.(type) {
+	case *String:
+		return []byte(o.Value), nil
+	case *Bytes:
+		return o.Value, nil
+	}
+	if index < 0 {
+		return nil, typeError("Option body to `%s` must be STRING or BYTES, got %s", name, o.Type())
+	}
+	return nil, typeError("Argument %d to `%s` must be STRING or BYTES, got %s", index, name, o.Type())
+}
+
+// responseHash builds {status, headers, body}. Header names are lower case
+// and sorted; a header sent more than once has its values joined by ", ".
+func responseHash(resp *http.Response, body []byte) *Hash {
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, name := range names {
+		key := &String{Value: strings.ToLower(name)}
+		value := &String{Value: strings.Join(resp.Header.Values(name), ", ")}
+		headers.Set(key.HashKey(), HashPair{Key: key, Value: value})
+	}
+
+	result := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, field := range []struct {
+		name  string
+		value Object
+	}{
+		{"status", &Integer{Value: int64(resp.StatusCode)}},
+		{"headers", headers},
+		{"body", &String{Value: string(body)}},
+	} {
+		key := &String{Value: field.name}
+		result.Set(key.HashKey(), HashPair{Key: key, Value: field.value})
+	}
+	return result
+}
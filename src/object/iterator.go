@@ -0,0 +1,88 @@
+package object
+
+import "fmt"
+
+// Iterator steps through a collection for a `for (x in collection)` loop.
+// Each step gives a key and a value: the index and the element of an array,
+// tuple, set, string or bytes, and the key and the value of a hash. A loop
+// naming one variable gets the value, except for hashes, where it gets the
+// key.
+type Iterator struct {
+	// Keyed is set for hashes, whose keys are what a single variable gets.
+	Keyed bool
+
+	length func() int
+	at     func(i int) (key, value Object)
+	index  int
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "ITERATOR" }
+
+// NewIterator returns an iterator over o, or an error for values that can't
+// be iterated. Arrays are read as the loop goes, so elements appended by the
+// loop are visited too; the pairs of a hash and the items of a set are the
+// ones they held when the loop started.
+func NewIterator(o Object) (*Iterator, error) {
+	switch o := o.(type) {
+	case *Array:
+		return &Iterator{
+			length: func() int { return len(o.Elements) },
+			at:     func(i int) (Object, Object) { return &Integer{Value: int64(i)}, o.Elements[i] },
+		}, nil
+
+	case *Tuple:
+		return elementIterator(o.Elements), nil
+
+	case *Set:
+		return elementIterator(o.Items()), nil
+
+	case *String:
+		chars := []rune(o.Value)
+		return &Iterator{
+			length: func() int { return len(chars) },
+			at: func(i int) (Object, Object) {
+				return &Integer{Value: int64(i)}, &String{Value: string(chars[i])}
+			},
+		}, nil
+
+	case *Bytes:
+		return &Iterator{
+			length: func() int { return len(o.Value) },
+			at: func(i int) (Object, Object) {
+				return &Integer{Value: int64(i)}, &Integer{Value: int64(o.Value[i])}
+			},
+		}, nil
+
+	case *Hash:
+		pairs := o.Ordered()
+		return &Iterator{
+			Keyed:  true,
+			length: func() int { return len(pairs) },
+			at:     func(i int) (Object, Object) { return pairs[i].Key, pairs[i].Value },
+		}, nil
+	}
+
+	if o == nil {
+		return nil, fmt.Errorf("Cannot iterate over %s", NULL_OBJ)
+	}
+	return nil, fmt.Errorf("Cannot iterate over %s", o.Type())
+}
+
+func elementIterator(elements []Object) *Iterator {
+	return &Iterator{
+		length: func() int { return len(elements) },
+		at:     func(i int) (Object, Object) { return &Integer{Value: int64(i)}, elements[i] },
+	}
+}
+
+// Next returns the key and the value of the next step, or false once the
+// collection is exhausted.
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	if it.index >= it.length() {
+		return nil, nil, false
+	}
+	key, value = it.at(it.index)
+	it.index++
+	return key, value, true
+}
@@ -0,0 +1,232 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// jsonParse is json.parse: it reads a JSON document into objects. Objects
+// become hashes keeping the order of their keys, numbers without a fraction
+// or an exponent become integers and other numbers floats.
+func jsonParse(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+	}
+	text, ok := args[0].(*String)
+	if !ok {
+		return typeError("Argument 0 to `parse` must be STRING, got %s", args[0].Type())
+	}
+
+	// Checking the whole document first gives the scanner's errors, which
+	// say what was expected where.
+	var raw json.RawMessage
+	if err := json.Unmarshal([]byte(text.Value), &raw); err != nil {
+		return jsonError(text.Value, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	value, err := decodeJSON(dec)
+	if err != nil {
+		return newError("Invalid JSON: %s", err)
+	}
+	return value
+}
+
+func decodeJSON(dec *json.Decoder) (Object, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			var elements []Object
+			for dec.More() {
+				element, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, element)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return &Array{Elements: elements}, nil
+		}
+
+		hash := &Hash{Pairs: map[HashKey]HashPair{}}
+		for dec.More() {
+			keyToken, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := &String{Value: keyToken.(string)}
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(key.HashKey(), HashPair{Key: key, Value: value})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return hash, nil
+	case json.Number:
+		if n, err := strconv.ParseInt(string(tok), 10, 64); err == nil {
+			return &Integer{Value: n}, nil
+		}
+		f, err := strconv.ParseFloat(string(tok), 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, err
+		}
+		return &Float{Value: f}, nil
+	case string:
+		return &String{Value: tok}, nil
+	case bool:
+		return &Boolean{Value: tok}, nil
+	}
+	return &Null{}, nil
+}
+
+// jsonError reports malformed input at the line and column of the byte the
+// scanner stopped at.
+func jsonError(text string, err error) *Error {
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return newError("Invalid JSON: %s", err)
+	}
+
+	before := text[:max(min(int(syntax.Offset), len(text))-1, 0)]
+	line := strings.Count(before, "\n") + 1
+	column := len(before) - strings.LastIndex(before, "\n")
+	return newError("Invalid JSON at line %d, column %d: %s", line, column, syntax)
+}
+
+// jsonWrite is json.write: it writes a value as JSON, indented by two
+// spaces per level when the second argument is true.
+func jsonWrite(args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+	}
+	pretty := false
+	if len(args) == 2 {
+		b, ok := args[1].(*Boolean)
+		if !ok {
+			return typeError("Argument 1 to `write` must be BOOLEAN, got %s", args[1].Type())
+		}
+		pretty = b.Value
+	}
+
+	var out bytes.Buffer
+	if err := encodeJSON(&out, args[0], map[Object]bool{}); err != nil {
+		return newError("Can't write JSON: %s", err)
+	}
+	if pretty {
+		var indented bytes.Buffer
+		json.Indent(&indented, out.Bytes(), "", "  ")
+		return &String{Value: indented.String()}
+	}
+	return &String{Value: out.String()}
+}
+
+// encodeJSON writes o to out. Arrays, tuples and sets become JSON arrays;
+// hashes and instances become objects and need string keys. Floats keep a
+// fraction so they read back as floats.
+func encodeJSON(out *bytes.Buffer, o Object, seen map[Object]bool) error {
+	switch o := o.(type) {
+	case nil, *Null:
+		out.WriteString("null")
+	case *Boolean:
+		out.WriteString(strconv.FormatBool(o.Value))
+	case *Integer:
+		out.WriteString(strconv.FormatInt(o.Value, 10))
+	case *Hex:
+		out.WriteString(strconv.FormatInt(o.Value, 10))
+	case *Float:
+		if math.IsNaN(o.Value) || math.IsInf(o.Value, 0) {
+			return fmt.Errorf("%s has no JSON form", formatFloat(o.Value))
+		}
+		text := strconv.FormatFloat(o.Value, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		out.WriteString(text)
+	case *String:
+		writeJSONString(out, o.Value)
+	case *Array:
+		return encodeJSONArray(out, o, o.Elements, seen)
+	case *Tuple:
+		return encodeJSONArray(out, o, o.Elements, seen)
+	case *Set:
+		return encodeJSONArray(out, o, o.Items(), seen)
+	case *Hash:
+		return encodeJSONObject(out, o, o.Ordered(), seen)
+	case *Instance:
+		return encodeJSONObject(out, o, o.Fields.Ordered(), seen)
+	default:
+		return fmt.Errorf("%s has no JSON form", o.Type())
+	}
+	return nil
+}
+
+func encodeJSONArray(out *bytes.Buffer, container Object, elements []Object, seen map[Object]bool) error {
+	if seen[container] {
+		return fmt.Errorf("a %s contains itself", container.Type())
+	}
+	seen[container] = true
+	defer delete(seen, container)
+
+	out.WriteByte('[')
+	for i, el := range elements {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		if err := encodeJSON(out, el, seen); err != nil {
+			return err
+		}
+	}
+	out.WriteByte(']')
+	return nil
+}
+
+func encodeJSONObject(out *bytes.Buffer, container Object, pairs []HashPair, seen map[Object]bool) error {
+	if seen[container] {
+		return fmt.Errorf("a %s contains itself", container.Type())
+	}
+	seen[container] = true
+	defer delete(seen, container)
+
+	out.WriteByte('{')
+	for i, pair := range pairs {
+		key, ok := pair.Key.(*String)
+		if !ok {
+			return fmt.Errorf("object keys must be STRING, got %s", pair.Key.Type())
+		}
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		writeJSONString(out, key.Value)
+		out.WriteByte(':')
+		if err := encodeJSON(out, pair.Value, seen); err != nil {
+			return err
+		}
+	}
+	out.WriteByte('}')
+	return nil
+}
+
+// writeJSONString quotes s, leaving <, > and & as they are.
+func writeJSONString(out *bytes.Buffer, s string) {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Encode ends the value with a newline.
+	out.Truncate(out.Len() - 1)
+}
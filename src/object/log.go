@@ -0,0 +1,174 @@
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels, from the most to the least verbose.
+const (
+	LogDebug = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// logger is the state of the log class.
+var logger = struct {
+	sync.Mutex
+	level int
+	json  bool
+	// out is where records go; nil means ErrWriter. file is set when out
+	// is a file the logger opened.
+	out  io.Writer
+	file *os.File
+	// now returns the time records are stamped with.
+	now func() time.Time
+}{level: LogInfo, now: time.Now}
+
+func parseLogLevel(name string) (int, bool) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// logBuiltin returns the builtin logging a record at level, called as
+// log.<level>(message) or log.<level>(message, fields).
+func logBuiltin(level int) *Builtin {
+	name := logLevelNames[level]
+	return createBuiltin(func(args ...Object) Object {
+		if len(args) != 1 && len(args) != 2 {
+			return newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+		}
+		var fields *Hash
+		if len(args) == 2 {
+			h, ok := args[1].(*Hash)
+			if !ok {
+				return typeError("Argument 1 to `%s` must be HASH, got %s", name, args[1].Type())
+			}
+			fields = h
+		}
+		if err := writeLog(level, logText(args[0]), fields); err != nil {
+			return ioError("Could not write log record: %s", err)
+		}
+		return &Null{}
+	}, "log")
+}
+
+// logText is how a value appears as a log message or text field.
+func logText(o Object) string {
+	if s, ok := o.(*String); ok {
+		return s.Value
+	}
+	return o.Inspect()
+}
+
+// writeLog writes one record if level is enabled.
+func writeLog(level int, message string, fields *Hash) error {
+	logger.Lock()
+	defer logger.Unlock()
+
+	if level < logger.level {
+		return nil
+	}
+
+	// Fields are written in key order.
+	var keys []string
+	values := map[string]Object{}
+	if fields != nil {
+		for _, pair := range fields.Pairs {
+			key := logText(pair.Key)
+			keys = append(keys, key)
+			values[key] = pair.Value
+		}
+		sort.Strings(keys)
+	}
+
+	stamp := logger.now().Format("2006-01-02T15:04:05.000Z07:00")
+	var line string
+	if logger.json {
+		// time, level and msg come first; fields using one of those names
+		// are written as field_<name>.
+		var b strings.Builder
+		for i, pair := range [][2]string{{"time", stamp}, {"level", logLevelNames[level]}, {"msg", message}} {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s:%s", jsonString(pair[0]), jsonString(pair[1]))
+		}
+		for _, key := range keys {
+			value, err := json.Marshal(sqxObjectToNative(values[key]))
+			if err != nil {
+				return err
+			}
+			name := key
+			if name == "time" || name == "level" || name == "msg" {
+				name = "field_" + name
+			}
+			fmt.Fprintf(&b, ",%s:%s", jsonString(name), value)
+		}
+		line = "{" + b.String() + "}"
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %-5s %s", stamp, strings.ToUpper(logLevelNames[level]), message)
+		for _, key := range keys {
+			value := logText(values[key])
+			if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(&b, " %s=%s", key, value)
+		}
+		line = b.String()
+	}
+
+	out := logger.out
+	if out == nil {
+		out = ErrWriter
+	}
+	_, err := io.WriteString(out, line+"\n")
+	return err
+}
+
+// setLogOutput sends records to "stderr", "stdout" or appends them to the
+// file at dest.
+func setLogOutput(dest string) error {
+	logger.Lock()
+	defer logger.Unlock()
+
+	var out io.Writer
+	var file *os.File
+	switch dest {
+	case "stderr":
+	case "stdout":
+		out = OutWriter
+	default:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		out, file = f, f
+	}
+
+	if logger.file != nil {
+		logger.file.Close()
+	}
+	logger.out, logger.file = out, file
+	return nil
+}
+
+func jsonString(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
@@ -0,0 +1,72 @@
+package object
+
+// Mutex lets tasks take turns using values they share. Holding the
+// interpreter doesn't make a statement atomic, so tasks updating the same
+// hash or array lock a mutex around the update.
+type Mutex struct {
+	// sem holds a value while the mutex is locked.
+	sem chan struct{}
+}
+
+func NewMutex() *Mutex {
+	return &Mutex{sem: make(chan struct{}, 1)}
+}
+
+func (m *Mutex) Type() ObjectType { return MUTEX_OBJ }
+func (m *Mutex) Inspect() string {
+	if len(m.sem) > 0 {
+		return "Mutex[locked]"
+	}
+	return "Mutex[unlocked]"
+}
+
+func (m *Mutex) tryLock() bool {
+	select {
+	case m.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// lock waits for the mutex while other tasks run.
+func (m *Mutex) lock() *Error {
+	if m.tryLock() {
+		return nil
+	}
+	if !othersCanRun() {
+		return errDeadlock("sync.lock")
+	}
+	ctx := currentContext
+	locked := false
+	unlocked(func() {
+		select {
+		case m.sem <- struct{}{}:
+			locked = true
+		case <-ctx.Done():
+		}
+	})
+	if !locked {
+		return cancelled(ctx)
+	}
+	return nil
+}
+
+func (m *Mutex) unlock() *Error {
+	select {
+	case <-m.sem:
+		return nil
+	default:
+		return newError("Unlock of unlocked mutex")
+	}
+}
+
+// withLock calls fn with the mutex locked and returns its result.
+func (m *Mutex) withLock(call Caller, fn Object) Object {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	return call(fn)
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"sort"
 	"squ1d++/ast"
 	"squ1d++/code"
 	"strings"
@@ -23,6 +25,19 @@ var hashPool = sync.Pool{New: func() interface{} { return &Hash{Pairs: make(map[
 
 type BuiltinFunction func(args ...Object) Object
 
+// Caller calls fn, a function a script passed to a builtin, with args and
+// returns its result, or an *Error when the call failed. The VM and the
+// evaluator each give builtins one that runs functions of the code they run.
+type Caller func(fn Object, args ...Object) Object
+
+// CallingFunction is a builtin that calls functions it is given, such as
+// array.map.
+type CallingFunction func(call Caller, args ...Object) Object
+
+// MachineFunction is a builtin that asks the VM calling it about itself,
+// such as runtime.stack_depth. vm is nil when no VM runs the call.
+type MachineFunction func(vm VMInfo, args ...Object) Object
+
 type ObjectType string
 
 const (
@@ -34,13 +49,29 @@ const (
 	BOOLEAN_OBJ           = "BOOLEAN"
 	STRING_OBJ            = "STRING"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
+	BREAK_SIGNAL_OBJ      = "BREAK_SIGNAL"
+	CONTINUE_SIGNAL_OBJ   = "CONTINUE_SIGNAL"
 	FUNCTION_OBJ          = "FUNCTION"
 	BUILTIN_OBJ           = "BUILTIN"
 	ARRAY_OBJ             = "ARRAY"
 	HASH_OBJ              = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	CELL_OBJ              = "CELL"
 	INCLUDE_DIRECTIVE_OBJ = "INCLUDE_DIRECTIVE"
+	CHANNEL_OBJ           = "CHANNEL"
+	MUTEX_OBJ             = "MUTEX"
+	WAITGROUP_OBJ         = "WAITGROUP"
+	COUNTER_OBJ           = "COUNTER"
+	STREAM_OBJ            = "STREAM"
+	WIDGET_OBJ            = "WIDGET"
+	SET_OBJ               = "SET"
+	TUPLE_OBJ             = "TUPLE"
+	BYTES_OBJ             = "BYTES"
+	ITERATOR_OBJ          = "ITERATOR"
+	CLASS_OBJ             = "CLASS"
+	INSTANCE_OBJ          = "INSTANCE"
+	BOUND_METHOD_OBJ      = "BOUND_METHOD"
 )
 
 type HashKey struct {
@@ -72,15 +103,17 @@ type Float struct {
 }
 
 func (f *Float) Type() ObjectType { return FLOAT_OBJ }
-func (f *Float) Inspect() string {
-	// Remove trailing zeros and unnecessary decimal point
-	str := fmt.Sprintf("%.10f", f.Value)
-	str = strings.TrimRight(str, "0")
-	str = strings.TrimRight(str, ".")
-	return str
-}
+func (f *Float) Inspect() string  { return formatFloat(f.Value) }
+
+// HashKey uses the bits of the value, so 1.5 and 1.7 are different keys.
+// 0 and -0 are equal and share a key. A float is never the same key as an
+// integer, just as 1 == 1.0 is false.
 func (f *Float) HashKey() HashKey {
-	return HashKey{Type: f.Type(), Value: uint64(f.Value)}
+	v := f.Value
+	if v == 0 {
+		v = 0
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(v)}
 }
 
 type Hex struct {
@@ -121,6 +154,9 @@ type Null struct{}
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string  { return "null" }
 
+// HashKey is the same for every null, so null is one key.
+func (n *Null) HashKey() HashKey { return HashKey{Type: n.Type()} }
+
 type ReturnValue struct {
 	Value Object
 }
@@ -128,29 +164,60 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// BreakSignal and ContinueSignal carry a break or continue statement out of
+// the blocks the evaluator runs, like ReturnValue does a return, up to the
+// loop it ends or goes on with.
+type BreakSignal struct{}
+
+func (bs *BreakSignal) Type() ObjectType { return BREAK_SIGNAL_OBJ }
+func (bs *BreakSignal) Inspect() string  { return "break" }
+
+type ContinueSignal struct{}
+
+func (cs *ContinueSignal) Type() ObjectType { return CONTINUE_SIGNAL_OBJ }
+func (cs *ContinueSignal) Inspect() string  { return "continue" }
+
 type Error struct {
+	Kind      string // What went wrong, like "IOError"; empty for plain errors
 	Message   string
+	Data      *Hash // Extra values given to error.new, or nil
 	Filename  string
 	Line      int
 	Column    int
 	Traceback []string // Stack frames for error context
 }
 
+// KindName is the error's kind, or "Error" when it has none.
+func (e *Error) KindName() string {
+	if e.Kind == "" {
+		return "Error"
+	}
+	return e.Kind
+}
+
+// Summary is the message prefixed by the kind, when the error has one.
+func (e *Error) Summary() string {
+	if e.Kind == "" {
+		return e.Message
+	}
+	return e.Kind + ": " + e.Message
+}
+
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string {
 	var out bytes.Buffer
 
 	if e.Filename != "" {
-		out.WriteString(fmt.Sprintf("ERROR: %s, line %d, column %d: %s\n", e.Filename, e.Line, e.Column, e.Message))
+		out.WriteString(fmt.Sprintf("ERROR: %s, line %d, column %d: %s\n", e.Filename, e.Line, e.Column, e.Summary()))
 	} else {
-		out.WriteString("ERROR: " + e.Message + "\n")
+		out.WriteString("ERROR: " + e.Summary() + "\n")
 	}
 
 	// Add traceback if available
 	if len(e.Traceback) > 0 {
 		out.WriteString("\nTraceback:\n")
-		for _, frame := range e.Traceback {
-			out.WriteString(fmt.Sprintf("  %s\n", frame))
+		for _, line := range TracebackLines(e.Traceback) {
+			out.WriteString(fmt.Sprintf("  %s\n", line))
 		}
 	}
 
@@ -159,6 +226,7 @@ func (e *Error) Inspect() string {
 
 type Function struct {
 	Parameters []*ast.Identifier
+	ReturnType *ast.TypeAnnotation
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -196,7 +264,13 @@ func (s *String) HashKey() HashKey {
 }
 
 type Builtin struct {
-	Fn         BuiltinFunction
+	Fn BuiltinFunction
+	// Calling is set for builtins that call functions. Backends call it
+	// with their Caller instead of Fn.
+	Calling CallingFunction
+	// Machine is set for builtins that ask the VM about itself. The VM
+	// calls it with the machine running the call instead of Fn.
+	Machine    MachineFunction
 	Class      string
 	Attributes map[string]Object
 }
@@ -206,52 +280,98 @@ func (b *Builtin) Inspect() string  { return "FUNCTION" }
 
 type Array struct {
 	Elements []Object
+	// Frozen is set by type.freeze.
+	Frozen bool
+	// shared is set while array.pmap workers can reach the array.
+	shared bool
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
-	var out bytes.Buffer
-
-	elements := []string{}
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
-	}
-
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
-
-	return out.String()
-}
+func (ao *Array) Inspect() string  { return Format(ao) }
 
 type HashPair struct {
 	Key   Object
 	Value Object
 }
 
+// Hash maps keys to values and remembers the order keys were added in, so
+// it prints and iterates the same way every run. Pairs are added and
+// removed only with Set and Delete, which keep that order.
 type Hash struct {
+	// Pairs holds the pairs by key. Read it freely, but change it with Set
+	// and Delete, which keep the order of the keys.
 	Pairs map[HashKey]HashPair
+	// order lists the keys in the order they were added, with deleted ones
+	// left as the zero HashKey until there are enough to drop; index is the
+	// place of each key in order.
+	order   []HashKey
+	index   map[HashKey]int
+	deleted int
+	// Frozen is set by type.freeze.
+	Frozen bool
+	// shared is set while array.pmap workers can reach the hash.
+	shared bool
+}
+
+// Set sets the pair stored under key. A new key goes after the others; an
+// existing one keeps its place.
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if h.Pairs == nil {
+		h.Pairs = make(map[HashKey]HashPair)
+	}
+	if h.index == nil {
+		h.index = make(map[HashKey]int)
+	}
+	if _, ok := h.Pairs[key]; !ok {
+		h.index[key] = len(h.order)
+		h.order = append(h.order, key)
+	}
+	h.Pairs[key] = pair
 }
 
-func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
-
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+// Delete removes the pair stored under key.
+func (h *Hash) Delete(key HashKey) {
+	if _, ok := h.Pairs[key]; !ok {
+		return
 	}
+	delete(h.Pairs, key)
+	h.order[h.index[key]] = HashKey{}
+	delete(h.index, key)
+	h.deleted++
+	if h.deleted > len(h.order)/2 {
+		h.compact()
+	}
+}
 
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
+// compact drops the deleted keys from order.
+func (h *Hash) compact() {
+	kept := h.order[:0]
+	for _, key := range h.order {
+		if key != (HashKey{}) {
+			h.index[key] = len(kept)
+			kept = append(kept, key)
+		}
+	}
+	h.order, h.deleted = kept, 0
+}
 
-	return out.String()
+// Ordered returns the pairs in the order their keys were added.
+func (h *Hash) Ordered() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, key := range h.order {
+		if key != (HashKey{}) {
+			pairs = append(pairs, h.Pairs[key])
+		}
+	}
+	return pairs
 }
 
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string  { return Format(h) }
+
 func NewArray(elements []Object) *Array {
 	a := arrayPool.Get().(*Array)
+	a.Frozen, a.shared = false, false
 	if cap(a.Elements) >= len(elements) {
 		a.Elements = a.Elements[:len(elements)]
 		copy(a.Elements, elements)
@@ -273,16 +393,24 @@ func ReleaseArray(a *Array) {
 	arrayPool.Put(a)
 }
 
+// NewHash returns a hash of pairs. A map has no order, so the pairs come
+// sorted by key; pass nil and add them with Set to keep an order.
 func NewHash(pairs map[HashKey]HashPair) *Hash {
 	h := hashPool.Get().(*Hash)
 	if h.Pairs == nil {
 		h.Pairs = make(map[HashKey]HashPair)
 	}
-	for k := range h.Pairs {
-		delete(h.Pairs, k)
+	h.reset()
+	h.Frozen, h.shared = false, false
+	// A map has no order of its own, so the keys are added in the order
+	// they print in, the same every time.
+	keys := make([]HashKey, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
 	}
-	for k, v := range pairs {
-		h.Pairs[k] = v
+	sort.Slice(keys, func(i, j int) bool { return pairs[keys[i]].Key.Inspect() < pairs[keys[j]].Key.Inspect() })
+	for _, k := range keys {
+		h.Set(k, pairs[k])
 	}
 	return h
 }
@@ -291,10 +419,19 @@ func ReleaseHash(h *Hash) {
 	if h == nil || h.Pairs == nil {
 		return
 	}
+	h.reset()
+	hashPool.Put(h)
+}
+
+// reset empties h.
+func (h *Hash) reset() {
 	for k := range h.Pairs {
 		delete(h.Pairs, k)
 	}
-	hashPool.Put(h)
+	for k := range h.index {
+		delete(h.index, k)
+	}
+	h.order, h.deleted = h.order[:0], 0
 }
 
 type CompiledFunction struct {
@@ -302,13 +439,46 @@ type CompiledFunction struct {
 	NumLocals     int
 	NumParameters int
 	Name          string
+	// Lines maps the offset of the first instruction of each statement to
+	// the source lines of the statements starting there.
+	Lines map[int][]int
+	// Positions maps the offset of each instruction that can fail at run
+	// time, such as an operator or a call, to its position in the source.
+	Positions map[int]Position
+	// Filename is the source file the function was compiled from, if known.
+	Filename string
+	// Files is set for code compiled from more than one file, such as a
+	// program with its includes expanded: it maps the offset of the first
+	// instruction compiled from each file after the first to that file.
+	Files map[int]string
+	// LocalNames and FreeNames name the function's local slots and the free
+	// variables of its closures, for debuggers.
+	LocalNames []string
+	FreeNames  []string
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+
+// FileAt returns the source file of the instruction at offset.
+func (cf *CompiledFunction) FileAt(offset int) string {
+	file, start := cf.Filename, -1
+	for at, f := range cf.Files {
+		if at <= offset && at > start {
+			file, start = f, at
+		}
+	}
+	return file
+}
 func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
 
+// Position is a line and column in a source file.
+type Position struct {
+	Line   int
+	Column int
+}
+
 type Closure struct {
 	Fn   *CompiledFunction
 	Free []Object
@@ -319,6 +489,36 @@ func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
 
+// Cell holds a local variable captured by a closure. The function's slot and
+// the closures capturing the variable share the cell, so an assignment by any
+// of them is seen by all. Cells never reach the program itself: reading the
+// variable reads the value inside.
+type Cell struct {
+	Value Object
+	// shared is set while array.pmap workers can reach the cell.
+	shared bool
+}
+
+// Shared reports whether the workers of array.pmap can reach the cell, so
+// the variable it holds can't be assigned.
+func (c *Cell) Shared() bool { return c.shared }
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	if c.Value == nil {
+		return "null"
+	}
+	return c.Value.Inspect()
+}
+
+// Deref returns the value held by o if it is a cell, and o otherwise.
+func Deref(o Object) Object {
+	if cell, ok := o.(*Cell); ok {
+		return cell.Value
+	}
+	return o
+}
+
 type IncludeDirective struct {
 	Namespace string
 	Filename  string
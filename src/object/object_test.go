@@ -1,7 +1,14 @@
 package object
 
 import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestStringHashKey(t *testing.T) {
@@ -22,3 +29,426 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("Strings with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 1.7}).HashKey() {
+		t.Errorf("1.5 and 1.7 have the same hash key")
+	}
+	if (&Float{Value: 0}).HashKey() != (&Float{Value: math.Copysign(0, -1)}).HashKey() {
+		t.Errorf("0 and -0 have different hash keys")
+	}
+	if (&Float{Value: 1}).HashKey() == (&Integer{Value: 1}).HashKey() {
+		t.Errorf("1.0 and 1 have the same hash key")
+	}
+	if _, ok := HashKeyOf(&Float{Value: math.NaN()}); ok {
+		t.Errorf("expected NaN to be unusable as a hash key")
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	h := &Hash{}
+	for _, key := range []string{"c", "a", "b"} {
+		k := &String{Value: key}
+		h.Set(k.HashKey(), HashPair{Key: k, Value: &Integer{Value: int64(len(h.Pairs))}})
+	}
+	a := &String{Value: "a"}
+	h.Set(a.HashKey(), HashPair{Key: a, Value: &Integer{Value: 9}})
+	if got := h.Inspect(); got != `{"c": 0, "a": 9, "b": 2}` {
+		t.Errorf("expected insertion order with a replaced value, got %s", got)
+	}
+
+	h.Delete(a.HashKey())
+	h.Set(a.HashKey(), HashPair{Key: a, Value: &Integer{Value: 1}})
+	if got := h.Inspect(); got != `{"c": 0, "b": 2, "a": 1}` {
+		t.Errorf("expected a deleted key to come back last, got %s", got)
+	}
+
+	// Deleting most keys drops them from the order without reordering
+	// the rest.
+	for i := 0; i < 100; i++ {
+		k := &Integer{Value: int64(i)}
+		h.Set(k.HashKey(), HashPair{Key: k, Value: &Null{}})
+	}
+	for i := 0; i < 100; i++ {
+		if i != 42 {
+			h.Delete((&Integer{Value: int64(i)}).HashKey())
+		}
+	}
+	h.Delete((&String{Value: "b"}).HashKey())
+	if got := h.Inspect(); got != `{"c": 0, "a": 1, 42: null}` {
+		t.Errorf("expected the remaining keys in insertion order, got %s", got)
+	}
+
+	// A map has no order, so NewHash orders its keys as they print.
+	pairs := map[HashKey]HashPair{}
+	for _, key := range []string{"z", "x", "y"} {
+		k := &String{Value: key}
+		pairs[k.HashKey()] = HashPair{Key: k, Value: &Null{}}
+	}
+	if got := NewHash(pairs).Inspect(); got != `{"x": null, "y": null, "z": null}` {
+		t.Errorf("expected a hash made from a map to be ordered by key, got %s", got)
+	}
+
+	reused := NewHash(nil)
+	if len(reused.Ordered()) != 0 {
+		t.Errorf("expected a new hash to be empty, got %s", reused.Inspect())
+	}
+}
+
+func TestClone(t *testing.T) {
+	shared := &Array{Elements: []Object{&Integer{Value: 1}}}
+	cyclic := &Array{}
+	cyclic.Elements = []Object{shared, shared, cyclic}
+	h := &Hash{}
+	for _, key := range []string{"b", "a"} {
+		k := &String{Value: key}
+		h.Set(k.HashKey(), HashPair{Key: k, Value: cyclic})
+	}
+
+	c, ok := Clone(h).(*Hash)
+	if !ok || c == h {
+		t.Fatalf("expected a new hash, got %v", c)
+	}
+	if got := c.Ordered()[0].Key.Inspect(); got != "b" {
+		t.Errorf("expected the copy to keep the key order, got %s first", got)
+	}
+	copied := c.Ordered()[0].Value.(*Array)
+	if copied == cyclic || c.Ordered()[1].Value != copied {
+		t.Errorf("expected both values to be the same new array")
+	}
+	if copied.Elements[2] != copied {
+		t.Errorf("expected the cycle to point at the copy")
+	}
+	if copied.Elements[0] == shared || copied.Elements[0] != copied.Elements[1] {
+		t.Errorf("expected the shared array to be copied once")
+	}
+}
+
+func TestInstanceInspect(t *testing.T) {
+	class := &Class{Name: "Point", Methods: map[string]Object{}}
+	p := NewInstance(class)
+	p.SetField("x", &Integer{Value: 1})
+	p.SetField("name", &String{Value: "origin"})
+	p.SetField("self", p)
+
+	expected := `Point{x: 1, name: "origin", self: Point{...}}`
+	if got := p.Inspect(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if got := NewInstance(class).Inspect(); got != "Point{}" {
+		t.Errorf("expected Point{}, got %s", got)
+	}
+}
+
+func TestObjectCounts(t *testing.T) {
+	shared := &String{Value: "shared"}
+	inner := &Array{Elements: []Object{shared, &Integer{Value: 1}}}
+	closure := &Closure{Fn: &CompiledFunction{}, Free: []Object{inner}}
+	class := CreateClassObjects()["sys"]
+
+	counts := objectCounts([]Object{inner, closure, shared, class, GetBuiltinByName("gc")})
+	want := map[string]int{"ARRAY": 1, "STRING": 1, "INTEGER": 1, "CLOSURE": 1}
+	if len(counts) != len(want) {
+		t.Fatalf("expected %v, got %v", want, counts)
+	}
+	for typ, n := range want {
+		if counts[typ] != n {
+			t.Fatalf("expected %d %s, got %v", n, typ, counts)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	var out bytes.Buffer
+	logger.out = &out
+	logger.now = func() time.Time { return time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC) }
+	defer func() {
+		logger.out, logger.now, logger.level, logger.json = nil, time.Now, LogInfo, false
+	}()
+
+	call := func(name string, args ...Object) Object {
+		t.Helper()
+		builtin := CreateClassObjects()["log"].Pairs[(&String{Value: name}).HashKey()].Value.(*Builtin)
+		return builtin.Fn(args...)
+	}
+	fields := NewHash(map[HashKey]HashPair{
+		(&String{Value: "user"}).HashKey(): {Key: &String{Value: "user"}, Value: &String{Value: "ann lee"}},
+		(&String{Value: "n"}).HashKey():    {Key: &String{Value: "n"}, Value: &Integer{Value: 3}},
+	})
+
+	call("info", &String{Value: "started"}, fields)
+	call("debug", &String{Value: "hidden"})
+	call("set_level", &String{Value: "debug"})
+	call("debug", &Integer{Value: 42})
+	call("set_format", &String{Value: "json"})
+	call("warn", &String{Value: "slow"}, fields)
+
+	want := "2024-05-01T12:30:00.000Z INFO  started n=3 user=\"ann lee\"\n" +
+		"2024-05-01T12:30:00.000Z DEBUG 42\n" +
+		`{"time":"2024-05-01T12:30:00.000Z","level":"warn","msg":"slow","n":3,"user":"ann lee"}` + "\n"
+	if out.String() != want {
+		t.Fatalf("expected log:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	if e, ok := call("set_level", &String{Value: "loud"}).(*Error); !ok || !strings.Contains(e.Message, "Unknown log level") {
+		t.Fatalf("expected an error for an unknown level, got %v", e)
+	}
+	if e, ok := call("info", &String{Value: "x"}, &Integer{Value: 1}).(*Error); !ok || e.Message != "Argument 1 to `info` must be HASH, got INTEGER" {
+		t.Fatalf("expected an error for bad fields, got %v", e)
+	}
+}
+
+func TestLogOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	if err := setLogOutput(file); err != nil {
+		t.Fatalf("setLogOutput returned error: %v", err)
+	}
+	defer setLogOutput("stderr")
+
+	if err := writeLog(LogError, "first", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := setLogOutput(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLog(LogError, "second", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != 2 ||
+		!strings.HasSuffix(lines[0], "ERROR first") || !strings.HasSuffix(lines[1], "ERROR second") {
+		t.Fatalf("expected both records appended to the file, got:\n%s", content)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	rand := GetBuiltinByName("rand")
+	now := GetBuiltinByName("now")
+
+	var trace bytes.Buffer
+	stop := StartRecording(&trace)
+	first := rand.Fn(&Integer{Value: 1}, &Integer{Value: 1 << 40})
+	stamp := now.Fn()
+	if err := stop(); err != nil {
+		t.Fatalf("recording failed: %v", err)
+	}
+	if lines := strings.Count(trace.String(), "\n"); lines != 2 {
+		t.Fatalf("expected 2 recorded calls, got %d:\n%s", lines, trace.String())
+	}
+
+	stopReplay, err := StartReplay(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatalf("StartReplay returned error: %v", err)
+	}
+	defer stopReplay()
+
+	if got := rand.Fn(&Integer{Value: 1}, &Integer{Value: 1 << 40}); got.Inspect() != first.Inspect() {
+		t.Errorf("replayed math.rand returned %s, want %s", got.Inspect(), first.Inspect())
+	}
+	if got := now.Fn(); got.Inspect() != stamp.Inspect() {
+		t.Errorf("replayed time.now returned %s, want %s", got.Inspect(), stamp.Inspect())
+	}
+	if got, ok := now.Fn().(*Error); !ok || !strings.Contains(got.Message, "ran out") {
+		t.Errorf("expected an error once the recorded calls ran out, got %v", got)
+	}
+}
+
+func TestReplayFileReads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(path, []byte("first"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var readBytes *Builtin
+	for _, def := range Builtins {
+		if def.Builtin.Class == "file" && def.Name == "read_bytes" {
+			readBytes = def.Builtin
+		}
+	}
+
+	var trace bytes.Buffer
+	stop := StartRecording(&trace)
+	recorded := readBytes.Fn(&String{Value: path})
+	if err := stop(); err != nil {
+		t.Fatalf("recording failed: %v", err)
+	}
+
+	// The replay reads the file as it was when the run was recorded.
+	if err := os.WriteFile(path, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stopReplay, err := StartReplay(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatalf("StartReplay returned error: %v", err)
+	}
+	defer stopReplay()
+	got := readBytes.Fn(&String{Value: path})
+	if got.Type() != BYTES_OBJ || got.Inspect() != recorded.Inspect() {
+		t.Errorf("replayed file.read_bytes returned %s, want %s", got.Inspect(), recorded.Inspect())
+	}
+}
+
+func TestNondeterministicBuiltinsExist(t *testing.T) {
+	defined := map[string]bool{}
+	for _, def := range Builtins {
+		defined[def.Builtin.Class+"."+def.Name] = true
+	}
+	for _, name := range NondeterministicBuiltins {
+		if !defined[name] {
+			t.Errorf("%s is listed as nondeterministic but isn't a builtin", name)
+		}
+	}
+}
+
+func TestReplayDiverged(t *testing.T) {
+	trace := `{"builtin":"math.rand","args":[{"int":1},{"int":6}],"result":{"int":4}}` + "\n"
+	stop, err := StartReplay(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("StartReplay returned error: %v", err)
+	}
+	defer stop()
+
+	got, ok := GetBuiltinByName("now").Fn().(*Error)
+	if !ok || !strings.Contains(got.Message, "Replay diverged at call 1") {
+		t.Fatalf("expected a divergence error, got %v", got)
+	}
+}
+
+func TestTraceValueRoundTrip(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	key := &String{Value: "k"}
+	hash.Set(key.HashKey(), HashPair{Key: key, Value: &Float{Value: 0.1}})
+
+	values := []Object{
+		&Integer{Value: 1<<62 + 1},
+		&Float{Value: 2.5},
+		&String{Value: "text"},
+		&Boolean{Value: true},
+		&Null{},
+		&Bytes{Value: []byte{0, 'a', 0xff}},
+		&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "2"}}},
+		hash,
+	}
+	for _, value := range values {
+		encoded, err := json.Marshal(encodeTraceValue(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeTraceValue(encoded)
+		if err != nil {
+			t.Fatalf("decoding %s: %v", encoded, err)
+		}
+		if decoded.Type() != value.Type() || decoded.Inspect() != value.Inspect() {
+			t.Errorf("%s decoded as %s %s, want %s %s", encoded, decoded.Type(), decoded.Inspect(), value.Type(), value.Inspect())
+		}
+	}
+}
+
+func TestSourceContext(t *testing.T) {
+	RegisterSource("context.sqd", "var a = 1\r\n\tvar b = a(2)\n")
+
+	tests := []struct {
+		line, column int
+		expected     string
+	}{
+		{2, 11, "  \tvar b = a(2)\n  \t         ^"},
+		{1, 0, "  var a = 1"},
+		{3, 1, "  "},
+		{4, 1, ""},
+	}
+	for _, tt := range tests {
+		if got := SourceContext("context.sqd", tt.line, tt.column); got != tt.expected {
+			t.Errorf("SourceContext(%d, %d) = %q, want %q", tt.line, tt.column, got, tt.expected)
+		}
+	}
+	if got := SourceContext("unknown.sqd", 1, 1); got != "" {
+		t.Errorf("expected no context for an unknown file, got %q", got)
+	}
+
+	e := &Error{Message: "boom", Filename: "context.sqd", Line: 1, Column: 5, Traceback: []string{"in f"}}
+	expected := "ERROR: context.sqd, line 1, column 5: boom\n  var a = 1\n      ^\n\nTraceback:\n  in f"
+	if got := e.InspectWithContext(); got != expected {
+		t.Errorf("wrong InspectWithContext.\nwant=%q\ngot= %q", expected, got)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	call := func(name string, args ...Object) Object {
+		t.Helper()
+		builtin := CreateClassObjects()["tmpl"].Pairs[(&String{Value: name}).HashKey()].Value.(*Builtin)
+		return builtin.Fn(args...)
+	}
+	str := func(s string) *String { return &String{Value: s} }
+	hash := func(pairs ...Object) *Hash {
+		h := NewHash(map[HashKey]HashPair{})
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i].(*String).HashKey(), HashPair{Key: pairs[i], Value: pairs[i+1]})
+		}
+		return h
+	}
+	data := hash(str("name"), str("ann"), str("admin"), &Boolean{Value: true},
+		str("items"), &Array{Elements: []Object{&Integer{Value: 1}, &Float{Value: 2.5}, str("x")}})
+
+	path := filepath.Join(t.TempDir(), "page.tmpl")
+	if err := os.WriteFile(path, []byte(`<h1>{{.name}}</h1>{{template "footer" .}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string
+	}{
+		{"render", []Object{str(`Hi {{.name | upper}}!{{range .items}} {{.}}{{end}}{{if .admin}} (admin){{end}}`), data}, "Hi ANN! 1 2.5 x (admin)"},
+		{"render", []Object{str(`{{join .items ", "}} {{len .items}}`), data}, "1, 2.5, x 3"},
+		{"render", []Object{str(`[{{template "item" .name}}]`), data, hash(str("item"), str(`<{{.}}>`))}, "[<ann>]"},
+		{"html", []Object{str(`<p>{{.}}</p>`), str("<b>&")}, "<p>&lt;b&gt;&amp;</p>"},
+		{"html_file", []Object{str(path), hash(str("name"), str("<ann>")), hash(str("footer"), str(`<hr>`))}, "<h1>&lt;ann&gt;</h1><hr>"},
+	}
+	for _, tt := range tests {
+		result, ok := call(tt.name, tt.args...).(*String)
+		if !ok || result.Value != tt.expected {
+			t.Errorf("tmpl.%s: expected %q, got %v", tt.name, tt.expected, result)
+		}
+	}
+
+	errors := []struct {
+		name     string
+		args     []Object
+		expected string
+	}{
+		{"render", []Object{str(`{{.x`), data}, "Template error: template: render:1: unclosed action"},
+		{"render", []Object{str(`{{template "nope"}}`), data}, `Template error: template: render:1:11: executing "render" at <{{template "nope"}}>: template "nope" not defined`},
+		{"render", []Object{str(``), data, hash(str("p"), &Integer{Value: 1})}, "Template error: partials must map names to template sources"},
+		{"render", []Object{&Integer{Value: 1}, data}, "Argument 0 to `render` must be STRING, got INTEGER"},
+	}
+	for _, tt := range errors {
+		err, ok := call(tt.name, tt.args...).(*Error)
+		if !ok || err.Message != tt.expected {
+			t.Errorf("tmpl.%s: expected error %q, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestTracebackLines(t *testing.T) {
+	got := TracebackLines([]string{"in <main>", "in f", "in f", "in g", "in g", "in g", "in f"})
+	expected := []string{"in <main>", "in f", "in f", "in g", "... repeated 2 more times", "in f"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong traceback lines: %q", got)
+	}
+
+	var alternating []string
+	for i := 0; i < 50; i++ {
+		alternating = append(alternating, "in a", "in b")
+	}
+	got = TracebackLines(alternating)
+	if len(got) > MaxTracebackLines {
+		t.Fatalf("expected at most %d lines, got %d", MaxTracebackLines, len(got))
+	}
+	if got[0] != "in a" || got[len(got)-1] != "in b" || !strings.Contains(strings.Join(got, "|"), "... 62 more lines") {
+		t.Errorf("expected the outermost and innermost calls around the omitted ones, got %q", got)
+	}
+}
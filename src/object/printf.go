@@ -0,0 +1,114 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stringFmt is string.fmt: it formats its arguments printf-style. Each verb
+// takes the next argument, which must be of the type the verb formats.
+func stringFmt(args ...Object) Object {
+	if len(args) < 1 {
+		return newError("Wrong number of arguments. Expected at least 1, got 0")
+	}
+	format, ok := args[0].(*String)
+	if !ok {
+		return typeError("Argument 0 to `fmt` must be STRING, got %s", args[0].Type())
+	}
+
+	var out strings.Builder
+	text := format.Value
+	next := 1
+	for i := 0; i < len(text); i++ {
+		if text[i] != '%' {
+			out.WriteByte(text[i])
+			continue
+		}
+
+		// A verb is %, flags, a width, a precision and a letter.
+		start := i
+		for i++; i < len(text) && strings.IndexByte("-+ 0#", text[i]) >= 0; i++ {
+		}
+		for ; i < len(text) && isDigit(text[i]); i++ {
+		}
+		if i < len(text) && text[i] == '.' {
+			for i++; i < len(text) && isDigit(text[i]); i++ {
+			}
+		}
+		if i == len(text) {
+			return newError("The format ends in the middle of the verb %s", text[start:])
+		}
+		spec := text[start : i+1]
+		if spec == "%%" {
+			out.WriteByte('%')
+			continue
+		}
+		if next == len(args) {
+			return newError("Missing argument for %s: the format has more verbs than arguments", spec)
+		}
+
+		formatted, err := formatVerb(spec, args[next], next)
+		if err != nil {
+			return err
+		}
+		out.WriteString(formatted)
+		next++
+	}
+
+	if next < len(args) {
+		return newError("Too many arguments for the format: it uses %d, got %d", next-1, len(args)-1)
+	}
+	return &String{Value: out.String()}
+}
+
+// formatVerb formats value, argument i to string.fmt, with spec, a verb
+// with its flags, width and precision.
+func formatVerb(spec string, value Object, i int) (string, *Error) {
+	verb := spec[len(spec)-1]
+	mismatch := func(want string) (string, *Error) {
+		return "", typeError("Argument %d to `fmt` must be %s for %s, got %s", i, want, spec, value.Type())
+	}
+
+	switch verb {
+	case 'd', 'x', 'X', 'o', 'b':
+		switch value := value.(type) {
+		case *Integer:
+			return fmt.Sprintf(spec, value.Value), nil
+		case *Hex:
+			return fmt.Sprintf(spec, value.Value), nil
+		case *String:
+			if verb == 'x' || verb == 'X' {
+				return fmt.Sprintf(spec, value.Value), nil
+			}
+		}
+		if verb == 'x' || verb == 'X' {
+			return mismatch("INTEGER or STRING")
+		}
+		return mismatch("INTEGER")
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		switch value := value.(type) {
+		case *Float:
+			return fmt.Sprintf(spec, value.Value), nil
+		case *Integer:
+			return fmt.Sprintf(spec, float64(value.Value)), nil
+		}
+		return mismatch("FLOAT")
+	case 's', 'q':
+		if s, ok := value.(*String); ok {
+			return fmt.Sprintf(spec, s.Value), nil
+		}
+		return mismatch("STRING")
+	case 't':
+		if b, ok := value.(*Boolean); ok {
+			return fmt.Sprintf(spec, b.Value), nil
+		}
+		return mismatch("BOOLEAN")
+	case 'v':
+		return fmt.Sprintf(spec[:len(spec)-1]+"s", Format(value)), nil
+	}
+	return "", newError("Unknown verb %s in the format", spec)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,36 @@
+package object
+
+import "testing"
+
+func TestStringFmt(t *testing.T) {
+	str := func(s string) *String { return &String{Value: s} }
+	integer := func(n int64) *Integer { return &Integer{Value: n} }
+
+	tests := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{str("x=%d y=%.2f name=%s"), integer(1), &Float{Value: 2.5}, str("bob")}, "x=1 y=2.50 name=bob"},
+		{[]Object{str("[%5d|%-5s|%05.1f]"), integer(42), str("ab"), integer(3)}, "[   42|ab   |003.0]"},
+		{[]Object{str("%x %X %x"), &Hex{Value: 255}, integer(171), str("hi")}, "ff AB 6869"},
+		{[]Object{str("%v and %v, %t"), &Array{Elements: []Object{integer(1), str("a")}}, &Null{}, &Boolean{Value: true}}, `[1, "a"] and null, true`},
+		{[]Object{str("%6v|%q"), str("ok"), str("a\"b")}, `    ok|"a\"b"`},
+		{[]Object{str("100%% of %s"), str("it")}, "100% of it"},
+		{[]Object{str("héllo %s"), str("wörld")}, "héllo wörld"},
+		{[]Object{str("plain")}, "plain"},
+
+		{[]Object{str("%d"), str("1")}, "ERROR: TypeError: Argument 1 to `fmt` must be INTEGER for %d, got STRING"},
+		{[]Object{str("%s %.1f"), str("a"), str("b")}, "ERROR: TypeError: Argument 2 to `fmt` must be FLOAT for %.1f, got STRING"},
+		{[]Object{str("%s %s"), str("a")}, "ERROR: Missing argument for %s: the format has more verbs than arguments"},
+		{[]Object{str("%s"), str("a"), str("b")}, "ERROR: Too many arguments for the format: it uses 1, got 2"},
+		{[]Object{str("%y"), integer(1)}, "ERROR: Unknown verb %y in the format"},
+		{[]Object{str("50%"), integer(1)}, "ERROR: The format ends in the middle of the verb %"},
+		{[]Object{integer(1)}, "ERROR: TypeError: Argument 0 to `fmt` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		if got := stringFmt(tt.args...).Inspect(); got != tt.expected {
+			t.Errorf("fmt(%s): expected %q, got %q", tt.args[0].Inspect(), tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package object
+
+import (
+	"fmt"
+	"plugin"
+	"sync"
+)
+
+// registry guards adding builtins. sealed is set once the class objects
+// were built: from then on the builtins' indexes are baked into compiled
+// code and symbol tables, so no more can be added.
+var registry struct {
+	sync.Mutex
+	sealed bool
+}
+
+func sealBuiltins() {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.sealed = true
+}
+
+// maxBuiltins is how many builtins and classes OpGetBuiltin can address.
+const maxBuiltins = 256
+
+// RegisterBuiltin adds fn to the builtins, as class.name or as a bare
+// builtin when class is "". An unknown class is added after the built-in
+// ones. Programs embedding the language, and plugins, use it to expose Go
+// functions to scripts; it must be called before any code is compiled or
+// run.
+func RegisterBuiltin(class, name string, fn BuiltinFunction) error {
+	if fn == nil {
+		return fmt.Errorf("builtin %s has no function", qualifiedName(class, name))
+	}
+	return register(class, name, func() *Builtin { return createBuiltin(fn, class) })
+}
+
+// RegisterCallingBuiltin is RegisterBuiltin for a builtin that calls the
+// functions scripts pass it, such as callbacks. fn calls them with the
+// Caller it is given, which runs them on the backend of the script calling
+// the builtin.
+func RegisterCallingBuiltin(class, name string, fn CallingFunction) error {
+	if fn == nil {
+		return fmt.Errorf("builtin %s has no function", qualifiedName(class, name))
+	}
+	return register(class, name, func() *Builtin { return createCallingBuiltin(fn, class) })
+}
+
+// register adds the builtin made by create as class.name, after checking
+// that it can be added.
+func register(class, name string, create func() *Builtin) error {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.sealed {
+		return fmt.Errorf("builtin %s can't be registered after code was compiled", qualifiedName(class, name))
+	}
+	if !isIdentifier(name) || (class != "" && !isIdentifier(class)) {
+		return fmt.Errorf("invalid builtin name %q", qualifiedName(class, name))
+	}
+	if class == "task" {
+		return fmt.Errorf("class task is reserved")
+	}
+
+	newClass := class != ""
+	for _, className := range ClassNames {
+		if className == class {
+			newClass = false
+		}
+	}
+	for _, def := range Builtins {
+		// Bare builtins are looked up by name alone, so their names can't
+		// be shared with any other builtin.
+		if def.Name == name && (def.Builtin.Class == class || class == "" || def.Builtin.Class == "") {
+			return fmt.Errorf("builtin %s clashes with builtin %s", qualifiedName(class, name), qualifiedName(def.Builtin.Class, def.Name))
+		}
+	}
+	count := len(Builtins) + len(ClassNames) + 1
+	if newClass {
+		count++
+	}
+	if count > maxBuiltins {
+		return fmt.Errorf("can't register %s: at most %d builtins and classes are supported", qualifiedName(class, name), maxBuiltins)
+	}
+
+	Builtins = append(Builtins, struct {
+		Name    string
+		Builtin *Builtin
+	}{name, create()})
+	if newClass {
+		ClassNames = append(ClassNames, class)
+	}
+	return nil
+}
+
+// LoadPlugin opens the Go plugin at path, built with `go build
+// -buildmode=plugin` against this module, and calls its exported Register
+// function, of type func() error, which registers the plugin's builtins
+// with RegisterBuiltin.
+func LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	symbol, err := p.Lookup("Register")
+	if err != nil {
+		return fmt.Errorf("plugin %s: %v", path, err)
+	}
+	register, ok := symbol.(func() error)
+	if !ok {
+		return fmt.Errorf("plugin %s: Register must be a func() error, got %T", path, symbol)
+	}
+	if err := register(); err != nil {
+		return fmt.Errorf("plugin %s: %v", path, err)
+	}
+	return nil
+}
+
+func qualifiedName(class, name string) string {
+	if class == "" {
+		return name
+	}
+	return class + "." + name
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		letter := r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package object
+
+import "testing"
+
+func TestRegisterBuiltin(t *testing.T) {
+	// Other tests may have built the class objects already.
+	builtins, classNames := Builtins, ClassNames
+	registry.sealed = false
+	t.Cleanup(func() {
+		Builtins, ClassNames = builtins, classNames
+		registry.sealed = true
+	})
+
+	greet := func(args ...Object) Object { return &String{Value: "hi"} }
+	if err := RegisterBuiltin("team", "greet", greet); err != nil {
+		t.Fatalf("RegisterBuiltin: %v", err)
+	}
+	if err := RegisterBuiltin("", "answer", func(args ...Object) Object { return &Integer{Value: 42} }); err != nil {
+		t.Fatalf("RegisterBuiltin: %v", err)
+	}
+	twice := func(call Caller, args ...Object) Object {
+		call(args[0])
+		return call(args[0])
+	}
+	if err := RegisterCallingBuiltin("os", "twice", twice); err != nil {
+		t.Fatalf("RegisterCallingBuiltin: %v", err)
+	}
+
+	errors := []struct {
+		class, name string
+		expected    string
+	}{
+		{"team", "greet", "builtin team.greet clashes with builtin team.greet"},
+		{"", "spawn", "builtin spawn clashes with builtin spawn"},
+		{"team", "answer", "builtin team.answer clashes with builtin answer"},
+		{"team", "not a name", `invalid builtin name "team.not a name"`},
+		{"task", "x", "class task is reserved"},
+		{"os", "twice", "builtin os.twice clashes with builtin os.twice"},
+	}
+	for _, tt := range errors {
+		err := RegisterBuiltin(tt.class, tt.name, greet)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("RegisterBuiltin(%q, %q): expected %q, got %v", tt.class, tt.name, tt.expected, err)
+		}
+	}
+
+	team, ok := CreateClassObjects()["team"]
+	if !ok {
+		t.Fatalf("class team wasn't created")
+	}
+	key := &String{Value: "greet"}
+	if pair, ok := team.Pairs[key.HashKey()]; !ok || pair.Value.(*Builtin).Fn().Inspect() != "hi" {
+		t.Errorf("team.greet isn't the registered builtin")
+	}
+	key = &String{Value: "twice"}
+	if pair, ok := CreateClassObjects()["os"].Pairs[key.HashKey()]; !ok || pair.Value.(*Builtin).Calling == nil {
+		t.Errorf("os.twice isn't the registered calling builtin")
+	}
+	if GetBuiltinByName("answer") == nil {
+		t.Errorf("answer isn't a builtin")
+	}
+
+	err := RegisterBuiltin("team", "late", greet)
+	if err == nil || err.Error() != "builtin team.late can't be registered after code was compiled" {
+		t.Errorf("expected registering after the class objects were built to fail, got %v", err)
+	}
+}
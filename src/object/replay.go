@@ -0,0 +1,284 @@
+package object
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// NondeterministicBuiltins are the builtins whose results depend on more
+// than their arguments. A recording captures their results so a replay can
+// return them instead of running them.
+//
+// Two sources of variation are left out. stream.open, stream.exec and
+// stream.connect return handles, which can't be recorded, so a replay opens
+// the file, starts the process or connects again; only what is read from
+// the stream is replayed. And the order tasks run in isn't recorded, so
+// which value chan.recv gets first or which channel chan.select picks can
+// differ between the recording and the replay.
+var NondeterministicBuiltins = []string{
+	"io.read", "keyboard.read", "keyboard.listen",
+	"os.env", "os.exec", "os.iRuntime",
+	"time.now", "math.rand",
+	"file.read", "file.read_bytes",
+	"stream.read", "stream.read_bytes", "stream.read_line",
+	"http.get", "http.post", "http.request",
+	"runtime.memory", "runtime.gc_stats",
+}
+
+// tracedCall is one line of a trace: a call of a nondeterministic builtin
+// and what it returned.
+type tracedCall struct {
+	Builtin string        `json:"builtin"`
+	Args    []interface{} `json:"args"`
+	Result  interface{}   `json:"result"`
+}
+
+// recordedCall is a tracedCall read back, with its values left encoded.
+type recordedCall struct {
+	Builtin string          `json:"builtin"`
+	Args    json.RawMessage `json:"args"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// traceActive is set while a recording or replay is running.
+var traceActive atomic.Bool
+
+// TraceActive reports whether calls are being recorded or replayed, so
+// they have to happen in a repeatable order.
+func TraceActive() bool {
+	return traceActive.Load()
+}
+
+// wrapNondeterministic replaces the function of every nondeterministic
+// builtin with wrap(name, fn) and returns a function restoring them.
+func wrapNondeterministic(wrap func(name string, fn BuiltinFunction) BuiltinFunction) (restore func()) {
+	wanted := map[string]bool{}
+	for _, name := range NondeterministicBuiltins {
+		wanted[name] = true
+	}
+
+	traceActive.Store(true)
+	originals := map[*Builtin]BuiltinFunction{}
+	for _, def := range Builtins {
+		name := def.Builtin.Class + "." + def.Name
+		if !wanted[name] {
+			continue
+		}
+		originals[def.Builtin] = def.Builtin.Fn
+		def.Builtin.Fn = wrap(name, def.Builtin.Fn)
+	}
+	return func() {
+		for builtin, fn := range originals {
+			builtin.Fn = fn
+		}
+		traceActive.Store(false)
+	}
+}
+
+// StartRecording makes the nondeterministic builtins write every call and
+// its result to w, one JSON object per line, until the returned function is
+// called. stop reports the first error writing the trace.
+func StartRecording(w io.Writer) (stop func() error) {
+	var mu sync.Mutex
+	var writeErr error
+	encoder := json.NewEncoder(w)
+
+	restore := wrapNondeterministic(func(name string, fn BuiltinFunction) BuiltinFunction {
+		return func(args ...Object) Object {
+			result := fn(args...)
+
+			mu.Lock()
+			defer mu.Unlock()
+			call := tracedCall{Builtin: name, Args: encodeTraceValues(args), Result: encodeTraceValue(result)}
+			if err := encoder.Encode(call); err != nil && writeErr == nil {
+				writeErr = err
+			}
+			return result
+		}
+	})
+	return func() error {
+		restore()
+		mu.Lock()
+		defer mu.Unlock()
+		return writeErr
+	}
+}
+
+// StartReplay reads a trace written by StartRecording and makes the
+// nondeterministic builtins return the recorded results, in order, without
+// running, until the returned function is called. A call that doesn't
+// match the next recorded one returns an error: the program has taken a
+// different path than in the recording.
+func StartReplay(r io.Reader) (stop func(), err error) {
+	var calls []recordedCall
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var call recordedCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			return nil, fmt.Errorf("trace line %d: %v", line, err)
+		}
+		calls = append(calls, call)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	next := 0
+	restore := wrapNondeterministic(func(name string, fn BuiltinFunction) BuiltinFunction {
+		return func(args ...Object) Object {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if next >= len(calls) {
+				return newError("Replay diverged: %s was called after the recorded calls ran out", name)
+			}
+			call := calls[next]
+			got, _ := json.Marshal(encodeTraceValues(args))
+			if call.Builtin != name || string(got) != string(call.Args) {
+				return newError("Replay diverged at call %d: recorded %s%s, got %s%s", next+1, call.Builtin, call.Args, name, got)
+			}
+			next++
+
+			result, err := decodeTraceValue(call.Result)
+			if err != nil {
+				return newError("Replay failed at call %d: %s", next, err)
+			}
+			return result
+		}
+	})
+	return restore, nil
+}
+
+// encodeTraceValue converts o to a JSON value tagged with its type, so
+// integers, floats and strings survive the round trip.
+func encodeTraceValue(o Object) interface{} {
+	switch o := o.(type) {
+	case nil, *Null:
+		return map[string]interface{}{"null": true}
+	case *Integer:
+		return map[string]interface{}{"int": o.Value}
+	case *Float:
+		return map[string]interface{}{"float": strconv.FormatFloat(o.Value, 'g', -1, 64)}
+	case *String:
+		return map[string]interface{}{"string": o.Value}
+	case *Boolean:
+		return map[string]interface{}{"bool": o.Value}
+	case *Bytes:
+		return map[string]interface{}{"bytes": o.Value}
+	case *Error:
+		return map[string]interface{}{"error": o.Message}
+	case *Array:
+		return map[string]interface{}{"array": encodeTraceValues(o.Elements)}
+	case *Hash:
+		pairs := make([]HashPair, 0, len(o.Pairs))
+		for _, pair := range o.Pairs {
+			pairs = append(pairs, pair)
+		}
+		// Sort so equal hashes encode the same.
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key.Inspect() < pairs[j].Key.Inspect() })
+		encoded := make([]interface{}, len(pairs))
+		for i, pair := range pairs {
+			encoded[i] = []interface{}{encodeTraceValue(pair.Key), encodeTraceValue(pair.Value)}
+		}
+		return map[string]interface{}{"hash": encoded}
+	default:
+		return map[string]interface{}{"inspect": o.Inspect()}
+	}
+}
+
+func encodeTraceValues(objects []Object) []interface{} {
+	values := make([]interface{}, len(objects))
+	for i, o := range objects {
+		values[i] = encodeTraceValue(o)
+	}
+	return values
+}
+
+// decodeTraceValue turns a value written by encodeTraceValue back into an
+// object.
+func decodeTraceValue(raw json.RawMessage) (Object, error) {
+	var tagged map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &tagged); err != nil || len(tagged) != 1 {
+		return nil, fmt.Errorf("invalid recorded value %s", raw)
+	}
+
+	for tag, data := range tagged {
+		switch tag {
+		case "null":
+			return &Null{}, nil
+		case "int":
+			var n int64
+			err := json.Unmarshal(data, &n)
+			return &Integer{Value: n}, err
+		case "float":
+			var text string
+			if err := json.Unmarshal(data, &text); err != nil {
+				return nil, err
+			}
+			f, err := strconv.ParseFloat(text, 64)
+			return &Float{Value: f}, err
+		case "string", "inspect":
+			var s string
+			err := json.Unmarshal(data, &s)
+			return &String{Value: s}, err
+		case "bool":
+			var b bool
+			err := json.Unmarshal(data, &b)
+			return &Boolean{Value: b}, err
+		case "bytes":
+			var b []byte
+			err := json.Unmarshal(data, &b)
+			return &Bytes{Value: b}, err
+		case "error":
+			var message string
+			err := json.Unmarshal(data, &message)
+			return &Error{Message: message}, err
+		case "array":
+			var items []json.RawMessage
+			if err := json.Unmarshal(data, &items); err != nil {
+				return nil, err
+			}
+			elements := make([]Object, len(items))
+			for i, item := range items {
+				el, err := decodeTraceValue(item)
+				if err != nil {
+					return nil, err
+				}
+				elements[i] = el
+			}
+			return &Array{Elements: elements}, nil
+		case "hash":
+			var items [][2]json.RawMessage
+			if err := json.Unmarshal(data, &items); err != nil {
+				return nil, err
+			}
+			hash := &Hash{Pairs: make(map[HashKey]HashPair, len(items))}
+			for _, item := range items {
+				key, err := decodeTraceValue(item[0])
+				if err != nil {
+					return nil, err
+				}
+				hashable, ok := key.(Hashable)
+				if !ok {
+					return nil, fmt.Errorf("unusable hash key %s", Brief(key))
+				}
+				value, err := decodeTraceValue(item[1])
+				if err != nil {
+					return nil, err
+				}
+				hash.Set(hashable.HashKey(), HashPair{Key: key, Value: value})
+			}
+			return hash, nil
+		}
+		return nil, fmt.Errorf("unknown recorded value type %q", tag)
+	}
+	return nil, fmt.Errorf("invalid recorded value %s", raw)
+}
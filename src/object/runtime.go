@@ -0,0 +1,147 @@
+package object
+
+import "runtime"
+
+// VMInfo is what the runtime class can ask the VM running a program.
+type VMInfo interface {
+	// Depth returns the number of active call frames.
+	Depth() int
+	// ExecutedInstructions returns the number of instructions executed by
+	// every VM of the process so far.
+	ExecutedInstructions() int
+	// Roots returns the values the program can reach directly: its globals
+	// and the values on the stack.
+	Roots() []Object
+	// Fork runs fn with args on a new VM sharing this one's constants and
+	// globals and returns its result.
+	Fork(fn *Closure, args []Object) (Object, error)
+	// Map calls fn with each of items on up to workers VMs running in
+	// parallel and returns the results in order.
+	Map(fn *Closure, items []Object, workers int) ([]Object, error)
+}
+
+// RunningVM is the VM executing the current builtin call, nil outside of
+// one. The VM sets it while it runs.
+var RunningVM VMInfo
+
+// ForkCall calls fn, a closure, with args on a fork of RunningVM. It is the
+// Caller of code that runs outside of a call from a backend, such as the
+// handlers of GUI events.
+func ForkCall(fn Object, args ...Object) Object {
+	cl, ok := fn.(*Closure)
+	if !ok || RunningVM == nil {
+		return newError("Can't call %s here", fn.Type())
+	}
+	result, err := RunningVM.Fork(cl, args)
+	if err != nil {
+		return &Error{Message: err.Error()}
+	}
+	if result == nil {
+		return &Null{}
+	}
+	return result
+}
+
+// stringHash builds a hash with string keys.
+func stringHash(values map[string]Object) *Hash {
+	hash := &Hash{Pairs: make(map[HashKey]HashPair, len(values))}
+	for _, key := range sortedNames(values) {
+		k := &String{Value: key}
+		hash.Set(k.HashKey(), HashPair{Key: k, Value: values[key]})
+	}
+	return hash
+}
+
+func integer(n uint64) *Integer {
+	return &Integer{Value: int64(n)}
+}
+
+// memoryHash reports the memory held by the process, in bytes.
+func memoryHash() *Hash {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return stringHash(map[string]Object{
+		"heap_alloc":   integer(m.HeapAlloc),
+		"heap_sys":     integer(m.HeapSys),
+		"heap_objects": integer(m.HeapObjects),
+		"total_alloc":  integer(m.TotalAlloc),
+		"sys":          integer(m.Sys),
+		"goroutines":   &Integer{Value: int64(runtime.NumGoroutine())},
+	})
+}
+
+// gcStatsHash reports the garbage collector's work so far. Durations are
+// in nanoseconds.
+func gcStatsHash() *Hash {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	lastPause := uint64(0)
+	if m.NumGC > 0 {
+		lastPause = m.PauseNs[(m.NumGC+255)%256]
+	}
+	return stringHash(map[string]Object{
+		"num_gc":         integer(uint64(m.NumGC)),
+		"pause_total_ns": integer(m.PauseTotalNs),
+		"last_pause_ns":  integer(lastPause),
+		"next_gc":        integer(m.NextGC),
+		"cpu_fraction":   &Float{Value: m.GCCPUFraction},
+	})
+}
+
+// objectCounts counts the values reachable from roots by type, each value
+// once. Arrays, hashes and closures are followed into their elements, and
+// the cells of captured variables into their values.
+// Builtins and the classes holding them aren't counted.
+func objectCounts(roots []Object) map[string]int {
+	counts := map[string]int{}
+	seen := map[Object]bool{}
+	var visit func(o Object)
+	visit = func(o Object) {
+		if cell, ok := o.(*Cell); ok {
+			o = cell.Value
+		}
+		if o == nil || seen[o] || IsBuiltinValue(o) {
+			return
+		}
+		seen[o] = true
+		counts[string(o.Type())]++
+		switch o := o.(type) {
+		case *Array:
+			for _, el := range o.Elements {
+				visit(el)
+			}
+		case *Hash:
+			for _, pair := range o.Pairs {
+				visit(pair.Key)
+				visit(pair.Value)
+			}
+		case *Closure:
+			for _, free := range o.Free {
+				visit(free)
+			}
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+	return counts
+}
+
+// IsBuiltinValue reports whether o is a builtin or a class of builtins.
+func IsBuiltinValue(o Object) bool {
+	switch o := o.(type) {
+	case *Builtin:
+		return true
+	case *Hash:
+		if len(o.Pairs) == 0 {
+			return false
+		}
+		for _, pair := range o.Pairs {
+			if _, ok := pair.Value.(*Builtin); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
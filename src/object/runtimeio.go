@@ -0,0 +1,71 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// RuntimeIO is where a script's input comes from and where its output and
+// errors go. io.read, keyboard.read, stream.stdin and "line" events read
+// In; io.echo, io.print, stream.stdout, the echoed values of top-level
+// statements and the messages of the pkg builtins write to Out; log records
+// and the stderr of processes started with stream.exec and of SQX sessions
+// go to Err.
+type RuntimeIO struct {
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+}
+
+// ErrWriter receives what scripts write to stderr. Set it with SetIO.
+var ErrWriter io.Writer = os.Stderr
+
+// inputSource is the reader stdinStream buffers.
+var inputSource io.Reader = os.Stdin
+
+// SetIO directs the input and output of scripts to the streams of rio,
+// leaving those that are nil as they are, and returns a function that
+// restores the previous ones. Input is buffered, so a reader given as In
+// shouldn't be read by anything else meanwhile.
+func SetIO(rio RuntimeIO) (restore func()) {
+	previous := CurrentIO()
+	previousReader := stdinStream.r
+	if rio.In != nil {
+		inputSource = rio.In
+		stdinStream.r = bufio.NewReader(rio.In)
+	}
+	if rio.Out != nil {
+		OutWriter = rio.Out
+	}
+	if rio.Err != nil {
+		ErrWriter = rio.Err
+	}
+	return func() {
+		inputSource, stdinStream.r = previous.In, previousReader
+		OutWriter, ErrWriter = previous.Out, previous.Err
+	}
+}
+
+// CurrentIO returns the streams scripts use.
+func CurrentIO() RuntimeIO {
+	return RuntimeIO{In: inputSource, Out: OutWriter, Err: ErrWriter}
+}
+
+// inputTerminal returns the file scripts read from when it is a terminal,
+// so keys can be read as they are pressed.
+func inputTerminal() (*os.File, bool) {
+	file, ok := inputSource.(*os.File)
+	if !ok || !term.IsTerminal(int(file.Fd())) {
+		return nil, false
+	}
+	return file, true
+}
+
+// inputIsTerminal reports whether scripts read from a terminal.
+func inputIsTerminal() bool {
+	_, ok := inputTerminal()
+	return ok
+}
@@ -0,0 +1,116 @@
+package object
+
+import (
+	"fmt"
+)
+
+// Set is a collection of distinct hashable values. Its elements keep the
+// order they were first added in, so a set prints and lists them the same
+// way every time. Like arrays, sets aren't changed in place: set.add and
+// the others return a new set.
+type Set struct {
+	Elements map[HashKey]Object
+	order    []HashKey
+}
+
+func NewSet() *Set {
+	return &Set{Elements: map[HashKey]Object{}}
+}
+
+func (s *Set) Type() ObjectType { return SET_OBJ }
+func (s *Set) Inspect() string  { return Format(s) }
+
+// Add adds o unless the set already has it. It fails for values that can't
+// be hashed.
+func (s *Set) Add(o Object) error {
+	key, ok := HashKeyOf(o)
+	if !ok {
+		return fmt.Errorf("unusable as set element: %s", o.Type())
+	}
+	if _, ok := s.Elements[key]; !ok {
+		s.Elements[key] = o
+		s.order = append(s.order, key)
+	}
+	return nil
+}
+
+// Has reports whether o is in the set.
+func (s *Set) Has(o Object) bool {
+	key, ok := HashKeyOf(o)
+	if !ok {
+		return false
+	}
+	_, ok = s.Elements[key]
+	return ok
+}
+
+// Items returns the elements in the order they were added.
+func (s *Set) Items() []Object {
+	items := make([]Object, len(s.order))
+	for i, key := range s.order {
+		items[i] = s.Elements[key]
+	}
+	return items
+}
+
+// Len returns the number of elements.
+func (s *Set) Len() int {
+	return len(s.order)
+}
+
+func (s *Set) clone() *Set {
+	out := &Set{Elements: make(map[HashKey]Object, len(s.Elements)), order: append([]HashKey(nil), s.order...)}
+	for key, el := range s.Elements {
+		out.Elements[key] = el
+	}
+	return out
+}
+
+// filter returns a new set of the elements keep accepts.
+func (s *Set) filter(keep func(Object) bool) *Set {
+	out := NewSet()
+	for _, el := range s.Items() {
+		if keep(el) {
+			out.Add(el)
+		}
+	}
+	return out
+}
+
+// setOf builds a set from the elements of an array or a set, the argument
+// of set.new.
+func setOf(o Object) Object {
+	var items []Object
+	switch o := o.(type) {
+	case *Array:
+		items = o.Elements
+	case *Set:
+		items = o.Items()
+	default:
+		return typeError("Argument 0 to `new` must be ARRAY or SET, got %s", o.Type())
+	}
+	s := NewSet()
+	for _, el := range items {
+		if err := s.Add(el); err != nil {
+			return newError("%s", err)
+		}
+	}
+	return s
+}
+
+// setArgs checks that the builtin name got n sets followed by extra other
+// arguments.
+func setArgs(name string, args []Object, n, extra int) ([]*Set, *Error) {
+	if len(args) != n+extra {
+		return nil, newError("Wrong number of arguments. Expected %d, got %d", n+extra, len(args))
+	}
+	sets := make([]*Set, n)
+	for i := range sets {
+		s, ok := args[i].(*Set)
+		if !ok {
+			return nil, typeError("Argument %d to `%s` must be SET, got %s", i, name, args[i].Type())
+		}
+		sets[i] = s
+	}
+	return sets, nil
+}
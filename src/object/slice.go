@@ -0,0 +1,82 @@
+package object
+
+// Slice returns o[start:end] for an array, tuple, string or bytes. start and
+// end are integers or null for the start and the end of o; negative bounds
+// count from the end. Strings are sliced by characters. A range outside of o
+// gives an error value; an o or bounds that can't be sliced give err.
+func Slice(o, start, end Object) (result Object, err error) {
+	var length int
+	var runes []rune
+	switch o := o.(type) {
+	case *Array:
+		length = len(o.Elements)
+	case *Tuple:
+		length = len(o.Elements)
+	case *Bytes:
+		length = len(o.Value)
+	case *String:
+		runes = []rune(o.Value)
+		length = len(runes)
+	default:
+		return nil, NewTypeError("Slice operator not supported: %s", o.Type())
+	}
+
+	from, err := sliceBound(start, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	to, err := sliceBound(end, length, length)
+	if err != nil {
+		return nil, err
+	}
+	if from < 0 || to > length || from > to {
+		return indexError("Slice %s:%s is out of range (length is %d)", boundString(start), boundString(end), length), nil
+	}
+
+	switch o := o.(type) {
+	case *Array:
+		return NewArray(append([]Object(nil), o.Elements[from:to]...)), nil
+	case *Tuple:
+		return &Tuple{Elements: append([]Object(nil), o.Elements[from:to]...)}, nil
+	case *Bytes:
+		return &Bytes{Value: append([]byte(nil), o.Value[from:to]...)}, nil
+	default:
+		return &String{Value: string(runes[from:to])}, nil
+	}
+}
+
+// sliceBound reads a slice bound, which is missing when it is null.
+func sliceBound(bound Object, missing, length int) (int, error) {
+	switch bound := bound.(type) {
+	case nil, *Null:
+		return missing, nil
+	case *Integer:
+		return NormalizeIndex(bound.Value, length), nil
+	default:
+		return 0, NewTypeError("Slice bounds must be INTEGER, got %s", bound.Type())
+	}
+}
+
+func boundString(bound Object) string {
+	if i, ok := bound.(*Integer); ok {
+		return i.Inspect()
+	}
+	return ""
+}
+
+// NormalizeIndex turns a negative index, counting from the end of a
+// collection of length elements, into one counting from the start. The
+// result can still be out of range.
+func NormalizeIndex(i int64, length int) int {
+	if i < 0 {
+		i += int64(length)
+	}
+	if i < -1 || i > int64(length)+1 {
+		// Keep far out-of-range indexes from overflowing int.
+		if i < 0 {
+			return -1
+		}
+		return length + 1
+	}
+	return int(i)
+}
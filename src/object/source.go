@@ -0,0 +1,72 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// sources holds the text of the files being run, split into lines, so
+// errors raised while running them can quote the offending line.
+var sources = struct {
+	sync.Mutex
+	files map[string][]string
+}{files: map[string][]string{}}
+
+// RegisterSource records src as the text of filename. Runners call it
+// before running code compiled from the file.
+func RegisterSource(filename, src string) {
+	sources.Lock()
+	defer sources.Unlock()
+	sources.files[filename] = strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+}
+
+// SourceContext returns line of filename followed by a caret under column,
+// indented like parser errors, or "" when the file's source isn't known.
+// The caret is left out when column is 0.
+func SourceContext(filename string, line, column int) string {
+	sources.Lock()
+	lines := sources.files[filename]
+	sources.Unlock()
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	text := lines[line-1]
+	if column < 1 || column > len(text) {
+		return "  " + text
+	}
+	// Keep the tabs of the line so the caret lines up with it.
+	var pointer strings.Builder
+	for _, ch := range text[:column-1] {
+		if ch == '\t' {
+			pointer.WriteRune('\t')
+		} else {
+			pointer.WriteRune(' ')
+		}
+	}
+	return fmt.Sprintf("  %s\n  %s^", text, pointer.String())
+}
+
+// WithSourceContext returns message with the SourceContext of the position
+// inserted under its first line, ahead of any traceback.
+func WithSourceContext(message, filename string, line, column int) string {
+	context := SourceContext(filename, line, column)
+	if context == "" {
+		return message
+	}
+	first, rest, found := strings.Cut(message, "\n")
+	if !found {
+		return first + "\n" + context
+	}
+	return first + "\n" + context + "\n" + rest
+}
+
+// InspectWithContext is Inspect, quoting the source line the error was
+// raised at when its file is known.
+func (e *Error) InspectWithContext() string {
+	if e.Filename == "" {
+		return e.Inspect()
+	}
+	return WithSourceContext(e.Inspect(), e.Filename, e.Line, e.Column)
+}
@@ -59,7 +59,8 @@ func loadSQXCommandManifest(path string, manifest SQXManifest) (*Hash, error) {
 	baseDir := filepath.Dir(path)
 	namespace := &Hash{Pairs: make(map[HashKey]HashPair)}
 
-	for fnName, spec := range manifest.Functions {
+	for _, fnName := range sortedNames(manifest.Functions) {
+		spec := manifest.Functions[fnName]
 		if len(spec.Exec) == 0 {
 			return nil, fmt.Errorf("SQX function %q in %q has empty exec command", fnName, path)
 		}
@@ -80,7 +81,7 @@ func loadSQXCommandManifest(path string, manifest SQXManifest) (*Hash, error) {
 		}
 
 		key := &String{Value: fnName}
-		namespace.Pairs[key.HashKey()] = HashPair{Key: key, Value: builtin}
+		namespace.Set(key.HashKey(), HashPair{Key: key, Value: builtin})
 	}
 
 	return namespace, nil
@@ -113,7 +114,8 @@ func loadSQXExecutableModule(path string) (*Hash, error) {
 	}
 
 	namespace := &Hash{Pairs: make(map[HashKey]HashPair)}
-	for fnName, spec := range manifest.Functions {
+	for _, fnName := range sortedNames(manifest.Functions) {
+		spec := manifest.Functions[fnName]
 		fnNameCopy := fnName
 		specCopy := spec
 
@@ -126,7 +128,7 @@ func loadSQXExecutableModule(path string) (*Hash, error) {
 			var runErr error
 
 			// Use session loader if available
-			if loader != nil && !loader.session.IsClosed() {
+			if loader != nil && loader.session != nil && !loader.session.IsClosed() {
 				out, runErr = loader.Call(fnNameCopy, specCopy.Return, args...)
 			} else {
 				out, runErr = runSQXModuleFunction(absPath, fnNameCopy, specCopy.Return, args...)
@@ -139,7 +141,7 @@ func loadSQXExecutableModule(path string) (*Hash, error) {
 		}
 
 		key := &String{Value: fnName}
-		namespace.Pairs[key.HashKey()] = HashPair{Key: key, Value: builtin}
+		namespace.Set(key.HashKey(), HashPair{Key: key, Value: builtin})
 	}
 
 	return namespace, nil
@@ -50,6 +50,7 @@ func EnableSessionMode(path string) (*sqx.Session, error) {
 	sess, err := sqx.NewSession(sqx.SessionConfig{
 		Path:        path,
 		CallTimeout: 30 * time.Second,
+		Stderr:      ErrWriter,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to start SQX session for %q: %w", path, err)
@@ -126,6 +127,7 @@ func supportsSession(path string) bool {
 	sess, err := sqx.NewSession(sqx.SessionConfig{
 		Path:        path,
 		CallTimeout: 5 * time.Second,
+		Stderr:      ErrWriter,
 	})
 	if err != nil {
 		return false
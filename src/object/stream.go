@@ -0,0 +1,344 @@
+package object
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Stream is something data can be read from or written to a piece at a
+// time: a file, a child process's stdout and stdin, a network connection
+// or the program's own stdin and stdout. stream.pipe copies one stream into
+// another without holding all of the data at once.
+type Stream struct {
+	name string
+	// r is nil for streams that can't be read, w for those that can't be
+	// written.
+	r *bufio.Reader
+	w io.Writer
+	// rmu and wmu keep tasks from reading, or writing, at once.
+	rmu, wmu sync.Mutex
+	// close releases the stream. For a process it closes the process's
+	// stdin and waits for it to exit.
+	close func() error
+	// closeWrite ends the writing side alone, so a process or the other end
+	// of a connection sees the end of its input while the stream can still
+	// be read. It is nil when the stream has no separate writing side.
+	closeWrite  func() error
+	cmd         *exec.Cmd
+	closed      bool
+	writeClosed bool
+	// std is set for the program's stdin and stdout, which stay open.
+	std bool
+}
+
+func (s *Stream) Type() ObjectType { return STREAM_OBJ }
+func (s *Stream) Inspect() string {
+	if s.closed {
+		return fmt.Sprintf("Stream[%s, closed]", s.name)
+	}
+	return fmt.Sprintf("Stream[%s]", s.name)
+}
+
+var (
+	stdinStream  = &Stream{name: "stdin", r: bufio.NewReader(os.Stdin), std: true}
+	stdoutStream = &Stream{name: "stdout", std: true}
+)
+
+func (s *Stream) writer() io.Writer {
+	if s == stdoutStream {
+		// Follow OutWriter, which tests and hosts redirect.
+		return OutWriter
+	}
+	return s.w
+}
+
+// openFileStream opens path for reading ("r"), writing ("w") or appending
+// ("a").
+func openFileStream(path, mode string) Object {
+	var f *os.File
+	var err error
+	switch mode {
+	case "r":
+		f, err = os.Open(path)
+	case "w":
+		f, err = os.Create(path)
+	case "a":
+		f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	default:
+		return newError("Unknown stream mode %q, expected \"r\", \"w\" or \"a\"", mode)
+	}
+	if err != nil {
+		return ioError("Could not open %s: %s", path, err)
+	}
+	s := &Stream{name: path, close: f.Close}
+	if mode == "r" {
+		s.r = bufio.NewReader(f)
+	} else {
+		s.w = f
+	}
+	return s
+}
+
+// execStream starts command, split on spaces like os.exec does. Reading
+// the stream reads the process's stdout and writing it feeds its stdin;
+// its stderr goes to ErrWriter.
+func execStream(command string) Object {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return newError("Empty command")
+	}
+	cmd := exec.CommandContext(currentContext, parts[0], parts[1:]...)
+	cmd.Stderr = ErrWriter
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return ioError("Failed to execute command: %s", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return ioError("Failed to execute command: %s", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return ioError("Failed to execute command: %s", err)
+	}
+	return &Stream{
+		name:       command,
+		r:          bufio.NewReader(stdout),
+		w:          stdin,
+		cmd:        cmd,
+		closeWrite: stdin.Close,
+		close: func() error {
+			stdin.Close()
+			var err error
+			unlocked(func() { err = cmd.Wait() })
+			return err
+		},
+	}
+}
+
+// connectStream opens a TCP connection to address, "host:port".
+func connectStream(address string) Object {
+	var conn net.Conn
+	var err error
+	ctx := currentContext
+	unlocked(func() { conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address) })
+	if ctx.Err() != nil {
+		return cancelled(ctx)
+	}
+	if err != nil {
+		return ioError("Could not connect to %s: %s", address, err)
+	}
+	s := &Stream{name: address, r: bufio.NewReader(conn), w: conn, close: conn.Close}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		s.closeWrite = tcp.CloseWrite
+	}
+	return s
+}
+
+func (s *Stream) checkReadable(op string) *Error {
+	if s.closed {
+		return newError("%s on closed stream %s", op, s.name)
+	}
+	if s.r == nil {
+		return newError("Stream %s can't be read", s.name)
+	}
+	return nil
+}
+
+func (s *Stream) checkWritable(op string) *Error {
+	if s.closed {
+		return newError("%s on closed stream %s", op, s.name)
+	}
+	if s.writer() == nil || s.writeClosed {
+		return newError("Stream %s can't be written", s.name)
+	}
+	return nil
+}
+
+// read reads up to n bytes, or everything left when n is negative, as a
+// string when raw is false and as BYTES when it is true. It returns null at
+// the end of the stream.
+func (s *Stream) read(n int64, raw bool) Object {
+	if err := s.checkReadable("Read"); err != nil {
+		return err
+	}
+	var data []byte
+	var err error
+	unlocked(func() {
+		s.rmu.Lock()
+		defer s.rmu.Unlock()
+		if n < 0 {
+			data, err = io.ReadAll(s.r)
+			if err == nil && len(data) == 0 {
+				err = io.EOF
+			}
+			return
+		}
+		data = make([]byte, n)
+		var read int
+		read, err = io.ReadAtLeast(s.r, data, 1)
+		data = data[:read]
+	})
+	if errors.Is(err, io.EOF) {
+		return &Null{}
+	}
+	if err != nil {
+		return ioError("Could not read %s: %s", s.name, err)
+	}
+	if raw {
+		return &Bytes{Value: data}
+	}
+	return &String{Value: string(data)}
+}
+
+// readArgs checks the arguments of stream.read and stream.read_bytes: a
+// stream and an optional positive size, -1 when left out.
+func readArgs(name string, args []Object) (*Stream, int64, *Error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, 0, newError("Wrong number of arguments. Expected 1 or 2, got %d", len(args))
+	}
+	s, ok := args[0].(*Stream)
+	if !ok {
+		return nil, 0, typeError("Argument 0 to `%s` must be STREAM, got %s", name, args[0].Type())
+	}
+	n := int64(-1)
+	if len(args) == 2 {
+		size, ok := args[1].(*Integer)
+		if !ok || size.Value <= 0 {
+			return nil, 0, newError("Argument 1 to `%s` must be a positive INTEGER, got %s", name, Brief(args[1]))
+		}
+		n = size.Value
+	}
+	return s, n, nil
+}
+
+// readLine returns the next line without its line ending, or null at the
+// end of the stream.
+func (s *Stream) readLine() Object {
+	if err := s.checkReadable("Read"); err != nil {
+		return err
+	}
+	var line string
+	var err error
+	unlocked(func() {
+		s.rmu.Lock()
+		defer s.rmu.Unlock()
+		line, err = s.r.ReadString('\n')
+	})
+	if err == io.EOF && line == "" {
+		return &Null{}
+	}
+	if err != nil && err != io.EOF {
+		return ioError("Could not read %s: %s", s.name, err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return &String{Value: strings.TrimSuffix(line, "\r")}
+}
+
+func (s *Stream) write(data []byte) Object {
+	if err := s.checkWritable("Write"); err != nil {
+		return err
+	}
+	var n int
+	var err error
+	w := s.writer()
+	unlocked(func() {
+		s.wmu.Lock()
+		defer s.wmu.Unlock()
+		n, err = w.Write(data)
+	})
+	if err != nil {
+		return ioError("Could not write %s: %s", s.name, err)
+	}
+	return &Integer{Value: int64(n)}
+}
+
+// pipe copies src into dst until src ends and returns the number of bytes
+// copied. Neither stream is closed.
+func pipe(src, dst *Stream) Object {
+	if err := src.checkReadable("Pipe"); err != nil {
+		return err
+	}
+	if err := dst.checkWritable("Pipe"); err != nil {
+		return err
+	}
+	ctx := currentContext
+	var n int64
+	var err error
+	w := dst.writer()
+	unlocked(func() {
+		src.rmu.Lock()
+		defer src.rmu.Unlock()
+		dst.wmu.Lock()
+		defer dst.wmu.Unlock()
+		buf := make([]byte, 32*1024)
+		for ctx.Err() == nil {
+			read, readErr := src.r.Read(buf)
+			if read > 0 {
+				written, writeErr := w.Write(buf[:read])
+				n += int64(written)
+				if writeErr != nil {
+					err = writeErr
+					return
+				}
+			}
+			if readErr != nil {
+				if readErr != io.EOF {
+					err = readErr
+				}
+				return
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		return cancelled(ctx)
+	}
+	if err != nil {
+		return ioError("Could not pipe %s to %s: %s", src.name, dst.name, err)
+	}
+	return &Integer{Value: n}
+}
+
+// closeStreamWrite ends the writing side of a process or connection.
+func (s *Stream) closeStreamWrite() Object {
+	if s.closeWrite == nil {
+		return newError("Stream %s has no writing side to close", s.name)
+	}
+	if s.closed || s.writeClosed {
+		return newError("Close of closed stream %s", s.name)
+	}
+	s.writeClosed = true
+	if err := s.closeWrite(); err != nil {
+		return ioError("Could not close %s: %s", s.name, err)
+	}
+	return &Null{}
+}
+
+// closeStream closes s. For a process it returns the exit code.
+func (s *Stream) closeStream() Object {
+	if s.std {
+		return &Null{}
+	}
+	if s.closed {
+		return newError("Close of closed stream %s", s.name)
+	}
+	s.closed = true
+	err := s.close()
+	if s.cmd != nil {
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return ioError("Could not close %s: %s", s.name, err)
+		}
+		return &Integer{Value: int64(s.cmd.ProcessState.ExitCode())}
+	}
+	if err != nil {
+		return ioError("Could not close %s: %s", s.name, err)
+	}
+	return &Null{}
+}
@@ -0,0 +1,80 @@
+package object
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreams(t *testing.T) {
+	call := func(name string, args ...Object) Object {
+		t.Helper()
+		builtin := CreateClassObjects()["stream"].Pairs[(&String{Value: name}).HashKey()].Value.(*Builtin)
+		return builtin.Fn(args...)
+	}
+	str := func(s string) *String { return &String{Value: s} }
+	expect := func(got Object, want string) {
+		t.Helper()
+		if got.Inspect() != want {
+			t.Errorf("expected %s, got %s", want, got.Inspect())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	out := call("open", str(path), str("w"))
+	expect(call("write", out, str("one\r\ntwo\n")), "9")
+	expect(call("write", out, str("three")), "5")
+	expect(call("close", out), "null")
+	expect(call("close", out), "ERROR: Close of closed stream "+path)
+	expect(call("write", out, str("x")), "ERROR: Write on closed stream "+path)
+
+	appended := call("open", str(path), str("a"))
+	expect(call("write", appended, str("\nfour\n")), "6")
+	call("close", appended)
+
+	in := call("open", str(path))
+	expect(call("read_line", in), "one")
+	expect(call("read", in, &Integer{Value: 3}), "two")
+	expect(call("read_line", in), "")
+	expect(call("read", in), "three\nfour\n")
+	expect(call("read", in), "null")
+	expect(call("read_line", in), "null")
+	expect(call("write", in, str("x")), "ERROR: Stream "+path+" can't be written")
+	call("close", in)
+
+	expect(call("open", str(path), str("rw")), `ERROR: Unknown stream mode "rw", expected "r", "w" or "a"`)
+	if got := call("open", str(filepath.Join(t.TempDir(), "missing"))); got.Type() != ERROR_OBJ {
+		t.Errorf("expected an error opening a missing file, got %s", got.Inspect())
+	}
+	expect(call("read", in, &Integer{Value: 0}), "ERROR: Argument 1 to `read` must be a positive INTEGER, got 0")
+
+	if _, err := exec.LookPath("tr"); err != nil {
+		t.Skip("tr not available")
+	}
+	// Feed the file through a process into another file.
+	upper := call("exec", str("tr a-z A-Z"))
+	if upper.Type() != STREAM_OBJ {
+		t.Fatalf("expected a stream, got %s", upper.Inspect())
+	}
+	in = call("open", str(path))
+	expect(call("pipe", in, upper), "20")
+	call("close", in)
+	expect(call("close_write", upper), "null")
+	expect(call("write", upper, str("x")), "ERROR: Stream tr a-z A-Z can't be written")
+	expect(call("close_write", in), "ERROR: Stream "+path+" has no writing side to close")
+
+	result := filepath.Join(t.TempDir(), "upper.txt")
+	out = call("open", str(result), str("w"))
+	expect(call("pipe", upper, out), "20")
+	call("close", out)
+	expect(call("close", upper), "0")
+	data, err := os.ReadFile(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.ReplaceAll(string(data), "\r", ""); got != "ONE\nTWO\nTHREE\nFOUR\n" {
+		t.Errorf("expected the upper-cased file, got %q", got)
+	}
+}
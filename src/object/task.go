@@ -0,0 +1,314 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Only one goroutine runs SQU1DLang code at a time: the one holding
+// interpreter. Programs run by different hosts, the tasks started by spawn
+// and the VMs' bookkeeping therefore never use the globals, RunningVM or
+// currentContext at once. The holder lets the others run when it waits,
+// sleeps or runs a command, and every so often between instructions.
+var interpreter struct {
+	sync.Mutex
+	// held is set while a goroutine holds the lock. Only the holder
+	// changes it.
+	held bool
+}
+
+// waiting counts the goroutines blocked in Enter.
+var waiting atomic.Int32
+
+// Enter takes the interpreter for a host about to run code and returns the
+// function handing it back. VM.Run calls it, so programs run by a Go
+// program embedding the language on several goroutines take turns. Hosts
+// running a series of machines sharing globals, like the REPL and the file
+// runner, hold it from start to end instead, so spawned tasks don't run
+// while they update the globals or print results between machines.
+func Enter() (leave func()) {
+	waiting.Add(1)
+	interpreter.Lock()
+	waiting.Add(-1)
+	interpreter.held = true
+	RunningVM, currentContext = nil, context.Background()
+	return func() {
+		interpreter.held = false
+		interpreter.Unlock()
+	}
+}
+
+// runningTasks counts the tasks that haven't finished.
+var runningTasks atomic.Int32
+
+// parallel counts the array.pmap calls running their function on several
+// goroutines at once. The goroutine that called pmap keeps the interpreter
+// meanwhile, so tasks are paused and waiting doesn't hand it over.
+var parallel atomic.Int32
+
+// BeginParallel marks the start of a parallel section and returns the
+// function ending it. The VM calls it around the calls of array.pmap.
+func BeginParallel() (end func()) {
+	parallel.Add(1)
+	return func() { parallel.Add(-1) }
+}
+
+// InParallel reports whether a parallel section is running.
+func InParallel() bool {
+	return parallel.Load() > 0
+}
+
+// othersCanRun reports whether code on another goroutine could still make
+// a blocked channel or mutex operation go through.
+func othersCanRun() bool {
+	return TasksRunning() || InParallel()
+}
+
+// TasksRunning reports whether any spawned task hasn't finished yet. The
+// VM checks it to decide whether to Yield.
+func TasksRunning() bool {
+	return runningTasks.Load() > 0
+}
+
+// Yield lets the tasks waiting to run take a turn before the caller, which
+// must be running SQU1DLang code, carries on.
+func Yield() {
+	unlocked(runtime.Gosched)
+}
+
+// currentContext belongs to the code running: the context of its task or
+// of the innermost with_timeout it runs in. Blocking builtins stop waiting
+// when it is done, and the VM stops running the code at its next
+// Checkpoint. Its cause is the error they return.
+var currentContext = context.Background()
+
+// Checkpoint is called by the VM every so often between instructions. It
+// lets waiting tasks and hosts take a turn and returns why the running code
+// was cancelled, if it was.
+func Checkpoint() error {
+	if TasksRunning() || waiting.Load() > 0 {
+		Yield()
+	}
+	return context.Cause(currentContext)
+}
+
+// cancelled is the error of a blocking builtin that stopped waiting because
+// ctx is done.
+func cancelled(ctx context.Context) *Error {
+	return &Error{Message: context.Cause(ctx).Error()}
+}
+
+// errTaskCancelled is the cause of the contexts of cancelled tasks.
+var errTaskCancelled = errors.New("Task cancelled")
+
+// withTimeout calls fn with the running code cancelled after ms
+// milliseconds, and returns its result or a timeout error.
+func withTimeout(ms int64, fn *Closure) Object {
+	if RunningVM == nil {
+		return newError("with_timeout needs compiled code; it can't be used in included files")
+	}
+	if InParallel() {
+		return newError("with_timeout can't be used in functions run by array.pmap")
+	}
+
+	parent := currentContext
+	ctx, cancel := context.WithTimeoutCause(parent, time.Duration(ms)*time.Millisecond,
+		fmt.Errorf("Timed out after %d ms", ms))
+	defer cancel()
+	currentContext = ctx
+	defer func() { currentContext = parent }()
+
+	result, err := RunningVM.Fork(fn, nil)
+	if ctx.Err() != nil {
+		return cancelled(ctx)
+	}
+	if err != nil {
+		return &Error{Message: err.Error()}
+	}
+	if result == nil {
+		return &Null{}
+	}
+	return result
+}
+
+// unlocked runs fn, usually something that blocks, while other tasks run.
+func unlocked(fn func()) {
+	if !interpreter.held || InParallel() {
+		fn()
+		return
+	}
+	vm, ctx := RunningVM, currentContext
+	interpreter.held = false
+	interpreter.Unlock()
+	defer func() {
+		interpreter.Lock()
+		interpreter.held = true
+		RunningVM, currentContext = vm, ctx
+	}()
+	fn()
+}
+
+// task is the state of a spawned function, shared with its handle.
+type task struct {
+	done   chan struct{}
+	result Object
+	cancel context.CancelCauseFunc
+}
+
+// spawnChecked is spawn for values from the program: it checks that args
+// fit fn and that a VM is running to start the task from.
+func spawnChecked(fn *Closure, args []Object) Object {
+	if len(args) != fn.Fn.NumParameters {
+		return newError("Wrong number of arguments for the spawned function. Expected %d, got %d", fn.Fn.NumParameters, len(args))
+	}
+	if RunningVM == nil {
+		return newError("spawn needs compiled code; it can't be used in included files")
+	}
+	if InParallel() {
+		return newError("spawn can't be used in functions run by array.pmap")
+	}
+	return spawn(fn, append([]Object(nil), args...))
+}
+
+// spawn runs fn with args on a new VM in its own goroutine and returns the
+// task's handle: a hash with wait, result and done builtins.
+func spawn(fn *Closure, args []Object) *Hash {
+	// A task is cancelled along with the code that started it.
+	ctx, cancel := context.WithCancelCause(currentContext)
+	t := &task{done: make(chan struct{}), cancel: cancel}
+	parent := RunningVM
+	runningTasks.Add(1)
+	go func() {
+		interpreter.Lock()
+		interpreter.held = true
+		defer func() {
+			interpreter.held = false
+			interpreter.Unlock()
+		}()
+		defer close(t.done)
+		defer runningTasks.Add(-1)
+		defer cancel(nil)
+
+		RunningVM, currentContext = nil, ctx
+		result, err := parent.Fork(fn, args)
+		if ctx.Err() != nil {
+			result = cancelled(ctx)
+		} else if err != nil {
+			result = &Error{Message: err.Error()}
+		}
+		if result == nil {
+			result = &Null{}
+		}
+		t.result = result
+	}()
+
+	return t.handle()
+}
+
+func (t *task) finished() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *task) handle() *Hash {
+	wait := func(args ...Object) Object {
+		if len(args) != 0 {
+			return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+		}
+		if !t.finished() {
+			if InParallel() {
+				// The task can't run until pmap is done.
+				return newError("Tasks can't be waited for in functions run by array.pmap")
+			}
+			ctx := currentContext
+			unlocked(func() {
+				select {
+				case <-t.done:
+				case <-ctx.Done():
+				}
+			})
+			if !t.finished() {
+				return cancelled(ctx)
+			}
+		}
+		return t.result
+	}
+	result := func(args ...Object) Object {
+		if len(args) != 0 {
+			return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+		}
+		if !t.finished() {
+			return &Null{}
+		}
+		return t.result
+	}
+	done := func(args ...Object) Object {
+		if len(args) != 0 {
+			return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+		}
+		return &Boolean{Value: t.finished()}
+	}
+	cancel := func(args ...Object) Object {
+		if len(args) != 0 {
+			return newError("Wrong number of arguments. Expected 0, got %d", len(args))
+		}
+		t.cancel(errTaskCancelled)
+		return &Null{}
+	}
+
+	return stringHash(map[string]Object{
+		"wait":   createBuiltin(wait, "task"),
+		"result": createBuiltin(result, "task"),
+		"done":   createBuiltin(done, "task"),
+		"cancel": createBuiltin(cancel, "task"),
+	})
+}
+
+// taskWait returns the wait builtin of a task handle, or nil when o isn't
+// one.
+func taskWait(o Object) *Builtin {
+	return taskMethod(o, "wait")
+}
+
+// taskMethod returns the builtin name of a task handle, or nil when o isn't
+// one.
+func taskMethod(o Object, name string) *Builtin {
+	handle, ok := o.(*Hash)
+	if !ok {
+		return nil
+	}
+	key := &String{Value: name}
+	pair, ok := handle.Pairs[key.HashKey()]
+	if !ok {
+		return nil
+	}
+	method, ok := pair.Value.(*Builtin)
+	if !ok || method.Class != "task" {
+		return nil
+	}
+	return method
+}
+
+// taskFunction returns the builtin task.name, which calls the builtin of
+// the same name of the task handle it is given.
+func taskFunction(name string) *Builtin {
+	return createBuiltin(func(args ...Object) Object {
+		if len(args) != 1 {
+			return newError("Wrong number of arguments. Expected 1, got %d", len(args))
+		}
+		method := taskMethod(args[0], name)
+		if method == nil {
+			return typeError("Argument 0 to `%s` must be a task, got %s", name, args[0].Type())
+		}
+		return method.Fn()
+	}, "task")
+}
@@ -0,0 +1,110 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the functions templates can call besides Go's
+// predefined ones such as len, index, printf, eq and and.
+var templateFuncs = map[string]interface{}{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	"join": func(items []interface{}, sep string) string {
+		parts := make([]string, len(items))
+		for i, item := range items {
+			if item != nil {
+				parts[i] = fmt.Sprint(item)
+			}
+		}
+		return strings.Join(parts, sep)
+	},
+}
+
+// renderTemplate renders source, a Go template, with data, after parsing
+// the templates of partials, which maps names to sources, so source can
+// include them with {{template "name" .}}. html selects html/template,
+// which escapes the values it inserts.
+func renderTemplate(name, source string, data Object, partials *Hash, html bool) Object {
+	var out bytes.Buffer
+	var err error
+	if html {
+		t := htmltemplate.New(name).Funcs(templateFuncs)
+		if t, err = t.Parse(source); err == nil {
+			if err = parsePartials(partials, func(name, source string) error {
+				_, err := t.New(name).Parse(source)
+				return err
+			}); err == nil {
+				err = t.Execute(&out, sqxObjectToNative(data))
+			}
+		}
+	} else {
+		t := template.New(name).Funcs(templateFuncs)
+		if t, err = t.Parse(source); err == nil {
+			if err = parsePartials(partials, func(name, source string) error {
+				_, err := t.New(name).Parse(source)
+				return err
+			}); err == nil {
+				err = t.Execute(&out, sqxObjectToNative(data))
+			}
+		}
+	}
+	if err != nil {
+		return newError("Template error: %s", err)
+	}
+	return &String{Value: out.String()}
+}
+
+func parsePartials(partials *Hash, parse func(name, source string) error) error {
+	if partials == nil {
+		return nil
+	}
+	for _, pair := range partials.Pairs {
+		name, ok := pair.Key.(*String)
+		source, ok2 := pair.Value.(*String)
+		if !ok || !ok2 {
+			return errors.New("partials must map names to template sources")
+		}
+		if err := parse(name.Value, source.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateBuiltin is tmpl.render and tmpl.html: source, data and optional
+// partials. fromFile makes the first argument a path to read the source
+// from instead.
+func templateBuiltin(fnName string, html, fromFile bool) BuiltinFunction {
+	return func(args ...Object) Object {
+		if len(args) != 2 && len(args) != 3 {
+			return newError("Wrong number of arguments. Expected 2 or 3, got %d", len(args))
+		}
+		source, ok := args[0].(*String)
+		if !ok {
+			return typeError("Argument 0 to `%s` must be STRING, got %s", fnName, args[0].Type())
+		}
+		var partials *Hash
+		if len(args) == 3 {
+			if partials, ok = args[2].(*Hash); !ok {
+				return typeError("Argument 2 to `%s` must be HASH, got %s", fnName, args[2].Type())
+			}
+		}
+		name := fnName
+		text := source.Value
+		if fromFile {
+			content, err := os.ReadFile(source.Value)
+			if err != nil {
+				return ioError("Could not read template %s: %s", source.Value, err)
+			}
+			name, text = source.Value, string(content)
+		}
+		return renderTemplate(name, text, args[1], partials, html)
+	}
+}
@@ -0,0 +1,89 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// Tuple is a fixed-size sequence written as (a, b). Unlike an array it
+// can't be changed at all, so a tuple of hashable values can key a hash or
+// be put in a set.
+type Tuple struct {
+	Elements []Object
+}
+
+func (t *Tuple) Type() ObjectType { return TUPLE_OBJ }
+func (t *Tuple) Inspect() string  { return Format(t) }
+
+// HashKey combines the keys of the elements. Elements that can't be hashed
+// only add their type; HashKeyOf refuses such tuples.
+func (t *Tuple) HashKey() HashKey {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, el := range t.Elements {
+		h.Write([]byte(el.Type()))
+		if hashable, ok := el.(Hashable); ok {
+			binary.LittleEndian.PutUint64(buf[:], hashable.HashKey().Value)
+			h.Write(buf[:])
+		}
+	}
+	return HashKey{Type: t.Type(), Value: h.Sum64()}
+}
+
+// HashKeyOf returns the key o is stored under in hashes and sets. It fails
+// for values that can't be hashed, including tuples holding one, and for
+// NaN, which isn't equal to itself and so could never be looked up.
+func HashKeyOf(o Object) (HashKey, bool) {
+	if f, ok := o.(*Float); ok && math.IsNaN(f.Value) {
+		return HashKey{}, false
+	}
+	if t, ok := o.(*Tuple); ok {
+		for _, el := range t.Elements {
+			if _, ok := HashKeyOf(el); !ok {
+				return HashKey{}, false
+			}
+		}
+	}
+	hashable, ok := o.(Hashable)
+	if !ok {
+		return HashKey{}, false
+	}
+	return hashable.HashKey(), true
+}
+
+// Unpack returns the n elements of a tuple or an array assigned by
+// `var (a, b) = value`.
+func Unpack(o Object, n int) ([]Object, error) {
+	var elements []Object
+	switch o := o.(type) {
+	case *Tuple:
+		elements = o.Elements
+	case *Array:
+		elements = o.Elements
+	default:
+		return nil, fmt.Errorf("cannot unpack %s, expected TUPLE or ARRAY", o.Type())
+	}
+	if len(elements) != n {
+		return nil, fmt.Errorf("cannot unpack %d values into %d variables", len(elements), n)
+	}
+	return elements, nil
+}
+
+// tupleOf builds a tuple from the elements of an array, a set or a tuple,
+// the argument of tuple.new.
+func tupleOf(o Object) Object {
+	var items []Object
+	switch o := o.(type) {
+	case *Array:
+		items = o.Elements
+	case *Set:
+		items = o.Items()
+	case *Tuple:
+		return o
+	default:
+		return typeError("Argument 0 to `new` must be ARRAY, SET or TUPLE, got %s", o.Type())
+	}
+	return &Tuple{Elements: append([]Object(nil), items...)}
+}
@@ -0,0 +1,74 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+)
+
+// WaitGroup lets a task wait for a number of others to finish, like Go's
+// sync.WaitGroup.
+type WaitGroup struct {
+	mu sync.Mutex
+	n  int64
+	// zero is closed whenever the count is zero.
+	zero chan struct{}
+}
+
+func NewWaitGroup() *WaitGroup {
+	zero := make(chan struct{})
+	close(zero)
+	return &WaitGroup{zero: zero}
+}
+
+func (wg *WaitGroup) Type() ObjectType { return WAITGROUP_OBJ }
+func (wg *WaitGroup) Inspect() string {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	return fmt.Sprintf("WaitGroup[%d]", wg.n)
+}
+
+// add adds delta, which may be negative, to the count.
+func (wg *WaitGroup) add(delta int64) *Error {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	n := wg.n + delta
+	if n < 0 {
+		return newError("Negative wait group count")
+	}
+	switch {
+	case wg.n == 0 && n > 0:
+		wg.zero = make(chan struct{})
+	case wg.n > 0 && n == 0:
+		close(wg.zero)
+	}
+	wg.n = n
+	return nil
+}
+
+// wait waits for the count to drop to zero while other tasks run.
+func (wg *WaitGroup) wait() *Error {
+	wg.mu.Lock()
+	zero := wg.zero
+	wg.mu.Unlock()
+	select {
+	case <-zero:
+		return nil
+	default:
+	}
+	if !othersCanRun() {
+		return errDeadlock("sync.wait")
+	}
+	ctx := currentContext
+	done := false
+	unlocked(func() {
+		select {
+		case <-zero:
+			done = true
+		case <-ctx.Done():
+		}
+	})
+	if !done {
+		return cancelled(ctx)
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package parser
 
 import (
 	"fmt"
+	"iter"
+	"reflect"
 	"squ1d++/ast"
 	"squ1d++/lexer"
 	"squ1d++/token"
@@ -117,8 +119,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.NULL, p.parseNull)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	p.registerPrefix(token.ATTEMPT, p.parseAttemptExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.SHIFT_RIGHT, p.parseFunctionLiteral)
+	p.registerPrefix(token.ASYNC, p.parseAsyncFunctionLiteral)
+	p.registerPrefix(token.AWAIT, p.parseAwaitExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.DOT, p.parseDotExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
@@ -160,14 +166,35 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseIndexExpression parses left[index], or the slice left[start:end],
+// where either bound can be left out.
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+
+	var index ast.Expression
+	if !p.curTokenIs(token.COLON) {
+		index = p.parseExpression(LOWEST)
+		if !p.peekTokenIs(token.COLON) {
+			if !p.expectPeek(token.RBRACKET) {
+				return nil
+			}
+			return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+		}
+		p.nextToken()
+	}
+
+	slice := &ast.SliceExpression{Token: tok, Left: left, Start: index}
+	if p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		return slice
+	}
+	p.nextToken()
+	slice.End = p.parseExpression(LOWEST)
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
-	return exp
+	return slice
 }
 
 func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
@@ -189,14 +216,31 @@ func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	tok := p.curToken
+	if p.peekTokenIs(token.RPAREN) {
+		// () is the empty tuple.
+		p.nextToken()
+		return &ast.TupleLiteral{Token: tok, Elements: []ast.Expression{}}
+	}
+
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
+	if p.peekTokenIs(token.COMMA) {
+		return p.parseTupleLiteral(tok, exp)
+	}
+
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
+	switch e := exp.(type) {
+	case *ast.InfixExpression:
+		e.Grouped = true
+	case *ast.PrefixExpression:
+		e.Grouped = true
+	}
 	return exp
 }
 
@@ -225,6 +269,105 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// parseMatchExpression parses
+//
+//	match (subject) { case 1, 2 { ... } case [a, b] if (a > b) { ... } el { ... } }
+func (p *Parser) parseAttemptExpression() ast.Expression {
+	expression := &ast.AttemptExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Body = p.parseBlockStatement()
+
+	if !p.expectPeek(token.RESCUE) {
+		return nil
+	}
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Rescue = p.parseBlockStatement()
+
+	return expression
+}
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	for !p.curTokenIs(token.RBRACE) {
+		switch {
+		case p.curTokenIs(token.CASE):
+			arm := &ast.MatchArm{Token: p.curToken}
+			p.nextToken()
+			arm.Patterns = append(arm.Patterns, p.parseExpression(LOWEST))
+			for p.peekTokenIs(token.COMMA) {
+				p.nextToken()
+				p.nextToken()
+				arm.Patterns = append(arm.Patterns, p.parseExpression(LOWEST))
+			}
+			if p.peekTokenIs(token.IF) {
+				p.nextToken()
+				if !p.expectPeek(token.LPAREN) {
+					return nil
+				}
+				p.nextToken()
+				arm.Guard = p.parseExpression(LOWEST)
+				if !p.expectPeek(token.RPAREN) {
+					return nil
+				}
+			}
+			if !p.expectPeek(token.LBRACE) {
+				return nil
+			}
+			arm.Body = p.parseBlockStatement()
+			expression.Arms = append(expression.Arms, arm)
+
+		case p.curTokenIs(token.ELSE) && expression.Default == nil:
+			if !p.expectPeek(token.LBRACE) {
+				return nil
+			}
+			expression.Default = p.parseBlockStatement()
+
+		default:
+			context := p.getErrorContext(p.curToken.Line, p.curToken.Column)
+			msg := fmt.Sprintf("line %d, column %d: expected case or el in match, got %s instead\n%s",
+				p.curToken.Line, p.curToken.Column, p.curToken.Type, context)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		p.nextToken()
+	}
+
+	return expression
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -400,9 +543,24 @@ func (p *Parser) parseNull() ast.Expression {
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
+	if p.curToken.Literal == "spawn" && p.peekTokenIs(token.FUNCTION) {
+		return p.parseSpawnExpression()
+	}
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseSpawnExpression parses `spawn def() { ... }`. spawn stays an
+// identifier otherwise, so spawn(fn, args...) is still a call.
+func (p *Parser) parseSpawnExpression() ast.Expression {
+	expression := &ast.SpawnExpression{Token: p.curToken}
+
+	p.nextToken()
+
+	expression.Function = p.parseExpression(PREFIX)
+
+	return expression
+}
+
 func (p *Parser) Errors() []string {
 	return p.errors
 }
@@ -415,16 +573,34 @@ func (p *Parser) nextToken() {
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
-	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
-		}
-		p.nextToken()
+	for stmt := range p.Statements() {
+		program.Statements = append(program.Statements, stmt)
 	}
 	return program
 }
 
+// Statements parses the input one top-level statement at a time and yields
+// each as soon as it is parsed, so tools can work through large files as
+// they go or stop early. Statements that fail to parse are skipped; Errors
+// reports why, as it does for ParseProgram. Breaking out of the loop leaves
+// the parser at the next statement, where a new call carries on.
+func (p *Parser) Statements() iter.Seq[ast.Statement] {
+	return func(yield func(ast.Statement) bool) {
+		for p.curToken.Type != token.EOF {
+			stmt := p.parseStatement()
+			p.nextToken()
+			// The parse functions return typed nils for statements they
+			// couldn't parse.
+			if stmt == nil || reflect.ValueOf(stmt).IsNil() {
+				continue
+			}
+			if !yield(stmt) {
+				return
+			}
+		}
+	}
+}
+
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
@@ -433,6 +609,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.parseTypeAnnotation(&stmt.Name.Type) {
+		return nil
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -459,6 +638,36 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// parseDestructureStatement parses `var (a, b) = value`.
+func (p *Parser) parseDestructureStatement() ast.Statement {
+	stmt := &ast.DestructureStatement{Token: p.curToken}
+	p.nextToken()
+
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
@@ -493,6 +702,9 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	lit.Parameters = p.parseFunctionParameters()
+	if lit.Parameters == nil || !p.parseTypeAnnotation(&lit.ReturnType) {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -503,6 +715,48 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
+// parseAsyncFunctionLiteral parses `async def(params) { ... }` and
+// `async >> (params) { ... }`.
+func (p *Parser) parseAsyncFunctionLiteral() ast.Expression {
+	if !p.peekTokenIs(token.FUNCTION) && !p.peekTokenIs(token.SHIFT_RIGHT) {
+		context := p.getErrorContext(p.peekToken.Line, p.peekToken.Column)
+		msg := fmt.Sprintf("line %d, column %d: expected a function after async, got %s instead\n%s",
+			p.peekToken.Line, p.peekToken.Column, p.peekToken.Type, context)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	p.nextToken()
+
+	lit, ok := p.parseFunctionLiteral().(*ast.FunctionLiteral)
+	if !ok {
+		return nil
+	}
+	lit.Async = true
+	return lit
+}
+
+// parseAsyncFunctionDefinitionStatement parses `async name >> (params) { ... }`.
+func (p *Parser) parseAsyncFunctionDefinitionStatement() ast.Statement {
+	p.nextToken()
+
+	stmt, ok := p.parseFunctionDefinitionStatement().(*ast.LetStatement)
+	if !ok {
+		return nil
+	}
+	stmt.Value.(*ast.FunctionLiteral).Async = true
+	return stmt
+}
+
+func (p *Parser) parseAwaitExpression() ast.Expression {
+	expression := &ast.AwaitExpression{Token: p.curToken}
+
+	p.nextToken()
+
+	expression.Value = p.parseExpression(PREFIX)
+
+	return expression
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	identifiers := []*ast.Identifier{}
 
@@ -515,12 +769,18 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
+	if !p.parseTypeAnnotation(&ident.Type) {
+		return nil
+	}
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
+		if !p.parseTypeAnnotation(&ident.Type) {
+			return nil
+		}
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -530,6 +790,26 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	return identifiers
 }
 
+// parseTypeAnnotation parses an optional `: type` after the current token
+// into *annotation. It reports false when the colon isn't followed by a
+// type name.
+func (p *Parser) parseTypeAnnotation(annotation **ast.TypeAnnotation) bool {
+	if !p.peekTokenIs(token.COLON) {
+		return true
+	}
+	p.nextToken()
+
+	// null is a keyword, but also the type of null.
+	if !p.peekTokenIs(token.NULL) && !p.expectPeek(token.IDENT) {
+		return false
+	}
+	if p.peekTokenIs(token.NULL) {
+		p.nextToken()
+	}
+	*annotation = &ast.TypeAnnotation{Token: p.curToken, Name: p.curToken.Literal}
+	return true
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
@@ -605,6 +885,10 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
 		key := p.parseExpression(LOWEST)
+		if len(hash.Pairs) == 0 && (p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE)) {
+			// A first element without a value makes it a set.
+			return p.parseSetLiteral(hash.Token, key)
+		}
 		if ident, ok := key.(*ast.Identifier); ok {
 			key = &ast.StringLiteral{Token: ident.Token, Value: ident.Value}
 		}
@@ -614,6 +898,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -624,6 +909,42 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+// parseSetLiteral parses the rest of a set literal whose first element was
+// parsed as the first key of a hash.
+func (p *Parser) parseSetLiteral(tok token.Token, first ast.Expression) ast.Expression {
+	set := &ast.SetLiteral{Token: tok, Elements: []ast.Expression{first}}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if p.peekTokenIs(token.RBRACE) {
+			break
+		}
+		p.nextToken()
+		set.Elements = append(set.Elements, p.parseExpression(LOWEST))
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return set
+}
+
+// parseTupleLiteral parses the rest of a tuple literal whose first element
+// was parsed as a parenthesized expression.
+func (p *Parser) parseTupleLiteral(tok token.Token, first ast.Expression) ast.Expression {
+	tuple := &ast.TupleLiteral{Token: tok, Elements: []ast.Expression{first}}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if p.peekTokenIs(token.RPAREN) {
+			break
+		}
+		p.nextToken()
+		tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return tuple
+}
+
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 
@@ -653,8 +974,29 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseFunctionDefinitionStatement()
 	}
 
+	if p.curToken.Type == token.IDENT && p.curToken.Literal == "bench" && (p.peekTokenIs(token.STRING) || p.peekTokenIs(token.BACKTICK)) {
+		return p.parseBenchStatement()
+	}
+
+	if p.curToken.Type == token.IDENT && p.curToken.Literal == "class" && p.peekTokenIs(token.IDENT) {
+		return p.parseClassStatement()
+	}
+
+	if p.curToken.Type == token.IDENT && p.curToken.Literal == "export" && p.peekToken.Line == p.curToken.Line &&
+		(p.peekTokenIs(token.IDENT) || p.peekTokenIs(token.LET) || p.peekTokenIs(token.UNBLOCK) || p.peekTokenIs(token.ASYNC)) {
+		return p.parseExportStatement()
+	}
+
 	switch p.curToken.Type {
+	case token.ASYNC:
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseAsyncFunctionDefinitionStatement()
+		}
+		return p.parseExpressionStatement()
 	case token.LET:
+		if p.peekTokenIs(token.LPAREN) {
+			return p.parseDestructureStatement()
+		}
 		return p.parseLetStatement()
 	case token.UNBLOCK:
 		return p.parseUnblockLetStatement()
@@ -677,6 +1019,116 @@ func (p *Parser) parseStatement() ast.Statement {
 	}
 }
 
+// parseBenchStatement parses `bench "name" { ... }`. bench is only a keyword
+// when a string follows it, so it stays usable as a variable name.
+func (p *Parser) parseBenchStatement() ast.Statement {
+	stmt := &ast.BenchStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Name = p.curToken.Literal
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	return stmt
+}
+
+// parseExportStatement parses `export` followed by a definition or by a
+// comma-separated list of names. export is only a keyword when one of those
+// follows it on the same line, so it stays usable as a variable name.
+func (p *Parser) parseExportStatement() ast.Statement {
+	stmt := &ast.ExportStatement{Token: p.curToken}
+	p.nextToken()
+
+	if p.curTokenIs(token.LET) || p.curTokenIs(token.UNBLOCK) ||
+		(p.curTokenIs(token.ASYNC) && p.peekTokenIs(token.IDENT)) ||
+		(p.curTokenIs(token.IDENT) && p.peekTokenIs(token.SHIFT_RIGHT)) ||
+		(p.curTokenIs(token.IDENT) && p.curToken.Literal == "class" && p.peekTokenIs(token.IDENT)) {
+		stmt.Statement = p.parseStatement()
+		switch s := stmt.Statement.(type) {
+		case *ast.LetStatement:
+			stmt.Names = []*ast.Identifier{s.Name}
+		case *ast.DestructureStatement:
+			stmt.Names = s.Names
+		case *ast.ClassStatement:
+			stmt.Names = []*ast.Identifier{s.Name}
+		default:
+			return nil
+		}
+		return stmt
+	}
+
+	for {
+		if !p.curTokenIs(token.IDENT) {
+			context := p.getErrorContext(p.curToken.Line, p.curToken.Column)
+			msg := fmt.Sprintf("line %d, column %d: expected a definition or a name to export, got %s instead\n%s",
+				p.curToken.Line, p.curToken.Column, p.curToken.Type, context)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+		p.nextToken()
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseClassStatement parses `class Name { def method(params) { ... } ... }`.
+// Like bench, class is only a keyword when a name follows it.
+func (p *Parser) parseClassStatement() ast.Statement {
+	stmt := &ast.ClassStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	for !p.curTokenIs(token.RBRACE) {
+		if p.curTokenIs(token.SEMICOLON) {
+			p.nextToken()
+			continue
+		}
+		if !p.curTokenIs(token.FUNCTION) {
+			context := p.getErrorContext(p.curToken.Line, p.curToken.Column)
+			msg := fmt.Sprintf("line %d, column %d: expected a method definition in class %s, got %s instead\n%s",
+				p.curToken.Line, p.curToken.Column, stmt.Name.Value, p.curToken.Type, context)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		defToken := p.curToken
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		name := p.curToken.Literal
+
+		method, ok := p.parseFunctionLiteral().(*ast.FunctionLiteral)
+		if !ok {
+			return nil
+		}
+		method.Token = defToken
+		method.Name = name
+		stmt.Methods = append(stmt.Methods, method)
+		p.nextToken()
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseSuppressStatement() ast.Statement {
 	stmt := &ast.SuppressStatement{Token: p.curToken}
 
@@ -738,7 +1190,8 @@ func (p *Parser) parseContinueStatement() ast.Statement {
 }
 
 func (p *Parser) parseFunctionDefinitionStatement() ast.Statement {
-	name := p.curToken.Literal
+	nameToken := p.curToken
+	name := nameToken.Literal
 
 	if !p.expectPeek(token.SHIFT_RIGHT) {
 		return nil
@@ -758,7 +1211,7 @@ func (p *Parser) parseFunctionDefinitionStatement() ast.Statement {
 	fn.Name = name
 
 	stmt := &ast.LetStatement{Token: p.curToken}
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: name}
+	stmt.Name = &ast.Identifier{Token: nameToken, Value: name}
 	stmt.Value = fn
 
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -776,6 +1229,9 @@ func (p *Parser) parseForStatement() ast.Statement {
 	}
 
 	p.nextToken()
+	if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA)) {
+		return p.parseForInStatement(stmt.Token)
+	}
 	if !p.curTokenIs(token.SEMICOLON) {
 		if p.curTokenIs(token.LET) {
 			stmt.Init = p.parseLetStatement()
@@ -795,28 +1251,58 @@ func (p *Parser) parseForStatement() ast.Statement {
 		return nil
 	}
 
+	// The condition and the update can be left out, as in `for (;;)`.
 	p.nextToken()
 	if !p.curTokenIs(token.SEMICOLON) {
 		stmt.Condition = p.parseExpression(LOWEST)
-	}
-
-	if !p.expectPeek(token.SEMICOLON) {
-		return nil
+		if !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
 	}
 
 	p.nextToken()
 	if !p.curTokenIs(token.RPAREN) {
 		stmt.Update = p.parseExpression(LOWEST)
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
-	if !p.expectPeek(token.LBRACE) {
+	stmt.Body = p.parseBlockStatement()
+
+	return stmt
+}
+
+// parseForInStatement parses the rest of `for (x in collection) { ... }` or
+// `for (key, value in collection) { ... }` from the first name.
+func (p *Parser) parseForInStatement(tok token.Token) ast.Statement {
+	stmt := &ast.ForInStatement{Token: tok}
+
+	stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+
+	if !p.expectPeek(token.IN) {
 		return nil
 	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
 
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
 	stmt.Body = p.parseBlockStatement()
 
 	return stmt
@@ -837,6 +1323,11 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	}
 	leftExp := prefix()
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+		// A ( or [ starting a new line begins the next statement rather
+		// than calling or indexing the end of this one.
+		if (p.peekTokenIs(token.LPAREN) || p.peekTokenIs(token.LBRACKET)) && p.peekToken.Line > p.curToken.Line {
+			return leftExp
+		}
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"squ1d++/ast"
 	"squ1d++/lexer"
+	"strings"
 	"testing"
 )
 
@@ -715,6 +716,116 @@ func TestParsingEmptyHashLiteral(t *testing.T) {
 	}
 }
 
+func TestParsingSetLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{1}", "{1}"},
+		{"{a, b + 1, \"c\"}", "{a, (b + 1), c}"},
+		{"{1, 2,}", "{1, 2}"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		set, ok := stmt.Expression.(*ast.SetLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.SetLiteral. Got %T", stmt.Expression)
+		}
+		if set.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, set.String())
+		}
+	}
+
+	p := New(lexer.New("{1, 2: 3}"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error mixing set elements and hash pairs")
+	}
+}
+
+func TestNewLineStartsStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"f()\n[1, 2]", []string{"f()", "[1, 2]"}},
+		{"var a = b\n(1, 2)", []string{"var a = b;", "(1, 2)"}},
+		{"f(1,\n  [2])[0]", []string{"(f(1, [2])[0])"}},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != len(tt.expected) {
+			t.Fatalf("%q: expected %d statements, got %d", tt.input, len(tt.expected), len(program.Statements))
+		}
+		for i, stmt := range program.Statements {
+			if stmt.String() != tt.expected[i] {
+				t.Errorf("%q: expected statement %d to be %q, got %q", tt.input, i, tt.expected[i], stmt.String())
+			}
+		}
+	}
+}
+
+func TestParsingTupleLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"()", "()"},
+		{"(1,)", "(1,)"},
+		{"(a, b + 1, \"c\")", "(a, (b + 1), c)"},
+		{"(1, 2,)", "(1, 2)"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		tuple, ok := stmt.Expression.(*ast.TupleLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.TupleLiteral. Got %T", stmt.Expression)
+		}
+		if tuple.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, tuple.String())
+		}
+	}
+
+	p := New(lexer.New("(1)"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if _, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral); !ok {
+		t.Errorf("expected (1) to stay a grouped expression")
+	}
+}
+
+func TestDestructureStatements(t *testing.T) {
+	p := New(lexer.New("var (a, b) = f(1);"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt, ok := program.Statements[0].(*ast.DestructureStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.DestructureStatement. Got %T", program.Statements[0])
+	}
+	if len(stmt.Names) != 2 || stmt.Names[0].Value != "a" || stmt.Names[1].Value != "b" {
+		t.Errorf("expected the names a and b, got %v", stmt.Names)
+	}
+	if stmt.String() != "var (a, b) = f(1);" {
+		t.Errorf("expected %q, got %q", "var (a, b) = f(1);", stmt.String())
+	}
+
+	for _, input := range []string{"var () = x", "var (a, 1) = x", "var (a, b) x"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected a parse error", input)
+		}
+	}
+}
+
 func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 	input := `{"one": 0 + 1, "two": 10 - 8, "three": 15 / 5}`
 	l := lexer.New(input)
@@ -865,6 +976,41 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[1:3]", "(a[1:3])"},
+		{"a[:n - 1]", "(a[:(n - 1)])"},
+		{"a[-2:]", "(a[(-2):])"},
+		{"a[:]", "(a[:])"},
+		{"a[1:][0]", "((a[1:])[0])"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.SliceExpression); !ok && tt.input != "a[1:][0]" {
+			t.Fatalf("%q: expected *ast.SliceExpression, got %T", tt.input, stmt.Expression)
+		}
+		if stmt.Expression.String() != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, stmt.Expression.String())
+		}
+	}
+
+	for _, input := range []string{"a[1:2:3]", "a[1:"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
 func testIdentifier(t *testing.T, exp ast.Expression, value string) bool {
 	ident, ok := exp.(*ast.Identifier)
 	if !ok {
@@ -1044,3 +1190,411 @@ func TestVariableNamesWithNumbers(t *testing.T) {
 		}
 	}
 }
+
+func TestBenchStatement(t *testing.T) {
+	l := lexer.New("bench \"sum\" { var x = 1 + 2 }\nvar bench = 1\nbench + 1")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. Got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.BenchStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.BenchStatement. Got %T", program.Statements[0])
+	}
+	if stmt.Name != "sum" {
+		t.Errorf("stmt.Name is not %q. Got %q", "sum", stmt.Name)
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("stmt.Body.Statements does not contain 1 statement. Got %d", len(stmt.Body.Statements))
+	}
+
+	// bench is only a keyword in front of a string.
+	if !testLetStatement(t, program.Statements[1], "bench") {
+		return
+	}
+	if _, ok := program.Statements[2].(*ast.ExpressionStatement); !ok {
+		t.Fatalf("program.Statements[2] is not *ast.ExpressionStatement. Got %T", program.Statements[2])
+	}
+}
+
+func TestAsyncAndAwait(t *testing.T) {
+	l := lexer.New("var f = async def(x) { x }\nasync g >> (y) { await f(y) }\nawait a.b + 1")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. Got %d", len(program.Statements))
+	}
+
+	for i, name := range []string{"f", "g"} {
+		stmt, ok := program.Statements[i].(*ast.LetStatement)
+		if !ok || stmt.Name.Value != name {
+			t.Fatalf("program.Statements[%d] doesn't define %s. Got %s", i, name, program.Statements[i])
+		}
+		fn, ok := stmt.Value.(*ast.FunctionLiteral)
+		if !ok || !fn.Async {
+			t.Errorf("%s is not an async function literal: %s", name, stmt)
+		}
+	}
+
+	expected := "((await (a.b)) + 1)"
+	if got := program.Statements[2].String(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSpawnExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"spawn def() { x }", "(spawn def() x)"},
+		{"(spawn def(a) { a }).wait()", "((spawn def(a) a).wait)()"},
+		{"spawn(f, 1)", "spawn(f, 1)"},
+		{"var spawn = 1", "var spawn = 1;"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	p := New(lexer.New("var a = 1;\nio.echo(a)\nvar b = a * 2"))
+
+	var got []string
+	for stmt := range p.Statements() {
+		got = append(got, stmt.String())
+		break
+	}
+	// A new loop carries on after the statement the last one stopped at.
+	for stmt := range p.Statements() {
+		got = append(got, stmt.String())
+	}
+	checkParserErrors(t, p)
+
+	expected := []string{"var a = 1;", "(io.echo)(a)", "var b = (a * 2);"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected statements %q, got %q", expected, got)
+	}
+
+	// Statements that fail to parse are left out and reported by Errors.
+	p = New(lexer.New("var = 2"))
+	for stmt := range p.Statements() {
+		if _, ok := stmt.(*ast.LetStatement); ok {
+			t.Errorf("expected the broken statement to be skipped, got %q", stmt.String())
+		}
+	}
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected parse errors")
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	l := lexer.New("var x: int = 5\nvar f = def(a: string, b): null { a }\ng >> (n: any): bool { true }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. Got %d", len(program.Statements))
+	}
+	if got := program.Statements[0].String(); got != "var x: int = 5;" {
+		t.Errorf("expected %q, got %q", "var x: int = 5;", got)
+	}
+	expected := []string{"def<f>(a: string, b): null a", ">><g>(n: any): bool true"}
+	for i, want := range expected {
+		stmt := program.Statements[i+1].(*ast.LetStatement)
+		if got := stmt.Value.String(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	for _, input := range []string{"var x: = 5", "def(a:) { a }", "def(a): { a }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	input := `match (x) {
+	case 1, -2 { "a" }
+	case "s" { b }
+	el { c }
+}`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. Got %d", len(program.Statements))
+	}
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	match, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not *ast.MatchExpression. Got %T", stmt.Expression)
+	}
+	if len(match.Arms) != 2 || len(match.Arms[0].Patterns) != 2 || match.Default == nil {
+		t.Fatalf("wrong arms: %s", match.String())
+	}
+	want := `match(x) { case 1, (-2) a case s b el c }`
+	if got := match.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	for _, input := range []string{"match x { case 1 { } }", "match (x) { 1 { } }", "match (x) { case 1 { }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
+func TestMatchPatterns(t *testing.T) {
+	input := `match (v) { case {type: "user", name: n} if (n != "") { n } case [a, _], (b, c) { a } }`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	match := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	if len(match.Arms) != 2 {
+		t.Fatalf("expected 2 arms, got %d", len(match.Arms))
+	}
+	if _, ok := match.Arms[0].Patterns[0].(*ast.HashLiteral); !ok {
+		t.Errorf("expected a hash pattern, got %T", match.Arms[0].Patterns[0])
+	}
+	if match.Arms[0].Guard == nil || match.Arms[0].Guard.String() != `(n != )` {
+		t.Errorf("wrong guard: %v", match.Arms[0].Guard)
+	}
+	if match.Arms[1].Guard != nil || len(match.Arms[1].Patterns) != 2 {
+		t.Errorf("wrong second arm: %s", match.Arms[1].String())
+	}
+
+	for _, input := range []string{"match (v) { case a if a { a } }", "match (v) { case a if (a { a } }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input                   string
+		init, condition, update string
+	}{
+		{"for (var i = 0; i < 3; i = i + 1) { i }", "var i = 0;", "(i < 3)", "(i = (i + 1))"},
+		{"for (i = 0; i < 3; i = i + 1) { }", "(i = 0)", "(i < 3)", "(i = (i + 1))"},
+		{"for (; i < 3; ) { }", "", "(i < 3)", ""},
+		{"for (;; i = i + 1) { }", "", "", "(i = (i + 1))"},
+		{"for (;;) { break }", "", "", ""},
+	}
+
+	str := func(node interface{ String() string }, isNil bool) string {
+		if isNil {
+			return ""
+		}
+		return node.String()
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("%q: expected *ast.ForStatement, got %T", tt.input, program.Statements[0])
+		}
+		if got := str(stmt.Init, stmt.Init == nil); got != tt.init {
+			t.Errorf("%q: init is %q, expected %q", tt.input, got, tt.init)
+		}
+		if got := str(stmt.Condition, stmt.Condition == nil); got != tt.condition {
+			t.Errorf("%q: condition is %q, expected %q", tt.input, got, tt.condition)
+		}
+		if got := str(stmt.Update, stmt.Update == nil); got != tt.update {
+			t.Errorf("%q: update is %q, expected %q", tt.input, got, tt.update)
+		}
+		if stmt.Body == nil {
+			t.Errorf("%q: no body", tt.input)
+		}
+	}
+
+	for _, input := range []string{"for (i = 0; i < 3 { }", "for (;; i = i + 1 { }", "for (i = 0) { }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
+func TestForInStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		names    []string
+		iterable string
+	}{
+		{"for (x in xs) { x }", []string{"x"}, "xs"},
+		{"for (k, v in h) { k }", []string{"k", "v"}, "h"},
+		{"for (c in \"abc\") { }", []string{"c"}, "abc"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ForInStatement)
+		if !ok {
+			t.Fatalf("%q: expected *ast.ForInStatement, got %T", tt.input, program.Statements[0])
+		}
+		if len(stmt.Names) != len(tt.names) {
+			t.Fatalf("%q: expected %d names, got %d", tt.input, len(tt.names), len(stmt.Names))
+		}
+		for i, name := range tt.names {
+			if stmt.Names[i].Value != name {
+				t.Errorf("%q: name %d is %q, expected %q", tt.input, i, stmt.Names[i].Value, name)
+			}
+		}
+		if stmt.Iterable.String() != tt.iterable {
+			t.Errorf("%q: iterable is %q, expected %q", tt.input, stmt.Iterable.String(), tt.iterable)
+		}
+	}
+
+	for _, input := range []string{"for (a, b, c in xs) { }", "for (a, in xs) { }", "for (a in xs { }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
+func TestAttemptExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"attempt { x / y } rescue (e) { e.message }", "attempt (x / y) rescue (e) (e.message)"},
+		{"var r = attempt { f() } rescue { 0 }", "var r = attempt f() rescue 0;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	for _, input := range []string{"attempt { 1 }", "attempt { 1 } rescue (1) { }", "attempt { 1 } rescue (e { }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
+func TestClassStatement(t *testing.T) {
+	input := `class Point {
+	def init(x, y) { self.x = x }
+	def len(): float { 1.5 }
+}`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ClassStatement)
+	if !ok {
+		t.Fatalf("expected *ast.ClassStatement, got %T", program.Statements[0])
+	}
+	if stmt.Name.Value != "Point" || len(stmt.Methods) != 2 {
+		t.Fatalf("expected class Point with 2 methods, got %s with %d", stmt.Name.Value, len(stmt.Methods))
+	}
+	expected := "class Point { def init(x, y) ((self.x) = x) def len(): float 1.5 }"
+	if stmt.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stmt.String())
+	}
+
+	// class is only a keyword before a name.
+	p = New(lexer.New("var class = 1; class + 1"))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	for _, input := range []string{"class A { var x = 1 }", "class A { def (x) { } }", "class A { def f() { }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
+func TestExportStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		names    string
+		expected string
+	}{
+		{"export var x = 1", "x", "export var x = 1;"},
+		{"export f >> (a) { a }", "f", ""},
+		{"export class P { def f() { 1 } }", "P", "export class P { def f() 1 }"},
+		{"export var (a, b) = (1, 2)", "a b", "export var (a, b) = (1, 2);"},
+		{"export f, g; x", "f g", "export f, g"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExportStatement)
+		if !ok {
+			t.Fatalf("%q: expected *ast.ExportStatement, got %T", tt.input, program.Statements[0])
+		}
+		var names []string
+		for _, name := range stmt.Names {
+			names = append(names, name.Value)
+		}
+		if strings.Join(names, " ") != tt.names {
+			t.Errorf("%q: expected names %q, got %q", tt.input, tt.names, names)
+		}
+		if tt.expected != "" && stmt.String() != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, stmt.String())
+		}
+	}
+
+	// export is only a keyword before a definition or a name on its line.
+	p := New(lexer.New("var export = 1; export + 1\nexport\nx"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 4 {
+		t.Errorf("expected 4 statements, got %d", len(program.Statements))
+	}
+
+	for _, input := range []string{"export f,", "export async 1", "export a, 2"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package pkg
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Run executes a `squ1dcc pkg` subcommand and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	manager, registry := GlobalManager.Out, GlobalRegistry.Out
+	GlobalManager.Out, GlobalRegistry.Out = stdout, stdout
+	defer func() { GlobalManager.Out, GlobalRegistry.Out = manager, registry }()
+
+	if len(args) == 0 {
+		printUsage(stdout)
+		return 0
+	}
+
+	switch strings.ToLower(strings.TrimSpace(args[0])) {
+	case "help", "-h", "--help":
+		printUsage(stdout)
+		return 0
+	case "install":
+		return runInstall(args[1:], stderr)
+	case "list":
+		return runList(stdout, stderr)
+	case "remove":
+		return runRemove(args[1:], stderr)
+	case "search":
+		return runSearch(args[1:], stdout, stderr)
+	case "info":
+		return runInfo(args[1:], stdout, stderr)
+	case "outdated":
+		return runOutdated(stdout, stderr)
+	case "update":
+		return runUpdate(args[1:], stdout, stderr)
+	case "publish":
+		return runPublish(args[1:], stderr)
+	case "migrate":
+		return runMigrate(stdout)
+	default:
+		fmt.Fprintf(stderr, "Unknown pkg command %q\n\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+}
+
+func runInstall(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("pkg install", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	version := fs.String("version", "", "Version constraint the package must satisfy, e.g. ^1.2")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "pkg install requires at least one source")
+		return 2
+	}
+
+	status := 0
+	for _, source := range fs.Args() {
+		if _, err := GlobalManager.InstallPackage(source, *version); err != nil {
+			fmt.Fprintf(stderr, "pkg install %s failed: %v\n", source, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func runList(stdout, stderr io.Writer) int {
+	packages, err := GlobalManager.ListPackages()
+	if err != nil {
+		fmt.Fprintf(stderr, "pkg list failed: %v\n", err)
+		return 1
+	}
+
+	for _, p := range packages {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\n", p.Name, p.Version, p.Description)
+	}
+	return 0
+}
+
+func runRemove(args []string, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "pkg remove requires a package name")
+		return 2
+	}
+
+	status := 0
+	for _, name := range args {
+		if err := GlobalManager.RemovePackage(name); err != nil {
+			fmt.Fprintf(stderr, "pkg remove %s failed: %v\n", name, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func runMigrate(stdout io.Writer) int {
+	if migrated := GlobalManager.MigrateLegacyPackages(); len(migrated) == 0 {
+		fmt.Fprintln(stdout, "No packages to migrate")
+	}
+	return 0
+}
+
+func runSearch(args []string, stdout, stderr io.Writer) int {
+	entries, err := GlobalRegistry.Search(strings.Join(args, " "))
+	if err != nil {
+		fmt.Fprintf(stderr, "pkg search failed: %v\n", err)
+		return 1
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(stdout, "No packages found")
+		return 0
+	}
+	for _, e := range entries {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\n", e.Name, e.Version, e.Description)
+	}
+	return 0
+}
+
+func runInfo(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "pkg info requires exactly one package name")
+		return 2
+	}
+
+	e, err := GlobalRegistry.Info(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "pkg info failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "%s %s\n", e.Name, e.Version)
+	if e.Description != "" {
+		fmt.Fprintf(stdout, "  %s\n", e.Description)
+	}
+	fmt.Fprintf(stdout, "  source:   %s\n", e.Source)
+	if len(e.Versions) > 0 {
+		fmt.Fprintf(stdout, "  versions: %s\n", strings.Join(e.Versions, ", "))
+	}
+	if e.Author != "" {
+		fmt.Fprintf(stdout, "  author:   %s\n", e.Author)
+	}
+	if e.Homepage != "" {
+		fmt.Fprintf(stdout, "  homepage: %s\n", e.Homepage)
+	}
+	if e.License != "" {
+		fmt.Fprintf(stdout, "  license:  %s\n", e.License)
+	}
+	if len(e.Keywords) > 0 {
+		fmt.Fprintf(stdout, "  keywords: %s\n", strings.Join(e.Keywords, ", "))
+	}
+	return 0
+}
+
+func runOutdated(stdout, stderr io.Writer) int {
+	entries, err := GlobalManager.Outdated()
+	if err != nil {
+		fmt.Fprintf(stderr, "pkg outdated failed: %v\n", err)
+		return 1
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(stdout, "All packages are up to date")
+		return 0
+	}
+	fmt.Fprintln(stdout, "Package\tCurrent\tWanted\tLatest")
+	for _, e := range entries {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", e.Name, e.Current, e.Wanted, e.Latest)
+	}
+	return 0
+}
+
+func runUpdate(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		updated, err := GlobalManager.UpdateAll()
+		if err != nil {
+			fmt.Fprintf(stderr, "pkg update failed: %v\n", err)
+			return 1
+		}
+		if len(updated) == 0 {
+			fmt.Fprintln(stdout, "All packages are up to date")
+		}
+		return 0
+	}
+
+	status := 0
+	for _, name := range args {
+		changed, err := GlobalManager.UpdatePackage(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "pkg update %s failed: %v\n", name, err)
+			status = 1
+		} else if !changed {
+			fmt.Fprintf(stdout, "Package '%s' is up to date\n", name)
+		}
+	}
+	return status
+}
+
+func runPublish(args []string, stderr io.Writer) int {
+	if len(args) > 1 {
+		fmt.Fprintln(stderr, "pkg publish takes at most one package directory")
+		return 2
+	}
+
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	if _, err := GlobalRegistry.Publish(dir); err != nil {
+		fmt.Fprintf(stderr, "pkg publish failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "SQU1D++ Package Manager")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  squ1dcc pkg install github.com/user/lib")
+	fmt.Fprintln(w, "  squ1dcc pkg install github.com/user/lib@^1.2")
+	fmt.Fprintln(w, "  squ1dcc pkg install --version \">=1.0 <2.0\" https://example.com/lib.zip")
+	fmt.Fprintln(w, "  squ1dcc pkg install https://example.com/lib.zip")
+	fmt.Fprintln(w, "  squ1dcc pkg search json")
+	fmt.Fprintln(w, "  squ1dcc pkg info strutil")
+	fmt.Fprintln(w, "  squ1dcc pkg list")
+	fmt.Fprintln(w, "  squ1dcc pkg outdated")
+	fmt.Fprintln(w, "  squ1dcc pkg update [lib...]")
+	fmt.Fprintln(w, "  squ1dcc pkg publish [dir]")
+	fmt.Fprintln(w, "  squ1dcc pkg remove lib")
+	fmt.Fprintln(w, "  squ1dcc pkg migrate")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  install  Install packages by registry name, from git repositories or URLs (.sqd, .zip, .tar.gz)")
+	fmt.Fprintln(w, "  list     List installed packages")
+	fmt.Fprintln(w, "  remove   Remove installed packages")
+	fmt.Fprintln(w, "  search   Search the package registry")
+	fmt.Fprintln(w, "  info     Show registry details for a package")
+	fmt.Fprintln(w, "  outdated List installed packages with newer versions available")
+	fmt.Fprintln(w, "  update   Update packages within the constraints recorded in squ1d.lock")
+	fmt.Fprintln(w, "  publish  Pack a package directory and publish it to the registry")
+	fmt.Fprintln(w, "  migrate  Move packages from ~/.cache/squ1dlang into the package directory")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "The registry index is read from $SQU1D_REGISTRY (default "+DefaultRegistry+").")
+	fmt.Fprintln(w, "Publishing to an HTTP registry sends $SQU1D_REGISTRY_TOKEN as a bearer token.")
+	fmt.Fprintln(w, "Packages are installed into $SQU1D_PACKAGES (default ~/.squ1dlang/packages).")
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// installDependencies installs the dependencies declared by package name.
+// Each entry maps a package name to a version constraint ("^1.2", looked up
+// in the registry) or to a source as accepted by InstallPackage, optionally
+// with an "@constraint" suffix. Dependencies that are already installed are
+// kept when they satisfy the constraint and reported as a conflict otherwise.
+func (pm *Manager) installDependencies(name string, deps map[string]string, chain []string) error {
+	chain = append(append([]string(nil), chain...), name)
+
+	names := make([]string, 0, len(deps))
+	for dep := range deps {
+		names = append(names, dep)
+	}
+	sort.Strings(names)
+
+	for _, dep := range names {
+		if !validPackageName(dep) {
+			return fmt.Errorf("Package '%s' declares an invalid dependency name '%s'", name, dep)
+		}
+
+		source, constraint := dependencySource(dep, deps[dep])
+		if constraint == "" {
+			if _, err := os.Stat(filepath.Join(pm.packageDir, dep)); err == nil {
+				continue
+			}
+		}
+
+		installed, _, err := pm.install(source, constraint, false, chain)
+		if err != nil {
+			return fmt.Errorf("Failed to install dependency '%s' of '%s': %v", dep, name, err)
+		}
+		if installed.Name != dep {
+			return fmt.Errorf("Dependency '%s' of '%s' installed as '%s'", dep, name, installed.Name)
+		}
+	}
+	return nil
+}
+
+// dependencySource turns a package.json dependency into an install source
+// and constraint. A value that parses as a constraint ("^1.2", "1.0.0", "*")
+// names a registry package; anything else is a source, with the constraint
+// split off git sources like "github.com/user/lib@^1.0".
+func dependencySource(name, value string) (source, constraint string) {
+	c, err := ParseConstraint(value)
+	if err != nil {
+		if repo, ref := splitGitRef(value); isGitSource(value) && isVersionConstraint(ref) {
+			return repo, ref
+		}
+		return value, ""
+	}
+	if c.String() == "*" {
+		return name, ""
+	}
+	return name, c.String()
+}
@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newPackageRepo creates a git repository at dir/name.git holding a package
+// with the given package.json, tagged v1.0.0.
+func newPackageRepo(t *testing.T, dir, name, packageJSON string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := filepath.Join(dir, name+".git")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"main.sqd": "var name = \"" + name + "\";", "package.json": packageJSON}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(repo, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"commit", "-q", "-m", "init"},
+		{"tag", "v1.0.0"},
+	} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	return repo
+}
+
+func TestInstallTransitiveDependencies(t *testing.T) {
+	dir := t.TempDir()
+	base, _ := newTaggedRepo(t, "base", "v1.0.0", "v1.2.0")
+	util := newPackageRepo(t, dir, "util", `{"name": "util", "version": "1.0.0", "dependencies": {"base": "`+base+`@^1.0"}}`)
+	app := newPackageRepo(t, dir, "app", `{"name": "app", "version": "1.0.0", "dependencies": {"util": "`+util+`", "base": "`+base+`@~1.2"}}`)
+
+	pm := newTestManager(t)
+	if _, err := pm.InstallPackage(app, ""); err != nil {
+		t.Fatalf("install with dependencies failed: %v", err)
+	}
+
+	lock, err := pm.ReadLockfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, version := range map[string]string{"app": "1.0.0", "util": "1.0.0", "base": "1.2.0"} {
+		if entry, ok := lock.Packages[name]; !ok || entry.Version != version {
+			t.Errorf("expected %s %s to be installed, got %+v", name, version, entry)
+		}
+	}
+
+	conflict := newPackageRepo(t, dir, "conflict", `{"name": "conflict", "version": "1.0.0", "dependencies": {"base": "`+base+`@^2.0"}}`)
+	if _, err := pm.InstallPackage(conflict, ""); err == nil || !strings.Contains(err.Error(), "conflict") {
+		t.Fatalf("expected a version conflict, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(pm.packageDir, "conflict")); !os.IsNotExist(err) {
+		t.Fatalf("a package with unmet dependencies must not be installed")
+	}
+}
+
+func TestInstallDependencyCycle(t *testing.T) {
+	dir := t.TempDir()
+	ping := filepath.Join(dir, "ping.git")
+	pong := newPackageRepo(t, dir, "pong", `{"name": "pong", "version": "1.0.0", "dependencies": {"ping": "`+ping+`"}}`)
+	newPackageRepo(t, dir, "ping", `{"name": "ping", "version": "1.0.0", "dependencies": {"pong": "`+pong+`"}}`)
+
+	pm := newTestManager(t)
+	_, err := pm.InstallPackage(ping, "")
+	if err == nil || !strings.Contains(err.Error(), "ping -> pong -> ping") {
+		t.Fatalf("expected a dependency cycle error, got %v", err)
+	}
+	packages, err := pm.ListPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 0 {
+		t.Fatalf("expected nothing to be installed, got %d packages", len(packages))
+	}
+}
+
+func TestDependencySource(t *testing.T) {
+	tests := []struct {
+		value      string
+		source     string
+		constraint string
+	}{
+		{"^1.2", "lib", "^1.2"},
+		{"1.0.0", "lib", "1.0.0"},
+		{"*", "lib", ""},
+		{"", "lib", ""},
+		{"github.com/user/lib@^1.0", "github.com/user/lib", "^1.0"},
+		{"github.com/user/lib@main", "github.com/user/lib@main", ""},
+		{"https://example.com/lib.zip", "https://example.com/lib.zip", ""},
+	}
+
+	for _, tt := range tests {
+		source, constraint := dependencySource("lib", tt.value)
+		if source != tt.source || constraint != tt.constraint {
+			t.Errorf("dependencySource(%q) = %q, %q, want %q, %q", tt.value, source, constraint, tt.source, tt.constraint)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// packageInit is the file that makes a directory a package.
+const packageInit = "__init__.sqd"
+
+// Submodule is one nested namespace of a directory package.
+type Submodule struct {
+	// Namespace is the file name without .sqd, or the directory name.
+	Namespace string
+	// Path is the .sqd file, or the nested package directory.
+	Path string
+}
+
+// PackageDir returns the directory package that path refers to: path itself
+// when it is a directory holding __init__.sqd, or the directory of path when
+// path is such an __init__.sqd. ok is false for anything else.
+func PackageDir(path string) (dir string, ok bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	if !info.IsDir() {
+		if filepath.Base(path) != packageInit {
+			return "", false
+		}
+		return filepath.Dir(path), true
+	}
+	if _, err := os.Stat(filepath.Join(path, packageInit)); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// PackageInit returns the __init__.sqd of the directory package dir.
+func PackageInit(dir string) string {
+	return filepath.Join(dir, packageInit)
+}
+
+// Submodules lists the nested namespaces of the directory package dir, sorted
+// by name: every other .sqd file, and every subdirectory that is itself a
+// directory package. Entries whose names aren't identifiers are skipped, as
+// they couldn't be reached with dot notation.
+func Submodules(dir string) ([]Submodule, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var modules []Submodule
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		name := entry.Name()
+		if entry.IsDir() {
+			if _, ok := PackageDir(path); !ok {
+				continue
+			}
+		} else {
+			if name == packageInit || !strings.HasSuffix(name, ".sqd") {
+				continue
+			}
+			name = strings.TrimSuffix(name, ".sqd")
+		}
+		if !isIdentifier(name) {
+			continue
+		}
+		modules = append(modules, Submodule{Namespace: name, Path: path})
+	}
+	return modules, nil
+}
+
+func isIdentifier(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSubmodulesListsFilesAndNestedPackages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"__init__.sqd", "strings.sqd", "my-lib.sqd", "notes.txt", "text/__init__.sqd", "plain/readme.sqd"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, ok := PackageDir(dir); !ok || got != dir {
+		t.Fatalf("expected %s to be a directory package, got %q %v", dir, got, ok)
+	}
+	if got, ok := PackageDir(filepath.Join(dir, "__init__.sqd")); !ok || got != dir {
+		t.Fatalf("expected __init__.sqd to resolve to its package, got %q %v", got, ok)
+	}
+	if _, ok := PackageDir(filepath.Join(dir, "plain")); ok {
+		t.Fatalf("expected a directory without __init__.sqd not to be a package")
+	}
+
+	modules, err := Submodules(dir)
+	if err != nil {
+		t.Fatalf("Submodules returned error: %v", err)
+	}
+	want := []Submodule{
+		{Namespace: "strings", Path: filepath.Join(dir, "strings.sqd")},
+		{Namespace: "text", Path: filepath.Join(dir, "text")},
+	}
+	if !reflect.DeepEqual(modules, want) {
+		t.Fatalf("expected %+v, got %+v", want, modules)
+	}
+}
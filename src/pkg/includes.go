@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// IncludeFrame is one file on the include stack. Line and Column locate the
+// include call that loaded it in the file below it on the stack; they are 0
+// when unknown, e.g. for the file being run.
+type IncludeFrame struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// IncludeCycleError reports a file that includes itself, directly or
+// through other files. Chain runs from the first occurrence of the file to
+// the include that repeats it.
+type IncludeCycleError struct {
+	Chain []IncludeFrame
+}
+
+func (e *IncludeCycleError) Error() string {
+	names := make([]string, len(e.Chain))
+	var sites []string
+	for i, frame := range e.Chain {
+		names[i] = frame.File
+		if i == 0 {
+			continue
+		}
+		site := e.Chain[i-1].File
+		if frame.Line > 0 {
+			site = fmt.Sprintf("%s:%d", site, frame.Line)
+			if frame.Column > 0 {
+				site = fmt.Sprintf("%s:%d", site, frame.Column)
+			}
+		}
+		sites = append(sites, site+" includes "+frame.File)
+	}
+	return fmt.Sprintf("Circular include: %s (%s)", strings.Join(names, " -> "), strings.Join(sites, ", "))
+}
+
+// IncludeStack tracks the files currently being included, so every loader
+// (REPL, evaluator, builder) can reject include cycles instead of recursing
+// forever.
+type IncludeStack struct {
+	mu     sync.Mutex
+	frames []IncludeFrame
+	keys   []string
+}
+
+// Enter pushes file, included from line:column of the file on top of the
+// stack. It returns an *IncludeCycleError, and pushes nothing, when file is
+// already being included. Every successful Enter must be paired with Leave.
+func (s *IncludeStack) Enter(file string, line, column int) error {
+	key := file
+	if abs, err := filepath.Abs(file); err == nil {
+		key = abs
+	}
+	frame := IncludeFrame{File: file, Line: line, Column: column}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, k := range s.keys {
+		if k == key {
+			chain := append(append([]IncludeFrame(nil), s.frames[i:]...), frame)
+			return &IncludeCycleError{Chain: chain}
+		}
+	}
+	s.frames = append(s.frames, frame)
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+// Leave pops the file pushed by the matching Enter.
+func (s *IncludeStack) Leave() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := len(s.frames); n > 0 {
+		s.frames = s.frames[:n-1]
+		s.keys = s.keys[:n-1]
+	}
+}
+
+// Frames returns a copy of the stack, outermost file first.
+func (s *IncludeStack) Frames() []IncludeFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]IncludeFrame(nil), s.frames...)
+}
+
+// Includes is the include stack shared by the REPL, file runner and
+// evaluator.
+var Includes = &IncludeStack{}
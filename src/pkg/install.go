@@ -0,0 +1,648 @@
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// httpClient is used for URL installs.
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// InstallPackage fetches a package into the package directory. source may be
+//
+//   - a git repository: "github.com/user/lib", "https://host/user/lib.git",
+//     "git@host:user/lib.git" or a local "*.git" path, optionally pinned with
+//     "@ref" (branch or tag) or "@constraint" (e.g. "@^1.2")
+//   - a URL to a single .sqd file, which becomes the package's main.sqd
+//   - a URL to a .zip, .tar.gz or .tgz archive of the package
+//   - a package name ("lib" or "lib@^1.2") looked up in the registry
+//
+// constraint (e.g. "^1.2", may be empty) must be satisfied by the installed
+// version. When a satisfying version is already installed it is kept; any
+// other installed version is reported as a conflict. The installed package
+// must provide its entry point (package.json "main", default main.sqd) at
+// its root. Dependencies declared in its package.json are installed first.
+func (pm *Manager) InstallPackage(source, constraint string) (*Package, error) {
+	pm.prepare()
+	installed, _, err := pm.install(source, constraint, false, nil)
+	return installed, err
+}
+
+// install implements InstallPackage. With replace set, an installed package
+// of the same name is swapped for the newly fetched one (see UpdatePackage).
+// changed reports whether anything was written. chain lists the packages
+// whose dependencies are being installed, outermost first.
+func (pm *Manager) install(spec, constraint string, replace bool, chain []string) (installed *Package, changed bool, err error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, false, fmt.Errorf("No package source given")
+	}
+
+	source := spec
+	lockSource := spec
+	integrity := ""
+	if isRegistryName(spec) {
+		name, ref := splitGitRef(spec)
+		entry, err := GlobalRegistry.Info(name)
+		if err != nil {
+			return nil, false, err
+		}
+		if ref != "" {
+			if constraint != "" && constraint != ref {
+				return nil, false, fmt.Errorf("Conflicting version constraints '%s' and '%s'", ref, constraint)
+			}
+			constraint = ref
+		}
+		source, lockSource = entry.Source, name
+
+		// Published tarballs take precedence over the entry's source.
+		if dist, ok, err := GlobalRegistry.resolveDist(entry, constraint); err != nil {
+			return nil, false, err
+		} else if ok {
+			source, integrity = dist.Tarball, dist.Integrity
+		}
+	}
+
+	fetchFrom := source
+	pinned := false
+	if isGitSource(source) {
+		repo, ref := splitGitRef(source)
+		if isVersionConstraint(ref) {
+			if constraint != "" && constraint != ref {
+				return nil, false, fmt.Errorf("Conflicting version constraints '%s' and '%s'", ref, constraint)
+			}
+			fetchFrom, constraint = repo, ref
+			if lockSource == spec {
+				lockSource = repo
+			}
+		} else if ref != "" {
+			pinned = true
+		}
+	}
+
+	var want *Constraint
+	if strings.TrimSpace(constraint) != "" {
+		if want, err = ParseConstraint(constraint); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if want != nil && !replace {
+		if installed, ok, err := pm.installedSatisfying(packageNameFromSource(source), want); err != nil {
+			return nil, false, err
+		} else if ok {
+			return installed, false, nil
+		}
+	}
+
+	// Unpinned git sources install the highest version tag that satisfies the
+	// constraint, falling back to the default branch for untagged repos.
+	tagVersion := ""
+	if isGitSource(fetchFrom) && !pinned {
+		tags, versions, err := gitTagVersions(fetchFrom)
+		if err != nil {
+			return nil, false, err
+		}
+		match := want
+		if match == nil {
+			match, _ = ParseConstraint("*")
+		}
+		if best, ok := match.MaxSatisfying(versions); ok {
+			fetchFrom, tagVersion = fetchFrom+"@"+tags[best], best.String()
+		} else if want != nil {
+			return nil, false, fmt.Errorf("No version of '%s' satisfies '%s'", fetchFrom, want)
+		}
+	} else if _, ref := splitGitRef(fetchFrom); pinned {
+		if v, err := ParseVersion(ref); err == nil {
+			tagVersion = v.String()
+		}
+	}
+
+	if replace && tagVersion != "" {
+		// Nothing to fetch when the wanted tag is already installed.
+		name := packageNameFromSource(source)
+		if current, err := pm.loadPackage(name, filepath.Join(pm.packageDir, name)); err == nil {
+			if v, err := ParseVersion(current.Version); err == nil && v.String() == tagVersion {
+				return current, false, nil
+			}
+		}
+	}
+
+	if err := os.MkdirAll(pm.packageDir, 0755); err != nil {
+		return nil, false, fmt.Errorf("Failed to create package directory: %v", err)
+	}
+
+	staging, err := os.MkdirTemp(pm.packageDir, ".install-")
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to create staging directory: %v", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if err := fetchSource(fetchFrom, staging, integrity); err != nil {
+		return nil, false, err
+	}
+
+	root, err := packageRoot(staging)
+	if err != nil {
+		return nil, false, err
+	}
+
+	name := packageNameFromSource(source)
+	meta, err := ReadMetadata(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	if meta != nil && meta.Name != "" {
+		name = meta.Name
+	}
+	if !validPackageName(name) {
+		return nil, false, fmt.Errorf("Invalid package name '%s'", name)
+	}
+	for _, required := range chain {
+		if required == name {
+			return nil, false, fmt.Errorf("Dependency cycle: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+
+	if want != nil && !replace {
+		if installed, ok, err := pm.installedSatisfying(name, want); err != nil {
+			return nil, false, err
+		} else if ok {
+			return installed, false, nil
+		}
+	}
+
+	packagePath := filepath.Join(pm.packageDir, name)
+	_, statErr := os.Stat(packagePath)
+	exists := statErr == nil
+	if exists && !replace {
+		return nil, false, fmt.Errorf("Package '%s' already exists", name)
+	}
+
+	fetched, err := pm.loadPackage(name, root)
+	if err != nil {
+		return nil, false, err
+	}
+	version := fetched.Version
+	if version == "" {
+		version = tagVersion
+	}
+	if want != nil {
+		v, err := ParseVersion(version)
+		if err != nil {
+			return nil, false, fmt.Errorf("Package '%s' has no usable version to check against '%s'", name, want)
+		}
+		if !want.Check(v) {
+			return nil, false, fmt.Errorf("Package '%s' %s does not satisfy '%s'", name, v, want)
+		}
+	}
+
+	if meta == nil {
+		if version == "" {
+			version = "0.0.0"
+		}
+		err := WriteMetadata(root, &Metadata{
+			Name:    name,
+			Version: version,
+			Main:    defaultMain,
+			Files:   []string{defaultMain},
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if meta != nil && len(meta.Dependencies) > 0 {
+		if err := pm.installDependencies(name, meta.Dependencies, chain); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if exists {
+		if current, err := pm.loadPackage(name, packagePath); err == nil && version != "" && current.Version == version {
+			return current, false, nil
+		}
+
+		// Swap directories so a failed rename leaves the old version intact.
+		backup := filepath.Join(staging, ".previous")
+		if err := os.Rename(packagePath, backup); err != nil {
+			return nil, false, fmt.Errorf("Failed to replace package: %v", err)
+		}
+		if err := os.Rename(root, packagePath); err != nil {
+			os.Rename(backup, packagePath)
+			return nil, false, fmt.Errorf("Failed to replace package: %v", err)
+		}
+	} else if err := os.Rename(root, packagePath); err != nil {
+		return nil, false, fmt.Errorf("Failed to install package: %v", err)
+	}
+
+	err = pm.lockPackage(name, &LockEntry{
+		Source:     lockSource,
+		Constraint: constraint,
+		Version:    version,
+		Pinned:     pinned,
+	})
+	if err != nil {
+		return nil, true, err
+	}
+
+	if exists {
+		fmt.Fprintf(pm.out(), "Package '%s' updated to %s\n", name, version)
+	} else {
+		fmt.Fprintf(pm.out(), "Package '%s' installed successfully at %s\n", name, packagePath)
+	}
+	installed, err = pm.loadPackage(name, packagePath)
+	return installed, true, err
+}
+
+// installedSatisfying checks an installed package against want. It returns
+// the package when it satisfies want, and a conflict error when a different
+// version is installed.
+func (pm *Manager) installedSatisfying(name string, want *Constraint) (*Package, bool, error) {
+	packagePath := filepath.Join(pm.packageDir, name)
+	if !validPackageName(name) {
+		return nil, false, nil
+	}
+	if _, err := os.Stat(packagePath); err != nil {
+		return nil, false, nil
+	}
+
+	installed, err := pm.loadPackage(name, packagePath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	v, err := ParseVersion(installed.Version)
+	if err != nil || !want.Check(v) {
+		return nil, false, fmt.Errorf("Version conflict: package '%s' %s is installed but '%s' was requested", name, installed.Version, want)
+	}
+	return installed, true, nil
+}
+
+// gitTagVersions lists the version tags of a repository, keyed by version.
+func gitTagVersions(source string) (map[Version]string, []Version, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, nil, fmt.Errorf("git is required to install '%s'", source)
+	}
+
+	repo, _ := splitGitRef(source)
+	cmd := exec.Command("git", "ls-remote", "--tags", "--refs", gitRemote(repo))
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to list tags of '%s': %v", repo, err)
+	}
+
+	tags := map[Version]string{}
+	var versions []Version
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/tags/") {
+			continue
+		}
+		tag := strings.TrimPrefix(fields[1], "refs/tags/")
+		v, err := ParseVersion(tag)
+		if err != nil {
+			continue
+		}
+		tags[v] = tag
+		versions = append(versions, v)
+	}
+	return tags, versions, nil
+}
+
+// fetchSource downloads or clones source into dir. A non-empty integrity
+// ("sha256-<base64>") is checked against the downloaded file.
+func fetchSource(source, dir, integrity string) error {
+	if isGitSource(source) {
+		return cloneGit(source, dir)
+	}
+
+	lower := strings.ToLower(source)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		// Local files, e.g. tarballs published to a file registry.
+		if _, err := os.Stat(strings.TrimPrefix(source, "file://")); err != nil {
+			return fmt.Errorf("Unsupported package source '%s'", source)
+		}
+	}
+
+	path := lower
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".sqd"):
+		return download(source, filepath.Join(dir, "main.sqd"), integrity)
+	case strings.HasSuffix(path, ".zip"):
+		archive := filepath.Join(dir, ".download.zip")
+		if err := download(source, archive, integrity); err != nil {
+			return err
+		}
+		defer os.Remove(archive)
+		return extractZip(archive, dir)
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		archive := filepath.Join(dir, ".download.tar.gz")
+		if err := download(source, archive, integrity); err != nil {
+			return err
+		}
+		defer os.Remove(archive)
+		return extractTarGz(archive, dir)
+	}
+
+	// Anything else served over HTTP(S) is assumed to be a git remote.
+	return cloneGit(source, dir)
+}
+
+// isGitSource reports whether source names a git repository rather than a
+// downloadable file.
+func isGitSource(source string) bool {
+	repo, _ := splitGitRef(source)
+	lower := strings.ToLower(repo)
+
+	switch {
+	case strings.HasPrefix(lower, "git@"), strings.HasPrefix(lower, "git://"),
+		strings.HasPrefix(lower, "ssh://"), strings.HasPrefix(lower, "git+"):
+		return true
+	case strings.HasSuffix(lower, ".git"):
+		return true
+	case strings.HasPrefix(lower, "github.com/"), strings.HasPrefix(lower, "gitlab.com/"),
+		strings.HasPrefix(lower, "bitbucket.org/"), strings.HasPrefix(lower, "codeberg.org/"):
+		return true
+	}
+	return false
+}
+
+// isRegistryName reports whether source is a bare package name such as
+// "lib" or "lib@^1.2" rather than a repository, URL or path.
+func isRegistryName(source string) bool {
+	name, _ := splitGitRef(source)
+	if isGitSource(source) || strings.ContainsAny(name, `/\:`) {
+		return false
+	}
+	for _, ext := range []string{".sqd", ".zip", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return false
+		}
+	}
+	return validPackageName(name)
+}
+
+// splitGitRef separates an optional "@ref" suffix from a git source. The "@"
+// in "git@host:..." is not a ref separator.
+func splitGitRef(source string) (repo, ref string) {
+	i := strings.LastIndex(source, "@")
+	if i <= 0 || strings.Contains(source[i:], "/") || strings.Contains(source[i:], ":") {
+		return source, ""
+	}
+	return source[:i], source[i+1:]
+}
+
+func cloneGit(source, dir string) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git is required to install '%s'", source)
+	}
+
+	repo, ref := splitGitRef(source)
+
+	args := []string{"clone", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, gitRemote(repo), dir)
+
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git clone of '%s' failed: %v\n%s", source, err, strings.TrimSpace(string(out)))
+	}
+
+	// The package cache holds plain files only.
+	return os.RemoveAll(filepath.Join(dir, ".git"))
+}
+
+// gitRemote turns a git source into something git can clone.
+func gitRemote(repo string) string {
+	repo = strings.TrimPrefix(repo, "git+")
+	if !strings.Contains(repo, "://") && !strings.HasPrefix(repo, "git@") {
+		if _, err := os.Stat(repo); err != nil {
+			// Host-relative form like github.com/user/lib.
+			return "https://" + repo
+		}
+	}
+	return repo
+}
+
+func download(url, dest, integrity string) error {
+	var body io.Reader
+	lower := strings.ToLower(url)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			return fmt.Errorf("Failed to download '%s': %v", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Failed to download '%s': %s", url, resp.Status)
+		}
+		body = resp.Body
+	} else {
+		in, err := os.Open(strings.TrimPrefix(url, "file://"))
+		if err != nil {
+			return fmt.Errorf("Failed to read '%s': %v", url, err)
+		}
+		defer in.Close()
+		body = in
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), body); err != nil {
+		return fmt.Errorf("Failed to download '%s': %v", url, err)
+	}
+	if integrity != "" && integrity != integrityString(hash.Sum(nil)) {
+		return fmt.Errorf("Integrity check failed for '%s': expected %s", url, integrity)
+	}
+	return out.Close()
+}
+
+// safeJoin resolves an archive member name inside dir, rejecting names that
+// would escape it.
+func safeJoin(dir, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if target != dir && !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Archive entry '%s' escapes the package directory", name)
+	}
+	return target, nil
+}
+
+func extractZip(archive, dir string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("Failed to open archive: %v", err)
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		target, err := safeJoin(dir, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(target, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarGz(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("Failed to open archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read archive: %v", err)
+		}
+
+		target, err := safeJoin(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// packageRoot finds the directory holding the package entry point. Archives
+// commonly wrap everything in a single top-level directory, which is looked
+// through.
+func packageRoot(dir string) (string, error) {
+	for {
+		if meta, err := ReadMetadata(dir); err == nil {
+			if _, err := os.Stat(filepath.Join(dir, meta.EntryPoint())); err != nil {
+				return "", fmt.Errorf("Package entry point '%s' not found", meta.EntryPoint())
+			}
+			return dir, nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, defaultMain)); err == nil {
+			return dir, nil
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+
+		var visible []os.DirEntry
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), ".") {
+				visible = append(visible, e)
+			}
+		}
+
+		if len(visible) != 1 || !visible[0].IsDir() {
+			return "", fmt.Errorf("Package has no main.sqd")
+		}
+		dir = filepath.Join(dir, visible[0].Name())
+	}
+}
+
+// packageNameFromSource derives a package name from the last path segment of
+// source, e.g. "github.com/user/lib.git@v1" -> "lib".
+func packageNameFromSource(source string) string {
+	name := source
+	if isGitSource(source) {
+		name, _ = splitGitRef(source)
+	}
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimRight(name, "/")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	for _, ext := range []string{".git", ".sqd", ".zip", ".tar.gz", ".tgz"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+func validPackageName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, ".") {
+		return false
+	}
+	return !strings.ContainsAny(name, `/\:`)
+}
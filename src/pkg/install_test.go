@@ -0,0 +1,259 @@
+package pkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	return &Manager{
+		packageDir: filepath.Join(t.TempDir(), "packages"),
+		packages:   make(map[string]*Package),
+	}
+}
+
+func TestInstallPackageFromURL(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for name, content := range map[string]string{
+		"archive-main/main.sqd":     "var answer = 42;",
+		"archive-main/package.json": "{\n  \"name\": \"ziplib\",\n  \"version\": \"2.0.0\"\n}",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/single.sqd":
+			w.Write([]byte("var x = 1;"))
+		case "/lib.zip":
+			w.Write(zipped.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	pm := newTestManager(t)
+	var out bytes.Buffer
+	pm.Out = &out
+
+	p, err := pm.InstallPackage(server.URL+"/single.sqd", "")
+	if err != nil {
+		t.Fatalf("install of .sqd failed: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Package 'single' installed successfully") {
+		t.Fatalf("expected the install to be reported to the manager's writer, got %q", out.String())
+	}
+	if p.Name != "single" {
+		t.Fatalf("expected package name 'single', got %q", p.Name)
+	}
+	if _, err := os.Stat(filepath.Join(pm.packageDir, "single", "main.sqd")); err != nil {
+		t.Fatalf("main.sqd missing: %v", err)
+	}
+
+	p, err = pm.InstallPackage(server.URL+"/lib.zip", "")
+	if err != nil {
+		t.Fatalf("install of .zip failed: %v", err)
+	}
+	if p.Name != "ziplib" || p.Version != "2.0.0" {
+		t.Fatalf("expected ziplib 2.0.0 from package.json, got %s %s", p.Name, p.Version)
+	}
+	lock, err := pm.ReadLockfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := lock.Packages["ziplib"]; entry.Source != server.URL+"/lib.zip" || entry.Version != "2.0.0" {
+		t.Fatalf("expected install to be recorded in the lockfile, got %+v", entry)
+	}
+
+	if _, err := pm.InstallPackage(server.URL+"/lib.zip", ""); err == nil {
+		t.Fatalf("expected reinstalling an existing package to fail")
+	}
+	if _, err := pm.InstallPackage(server.URL+"/missing.sqd", ""); err == nil {
+		t.Fatalf("expected a 404 to fail the install")
+	}
+
+	packages, err := pm.ListPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 2 {
+		t.Fatalf("expected 2 installed packages, got %d", len(packages))
+	}
+}
+
+func TestRunReportsToStdout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("var x = 1;"))
+	}))
+	defer server.Close()
+
+	previous := GlobalManager
+	GlobalManager = newTestManager(t)
+	defer func() { GlobalManager = previous }()
+
+	var stdout, stderr bytes.Buffer
+	if status := Run([]string{"install", server.URL + "/single.sqd"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("pkg install failed: %s", stderr.String())
+	}
+	if status := Run([]string{"remove", "single"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("pkg remove failed: %s", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Package 'single' installed successfully") ||
+		!strings.Contains(stdout.String(), "Package 'single' removed successfully") {
+		t.Fatalf("expected install and remove to report to stdout, got %q", stdout.String())
+	}
+	if GlobalManager.Out != nil {
+		t.Fatalf("expected Run to restore the manager's writer")
+	}
+}
+
+func TestInstallPackageFromGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := filepath.Join(t.TempDir(), "gitlib.git")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "main.sqd"), []byte("var y = 2;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+		{"tag", "v1.0.0"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+
+	pm := newTestManager(t)
+	p, err := pm.InstallPackage(repo+"@v1.0.0", "")
+	if err != nil {
+		t.Fatalf("install from git failed: %v", err)
+	}
+	if p.Name != "gitlib" {
+		t.Fatalf("expected package name 'gitlib', got %q", p.Name)
+	}
+	if _, err := os.Stat(filepath.Join(pm.packageDir, "gitlib", ".git")); !os.IsNotExist(err) {
+		t.Fatalf("expected .git to be removed from the installed package")
+	}
+}
+
+func TestInstallPackageVersionConstraints(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := filepath.Join(t.TempDir(), "semlib.git")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	for _, tag := range []string{"v1.0.0", "v1.3.0", "v2.0.0"} {
+		if err := os.WriteFile(filepath.Join(repo, "main.sqd"), []byte("var version = \""+tag+"\";"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", ".")
+		git("commit", "-q", "-m", tag)
+		git("tag", tag)
+	}
+
+	pm := newTestManager(t)
+	p, err := pm.InstallPackage(repo+"@^1.0", "")
+	if err != nil {
+		t.Fatalf("install with constraint failed: %v", err)
+	}
+	if p.Version != "1.3.0" {
+		t.Fatalf("expected the highest ^1.0 tag (1.3.0), got %q", p.Version)
+	}
+	main, err := os.ReadFile(filepath.Join(pm.packageDir, "semlib", "main.sqd"))
+	if err != nil || !strings.Contains(string(main), "v1.3.0") {
+		t.Fatalf("expected v1.3.0 sources, got %q (%v)", main, err)
+	}
+
+	// A satisfied constraint keeps the installed package.
+	if _, err := pm.InstallPackage(repo, "~1.3"); err != nil {
+		t.Fatalf("expected installed 1.3.0 to satisfy ~1.3: %v", err)
+	}
+	if _, err := pm.InstallPackage(repo, "^2.0"); err == nil || !strings.Contains(err.Error(), "conflict") {
+		t.Fatalf("expected a version conflict, got %v", err)
+	}
+
+	if path, found, err := pm.ResolveInclude("semlib@>=1.1"); err != nil || !found || filepath.Base(path) != "main.sqd" {
+		t.Fatalf("expected semlib@>=1.1 to resolve, got %q %v %v", path, found, err)
+	}
+	if _, _, err := pm.ResolveInclude("semlib@^2"); err == nil {
+		t.Fatalf("expected include of semlib@^2 to report a conflict")
+	}
+	if _, found, err := pm.ResolveInclude("lib/local.sqd"); found || err != nil {
+		t.Fatalf("file paths must not resolve as packages")
+	}
+}
+
+func TestPackageSourceParsing(t *testing.T) {
+	tests := []struct {
+		source string
+		git    bool
+		name   string
+	}{
+		{"github.com/user/lib", true, "lib"},
+		{"github.com/user/lib@v1.2.0", true, "lib"},
+		{"git@github.com:user/lib.git", true, "lib"},
+		{"https://example.com/user/lib.git", true, "lib"},
+		{"https://example.com/files/util.sqd", false, "util"},
+		{"https://example.com/files/util.tar.gz?raw=1", false, "util"},
+	}
+
+	for source, expected := range map[string]bool{
+		"strutil":             true,
+		"strutil@^1.2":        true,
+		"github.com/user/lib": false,
+		"./lib":               false,
+		"util.sqd":            false,
+		"https://x/lib.zip":   false,
+	} {
+		if got := isRegistryName(source); got != expected {
+			t.Errorf("isRegistryName(%q) = %v, want %v", source, got, expected)
+		}
+	}
+
+	for _, tt := range tests {
+		if got := isGitSource(tt.source); got != tt.git {
+			t.Errorf("isGitSource(%q) = %v, want %v", tt.source, got, tt.git)
+		}
+		if got := packageNameFromSource(tt.source); got != tt.name {
+			t.Errorf("packageNameFromSource(%q) = %q, want %q", tt.source, got, tt.name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lockFile records, for every installed package, where it came from and
+// which version was resolved, so updates can re-fetch it within the
+// constraint it was installed with.
+const lockFile = "squ1d.lock"
+
+// LockEntry is one installed package in the lockfile.
+type LockEntry struct {
+	// Source is what to fetch again: a registry name, a git repository (with
+	// "@ref" when pinned to a branch or tag) or a URL.
+	Source string `json:"source"`
+	// Constraint is the version constraint given at install time, if any.
+	Constraint string `json:"constraint,omitempty"`
+	// Version is the installed version.
+	Version string `json:"version"`
+	// Pinned entries were installed from an explicit git branch or tag and
+	// are left alone by updates.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// Lockfile maps package names to their lock entries.
+type Lockfile struct {
+	Packages map[string]LockEntry `json:"packages"`
+}
+
+func (pm *Manager) lockPath() string {
+	return filepath.Join(pm.packageDir, lockFile)
+}
+
+// ReadLockfile loads the lockfile, returning an empty one if none exists.
+func (pm *Manager) ReadLockfile() (*Lockfile, error) {
+	pm.prepare()
+	lock := &Lockfile{Packages: map[string]LockEntry{}}
+
+	data, err := os.ReadFile(pm.lockPath())
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", lockFile, err)
+	}
+	if lock.Packages == nil {
+		lock.Packages = map[string]LockEntry{}
+	}
+	return lock, nil
+}
+
+func (pm *Manager) writeLockfile(lock *Lockfile) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(pm.lockPath(), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", lockFile, err)
+	}
+	return nil
+}
+
+// lockPackage records or, when entry is nil, forgets a package.
+func (pm *Manager) lockPackage(name string, entry *LockEntry) error {
+	lock, err := pm.ReadLockfile()
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		if _, ok := lock.Packages[name]; !ok {
+			return nil
+		}
+		delete(lock.Packages, name)
+	} else {
+		lock.Packages[name] = *entry
+	}
+	return pm.writeLockfile(lock)
+}
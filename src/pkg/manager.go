@@ -2,37 +2,88 @@ package pkg
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type Package struct {
-	Name        string
-	Path        string
-	Version     string
-	Description string
-	Files       []string
+	Name         string
+	Path         string
+	Version      string
+	Description  string
+	Main         string
+	Files        []string
+	Dependencies map[string]string
+	Scripts      map[string]string
 }
 
 type Manager struct {
+	// Out receives the messages reporting created, installed and removed
+	// packages; os.Stdout when nil.
+	Out io.Writer
+
 	packageDir string
 	packages   map[string]*Package
+	// legacyDir is where packages of the old include loader are moved from
+	// (see prepare); none are moved when it is empty.
+	legacyDir string
+	migration sync.Once
 }
 
 func NewManager() *Manager {
-	homeDir, _ := os.UserHomeDir()
-	packageDir := filepath.Join(homeDir, ".squ1dlang", "packages")
-
-	os.MkdirAll(packageDir, 0755)
-
 	return &Manager{
-		packageDir: packageDir,
+		packageDir: CurrentPackageDir(),
 		packages:   make(map[string]*Package),
+		legacyDir:  legacyPackageDir(),
 	}
 }
 
+// prepare creates the package root and moves the old include loader's
+// packages into it, the first time the manager is used.
+func (pm *Manager) prepare() {
+	pm.migration.Do(func() { pm.MigrateLegacyPackages() })
+}
+
+// MigrateLegacyPackages moves the old include loader's packages into the
+// package root now, reporting each one to Out, and returns their names.
+func (pm *Manager) MigrateLegacyPackages() []string {
+	os.MkdirAll(pm.packageDir, 0755)
+	return migrateLegacyPackages(pm.legacyDir, pm.packageDir, pm.out())
+}
+
+// packageDirOverride is the package root chosen with SetPackageDir.
+var packageDirOverride = ""
+
+// SetPackageDir selects the directory packages are installed into and
+// included from. An empty dir restores the default.
+func SetPackageDir(dir string) {
+	packageDirOverride = strings.TrimSpace(dir)
+	GlobalManager = NewManager()
+}
+
+// CurrentPackageDir returns the package root in effect: SetPackageDir, then
+// $SQU1D_PACKAGES, then ~/.squ1dlang/packages.
+func CurrentPackageDir() string {
+	if packageDirOverride != "" {
+		return packageDirOverride
+	}
+	if env := strings.TrimSpace(os.Getenv("SQU1D_PACKAGES")); env != "" {
+		return env
+	}
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".squ1dlang", "packages")
+}
+
+// PackageDir returns the directory the manager installs packages into.
+func (pm *Manager) PackageDir() string {
+	return pm.packageDir
+}
+
 func (pm *Manager) CreatePackage(name, description string) error {
+	pm.prepare()
 	packagePath := filepath.Join(pm.packageDir, name)
 
 	if _, err := os.Stat(packagePath); err == nil {
@@ -57,18 +108,15 @@ func (pm *Manager) CreatePackage(name, description string) error {
 		return fmt.Errorf("Failed to create main.sqd: %v", err)
 	}
 
-	metadataFile := filepath.Join(packagePath, "package.json")
-	metadataContent := fmt.Sprintf(`{
-  "name": "%s",
-  "version": "1.0.0",
-  "description": "%s",
-  "main": "main.sqd",
-  "files": ["main.sqd"]
-}`, name, description)
-
-	err = os.WriteFile(metadataFile, []byte(metadataContent), 0644)
+	err = WriteMetadata(packagePath, &Metadata{
+		Name:        name,
+		Version:     "1.0.0",
+		Description: description,
+		Main:        defaultMain,
+		Files:       []string{defaultMain},
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to create package.json: %v", err)
+		return err
 	}
 
 	readmeFile := filepath.Join(packagePath, "README.md")
@@ -90,11 +138,12 @@ This package is installed in your local SQU1D++ package directory.
 		return fmt.Errorf("Failed to create README.md: %v", err)
 	}
 
-	fmt.Printf("Package '%s' created successfully at %s\n", name, packagePath)
+	fmt.Fprintf(pm.out(), "Package '%s' created successfully at %s\n", name, packagePath)
 	return nil
 }
 
 func (pm *Manager) ListPackages() ([]*Package, error) {
+	pm.prepare()
 	var packages []*Package
 
 	entries, err := os.ReadDir(pm.packageDir)
@@ -103,11 +152,12 @@ func (pm *Manager) ListPackages() ([]*Package, error) {
 	}
 
 	for _, entry := range entries {
-		if entry.IsDir() {
+		// Dot directories are install staging areas, not packages.
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
 			packagePath := filepath.Join(pm.packageDir, entry.Name())
 			pkg, err := pm.loadPackage(entry.Name(), packagePath)
 			if err != nil {
-				fmt.Printf("Warning: failed to load package '%s': %v\n", entry.Name(), err)
+				fmt.Fprintf(pm.out(), "Warning: failed to load package '%s': %v\n", entry.Name(), err)
 				continue
 			}
 			packages = append(packages, pkg)
@@ -123,24 +173,17 @@ func (pm *Manager) loadPackage(name, path string) (*Package, error) {
 		Path: path,
 	}
 
-	metadataFile := filepath.Join(path, "package.json")
-	if data, err := os.ReadFile(metadataFile); err == nil {
-		content := string(data)
-		if strings.Contains(content, `"version"`) {
-			start := strings.Index(content, `"version": "`) + 11
-			end := strings.Index(content[start:], `"`)
-			if start > 10 && end > 0 {
-				pkg.Version = content[start : start+end]
-			}
-		}
-		if strings.Contains(content, `"description"`) {
-			start := strings.Index(content, `"description": "`) + 16
-			end := strings.Index(content[start:], `"`)
-			if start > 15 && end > 0 {
-				pkg.Description = content[start : start+end]
-			}
-		}
+	meta, err := ReadMetadata(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if meta != nil {
+		pkg.Version = meta.Version
+		pkg.Description = meta.Description
+		pkg.Dependencies = meta.Dependencies
+		pkg.Scripts = meta.Scripts
 	}
+	pkg.Main = meta.EntryPoint()
 
 	files, err := filepath.Glob(filepath.Join(path, "*.sqd"))
 	if err == nil {
@@ -153,6 +196,7 @@ func (pm *Manager) loadPackage(name, path string) (*Package, error) {
 }
 
 func (pm *Manager) RemovePackage(name string) error {
+	pm.prepare()
 	packagePath := filepath.Join(pm.packageDir, name)
 
 	if _, err := os.Stat(packagePath); os.IsNotExist(err) {
@@ -163,12 +207,61 @@ func (pm *Manager) RemovePackage(name string) error {
 	if err != nil {
 		return fmt.Errorf("Failed to remove package: %v", err)
 	}
+	if err := pm.lockPackage(name, nil); err != nil {
+		return err
+	}
 
-	fmt.Printf("Package '%s' removed successfully\n", name)
+	fmt.Fprintf(pm.out(), "Package '%s' removed successfully\n", name)
 	return nil
 }
 
+// ResolveInclude maps an include spec such as "lib" or "lib@^1.2" to the
+// installed package's entry point. found is false when spec doesn't name an
+// installed package (it is then treated as a file path by the caller). An
+// installed version that doesn't satisfy the constraint is an error.
+func (pm *Manager) ResolveInclude(spec string) (path string, found bool, err error) {
+	pm.prepare()
+	name, constraint := spec, ""
+	if i := strings.LastIndex(spec, "@"); i > 0 {
+		name, constraint = spec[:i], spec[i+1:]
+	}
+	if !validPackageName(name) || strings.HasSuffix(name, ".sqd") || strings.HasSuffix(name, ".sqx") {
+		return "", false, nil
+	}
+
+	packagePath := filepath.Join(pm.packageDir, name)
+	meta, _ := ReadMetadata(packagePath)
+	mainFile := filepath.Join(packagePath, meta.EntryPoint())
+	if _, statErr := os.Stat(mainFile); statErr != nil {
+		if constraint != "" {
+			return "", false, fmt.Errorf("Package '%s' is not installed (required '%s')", name, constraint)
+		}
+		return "", false, nil
+	}
+
+	if constraint != "" {
+		want, err := ParseConstraint(constraint)
+		if err != nil {
+			return "", false, err
+		}
+		if _, _, err := pm.installedSatisfying(name, want); err != nil {
+			return "", false, err
+		}
+	}
+
+	return mainFile, true, nil
+}
+
+// out returns where the manager reports its progress.
+func (pm *Manager) out() io.Writer {
+	if pm.Out == nil {
+		return os.Stdout
+	}
+	return pm.Out
+}
+
 func (pm *Manager) GetPackagePath(name string) (string, error) {
+	pm.prepare()
 	packagePath := filepath.Join(pm.packageDir, name)
 
 	if _, err := os.Stat(packagePath); os.IsNotExist(err) {
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageMetadataRoundTrip(t *testing.T) {
+	pm := newTestManager(t)
+	if err := os.MkdirAll(pm.packageDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	description := `Say "hi", {braces} and \backslashes\`
+	if err := pm.CreatePackage("greeter", description); err != nil {
+		t.Fatalf("CreatePackage failed: %v", err)
+	}
+
+	packages, err := pm.ListPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 {
+		t.Fatalf("expected 1 package, got %d", len(packages))
+	}
+	if packages[0].Description != description {
+		t.Fatalf("description was mangled: got %q, want %q", packages[0].Description, description)
+	}
+	if packages[0].Version != "1.0.0" || packages[0].Main != "main.sqd" {
+		t.Fatalf("unexpected version/main: %q %q", packages[0].Version, packages[0].Main)
+	}
+}
+
+func TestPackageMetadataFields(t *testing.T) {
+	pm := newTestManager(t)
+	dir := filepath.Join(pm.packageDir, "tools")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := `{
+		"name": "tools",
+		"version": "0.3.1",
+		"description": "helpers",
+		"main": "src/tools.sqd",
+		"dependencies": {"strutil": "^1.0"},
+		"scripts": {"test": "squ1dcc tests.sqd"}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "package.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "src", "tools.sqd"), []byte("var t = 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := pm.loadPackage("tools", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Main != "src/tools.sqd" || p.Dependencies["strutil"] != "^1.0" || p.Scripts["test"] != "squ1dcc tests.sqd" {
+		t.Fatalf("metadata fields not loaded: %+v", p)
+	}
+
+	path, found, err := pm.ResolveInclude("tools")
+	if err != nil || !found || path != filepath.Join(dir, "src", "tools.sqd") {
+		t.Fatalf("expected include to use the main entry point, got %q %v %v", path, found, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"name": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.loadPackage("tools", dir); err == nil {
+		t.Fatalf("expected malformed package.json to be reported")
+	}
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// metadataFile is the package manifest kept at the root of every package.
+const metadataFile = "package.json"
+
+// defaultMain is the entry point used when package.json doesn't name one.
+const defaultMain = "main.sqd"
+
+// Metadata is the contents of package.json.
+type Metadata struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	Description  string            `json:"description"`
+	Main         string            `json:"main,omitempty"`
+	Files        []string          `json:"files,omitempty"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+	Scripts      map[string]string `json:"scripts,omitempty"`
+}
+
+// EntryPoint returns the file loaded when the package is included.
+func (m *Metadata) EntryPoint() string {
+	if m == nil || m.Main == "" {
+		return defaultMain
+	}
+	return m.Main
+}
+
+// ReadMetadata loads dir/package.json. A missing file is reported with an
+// error satisfying os.IsNotExist.
+func ReadMetadata(dir string) (*Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, metadataFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", metadataFile, err)
+	}
+	return &m, nil
+}
+
+// WriteMetadata writes m to dir/package.json.
+func WriteMetadata(dir string, m *Metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, metadataFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Failed to create %s: %v", metadataFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// legacyPackageDir is where the old include loader looked for packages,
+// either as name.sqd files or as name/__init__.sqd directories.
+func legacyPackageDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".cache", "squ1dlang")
+}
+
+// migrateLegacyPackages moves packages from the old loader directory into
+// packageDir, writing a package.json for each so includes keep resolving,
+// and reports each one to out. Packages that already exist in packageDir
+// are left where they are. The builder's cache shares the legacy directory
+// and is never touched.
+func migrateLegacyPackages(legacyDir, packageDir string, out io.Writer) []string {
+	if legacyDir == "" || filepath.Clean(legacyDir) == filepath.Clean(packageDir) {
+		return nil
+	}
+	entries, err := os.ReadDir(legacyDir)
+	if err != nil {
+		return nil
+	}
+
+	var migrated []string
+	for _, entry := range entries {
+		name, main := legacyPackage(legacyDir, entry)
+		if name == "" {
+			continue
+		}
+		target := filepath.Join(packageDir, name)
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+
+		if err := migratePackage(filepath.Join(legacyDir, entry.Name()), target, name, main, entry.IsDir()); err != nil {
+			fmt.Fprintf(out, "Failed to migrate package '%s' from %s: %v\n", name, legacyDir, err)
+			continue
+		}
+		fmt.Fprintf(out, "Package '%s' migrated from %s to %s\n", name, legacyDir, target)
+		migrated = append(migrated, name)
+	}
+	return migrated
+}
+
+// legacyPackage reports the package name and entry point of a legacy loader
+// entry, or an empty name if the entry isn't a package.
+func legacyPackage(legacyDir string, entry os.DirEntry) (name, main string) {
+	if !entry.IsDir() {
+		name = strings.TrimSuffix(entry.Name(), ".sqd")
+		if name == entry.Name() || !validPackageName(name) {
+			return "", ""
+		}
+		return name, defaultMain
+	}
+
+	name = entry.Name()
+	if name == "build" || !validPackageName(name) {
+		return "", ""
+	}
+	for _, main := range []string{"__init__.sqd", defaultMain} {
+		if _, err := os.Stat(filepath.Join(legacyDir, name, main)); err == nil {
+			return name, main
+		}
+	}
+	return "", ""
+}
+
+func migratePackage(from, to, name, main string, isDir bool) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	if isDir {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(to, 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(from, filepath.Join(to, main)); err != nil {
+			os.Remove(to)
+			return err
+		}
+	}
+
+	if _, err := ReadMetadata(to); err == nil || !os.IsNotExist(err) {
+		return nil
+	}
+	return WriteMetadata(to, &Metadata{
+		Name:    name,
+		Version: "0.0.0",
+		Main:    main,
+		Files:   []string{main},
+	})
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateLegacyPackages(t *testing.T) {
+	legacy := filepath.Join(t.TempDir(), "squ1dlang")
+	pm := newTestManager(t)
+
+	for path, content := range map[string]string{
+		"single.sqd":          "var single = 1;",
+		"dirlib/__init__.sqd": "var dirlib = 2;",
+		"existing.sqd":        "var old = 3;",
+		"build/abc.stamp":     "",
+		"notes.txt":           "not a package",
+	} {
+		full := filepath.Join(legacy, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(pm.packageDir, "existing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated := migrateLegacyPackages(legacy, pm.packageDir, io.Discard)
+	if len(migrated) != 2 || migrated[0] != "dirlib" || migrated[1] != "single" {
+		t.Fatalf("expected dirlib and single to be migrated, got %v", migrated)
+	}
+
+	for spec, main := range map[string]string{"single": "main.sqd", "dirlib": "__init__.sqd"} {
+		path, found, err := pm.ResolveInclude(spec)
+		if err != nil || !found || filepath.Base(path) != main {
+			t.Fatalf("expected %s to resolve to %s after migration, got %q %v %v", spec, main, path, found, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "existing.sqd")); err != nil {
+		t.Fatalf("packages already in the package root must not be overwritten")
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "build", "abc.stamp")); err != nil {
+		t.Fatalf("the build cache must be left alone")
+	}
+}
+
+func TestManagerMigratesOnFirstUse(t *testing.T) {
+	legacy := filepath.Join(t.TempDir(), "squ1dlang")
+	if err := os.MkdirAll(legacy, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacy, "single.sqd"), []byte("var single = 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	pm := newTestManager(t)
+	pm.legacyDir, pm.Out = legacy, &out
+	if _, err := os.Stat(filepath.Join(legacy, "single.sqd")); err != nil {
+		t.Fatalf("expected nothing to move before the manager is used")
+	}
+
+	if _, found, err := pm.ResolveInclude("single"); err != nil || !found {
+		t.Fatalf("expected the legacy package to resolve, got %v %v", found, err)
+	}
+	if !strings.Contains(out.String(), "Package 'single' migrated") {
+		t.Fatalf("expected the migration to be reported to Out, got %q", out.String())
+	}
+}
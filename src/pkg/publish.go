@@ -0,0 +1,319 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PublishRequest is the body POSTed to <registry>/publish by HTTP registries.
+type PublishRequest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Integrity   string `json:"integrity"`
+	// Tarball is the base64-encoded .tar.gz of the package.
+	Tarball string `json:"tarball"`
+}
+
+// integrityString formats a SHA-256 digest as a subresource-integrity value.
+func integrityString(sum []byte) string {
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// PackTarball builds a gzipped tarball of the package in dir. Only package.json,
+// the entry point and the paths listed in "files" (files, directories or glob
+// patterns) are included. The archive is reproducible: entries are sorted and
+// carry no timestamps or owners.
+func PackTarball(dir string) ([]byte, *Metadata, error) {
+	meta, err := ReadMetadata(dir)
+	if os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("No %s in %s", metadataFile, dir)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !validPackageName(meta.Name) {
+		return nil, nil, fmt.Errorf("Invalid package name '%s'", meta.Name)
+	}
+	if _, err := ParseVersion(meta.Version); err != nil {
+		return nil, nil, err
+	}
+
+	files, err := packageFiles(dir, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			return nil, nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), meta, nil
+}
+
+// packageFiles lists the slash-separated paths, relative to dir, that make up
+// the published package.
+func packageFiles(dir string, meta *Metadata) ([]string, error) {
+	seen := map[string]bool{}
+	add := func(path string) error {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return fmt.Errorf("File '%s' is outside the package", path)
+		}
+		seen[filepath.ToSlash(rel)] = true
+		return nil
+	}
+
+	patterns := append([]string{metadataFile, meta.EntryPoint()}, meta.Files...)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid files pattern '%s': %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("Package file '%s' not found", pattern)
+		}
+
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					if path != match && strings.HasPrefix(info.Name(), ".") {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				return add(path)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	files := make([]string, 0, len(seen))
+	for name := range seen {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Publish packs the package in dir and adds it to the registry. Registries
+// on disk get the tarball written under packages/ next to the index, which
+// is updated in place; HTTP registries receive a PublishRequest at
+// <registry>/publish, authorized by $SQU1D_REGISTRY_TOKEN when set. A
+// version can only be published once.
+func (r *Registry) Publish(dir string) (*RegistryEntry, error) {
+	tarball, meta, err := PackTarball(dir)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(tarball)
+	integrity := integrityString(sum[:])
+	version, _ := ParseVersion(meta.Version)
+
+	defer func() {
+		r.mu.Lock()
+		r.index = nil
+		r.mu.Unlock()
+	}()
+
+	if isRemoteRegistry(r.URL) {
+		return r.publishRemote(meta, version, tarball, integrity)
+	}
+	return r.publishLocal(meta, version, tarball, integrity)
+}
+
+func isRemoteRegistry(registry string) bool {
+	lower := strings.ToLower(registry)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+func (r *Registry) publishLocal(meta *Metadata, version Version, tarball []byte, integrity string) (*RegistryEntry, error) {
+	indexPath := strings.TrimPrefix(r.URL, "file://")
+
+	var index RegistryIndex
+	data, err := os.ReadFile(indexPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read registry %s: %v", r.URL, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("Invalid registry index at %s: %v", r.URL, err)
+		}
+	}
+
+	var entry *RegistryEntry
+	for i := range index.Packages {
+		if strings.EqualFold(index.Packages[i].Name, meta.Name) {
+			entry = &index.Packages[i]
+		}
+	}
+	if entry == nil {
+		index.Packages = append(index.Packages, RegistryEntry{Name: meta.Name})
+		entry = &index.Packages[len(index.Packages)-1]
+	}
+	if _, ok := entry.Dist[version.String()]; ok {
+		return nil, fmt.Errorf("Package '%s' %s is already published", meta.Name, version)
+	}
+
+	tarballName := path.Join("packages", fmt.Sprintf("%s-%s.tar.gz", meta.Name, version))
+	tarballPath := filepath.Join(filepath.Dir(indexPath), filepath.FromSlash(tarballName))
+	if err := os.MkdirAll(filepath.Dir(tarballPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(tarballPath, tarball, 0644); err != nil {
+		return nil, fmt.Errorf("Failed to write %s: %v", tarballPath, err)
+	}
+
+	if entry.Dist == nil {
+		entry.Dist = map[string]Dist{}
+	}
+	entry.Dist[version.String()] = Dist{Tarball: tarballName, Integrity: integrity}
+	entry.Versions = append(entry.Versions, version.String())
+	sort.Slice(entry.Versions, func(i, j int) bool {
+		a, _ := ParseVersion(entry.Versions[i])
+		b, _ := ParseVersion(entry.Versions[j])
+		return a.Compare(b) < 0
+	})
+	if latest, err := ParseVersion(entry.Version); err != nil || version.Compare(latest) > 0 {
+		entry.Version = version.String()
+		entry.Description = meta.Description
+	}
+	published := *entry
+
+	out, err := json.MarshalIndent(&index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(indexPath, append(out, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("Failed to update registry %s: %v", r.URL, err)
+	}
+
+	fmt.Fprintf(r.out(), "Package '%s' %s published to %s\n", meta.Name, version, r.URL)
+	return &published, nil
+}
+
+func (r *Registry) publishRemote(meta *Metadata, version Version, tarball []byte, integrity string) (*RegistryEntry, error) {
+	endpoint, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid registry URL %s: %v", r.URL, err)
+	}
+	endpoint = endpoint.ResolveReference(&url.URL{Path: "publish"})
+
+	body, err := json.Marshal(PublishRequest{
+		Name:        meta.Name,
+		Version:     version.String(),
+		Description: meta.Description,
+		Integrity:   integrity,
+		Tarball:     base64.StdEncoding.EncodeToString(tarball),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := strings.TrimSpace(os.Getenv("SQU1D_REGISTRY_TOKEN")); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to reach registry %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Registry rejected %s %s: %s %s", meta.Name, version, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	fmt.Fprintf(r.out(), "Package '%s' %s published to %s\n", meta.Name, version, r.URL)
+	return &RegistryEntry{
+		Name:        meta.Name,
+		Version:     version.String(),
+		Description: meta.Description,
+		Dist:        map[string]Dist{version.String(): {Integrity: integrity}},
+	}, nil
+}
+
+// resolveDist picks the highest published tarball of entry that satisfies
+// constraint. ok is false when the entry has no published tarballs.
+func (r *Registry) resolveDist(entry *RegistryEntry, constraint string) (dist Dist, ok bool, err error) {
+	if len(entry.Dist) == 0 {
+		return Dist{}, false, nil
+	}
+
+	want, err := ParseConstraint(constraint)
+	if err != nil {
+		return Dist{}, false, err
+	}
+	keys := map[Version]string{}
+	var versions []Version
+	for s := range entry.Dist {
+		if v, err := ParseVersion(s); err == nil {
+			keys[v] = s
+			versions = append(versions, v)
+		}
+	}
+	best, found := want.MaxSatisfying(versions)
+	if !found {
+		return Dist{}, false, fmt.Errorf("No published version of '%s' satisfies '%s'", entry.Name, want)
+	}
+
+	dist = entry.Dist[keys[best]]
+	if isRemoteRegistry(dist.Tarball) || filepath.IsAbs(dist.Tarball) {
+		return dist, true, nil
+	}
+	if isRemoteRegistry(r.URL) {
+		base, err := url.Parse(r.URL)
+		if err != nil {
+			return Dist{}, false, err
+		}
+		ref, err := url.Parse(dist.Tarball)
+		if err != nil {
+			return Dist{}, false, err
+		}
+		dist.Tarball = base.ResolveReference(ref).String()
+	} else {
+		dist.Tarball = filepath.Join(filepath.Dir(strings.TrimPrefix(r.URL, "file://")), filepath.FromSlash(dist.Tarball))
+	}
+	return dist, true, nil
+}
@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePackage creates a publishable package directory.
+func writePackage(t *testing.T, version string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"package.json":    `{"name": "pubkit", "version": "` + version + `", "description": "Published", "files": ["lib"]}`,
+		"main.sqd":        `var version = "` + version + `";`,
+		"lib/helpers.sqd": "var helper = 1;",
+		"lib/.hidden/x":   "skipped",
+		"notes.txt":       "not listed in files",
+	} {
+		full := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func tarballNames(t *testing.T, data []byte) []string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestPackTarballRespectsFiles(t *testing.T) {
+	dir := writePackage(t, "1.0.0")
+	data, meta, err := PackTarball(dir)
+	if err != nil {
+		t.Fatalf("PackTarball failed: %v", err)
+	}
+	if meta.Name != "pubkit" {
+		t.Fatalf("unexpected metadata: %+v", meta)
+	}
+	if got := strings.Join(tarballNames(t, data), ","); got != "lib/helpers.sqd,main.sqd,package.json" {
+		t.Fatalf("unexpected tarball contents: %s", got)
+	}
+
+	again, _, err := PackTarball(dir)
+	if err != nil || !bytes.Equal(data, again) {
+		t.Fatalf("expected reproducible tarballs")
+	}
+}
+
+func TestPublishToLocalRegistryAndInstall(t *testing.T) {
+	index := filepath.Join(t.TempDir(), "index.json")
+	SetRegistry(index)
+	t.Cleanup(func() { SetRegistry("") })
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if _, err := GlobalRegistry.Publish(writePackage(t, version)); err != nil {
+			t.Fatalf("publish %s failed: %v", version, err)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	if status := Run([]string{"publish", writePackage(t, "2.0.0")}, &stdout, &stderr); status != 0 {
+		t.Fatalf("pkg publish 2.0.0 failed: %s", stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "Package 'pubkit' 2.0.0 published to ") {
+		t.Fatalf("expected pkg publish to report to stdout, got %q", stdout.String())
+	}
+	if _, err := GlobalRegistry.Publish(writePackage(t, "1.1.0")); err == nil {
+		t.Fatalf("expected republishing a version to fail")
+	}
+
+	entry, err := GlobalRegistry.Info("pubkit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Version != "2.0.0" || strings.Join(entry.Versions, ",") != "1.0.0,1.1.0,2.0.0" {
+		t.Fatalf("unexpected registry entry: %+v", entry)
+	}
+	dist := entry.Dist["1.1.0"]
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(index), dist.Tarball))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if dist.Integrity != "sha256-"+base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("integrity %q does not match the tarball", dist.Integrity)
+	}
+
+	pm := newTestManager(t)
+	p, err := pm.InstallPackage("pubkit@^1.0", "")
+	if err != nil {
+		t.Fatalf("install from registry tarball failed: %v", err)
+	}
+	if p.Version != "1.1.0" {
+		t.Fatalf("expected the highest ^1.0 tarball (1.1.0), got %s", p.Version)
+	}
+	if _, err := os.Stat(filepath.Join(pm.packageDir, "pubkit", "lib", "helpers.sqd")); err != nil {
+		t.Fatalf("expected files from the tarball to be installed: %v", err)
+	}
+
+	// A tampered tarball fails the integrity check.
+	if err := os.WriteFile(filepath.Join(filepath.Dir(index), entry.Dist["2.0.0"].Tarball), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestManager(t).InstallPackage("pubkit", ""); err == nil || !strings.Contains(err.Error(), "Integrity") {
+		t.Fatalf("expected an integrity failure, got %v", err)
+	}
+}
+
+func TestPublishToHTTPRegistry(t *testing.T) {
+	var got PublishRequest
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/publish" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	t.Setenv("SQU1D_REGISTRY_TOKEN", "secret")
+	r := NewRegistry(server.URL + "/index.json")
+	var out bytes.Buffer
+	r.Out = &out
+	if _, err := r.Publish(writePackage(t, "0.3.0")); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	if out.String() != "Package 'pubkit' 0.3.0 published to "+r.URL+"\n" {
+		t.Fatalf("expected the publish to be reported to the registry's writer, got %q", out.String())
+	}
+
+	if got.Name != "pubkit" || got.Version != "0.3.0" || auth != "Bearer secret" {
+		t.Fatalf("unexpected publish request: %+v (auth %q)", got, auth)
+	}
+	tarball, err := base64.StdEncoding.DecodeString(got.Tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(tarball); got.Integrity != integrityString(sum[:]) {
+		t.Fatalf("integrity %q does not match the uploaded tarball", got.Integrity)
+	}
+}
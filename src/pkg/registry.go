@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRegistry is the package index used when neither SetRegistry nor
+// $SQU1D_REGISTRY selects another one.
+const DefaultRegistry = "https://raw.githubusercontent.com/SQU1DMAN6/squ1d-registry/main/index.json"
+
+// registryCacheTTL is how long a fetched index is reused within a process.
+const registryCacheTTL = 5 * time.Minute
+
+// RegistryEntry describes one package in the registry index.
+type RegistryEntry struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Version     string   `json:"version"`
+	Versions    []string `json:"versions,omitempty"`
+	Source      string   `json:"source"`
+	Author      string   `json:"author,omitempty"`
+	Homepage    string   `json:"homepage,omitempty"`
+	License     string   `json:"license,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	// Dist maps versions published with pkg.publish to their tarballs.
+	Dist map[string]Dist `json:"dist,omitempty"`
+}
+
+// Dist is a published package tarball. Tarball may be relative to the
+// registry index.
+type Dist struct {
+	Tarball   string `json:"tarball"`
+	Integrity string `json:"integrity"`
+}
+
+// RegistryIndex is the document served by a registry: a JSON object with a
+// "packages" array.
+type RegistryIndex struct {
+	Packages []RegistryEntry `json:"packages"`
+}
+
+// Registry is a client for an HTTP(S) package index. The URL may also be a
+// local path or file:// URL, for mirrors and offline use.
+type Registry struct {
+	URL string
+	// Out receives the messages confirming published packages; os.Stdout
+	// when nil.
+	Out io.Writer
+
+	mu        sync.Mutex
+	index     *RegistryIndex
+	fetchedAt time.Time
+}
+
+// out returns where the registry reports what it published.
+func (r *Registry) out() io.Writer {
+	if r.Out == nil {
+		return os.Stdout
+	}
+	return r.Out
+}
+
+// registryURL is the registry chosen with SetRegistry.
+var registryURL = ""
+
+// SetRegistry selects the registry used by pkg.search, pkg.info and
+// installs by name. An empty url restores the default.
+func SetRegistry(url string) {
+	registryURL = strings.TrimSpace(url)
+	GlobalRegistry = NewRegistry(CurrentRegistryURL())
+}
+
+// CurrentRegistryURL returns the registry in effect: SetRegistry, then
+// $SQU1D_REGISTRY, then DefaultRegistry.
+func CurrentRegistryURL() string {
+	if registryURL != "" {
+		return registryURL
+	}
+	if env := strings.TrimSpace(os.Getenv("SQU1D_REGISTRY")); env != "" {
+		return env
+	}
+	return DefaultRegistry
+}
+
+func NewRegistry(url string) *Registry {
+	return &Registry{URL: url}
+}
+
+// Index returns the registry index, fetching it if the cached copy is stale.
+func (r *Registry) Index() (*RegistryIndex, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.index != nil && time.Since(r.fetchedAt) < registryCacheTTL {
+		return r.index, nil
+	}
+
+	data, err := r.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	var index RegistryIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("Invalid registry index at %s: %v", r.URL, err)
+	}
+
+	r.index = &index
+	r.fetchedAt = time.Now()
+	return r.index, nil
+}
+
+func (r *Registry) fetch() ([]byte, error) {
+	lower := strings.ToLower(r.URL)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		data, err := os.ReadFile(strings.TrimPrefix(r.URL, "file://"))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read registry %s: %v", r.URL, err)
+		}
+		return data, nil
+	}
+
+	resp, err := httpClient.Get(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to reach registry %s: %v", r.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to reach registry %s: %s", r.URL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Search returns the entries whose name, description or keywords contain
+// term (case-insensitively). Name matches are listed first. An empty term
+// lists every package.
+func (r *Registry) Search(term string) ([]RegistryEntry, error) {
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	term = strings.ToLower(strings.TrimSpace(term))
+	rank := func(e RegistryEntry) int {
+		name := strings.ToLower(e.Name)
+		switch {
+		case term == "" || name == term:
+			return 0
+		case strings.Contains(name, term):
+			return 1
+		case strings.Contains(strings.ToLower(e.Description), term):
+			return 2
+		}
+		for _, k := range e.Keywords {
+			if strings.Contains(strings.ToLower(k), term) {
+				return 2
+			}
+		}
+		return -1
+	}
+
+	var results []RegistryEntry
+	ranks := map[string]int{}
+	for _, e := range index.Packages {
+		if rk := rank(e); rk >= 0 {
+			results = append(results, e)
+			ranks[e.Name] = rk
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if ranks[results[i].Name] != ranks[results[j].Name] {
+			return ranks[results[i].Name] < ranks[results[j].Name]
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results, nil
+}
+
+// Info returns the registry entry for the package called name.
+func (r *Registry) Info(name string) (*RegistryEntry, error) {
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range index.Packages {
+		if strings.EqualFold(index.Packages[i].Name, name) {
+			return &index.Packages[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Package '%s' not found in registry %s", name, r.URL)
+}
+
+var GlobalRegistry = NewRegistry(CurrentRegistryURL())
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testIndex = `{
+  "packages": [
+    {"name": "jsonkit", "version": "1.2.0", "description": "JSON helpers", "source": "github.com/example/jsonkit", "versions": ["1.0.0", "1.2.0"]},
+    {"name": "strutil", "version": "0.4.0", "description": "String utilities", "source": "github.com/example/strutil", "keywords": ["text", "json"]},
+    {"name": "mathx", "version": "2.0.0", "description": "Extra math", "source": "github.com/example/mathx"}
+  ]
+}`
+
+func TestRegistrySearchAndInfo(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(testIndex))
+	}))
+	defer server.Close()
+
+	r := NewRegistry(server.URL + "/index.json")
+
+	results, err := r.Search("JSON")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	var names []string
+	for _, e := range results {
+		names = append(names, e.Name)
+	}
+	if strings.Join(names, ",") != "jsonkit,strutil" {
+		t.Fatalf("expected name matches before keyword matches, got %v", names)
+	}
+
+	all, err := r.Search("")
+	if err != nil || len(all) != 3 {
+		t.Fatalf("expected an empty term to list everything, got %d (%v)", len(all), err)
+	}
+
+	info, err := r.Info("mathx")
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if info.Version != "2.0.0" || info.Source != "github.com/example/mathx" {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if _, err := r.Info("nope"); err == nil {
+		t.Fatalf("expected unknown package to fail")
+	}
+
+	if requests != 1 {
+		t.Fatalf("expected the index to be fetched once, got %d requests", requests)
+	}
+}
+
+func TestRegistryConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	if err := os.WriteFile(indexFile, []byte(testIndex), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SQU1D_REGISTRY", indexFile)
+	SetRegistry("")
+	defer SetRegistry("")
+
+	if CurrentRegistryURL() != indexFile {
+		t.Fatalf("expected $SQU1D_REGISTRY to select the registry, got %s", CurrentRegistryURL())
+	}
+
+	var out, errOut bytes.Buffer
+	if code := Run([]string{"info", "strutil"}, &out, &errOut); code != 0 {
+		t.Fatalf("pkg info exited %d: %s", code, errOut.String())
+	}
+	if !strings.Contains(out.String(), "strutil 0.4.0") || !strings.Contains(out.String(), "keywords: text, json") {
+		t.Fatalf("unexpected pkg info output:\n%s", out.String())
+	}
+
+	SetRegistry("file://" + indexFile)
+	if _, err := GlobalRegistry.Info("jsonkit"); err != nil {
+		t.Fatalf("expected file:// registries to work: %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version (https://semver.org). Build metadata is
+// accepted when parsing but ignored.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+// ParseVersion parses versions like "1.2.3", "v1.2.3" or "1.2.3-beta.1".
+// Missing minor/patch components default to 0.
+func ParseVersion(s string) (Version, error) {
+	v, _, err := parsePartialVersion(s)
+	return v, err
+}
+
+// parsePartialVersion parses a possibly incomplete version and also returns
+// how many numeric components were given (0 for "*"), so "1.2" can act as
+// the range 1.2.x inside constraints.
+func parsePartialVersion(s string) (Version, int, error) {
+	var v Version
+	raw := s
+
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.Prerelease = s[i+1:]
+		s = s[:i]
+		if v.Prerelease == "" {
+			return v, 0, fmt.Errorf("Invalid version '%s'", raw)
+		}
+	}
+	if s == "" {
+		return v, 0, fmt.Errorf("Invalid version '%s'", raw)
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, 0, fmt.Errorf("Invalid version '%s'", raw)
+	}
+
+	fields := []*int{&v.Major, &v.Minor, &v.Patch}
+	given := 0
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			// Wildcards end the version: "1.x.3" is not meaningful.
+			if i != len(parts)-1 || v.Prerelease != "" {
+				return v, 0, fmt.Errorf("Invalid version '%s'", raw)
+			}
+			break
+		}
+
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, 0, fmt.Errorf("Invalid version '%s'", raw)
+		}
+		*fields[i] = n
+		given++
+	}
+
+	return v, given, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is lower than, equal to or higher than o.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+// comparePrerelease orders prerelease tags per semver: a release is higher
+// than any prerelease; identifiers compare numerically when both are numbers.
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// comparator is a single bound such as ">=1.2.0".
+type comparator struct {
+	op      string
+	version Version
+}
+
+func (c comparator) matches(v Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+// Constraint is a version requirement like "^1.2", "~1.4.0", ">=1.0 <2.0" or
+// "1.x || 2.x". An empty constraint or "*" accepts every version.
+type Constraint struct {
+	raw string
+	// alternatives are OR-ed; the comparators inside each are AND-ed.
+	alternatives [][]comparator
+}
+
+// ParseConstraint parses a version constraint. Supported forms:
+//
+//	1.2.3  =1.2.3        exact version
+//	1.2  1.2.x  1.x  *   any version with the given prefix
+//	^1.2.3               compatible: >=1.2.3 <2.0.0 (<0.3.0 for ^0.2.3)
+//	~1.2.3               patch updates: >=1.2.3 <1.3.0
+//	>1 >=1.2 <2 <=2.1    comparisons
+//	">=1.0, <2.0"        all of (comma or space separated)
+//	"^1.0 || ^2.0"       any of
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{raw: strings.TrimSpace(s)}
+
+	for _, alt := range strings.Split(c.raw, "||") {
+		alt = strings.TrimSpace(alt)
+		var comparators []comparator
+
+		for _, term := range strings.Fields(strings.ReplaceAll(alt, ",", " ")) {
+			parsed, err := parseConstraintTerm(term)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid version constraint '%s': %v", s, err)
+			}
+			comparators = append(comparators, parsed...)
+		}
+
+		c.alternatives = append(c.alternatives, comparators)
+	}
+
+	return c, nil
+}
+
+func parseConstraintTerm(term string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op = prefix
+			term = strings.TrimSpace(term[len(prefix):])
+			break
+		}
+	}
+
+	v, given, err := parsePartialVersion(term)
+	if err != nil {
+		if op == "" && (term == "*" || term == "x" || term == "X") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	switch op {
+	case ">", ">=", "<", "<=":
+		return []comparator{{op, v}}, nil
+
+	case "^":
+		upper := Version{Major: v.Major + 1}
+		switch {
+		case v.Major == 0 && given >= 2 && v.Minor > 0:
+			upper = Version{Minor: v.Minor + 1}
+		case v.Major == 0 && given == 3:
+			upper = Version{Minor: v.Minor, Patch: v.Patch + 1}
+		case v.Major == 0 && given == 2:
+			upper = Version{Minor: v.Minor + 1}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+
+	case "~":
+		upper := Version{Major: v.Major, Minor: v.Minor + 1}
+		if given == 1 {
+			upper = Version{Major: v.Major + 1}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	}
+
+	// Exact or wildcard match.
+	switch given {
+	case 0:
+		return nil, nil
+	case 1:
+		return []comparator{{">=", v}, {"<", Version{Major: v.Major + 1}}}, nil
+	case 2:
+		return []comparator{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+	}
+	return []comparator{{"=", v}}, nil
+}
+
+// Check reports whether v satisfies the constraint.
+func (c *Constraint) Check(v Version) bool {
+	for _, alt := range c.alternatives {
+		ok := true
+		for _, cmp := range alt {
+			if !cmp.matches(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Constraint) String() string {
+	if c.raw == "" {
+		return "*"
+	}
+	return c.raw
+}
+
+// MaxSatisfying returns the highest version that satisfies the constraint.
+func (c *Constraint) MaxSatisfying(versions []Version) (Version, bool) {
+	var best Version
+	found := false
+	for _, v := range versions {
+		if c.Check(v) && (!found || v.Compare(best) > 0) {
+			best = v
+			found = true
+		}
+	}
+	return best, found
+}
+
+// isVersionConstraint reports whether s reads as a constraint rather than a
+// git branch or tag name.
+func isVersionConstraint(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+	if strings.ContainsAny(s, "^~<>=*| ,") || strings.HasSuffix(s, ".x") || s == "x" {
+		_, err := ParseConstraint(s)
+		return err == nil
+	}
+	return false
+}
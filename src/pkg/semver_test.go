@@ -0,0 +1,111 @@
+package pkg
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.2.3", "1.2.3"},
+		{"v1.2.3", "1.2.3"},
+		{"1.2", "1.2.0"},
+		{"2", "2.0.0"},
+		{"1.0.0-beta.2+build.5", "1.0.0-beta.2"},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.input)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q) returned error: %v", tt.input, err)
+		}
+		if v.String() != tt.expected {
+			t.Errorf("ParseVersion(%q) = %s, want %s", tt.input, v, tt.expected)
+		}
+	}
+
+	for _, bad := range []string{"", "1.2.3.4", "one", "1.-2", "1.0.0-"} {
+		if _, err := ParseVersion(bad); err == nil {
+			t.Errorf("ParseVersion(%q) should fail", bad)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	ordered := []string{
+		"0.9.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "1.2.0", "1.10.0", "2.0.0",
+	}
+
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := ParseVersion(ordered[i])
+		b, _ := ParseVersion(ordered[i+1])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Errorf("expected %s < %s", a, b)
+		}
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"^1.2", "1.2.0", true},
+		{"^1.2", "1.9.3", true},
+		{"^1.2", "2.0.0", false},
+		{"^1.2", "1.1.9", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{"1.2", "1.2.7", true},
+		{"1.x", "1.7.0", true},
+		{"1.x", "2.0.0", false},
+		{"*", "9.9.9", true},
+		{"", "0.0.1", true},
+		{">=1.0 <2.0", "1.5.0", true},
+		{">=1.0, <2.0", "2.0.0", false},
+		{"^1.0 || ^3.0", "3.1.0", true},
+		{"^1.0 || ^3.0", "2.1.0", false},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%q) returned error: %v", tt.constraint, err)
+		}
+		v, _ := ParseVersion(tt.version)
+		if got := c.Check(v); got != tt.expected {
+			t.Errorf("%q.Check(%s) = %v, want %v", tt.constraint, v, got, tt.expected)
+		}
+	}
+
+	if _, err := ParseConstraint("^banana"); err == nil {
+		t.Errorf("expected invalid constraint to fail")
+	}
+}
+
+func TestConstraintMaxSatisfying(t *testing.T) {
+	var versions []Version
+	for _, s := range []string{"1.0.0", "1.4.2", "1.10.0", "2.0.0"} {
+		v, _ := ParseVersion(s)
+		versions = append(versions, v)
+	}
+
+	c, _ := ParseConstraint("^1.2")
+	best, ok := c.MaxSatisfying(versions)
+	if !ok || best.String() != "1.10.0" {
+		t.Fatalf("expected 1.10.0, got %s (%v)", best, ok)
+	}
+
+	c, _ = ParseConstraint("^3")
+	if _, ok := c.MaxSatisfying(versions); ok {
+		t.Fatalf("expected no version to satisfy ^3")
+	}
+}
@@ -0,0 +1,146 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+)
+
+// OutdatedEntry reports an installed package for which a newer version is
+// available. Wanted is the highest version within the package's install
+// constraint; Latest is the highest version overall.
+type OutdatedEntry struct {
+	Name    string
+	Current string
+	Wanted  string
+	Latest  string
+}
+
+// Outdated lists locked packages with newer versions available. Packages
+// installed from plain URLs have no version list to compare against and are
+// skipped, as are packages created locally.
+func (pm *Manager) Outdated() ([]OutdatedEntry, error) {
+	lock, err := pm.ReadLockfile()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(lock.Packages))
+	for name := range lock.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var outdated []OutdatedEntry
+	for _, name := range names {
+		entry := lock.Packages[name]
+		if entry.Pinned {
+			continue
+		}
+		current, err := ParseVersion(entry.Version)
+		if err != nil {
+			continue
+		}
+
+		versions, err := availableVersions(entry.Source)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to check '%s' for updates: %v", name, err)
+		}
+
+		every, _ := ParseConstraint("*")
+		latest, ok := every.MaxSatisfying(versions)
+		if !ok || latest.Compare(current) <= 0 {
+			continue
+		}
+
+		wanted := current
+		if want, err := ParseConstraint(entry.Constraint); err == nil {
+			if v, ok := want.MaxSatisfying(versions); ok && v.Compare(current) > 0 {
+				wanted = v
+			}
+		}
+
+		outdated = append(outdated, OutdatedEntry{
+			Name:    name,
+			Current: current.String(),
+			Wanted:  wanted.String(),
+			Latest:  latest.String(),
+		})
+	}
+	return outdated, nil
+}
+
+// UpdatePackage re-installs a locked package at the highest version allowed
+// by the constraint it was installed with. It reports whether the package
+// changed. Pinned packages are left alone.
+func (pm *Manager) UpdatePackage(name string) (bool, error) {
+	lock, err := pm.ReadLockfile()
+	if err != nil {
+		return false, err
+	}
+
+	entry, ok := lock.Packages[name]
+	if !ok {
+		return false, fmt.Errorf("Package '%s' is not in %s", name, lockFile)
+	}
+	if entry.Pinned {
+		return false, nil
+	}
+
+	_, changed, err := pm.install(entry.Source, entry.Constraint, true, nil)
+	return changed, err
+}
+
+// UpdateAll updates every locked package and returns the names of those that
+// changed. It keeps going after a failure and returns the first error.
+func (pm *Manager) UpdateAll() ([]string, error) {
+	lock, err := pm.ReadLockfile()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(lock.Packages))
+	for name := range lock.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var updated []string
+	var firstErr error
+	for _, name := range names {
+		changed, err := pm.UpdatePackage(name)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if changed {
+			updated = append(updated, name)
+		}
+	}
+	return updated, firstErr
+}
+
+// availableVersions lists the versions a lock source can be updated to.
+func availableVersions(source string) ([]Version, error) {
+	if isRegistryName(source) {
+		entry, err := GlobalRegistry.Info(source)
+		if err != nil {
+			return nil, err
+		}
+		if isGitSource(entry.Source) {
+			return availableVersions(entry.Source)
+		}
+
+		var versions []Version
+		for _, s := range append(entry.Versions, entry.Version) {
+			if v, err := ParseVersion(s); err == nil {
+				versions = append(versions, v)
+			}
+		}
+		return versions, nil
+	}
+
+	if isGitSource(source) {
+		_, versions, err := gitTagVersions(source)
+		return versions, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTaggedRepo creates a git repository at dir/name.git with one commit per
+// tag, returning the repository path and a function that adds more tags.
+func newTaggedRepo(t *testing.T, name string, tags ...string) (string, func(tags ...string)) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := filepath.Join(t.TempDir(), name+".git")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	addTags := func(tags ...string) {
+		t.Helper()
+		for _, tag := range tags {
+			if err := os.WriteFile(filepath.Join(repo, "main.sqd"), []byte("var version = \""+tag+"\";"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			git("add", ".")
+			git("commit", "-q", "-m", tag)
+			git("tag", tag)
+		}
+	}
+
+	git("init", "-q")
+	addTags(tags...)
+	return repo, addTags
+}
+
+func TestOutdatedAndUpdate(t *testing.T) {
+	repo, addTags := newTaggedRepo(t, "uplib", "v1.0.0", "v1.3.0")
+	pinnedRepo, addPinnedTags := newTaggedRepo(t, "pinlib", "v1.0.0")
+
+	pm := newTestManager(t)
+	if _, err := pm.InstallPackage(repo, "^1.0"); err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	if _, err := pm.InstallPackage(pinnedRepo+"@v1.0.0", ""); err != nil {
+		t.Fatalf("pinned install failed: %v", err)
+	}
+
+	outdated, err := pm.Outdated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outdated) != 0 {
+		t.Fatalf("expected nothing outdated yet, got %+v", outdated)
+	}
+
+	addTags("v1.4.0", "v2.0.0")
+	addPinnedTags("v1.1.0")
+
+	outdated, err = pm.Outdated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OutdatedEntry{Name: "uplib", Current: "1.3.0", Wanted: "1.4.0", Latest: "2.0.0"}
+	if len(outdated) != 1 || outdated[0] != want {
+		t.Fatalf("expected %+v, got %+v", want, outdated)
+	}
+
+	updated, err := pm.UpdateAll()
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if len(updated) != 1 || updated[0] != "uplib" {
+		t.Fatalf("expected only uplib to be updated, got %v", updated)
+	}
+
+	main, err := os.ReadFile(filepath.Join(pm.packageDir, "uplib", "main.sqd"))
+	if err != nil || !strings.Contains(string(main), "v1.4.0") {
+		t.Fatalf("expected v1.4.0 sources after update, got %q (%v)", main, err)
+	}
+	lock, err := pm.ReadLockfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := lock.Packages["uplib"]; entry.Version != "1.4.0" || entry.Constraint != "^1.0" {
+		t.Fatalf("expected the lockfile to record uplib 1.4.0 within ^1.0, got %+v", entry)
+	}
+	if entry := lock.Packages["pinlib"]; !entry.Pinned || entry.Version != "1.0.0" {
+		t.Fatalf("expected pinlib to stay pinned at 1.0.0, got %+v", entry)
+	}
+
+	if changed, err := pm.UpdatePackage("uplib"); err != nil || changed {
+		t.Fatalf("expected a second update to be a no-op, got %v %v", changed, err)
+	}
+	if _, err := pm.UpdatePackage("missing"); err == nil {
+		t.Fatalf("expected updating an unknown package to fail")
+	}
+
+	if err := pm.RemovePackage("uplib"); err != nil {
+		t.Fatal(err)
+	}
+	if lock, _ := pm.ReadLockfile(); len(lock.Packages) != 1 {
+		t.Fatalf("expected removal to drop the lock entry, got %+v", lock.Packages)
+	}
+}
@@ -0,0 +1,96 @@
+package profile
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"squ1d++/repl"
+)
+
+// topFunctions is how many functions the summary lists.
+const topFunctions = 10
+
+// Run implements `squ1dcc profile [--interval d] [-o out.folded] file.sqd`:
+// it runs the file while sampling its call stack, writes the samples in
+// folded-stack format and prints the functions that took the most samples.
+// The exit status is 1 when the program fails, after the profile is written.
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(stderr) }
+
+	interval := fs.Duration("interval", DefaultInterval, "Time between samples")
+	output := fs.String("o", "profile.folded", "Write the folded stacks to this file")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *interval <= 0 {
+		printUsage(stderr)
+		return 2
+	}
+	file := fs.Arg(0)
+
+	p := New(*interval)
+	p.Start()
+	runErr := repl.ExecuteFile(file, stdout)
+	p.Stop()
+	if runErr != nil {
+		fmt.Fprintf(stderr, "Error executing file %s: %v\n", file, runErr)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(stderr, "profile: %v\n", err)
+		return 1
+	}
+	err = p.WriteFolded(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "profile: %v\n", err)
+		return 1
+	}
+
+	WriteSummary(stdout, p, topFunctions)
+	fmt.Fprintf(stdout, "Wrote %d samples to %s\n", p.Total(), *output)
+
+	if runErr != nil {
+		return 1
+	}
+	return 0
+}
+
+// WriteSummary prints the n functions with the most samples of their own,
+// with their share of all samples.
+func WriteSummary(w io.Writer, p *Profile, n int) {
+	total := p.Total()
+	if total == 0 {
+		fmt.Fprintln(w, "\nNo samples; the program ran for less than the sampling interval.")
+		return
+	}
+
+	fmt.Fprintf(w, "\n%8s %7s %8s %7s  %s\n", "self", "self%", "total", "total%", "function")
+	functions := p.Functions()
+	for _, f := range functions[:min(n, len(functions))] {
+		fmt.Fprintf(w, "%8d %6.1f%% %8d %6.1f%%  %s\n",
+			f.Self, percent(f.Self, total), f.Total, percent(f.Total, total), f.Name)
+	}
+}
+
+func percent(n, total int) float64 {
+	return 100 * float64(n) / float64(total)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: squ1dcc profile [--interval 1ms] [-o profile.folded] file.sqd")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Runs file.sqd while sampling its call stack and writes the samples as")
+	fmt.Fprintln(w, "folded stacks, the input format of flame graph tools such as")
+	fmt.Fprintln(w, "flamegraph.pl and speedscope.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "  --interval d   time between samples (default 1ms)")
+	fmt.Fprintln(w, "  -o file        where to write the folded stacks (default profile.folded)")
+}
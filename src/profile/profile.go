@@ -0,0 +1,143 @@
+// Package profile is a sampling profiler for SQU1DLang programs. It samples
+// the call stack of the running VM at a fixed interval and writes the
+// samples in the folded-stack format read by flame graph tools such as
+// flamegraph.pl, speedscope and inferno.
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"squ1d++/vm"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is the default time between samples.
+const DefaultInterval = time.Millisecond
+
+// Profile holds the samples of one run, counted per call stack.
+type Profile struct {
+	// Interval is the time between samples.
+	Interval time.Duration
+
+	mu      sync.Mutex
+	samples map[string]int
+	stop    func()
+}
+
+// New returns an empty profile sampling every interval.
+func New(interval time.Duration) *Profile {
+	return &Profile{Interval: interval, samples: map[string]int{}}
+}
+
+// Start starts sampling the VMs that run until Stop is called.
+func (p *Profile) Start() {
+	p.stop = vm.StartSampling(p.Interval, p.Add)
+}
+
+// Stop stops sampling.
+func (p *Profile) Stop() {
+	if p.stop != nil {
+		p.stop()
+		p.stop = nil
+	}
+}
+
+// Add records n samples of stack, which lists functions outermost first.
+func (p *Profile) Add(stack []string, n int) {
+	names := make([]string, len(stack))
+	for i, name := range stack {
+		// ';' separates frames in the folded format.
+		names[i] = strings.ReplaceAll(name, ";", ":")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples[strings.Join(names, ";")] += n
+}
+
+// Total returns the number of samples taken.
+func (p *Profile) Total() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := 0
+	for _, n := range p.samples {
+		total += n
+	}
+	return total
+}
+
+// WriteFolded writes one line per call stack, "main.sqd;f;g 12", sorted by
+// stack.
+func (p *Profile) WriteFolded(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stacks := make([]string, 0, len(p.samples))
+	for stack := range p.samples {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, p.samples[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function is the share of the samples of one function.
+type Function struct {
+	Name string
+	// Self counts the samples taken in the function itself and Total those
+	// taken in it or in functions it called.
+	Self  int
+	Total int
+}
+
+// Functions returns the sampled functions, the most expensive first.
+func (p *Profile) Functions() []Function {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	byName := map[string]*Function{}
+	get := func(name string) *Function {
+		f := byName[name]
+		if f == nil {
+			f = &Function{Name: name}
+			byName[name] = f
+		}
+		return f
+	}
+	for stack, n := range p.samples {
+		names := strings.Split(stack, ";")
+		get(names[len(names)-1]).Self += n
+		// Recursive functions count once per sample.
+		seen := map[string]bool{}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				get(name).Total += n
+			}
+		}
+	}
+
+	functions := make([]Function, 0, len(byName))
+	for _, f := range byName {
+		functions = append(functions, *f)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		a, b := functions[i], functions[j]
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Name < b.Name
+	})
+	return functions
+}
@@ -0,0 +1,66 @@
+package profile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFoldedAndFunctions(t *testing.T) {
+	p := New(time.Millisecond)
+	p.Add([]string{"main.sqd", "work", "fib"}, 3)
+	p.Add([]string{"main.sqd", "work", "fib", "fib"}, 2)
+	p.Add([]string{"main.sqd", "work"}, 1)
+	p.Add([]string{"main.sqd", "a;b"}, 1)
+	p.Add([]string{"main.sqd", "work"}, 1)
+
+	var out bytes.Buffer
+	if err := p.WriteFolded(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "main.sqd;a:b 1\nmain.sqd;work 2\nmain.sqd;work;fib 3\nmain.sqd;work;fib;fib 2\n"
+	if out.String() != want {
+		t.Fatalf("expected folded stacks:\n%s\ngot:\n%s", want, out.String())
+	}
+	if p.Total() != 8 {
+		t.Fatalf("expected 8 samples, got %d", p.Total())
+	}
+
+	functions := p.Functions()
+	if len(functions) != 4 {
+		t.Fatalf("expected 4 functions, got %v", functions)
+	}
+	if f := functions[0]; f != (Function{Name: "fib", Self: 5, Total: 5}) {
+		t.Fatalf("expected fib first with 5 self and total samples, got %+v", f)
+	}
+	if f := functions[1]; f != (Function{Name: "work", Self: 2, Total: 7}) {
+		t.Fatalf("expected work second, got %+v", f)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.sqd")
+	if err := os.WriteFile(file, []byte("io.echo(\"hi\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.folded")
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"-o", output, file}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "hi") || !strings.Contains(stdout.String(), "samples to "+output) {
+		t.Fatalf("expected program output and summary, got:\n%s", stdout.String())
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("expected the folded stacks to be written: %v", err)
+	}
+
+	if code := Run([]string{"--interval", "0", file}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit 2 for a bad interval, got %d", code)
+	}
+}
@@ -0,0 +1,217 @@
+package repl
+
+import (
+	"io"
+	"os"
+	"squ1d++/builder"
+	"squ1d++/object"
+	"squ1d++/pkg"
+	"strings"
+	"testing"
+)
+
+func TestExecuteCompiledFilePrintsLikeExecuteFile(t *testing.T) {
+	source := "var x = 3\nx\nsuppress x + 1\nx = 4\nif (x > 2) { \"big\" }\nvar i = 0\nwhile (i < 3) { i = i + 1 }\n" +
+		"f >> (n) { n * 2 }\nf(x)\nio.echo(\"echo\")\nnull\n[x, \"s\"]\n"
+	t.Chdir(writeFiles(t, map[string]string{"main.sqd": source}))
+
+	var want, got strings.Builder
+	if err := ExecuteFile("main.sqd", &want); err != nil {
+		t.Fatalf("ExecuteFile returned error: %v\noutput: %q", err, want.String())
+	}
+	if err := ExecuteCompiledFile("main.sqd", &got); err != nil {
+		t.Fatalf("ExecuteCompiledFile returned error: %v\noutput: %q", err, got.String())
+	}
+	if got.String() != want.String() {
+		t.Fatalf("compiled output differs.\nwant: %q\ngot:  %q", want.String(), got.String())
+	}
+}
+
+func TestLoopsLeftWithBreakPrintNothing(t *testing.T) {
+	source := "var n = 0\nfor (;;) { n = n + 1; if (n == 3) { break } }\nwhile (true) { if (n > 1) { break } }\nn\n"
+	t.Chdir(writeFiles(t, map[string]string{"main.sqd": source}))
+
+	for name, execute := range map[string]func(string, io.Writer) error{
+		"ExecuteFile":         ExecuteFile,
+		"ExecuteCompiledFile": ExecuteCompiledFile,
+	} {
+		var out strings.Builder
+		if err := execute("main.sqd", &out); err != nil {
+			t.Fatalf("%s returned error: %v", name, err)
+		}
+		if out.String() != "3\n" {
+			t.Errorf("%s printed %q, want %q", name, out.String(), "3\n")
+		}
+	}
+}
+
+func TestEchoAndPrintWriteToOutput(t *testing.T) {
+	files := map[string]string{
+		"lib.sqd":  "greet >> (name) { io.echo(\"hi\", name) }\n",
+		"main.sqd": "pkg.include(\"lib.sqd\", \"lib\")\nlib.greet(\"ann\")\nio.echo([1, \"a\"], 2.5)\nio.echo()\nio.print(\"en\")\nio.print(\"d\")\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	for name, execute := range map[string]func(string, io.Writer) error{
+		"ExecuteFile":         ExecuteFile,
+		"ExecuteCompiledFile": ExecuteCompiledFile,
+	} {
+		var out strings.Builder
+		if err := execute("main.sqd", &out); err != nil {
+			t.Fatalf("%s returned error: %v", name, err)
+		}
+		if want := "hi ann\n[1, \"a\"] 2.5\n\nend"; out.String() != want {
+			t.Errorf("%s printed %q, want %q", name, out.String(), want)
+		}
+	}
+}
+
+func TestScriptsUseRuntimeIO(t *testing.T) {
+	source := "var n = io.read(\"n? \")\nvar line = stream.read_line(stream.stdin())\nio.echo(n + 1, line)\n" +
+		"log.warn(\"done\")\nio.read()\n"
+	t.Chdir(writeFiles(t, map[string]string{"main.sqd": source}))
+
+	var stderr strings.Builder
+	restore := object.SetIO(object.RuntimeIO{In: strings.NewReader("41\nlast line"), Err: &stderr})
+	defer restore()
+
+	var out strings.Builder
+	if err := ExecuteCompiledFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteCompiledFile returned error: %v", err)
+	}
+	if want := "n? 42 last line\nERROR: IOError: Failed to read input: EOF\n"; out.String() != want {
+		t.Errorf("printed %q, want %q", out.String(), want)
+	}
+	if !strings.Contains(stderr.String(), "WARN  done") {
+		t.Errorf("expected the log record on stderr, got %q", stderr.String())
+	}
+}
+
+func TestKeyboardReadsRuntimeIO(t *testing.T) {
+	t.Chdir(writeFiles(t, map[string]string{"main.sqd": "io.echo(keyboard.read())\n"}))
+	restore := object.SetIO(object.RuntimeIO{In: strings.NewReader("q\n")})
+	defer restore()
+
+	previous := object.CurrentIO().Out
+	var out strings.Builder
+	if err := ExecuteCompiledFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteCompiledFile returned error: %v", err)
+	}
+	if out.String() != "KeyQ\n" {
+		t.Errorf("expected the key from the runtime input, got %q", out.String())
+	}
+	if object.CurrentIO().Out != previous {
+		t.Errorf("expected the output to be restored after the run")
+	}
+}
+
+func TestPackageMessagesUseRuntimeIO(t *testing.T) {
+	pkg.SetPackageDir(t.TempDir())
+	defer pkg.SetPackageDir("")
+	t.Chdir(writeFiles(t, map[string]string{"main.sqd": "suppress pkg.create(\"demo\", \"A demo\")\n"}))
+
+	var out strings.Builder
+	if err := ExecuteCompiledFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteCompiledFile returned error: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Package 'demo' created successfully at ") {
+		t.Errorf("expected the package manager's message in the output, got %q", out.String())
+	}
+	if pkg.GlobalManager.Out != nil {
+		t.Errorf("expected the package manager's writer to be restored")
+	}
+}
+
+func TestExecuteCompiledFileRunsIncludesOnVM(t *testing.T) {
+	files := map[string]string{
+		"lib.sqd":  "double >> (n) { return n * 2 }\n",
+		"main.sqd": "include(\"lib.sqd\")\nio.echo(double(21))\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	var out strings.Builder
+	if err := ExecuteCompiledFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteCompiledFile returned error: %v\noutput: %q", err, out.String())
+	}
+	if got := out.String(); got != "42\n" {
+		t.Fatalf("expected the included function to be callable, got: %q", got)
+	}
+}
+
+func TestExecuteCompiledFileReportsUndefinedNamesBeforeRunning(t *testing.T) {
+	source := "io.echo(\"ran\")\nf >> () { return missing }\nf()\n"
+	t.Chdir(writeFiles(t, map[string]string{"main.sqd": source}))
+
+	var out strings.Builder
+	err := ExecuteCompiledFile("main.sqd", &out)
+	if err == nil || !strings.Contains(err.Error(), "line 2, column 18: Undefined variable missing") {
+		t.Fatalf("expected the undefined name to be a compilation error, got: %v", err)
+	}
+	if strings.Contains(out.String(), "ran") {
+		t.Fatalf("expected nothing to run, got: %q", out.String())
+	}
+}
+
+func TestExecuteBytecodeFile(t *testing.T) {
+	files := map[string]string{
+		"lib.sqd":  "double >> (n) { return n * 2 }\n",
+		"main.sqd": "include(\"lib.sqd\")\nvar x = double(21)\nx\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	if err := builder.BuildBytecode("main.sqd", "main.byc"); err != nil {
+		t.Fatalf("BuildBytecode returned error: %v", err)
+	}
+	// The program runs without its sources.
+	for name := range files {
+		os.Remove(name)
+	}
+
+	var out strings.Builder
+	if err := ExecuteBytecodeFile("main.byc", &out); err != nil {
+		t.Fatalf("ExecuteBytecodeFile returned error: %v\noutput: %q", err, out.String())
+	}
+	if got := out.String(); got != "42\n" {
+		t.Fatalf("expected 42, got: %q", got)
+	}
+}
+
+func TestCompiledProgramsReportIncludedFiles(t *testing.T) {
+	files := map[string]string{
+		"lib.sqd":  "boom >> (n) {\n    var f = n\n    return f()\n}\n",
+		"main.sqd": "io.echo(\"start\")\ninclude(\"lib.sqd\")\nvar x = 1\nboom(x)\n",
+	}
+	t.Chdir(writeFiles(t, files))
+	if err := builder.BuildBytecode("main.sqd", "main.byc"); err != nil {
+		t.Fatalf("BuildBytecode returned error: %v", err)
+	}
+
+	runs := map[string]func() error{
+		"ExecuteCompiledFile": func() error { return ExecuteCompiledFile("main.sqd", io.Discard) },
+		"ExecuteBytecodeFile": func() error { return ExecuteBytecodeFile("main.byc", io.Discard) },
+	}
+	for name, run := range runs {
+		err := run()
+		if err == nil {
+			t.Fatalf("%s: expected a runtime error", name)
+		}
+		for _, want := range []string{"lib.sqd, line 3, column 13:", "in <main> (main.sqd:4)", "in boom (lib.sqd:3)"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected %q in the error, got: %v", name, want, err)
+			}
+		}
+	}
+}
+
+func TestCompiledProgramsReportParseErrorsInIncludedFiles(t *testing.T) {
+	files := map[string]string{
+		"lib.sqd":  "boom >> (n) {\n    var f = n\n    return f(\n}\n",
+		"main.sqd": "io.echo(\"start\")\ninclude(\"lib.sqd\")\nvar x = 1\nboom(x)\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	err := ExecuteCompiledFile("main.sqd", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "lib.sqd, line 4, column 1:") {
+		t.Fatalf("expected the parse error to point into lib.sqd, got: %v", err)
+	}
+}
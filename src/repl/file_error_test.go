@@ -41,3 +41,44 @@ func TestExecuteFilePrintsHelpfulUndefinedVariableError(t *testing.T) {
 		t.Fatalf("expected output to contain line and column info, got: %q", o)
 	}
 }
+
+func TestExecuteFileReportsLinesAfterIncludesAndMultiLineStatements(t *testing.T) {
+	t.Chdir(writeFiles(t, map[string]string{
+		"lib.sqd":  "var answer = 42\n",
+		"main.sqd": "pkg.include(\"lib.sqd\", \"s\")\nvar f = def() {\n\n    return y\n}\nf()\n",
+	}))
+
+	var out strings.Builder
+	ExecuteFile("main.sqd", &out)
+
+	if o := out.String(); !strings.Contains(o, "line 4, column 12: Undefined variable y") {
+		t.Fatalf("expected the error on line 4, got: %q", o)
+	}
+}
+
+func TestExecuteFileParsesWholeFile(t *testing.T) {
+	// Brackets in strings and comments don't end or extend a statement, and
+	// a line can hold several statements.
+	content := "var s = \"{ ( [\"\n# a ( comment {\nio.print(s, \"|\"); io.print(\"two|\")\nvar f = def(x) {\n    return x + 1\n}\nio.print(f(1))\nvar g = def() { return z }\ng()\n"
+	f, err := ioutil.TempFile("", "test4-*.sqd")
+	if err != nil {
+		t.Fatalf("couldn't create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("couldn't write temp file: %v", err)
+	}
+	f.Close()
+
+	var out strings.Builder
+	ExecuteFile(f.Name(), &out)
+
+	o := out.String()
+	if !strings.HasPrefix(o, "{ ( [ |two|2") {
+		t.Fatalf("expected every statement to run, got: %q", o)
+	}
+	if !strings.Contains(o, "line 8, column 24: Undefined variable z") {
+		t.Fatalf("expected the error on line 8, got: %q", o)
+	}
+}
@@ -38,7 +38,7 @@ func TestExecuteFileProcessesAllPkgIncludesInIfBlock(t *testing.T) {
     pkg.include("lib/reader.sqd", "reader")
 }
 var sorted = sort.sort(reader.get(1))
-io.echo(sorted, "\n")
+io.echo(sorted)
 `
 
 	mainPath := filepath.Join(root, "main.sqd")
@@ -64,7 +64,7 @@ if (mode == 1) {
 } elif (mode == 2) {
     var x = [9]
 }
-io.echo(array.cat(x), "\n")
+io.echo(array.cat(x))
 `
 
 	mainPath := filepath.Join(root, "main.sqd")
@@ -0,0 +1,46 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"squ1d++/pkg"
+	"strings"
+	"testing"
+)
+
+func TestExecuteFileReportsCircularInclude(t *testing.T) {
+	files := map[string]string{
+		"a.sqd": "var x = 1\npkg.include(\"b.sqd\", \"b\")\n",
+		"b.sqd": "pkg.include(\"a.sqd\", \"a\")\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	var out strings.Builder
+	err := ExecuteFile("a.sqd", &out)
+	if err == nil {
+		t.Fatalf("expected a circular include error, output: %q", out.String())
+	}
+	if !strings.Contains(err.Error(), "a.sqd -> b.sqd -> a.sqd") || !strings.Contains(err.Error(), "a.sqd:2 includes b.sqd") {
+		t.Fatalf("expected the include chain with positions, got: %v", err)
+	}
+	if frames := pkg.Includes.Frames(); len(frames) != 0 {
+		t.Fatalf("expected the include stack to be unwound, got %v", frames)
+	}
+}
+
+// writeFiles writes files, keyed by slash-separated paths, into a new
+// temporary directory and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("could not create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("could not write %s: %v", name, err)
+		}
+	}
+	return root
+}
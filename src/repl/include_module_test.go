@@ -0,0 +1,84 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExecuteFileNamespacedIncludeKeepsModuleState(t *testing.T) {
+	files := map[string]string{
+		"counter.sqd": "pkg.include(\"fmt.sqd\", \"fmt\")\nvar count = 0\nvar label = \"hits\"\nbump >> () {\n    count = count + 1\n    return fmt.show(label, count)\n}\n",
+		"fmt.sqd":     "show >> (name, n) { return name + \"=\" + type.d2s(n) }\n",
+		"main.sqd":    "var count = 7\npkg.include(\"counter.sqd\", \"counter\")\nio.echo(counter.bump())\nio.echo(counter.bump())\nio.echo(counter.label, count)\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	var out strings.Builder
+	if err := ExecuteFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteFile returned error: %v\noutput: %q", err, out.String())
+	}
+
+	for _, want := range []string{"hits=1", "hits=2", "hits 7"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected output to contain %q, got: %q", want, out.String())
+		}
+	}
+}
+
+func TestExecuteFileNamespacedIncludeHidesPrivateNames(t *testing.T) {
+	files := map[string]string{
+		"lib.sqd":  "var _scale = 10\n_helper >> (n) { return n * _scale }\nscale >> (n) { return _helper(n) }\n",
+		"main.sqd": "pkg.include(\"lib.sqd\", \"lib\")\nio.echo(lib.scale(4))\nio.echo(lib._helper)\nio.echo(lib._scale)\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	var out strings.Builder
+	if err := ExecuteFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteFile returned error: %v\noutput: %q", err, out.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || strings.TrimSpace(lines[0]) != "40" {
+		t.Fatalf("expected the public function to use private helpers, got: %q", out.String())
+	}
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) != "null" {
+			t.Fatalf("expected private names to be missing from the namespace, got: %q", out.String())
+		}
+	}
+}
+
+func TestExecuteFileNamespacedIncludeHonorsExports(t *testing.T) {
+	files := map[string]string{
+		"lib.sqd":  "var scale = 10\nhelper >> (n) { return n * scale }\nexport times >> (n) { return helper(n) }\nexport var version = \"2.0\"\nexport scale\n",
+		"main.sqd": "pkg.include(\"lib.sqd\", \"lib\")\nio.echo([lib.times(4), lib.version, lib.scale, lib.helper])\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	var out strings.Builder
+	if err := ExecuteFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteFile returned error: %v\noutput: %q", err, out.String())
+	}
+	if got := strings.TrimSpace(out.String()); got != `[40, "2.0", 10, null]` {
+		t.Fatalf("expected only the exported names in the namespace, got: %q", got)
+	}
+}
+
+func TestExecuteFileIncludesDirectoryPackage(t *testing.T) {
+	files := map[string]string{
+		"utils/__init__.sqd":      "var version = \"1.0\"\nshout >> (s) { return strings.upper(s) + \"!\" }\n",
+		"utils/strings.sqd":       "upper >> (s) { return string.upper(s) }\nslugify >> (s) { return string.lower(s) }\n",
+		"utils/text/__init__.sqd": "",
+		"utils/text/wrap.sqd":     "wrap >> (s) { return \"[\" + s + \"]\" }\n",
+		"main.sqd":                "pkg.include(\"utils\", \"utils\")\nio.echo(utils.strings.slugify(\"Hello\"), utils.shout(\"hi\"), utils.text.wrap.wrap(utils.version))\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	var out strings.Builder
+	if err := ExecuteFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteFile returned error: %v\noutput: %q", err, out.String())
+	}
+	if !strings.Contains(out.String(), "hello HI! [1.0]") {
+		t.Fatalf("expected nested namespaces to resolve, got: %q", out.String())
+	}
+}
@@ -0,0 +1,78 @@
+package repl
+
+import (
+	"io"
+	"squ1d++/builder"
+	"strings"
+	"testing"
+)
+
+func TestExecuteFileRunsNamespacedIncludeOnce(t *testing.T) {
+	files := map[string]string{
+		"lib/counter.sqd": "io.echo(\"loading counter\")\nvar count = 0\nbump >> () {\n    count = count + 1\n    return count\n}\n",
+		"main.sqd": "pkg.include(\"lib/counter.sqd\", \"a\")\npkg.include(\"./lib/../lib/counter.sqd\", \"b\")\n" +
+			"a.bump()\nio.echo(b.bump())\n",
+	}
+	t.Chdir(writeFiles(t, files))
+
+	var out strings.Builder
+	if err := ExecuteFile("main.sqd", &out); err != nil {
+		t.Fatalf("ExecuteFile returned error: %v\noutput: %q", err, out.String())
+	}
+
+	got := out.String()
+	if n := strings.Count(got, "loading counter"); n != 1 {
+		t.Fatalf("expected pkg.include() to run the file once, ran %d times: %q", n, got)
+	}
+	// Both namespaces share one module, so b sees a's bump.
+	if !strings.Contains(got, "2") {
+		t.Fatalf("expected both namespaces to share module state, got: %q", got)
+	}
+}
+
+func TestStartRunsEachIncludeOnce(t *testing.T) {
+	t.Chdir(writeFiles(t, map[string]string{"helpers.sqd": "io.echo(\"loading helpers\")\n"}))
+
+	var out strings.Builder
+	Start(strings.NewReader("include(\"helpers.sqd\")\ninclude(\"./helpers.sqd\")\n"), &out)
+
+	if n := strings.Count(out.String(), "loading helpers"); n != 1 {
+		t.Fatalf("expected include() to run the file once, ran %d times: %q", n, out.String())
+	}
+}
+
+func TestCompiledProgramsRunEachIncludeOnce(t *testing.T) {
+	t.Chdir(writeFiles(t, map[string]string{
+		"helpers.sqd":     "io.echo(\"loading helpers\")\n",
+		"lib/counter.sqd": "io.echo(\"loading counter\")\nvar count = 0\nbump >> () {\n    count = count + 1\n    return count\n}\n",
+		"main.sqd": "include(\"helpers.sqd\")\ninclude(\"./helpers.sqd\")\n" +
+			"pkg.include(\"lib/counter.sqd\", \"a\")\npkg.include(\"./lib/../lib/counter.sqd\", \"b\")\n" +
+			"suppress a.bump()\nio.echo(\"count\", b.bump())\n",
+	}))
+
+	for name, execute := range map[string]func(string, io.Writer) error{
+		"ExecuteCompiledFile": ExecuteCompiledFile,
+		"BuildBytecode": func(filename string, out io.Writer) error {
+			if err := builder.BuildBytecode(filename, "main.byc"); err != nil {
+				return err
+			}
+			return ExecuteBytecodeFile("main.byc", out)
+		},
+	} {
+		var out strings.Builder
+		if err := execute("main.sqd", &out); err != nil {
+			t.Fatalf("%s returned error: %v\noutput: %q", name, err, out.String())
+		}
+		got := out.String()
+		if n := strings.Count(got, "loading helpers"); n != 1 {
+			t.Errorf("%s: expected include() to run the file once, ran %d times: %q", name, n, got)
+		}
+		if n := strings.Count(got, "loading counter"); n != 1 {
+			t.Errorf("%s: expected pkg.include() to run the file once, ran %d times: %q", name, n, got)
+		}
+		// Both namespaces share one module, so b sees a's bump.
+		if !strings.Contains(got, "count 2") {
+			t.Errorf("%s: expected both namespaces to share module state, got: %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package repl
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestIncludesEmbeddedStandardLibrary(t *testing.T) {
+	main := "pkg.include(\"std/sort\", \"sorting\")\npkg.include(\"std/sort.sqd\", \"again\")\nio.echo(sorting.sort([3, 1, 2]), again.sort([5, 4]))\n"
+	t.Chdir(writeFiles(t, map[string]string{"main.sqd": main}))
+
+	for name, execute := range map[string]func(string, io.Writer) error{
+		"ExecuteFile":         ExecuteFile,
+		"ExecuteCompiledFile": ExecuteCompiledFile,
+	} {
+		var out strings.Builder
+		if err := execute("main.sqd", &out); err != nil {
+			t.Fatalf("%s returned error: %v\noutput: %q", name, err, out.String())
+		}
+		if !strings.Contains(out.String(), "[1, 2, 3] [4, 5]\n") {
+			t.Errorf("%s: expected standard modules to load without a lib directory, got: %q", name, out.String())
+		}
+	}
+}
@@ -7,14 +7,11 @@ import (
 	"os"
 	"os/signal"
 	"os/user"
-	"path/filepath"
-	"squ1d++/ast"
-	"squ1d++/compiler"
-	"squ1d++/evaluator"
-	"squ1d++/lexer"
+	"squ1d++/builder"
+	"squ1d++/bytecode"
 	"squ1d++/object"
-	"squ1d++/parser"
-	"squ1d++/vm"
+	"squ1d++/pkg"
+	"squ1d++/runner"
 	"strings"
 	"syscall"
 )
@@ -71,33 +68,23 @@ func needsContinuation(line string) bool {
 
 func Start(in io.Reader, out io.Writer) {
 	// Ensure builtins write to the REPL output writer so tests can capture prints.
-	object.OutWriter = out
+	defer object.SetIO(object.RuntimeIO{Out: out})()
 	_, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
-	// REPL state for migration to compiler/VM with include fallback via evaluator
 	scanner := bufio.NewScanner(in)
-	classes := object.CreateClassObjects()
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	env := object.NewEnvironment()
-	for name, obj := range classes {
-		env.Set(name, obj)
-	}
-	// Register builtins and classes in compiler symbol table and globals
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
-	for name, obj := range classes {
-		sym := symbolTable.Define(name)
-		globals[sym.Index] = obj
-	}
-	constants := []object.Object{}
+	session := runner.New(out)
+	session.Errors = out
+	// Spawned tasks run in the background while the REPL waits for input.
+	leave := object.Enter()
+	defer func() { leave() }()
 	for {
 		fmt.Fprintf(out, PROMPT)
 		// Read complete input (handling multi-line statements)
+		leave()
 		input := readCompleteInput(scanner, out)
+		leave = object.Enter()
 		if input == "" {
 			if !scanner.Scan() {
 				fmt.Println("\nSee you later.")
@@ -105,101 +92,30 @@ func Start(in io.Reader, out io.Writer) {
 			}
 			continue
 		}
-		// Simple include handling: include("path") or include("name")
-		if incPath, ok := tryParseInclude(input); ok {
-			if err := executeInclude(incPath, env, out); err != nil {
-				fmt.Fprintf(out, "Include error: %v\n", err)
-			}
-			continue
-		}
-		l := lexer.New(input)
-		p := parser.New(l)
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
-		}
-		compiled := compiler.NewWithState(symbolTable, constants)
-		if err := compiled.Compile(program); err != nil {
-			io.WriteString(out, "Compilation error: "+err.Error()+"\n")
-			continue
-		}
-		bytecode := compiled.Bytecode()
-		constants = bytecode.Constants
-		machine := vm.NewWithGlobalsStore(bytecode, globals)
-		if err := machine.Run(); err != nil {
-			io.WriteString(out, "Runtime error: "+err.Error()+"\n")
+		program, err := session.Parse(input)
+		if err != nil {
 			continue
 		}
-		if last := machine.LastPoppedStackElem(); last != nil && last.Type() != object.NULL_OBJ {
-			io.WriteString(out, last.Inspect()+"\n")
-		}
-	}
-}
-
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, "ERROR:\n\t\t\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+		// Errors were reported to out; the session goes on.
+		session.Run(program)
 	}
 }
 
-func tryParseInclude(input string) (string, bool) {
-	trimmed := strings.TrimSpace(input)
-	if !strings.HasPrefix(trimmed, "include(") || !strings.HasSuffix(trimmed, ")") {
-		return "", false
-	}
-	inside := strings.TrimSpace(trimmed[len("include(") : len(trimmed)-1])
-	if len(inside) >= 2 && ((inside[0] == '"' && inside[len(inside)-1] == '"') || (inside[0] == '\'' && inside[len(inside)-1] == '\'')) {
-		return inside[1 : len(inside)-1], true
-	}
-	return inside, true
-}
-
-func executeInclude(path string, env *object.Environment, out io.Writer) error {
-	candidates := []string{path}
-	if !strings.HasSuffix(path, ".sqd") {
-		candidates = append(candidates, "lib/"+path+".sqd")
-	}
-	if home, err := os.UserHomeDir(); err == nil {
-		candidates = append(candidates, home+"/.squ1dlang/packages/"+path+"/main.sqd")
-	}
-	var chosen string
-	for _, c := range candidates {
-		if fi, err := os.Stat(c); err == nil && !fi.IsDir() {
-			chosen = c
-			break
-		}
-	}
-	if chosen == "" {
-		return fmt.Errorf("module or file not found: %s", path)
-	}
-	data, err := os.ReadFile(chosen)
-	if err != nil {
-		return fmt.Errorf("could not read %s: %v", chosen, err)
-	}
-	// Parse and execute as a whole unit to preserve statements across lines
-	l := lexer.New(string(data))
-	p := parser.New(l)
-	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		printParserErrors(out, p.Errors())
-		return fmt.Errorf("parse errors in include %s", chosen)
-	}
-	evaluated := evaluator.Eval(program, env)
-	if evaluated != nil && evaluated.Type() == object.ERROR_OBJ {
-		return fmt.Errorf("runtime error in include %s: %s", chosen, evaluated.Inspect())
-	}
-	return nil
+// ExecuteFile reads and executes a .sqd file. The file is parsed once, then
+// compiled and run one statement at a time while preserving global state
+// between statements, so positions come straight from the file's tokens.
+func ExecuteFile(filename string, out io.Writer) error {
+	return ExecuteFileWithState(filename, out, nil)
 }
 
-// ExecuteFile reads and executes a .sqd file, compiling one statement at a time
-// while preserving global state between statements. Error line/column positions
-// are reported relative to the start of the file by tracking cumulative line offsets.
-func ExecuteFile(filename string, out io.Writer) error {
+// ExecuteFileWithState is ExecuteFile, calling started, when it isn't nil,
+// with the file's session before its first statement runs. The debugger
+// uses it to look up globals by name.
+func ExecuteFileWithState(filename string, out io.Writer, started func(*runner.Session)) error {
 	// Ensure builtins write to the provided writer so file execution prints
-	// are captured by callers (tests, CLI, etc.).
-	object.OutWriter = out
+	// are captured by callers (tests, CLI, etc.). Input and stderr stay as
+	// object.SetIO left them.
+	defer object.SetIO(object.RuntimeIO{Out: out})()
 
 	file, err := os.Open(filename)
 	if err != nil {
@@ -211,251 +127,77 @@ func ExecuteFile(filename string, out io.Writer) error {
 	if err != nil {
 		return fmt.Errorf("Could not read file %s: %v", filename, err)
 	}
+	defer object.Enter()()
 
-	// Build initial symbol table and register builtins and class names
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+	if err := pkg.Includes.Enter(filename, 0, 0); err != nil {
+		return err
 	}
+	defer pkg.Includes.Leave()
+	object.RegisterSource(filename, string(content))
 
-	globals := make([]object.Object, vm.GlobalsSize)
-	classes := object.CreateClassObjects()
-	classNames := []string{"io", "type", "time", "os", "math", "string", "file", "pkg", "array", "sys", "keyboard"}
-	for _, className := range classNames {
-		if classObj, ok := classes[className]; ok {
-			sym := symbolTable.Define(className)
-			globals[sym.Index] = classObj
-		}
+	session := runner.New(out)
+	session.Filename = filename
+	session.Errors = out
+	if started != nil {
+		started(session)
 	}
-	constants := []object.Object{}
-	lineOffset := 0
-
-	// Read the file and execute complete statements
-	scanner := bufio.NewScanner(strings.NewReader(string(content)))
-	var currentStatement strings.Builder
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(strings.TrimSpace(line)) == 0 {
-			lineOffset++
-			continue
-		}
-		currentStatement.WriteString(line)
-		if !needsContinuation(currentStatement.String()) {
-			stmt := currentStatement.String()
-			currentStatement.Reset()
-			// Handle include inline (keeps existing include behavior)
-			if incPath, ok := tryParseInclude(stmt); ok {
-				lineOffset++
-				if err := executeInclude(incPath, object.NewEnvironment(), out); err != nil {
-					fmt.Fprintf(out, "Include error: %v\n", err)
-					return err
-				}
-				continue
-			}
-			l := lexer.New(stmt)
-			p := parser.New(l)
-			program := p.ParseProgram()
-			if len(p.Errors()) != 0 {
-				printParserErrors(out, p.Errors())
-				return fmt.Errorf("Parsing errors in file %s:\t%v\n", filename, p.Errors())
-			}
-			// Compile the current statement only
-			tmp := compiler.NewWithState(symbolTable, constants)
-			tmp.LineOffset = lineOffset
-			if err := tmp.Compile(program); err != nil {
-				return fmt.Errorf("Compilation error in file %s: %v", filename, err)
-			}
-			// Seed any undefined globals discovered during this statement's compilation
-			for idx, e := range tmp.UndefinedGlobals() {
-				if e == nil {
-					continue
-				}
-				if ss, ok := program.Statements[0].(*ast.SuppressStatement); ok {
-					if ls, ok2 := ss.Statement.(*ast.LetStatement); ok2 {
-						if e.Line == ls.Token.Line {
-							continue
-						}
-					}
-				}
-				// Adjust line to be file-relative by adding the accumulated line offset
-				e.Line = e.Line + lineOffset
-				if e.Filename == "" {
-					e.Filename = filename
-				}
-				globals[idx] = e
-			}
-			bytecode := tmp.Bytecode()
-			constants = bytecode.Constants
-			machine := vm.NewWithGlobalsStore(bytecode, globals)
-			if err := machine.Run(); err != nil {
-				io.WriteString(out, err.Error()+"\n")
-				return err
-			}
-			// Process all include directives produced by this statement in-order.
-			for _, directive := range machine.DrainIncludeDirectives() {
-				if err := executeIncludeDirective(directive, symbolTable, &constants, globals, filename, out); err != nil {
-					fmt.Fprintf(out, "Include error: %v\n", err)
-					return err
-				}
-			}
-			// Print normal statement result if any
-			if last := machine.LastPoppedStackElem(); last != nil {
-				if _, ok := last.(*object.IncludeDirective); ok {
-					continue
-				}
-				if last.Type() != object.NULL_OBJ {
-					io.WriteString(out, last.Inspect()+"\n")
-				}
-			}
-		} else {
-			currentStatement.WriteString("\n")
-		}
-		lineOffset++
-	}
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("Error reading file %s: %v", filename, err)
-	}
-	// Handle any remaining statement
-	if currentStatement.Len() > 0 {
-		stmt := currentStatement.String()
-		if incPath, ok := tryParseInclude(stmt); ok {
-			if err := executeInclude(incPath, object.NewEnvironment(), out); err != nil {
-				fmt.Fprintf(out, "Include error: %v\n", err)
-				return err
-			}
-			return nil
-		}
-		l := lexer.New(stmt)
-		p := parser.New(l)
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			return fmt.Errorf("Parsing errors in file %s: %v", filename, p.Errors())
-		}
-		tmp := compiler.NewWithState(symbolTable, constants)
-		if err := tmp.Compile(program); err != nil {
-			return fmt.Errorf("Compilation error in file %s: %v", filename, err)
-		}
-		// Adjust undefined globals for remaining statement
-		for idx, e := range tmp.UndefinedGlobals() {
-			if e == nil {
-				continue
-			}
-			e.Line = e.Line + lineOffset
-			if e.Filename == "" {
-				e.Filename = filename
-			}
-			globals[idx] = e
-		}
-		bytecode := tmp.Bytecode()
-		machine := vm.NewWithGlobalsStore(bytecode, globals)
-		if err := machine.Run(); err != nil {
-			io.WriteString(out, err.Error()+"\n")
-			return err
-		}
-		for _, directive := range machine.DrainIncludeDirectives() {
-			if err := executeIncludeDirective(directive, symbolTable, &constants, globals, filename, out); err != nil {
-				fmt.Fprintf(out, "Include error: %v\n", err)
-				return err
-			}
-		}
-		if last := machine.LastPoppedStackElem(); last != nil {
-			if _, ok := last.(*object.IncludeDirective); ok {
-				return nil
-			}
-			if last.Type() != object.NULL_OBJ {
-				io.WriteString(out, last.Inspect()+"\n")
-			}
-		}
+	program, err := session.Parse(string(content))
+	if err != nil {
+		return err
 	}
-	return nil
+	return session.Run(program)
 }
 
-// executeIncludeDirective handles pkg.include() directives by loading and evaluating a file
-// and registering its functions in the symbol table and globals
-func executeIncludeDirective(directive *object.IncludeDirective, symbolTable *compiler.SymbolTable, constants *[]object.Object, globals []object.Object, caller string, out io.Writer) error {
-	// Resolve the include filename relative to the caller and common locations
-	// Normalize path separators in the directive filename so relative joins work
-	normalized := filepath.Clean(strings.ReplaceAll(directive.Filename, "\\", string(os.PathSeparator)))
-	candidates := []string{normalized}
-	// Try relative to caller's directory
-	if caller != "" {
-		candidates = append(candidates, filepath.Join(filepath.Dir(caller), normalized))
-		candidates = append(candidates, filepath.Join(filepath.Dir(caller), "lib", normalized))
-	}
-	candidates = append(candidates, filepath.Join("lib", normalized))
-	var content []byte
-	var err error
-	var chosen string
-	for _, c := range candidates {
-		if fi, statErr := os.Stat(c); statErr == nil && !fi.IsDir() {
-			chosen = c
-			break
-		}
-	}
-	if chosen == "" {
-		return fmt.Errorf("Failed to read include file '%s': file not found", directive.Filename)
-	}
-	// SQX plugins are JSON manifests for external command-backed functions.
-	// Load them directly into a namespace hash without evaluator parsing.
-	if strings.EqualFold(filepath.Ext(chosen), ".sqx") {
-		nsHash, err := object.LoadSQXNamespace(chosen)
-		if err != nil {
-			return fmt.Errorf("SQX load error in '%s': %v", directive.Filename, err)
-		}
-		ns := symbolTable.Define(directive.Namespace)
-		if ns.Index < len(globals) {
-			globals[ns.Index] = nsHash
-		}
-		object.RegisterNamespace(directive.Namespace, nsHash)
-		return nil
-	}
-	content, err = os.ReadFile(chosen)
+// ExecuteCompiledFile runs a file the way a built executable runs it:
+// compiled as a whole, includes and all, then run on the VM. It prints what
+// ExecuteFile prints, but files it includes run on the VM too, and names a
+// function uses are known before any of the file runs.
+func ExecuteCompiledFile(filename string, out io.Writer) error {
+	defer object.SetIO(object.RuntimeIO{Out: out})()
+
+	content, err := os.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("Failed to read include file '%s': %v", chosen, err)
-	}
-	// Parse the file
-	l := lexer.New(string(content))
-	p := parser.New(l)
-	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		return fmt.Errorf("Parse errors in '%s': %v", directive.Filename, p.Errors())
+		return fmt.Errorf("Could not read file %s: %v", filename, err)
 	}
-	// Create a new environment for the included file
-	// Seed it with all class objects and builtins
-	includeEnv := object.NewEnvironment()
-	classes := object.CreateClassObjects()
-	for className, classObj := range classes {
-		includeEnv.Set(className, classObj)
+	defer object.Enter()()
+	object.RegisterSource(filename, string(content))
+
+	session := runner.New(out)
+	session.Filename = filename
+	session.Errors = out
+	// Syntax errors in the file itself are reported as ExecuteFile
+	// reports them.
+	if _, err := session.Parse(string(content)); err != nil {
+		return err
 	}
-	// Evaluate the program in this new environment
-	evalResult := evaluator.Eval(program, includeEnv)
-	if evalResult != nil && evalResult.Type() == object.ERROR_OBJ {
-		return fmt.Errorf("Evaluation error in '%s': %v", directive.Filename, evalResult)
+	bytecode, err := builder.Compile(filename)
+	if err != nil {
+		err = fmt.Errorf("Compilation error in file %s: %v", filename, err)
+		io.WriteString(out, err.Error()+"\n")
+		return err
 	}
-	// Extract all functions and variables from the environment and create a namespace Hash
-	nsHash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
-	storeContents := includeEnv.GetStore()
-	for name, obj := range storeContents {
-		// Skip built-in classes - only include user-defined objects
-		if _, isBuiltin := classes[name]; isBuiltin {
-			continue
-		}
-		// Include Functions and Builtins (match REPL behavior)
-		switch obj.(type) {
-		case *object.Function, *object.Builtin, *object.Closure:
-			key := &object.String{Value: name}
-			nsHash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: obj}
-		}
+	return session.RunBytecode(bytecode)
+}
+
+// ExecuteBytecodeFile runs a program compiled to a .byc file by
+// `squ1dcc build -c`, the way a built executable runs the same program.
+func ExecuteBytecodeFile(filename string, out io.Writer) error {
+	defer object.SetIO(object.RuntimeIO{Out: out})()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Could not open file %s: %v", filename, err)
 	}
-	// Register the namespace as a variable in the symbol table and globals
-	ns := symbolTable.Define(directive.Namespace)
-	if ns.Index < len(globals) {
-		globals[ns.Index] = nsHash
+	defer file.Close()
+	pkg, err := bytecode.Deserialize(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("Could not load bytecode file %s: %v", filename, err)
 	}
-	// Also register the namespace globally so sys.list() can find it
-	object.RegisterNamespace(directive.Namespace, nsHash)
-	return nil
+	defer object.Enter()()
+
+	session := runner.New(out)
+	session.Errors = out
+	return session.RunBytecode(pkg.Bytecode())
 }
 
 func StartWithSignalHandling(in io.Reader, out io.Writer) {
@@ -44,10 +44,10 @@ esac
 	}
 
 	main := `pkg.include("lib/tooling.sqx", "tooling")
-io.echo(tooling.importedFunction(), "\n")
-io.echo(tooling.sendGreeting("Ana"), "\n")
-io.echo(tooling.sumTwo(4, 6), "\n")
-io.echo(tooling.stats(1, 2, 3).count, "\n")
+io.echo(tooling.importedFunction())
+io.echo(tooling.sendGreeting("Ana"))
+io.echo(tooling.sumTwo(4, 6))
+io.echo(tooling.stats(1, 2, 3).count)
 `
 	mainPath := filepath.Join(root, "main.sqd")
 	if err := os.WriteFile(mainPath, []byte(main), 0o644); err != nil {
@@ -103,9 +103,9 @@ esac
 	}
 
 	main := `pkg.include("lib/tooling.sqx", "tooling")
-io.echo(tooling.ping(), "\n")
-io.echo(tooling.sumTwo(1, 4), "\n")
-io.echo(tooling.stats(7, 8).count, "\n")
+io.echo(tooling.ping())
+io.echo(tooling.sumTwo(1, 4))
+io.echo(tooling.stats(7, 8).count)
 `
 	mainPath := filepath.Join(root, "main.sqd")
 	if err := os.WriteFile(mainPath, []byte(main), 0o644); err != nil {
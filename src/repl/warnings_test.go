@@ -0,0 +1,35 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"squ1d++/runner"
+	"strings"
+	"testing"
+)
+
+func TestExecuteFileReportsWarnings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "warn.sqd")
+	content := "var total = 0\nvar f = def(x) {\n    var total = x\n    var unused = 1\n    total\n}\nf(1)\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings strings.Builder
+	runner.Warnings = &warnings
+	defer func() { runner.Warnings = nil }()
+
+	var out strings.Builder
+	if err := ExecuteFile(file, &out); err != nil {
+		t.Fatalf("ExecuteFile returned error: %v", err)
+	}
+
+	expected := file + ", line 3, column 9: warning: total shadows the global defined at line 1\n" +
+		file + ", line 4, column 9: warning: unused is defined but never used\n"
+	if warnings.String() != expected {
+		t.Fatalf("wrong warnings.\nwant=%q\ngot= %q", expected, warnings.String())
+	}
+	if strings.Contains(out.String(), "warning") {
+		t.Fatalf("expected warnings to stay out of the program output, got %q", out.String())
+	}
+}
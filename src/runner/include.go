@@ -0,0 +1,330 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"squ1d++/ast"
+	"squ1d++/compiler"
+	"squ1d++/evaluator"
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"squ1d++/pkg"
+	"squ1d++/std"
+	"squ1d++/vm"
+	"strings"
+)
+
+// includeStatement reports whether stmt is a top-level include("path")
+// call, returning the path and the column of the call.
+func includeStatement(stmt ast.Statement) (string, int, bool) {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return "", 0, false
+	}
+	call, ok := es.Expression.(*ast.CallExpression)
+	if !ok || len(call.Arguments) != 1 {
+		return "", 0, false
+	}
+	fn, ok := call.Function.(*ast.Identifier)
+	if !ok || fn.Value != "include" {
+		return "", 0, false
+	}
+	switch arg := call.Arguments[0].(type) {
+	case *ast.StringLiteral:
+		return arg.Value, fn.Token.Column, true
+	case *ast.Identifier:
+		return arg.Value, fn.Token.Column, true
+	}
+	return "", 0, false
+}
+
+// loadedModules tracks the files a REPL session or file run has already
+// included, so including a file again is a no-op, as it is for
+// include.Loader. Namespaced includes keep the namespace they produced, which
+// is bound again when the file is included under another name.
+type loadedModules struct {
+	files      map[string]bool
+	namespaces map[string]*object.Hash
+}
+
+func newLoadedModules() *loadedModules {
+	return &loadedModules{files: map[string]bool{}, namespaces: map[string]*object.Hash{}}
+}
+
+// moduleKey identifies an included file independently of the path used to
+// reach it.
+func moduleKey(path string) string {
+	if name, ok := std.Resolve(path); ok {
+		return name
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// include runs a top-level include("path") on the evaluator. line and
+// column locate the include in the current file (0 when unknown).
+func (s *Session) include(path string, line, column int) error {
+	candidates := []string{path}
+	if !strings.HasSuffix(path, ".sqd") {
+		candidates = append(candidates, "lib/"+path+".sqd")
+	}
+	if pkgMain, found, err := pkg.GlobalManager.ResolveInclude(path); err != nil {
+		return err
+	} else if found {
+		candidates = append(candidates, pkgMain)
+	}
+	// Standard library modules are embedded and win over files on disk
+	chosen, isStd := std.Resolve(path)
+	for _, c := range candidates {
+		if isStd {
+			break
+		}
+		if fi, err := os.Stat(c); err == nil && !fi.IsDir() {
+			chosen = c
+			break
+		}
+	}
+	if chosen == "" {
+		return fmt.Errorf("module or file not found: %s", path)
+	}
+	key := moduleKey(chosen)
+	if s.loaded.files[key] {
+		return nil
+	}
+	if err := pkg.Includes.Enter(chosen, line, column); err != nil {
+		return err
+	}
+	defer pkg.Includes.Leave()
+	data, err := std.ReadFile(chosen)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", chosen, err)
+	}
+	// Parse and execute as a whole unit to preserve statements across lines
+	l := lexer.New(string(data))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		if s.Errors != nil {
+			printParserErrors(s.Errors, p.Errors())
+		}
+		return fmt.Errorf("parse errors in include %s", chosen)
+	}
+	object.RegisterSource(chosen, string(data))
+	evaluated, err := evaluator.EvalFile(program, s.env, chosen)
+	if err != nil {
+		return err
+	}
+	if e, ok := evaluated.(*object.Error); ok {
+		if e.Line > 0 && e.Filename == "" {
+			e.Filename = chosen
+		}
+		return fmt.Errorf("runtime error in include %s: %s", chosen, e.InspectWithContext())
+	}
+	s.loaded.files[key] = true
+	return nil
+}
+
+// includeDirective handles pkg.include() directives by loading and evaluating a file
+// and registering its functions in the symbol table and globals. line is the
+// caller line of the statement that produced the directive.
+func (s *Session) includeDirective(directive *object.IncludeDirective, symbolTable *compiler.SymbolTable, caller string, line int) error {
+	// Resolve the include filename relative to the caller and common locations
+	// Normalize path separators in the directive filename so relative joins work
+	normalized := filepath.Clean(strings.ReplaceAll(directive.Filename, "\\", string(os.PathSeparator)))
+	candidates := []string{normalized}
+	// Try relative to caller's directory
+	if caller != "" {
+		candidates = append(candidates, filepath.Join(filepath.Dir(caller), normalized))
+		candidates = append(candidates, filepath.Join(filepath.Dir(caller), "lib", normalized))
+	}
+	candidates = append(candidates, filepath.Join("lib", normalized))
+	// Installed packages: "name" or "name@constraint"
+	if pkgMain, found, err := pkg.GlobalManager.ResolveInclude(directive.Filename); err != nil {
+		return err
+	} else if found {
+		candidates = append(candidates, pkgMain)
+	}
+	// Standard library modules are embedded and win over files on disk
+	chosen, isStd := std.Resolve(directive.Filename)
+	for _, c := range candidates {
+		if isStd {
+			break
+		}
+		fi, statErr := os.Stat(c)
+		if statErr != nil {
+			continue
+		}
+		// Directories are included as directory packages
+		if _, isPackage := pkg.PackageDir(c); !fi.IsDir() || isPackage {
+			chosen = c
+			break
+		}
+	}
+	if chosen == "" {
+		return fmt.Errorf("Failed to read include file '%s': file not found", directive.Filename)
+	}
+	nsHash, err := s.loadModule(chosen, symbolTable, line)
+	if err != nil {
+		return err
+	}
+	s.bindNamespace(symbolTable, directive.Namespace, nsHash)
+	// Also register the namespace globally so sys.list() can find it
+	object.RegisterNamespace(directive.Namespace, nsHash)
+	return nil
+}
+
+// loadModule returns the namespace of the file or directory package at path,
+// running it first unless it was already loaded. line is the caller line of
+// the include.
+func (s *Session) loadModule(path string, symbolTable *compiler.SymbolTable, line int) (*object.Hash, error) {
+	// A file that was already included is not run again; its namespace is
+	// bound under the requested name.
+	key := moduleKey(path)
+	if dir, ok := pkg.PackageDir(path); ok {
+		key = moduleKey(dir)
+	}
+	if nsHash, ok := s.loaded.namespaces[key]; ok {
+		return nsHash, nil
+	}
+
+	var nsHash *object.Hash
+	if dir, ok := pkg.PackageDir(path); ok {
+		// Each file of a directory package becomes a nested namespace,
+		// visible to __init__.sqd, whose definitions are the package's own.
+		if err := pkg.Includes.Enter(dir, line, 0); err != nil {
+			return nil, err
+		}
+		defer pkg.Includes.Leave()
+		submodules, err := pkg.Submodules(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read package directory '%s': %v", dir, err)
+		}
+		module := s.newModuleScope(symbolTable)
+		for _, sub := range submodules {
+			subHash, err := s.loadModule(sub.Path, module, 0)
+			if err != nil {
+				return nil, err
+			}
+			s.bindNamespace(module, sub.Namespace, subHash)
+		}
+		if err := s.runModule(pkg.PackageInit(dir), module, 0); err != nil {
+			return nil, err
+		}
+		nsHash = s.moduleNamespace(module)
+	} else if strings.EqualFold(filepath.Ext(path), ".sqx") {
+		// SQX plugins are JSON manifests for external command-backed functions.
+		// Load them directly into a namespace hash without evaluator parsing.
+		sqxHash, err := object.LoadSQXNamespace(path)
+		if err != nil {
+			return nil, fmt.Errorf("SQX load error in '%s': %v", path, err)
+		}
+		nsHash = sqxHash
+	} else {
+		// Compile and run the included file in its own module scope. Its
+		// globals share the caller's global store, so exported closures keep
+		// working when called from the including program.
+		module := s.newModuleScope(symbolTable)
+		if err := s.runModule(path, module, line); err != nil {
+			return nil, err
+		}
+		nsHash = s.moduleNamespace(module)
+	}
+	s.loaded.namespaces[key] = nsHash
+	return nsHash, nil
+}
+
+// newModuleScope returns a module symbol table for an included file, with
+// the class objects defined in it.
+func (s *Session) newModuleScope(symbolTable *compiler.SymbolTable) *compiler.SymbolTable {
+	module := compiler.NewModuleSymbolTable(symbolTable)
+	for className, classObj := range object.CreateClassObjects() {
+		sym := module.Define(className)
+		s.Globals.Set(sym.Index, classObj)
+	}
+	return module
+}
+
+// runModule compiles and runs the file at path in module.
+func (s *Session) runModule(path string, module *compiler.SymbolTable, line int) error {
+	content, err := std.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read include file '%s': %v", path, err)
+	}
+	if err := pkg.Includes.Enter(path, line, 0); err != nil {
+		return err
+	}
+	defer pkg.Includes.Leave()
+	object.RegisterSource(path, string(content))
+	// Parse the file
+	l := lexer.New(string(content))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return fmt.Errorf("Parse errors in '%s': %v", path, p.Errors())
+	}
+	// Run statement by statement so namespaces included by the module are
+	// bound before the statements that use them.
+	for _, stmt := range program.Statements {
+		comp := compiler.NewWithState(module, s.Constants)
+		comp.Filename = path
+		if err := comp.Compile(&ast.Program{Statements: []ast.Statement{stmt}}); err != nil {
+			return fmt.Errorf("Compilation error in '%s': %v", path, err)
+		}
+		for idx, e := range comp.UndefinedGlobals() {
+			if e == nil {
+				continue
+			}
+			if e.Filename == "" {
+				e.Filename = path
+			}
+			s.Globals.Set(idx, e)
+		}
+		bytecode := comp.Bytecode()
+		s.Constants = bytecode.Constants
+		machine := vm.NewWithGlobalsStore(bytecode, s.Globals)
+		machine.Entered = true
+		if err := machine.Run(); err != nil {
+			return fmt.Errorf("Runtime error in '%s': %v", path, err)
+		}
+		for _, nested := range machine.DrainIncludeDirectives() {
+			if err := s.includeDirective(nested, module, path, ast.StatementLine(stmt)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moduleNamespace exports the exported top-level definitions of module as a
+// namespace Hash.
+func (s *Session) moduleNamespace(module *compiler.SymbolTable) *object.Hash {
+	classes := object.CreateClassObjects()
+	nsHash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	for _, sym := range module.GlobalSymbols() {
+		if _, isClass := classes[sym.Name]; isClass || !module.Exported(sym.Name) {
+			continue
+		}
+		value := s.Globals.Get(sym.Index)
+		if value == nil {
+			continue
+		}
+		// Deferred references to names the module never defined
+		if _, undefined := value.(*object.Error); undefined {
+			continue
+		}
+		key := &object.String{Value: sym.Name}
+		nsHash.Set(key.HashKey(), object.HashPair{Key: key, Value: value})
+	}
+	return nsHash
+}
+
+// bindNamespace registers nsHash as a variable named name in the symbol table
+// and globals.
+func (s *Session) bindNamespace(symbolTable *compiler.SymbolTable, name string, nsHash *object.Hash) {
+	ns := symbolTable.Define(name)
+	s.Globals.Set(ns.Index, nsHash)
+}
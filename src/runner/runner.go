@@ -0,0 +1,267 @@
+// Package runner runs SQU1D++ programs. The REPL, the file runner and
+// executables built with -B all run their code through a Session, so they
+// set up the global scope, handle includes and report results and errors
+// the same way.
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"squ1d++/ast"
+	"squ1d++/compiler"
+	"squ1d++/crash"
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"squ1d++/vm"
+)
+
+// Warnings, when set, receives the compiler warnings of the statements a
+// session compiles, one per line.
+var Warnings io.Writer
+
+// Session is the state the statements of one program share: its symbol
+// table, globals and constants, and the files it has included.
+type Session struct {
+	// Symbols is the program's global symbol table and Globals the values
+	// of its globals, indexed by symbol.
+	Symbols   *compiler.SymbolTable
+	Globals   *vm.Globals
+	Constants []object.Object
+	// Filename is the file being run, "" in the REPL. Positions and
+	// relative includes are resolved against it.
+	Filename string
+	// Out receives the values of statements that have one.
+	Out io.Writer
+	// Errors, when set, receives a report of each error before it is
+	// returned.
+	Errors io.Writer
+
+	// env is the scope of files included with include("path"), which run
+	// on the evaluator.
+	env    *object.Environment
+	loaded *loadedModules
+}
+
+// New returns a session printing to out, with the builtins and classes
+// defined.
+func New(out io.Writer) *Session {
+	s := &Session{
+		Symbols: compiler.NewSymbolTable(),
+		Globals: vm.NewGlobals(),
+		Out:     out,
+		env:     object.NewEnvironment(),
+		loaded:  newLoadedModules(),
+	}
+	for i, v := range object.Builtins {
+		s.Symbols.DefineBuiltin(i, v.Name)
+	}
+	classes := object.CreateClassObjects()
+	for _, className := range object.ClassNames {
+		if classObj, ok := classes[className]; ok {
+			sym := s.Symbols.Define(className)
+			s.Globals.Set(sym.Index, classObj)
+			s.env.Set(className, classObj)
+		}
+	}
+	return s
+}
+
+// Parse parses source, reporting any syntax errors.
+func (s *Session) Parse(source string) (*ast.Program, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		if s.Errors != nil {
+			printParserErrors(s.Errors, p.Errors())
+		}
+		if s.Filename != "" {
+			return nil, fmt.Errorf("Parsing errors in file %s:\t%v\n", s.Filename, p.Errors())
+		}
+		return nil, fmt.Errorf("Parsing errors: %v", p.Errors())
+	}
+	return program, nil
+}
+
+// Run compiles and runs program one statement at a time, so includes are
+// processed before the statements that use what they define. It stops at
+// the first error.
+func (s *Session) Run(program *ast.Program) error {
+	for _, stmt := range program.Statements {
+		value, err := s.runStatement(stmt)
+		if err != nil {
+			return err
+		}
+		s.print(value)
+	}
+	return nil
+}
+
+// Eval runs program like Run, but returns the value of its last statement
+// instead of printing the values of its statements. The value is NULL when
+// the last statement has none.
+func (s *Session) Eval(program *ast.Program) (object.Object, error) {
+	var result object.Object = &object.Null{}
+	for _, stmt := range program.Statements {
+		value, err := s.runStatement(stmt)
+		if err != nil {
+			return nil, err
+		}
+		result = value
+		if result == nil {
+			result = &object.Null{}
+		}
+	}
+	return result, nil
+}
+
+// runStatement runs stmt and returns its value, nil when it has none.
+func (s *Session) runStatement(stmt ast.Statement) (object.Object, error) {
+	line := ast.StatementLine(stmt)
+	if path, column, ok := includeStatement(stmt); ok {
+		if vm.LineHook != nil {
+			vm.LineHook(nil, s.Filename, line)
+		}
+		if err := s.include(path, line, column); err != nil {
+			return nil, s.report(fmt.Errorf("Include error: %v", err))
+		}
+		return nil, nil
+	}
+
+	comp := compiler.NewWithState(s.Symbols, s.Constants)
+	comp.Filename = s.Filename
+	if err := comp.Compile(&ast.Program{Statements: []ast.Statement{stmt}}); err != nil {
+		if s.Filename != "" {
+			return nil, s.report(fmt.Errorf("Compilation error in file %s: %v", s.Filename, err))
+		}
+		return nil, s.report(fmt.Errorf("Compilation error: %v", err))
+	}
+	printWarnings(comp)
+	// Seed any undefined globals discovered during this statement's compilation
+	for idx, e := range comp.UndefinedGlobals() {
+		if e == nil {
+			continue
+		}
+		if ss, ok := stmt.(*ast.SuppressStatement); ok {
+			if ls, ok2 := ss.Statement.(*ast.LetStatement); ok2 {
+				if e.Line == ls.Token.Line {
+					continue
+				}
+			}
+		}
+		if e.Filename == "" {
+			e.Filename = s.Filename
+		}
+		s.Globals.Set(idx, e)
+	}
+	return s.run(comp.Bytecode(), line)
+}
+
+// RunBytecode runs a program compiled ahead of time as a whole, such as the
+// one embedded in a built executable. Such a program refers to the classes
+// as builtins rather than globals, so its globals start out empty.
+func (s *Session) RunBytecode(bytecode *compiler.Bytecode) error {
+	value, err := s.EvalBytecode(bytecode)
+	if err != nil {
+		return err
+	}
+	s.print(value)
+	return nil
+}
+
+// EvalBytecode runs bytecode like RunBytecode, but returns the value of its
+// last statement instead of printing it.
+func (s *Session) EvalBytecode(bytecode *compiler.Bytecode) (object.Object, error) {
+	s.Globals = vm.NewGlobals()
+	for idx, e := range bytecode.Undefined {
+		if e.Filename == "" {
+			e.Filename = s.Filename
+		}
+		s.Globals.Set(idx, e)
+	}
+	value, err := s.run(bytecode, 0)
+	if value == nil {
+		value = &object.Null{}
+	}
+	return value, err
+}
+
+// run runs bytecode on the session's globals, then processes the
+// pkg.include() calls it made and returns its value, nil when it has none.
+// line is the line of the statement it was compiled from.
+func (s *Session) run(bytecode *compiler.Bytecode, line int) (object.Object, error) {
+	s.Constants = bytecode.Constants
+	machine := vm.NewWithGlobalsStore(bytecode, s.Globals)
+	machine.Entered = true
+	if err := machine.Run(); err != nil {
+		nameGlobals(err, bytecode.GlobalNames)
+		return nil, s.report(err)
+	}
+	for _, directive := range machine.DrainIncludeDirectives() {
+		if err := s.includeDirective(directive, s.Symbols, s.Filename, line); err != nil {
+			return nil, s.report(fmt.Errorf("Include error: %v", err))
+		}
+	}
+	last := machine.LastPoppedStackElem()
+	if last == nil || last.Type() == object.NULL_OBJ {
+		return nil, nil
+	}
+	if _, isInclude := last.(*object.IncludeDirective); isInclude {
+		return nil, nil
+	}
+	return last, nil
+}
+
+// print writes value, the value of a statement, to Out unless it is nil.
+func (s *Session) print(value object.Object) {
+	if value != nil && value.Type() != object.NULL_OBJ {
+		io.WriteString(s.Out, inspectResult(value)+"\n")
+	}
+}
+
+// report writes err to Errors, when it is set, and returns it.
+func (s *Session) report(err error) error {
+	if s.Errors != nil {
+		io.WriteString(s.Errors, err.Error()+"\n")
+	}
+	return err
+}
+
+// nameGlobals fills in the names of the globals of a crash.Fault from the
+// names of the globals of the program that crashed.
+func nameGlobals(err error, names map[int]string) {
+	var fault *crash.Fault
+	if !errors.As(err, &fault) {
+		return
+	}
+	for i := range fault.Globals {
+		fault.Globals[i].Name = names[fault.Globals[i].Index]
+	}
+}
+
+// printWarnings writes the warnings of comp to Warnings, when it is set.
+func printWarnings(comp *compiler.Compiler) {
+	if Warnings == nil {
+		return
+	}
+	for _, w := range comp.Warnings() {
+		fmt.Fprintln(Warnings, w)
+	}
+}
+
+// inspectResult formats a statement's value for echoing. Errors quote the
+// source line they were raised at.
+func inspectResult(o object.Object) string {
+	if e, ok := o.(*object.Error); ok {
+		return e.InspectWithContext()
+	}
+	return object.Format(o)
+}
+
+func printParserErrors(out io.Writer, errors []string) {
+	io.WriteString(out, "ERROR:\n\t\t\n")
+	for _, msg := range errors {
+		io.WriteString(out, "\t"+msg+"\n")
+	}
+}
@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"squ1d++/compiler"
+	"squ1d++/lexer"
+	"squ1d++/object"
+	"squ1d++/parser"
+	"strings"
+	"testing"
+)
+
+func TestSessionRun(t *testing.T) {
+	var out, errs strings.Builder
+	object.OutWriter = &out
+	s := New(&out)
+	s.Errors = &errs
+
+	program, err := s.Parse("var a = 2; a * 3\n[a, \"b\"]")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if err := s.Run(program); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	// Later runs see the globals of earlier ones.
+	program, _ = s.Parse("a + missing")
+	if err := s.Run(program); err == nil {
+		t.Fatalf("expected an error for an undefined variable")
+	}
+
+	if want := "6\n[2, \"b\"]\n"; out.String() != want {
+		t.Errorf("expected output %q, got %q", want, out.String())
+	}
+	if want := "Compilation error: line 1, column 5: Undefined variable missing\n"; errs.String() != want {
+		t.Errorf("expected error report %q, got %q", want, errs.String())
+	}
+}
+
+func TestSessionRunBytecode(t *testing.T) {
+	p := parser.New(lexer.New("[1, string.upper(\"x\")]"))
+	comp := compiler.New()
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		t.Fatalf("Compile returned error: %v", err)
+	}
+
+	var out strings.Builder
+	if err := New(&out).RunBytecode(comp.Bytecode()); err != nil {
+		t.Fatalf("RunBytecode returned error: %v", err)
+	}
+	if want := "[1, \"X\"]\n"; out.String() != want {
+		t.Errorf("expected output %q, got %q", want, out.String())
+	}
+}
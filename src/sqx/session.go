@@ -36,6 +36,8 @@ type SessionConfig struct {
 	CallTimeout time.Duration
 	// Additional environment variables.
 	Env map[string]string
+	// Where the module's stderr lines are logged. Defaults to os.Stderr.
+	Stderr io.Writer
 }
 
 // NewSession starts a persistent SQX module process.
@@ -89,6 +91,10 @@ func NewSession(cfg SessionConfig) (*Session, error) {
 	}
 
 	// Read stderr asynchronously (log it, don't block)
+	logTo := cfg.Stderr
+	if logTo == nil {
+		logTo = os.Stderr
+	}
 	go func() {
 		scanner := bufio.NewScanner(stderrPipe)
 		for scanner.Scan() {
@@ -96,7 +102,7 @@ func NewSession(cfg SessionConfig) (*Session, error) {
 			// redirected to a logger.
 			line := strings.TrimSpace(scanner.Text())
 			if line != "" {
-				fmt.Fprintf(os.Stderr, "[sqx-session:%s] %s\n", filepath.Base(absPath), line)
+				fmt.Fprintf(logTo, "[sqx-session:%s] %s\n", filepath.Base(absPath), line)
 			}
 		}
 	}()
@@ -0,0 +1,60 @@
+// Package std holds the SQU1DLang standard library. Its modules are embedded
+// in the binary and included as "std/<name>", so scripts don't depend on a
+// lib directory being present next to them.
+package std
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed lib/*.sqd
+var files embed.FS
+
+// Prefix starts every standard library include path.
+const Prefix = "std/"
+
+// Resolve maps an include path such as "std/sort" or "std/sort.sqd" to the
+// name of the embedded module, "std/sort.sqd". ok is false when path doesn't
+// name a standard module.
+func Resolve(includePath string) (name string, ok bool) {
+	includePath = strings.ReplaceAll(includePath, "\\", "/")
+	if !strings.HasPrefix(includePath, Prefix) {
+		return "", false
+	}
+	module := strings.TrimPrefix(includePath, Prefix)
+	if !strings.HasSuffix(module, ".sqd") {
+		module += ".sqd"
+	}
+	if module != path.Base(module) {
+		return "", false
+	}
+	if _, err := fs.Stat(files, path.Join("lib", module)); err != nil {
+		return "", false
+	}
+	return Prefix + module, true
+}
+
+// ReadFile returns the contents of an included file, reading standard
+// modules from the binary and anything else from disk.
+func ReadFile(filename string) ([]byte, error) {
+	if name, ok := Resolve(filename); ok {
+		return files.ReadFile(path.Join("lib", strings.TrimPrefix(name, Prefix)))
+	}
+	return os.ReadFile(filename)
+}
+
+// Modules lists the names of the standard modules, e.g. "std/sort.sqd".
+func Modules() []string {
+	entries, _ := fs.ReadDir(files, "lib")
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, Prefix+entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,46 @@
+package std
+
+import (
+	"squ1d++/lexer"
+	"squ1d++/parser"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"std/sort", "std/sort.sqd", true},
+		{"std/sort.sqd", "std/sort.sqd", true},
+		{`std\sort.sqd`, "std/sort.sqd", true},
+		{"std/missing", "", false},
+		{"std/../sort.sqd", "", false},
+		{"lib/sort.sqd", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Resolve(tt.path)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Resolve(%q) = %q, %v; want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestModulesParse(t *testing.T) {
+	modules := Modules()
+	if len(modules) == 0 {
+		t.Fatalf("expected embedded standard modules")
+	}
+	for _, name := range modules {
+		source, err := ReadFile(name)
+		if err != nil {
+			t.Fatalf("could not read %s: %v", name, err)
+		}
+		p := parser.New(lexer.New(string(source)))
+		p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("%s has parse errors: %v", name, p.Errors())
+		}
+	}
+}
@@ -6,6 +6,11 @@ type Token struct {
 	Literal string
 	Line    int
 	Column  int
+	// Offset and End are the byte offsets in the source of the token's
+	// first character and of the one after its last. Both are 0 for tokens
+	// the parser made up.
+	Offset int
+	End    int
 }
 
 const (
@@ -62,6 +67,13 @@ const (
 	CONTINUE    = "CONTINUE"
 	FOR         = "FOR"
 	SHIFT_RIGHT = ">>"
+	ASYNC       = "ASYNC"
+	AWAIT       = "AWAIT"
+	MATCH       = "MATCH"
+	CASE        = "CASE"
+	IN          = "IN"
+	ATTEMPT     = "ATTEMPT"
+	RESCUE      = "RESCUE"
 )
 
 var keywords = map[string]TokenType{
@@ -83,6 +95,13 @@ var keywords = map[string]TokenType{
 	"break":    BREAK,
 	"continue": CONTINUE,
 	"for":      FOR,
+	"async":    ASYNC,
+	"await":    AWAIT,
+	"match":    MATCH,
+	"case":     CASE,
+	"in":       IN,
+	"attempt":  ATTEMPT,
+	"rescue":   RESCUE,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"errors"
+	"squ1d++/object"
+)
+
+// handler is the rescue block of an attempt expression being run: where the
+// stack and the frames were when its attempt block started, and the offset
+// of the rescue block in the frame's instructions.
+type handler struct {
+	frame  int
+	sp     int
+	rescue int
+}
+
+// raised is an error value raised by OpCheckError.
+type raised struct {
+	value *object.Error
+}
+
+func (r *raised) Error() string { return r.value.Summary() }
+
+// uncatchable wraps the errors attempt blocks leave alone: running out of
+// instructions and being cancelled.
+type uncatchable struct {
+	error
+}
+
+func (u uncatchable) Unwrap() error { return u.error }
+
+// rescue unwinds the machine to the innermost handler pushed since the
+// handler count was base and resumes at its rescue block with err as an
+// error value. It reports false when err can't be rescued.
+func (vm *VM) rescue(err error, base int) bool {
+	if len(vm.handlers) <= base {
+		return false
+	}
+	var fatal uncatchable
+	if errors.As(err, &fatal) {
+		return false
+	}
+
+	value := vm.errorValue(err)
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex = h.frame
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.rescue - 1
+	return vm.push(value) == nil
+}
+
+// errorValue returns the error value a rescue block receives for err, with
+// the position and traceback of where it was raised.
+func (vm *VM) errorValue(err error) *object.Error {
+	var r *raised
+	if errors.As(err, &r) {
+		return r.value
+	}
+
+	value := &object.Error{Message: err.Error()}
+	var located *object.RuntimeError
+	if errors.As(vm.locate(err), &located) {
+		value.Message = located.Err.Error()
+		value.Filename, value.Line, value.Column = located.Filename, located.Line, located.Column
+		if len(located.Stack) > 1 {
+			for _, frame := range located.Stack {
+				value.Traceback = append(value.Traceback, frame.String())
+			}
+		}
+	}
+	var kinded *object.KindError
+	if errors.As(err, &kinded) {
+		value.Kind, value.Message = kinded.Kind, kinded.Message
+	}
+	return value
+}
+
+// dropHandlers forgets the handlers of the frame being returned from.
+func (vm *VM) dropHandlers() {
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frame >= vm.framesIndex {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+}
@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"fmt"
+	"squ1d++/object"
+)
+
+// buildClass makes a class from the pairs of method name and method
+// between startIndex and endIndex.
+func (vm *VM) buildClass(name string, startIndex, endIndex int) *object.Class {
+	class := &object.Class{Name: name, Methods: map[string]object.Object{}}
+	for i := startIndex; i < endIndex; i += 2 {
+		class.Methods[vm.stack[i].(*object.String).Value] = vm.stack[i+1]
+	}
+	return class
+}
+
+// executeInstanceDot pushes a field of an instance or one of its methods,
+// bound to it. Like hashes, instances give null for names they lack.
+func (vm *VM) executeInstanceDot(instance *object.Instance, right object.Object) error {
+	name, ok := right.(*object.String)
+	if !ok {
+		return fmt.Errorf("Dot operator requires string identifier, got: %s", right.Type())
+	}
+	value, ok := instance.Get(name.Value)
+	if !ok {
+		return vm.push(Null)
+	}
+	return vm.push(value)
+}
+
+// executeClassDot pushes Name.new, which is the class itself: calling a
+// class creates an instance.
+func (vm *VM) executeClassDot(class *object.Class, right object.Object) error {
+	if name, ok := right.(*object.String); ok && name.Value == "new" {
+		return vm.push(class)
+	}
+	return fmt.Errorf("Class %s has no field %s; only %s.new", class.Name, right.Inspect(), class.Name)
+}
+
+func (vm *VM) executeSetField(target, name, value object.Object) error {
+	field, ok := name.(*object.String)
+	if !ok {
+		return fmt.Errorf("Dot operator requires string identifier, got: %s", name.Type())
+	}
+
+	switch target := target.(type) {
+	case *object.Instance:
+		if err := target.SetField(field.Value, value); err != nil {
+			return fmt.Errorf("%s", err.Message)
+		}
+	case *object.Hash:
+		if err := object.CheckMutable(target); err != nil {
+			return fmt.Errorf("%s", err.Message)
+		}
+		target.Set(field.HashKey(), object.HashPair{Key: field, Value: value})
+	default:
+		return fmt.Errorf("Cannot set field %s of %s", field.Value, target.Type())
+	}
+	return vm.push(Null)
+}
+
+// callMethod calls method with receiver as self, followed by the numArgs
+// arguments on the stack.
+func (vm *VM) callMethod(receiver *object.Instance, method object.Object, numArgs int) error {
+	var params int
+	switch method := method.(type) {
+	case *object.Closure:
+		params = method.Fn.NumParameters
+	case *object.Function:
+		params = len(method.Parameters)
+	}
+	if numArgs != params-1 {
+		return fmt.Errorf("Wrong number of arguments. Expected %d, got %d", params-1, numArgs)
+	}
+
+	// Make room for self under the arguments.
+	if err := vm.push(Null); err != nil {
+		return err
+	}
+	copy(vm.stack[vm.sp-numArgs:vm.sp], vm.stack[vm.sp-1-numArgs:vm.sp-1])
+	vm.stack[vm.sp-1-numArgs] = receiver
+
+	switch method := method.(type) {
+	case *object.Closure:
+		return vm.callClosure(method, numArgs+1)
+	case *object.Function:
+		return vm.callInterpreterFunction(method, numArgs+1)
+	}
+	return fmt.Errorf("Calling non-function and non-builtin function.")
+}
+
+// construct creates an instance of class and runs its init method with the
+// numArgs arguments on the stack. The instance replaces init's result.
+func (vm *VM) construct(class *object.Class, numArgs int) error {
+	instance := object.NewInstance(class)
+
+	init, ok := class.Methods["init"]
+	if !ok {
+		if numArgs != 0 {
+			return fmt.Errorf("Wrong number of arguments. Expected 0, got %d", numArgs)
+		}
+		vm.sp--
+		return vm.push(instance)
+	}
+
+	frames := vm.framesIndex
+	if err := vm.callMethod(instance, init, numArgs); err != nil {
+		return err
+	}
+	if vm.framesIndex > frames {
+		vm.currentFrame().instance = instance
+	} else {
+		// Functions from included files have already run.
+		vm.stack[vm.sp-1] = instance
+	}
+	return nil
+}
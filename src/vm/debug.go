@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"squ1d++/object"
+	"sync/atomic"
+)
+
+// Variable is a named value of a call frame.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// FrameInfo describes an active call frame.
+type FrameInfo struct {
+	// Function is the name of the function running in the frame, "<main>"
+	// for top-level code and "<anonymous>" for unnamed functions.
+	Function string
+	File     string
+	// Line is the line of the statement the frame is executing, 0 if the
+	// function has no line table.
+	Line int
+	// Locals holds the parameters and locals of the frame, followed by the
+	// free variables its closure captured.
+	Locals []Variable
+}
+
+// Depth returns the number of active call frames.
+func (vm *VM) Depth() int {
+	return vm.framesIndex
+}
+
+// executedInstructions counts the instructions of finished Run calls.
+var executedInstructions atomic.Int64
+
+// ExecutedInstructions returns the number of instructions executed by every
+// VM so far, including this one. It is the instruction count runtime
+// reports: a file runs on one VM per top-level statement.
+func (vm *VM) ExecutedInstructions() int {
+	return int(executedInstructions.Load()) + vm.instructionCount - vm.countedInstructions
+}
+
+// countInstructions adds the instructions run since the last call to the
+// process-wide count.
+func (vm *VM) countInstructions() {
+	executedInstructions.Add(int64(vm.instructionCount - vm.countedInstructions))
+	vm.countedInstructions = vm.instructionCount
+}
+
+// Roots returns the globals and the values on the stack.
+func (vm *VM) Roots() []object.Object {
+	roots := make([]object.Object, 0, vm.sp)
+	for _, global := range vm.globals.values {
+		if global != nil {
+			roots = append(roots, global)
+		}
+	}
+	return append(roots, vm.stack[:vm.sp]...)
+}
+
+// Frames returns the active call frames, innermost first. It is meant to be
+// called from LineHook, while the machine is paused on a statement.
+func (vm *VM) Frames() []FrameInfo {
+	frames := make([]FrameInfo, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		if frame == nil || frame.cl == nil || frame.cl.Fn == nil {
+			continue
+		}
+		fn := frame.cl.Fn
+
+		info := FrameInfo{Function: frameName(i, fn), File: frame.file(), Line: frame.line()}
+		for slot, name := range fn.LocalNames {
+			if name == "" || frame.basePointer+slot >= len(vm.stack) {
+				continue
+			}
+			value := object.Deref(vm.stack[frame.basePointer+slot])
+			if value == nil {
+				value = Null
+			}
+			info.Locals = append(info.Locals, Variable{Name: name, Value: value})
+		}
+		for slot, name := range fn.FreeNames {
+			if slot < len(frame.cl.Free) {
+				info.Locals = append(info.Locals, Variable{Name: name, Value: object.Deref(frame.cl.Free[slot])})
+			}
+		}
+		frames = append(frames, info)
+	}
+	return frames
+}
+
+// frameName returns the name of the function running in the frame at index.
+func frameName(index int, fn *object.CompiledFunction) string {
+	switch {
+	case index == 0:
+		return "<main>"
+	case fn.Name == "":
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
+// line returns the line of the last statement starting at or before the
+// frame's instruction pointer.
+func (f *Frame) line() int {
+	start, line := -1, 0
+	for offset, lines := range f.cl.Fn.Lines {
+		if offset <= f.ip && offset > start && len(lines) > 0 {
+			start, line = offset, lines[len(lines)-1]
+		}
+	}
+	return line
+}
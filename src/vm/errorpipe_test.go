@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"path/filepath"
 	"squ1d++/compiler"
 	"squ1d++/lexer"
 	"squ1d++/object"
@@ -80,3 +81,50 @@ func TestUnblockWithErrorPipeAssignsErrorInVM(t *testing.T) {
 		t.Fatalf("expected last popped to be Error, got %T (%+v)", last, last)
 	}
 }
+
+func TestErrorKinds(t *testing.T) {
+	load := `var load = def(path) { if (path == "") { return error.new("IOError", "no path", {"path": path}) }; path }; `
+	tests := []vmTestCase{
+		{load + `var e = << load(""); error.kind(e)`, "IOError"},
+		{load + `var e = << load(""); error.msg(e)`, "no path"},
+		{load + `var e = << load(""); e.kind`, "IOError"},
+		{load + `var e = << load(""); error.data(e).path`, ""},
+		{load + `var e = << load("a"); e == null`, true},
+		{load + `attempt { load("") } rescue (e) { error.kind(e) }`, "IOError"},
+		{`var e = << json.parse("{"); error.kind(e)`, "Error"},
+		{`error.data(error.new("TypeError", "bad")) == null`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinErrorKinds(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	tests := []vmTestCase{
+		// Arguments of the wrong type.
+		{`var e = << json.parse(1); error.kind(e)`, "TypeError"},
+		{`var e = << string.fmt(1); e.message`, "Argument 0 to `fmt` must be STRING, got INTEGER"},
+		{`var e = << string.fmt("%d", "a"); e.kind`, "TypeError"},
+		{`attempt { array.remove([1], "a") } rescue (e) { e.kind }`, "TypeError"},
+		// Operations on values of the wrong type.
+		{`attempt { 1[0] } rescue (e) { e.kind }`, "TypeError"},
+		{`attempt { 1[0] } rescue (e) { e.message }`, "Index operator not supported: INTEGER"},
+		{`attempt { -"a" } rescue (e) { e.kind }`, "TypeError"},
+		{`attempt { 1[0:1] } rescue (e) { e.kind }`, "TypeError"},
+		{`attempt { {[1]: 2} } rescue (e) { e.kind }`, "TypeError"},
+		// Reading and writing that fails.
+		{`var e = << file.read("` + missing + `"); error.kind(e)`, "IOError"},
+		{`var e = << file.read_bytes("` + missing + `"); error.kind(e)`, "IOError"},
+		{`attempt { stream.open("` + missing + `", "r") } rescue (e) { e.kind }`, "IOError"},
+		// Indexes outside the value.
+		{`var e = << array.remove([1], 5); error.kind(e)`, "IndexError"},
+		{`var e = << array.remove([1], 5); e.message`, "Index 5 is out of range (array length is 1)"},
+		{`attempt { [1, 2][1:5] } rescue (e) { e.kind }`, "IndexError"},
+		{`var e = << bytes.set(bytes.new([1]), 3, 0); error.kind(e)`, "IndexError"},
+		// Errors of no particular kind.
+		{`var e = << json.parse("{"); error.kind(e)`, "Error"},
+		{`attempt { 1 / 0 } rescue (e) { e.kind }`, "Error"},
+	}
+
+	runVmTests(t, tests)
+}
@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"errors"
+	"squ1d++/object"
+)
+
+// locate wraps err in an object.RuntimeError holding the call stack and, when
+// the current frame's code comes from a known file, the position of its
+// instruction. Errors that already went through locate are returned as they
+// are, and so are errors raised by top-level code without a file, which
+// have nothing to add.
+func (vm *VM) locate(err error) error {
+	var located *object.RuntimeError
+	if errors.As(err, &located) {
+		return err
+	}
+
+	runtimeErr := &object.RuntimeError{Err: err, Stack: vm.callStack()}
+	frame := vm.currentFrame()
+	if pos, file := frame.position(), frame.file(); file != "" && pos.Line > 0 {
+		runtimeErr.Filename = file
+		runtimeErr.Line, runtimeErr.Column = pos.Line, pos.Column
+	} else if len(runtimeErr.Stack) < 2 {
+		return err
+	}
+	return runtimeErr
+}
+
+// callStack returns the active calls, outermost first, each at the line of
+// the instruction it is running.
+func (vm *VM) callStack() []object.StackFrame {
+	stack := make([]object.StackFrame, 0, vm.framesIndex)
+	for i := 0; i < vm.framesIndex; i++ {
+		frame := vm.frames[i]
+		if frame == nil || frame.cl == nil || frame.cl.Fn == nil {
+			continue
+		}
+		// Machines that only run closures handed to Call, such as those of
+		// tasks and event handlers, have an empty main function.
+		if i == 0 && len(frame.cl.Fn.Instructions) == 0 {
+			continue
+		}
+		stack = append(stack, object.StackFrame{
+			Function: frameName(i, frame.cl.Fn),
+			File:     frame.file(),
+			Line:     frame.position().Line,
+		})
+	}
+	return stack
+}
+
+// file returns the source file of the instruction at the frame's
+// instruction pointer.
+func (f *Frame) file() string {
+	return f.cl.Fn.FileAt(f.ip)
+}
+
+// position returns the source position of the instruction at the frame's
+// instruction pointer: the closest recorded position at or before it
+// within the current statement, or just the statement's line.
+func (f *Frame) position() object.Position {
+	start := -1
+	for offset, lines := range f.cl.Fn.Lines {
+		if offset <= f.ip && offset > start && len(lines) > 0 {
+			start = offset
+		}
+	}
+
+	best := -1
+	var pos object.Position
+	for offset, p := range f.cl.Fn.Positions {
+		if offset <= f.ip && offset >= start && offset > best {
+			best, pos = offset, p
+		}
+	}
+	if best >= 0 {
+		return pos
+	}
+	return object.Position{Line: f.line()}
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"errors"
+	"squ1d++/compiler"
+	"squ1d++/object"
+	"strings"
+	"testing"
+)
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"var a = 1\nvar b = a(2)",
+			"errors.sqd, line 2, column 10: Calling non-function and non-builtin function.\n" +
+				"  var b = a(2)\n" +
+				"           ^",
+		},
+		{
+			"var f = def(x) {\n\treturn 1 + x / 0\n}\nf(3)",
+			"errors.sqd, line 2, column 15: Division by zero\n" +
+				"  \treturn 1 + x / 0\n" +
+				"  \t             ^\n" +
+				"\n" +
+				"Traceback:\n" +
+				"  in <main> (errors.sqd:4)\n" +
+				"  in f (errors.sqd:2)",
+		},
+	}
+
+	for _, tt := range tests {
+		object.RegisterSource("errors.sqd", tt.input)
+		comp := compiler.New()
+		comp.Filename = "errors.sqd"
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+		var runtimeErr *object.RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("expected a *object.RuntimeError, got %T (%v)", err, err)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error.\nwant=%q\ngot= %q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestRuntimeErrorWithoutFile(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("1 / 0")); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	if err == nil || err.Error() != "Division by zero" {
+		t.Fatalf("expected the plain error for code without a file, got %v", err)
+	}
+}
+
+func TestRuntimeErrorTraceback(t *testing.T) {
+	input := "var h = def(x) { 10 / x }\nvar k = def() {\n\th(0)\n}\nk()"
+	object.RegisterSource("trace.sqd", input)
+	comp := compiler.New()
+	comp.Filename = "trace.sqd"
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	err := New(comp.Bytecode()).Run()
+	expected := "trace.sqd, line 1, column 21: Division by zero\n" +
+		"  var h = def(x) { 10 / x }\n" +
+		"                      ^\n" +
+		"\n" +
+		"Traceback:\n" +
+		"  in <main> (trace.sqd:5)\n" +
+		"  in k (trace.sqd:3)\n" +
+		"  in h (trace.sqd:1)"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("wrong error.\nwant=%q\ngot= %v", expected, err)
+	}
+
+	var runtimeErr *object.RuntimeError
+	if !errors.As(err, &runtimeErr) || len(runtimeErr.Stack) != 3 || runtimeErr.Stack[1].Function != "k" {
+		t.Fatalf("expected the call stack on the error, got %#v", runtimeErr)
+	}
+}
+
+func TestRuntimeErrorTracebackWithoutFile(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("var f = def(a, b) { a }\nvar g = def() { f(1) }\ng()")); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	err := New(comp.Bytecode()).Run()
+	expected := "Wrong number of arguments. Expected 2, got 1\n\nTraceback:\n  in <main> (line 3)\n  in g (line 2)"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("wrong error.\nwant=%q\ngot= %v", expected, err)
+	}
+}
+
+func TestRuntimeErrorTracebackCollapsesRecursion(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("f >> (n) {\n\treturn f(n + 1)\n}\nf(0)")); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	err := NewWithOptions(comp.Bytecode(), nil, Options{MaxFrames: 100}).Run()
+	expected := "STACK OVERFLOW: calls nested more than 100 deep\n\nTraceback:\n" +
+		"  in <main> (line 4)\n" +
+		"  in f (line 2)\n" +
+		"  ... repeated 98 more times"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("wrong error.\nwant=%q\ngot= %v", expected, err)
+	}
+}
+
+func TestErrorValueTraceback(t *testing.T) {
+	input := "var f = def() { string.upper(1) }\nvar g = def() { f() }\n<< g()"
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	e, ok := machine.LastPoppedStackElem().(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error, got %v", machine.LastPoppedStackElem())
+	}
+	expected := []string{"in <main> (line 3)", "in g (line 2)", "in f (line 1)"}
+	if strings.Join(e.Traceback, "|") != strings.Join(expected, "|") {
+		t.Fatalf("wrong traceback: %q", e.Traceback)
+	}
+}
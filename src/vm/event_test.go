@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"squ1d++/object"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	t.Cleanup(func() { object.EventHandlers = nil })
+
+	runVmTests(t, []vmTestCase{
+		// Handlers run in the order they were registered.
+		{`var log = []; event.on("ping", def(x) { log = log + [x] }); event.on("ping", def(x) { log = log + [x * 10] });
+		  [event.emit("ping", 1), log]`, []interface{}{2, []interface{}{1, 10}}},
+		{`event.emit("nobody listens")`, 0},
+		// off removes one handler or all of them.
+		{`var n = 0; var h = def() { n = n + 1 }; event.on("tick", h); event.on("tick", def() { n = n + 10 });
+		  var removed = event.off("tick", h); event.emit("tick");
+		  [removed, n, event.off("tick"), event.off("tick"), event.emit("tick")]`, []interface{}{true, 10, true, false, 0}},
+		// The loop runs timers in order and returns once none are left.
+		{`var order = []; event.after(20, def() { order = order + ["b"] }); event.after(5, def() { order = order + ["a"] });
+		  event.run(); order`, []interface{}{"a", "b"}},
+		{`var n = 0; var id = 0; id = event.every(2, def() { n = n + 1; if (n == 3) { event.cancel(id) } }); event.run(); n`, 3},
+		// stop leaves the timers scheduled for the next run.
+		{`var n = 0; var id = event.every(2, def() { n = n + 1; if (n == 2) { event.stop() } }); event.run(); [n, event.cancel(id)]`,
+			[]interface{}{2, true}},
+		{`event.cancel(12345)`, false},
+	})
+}
+
+func TestPostedEvents(t *testing.T) {
+	t.Cleanup(func() { object.EventHandlers = nil })
+
+	runVmTests(t, []vmTestCase{
+		// The loop dispatches posted events in order, including those its
+		// handlers and running tasks post.
+		{`var got = []; event.on("job", def(n) { got = array.append(got, n); if (n == 1) { event.post("job", 10) } });
+		  event.post("job", 1); event.post("job", 2);
+		  spawn def() { time.sleep(20); event.post("job", 3) };
+		  event.run(); json.write(got)`, "[1,2,10,3]"},
+		// post doesn't call the handlers itself, and stop leaves the
+		// queued events for the next run.
+		{`var n = 0; event.on("inc", def() { n = n + 1; event.stop() });
+		  event.post("inc"); event.post("inc"); var before = n; event.run(); var once = n; event.run();
+		  json.write([before, once, n])`, "[0,1,2]"},
+	})
+
+	// Go code posts from its own goroutine while the loop waits on a
+	// timer.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		object.PostEvent("request", &object.String{Value: "from Go"})
+	}()
+	runVmTests(t, []vmTestCase{
+		{`var got = ""; var id = event.after(5000, def() {});
+		  event.on("request", def(s) { got = s; event.cancel(id) });
+		  event.run(); got`, "from Go"},
+	})
+}
+
+func TestEventErrors(t *testing.T) {
+	t.Cleanup(func() { object.EventHandlers = nil })
+
+	tests := []errorTestCase{
+		{`event.on("e", def(a, b) { a }); event.emit("e", 1)`, "Handler parameter mismatch for event 'e': expected 2, got 1"},
+		{`event.on(1, def() {})`, "Argument 0 to `on` must be STRING, got INTEGER"},
+		{`event.after(10, def(x) { x })`, "Argument 1 to `after` must be a FUNCTION without parameters, got CLOSURE"},
+		{`event.every(0, def() {})`, "Argument 0 to `every` must be a positive number of milliseconds, got 0"},
+		{`event.after(1, def() { 1() }); event.run()`, "Calling non-function and non-builtin function."},
+		{`event.post(1)`, "Argument 0 to `post` must be STRING, got INTEGER"},
+		{`event.on("bad", def(a) { a }); event.post("bad"); event.run()`, "Handler parameter mismatch for event 'bad': expected 1, got 0"},
+	}
+
+	runErrorTests(t, tests)
+}
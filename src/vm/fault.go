@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"fmt"
+	"runtime/debug"
+	"squ1d++/crash"
+	"squ1d++/object"
+)
+
+// faultStackDepth is how many values from the top of the stack a fault
+// records.
+const faultStackDepth = 16
+
+// fault describes the machine's state after a panic with value r.
+func (vm *VM) fault(r interface{}) *crash.Fault {
+	f := &crash.Fault{Value: r, GoStack: debug.Stack(), Engine: "vm"}
+
+	frame := vm.currentFrame()
+	f.File = frame.file()
+	f.Line = frame.line()
+	if ins := frame.Instructions(); vm.lastIP < len(ins) {
+		f.Offset = vm.lastIP
+		f.Instruction = decodeInstruction(ins, vm.lastIP)
+	}
+
+	for _, info := range vm.Frames() {
+		line := info.Function
+		if info.File != "" || info.Line > 0 {
+			line += fmt.Sprintf(" (%s:%d)", info.File, info.Line)
+		}
+		f.Frames = append(f.Frames, line)
+		for _, local := range info.Locals {
+			f.Frames = append(f.Frames, fmt.Sprintf("    %s = %s", local.Name, traceValue(local.Value)))
+		}
+	}
+
+	from := max(vm.sp-faultStackDepth, 0)
+	for i := vm.sp - 1; i >= from; i-- {
+		f.Stack = append(f.Stack, traceValue(vm.stack[i]))
+	}
+	if from > 0 {
+		f.Stack = append(f.Stack, fmt.Sprintf("... %d more", from))
+	}
+
+	for index, global := range vm.globals.values {
+		if global == nil || object.IsBuiltinValue(global) {
+			continue
+		}
+		f.Globals = append(f.Globals, crash.Global{
+			Index: index,
+			Type:  string(global.Type()),
+			Value: traceValue(global),
+		})
+	}
+	return f
+}
@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"squ1d++/compiler"
+	"squ1d++/crash"
+	"squ1d++/object"
+	"strings"
+	"testing"
+)
+
+func TestFault(t *testing.T) {
+	symbols := compiler.NewSymbolTable()
+	boom := symbols.Define("boom")
+	globals := NewGlobals()
+	globals.Set(boom.Index, &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		var values []object.Object
+		return values[len(args)]
+	}})
+
+	comp := compiler.NewWithState(symbols, nil)
+	comp.Filename = "fault.sqd"
+	if err := comp.Compile(parse("var count = 41\nvar f = def(n) { boom(n) }\nf(count)")); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	err := NewWithGlobalsStore(comp.Bytecode(), globals).Run()
+	var fault *crash.Fault
+	if !errors.As(err, &fault) {
+		t.Fatalf("expected a *crash.Fault, got %T (%v)", err, err)
+	}
+	if fault.Engine != "vm" || fault.File != "fault.sqd" || fault.Line != 2 {
+		t.Errorf("wrong fault location: engine=%s file=%s line=%d", fault.Engine, fault.File, fault.Line)
+	}
+	if fault.Instruction != "OpCall 1" {
+		t.Errorf("expected the fault at OpCall 1, got %q at %04d", fault.Instruction, fault.Offset)
+	}
+
+	var report bytes.Buffer
+	if err := fault.WriteReport(&report, "1.2.3"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Error:       runtime error: index out of range [1] with length 0",
+		"Version:     squ1d++ 1.2.3",
+		"  f (fault.sqd:2)\n      n = 41\n  <main> (fault.sqd:3)",
+		"VM stack (topmost first):\n  41\n  FUNCTION\n",
+		"#1               INTEGER      41",
+		"Go stack:\n",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("expected the report to contain %q, got:\n%s", want, report.String())
+		}
+	}
+}
+
+func TestNonFaultPanicPropagates(t *testing.T) {
+	type unwind struct{}
+	symbols := compiler.NewSymbolTable()
+	stop := symbols.Define("stop")
+	globals := NewGlobals()
+	globals.Set(stop.Index, &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		panic(unwind{})
+	}})
+
+	comp := compiler.NewWithState(symbols, nil)
+	if err := comp.Compile(parse("stop()")); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	defer func() {
+		if _, ok := recover().(unwind); !ok {
+			t.Fatalf("expected the panic to reach the caller")
+		}
+	}()
+	NewWithGlobalsStore(comp.Bytecode(), globals).Run()
+}
@@ -9,6 +9,9 @@ type Frame struct {
 	cl          *object.Closure
 	ip          int
 	basePointer int
+	// instance is set for the init method run by Name.new, which returns
+	// it instead of its own result.
+	instance *object.Instance
 }
 
 func NewFrame(cl *object.Closure, basePointer int) *Frame {
@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"squ1d++/object"
+	"sync"
+)
+
+// initialGlobals is the number of slots a new store starts with. Programs
+// rarely need more; the store grows when they do.
+const initialGlobals = 256
+
+// Globals is the store of global variables shared by the machines that run
+// one program: the REPL's statements, included files and spawned tasks. It
+// starts small and grows as the program defines more globals.
+//
+// Only the goroutine holding the interpreter uses the store, except while
+// array.pmap runs its function on several machines at once: then Get and
+// Set take mu, so the machines can read and assign globals safely.
+type Globals struct {
+	values []object.Object
+	mu     sync.RWMutex
+}
+
+// NewGlobals returns an empty store.
+func NewGlobals() *Globals {
+	return &Globals{values: make([]object.Object, initialGlobals)}
+}
+
+// Get returns the value of slot index, or nil if it was never set.
+func (g *Globals) Get(index int) object.Object {
+	if object.InParallel() {
+		return g.lockedGet(index)
+	}
+	if index >= len(g.values) {
+		return nil
+	}
+	return g.values[index]
+}
+
+// Set stores value in slot index, growing the store if needed.
+func (g *Globals) Set(index int, value object.Object) {
+	if object.InParallel() {
+		g.lockedSet(index, value)
+		return
+	}
+	g.Grow(index + 1)
+	g.values[index] = value
+}
+
+// lockedGet and lockedSet are Get and Set for parallel sections.
+func (g *Globals) lockedGet(index int) object.Object {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if index >= len(g.values) {
+		return nil
+	}
+	return g.values[index]
+}
+
+func (g *Globals) lockedSet(index int, value object.Object) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.Grow(index + 1)
+	g.values[index] = value
+}
+
+// Grow makes room for n slots. Machines grow the store for the globals
+// their bytecode defines before they start, so the store doesn't change
+// size while parallel machines share it.
+func (g *Globals) Grow(n int) {
+	if n <= len(g.values) {
+		return
+	}
+	size := max(2*len(g.values), n)
+	values := make([]object.Object, size)
+	copy(values, g.values)
+	g.values = values
+}
+
+// Len returns the number of slots.
+func (g *Globals) Len() int { return len(g.values) }
@@ -0,0 +1,45 @@
+package vm
+
+import (
+	"squ1d++/compiler"
+	"squ1d++/object"
+)
+
+// Options are the limits of a machine. Zero fields take the defaults.
+type Options struct {
+	// StackSize is the most values the stack grows to. By default it is
+	// object.SysMaxStackSize, which sys.set_overflow_size changes while
+	// the program runs.
+	StackSize int
+	// MaxFrames is how deeply calls can nest, MaxFrames by default.
+	MaxFrames int
+}
+
+// DefaultOptions are the options of the machines made by New and
+// NewWithGlobalsStore, and so of the programs the runner runs.
+var DefaultOptions Options
+
+// NewWithOptions returns a machine for bytecode with the limits opts sets,
+// sharing globals, or with a store of its own when globals is nil.
+func NewWithOptions(bytecode *compiler.Bytecode, globals *Globals, opts Options) *VM {
+	if globals == nil {
+		globals = NewGlobals()
+	}
+	return newVM(bytecode, globals, opts)
+}
+
+// maxStackSize returns the most values the stack can hold.
+func (vm *VM) maxStackSize() int {
+	if vm.options.StackSize > 0 {
+		return vm.options.StackSize
+	}
+	return object.SysMaxStackSize
+}
+
+// maxFrames returns how deeply calls can nest.
+func (vm *VM) maxFrames() int {
+	if vm.options.MaxFrames > 0 {
+		return vm.options.MaxFrames
+	}
+	return MaxFrames
+}
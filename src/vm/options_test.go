@@ -0,0 +1,34 @@
+package vm
+
+import (
+	"squ1d++/compiler"
+	"strings"
+	"testing"
+)
+
+func TestOptionsLimits(t *testing.T) {
+	input := "var f = def(n) { if (n == 0) { return 0 }; return 1 + f(n - 1) }; f(3000)"
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	err := New(bytecode).Run()
+	if err == nil || !strings.Contains(err.Error(), "calls nested more than 1024 deep") {
+		t.Fatalf("expected the default frame limit, got %v", err)
+	}
+
+	machine := NewWithOptions(bytecode, nil, Options{MaxFrames: 5000, StackSize: 100000})
+	if err := machine.Run(); err != nil {
+		t.Fatalf("run with higher limits: %s", err)
+	}
+	if err := testIntegerObject(3000, machine.LastPoppedStackElem()); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewWithOptions(bytecode, nil, Options{MaxFrames: 5000, StackSize: 1024}).Run()
+	if err == nil || !strings.Contains(err.Error(), "STACK OVERFLOW") {
+		t.Fatalf("expected the stack limit, got %v", err)
+	}
+}
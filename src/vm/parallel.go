@@ -0,0 +1,83 @@
+package vm
+
+import (
+	"squ1d++/object"
+	"sync"
+	"sync/atomic"
+)
+
+// Map calls cl with each of items and returns the results in order. The
+// calls are spread over up to workers machines, each running on its own
+// goroutine, sharing this one's constants and globals. This machine waits
+// for them, and spawned tasks are paused meanwhile. The arrays, hashes and
+// captured variables that cl and the globals reach can't be changed while
+// the calls run; each call returns what it changes instead.
+//
+// The calls run one after the other on a single machine when the debugger,
+// coverage, statistics, the profiler, tracing or a recording is active:
+// those watch one machine at a time or need the calls in a repeatable
+// order.
+func (vm *VM) Map(cl *object.Closure, items []object.Object, workers int) ([]object.Object, error) {
+	results := make([]object.Object, len(items))
+	// The calls can read what the function and the globals reach, but not
+	// change it: there is no lock on values. They are held to it when they
+	// run one by one too, so a program behaves the same either way.
+	release := object.Share(append([]object.Object{cl}, vm.globals.values...)...)
+	defer release()
+
+	workers = min(workers, len(items))
+	if workers <= 1 || LineHook != nil || CurrentStats != nil || sampler != nil || object.Tracing || object.TraceActive() {
+		machine := vm.fork()
+		for i, item := range items {
+			result, err := machine.Call(cl, item)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = result
+		}
+		return results, nil
+	}
+
+	end := object.BeginParallel()
+	defer end()
+
+	// Each worker takes the next item until they run out. After a failed
+	// call the workers stop, returning the error of the earliest item that
+	// failed.
+	var next atomic.Int64
+	var mu sync.Mutex
+	failedAt := len(items)
+	var failure error
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			machine := vm.fork()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(items) {
+					return
+				}
+				result, err := machine.Call(cl, items[i])
+				if err != nil {
+					mu.Lock()
+					if i < failedAt {
+						failedAt, failure = i, err
+					}
+					mu.Unlock()
+					next.Store(int64(len(items)))
+					return
+				}
+				results[i] = result
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failure != nil {
+		return nil, failure
+	}
+	return results, nil
+}
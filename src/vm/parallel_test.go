@@ -0,0 +1,67 @@
+package vm
+
+import (
+	"squ1d++/object"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`var fib = def(n) { if (n < 2) { return n }; fib(n - 1) + fib(n - 2) }; array.pmap([10, 1, 15, 2, 12], fib, 3)`,
+			[]interface{}{55, 1, 610, 1, 144}},
+		// Functions see globals and what they closed over.
+		{`var base = 100; var add = def(k) { def(x) { base + k + x } }; array.pmap([1, 2, 3], add(10))`,
+			[]interface{}{111, 112, 113}},
+		{`array.pmap([1, 2], def(x) { array.pmap([x, x], def(y) { y * 10 }, 2) }, 2)`,
+			[]interface{}{[]interface{}{10, 10}, []interface{}{20, 20}}},
+		// Workers can share a mutex and a channel.
+		{`var m = sync.mutex(); var c = chan.new(10);
+		  array.pmap([1, 2, 3, 4], def(x) { sync.with(m, def() { chan.send(c, x) }) }, 4);
+		  var sum = 0; var i = 0; while (i < 4) { sum = sum + chan.recv(c); i = i + 1 }; sum`, 10},
+		{`array.pmap([], def(x) { x })`, []interface{}{}},
+		// Workers can assign globals while others read them; run with -race
+		// to check the store.
+		{`var last = 0; var total = sync.counter();
+		  array.pmap([1, 2, 3, 4, 5, 6, 7, 8], def(x) { var i = 0; while (i < 200) { last = x; i = i + 1 + last * 0 }; sync.atomic_add(total, x) }, 4);
+		  json.write([sync.get(total), last >= 1 and last <= 8])`, "[36,true]"},
+	})
+	runErrorTests(t, []errorTestCase{
+		{`array.pmap([1, 2, 3, 4], def(x) { if (x > 1) { x() }; x }, 2)`, "Calling non-function and non-builtin function."},
+		{`array.pmap([1, 2], def(x) { spawn(def() { x }) }, 2)[0]`, "spawn can't be used in functions run by array.pmap"},
+		{`array.pmap([1], def(a, b) { a })`, "The function passed to `pmap` must take 1 argument, not 2"},
+		{`array.pmap([1], def(x) { x }, 0)`, "pmap needs at least 1 worker, got 0"},
+		// What the workers share can be read but not changed.
+		{`var count = def(xs) { var n = 0; array.pmap(xs, def(x) { n = n + x }, 2) }; count([1, 2])`,
+			"Cannot assign to a variable shared by array.pmap workers; return the changes instead"},
+	})
+}
+
+func TestMapWorkersRunOnTheirOwnMachines(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`array.pmap([1, 2, 3], def(x) { runtime.stack_depth() }, 3)`, []interface{}{2, 2, 2}},
+		// What the workers share can be read but not changed; run with -race
+		// to check that writing to it is refused rather than racing.
+		{`var seen = {}; array.pmap([1, 2, 3, 4], def(x) { hash.set(seen, x, true) }, 4)[3]`,
+			&object.Error{Message: "Cannot modify a HASH shared by array.pmap workers; return the changes instead"}},
+		{`var stack = [1, 2]; array.pmap([1, 2], def(x) { array.pop(stack) }, 2)[1]`,
+			&object.Error{Message: "Cannot modify a ARRAY shared by array.pmap workers; return the changes instead"}},
+		// Each worker has its own copy of what it builds, and the shared
+		// values can be changed again once the calls are done.
+		{`var seen = {"a": [1]}; var copies = array.pmap([1, 2, 3, 4], def(x) { var h = {}; hash.set(h, "v", x + seen["a"][0]); h }, 4);
+		  hash.set(seen, "b", 2); array.pop(seen["a"]); json.write([copies, seen])`,
+			`[[{"v":2},{"v":3},{"v":4},{"v":5}],{"a":[],"b":2}]`},
+	})
+}
+
+func TestMapRunsInOrderWhenWatched(t *testing.T) {
+	CurrentStats = NewStats()
+	defer func() { CurrentStats = nil }()
+
+	// Run one by one, each call is the running machine's only one.
+	runVmTests(t, []vmTestCase{
+		{`array.pmap([1, 2, 3], def(x) { runtime.stack_depth() }, 3)`, []interface{}{2, 2, 2}},
+	})
+	if CurrentStats.Calls["array.pmap"] != 1 {
+		t.Errorf("expected the statistics to count the pmap call, got %v", CurrentStats.Calls)
+	}
+}
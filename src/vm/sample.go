@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// sampler receives the samples taken while StartSampling is active.
+	sampler        func(stack []string, n int)
+	sampleInterval time.Duration
+	lastSample     time.Time
+	// sampleDue is set by the sampling ticker and cleared by the VM that
+	// takes the sample.
+	sampleDue atomic.Bool
+)
+
+// StartSampling samples the call stack of the running VM about every
+// interval until the returned function is called. The dispatch loop takes
+// the samples, so sample runs on the VM's goroutine with the stack,
+// outermost function first, and the number of intervals that passed since
+// the previous sample: time spent in a builtin, or while the ticker couldn't
+// run, is attributed to the stack the VM is in when it next takes a sample.
+// Only one sampler can be active at a time.
+func StartSampling(interval time.Duration, sample func(stack []string, n int)) (stop func()) {
+	sampler = sample
+	sampleInterval = interval
+	lastSample = time.Now()
+	sampleDue.Store(false)
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-ticker.C:
+				sampleDue.Store(true)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-finished
+		sampler = nil
+		sampleDue.Store(false)
+	}
+}
+
+// takeSample hands the current call stack to the sampler.
+func (vm *VM) takeSample() {
+	sampleDue.Store(false)
+	if sampler == nil {
+		return
+	}
+	now := time.Now()
+	n := max(int(now.Sub(lastSample)/sampleInterval), 1)
+	lastSample = now
+
+	stack := make([]string, 0, vm.framesIndex)
+	for i := 0; i < vm.framesIndex; i++ {
+		fn := vm.frames[i].cl.Fn
+		switch {
+		case i == 0 && fn.Filename != "":
+			stack = append(stack, filepath.Base(fn.Filename))
+		case i == 0:
+			stack = append(stack, "<main>")
+		case fn.Name != "":
+			stack = append(stack, fn.Name)
+		default:
+			stack = append(stack, anonymousName(fn.Filename, firstLine(fn.Lines)))
+		}
+	}
+	sampler(stack, n)
+}
+
+// anonymousName names an unnamed function after the file and first line of
+// its body.
+func anonymousName(file string, line int) string {
+	if file == "" || line == 0 {
+		return "<anonymous>"
+	}
+	return fmt.Sprintf("<anonymous %s:%d>", filepath.Base(file), line)
+}
+
+// firstLine returns the first line of a line table, 0 if it is empty.
+func firstLine(lines map[int][]int) int {
+	first := 0
+	for _, ls := range lines {
+		for _, line := range ls {
+			if first == 0 || line < first {
+				first = line
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,60 @@
+package vm
+
+import (
+	"squ1d++/compiler"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTakeSample(t *testing.T) {
+	input := "var f = def(x) { x }\nvar g = def() { f(1) }\ng()"
+	comp := compiler.New()
+	comp.Filename = "/tmp/main.sqd"
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	var stacks []string
+	sampler = func(stack []string, n int) {
+		if n < 1 {
+			t.Errorf("expected at least one sample, got %d", n)
+		}
+		stacks = append(stacks, strings.Join(stack, ";"))
+		// Sample every instruction.
+		sampleDue.Store(true)
+	}
+	sampleInterval = time.Hour
+	lastSample = time.Now()
+	sampleDue.Store(true)
+	defer func() {
+		sampler = nil
+		sampleDue.Store(false)
+	}()
+
+	if err := New(comp.Bytecode()).Run(); err != nil {
+		t.Fatalf("Vm error: %s", err)
+	}
+
+	got := strings.Join(stacks, "\n")
+	for _, want := range []string{"main.sqd\n", "main.sqd;g\n", "main.sqd;g;f\n"} {
+		if !strings.Contains(got+"\n", want) {
+			t.Fatalf("expected stack %q among samples, got:\n%s", strings.TrimSpace(want), got)
+		}
+	}
+}
+
+func TestStartSampling(t *testing.T) {
+	stop := StartSampling(time.Millisecond, func([]string, int) {})
+	deadline := time.Now().Add(time.Second)
+	for !sampleDue.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !sampleDue.Load() {
+		t.Fatalf("expected the ticker to request a sample")
+	}
+	stop()
+	if sampler != nil || sampleDue.Load() {
+		t.Fatalf("expected stop to remove the sampler")
+	}
+}
@@ -0,0 +1,130 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"squ1d++/code"
+	"squ1d++/object"
+)
+
+// Stats are execution statistics gathered by every VM while CurrentStats is
+// set.
+type Stats struct {
+	// Instructions is the number of instructions executed and Opcodes the
+	// number executed per opcode.
+	Instructions int
+	Opcodes      [256]int
+	// Calls counts the calls made per function name. Builtins are named
+	// class.name, unnamed functions after where their body starts.
+	Calls map[string]int
+	// PeakStack is the most values the stack held and PeakFrames the
+	// deepest the calls went, counting the top level as one frame.
+	PeakStack  int
+	PeakFrames int
+	// Constants is the size of the largest constant pool a VM ran with.
+	Constants int
+
+	builtinNames map[*object.Builtin]string
+}
+
+// CurrentStats, when set, collects the statistics of every instruction the
+// VMs execute.
+var CurrentStats *Stats
+
+// NewStats returns empty statistics.
+func NewStats() *Stats {
+	names := map[*object.Builtin]string{}
+	for _, def := range object.Builtins {
+		name := def.Name
+		if def.Builtin.Class != "" {
+			name = def.Builtin.Class + "." + name
+		}
+		names[def.Builtin] = name
+	}
+	return &Stats{Calls: map[string]int{}, builtinNames: names}
+}
+
+// record counts the instruction op about to run on vm.
+func (s *Stats) record(vm *VM, op code.Opcode) {
+	s.Instructions++
+	s.Opcodes[op]++
+	s.PeakStack = max(s.PeakStack, vm.sp)
+	s.PeakFrames = max(s.PeakFrames, vm.framesIndex)
+	s.Constants = max(s.Constants, len(vm.constants))
+}
+
+// call counts a call of callee.
+func (s *Stats) call(callee object.Object) {
+	switch callee := callee.(type) {
+	case *object.Closure:
+		name := callee.Fn.Name
+		if name == "" {
+			name = anonymousName(callee.Fn.Filename, firstLine(callee.Fn.Lines))
+		}
+		s.Calls[name]++
+	case *object.Builtin:
+		if name, ok := s.builtinNames[callee]; ok {
+			s.Calls[name]++
+		} else {
+			s.Calls["<builtin>"]++
+		}
+	case *object.Function:
+		s.Calls["<included function>"]++
+	}
+}
+
+// statsCount is one line of a histogram.
+type statsCount struct {
+	name  string
+	count int
+}
+
+// sortedCounts orders counts from the highest, then by name.
+func sortedCounts(counts []statsCount) []statsCount {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count != counts[j].count {
+			return counts[i].count > counts[j].count
+		}
+		return counts[i].name < counts[j].name
+	})
+	return counts
+}
+
+// Write prints the statistics as a report.
+func (s *Stats) Write(w io.Writer) {
+	fmt.Fprintln(w, "Execution statistics:")
+	fmt.Fprintf(w, "  instructions:     %d\n", s.Instructions)
+	fmt.Fprintf(w, "  peak stack:       %d values\n", s.PeakStack)
+	fmt.Fprintf(w, "  peak call depth:  %d frames\n", s.PeakFrames)
+	fmt.Fprintf(w, "  constant pool:    %d constants\n", s.Constants)
+
+	var opcodes []statsCount
+	for op, count := range s.Opcodes {
+		if count == 0 {
+			continue
+		}
+		name := fmt.Sprintf("opcode %d", op)
+		if def, err := code.Lookup(byte(op)); err == nil {
+			name = def.Name
+		}
+		opcodes = append(opcodes, statsCount{name, count})
+	}
+	if len(opcodes) > 0 {
+		fmt.Fprintln(w, "\nOpcodes:")
+		for _, c := range sortedCounts(opcodes) {
+			fmt.Fprintf(w, "  %-22s %10d %6.1f%%\n", c.name, c.count, 100*float64(c.count)/float64(s.Instructions))
+		}
+	}
+
+	var calls []statsCount
+	for name, count := range s.Calls {
+		calls = append(calls, statsCount{name, count})
+	}
+	if len(calls) > 0 {
+		fmt.Fprintln(w, "\nCalls:")
+		for _, c := range sortedCounts(calls) {
+			fmt.Fprintf(w, "  %-22s %10d\n", c.name, c.count)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"bytes"
+	"squ1d++/code"
+	"squ1d++/compiler"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	input := `var f = def(n) { if (n == 0) { return 0 }; f(n - 1) }; f(3); array.cat([1]); def() { 1 }()`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	CurrentStats = NewStats()
+	defer func() { CurrentStats = nil }()
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("Vm error: %s", err)
+	}
+	stats := CurrentStats
+
+	if stats.Instructions != machine.InstructionCount() {
+		t.Errorf("expected %d instructions, got %d", machine.InstructionCount(), stats.Instructions)
+	}
+	total := 0
+	for _, n := range stats.Opcodes {
+		total += n
+	}
+	if total != stats.Instructions {
+		t.Errorf("expected the opcode histogram to add up to %d, got %d", stats.Instructions, total)
+	}
+	if stats.Opcodes[code.OpCall] != 6 {
+		t.Errorf("expected 6 calls, got %d", stats.Opcodes[code.OpCall])
+	}
+	if stats.Calls["f"] != 4 || stats.Calls["array.cat"] != 1 || stats.Calls["<anonymous>"] != 1 {
+		t.Errorf("wrong call counts: %v", stats.Calls)
+	}
+	if stats.PeakFrames != 5 {
+		t.Errorf("expected a peak call depth of 5, got %d", stats.PeakFrames)
+	}
+	if stats.PeakStack == 0 || stats.Constants != len(comp.Bytecode().Constants) {
+		t.Errorf("expected the peak stack and constant pool size, got %d and %d", stats.PeakStack, stats.Constants)
+	}
+
+	var out bytes.Buffer
+	stats.Write(&out)
+	for _, want := range []string{"  peak call depth:  5 frames\n", "\nOpcodes:\n  ", "\nCalls:\n  f                               4\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected the report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package vm
+
+import (
+	"fmt"
+	"squ1d++/compiler"
+	"squ1d++/object"
+	"testing"
+)
+
+func TestSpawn(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		// Tasks interleave with the program and each other, and get their
+		// arguments and closed-over values.
+		{`var sum = def(from, n) { var s = 0; var i = 0; while (i < n) { s = s + from + i; i = i + 1 }; s };
+		  var a = spawn(sum, 0, 3000); var b = spawn(sum, 1, 3000);
+		  var c = spawn(def() { var x = 10; def() { x * 2 }() });
+		  a.wait() + b.wait() + c.wait()`, 9000000 + 20},
+		// Globals are shared.
+		{`var seen = 0; var t = spawn(def() { seen = 42 }); t.wait(); seen`, 42},
+		// result doesn't wait, wait does.
+		{`var t = spawn(def() { time.sleep(20); 1 }); var before = t.result(); [before, t.done(), t.wait(), t.done(), t.result()]`,
+			[]interface{}{Null, false, 1, true, 1}},
+	})
+}
+
+func TestSpawnExpressionAndTaskClass(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`var n = 20; var t = spawn def() { n + 1 }; task.wait(t) * 2`, 42},
+		// spawn is still a name otherwise.
+		{`var t = spawn(def(x) { x }, 5); task.wait(t)`, 5},
+		{`var ch = chan.new(); var t = spawn def() { chan.send(ch, "hi") }; chan.recv(ch) + string.fmt("%t", task.wait(t) == null)`, "hitrue"},
+		{`var t = spawn def() { time.sleep(20); 1 }; var before = task.result(t); json.write([before, task.done(t), task.wait(t), task.done(t), task.result(t)])`,
+			"[null,false,1,true,1]"},
+		{`var t = spawn def() { time.sleep(1000) }; task.cancel(t); error.msg(task.wait(t))`, "Task cancelled"},
+		// The keywords' builtins aren't part of the class.
+		{`json.write(array.sort(hash.keys(task)))`, `["cancel","done","result","wait"]`},
+	})
+}
+
+func TestSpawnErrors(t *testing.T) {
+	tests := []errorTestCase{
+		{`task.wait(1)`, "Argument 0 to `wait` must be a task, got INTEGER"},
+		{`task.done({"wait": 1})`, "Argument 0 to `done` must be a task, got HASH"},
+		{`spawn(def(x) { x }, 1, 2)`, "Wrong number of arguments for the spawned function. Expected 1, got 2"},
+		{`spawn(1)`, "Argument 0 to `spawn` must be CLOSURE, got INTEGER"},
+		{`spawn(def() { 1() }).wait()`, "Calling non-function and non-builtin function."},
+	}
+
+	runErrorTests(t, tests)
+}
+
+func TestChannels(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		// Buffered channels work without tasks.
+		{`var c = chan.new(2); chan.send(c, 1); chan.send(c, 2); chan.close(c); [chan.recv(c), chan.recv(c), chan.recv(c), chan.closed(c)]`,
+			[]interface{}{1, 2, Null, true}},
+		// Workers read jobs until the channel is closed.
+		{`var jobs = chan.new(); var results = chan.new();
+		  var worker = def() { var j = chan.recv(jobs); while (j != null) { chan.send(results, j * j); j = chan.recv(jobs) } };
+		  spawn(worker); spawn(worker);
+		  spawn(def() { var i = 1; while (i <= 4) { chan.send(jobs, i); i = i + 1 }; chan.close(jobs) });
+		  var sum = 0; var n = 0; while (n < 4) { sum = sum + chan.recv(results); n = n + 1 }; sum`, 30},
+		// select receives from whichever channel is ready, sends, and
+		// times out.
+		{`var a = chan.new(1); var b = chan.new(1); chan.send(b, "b"); var r = chan.select([a, b]); [r.index, r.value, r.ok]`,
+			[]interface{}{1, "b", true}},
+		{`var a = chan.new(1); var r = chan.select([[a, 5]]); [r.index, chan.recv(a)]`, []interface{}{0, 5}},
+		{`var a = chan.new(); chan.close(a); var r = chan.select([a], 0); [r.index, r.value, r.ok]`, []interface{}{0, Null, false}},
+		{`var a = chan.new(); var r = chan.select([a], 10); [r.index, r.ok]`, []interface{}{-1, false}},
+		{`var a = chan.new(); spawn(def() { time.sleep(10); chan.send(a, 7) }); chan.select([a]).value`, 7},
+	})
+}
+
+func TestChannelErrors(t *testing.T) {
+	tests := []errorTestCase{
+		{`chan.recv(chan.new())`, "Deadlock: chan.recv would wait forever, no task is running"},
+		{`var c = chan.new(); chan.close(c); chan.send(c, 1)`, "Send on closed channel"},
+		{`var c = chan.new(); chan.close(c); chan.close(c)`, "Close of closed channel"},
+		{`chan.select([1])`, "Case 0 to `select` must be a CHANNEL or a [CHANNEL, value] array, got INTEGER"},
+		{`chan.new(-1)`, "Channel capacity must not be negative, got -1"},
+	}
+
+	runErrorTests(t, tests)
+}
+
+type errorTestCase struct {
+	input    string
+	expected string
+}
+
+// runErrorTests runs each input and checks it evaluates to an error value
+// with the expected message.
+func runErrorTests(t *testing.T, tests []errorTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+		machine := New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			t.Fatalf("Vm error: %s", err)
+		}
+		result, ok := machine.LastPoppedStackElem().(*object.Error)
+		if !ok {
+			t.Fatalf("%s: expected an error, got %s", tt.input, machine.LastPoppedStackElem().Inspect())
+		}
+		if result.Message != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.input, tt.expected, result.Message)
+		}
+	}
+}
+
+func TestMutex(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`var m = sync.mutex(); sync.lock(m); var first = sync.try_lock(m); sync.unlock(m); [first, sync.try_lock(m)]`,
+			[]interface{}{false, true}},
+		// Updates made under the lock aren't lost to tasks interleaving.
+		{`var m = sync.mutex(); var n = 0;
+		  var add = def() { var i = 0; while (i < 2000) { sync.with(m, def() { n = n + 1 }); i = i + 1 } };
+		  var a = spawn(add); var b = spawn(add); a.wait(); b.wait(); n`, 4000},
+		// A task waits for the lock while the program holds it.
+		{`var m = sync.mutex(); var order = chan.new(2); sync.lock(m);
+		  var t = spawn(def() { sync.lock(m); chan.send(order, "task"); sync.unlock(m) });
+		  time.sleep(10); chan.send(order, "main"); sync.unlock(m); t.wait(); [chan.recv(order), chan.recv(order)]`,
+			[]interface{}{"main", "task"}},
+		{`var m = sync.mutex(); sync.with(m, def() { 42 })`, 42},
+	})
+	runErrorTests(t, []errorTestCase{
+		{`var m = sync.mutex(); sync.lock(m); sync.lock(m)`, "Deadlock: sync.lock would wait forever, no task is running"},
+		{`sync.unlock(sync.mutex())`, "Unlock of unlocked mutex"},
+		{`sync.with(sync.mutex(), def(x) { x })`, "Argument 1 to `with` must be a FUNCTION without parameters, got CLOSURE"},
+	})
+}
+
+func TestWaitGroupAndCounter(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		// The program waits for every worker, and no increment is lost.
+		{`var wg = sync.waitgroup(); var hits = sync.counter();
+		  var work = def() { var i = 0; while (i < 2000) { sync.inc(hits); i = i + 1 }; sync.done(wg) };
+		  sync.add(wg, 3); spawn(work); spawn(work); spawn(work); sync.wait(wg); sync.get(hits)`, 6000},
+		{`var wg = sync.waitgroup(); sync.wait(wg); sync.add(wg); sync.done(wg); sync.wait(wg); type.tp(wg)`, "WaitGroup"},
+		{`var c = sync.counter(10); [sync.inc(c), sync.inc(c, -5), sync.get(c)]`, []interface{}{11, 6, 6}},
+		{`var c = sync.counter(); sync.atomic_add(c, 40); sync.atomic_add(c, 2)`, 42},
+	})
+	runErrorTests(t, []errorTestCase{
+		{`var wg = sync.waitgroup(); sync.add(wg); sync.wait(wg)`, "Deadlock: sync.wait would wait forever, no task is running"},
+		{`sync.done(sync.waitgroup())`, "Negative wait group count"},
+		{`sync.inc(sync.mutex())`, "Argument 0 to `inc` must be COUNTER, got MUTEX"},
+		{`sync.atomic_add(sync.counter())`, "Wrong number of arguments. Expected 2, got 1"},
+		{`sync.atomic_add(sync.counter(), "1")`, "Argument 1 to `atomic_add` must be INTEGER, got STRING"},
+	})
+}
+
+func TestConcurrentHosts(t *testing.T) {
+	// Each goroutine is a host running its own programs, as a Go server
+	// embedding the language would.
+	const hosts = 4
+	errs := make(chan error, hosts)
+	for h := range hosts {
+		go func() {
+			errs <- func() error {
+				for range 5 {
+					comp := compiler.New()
+					if err := comp.Compile(parse(`var total = sync.counter(); var c = chan.new();
+					  var t = spawn(def() { var i = 0; while (i < 3000) { sync.inc(total); i = i + 1 }; chan.send(c, "done") });
+					  var squares = array.pmap([1, 2, 3], def(x) { x * x }, 2);
+					  [chan.recv(c), t.wait(), sync.get(total), squares[2], type.tp(sys.list())]`)); err != nil {
+						return err
+					}
+					machine := New(comp.Bytecode())
+					if err := machine.Run(); err != nil {
+						return err
+					}
+					got := machine.LastPoppedStackElem().Inspect()
+					if want := `["done", null, 3000, 9, "Object"]`; got != want {
+						return fmt.Errorf("host %d: expected %s, got %s", h, want, got)
+					}
+				}
+				return nil
+			}()
+		}()
+	}
+	for range hosts {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestAsyncAwait(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`var square = async def(x) { time.sleep(5); x * x }; var a = square(3); var b = square(4); await a + await b`, 25},
+		{`async twice >> (x) { x * 2 }; await twice(21)`, 42},
+		// Awaiting anything but a task gives it back.
+		{`await 7`, 7},
+		{`var t = spawn(def() { "done" }); [await t, await t]`, []interface{}{"done", "done"}},
+	})
+	runErrorTests(t, []errorTestCase{
+		{`var f = async def(x) { x }; f(1, 2)`, "Wrong number of arguments for the spawned function. Expected 1, got 2"},
+	})
+}
+
+func TestCancellation(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`with_timeout(1000, def() { 1 + 2 })`, 3},
+		{`var t = spawn(def() { time.sleep(5); "finished" }); t.wait(); t.cancel(); t.result()`, "finished"},
+	})
+	runErrorTests(t, []errorTestCase{
+		// Running code is stopped between instructions, blocking builtins
+		// while they wait.
+		{`with_timeout(10, def() { while (true) {} })`, "Timed out after 10 ms"},
+		{`with_timeout(10, def() { time.sleep(10000) })`, "Timed out after 10 ms"},
+		{`with_timeout(10, def() { chan.select([chan.new()], 10000) })`, "Timed out after 10 ms"},
+		{`var t = spawn(def() { chan.recv(chan.new()) }); t.cancel(); t.wait()`, "Task cancelled"},
+		{`var m = sync.mutex(); sync.lock(m); var t = spawn(def() { sync.lock(m) }); t.cancel(); t.wait()`, "Task cancelled"},
+		{`var t = spawn(def() { while (true) {} }); time.sleep(5); t.cancel(); t.wait()`, "Task cancelled"},
+		// Tasks are cancelled with the code that started them, and waiting
+		// for them stops too.
+		{`var inner = null; with_timeout(10, def() { inner = spawn(def() { time.sleep(10000) }); inner.wait() }); inner.wait()`, "Timed out after 10 ms"},
+		{`with_timeout(10, def() { spawn(def() { time.sleep(10000) }).wait() })`, "Timed out after 10 ms"},
+		{`with_timeout(10, def() { array.pmap([1, 2], def(x) { while (true) {} }, 2) })`, "Timed out after 10 ms"},
+	})
+}
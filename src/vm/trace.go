@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"fmt"
+	"squ1d++/code"
+	"squ1d++/object"
+	"strconv"
+	"strings"
+)
+
+// traceStackDepth is how many values from the top of the stack each trace
+// line shows.
+const traceStackDepth = 4
+
+// traceValueWidth is the longest a value is shown in a trace line.
+const traceValueWidth = 24
+
+// trace writes a line for the instruction at ip to object.TraceWriter: the
+// running function, ip, opcode and operands, and the top of the stack before
+// the instruction runs, e.g.
+//
+//	<main>       0004 OpConstant 2          [1 "a"]
+func (vm *VM) trace(ins code.Instructions, ip int) {
+	if object.TraceWriter == nil {
+		return
+	}
+
+	function := vm.currentFrame().cl.Fn.Name
+	if vm.framesIndex == 1 {
+		function = "<main>"
+	} else if function == "" {
+		function = "<anonymous>"
+	}
+
+	fmt.Fprintf(object.TraceWriter, "%-12s %04d %-22s %s\n", function, ip, decodeInstruction(ins, ip), vm.stackSnapshot())
+}
+
+// decodeInstruction renders the instruction at ip as its opcode name and
+// operands, e.g. "OpConstant 2".
+func decodeInstruction(ins code.Instructions, ip int) string {
+	def, err := code.Lookup(ins[ip])
+	if err != nil {
+		return fmt.Sprintf("opcode %d", ins[ip])
+	}
+	operands, _ := code.ReadOperands(def, ins[ip+1:])
+	parts := []string{def.Name}
+	for _, operand := range operands {
+		parts = append(parts, strconv.Itoa(operand))
+	}
+	return strings.Join(parts, " ")
+}
+
+// stackSnapshot renders the top traceStackDepth values of the stack, the
+// topmost last.
+func (vm *VM) stackSnapshot() string {
+	var values []string
+	from := max(vm.sp-traceStackDepth, 0)
+	if from > 0 {
+		values = append(values, "...")
+	}
+	for _, value := range vm.stack[from:vm.sp] {
+		values = append(values, traceValue(value))
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+func traceValue(value object.Object) string {
+	if value == nil {
+		return "<nil>"
+	}
+	brief := object.BriefFormat
+	brief.MaxLength = traceValueWidth
+	return brief.Format(value)
+}
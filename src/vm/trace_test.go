@@ -0,0 +1,50 @@
+package vm
+
+import (
+	"bytes"
+	"os"
+	"squ1d++/compiler"
+	"squ1d++/object"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var out bytes.Buffer
+	object.TraceWriter = &out
+	defer func() {
+		object.Tracing = false
+		object.TraceWriter = os.Stderr
+	}()
+
+	input := `var add = def(a) { a + 10 }; sys.trace(true); add("some long string value here"); sys.trace(false); 99`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("Vm error: %s", err)
+	}
+	if object.Tracing {
+		t.Fatalf("expected sys.trace(false) to turn tracing off")
+	}
+
+	trace := out.String()
+	for _, want := range []string{
+		"<main>       0023 OpCall 1               [Closure[",
+		"add          0000 OpGetLocal 0           [",
+		`"some long string val...`,
+		"add          0005 OpAdd",
+		"add          0006 OpReturnValue",
+	} {
+		if !strings.Contains(trace, want) {
+			t.Fatalf("expected trace to contain %q, got:\n%s", want, trace)
+		}
+	}
+	// Instructions before sys.trace(true) and after sys.trace(false) aren't
+	// traced.
+	if strings.Contains(trace, "OpClosure") || strings.Contains(trace, "99") {
+		t.Fatalf("expected only the traced section, got:\n%s", trace)
+	}
+}
@@ -1,24 +1,38 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"squ1d++/code"
 	"squ1d++/compiler"
+	"squ1d++/crash"
 	"squ1d++/evaluator"
 	"squ1d++/object"
+	"strings"
 )
 
+// StackSize is the number of values the stack starts with, and MaxFrames
+// how deeply calls can nest unless Options say otherwise.
 const StackSize = 2048
-const GlobalsSize = 65536
 const MaxFrames = 1024
 
+// yieldInterval is the number of instructions a VM runs between letting
+// spawned tasks take a turn and checking whether it was cancelled.
+const yieldInterval = 1000
+
 // MaxStackSize is the absolute maximum the VM stack will grow to. This guards
 // against unbounded memory growth for programs that don't properly balance
 // pushes/pops (e.g. bugs in user code). It's large enough for typical
 // long-running programs but prevents runaway memory usage.
 // MaxStackSize is configurable via object.SysMaxStackSize (default 65536).
 
+// LineHook, when set, is called with the machine, file and line of every
+// statement as the VM starts executing it. Coverage tools and the debugger
+// install it; it only sees code compiled with line tables. The machine is
+// nil for statements that don't run on a VM, such as plain includes.
+var LineHook func(vm *VM, file string, line int)
+
 var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
 var Null = &object.Null{}
@@ -27,12 +41,15 @@ type VM struct {
 	constants        []object.Object
 	stack            []object.Object
 	sp               int
-	globals          []object.Object
+	globals          *Globals
 	frames           []*Frame
 	framesIndex      int
 	lastOpcode       code.Opcode
 	lastPopped       object.Object
 	instructionCount int
+	// countedInstructions is the part of instructionCount already added to
+	// executedInstructions.
+	countedInstructions int
 	// lastPopWasAssignment is true when the last popped value was popped
 	// as part of an assignment (OpSetGlobal/OpSetLocal). This allows
 	// LastPoppedStackElem to suppress printing assignment results even
@@ -41,42 +58,110 @@ type VM struct {
 	// includeDirectives records every IncludeDirective popped during execution
 	// so callers can process all pkg.include() side effects in-order.
 	includeDirectives []*object.IncludeDirective
+	// lastIP is the offset of the instruction running, in the current
+	// frame, for crash reports.
+	lastIP int
+	// Entered is set when the machine is run by a goroutine already holding
+	// the interpreter (see object.Enter), so Run doesn't take it again.
+	Entered bool
+	// handlers holds the attempt blocks being run, innermost last.
+	handlers []handler
+	options  Options
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	return newVM(bytecode, NewGlobals(), DefaultOptions)
+}
+
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s *Globals) *VM {
+	return newVM(bytecode, s, DefaultOptions)
+}
+
+func newVM(bytecode *compiler.Bytecode, globals *Globals, opts Options) *VM {
+	globals.Grow(bytecode.Globals)
+
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Lines:        bytecode.Lines,
+		Positions:    bytecode.Positions,
+		Filename:     bytecode.Filename,
+		Files:        bytecode.Files,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
-	frames[0] = mainFrame
-
-	return &VM{
+	vm := &VM{
 		constants:   bytecode.Constants,
-		stack:       make([]object.Object, StackSize),
 		sp:          0,
-		globals:     make([]object.Object, GlobalsSize),
-		frames:      frames,
+		globals:     globals,
 		framesIndex: 1,
 		lastOpcode:  code.OpConstant, // Initialize with a safe default
+		options:     opts,
 	}
+	// The stack and frames start small and grow up to their limits.
+	vm.stack = make([]object.Object, max(min(StackSize, vm.maxStackSize()), 1))
+	vm.frames = make([]*Frame, max(min(MaxFrames, vm.maxFrames()), 1))
+	vm.frames[0] = mainFrame
+	return vm
 }
 
-func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
-	vm.globals = s
-	return vm
+// Run executes the program. Errors raised by code compiled from a file, or
+// inside a function call, are returned as *object.RuntimeError, pointing at
+// the failing expression and listing the calls that led to it. A panic
+// while running, such as a Go runtime error, is returned as a *crash.Fault
+// describing the machine's state.
+func (vm *VM) Run() (err error) {
+	if !vm.Entered {
+		defer object.Enter()()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if !crash.IsFault(r) {
+				panic(r)
+			}
+			err = vm.fault(r)
+		}
+	}()
+
+	// Errors raised inside attempt blocks resume at their rescue blocks.
+	base := len(vm.handlers)
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		if !vm.rescue(err, base) {
+			return vm.locate(err)
+		}
+	}
 }
 
-func (vm *VM) Run() error {
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
+	// The machines of a parallel section leave RunningVM to the one that
+	// started the section.
+	if !object.InParallel() {
+		previous := object.RunningVM
+		object.RunningVM = vm
+		defer func() { object.RunningVM = previous }()
+	}
+	defer vm.countInstructions()
+
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.instructionCount++
 		if vm.instructionCount > object.SysMaxInstructionCount {
-			return fmt.Errorf("runtime error: max instruction count exceeded: %d", object.SysMaxInstructionCount)
+			return uncatchable{fmt.Errorf("runtime error: max instruction count exceeded: %d", object.SysMaxInstructionCount)}
+		}
+		if sampleDue.Load() {
+			vm.takeSample()
+		}
+		if vm.instructionCount%yieldInterval == 0 {
+			if err := object.Checkpoint(); err != nil {
+				return uncatchable{err}
+			}
 		}
 
 		vm.currentFrame().ip++
@@ -85,7 +170,25 @@ func (vm *VM) Run() error {
 		ins = vm.currentFrame().Instructions()
 
 		op = code.Opcode(ins[ip])
-		vm.lastOpcode = op
+		if op != code.OpEcho {
+			// OpEcho needs the opcode before it to tell what to print.
+			vm.lastOpcode = op
+		}
+		vm.lastIP = ip
+
+		if object.Tracing {
+			vm.trace(ins, ip)
+		}
+		if CurrentStats != nil {
+			CurrentStats.record(vm, op)
+		}
+
+		if LineHook != nil {
+			fn := vm.currentFrame().cl.Fn
+			for _, line := range fn.Lines[ip] {
+				LineHook(vm, fn.FileAt(ip), line)
+			}
+		}
 
 		switch op {
 		case code.OpConstant:
@@ -104,9 +207,17 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpAnd, code.OpOr:
-			err := vm.executeLogicalOperation(op)
-			if err != nil {
-				return err
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			// The operand decides the result when it is falsy for and,
+			// or truthy for or.
+			truthy := isTruthy(vm.pop())
+			if truthy == (op == code.OpOr) {
+				if err := vm.push(nativeBoolToBooleanObject(truthy)); err != nil {
+					return err
+				}
+				vm.currentFrame().ip = pos - 1
 			}
 
 		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
@@ -173,6 +284,52 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpSet:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			set, err := vm.buildSet(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+
+			vm.sp = vm.sp - numElements
+
+			err = vm.push(set)
+			if err != nil {
+				return err
+			}
+
+		case code.OpTuple:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp = vm.sp - numElements
+
+			err := vm.push(&object.Tuple{Elements: elements})
+			if err != nil {
+				return err
+			}
+
+		case code.OpUnpack:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements, err := object.Unpack(vm.pop(), numElements)
+			if err != nil {
+				return err
+			}
+
+			// Push the first element last so the assignments that follow
+			// pop the elements in order.
+			for i := len(elements) - 1; i >= 0; i-- {
+				if err := vm.push(elements[i]); err != nil {
+					return err
+				}
+			}
+
 		case code.OpIndex:
 			index := vm.pop()
 			left := vm.pop()
@@ -182,6 +339,19 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+
+			slice, err := object.Slice(left, start, end)
+			if err != nil {
+				return err
+			}
+			if err := vm.push(slice); err != nil {
+				return err
+			}
+
 		case code.OpDot:
 			right := vm.pop()
 			left := vm.pop()
@@ -204,6 +374,40 @@ func (vm *VM) Run() error {
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
 
+		case code.OpLoopLocal, code.OpLoopGlobal:
+			var slot, width int
+			if op == code.OpLoopLocal {
+				slot, width = int(code.ReadUint8(ins[ip+1:])), 1
+			} else {
+				slot, width = int(code.ReadUint16(ins[ip+1:])), 2
+			}
+			step := vm.constants[code.ReadUint16(ins[ip+1+width:])]
+			cmp := int(code.ReadUint8(ins[ip+3+width:]))
+			body := int(code.ReadUint16(ins[ip+4+width:]))
+			vm.currentFrame().ip += width + 5
+
+			frame := vm.currentFrame()
+			limit := vm.pop()
+			var counter object.Object
+			if op == code.OpLoopLocal {
+				counter = object.Deref(vm.stack[frame.basePointer+slot])
+			} else {
+				counter = vm.globals.Get(slot)
+			}
+
+			next, holds, err := vm.loopStep(counter, step, limit, cmp)
+			if err != nil {
+				return err
+			}
+			if op == code.OpLoopLocal {
+				vm.setLocal(frame.basePointer+slot, next)
+			} else {
+				vm.globals.Set(slot, next)
+			}
+			if holds {
+				frame.ip = body - 1
+			}
+
 		case code.OpJumpNotTruthy:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -213,18 +417,30 @@ func (vm *VM) Run() error {
 				vm.currentFrame().ip = pos - 1
 			}
 
-		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+		case code.OpSetGlobal, code.OpSetGlobalWide:
+			var globalIndex int
+			if op == code.OpSetGlobal {
+				globalIndex = int(code.ReadUint16(ins[ip+1:]))
+				vm.currentFrame().ip += 2
+			} else {
+				globalIndex = int(code.ReadUint32(ins[ip+1:]))
+				vm.currentFrame().ip += 4
+			}
 
-			vm.globals[globalIndex] = vm.pop()
+			vm.globals.Set(globalIndex, vm.pop())
 			vm.lastPopWasAssignment = true
 
-		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+		case code.OpGetGlobal, code.OpGetGlobalWide:
+			var globalIndex int
+			if op == code.OpGetGlobal {
+				globalIndex = int(code.ReadUint16(ins[ip+1:]))
+				vm.currentFrame().ip += 2
+			} else {
+				globalIndex = int(code.ReadUint32(ins[ip+1:]))
+				vm.currentFrame().ip += 4
+			}
 
-			val := vm.globals[globalIndex]
+			val := vm.globals.Get(globalIndex)
 			// If global is not set (nil), treat it as a runtime undefined variable
 			// and push an Error object instead of nil so that functions can
 			// return errors as values instead of crashing the VM.
@@ -246,7 +462,7 @@ func (vm *VM) Run() error {
 
 			frame := vm.currentFrame()
 
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			vm.setLocal(frame.basePointer+int(localIndex), vm.pop())
 			vm.lastPopWasAssignment = true
 
 		case code.OpGetLocal:
@@ -255,7 +471,22 @@ func (vm *VM) Run() error {
 
 			frame := vm.currentFrame()
 
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			err := vm.push(object.Deref(vm.stack[frame.basePointer+int(localIndex)]))
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetLocalCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			slot := vm.currentFrame().basePointer + int(localIndex)
+			cell, ok := vm.stack[slot].(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: vm.stack[slot]}
+				vm.stack[slot] = cell
+			}
+			err := vm.push(cell)
 			if err != nil {
 				return err
 			}
@@ -266,11 +497,36 @@ func (vm *VM) Run() error {
 
 			currentClosure := vm.currentFrame().cl
 
-			err := vm.push(currentClosure.Free[freeIndex])
+			err := vm.push(object.Deref(currentClosure.Free[freeIndex]))
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.push(vm.currentFrame().cl.Free[freeIndex])
 			if err != nil {
 				return err
 			}
 
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			free := vm.currentFrame().cl.Free
+			if cell, ok := free[freeIndex].(*object.Cell); ok {
+				if cell.Shared() {
+					return fmt.Errorf("Cannot assign to a variable shared by array.pmap workers; return the changes instead")
+				}
+				cell.Value = vm.pop()
+			} else {
+				// The enclosing function's own name, which isn't a cell.
+				free[freeIndex] = vm.pop()
+			}
+			vm.lastPopWasAssignment = true
+
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
@@ -284,10 +540,9 @@ func (vm *VM) Run() error {
 			} else {
 				// Handle class objects
 				classIndex := int(builtinIndex) - len(object.Builtins)
-				classes := object.CreateClassObjects()
-				classNames := []string{"io", "type", "time", "os", "math", "string", "file", "pkg", "array", "sys", "keyboard"}
-				if classIndex < len(classNames) {
-					className := classNames[classIndex]
+				classes := object.ClassObjects()
+				if classIndex < len(object.ClassNames) {
+					className := object.ClassNames[classIndex]
 					if classObj, ok := classes[className]; ok {
 						err := vm.push(classObj)
 						if err != nil {
@@ -310,6 +565,87 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpCheckType:
+			typeIndex := code.ReadUint16(ins[ip+1:])
+			subjectIndex := code.ReadUint16(ins[ip+3:])
+			vm.currentFrame().ip += 4
+
+			want := vm.constants[typeIndex].(*object.String).Value
+			if value := vm.stack[vm.sp-1]; !object.MatchesAnnotation(want, value) {
+				subject := vm.constants[subjectIndex].(*object.String).Value
+				return fmt.Errorf("%s", object.AnnotationMismatch(subject, want, object.AnnotationOf(value)))
+			}
+
+		case code.OpMatch:
+			tableIndex := code.ReadUint16(ins[ip+1:])
+			arms := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			arm := vm.matchArm(vm.constants[tableIndex].(*object.Hash), vm.pop(), arms)
+			// Each entry of the jump table is a 3-byte OpJump.
+			vm.currentFrame().ip += 3 * arm
+
+		case code.OpIter:
+			iterator, err := object.NewIterator(vm.pop())
+			if err != nil {
+				return err
+			}
+			err = vm.push(iterator)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			done := int(code.ReadUint16(ins[ip+1:]))
+			names := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			iterator := vm.stack[vm.sp-1].(*object.Iterator)
+			key, value, ok := iterator.Next()
+			if !ok {
+				vm.currentFrame().ip = done - 1
+				break
+			}
+			if names == 2 {
+				if err := vm.push(key); err != nil {
+					return err
+				}
+			} else if iterator.Keyed {
+				value = key
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpDup:
+			err := vm.push(vm.stack[vm.sp-1])
+			if err != nil {
+				return err
+			}
+
+		case code.OpMatchValue, code.OpMatchArray, code.OpMatchTuple, code.OpMatchHash:
+			operand := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			value := vm.pop()
+			var matched bool
+			switch op {
+			case code.OpMatchValue:
+				matched = sameValue(vm.constants[operand], value)
+			case code.OpMatchArray:
+				array, ok := value.(*object.Array)
+				matched = ok && len(array.Elements) == operand
+			case code.OpMatchTuple:
+				tuple, ok := value.(*object.Tuple)
+				matched = ok && len(tuple.Elements) == operand
+			case code.OpMatchHash:
+				matched = hasKeys(value, vm.constants[operand].(*object.Array).Elements)
+			}
+			err := vm.push(nativeBoolToBooleanObject(matched))
+			if err != nil {
+				return err
+			}
+
 		case code.OpIsError:
 			// Inspect top-of-stack without popping and push a Boolean indicating whether
 			// the value is an Error object.
@@ -345,7 +681,18 @@ func (vm *VM) Run() error {
 			val := vm.pop()
 			switch e := val.(type) {
 			case *object.Error:
-				// Use the Error's Inspect() (which includes file/line/column when present)
+				if e.Filename != "" {
+					// Report where the error was raised rather than where
+					// it was checked.
+					unplaced := *e
+					unplaced.Filename = ""
+					return &object.RuntimeError{
+						Err:      fmt.Errorf("%s", strings.TrimPrefix(unplaced.Inspect(), "ERROR: ")),
+						Filename: e.Filename,
+						Line:     e.Line,
+						Column:   e.Column,
+					}
+				}
 				return fmt.Errorf("%s", e.Inspect())
 			default:
 				// If not an Error, push it back and continue
@@ -355,8 +702,15 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpExtractErrorField:
-			// Pop the value from stack and extract .error field
+			// Pop the value from stack and extract .error field. An error
+			// is its own error field.
 			val := vm.pop()
+			if val.Type() == object.ERROR_OBJ {
+				if err := vm.push(val); err != nil {
+					return err
+				}
+				break
+			}
 			if val.Type() != object.HASH_OBJ {
 				if err := vm.push(&object.Error{Message: fmt.Sprintf("Cannot extract .error from %s", val.Type())}); err != nil {
 					return err
@@ -428,8 +782,12 @@ func (vm *VM) Run() error {
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
+			vm.dropHandlers()
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
+			if frame.instance != nil {
+				returnValue = frame.instance
+			}
 
 			err := vm.push(returnValue)
 			if err != nil {
@@ -437,10 +795,50 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpReturn:
+			vm.dropHandlers()
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 
-			err := vm.push(Null)
+			var returnValue object.Object = Null
+			if frame.instance != nil {
+				returnValue = frame.instance
+			}
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+
+		case code.OpAttempt:
+			rescue := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			vm.handlers = append(vm.handlers, handler{frame: vm.framesIndex, sp: vm.sp, rescue: rescue})
+
+		case code.OpEndAttempt:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpCheckError:
+			if e, ok := vm.stack[vm.sp-1].(*object.Error); ok {
+				return &raised{value: e}
+			}
+
+		case code.OpClass:
+			name := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			numMethods := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			class := vm.buildClass(name.Value, vm.sp-2*numMethods, vm.sp)
+			vm.sp -= 2 * numMethods
+			err := vm.push(class)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetField:
+			value := vm.pop()
+			name := vm.pop()
+			target := vm.pop()
+
+			err := vm.executeSetField(target, name, value)
 			if err != nil {
 				return err
 			}
@@ -454,6 +852,9 @@ func (vm *VM) Run() error {
 			if vm.sp > 0 {
 				vm.pop()
 			}
+
+		case code.OpEcho:
+			vm.echo()
 		}
 	}
 
@@ -543,11 +944,65 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	case code.OpNotEqual:
 		return vm.push(nativeBoolToBooleanObject(right != left))
 	default:
-		return fmt.Errorf("Unknown operator: %d (%s %s)",
+		return object.NewTypeError("Unknown operator: %d (%s %s)",
 			op, left.Type(), right.Type())
 	}
 }
 
+// loopStep adds step to the counter of a counted loop and reports whether
+// the loop goes on, comparing the new counter with limit as cmp says.
+// Counters and limits that aren't integers take the path the separate
+// instructions would, errors included.
+func (vm *VM) loopStep(counter, step, limit object.Object, cmp int) (object.Object, bool, error) {
+	c, counterOK := counter.(*object.Integer)
+	l, limitOK := limit.(*object.Integer)
+	if counterOK && limitOK {
+		next := c.Value + step.(*object.Integer).Value
+		switch cmp {
+		case code.LoopLess:
+			return &object.Integer{Value: next}, next < l.Value, nil
+		case code.LoopLessEqual:
+			return &object.Integer{Value: next}, next <= l.Value, nil
+		case code.LoopGreater:
+			return &object.Integer{Value: next}, next > l.Value, nil
+		default:
+			return &object.Integer{Value: next}, next >= l.Value, nil
+		}
+	}
+
+	if err := vm.push(counter); err != nil {
+		return nil, false, err
+	}
+	if err := vm.push(step); err != nil {
+		return nil, false, err
+	}
+	if err := vm.executeBinaryOperation(code.OpAdd); err != nil {
+		return nil, false, err
+	}
+	next := vm.pop()
+
+	// The compiler turns < and >= around, so that OpGreaterThan does all
+	// comparisons.
+	left, right := next, limit
+	if cmp == code.LoopLess || cmp == code.LoopGreaterEqual {
+		left, right = limit, next
+	}
+	if err := vm.push(left); err != nil {
+		return nil, false, err
+	}
+	if err := vm.push(right); err != nil {
+		return nil, false, err
+	}
+	if err := vm.executeComparison(code.OpGreaterThan); err != nil {
+		return nil, false, err
+	}
+	holds := isTruthy(vm.pop())
+	if cmp == code.LoopLessEqual || cmp == code.LoopGreaterEqual {
+		holds = !holds
+	}
+	return next, holds, nil
+}
+
 func (vm *VM) executeBinaryIntegerOperation(
 	op code.Opcode,
 	left, right object.Object,
@@ -624,7 +1079,7 @@ func (vm *VM) executeBinaryStringOperation(
 	left, right object.Object,
 ) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("Unknown string operator: %d", op)
+		return object.NewTypeError("Unknown string operator: %d", op)
 	}
 
 	leftValue := left.(*object.String).Value
@@ -698,7 +1153,7 @@ func (vm *VM) executeNegateOperator() error {
 		value := operand.(*object.Float).Value
 		return vm.push(&object.Float{Value: -value})
 	default:
-		return fmt.Errorf("Unsupported type for negation: %s", operand.Type())
+		return object.NewTypeError("Unsupported type for negation: %s", operand.Type())
 	}
 
 	// if operand.Type() != object.INTEGER_OBJ && operand.Type() != object.FLOAT_OBJ {
@@ -706,26 +1161,6 @@ func (vm *VM) executeNegateOperator() error {
 	// }
 }
 
-func (vm *VM) executeLogicalOperation(op code.Opcode) error {
-	right := vm.pop()
-	left := vm.pop()
-
-	leftTruthy := isTruthy(left)
-	rightTruthy := isTruthy(right)
-
-	var result bool
-	switch op {
-	case code.OpAnd:
-		result = leftTruthy && rightTruthy
-	case code.OpOr:
-		result = leftTruthy || rightTruthy
-	default:
-		return fmt.Errorf("Unknown logical operator: %d", op)
-	}
-
-	return vm.push(nativeBoolToBooleanObject(result))
-}
-
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 	elements := make([]object.Object, endIndex-startIndex)
 
@@ -737,21 +1172,31 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash(nil)
 
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 		pair := object.HashPair{Key: key, Value: value}
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.HashKeyOf(key)
 		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			return nil, object.NewTypeError("unusable as hash key: %s", key.Type())
 		}
 
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey, pair)
 	}
 
-	return object.NewHash(hashedPairs), nil
+	return hash, nil
+}
+
+func (vm *VM) buildSet(startIndex, endIndex int) (object.Object, error) {
+	set := object.NewSet()
+	for i := startIndex; i < endIndex; i++ {
+		if err := set.Add(vm.stack[i]); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
@@ -759,11 +1204,20 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
 
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeTupleIndex(left, index)
+
+	case left.Type() == object.BYTES_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeBytesIndex(left, index)
+
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
+
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 
 	default:
-		return fmt.Errorf("Index operator not supported: %s", left.Type())
+		return object.NewTypeError("Index operator not supported: %s", left.Type())
 	}
 }
 
@@ -773,35 +1227,123 @@ func (vm *VM) executeDotExpression(left, right object.Object) error {
 		return vm.executeHashDot(left, right)
 	case left.Type() == object.ERROR_OBJ:
 		return vm.executeErrorDot(left, right)
+	case left.Type() == object.INSTANCE_OBJ:
+		return vm.executeInstanceDot(left.(*object.Instance), right)
+	case left.Type() == object.CLASS_OBJ:
+		return vm.executeClassDot(left.(*object.Class), right)
 	case left.Type() == object.NULL_OBJ:
 		// Null values silently return null for any field access
 		return vm.push(Null)
 	default:
-		return fmt.Errorf("Dot operator not supported: %s", left.Type())
+		return object.NewTypeError("Dot operator not supported: %s", left.Type())
 	}
 }
 
+// executeArrayIndex pushes an element of an array, counting from the end
+// for negative indexes, or null when there is no such element. Tuples,
+// bytes and strings are indexed the same way.
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObject := array.(*object.Array)
-	i := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+	i := object.NormalizeIndex(index.(*object.Integer).Value, len(arrayObject.Elements))
 
-	if i < 0 || i > max {
+	if i < 0 || i >= len(arrayObject.Elements) {
 		return vm.push(Null)
 	}
 
 	return vm.push(arrayObject.Elements[i])
 }
 
+func (vm *VM) executeTupleIndex(tuple, index object.Object) error {
+	tupleObject := tuple.(*object.Tuple)
+	i := object.NormalizeIndex(index.(*object.Integer).Value, len(tupleObject.Elements))
+
+	if i < 0 || i >= len(tupleObject.Elements) {
+		return vm.push(Null)
+	}
+
+	return vm.push(tupleObject.Elements[i])
+}
+
+// executeStringIndex pushes the character at index as a string.
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	runes := []rune(str.(*object.String).Value)
+	i := object.NormalizeIndex(index.(*object.Integer).Value, len(runes))
+
+	if i < 0 || i >= len(runes) {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.String{Value: string(runes[i])})
+}
+
+func (vm *VM) executeBytesIndex(bytes, index object.Object) error {
+	bytesObject := bytes.(*object.Bytes)
+	i := object.NormalizeIndex(index.(*object.Integer).Value, len(bytesObject.Value))
+
+	if i < 0 || i >= len(bytesObject.Value) {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.Integer{Value: int64(bytesObject.Value[i])})
+}
+
+// matchArm returns the index of the arm of a match expression whose pattern
+// equals subject, or arms, the el branch, when there is none.
+func (vm *VM) matchArm(table *object.Hash, subject object.Object, arms int) int {
+	if subject == nil {
+		subject = Null
+	}
+	key, ok := object.HashKeyOf(subject)
+	if !ok {
+		return arms
+	}
+	pair, ok := table.Pairs[key]
+	if !ok || !sameValue(pair.Key, subject) {
+		return arms
+	}
+	return int(pair.Value.(*object.Integer).Value)
+}
+
+// sameValue reports whether value equals the constant pattern of a match
+// arm: both have the same type and the same value.
+func sameValue(pattern, value object.Object) bool {
+	if value == nil {
+		value = Null
+	}
+	key, ok := object.HashKeyOf(value)
+	if !ok || key != pattern.(object.Hashable).HashKey() {
+		return false
+	}
+	// Different strings can share a key.
+	if s, ok := value.(*object.String); ok {
+		return pattern.(*object.String).Value == s.Value
+	}
+	return true
+}
+
+// hasKeys reports whether value is a hash holding all of keys.
+func hasKeys(value object.Object, keys []object.Object) bool {
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return false
+	}
+	for _, key := range keys {
+		if _, ok := hash.Pairs[key.(object.Hashable).HashKey()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.HashKeyOf(index)
 	if !ok {
-		return fmt.Errorf("Unusable as hash key: %s", index.Type())
+		return object.NewTypeError("Unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Pairs[key]
 	if !ok {
 		return vm.push(Null)
 	}
@@ -847,6 +1389,13 @@ func (vm *VM) executeErrorDot(left, right object.Object) error {
 	switch keyName {
 	case "message":
 		return vm.push(&object.String{Value: errObj.Message})
+	case "kind":
+		return vm.push(&object.String{Value: errObj.KindName()})
+	case "data":
+		if errObj.Data == nil {
+			return vm.push(Null)
+		}
+		return vm.push(errObj.Data)
 	case "filename":
 		return vm.push(&object.String{Value: errObj.Filename})
 	case "line":
@@ -868,6 +1417,9 @@ func (vm *VM) executeErrorDot(left, right object.Object) error {
 
 func (vm *VM) executeCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
+	if CurrentStats != nil {
+		CurrentStats.call(callee)
+	}
 
 	switch callee := callee.(type) {
 	case *object.Closure:
@@ -877,6 +1429,10 @@ func (vm *VM) executeCall(numArgs int) error {
 	case *object.Function:
 		// Support calling interpreter-mode functions (from included files evaluated with the evaluator)
 		return vm.callInterpreterFunction(callee, numArgs)
+	case *object.BoundMethod:
+		return vm.callMethod(callee.Receiver, callee.Method, numArgs)
+	case *object.Class:
+		return vm.construct(callee, numArgs)
 	default:
 		return fmt.Errorf("Calling non-function and non-builtin function.")
 	}
@@ -884,18 +1440,19 @@ func (vm *VM) executeCall(numArgs int) error {
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
-		err := &object.Error{
-			Message: fmt.Sprintf("Wrong number of arguments. Expected %d, got %d",
-				cl.Fn.NumParameters, numArgs),
-			Traceback: vm.getTraceback(),
-		}
-		return fmt.Errorf("%s", err.Inspect())
+		return fmt.Errorf("Wrong number of arguments. Expected %d, got %d",
+			cl.Fn.NumParameters, numArgs)
 	}
 
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
 
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	// Clear the locals after the arguments, so nothing left on the stack by
+	// earlier calls shows up in them.
+	clear(vm.stack[min(frame.basePointer+numArgs, len(vm.stack)):min(vm.sp, len(vm.stack))])
 
 	return nil
 }
@@ -916,9 +1473,24 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	var result object.Object
+	if builtin.Calling != nil {
+		result = builtin.Calling(vm.caller(), args...)
+	} else if builtin.Machine != nil {
+		result = builtin.Machine(vm, args...)
+	} else {
+		result = builtin.Fn(args...)
+	}
 	vm.sp = vm.sp - numArgs - 1
 
+	// An error returned inside a function call keeps the calls that led to
+	// it, so `<< f()` can show where it came from.
+	if e, ok := result.(*object.Error); ok && e.Traceback == nil && vm.framesIndex > 1 {
+		traced := *e
+		traced.Traceback = vm.getTraceback()
+		result = &traced
+	}
+
 	if result != nil {
 		vm.push(result)
 	} else {
@@ -932,12 +1504,8 @@ func (vm *VM) callInterpreterFunction(fn *object.Function, numArgs int) error {
 	// Support for calling interpreter-mode (evaluator-created) functions
 	// These come from included files that are evaluated with the evaluator
 	if numArgs != len(fn.Parameters) {
-		err := &object.Error{
-			Message: fmt.Sprintf("Wrong number of arguments. Expected %d, got %d",
-				len(fn.Parameters), numArgs),
-			Traceback: vm.getTraceback(),
-		}
-		return fmt.Errorf("%s", err.Inspect())
+		return fmt.Errorf("Wrong number of arguments. Expected %d, got %d",
+			len(fn.Parameters), numArgs)
 	}
 
 	// Get arguments from stack
@@ -973,6 +1541,16 @@ func (vm *VM) callInterpreterFunction(fn *object.Function, numArgs int) error {
 	return nil
 }
 
+// setLocal stores value in the stack slot of a local, or in its cell once a
+// closure has captured it.
+func (vm *VM) setLocal(slot int, value object.Object) {
+	if cell, ok := vm.stack[slot].(*object.Cell); ok {
+		cell.Value = value
+		return
+	}
+	vm.stack[slot] = value
+}
+
 func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
@@ -1012,14 +1590,13 @@ func isTruthy(obj object.Object) bool {
 func (vm *VM) push(o object.Object) error {
 	// If we're about to exceed the current stack length, try to grow it.
 	if vm.sp >= len(vm.stack) {
-		// Determine new size (double) but do not exceed MaxStackSize.
+		// Determine new size (double) but do not exceed the maximum.
 		newSize := len(vm.stack) * 2
 		if newSize == 0 {
 			newSize = StackSize
 		}
-		// Respect the configured maximum stack size from the object package.
-		if newSize > object.SysMaxStackSize {
-			newSize = object.SysMaxStackSize
+		if newSize > vm.maxStackSize() {
+			newSize = vm.maxStackSize()
 		}
 
 		if vm.sp >= newSize {
@@ -1063,7 +1640,7 @@ func (vm *VM) StackTop() object.Object {
 
 func (vm *VM) LastPoppedStackElem() object.Object {
 	// Don't return values for variable assignments - they should be "pure" statements
-	if vm.lastOpcode == code.OpSetGlobal || vm.lastOpcode == code.OpSetLocal {
+	if vm.lastOpcode == code.OpSetGlobal || vm.lastOpcode == code.OpSetGlobalWide || vm.lastOpcode == code.OpSetLocal {
 		return nil
 	}
 	// Also suppress printing when the last opcode was OpSuppress
@@ -1071,8 +1648,11 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 		return nil
 	}
 	// Suppress values after OpJumpNotTruthy as this is a control-flow pop
-	// (e.g., the condition result `false` at end of a for/while loop).
-	if vm.lastOpcode == code.OpJumpNotTruthy {
+	// (e.g., the condition result `false` at end of a for/while loop). The
+	// limit popped by a counted loop is one too, and so is the condition
+	// of the if a loop was left by with break, as a statement never ends
+	// on OpJump otherwise.
+	if vm.lastOpcode == code.OpJumpNotTruthy || vm.lastOpcode == code.OpJump || vm.lastOpcode == code.OpLoopLocal || vm.lastOpcode == code.OpLoopGlobal {
 		return nil
 	}
 	// Also suppress if the last popped value was from an assignment; this
@@ -1087,6 +1667,24 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.lastPopped
 }
 
+// echo prints the value of the top-level statement that just ended, the
+// way the REPL prints it, then forgets it so the next statement starts
+// afresh.
+func (vm *VM) echo() {
+	if last := vm.LastPoppedStackElem(); last != nil && last.Type() != object.NULL_OBJ {
+		if _, isInclude := last.(*object.IncludeDirective); !isInclude {
+			if e, ok := last.(*object.Error); ok {
+				fmt.Fprintln(object.OutWriter, e.InspectWithContext())
+			} else {
+				fmt.Fprintln(object.OutWriter, object.Format(last))
+			}
+		}
+	}
+	vm.lastOpcode = code.OpConstant
+	vm.lastPopped = nil
+	vm.lastPopWasAssignment = false
+}
+
 // DrainIncludeDirectives returns all include directives popped so far and
 // clears the internal queue.
 func (vm *VM) DrainIncludeDirectives() []*object.IncludeDirective {
@@ -1102,9 +1700,18 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= len(vm.frames) {
+		if vm.framesIndex >= vm.maxFrames() {
+			return fmt.Errorf("STACK OVERFLOW: calls nested more than %d deep", vm.maxFrames())
+		}
+		frames := make([]*Frame, min(2*len(vm.frames), vm.maxFrames()))
+		copy(frames, vm.frames)
+		vm.frames = frames
+	}
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -1112,48 +1719,29 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+// getTraceback returns the active calls, outermost first, as the Traceback
+// of an error value.
 func (vm *VM) getTraceback() []string {
-	// Build a traceback from the current call stack
 	var traceback []string
-
-	for i := 0; i < vm.framesIndex; i++ {
-		frame := vm.frames[i]
-		if frame == nil || frame.cl == nil || frame.cl.Fn == nil {
-			continue
-		}
-
-		// Get function name
-		fnName := frame.cl.Fn.Name
-		if fnName == "" {
-			fnName = "<anonymous>"
-		}
-
-		// Format: in function_name at instruction offset
-		traceback = append(traceback, fmt.Sprintf("in %s at offset %d", fnName, frame.ip))
+	for _, frame := range vm.callStack() {
+		traceback = append(traceback, frame.String())
 	}
-
 	return traceback
 }
 
-// TriggerEvent executes registered GUI event handlers (closures) for the
-// given event name. Handlers are looked up from object.GUIEventHandlers.
-// Each handler must be a *object.Closure; the number of provided args must
-// match the closure's parameter count. The method will run the VM until the
-// handler returns before continuing to the next handler.
+// TriggerEvent executes registered event handlers for the given event name
+// right away. Handlers are looked up with object.Handlers. The number of
+// provided args must match a closure's parameter count. The method will run
+// the VM until the handler returns before continuing to the next handler.
+// object.PostEvent queues an event for the event loop instead.
 func (vm *VM) TriggerEvent(eventName string, args ...object.Object) error {
-	if object.GUIEventHandlers == nil {
-		return nil
-	}
-
-	handlers := object.GUIEventHandlers[eventName]
-	if len(handlers) == 0 {
-		return nil
-	}
-
-	for _, h := range handlers {
+	for _, h := range object.Handlers(eventName) {
 		cl, ok := h.(*object.Closure)
 		if !ok {
-			// non-callable handlers are ignored
+			// Functions of included files run on the evaluator.
+			if result, ok := evaluator.Apply(h, args...).(*object.Error); ok {
+				return errors.New(result.Message)
+			}
 			continue
 		}
 
@@ -1163,32 +1751,106 @@ func (vm *VM) TriggerEvent(eventName string, args ...object.Object) error {
 				eventName, cl.Fn.NumParameters, len(args))
 		}
 
-		// Push the closure followed by its arguments (same layout as the
-		// compiler produces before OpCall), then call the closure.
-		if err := vm.push(cl); err != nil {
+		if _, err := vm.Call(cl, args...); err != nil {
 			return err
 		}
-		for _, a := range args {
-			if err := vm.push(a); err != nil {
-				return err
-			}
-		}
+	}
 
-		if err := vm.callClosure(cl, len(args)); err != nil {
-			return err
-		}
+	return nil
+}
 
-		// Run VM until handler returns (the Run loop will execute frames on
-		// top of the stack and return when there's nothing left to run).
-		if err := vm.Run(); err != nil {
-			return err
+// Call runs cl with args after the program has finished running and returns
+// its result, so Go code can invoke SQU1DLang functions such as event
+// handlers or benchmark bodies.
+func (vm *VM) Call(cl *object.Closure, args ...object.Object) (object.Object, error) {
+	// Push the closure followed by its arguments (same layout as the
+	// compiler produces before OpCall), then call the closure.
+	if err := vm.push(cl); err != nil {
+		return nil, err
+	}
+	for _, a := range args {
+		if err := vm.push(a); err != nil {
+			return nil, err
 		}
+	}
+
+	if err := vm.callClosure(cl, len(args)); err != nil {
+		return nil, err
+	}
+
+	// Run VM until the function returns (the Run loop will execute frames on
+	// top of the stack and return when there's nothing left to run).
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+
+	// Take the return value off the stack.
+	if vm.sp > 0 {
+		return vm.pop(), nil
+	}
+	return Null, nil
+}
+
+// Apply calls fn with args like Call, but fn may be a function of either
+// backend or a builtin. A function other than a closure reports its errors
+// as its result.
+func (vm *VM) Apply(fn object.Object, args ...object.Object) (object.Object, error) {
+	if cl, ok := fn.(*object.Closure); ok {
+		return vm.Call(cl, args...)
+	}
+	return vm.caller()(fn, args...), nil
+}
 
-		// Clean up any return value left on the stack from the handler.
-		if vm.sp > 0 {
-			vm.pop()
+// Fork runs cl with args on a new machine sharing this one's constants and
+// globals, and returns its result. Tasks started by spawn run on one.
+func (vm *VM) Fork(cl *object.Closure, args []object.Object) (object.Object, error) {
+	return vm.fork().Call(cl, args...)
+}
+
+// caller returns the object.Caller of a builtin call: closures run one after
+// the other on a machine forked from vm, and functions of included files on
+// the evaluator.
+func (vm *VM) caller() object.Caller {
+	var machine *VM
+	var call object.Caller
+	call = func(fn object.Object, args ...object.Object) object.Object {
+		switch fn := fn.(type) {
+		case *object.Closure:
+			if machine == nil {
+				machine = vm.fork()
+			}
+			result, err := machine.Call(fn, args...)
+			if err != nil {
+				return machine.errorValue(err)
+			}
+			return result
+		case *object.Builtin:
+			if fn.Calling != nil {
+				return fn.Calling(call, args...)
+			}
+			if fn.Machine != nil {
+				if result := fn.Machine(vm, args...); result != nil {
+					return result
+				}
+				return Null
+			}
+			if result := fn.Fn(args...); result != nil {
+				return result
+			}
+			return Null
 		}
+		return evaluator.Apply(fn, args...)
 	}
+	return call
+}
 
-	return nil
+func (vm *VM) fork() *VM {
+	machine := newVM(&compiler.Bytecode{Constants: vm.constants}, vm.globals, vm.options)
+	machine.Entered = true
+	return machine
+}
+
+// InstructionCount returns the number of instructions the VM has executed.
+func (vm *VM) InstructionCount() int {
+	return vm.instructionCount
 }
@@ -3,6 +3,9 @@ package vm
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"squ1d++/ast"
@@ -98,9 +101,317 @@ func TestHashLiterals(t *testing.T) {
 			"{sort: 1}.sort",
 			int64(1),
 		},
+		{
+			"{1.5: 1, 1.7: 2, 1: 3, null: 4}",
+			map[object.HashKey]int64{
+				(&object.Float{Value: 1.5}).HashKey(): 1,
+				(&object.Float{Value: 1.7}).HashKey(): 2,
+				(&object.Integer{Value: 1}).HashKey(): 3,
+				(&object.Null{}).HashKey():            4,
+			},
+		},
+		{"{1.5: 1, 1.7: 2}[1.7]", 2},
+		{"{null: 1}[null]", 1},
+		{"{0.0: 1}[-0.0]", 1},
+		{"{1: 1}[1.0]", Null},
 	}
 
 	runVmTests(t, tests)
+
+	// Hashes keep their keys in the order they were written.
+	comp := compiler.New()
+	if err := comp.Compile(parse(`{"b": 1, "a": 2, 10: 3, "c": 4}`)); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := machine.LastPoppedStackElem().Inspect(); got != `{"b": 1, "a": 2, 10: 3, "c": 4}` {
+		t.Errorf("expected the keys in source order, got %s", got)
+	}
+}
+
+func TestSets(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"set.items({3, 1, 3, 2})", []int{3, 1, 2}},
+		{"set.items(set.new([1, 1, 2]))", []int{1, 2}},
+		{"set.items(set.new())", []int{}},
+		{"var x = 5; set.items({x, x + 1,})", []int{5, 6}},
+		{`[set.has({1, "a"}, "a"), set.has({1, "a"}, 2), set.has({1}, [1])]`, []interface{}{true, false, false}},
+		{"var s = {1, 2}; var t = set.add(s, 3); [array.cat(s), array.cat(t)]", []int{2, 3}},
+		{"set.items(set.remove({1, 2, 3}, 2))", []int{1, 3}},
+		{"set.items(set.union({1, 2}, {2, 3}))", []int{1, 2, 3}},
+		{"set.items(set.intersect({1, 2, 3}, {3, 2, 4}))", []int{2, 3}},
+		{"set.items(set.difference({1, 2, 3}, {2}))", []int{1, 3}},
+		{"type.tp({1})", "Set"},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{"set.new(1)", "Argument 0 to `new` must be ARRAY or SET, got INTEGER"},
+		{"set.new([[1]])", "unusable as set element: ARRAY"},
+		{"set.add({1}, {})", "unusable as set element: HASH"},
+		{"set.union({1}, [2])", "Argument 1 to `union` must be SET, got ARRAY"},
+	})
+
+	comp := compiler.New()
+	if err := comp.Compile(parse("{1, [2]}")); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	if err := New(comp.Bytecode()).Run(); err == nil || err.Error() != "unusable as set element: ARRAY" {
+		t.Errorf("expected an error for an array in a set literal, got %v", err)
+	}
+}
+
+func TestTuples(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`var t = (1, "a", true); [t[0], t[1], t[2], t[3]]`, []interface{}{1, "a", true, Null}},
+		{"tuple.items((1, 2 + 3,))", []int{1, 5}},
+		{"tuple.items((4,))", []int{4}},
+		{"tuple.items(())", []int{}},
+		{"tuple.items(tuple.new([1, 2]))", []int{1, 2}},
+		{"array.cat((1, 2, 3))", 3},
+		{"var (a, b) = (1, 2); [a, b]", []int{1, 2}},
+		{"var a = 1; var b = 2; var (a, b) = (b, a); [a, b]", []int{2, 1}},
+		{"var (a, b, c) = [3, 4, 5]; a + b + c", 12},
+		{"var f = def(x) { return (x, x * 2) }; var g = def() { var (a, b) = f(3); return a + b }; g()", 9},
+		{`var h = {(1, "a"): 10, (1, "b"): 20}; h[(1, "b")]`, 20},
+		{"array.cat({(1, 2), (1, 2), (2, 1)})", 2},
+		{"type.tp((1, 2))", "Tuple"},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{"tuple.new(1)", "Argument 0 to `new` must be ARRAY, SET or TUPLE, got INTEGER"},
+		{"tuple.items([1])", "Argument 0 to `items` must be TUPLE, got ARRAY"},
+		{"set.new([([1], 2)])", "unusable as set element: TUPLE"},
+	})
+
+	for input, expected := range map[string]string{
+		"var (a, b) = (1, 2, 3)": "cannot unpack 3 values into 2 variables",
+		"var (a, b) = 1":         "cannot unpack INTEGER, expected TUPLE or ARRAY",
+		"{([1], 2): 3}":          "TypeError: unusable as hash key: TUPLE",
+	} {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+		if err := New(comp.Bytecode()).Run(); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected error %q, got %v", input, expected, err)
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`var b = bytes.new("hi"); [b[0], b[1], b[2], b[-1], b[-3]]`, []interface{}{104, 105, Null, 105, Null}},
+		{"array.cat(bytes.new(4))", 4},
+		{"var b = bytes.new([0, 255]); [b[0], b[1]]", []int{0, 255}},
+		{`bytes.string(bytes.new("héllo"))`, "héllo"},
+		{`bytes.string(bytes.slice(bytes.new("hello"), 1, 3))`, "el"},
+		{`bytes.string(bytes.slice(bytes.new("hello"), 3))`, "lo"},
+		{`var b = bytes.new("ab"); bytes.append(b, "c"); bytes.append(b, bytes.new("d")); bytes.append(b, 33); bytes.string(b)`, "abcd!"},
+		{`var b = bytes.new("abc"); bytes.set(b, 1, 66); bytes.string(b)`, "aBc"},
+		{`var a = bytes.new("ab"); var b = bytes.new(a); bytes.set(b, 0, 65); [bytes.string(a), bytes.string(b)]`, []interface{}{"ab", "Ab"}},
+		{"type.tp(bytes.new(0))", "Bytes"},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{"bytes.new(-1)", "Argument 0 to `new` must not be negative, got -1"},
+		{"bytes.new([1, 256])", "Element 1 of argument 0 to `new` must be an INTEGER from 0 to 255, got 256"},
+		{"bytes.new(true)", "Argument 0 to `new` must be INTEGER, STRING, ARRAY or BYTES, got BOOLEAN"},
+		{`bytes.string("a")`, "Argument 0 to `string` must be BYTES, got STRING"},
+		{"bytes.slice(bytes.new(3), 2, 1)", "Slice 2:1 is out of range (buffer length is 3)"},
+		{"bytes.slice(bytes.new(3), 0, 4)", "Slice 0:4 is out of range (buffer length is 3)"},
+		{"bytes.set(bytes.new(3), 3, 0)", "Index 3 is out of range (buffer length is 3)"},
+		{"bytes.set(bytes.new(3), 0, -1)", "Argument 2 to `set` must be an INTEGER from 0 to 255, got -1"},
+		{"bytes.append(bytes.new(0), [1])", "Argument 1 to `append` must be STRING, BYTES or an INTEGER from 0 to 255, got [1]"},
+	})
+}
+
+func TestFreeze(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"var a = [1, 2]; [type.frozen(a), type.frozen(type.freeze(a)), type.frozen(a)]", []interface{}{false, true, true}},
+		{"var h = type.freeze({list: [1]}); type.frozen(h.list)", true},
+		{"type.freeze(5)", 5},
+		{"type.frozen(\"a\")", false},
+		{"var a = type.freeze([1, 2]); array.cat(array.append(a, 3))", 3},
+		{"type.frozen(array.append(type.freeze([1]), 2))", false},
+		{"var a = [1, 2]; array.pop(a); array.cat(a)", 1},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{"array.pop(type.freeze([1, 2]))", "Cannot modify a frozen ARRAY"},
+		{"var h = type.freeze({b: bytes.new(1)}); bytes.set(h.b, 0, 1)", "Cannot modify a frozen BYTES"},
+		{`bytes.append(type.freeze(bytes.new("a")), "b")`, "Cannot modify a frozen BYTES"},
+	})
+}
+
+func TestClone(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"var a = [1, 2]; var b = type.clone(a); array.pop(b); [array.cat(a), array.cat(b)]", []int{2, 1}},
+		{"var h = {list: [1, 2]}; var c = type.clone(h); array.pop(c.list); array.cat(h.list)", 2},
+		{`var b = bytes.new("ab"); var c = type.clone(b); bytes.set(c, 0, 65); bytes.string(b)`, "ab"},
+		{"var t = ([1, 2], 3); var c = type.clone(t); array.pop(c[0]); array.cat(t[0])", 2},
+		{"var a = type.freeze([[1]]); var c = type.clone(a); [type.frozen(c), type.frozen(c[0])]", []interface{}{false, false}},
+		{"type.clone(5)", 5},
+		{`type.clone("s")`, "s"},
+	})
+}
+
+func TestHashGetAndSet(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`hash.get({"a": 1}, "a")`, 1},
+		{`hash.get({"a": 1}, "b")`, Null},
+		{`hash.get({"a": 1}, "b", 7)`, 7},
+		{`hash.get({"a": null}, "a", 7)`, Null},
+		{`hash.get({(1, 2): 3}, (1, 2), 0)`, 3},
+		{`var h = {}; hash.set(h, "a", 1); h.a`, 1},
+		{`var h = {"a": 1}; hash.get(hash.set(h, "a", 2), "a")`, 2},
+		{`var h = {}; array.cat([hash.set(h, "x", 1), hash.set(h, "y", 2)])`, 2},
+		{`json.write(hash.keys({"b": 1, "a": 2}))`, `["b","a"]`},
+		{`hash.values({"b": 1, "a": 2})`, []int{1, 2}},
+		{`hash.keys({})`, []int{}},
+		{`hash.has({"a": null}, "a")`, true},
+		{`hash.has({"a": 1}, "b")`, false},
+		{`var h = {"a": 1, "b": 2}; hash.del(h, "a"); hash.del(h, "z"); json.write(h)`, `{"b":2}`},
+		{`var a = {"x": 1, "y": 2}; var m = hash.merge(a, {"y": 3, "z": 4}); json.write([m, a])`,
+			`[{"x":1,"y":3,"z":4},{"x":1,"y":2}]`},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{`hash.get([1], 0)`, "Argument 0 to `get` must be HASH, got ARRAY"},
+		{`hash.get({}, [1])`, "Argument 1 to `get` is unusable as a hash key: ARRAY"},
+		{`hash.set({}, "a")`, "Wrong number of arguments. Expected 3, got 2"},
+		{`hash.set(type.freeze({}), "a", 1)`, "Cannot modify a frozen HASH"},
+		{`hash.keys([1])`, "Argument 0 to `keys` must be HASH, got ARRAY"},
+		{`hash.del(type.freeze({"a": 1}), "a")`, "Cannot modify a frozen HASH"},
+		{`hash.merge({}, 1)`, "Argument 1 to `merge` must be HASH, got INTEGER"},
+	})
+}
+
+func TestConversions(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`type.s2i("42")`, 42},
+		{`type.s2i(" -7\n")`, -7},
+		{`type.fl2s(type.s2fl("2.5"))`, "2.5"},
+		{`type.fl2s(type.s2fl("1e3"))`, "1000"},
+		{`type.i2s(42)`, "42"},
+		{`type.i2s(0xff)`, "255"},
+		{`type.i2s("x")`, "x"},
+		{`type.fl2s(2.5)`, "2.5"},
+		{`var n = << type.s2i("abc"); error.kind(n)`, "ValueError"},
+		{`var n = << type.s2i("12"); n == null`, true},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{`type.s2i("abc")`, `Can't convert "abc" to an integer`},
+		{`type.s2i("1.5")`, `Can't convert "1.5" to an integer`},
+		{`type.s2i("99999999999999999999")`, `Can't convert "99999999999999999999" to an integer: out of range`},
+		{`type.s2fl("")`, `Can't convert "" to a float`},
+		{`type.i2s(1.5)`, "Argument 0 to `i2s` must be INTEGER, got FLOAT"},
+		{`type.fl2s(1)`, "Argument 0 to `fl2s` must be FLOAT, got INTEGER"},
+	})
+}
+
+func TestArrayHigherOrder(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`array.map([1, 2, 3], def(x) { x * 2 })`, []int{2, 4, 6}},
+		{`var k = 10; array.map([1, 2], def(x) { x + k })`, []int{11, 12}},
+		{`array.map([-1, 2], math.abs)`, []int{1, 2}},
+		{`array.map([[1], [2, 3]], def(a) { array.reduce(a, def(s, x) { s + x }) })`, []int{1, 5}},
+		{`array.filter([1, 2, 3, 4], def(x) { x > 2 })`, []int{3, 4}},
+		{`array.filter([1, 2], def(x) { false })`, []int{}},
+		{`array.reduce([1, 2, 3], def(s, x) { s + x })`, 6},
+		{`array.reduce([], def(s, x) { s + x }, 7)`, 7},
+		{`array.reduce(["a", "b"], def(s, x) { s + x }, ">")`, ">ab"},
+		{`array.sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`json.write(array.sort([2, 1.5, 3]))`, `[1.5,2,3]`},
+		{`json.write(array.sort(["b", "c", "a"]))`, `["a","b","c"]`},
+		{`array.sort([3, 1, 2], def(a, b) { a > b })`, []int{3, 2, 1}},
+		{`json.write(array.sort([(2, "a"), (1, "b"), (2, "c")], def(a, b) { a[0] < b[0] }))`, `[[1,"b"],[2,"a"],[2,"c"]]`},
+		{`var a = [2, 1]; array.sort(a); a`, []int{2, 1}},
+		{`array.find([1, 2, 3], def(x) { x > 1 })`, 2},
+		{`array.find([1, 2, 3], def(x) { x > 5 })`, Null},
+		{`attempt { array.map([1], def(x) { x / 0 }) } rescue (e) { e.message }`, "Division by zero"},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{`array.map(1, def(x) { x })`, "Argument 0 to `map` must be ARRAY, got INTEGER"},
+		{`array.filter([1], 2)`, "Argument 1 to `filter` must be FUNCTION, got INTEGER"},
+		{`array.reduce([], def(s, x) { s })`, "reduce of an empty array needs an initial value"},
+		{`array.sort([1, "a"])`, "Can't sort STRING and INTEGER without a function to compare them"},
+		{`array.sort([1, 2], def(a, b) { 1 })`, "The function passed to `sort` must return BOOLEAN, got INTEGER"},
+	})
+}
+
+func TestJSON(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`json.parse("[1, 2.5, true, null]")`, []interface{}{1, 2.5, true, Null}},
+		{`json.parse("{\"a\": {\"b\": [\"x\"]}}").a.b[0]`, "x"},
+		{`json.parse("1e2")`, 100.0},
+		{`json.parse("  \"\\u00e9\"  ")`, "é"},
+		// Objects keep their key order.
+		{`json.write(json.parse("{\"b\": 1, \"a\": [], \"c\": {}}"))`, `{"b":1,"a":[],"c":{}}`},
+		{`json.write([1, 2.0, "<\"x\">", true, null, (1,), {"k": 0x10}])`, `[1,2.0,"<\"x\">",true,null,[1],{"k":16}]`},
+		{`json.write({"a": [1, 2], "b": {}}, true)`, "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": {}\n}"},
+		{`class P { def init() { self.x = 1 } }; json.write(P.new())`, `{"x":1}`},
+		{`var v = {"a": [1, 2.5, "s"]}; json.write(json.parse(json.write(v))) == json.write(v)`, true},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{`json.parse("{\"a\": 1,}")`, "Invalid JSON at line 1, column 9: invalid character '}' looking for beginning of object key string"},
+		{`json.parse("[1,\n  x]")`, "Invalid JSON at line 2, column 3: invalid character 'x' looking for beginning of value"},
+		{`json.parse("1 2")`, "Invalid JSON at line 1, column 3: invalid character '2' after top-level value"},
+		{`json.parse("")`, "Invalid JSON at line 1, column 1: unexpected end of JSON input"},
+		{`json.parse(1)`, "Argument 0 to `parse` must be STRING, got INTEGER"},
+		{`json.write({1: 2})`, "Can't write JSON: object keys must be STRING, got INTEGER"},
+		{`json.write([def() { 1 }])`, "Can't write JSON: CLOSURE has no JSON form"},
+		{`var h = {}; hash.set(h, "h", h); json.write([h])`, "Can't write JSON: a HASH contains itself"},
+		{`json.write(1, "yes")`, "Argument 1 to `write` must be BOOLEAN, got STRING"},
+	})
+}
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Add("X-Seen", "a")
+		w.Header().Add("X-Seen", "b")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("Authorization"), body)
+	}))
+	defer server.Close()
+	url := func(input string) string {
+		return fmt.Sprintf("var url = %q; %s", server.URL, input)
+	}
+
+	runVmTests(t, []vmTestCase{
+		{url(`http.get(url).body`), "GET  "},
+		{url(`http.get(url).status`), 200},
+		{url(`http.get(url + "/missing").status`), 404},
+		{url(`http.get(url).headers["x-seen"]`), "a, b"},
+		{url(`http.get(url, {"headers": {"Authorization": "token"}}).body`), "GET token "},
+		{url(`http.post(url, "{}").body`), "POST  {}"},
+		{url(`http.post(url, bytes.new("raw")).body`), "POST  raw"},
+		{url(`var r = http.request("put", url, {"body": "data"}); [r.status, r.headers["x-method"], r.body]`), []interface{}{200, "PUT", "PUT  data"}},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{url(`http.get(url + "/slow", {"timeout": 50})`), "HTTP request failed: Get \"" + server.URL + "/slow\": context deadline exceeded (Client.Timeout exceeded while awaiting headers)"},
+		{url(`http.get(url, {"retries": 3})`), "Unknown option retries to `get`; use headers, body or timeout"},
+		{url(`http.get(url, {"headers": {"X-Count": 1}})`), "Headers to `get` must map STRING to STRING, got STRING: INTEGER"},
+		{url(`http.get(url, {"timeout": 0})`), "Option timeout to `get` must be positive, got 0"},
+		{url(`http.post(url, 1)`), "Argument 1 to `post` must be STRING or BYTES, got INTEGER"},
+		{`http.request(1, "http://localhost")`, "Argument 0 to `request` must be STRING, got INTEGER"},
+		{`http.request("GET", 1)`, "Argument 1 to `request` must be STRING, got INTEGER"},
+		{`http.get("http://[::1")`, "Invalid HTTP request: parse \"http://[::1\": missing ']' in host"},
+	})
 }
 
 func TestIndexExpressions(t *testing.T) {
@@ -110,16 +421,51 @@ func TestIndexExpressions(t *testing.T) {
 		{"[[1, 1, 1]][0][0]", 1},
 		{"[][0]", Null},
 		{"[1, 2, 3][99]", Null},
-		{"[1][-1]", Null},
+		{"[1][-1]", 1},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", Null},
+		{"(1, 2)[-1]", 2},
+		{`"héllo"[1]`, "é"},
+		{`"hello"[-1]`, "o"},
+		{`"hello"[5]`, Null},
 		{"{1: 1, 2: 2}[1]", 1},
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{"{-1: 1}[-1]", 1},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestSliceExpressions(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"[1, 2, 3, 4][1:3]", []interface{}{2, 3}},
+		{"[1, 2, 3, 4][:2]", []interface{}{1, 2}},
+		{"[1, 2, 3, 4][2:]", []interface{}{3, 4}},
+		{"[1, 2, 3, 4][:]", []interface{}{1, 2, 3, 4}},
+		{"[1, 2, 3, 4][-2:]", []interface{}{3, 4}},
+		{"[1, 2, 3, 4][:-1]", []interface{}{1, 2, 3}},
+		{"[1, 2][2:]", []interface{}{}},
+		{"var a = [1, 2, 3]; var b = a[:]; var c = array.append(b, 4); [a, b]", []interface{}{[]interface{}{1, 2, 3}, []interface{}{1, 2, 3}}},
+		{`"hello"[2:]`, "llo"},
+		{`"héllo"[1:3]`, "él"},
+		{`"hello"[-3:-1]`, "ll"},
+		{"(1, 2, 3)[1:]", &object.Tuple{Elements: []object.Object{&object.Integer{Value: 2}, &object.Integer{Value: 3}}}},
+		{`bytes.string(bytes.new("hello")[1:3])`, "el"},
+		{"var i = 1; [1, 2, 3][i:i + 1]", []interface{}{2}},
+		{`attempt { {"a": 1}[0:1] } rescue (e) { e.message }`, "Slice operator not supported: HASH"},
+		{`attempt { [1, 2][0:"1"] } rescue (e) { e.message }`, "Slice bounds must be INTEGER, got STRING"},
+	})
+
+	runErrorTests(t, []errorTestCase{
+		{"[1, 2, 3][1:5]", "Slice 1:5 is out of range (length is 3)"},
+		{"[1, 2, 3][2:1]", "Slice 2:1 is out of range (length is 3)"},
+		{`"abc"[-4:]`, "Slice -4: is out of range (length is 3)"},
+	})
+
+}
+
 func TestWhileBreakContinue(t *testing.T) {
 	_, val := runVmTestWithOutput(t, `
 		var i = 0;
@@ -413,13 +759,13 @@ func TestIOWriteAndLoopFixes(t *testing.T) {
 	output, val := runVmTestWithOutput(t, `
 		var i = 0;
 		for (var i = 0; i < 3; i = i + 1) {
-			io.echo(i);
+			io.print(i);
 		}
 		1
 		`)
 
 	if output != "012" {
-		t.Fatalf("expected io.echo output '012', got %q", output)
+		t.Fatalf("expected io.print output '012', got %q", output)
 	}
 
 	if got, ok := val.(*object.Integer); !ok || got.Value != 1 {
@@ -429,14 +775,14 @@ func TestIOWriteAndLoopFixes(t *testing.T) {
 	output, val = runVmTestWithOutput(t, `
 		var i = 0;
 		while (i < 2) {
-			io.echo(i);
+			io.print(i);
 			i = i + 1;
 		}
 		2
 		`)
 
 	if output != "01" {
-		t.Fatalf("expected io.echo output '01', got %q", output)
+		t.Fatalf("expected io.print output '01', got %q", output)
 	}
 
 	if got, ok := val.(*object.Integer); !ok || got.Value != 2 {
@@ -513,6 +859,18 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if (false) { 5; })", true},
+		{"true and true", true},
+		{"true and false", false},
+		{"false or true", true},
+		{"false or false", false},
+		{"1 and \"a\"", true},
+		{"null or false", false},
+		{"1 < 2 and 2 < 3 or false", true},
+		// The right operand only runs when it decides the result.
+		{"var n = 0; var f = def() { n = n + 1; true }; false and f(); true or f(); n", 0},
+		{"var n = 0; var f = def() { n = n + 1; true }; true and f(); false or f(); n", 2},
+		{"false and [][1]", false},
+		{"var h = null; h == null or h[\"k\"]", true},
 	}
 
 	runVmTests(t, tests)
@@ -555,6 +913,34 @@ func TestGlobalLetStatements(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestManyGlobals(t *testing.T) {
+	// Past 65535 globals the compiler switches to the wide opcodes and the
+	// store grows to fit.
+	symbols := compiler.NewSymbolTable()
+	for i := 0; i < 70000; i++ {
+		symbols.Define(fmt.Sprintf("g%d", i))
+	}
+	comp := compiler.NewWithState(symbols, nil)
+	if err := comp.Compile(parse("var x = 2; var f = def() { x = x * 21 }; f(); x")); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	globals := NewGlobals()
+	if globals.Len() >= 70000 {
+		t.Fatalf("expected a new store to start small, got %d slots", globals.Len())
+	}
+	machine := NewWithGlobalsStore(comp.Bytecode(), globals)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := testIntegerObject(42, machine.LastPoppedStackElem()); err != nil {
+		t.Error(err)
+	}
+	if globals.Len() < 70002 {
+		t.Errorf("expected the store to grow to the program's globals, got %d slots", globals.Len())
+	}
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -759,25 +1145,16 @@ outer();
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
-			input: `def() { 1; }(1)`,
-			expected: `ERROR: Wrong number of arguments. Expected 0, got 1
-
-Traceback:
-  in <anonymous> at offset 8`,
+			input:    `def() { 1; }(1)`,
+			expected: "Wrong number of arguments. Expected 0, got 1",
 		},
 		{
-			input: `def(a) { a; }();`,
-			expected: `ERROR: Wrong number of arguments. Expected 1, got 0
-
-Traceback:
-  in <anonymous> at offset 5`,
+			input:    `def(a) { a; }();`,
+			expected: "Wrong number of arguments. Expected 1, got 0",
 		},
 		{
-			input: `def(a, b) { a + b; }(1);`,
-			expected: `ERROR: Wrong number of arguments. Expected 2, got 1
-
-Traceback:
-  in <anonymous> at offset 8`,
+			input:    `def(a, b) { a + b; }(1);`,
+			expected: "Wrong number of arguments. Expected 2, got 1",
 		},
 	}
 
@@ -882,6 +1259,71 @@ closure();
 	runVmTests(t, tests)
 }
 
+func TestSharedCaptures(t *testing.T) {
+	tests := []vmTestCase{
+		// Closures capturing the same local share it, and see assignments
+		// made after they were created.
+		{`
+		var counter = def() {
+			var n = 0
+			var inc = def() { n = n + 1 }
+			var get = def() { n }
+			[inc, get]
+		}
+		var fns = counter()
+		fns[0]()
+		fns[0]()
+		fns[1]()
+		`, 2},
+		{`
+		def() {
+			var x = 1
+			var get = def() { x }
+			x = 5
+			get()
+		}()
+		`, 5},
+		// Through a closure in between, and back to the function itself.
+		{`
+		def() {
+			var x = 1
+			var mid = def() { def() { x = x * 10 } }
+			mid()()
+			x
+		}()
+		`, 10},
+		{`
+		def() {
+			var total = 0
+			var add = def(v) { total = total + v }
+			for (var i = 0; i < 5; i = i + 1) { add(i) }
+			total
+		}()
+		`, 10},
+		{`
+		def() {
+			var s = 0
+			for (var i = 0; i < 4; i = i + 1) {
+				var peek = def() { i }
+				s = s + peek()
+			}
+			s
+		}()
+		`, 6},
+		// Every call has variables of its own.
+		{`
+		var make = def() { var n = 0; def() { n = n + 1; n } }
+		var a = make()
+		var b = make()
+		a()
+		a()
+		b()
+		`, 1},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -955,3 +1397,290 @@ type vmTestCase struct {
 	input    string
 	expected interface{}
 }
+
+func TestRuntimeClass(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`var f = def(n) { if (n == 0) { return runtime.stack_depth() }; f(n - 1) }; f(3)`, 5},
+		{`runtime.stack_depth()`, 1},
+		{`var a = runtime.instructions(); var b = runtime.instructions(); b - a`, 5},
+		{`runtime.memory()["heap_alloc"] > 0`, true},
+		{`runtime.gc_stats()["num_gc"] >= 0`, true},
+		{`var xs = [1, "a", [2]]; var h = {"k": xs}; runtime.objects()["ARRAY"]`, 2},
+		{`var xs = [1, "a", [2]]; var h = {"k": xs}; runtime.objects()["STRING"]`, 2},
+		{`runtime.memory(1)`, &object.Error{Message: "Wrong number of arguments. Expected 0, got 1"}},
+	})
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"var f = def(a: int, b: int): int { a + b }; f(1, 2)", 3},
+		{"var f = def(a: int): int { if (a > 0) { return a }; -a }; f(-4)", 4},
+		{`var f = def(a: string): any { a }; var g = f; g("x")`, "x"},
+		{"var f = def(): int { }; var g = def(): null { }; g()", Null},
+		// Errors pass every annotation, so they can still be handled.
+		{`var n: int = type.s2i("x"); type.tp(n)`, "Error"},
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`var f = def(a: int) { a }; var g = f; g("x")`, "Argument a to f must be int, got string"},
+		{`var f = def(a): int { a }; f("x")`, "The result of f must be int, got string"},
+		{`var f = def(): int { }; f()`, "The result of f must be int, got null"},
+		{`var s = string.upper("a"); var n: int = s`, "n must be int, got string"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("%q: compiler error: %s", tt.input, err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("%q: wrong VM error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestCountedLoops(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"var s = 0; for (var i = 0; i < 5; i = i + 1) { s = s + i }; s", 10},
+		{"var s = 0; for (var i = 1; i <= 4; i = i + 1) { s = s + i }; s", 10},
+		{"var s = 0; for (var i = 10; i > 0; i = i + -3) { s = s + i }; s", 22},
+		{"var s = 0; for (var i = 4; i >= 0; i = i + -2) { s = s + i }; s", 6},
+		{"var s = 0; for (var i = 5; i < 5; i = i + 1) { s = s + 1 }; s", 0},
+		// The limit is read again every iteration, and the body may move
+		// the counter.
+		{"var n = 3; var c = 0; for (var i = 0; i < n; i = i + 1) { n = 5; c = c + 1 }; c", 5},
+		{"var c = 0; for (var i = 0; i < 10; i = i + 1) { i = i + 2; c = c + 1 }; c", 4},
+		{`def() {
+			var s = 0
+			for (var i = 0; i < 10; i = i + 1) {
+				if (i == 2) { continue }
+				if (i == 5) { break }
+				s = s + i
+			}
+			s
+		}()`, 8},
+	})
+
+	// A counter that stops being an integer fails the way the separate
+	// instructions would: "a" + 1 is false, which can't be compared.
+	program := parse(`for (var i = 0; i < 3; i = i + 1) { i = "a" }`)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	if err == nil || !strings.HasPrefix(err.Error(), "TypeError: Unknown operator: 11 (INTEGER BOOLEAN)") {
+		t.Fatalf("expected the comparison to fail, got %v", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	input := `var pick = def(x) {
+		match (x) {
+			case 1, 2 { "small" }
+			case "a" { "letter" }
+			case true { "yes" }
+			case null { "nothing" }
+			case -1.5 { "negative" }
+			el { "other" }
+		}
+	}
+	`
+	runVmTests(t, []vmTestCase{
+		{input + "pick(1)", "small"},
+		{input + "pick(2)", "small"},
+		{input + `pick("a")`, "letter"},
+		{input + "pick(true)", "yes"},
+		{input + "pick(null)", "nothing"},
+		{input + "pick(-1.5)", "negative"},
+		{input + "pick(3)", "other"},
+		{input + `pick("b")`, "other"},
+		// 1 == 1.0 is false, so 1.0 doesn't match 1.
+		{input + "pick(1.0)", "other"},
+		// Values that can't be hashed never match.
+		{input + "pick([1])", "other"},
+		{"match (5) { case 1 { 1 } }", Null},
+		{"match (1) { case 1 { } }", Null},
+		{"match (1) { el { 2 } }", 2},
+		{"var y = 0; match (2) { case 1 { y = 1 } case 2 { y = 2 } }; y", 2},
+	})
+}
+
+func TestMatchPatterns(t *testing.T) {
+	input := `var describe = def(v) {
+		match (v) {
+			case {type: "user", name: n} { "user " + n }
+			case {type: "group", members: [first, _]} { "group of " + first }
+			case [x, y] if (x > y) { "down" }
+			case [x, y] { "pair" }
+			case (a, b, c) { "triple" }
+			case 0, "zero" { "nothing" }
+			case other { "other" }
+		}
+	}
+	`
+	runVmTests(t, []vmTestCase{
+		{input + `describe({"type": "user", "name": "ann", "age": 30})`, "user ann"},
+		{input + `describe({"type": "group", "members": ["bo", "cy"]})`, "group of bo"},
+		// A nested pattern that doesn't match moves on to the next arm.
+		{input + `describe({"type": "group", "members": ["bo"]})`, "other"},
+		{input + `describe({"name": "ann"})`, "other"},
+		{input + "describe([3, 1])", "down"},
+		{input + "describe([1, 3])", "pair"},
+		{input + "describe([1, 2, 3])", "other"},
+		{input + "describe((1, 2, 3))", "triple"},
+		{input + `describe("zero")`, "nothing"},
+		{input + "describe(0)", "nothing"},
+		{input + "describe(5)", "other"},
+		{"match ([1, [2, 3]]) { case [a, [b, c]] { a + b + c } }", 6},
+		{"match (5) { case [a] { a } }", Null},
+		{"match (5) { case [a] { a } el { 0 } }", 0},
+		// Bound names stay defined after the match, like var.
+		{"match ((1, 2)) { case (a, b) { } }; a + b", 3},
+		{"def(v) { match (v) { case [a, b] if (a == b) { a } el { -1 } } }([4, 4])", 4},
+		{"def(v) { match (v) { case [a, b] if (a == b) { a } el { -1 } } }([4, 5])", -1},
+	})
+}
+
+func TestForIn(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"var s = 0; for (x in [1, 2, 3]) { s = s + x }; s", 6},
+		{"var s = 0; for (i, x in [10, 20]) { s = s + i * x }; s", 20},
+		{`var s = ""; for (k in {"a": 1, "b": 2}) { s = s + k }; s`, "ab"},
+		{`var s = ""; for (k, v in {"a": 1, "b": 2}) { s = s + k + type.d2s(v) }; s`, "a1b2"},
+		{`var s = ""; for (c in "héllo") { s = c + s }; s`, "olléh"},
+		{"var s = 0; for (x in (1, 2)) { s = s + x }; s", 3},
+		{"var s = 0; for (x in set.new([3, 4])) { s = s + x }; s", 7},
+		{"var s = 0; for (x in []) { s = 1 }; s", 0},
+		{`var s = 0
+		for (x in [1, 2, 3, 4, 5]) {
+			if (x == 2) { continue }
+			if (x == 4) { break }
+			s = s + x
+		}
+		s`, 4},
+		{`def(arr) {
+			var s = 0
+			for (a in arr) {
+				for (b in arr) { s = s + a * b }
+			}
+			s
+		}([1, 2, 3])`, 36},
+		{"def(arr) { for (a in arr) { if (a > 1) { return a } }; 0 }([1, 5, 7])", 5},
+		// A loop ending a function leaves nothing to return.
+		{"def(arr) { for (a in arr) { a } }([1])", Null},
+		// The loop sees elements removed while it runs.
+		{"var a = [1, 2, 3, 4]; var n = 0; for (x in a) { n = n + 1; array.pop(a) }; n", 2},
+	})
+
+	program := parse("for (x in 5) { }")
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	if err == nil || !strings.Contains(err.Error(), "Cannot iterate over INTEGER") {
+		t.Fatalf("expected an error iterating over an integer, got %v", err)
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"attempt { 1 } rescue { 2 }", 1},
+		{"attempt { 1 / 0 } rescue { 2 }", 2},
+		{"attempt { 1 / 0 } rescue (e) { e.message }", "Division by zero"},
+		{"attempt { } rescue { 2 }", Null},
+		// Errors raised in functions the block calls unwind to it.
+		{"var f = def(x) { 10 / x }; var g = def() { f(0) + 1 }; attempt { g() } rescue (e) { e.message }", "Division by zero"},
+		// So do error values a statement of the block produces.
+		{"attempt { var s = type.d2s(); 1 } rescue (e) { 2 }", 2},
+		{`var f = def() { string.upper(1) }; attempt { f(); 1 } rescue (e) { e.message }`,
+			"Argument 0 to `upper` must be STRING, got INTEGER"},
+		// The stack is unwound to where the block started.
+		{"[1, attempt { [2, 3, 1 / 0] } rescue { 4 }, 5]", []interface{}{1, 4, 5}},
+		{"var f = def(x) { 1 + attempt { x / 0 } rescue { 10 } }; f(1)", 11},
+		// An error in the rescue block goes to the enclosing attempt.
+		{`attempt { attempt { 1 / 0 } rescue { 2 / 0 } } rescue (e) { "outer" }`, "outer"},
+		// Returning, breaking or continuing out of a block ends it.
+		{"var f = def() { attempt { return 1 } rescue { 2 } }; f(); attempt { f(); 1 / 0 } rescue { 3 }", 3},
+		{"var f = def() { attempt { return 1 } rescue { 2 } }; var g = def() { 1 / 0 }; attempt { f(); g() } rescue { 3 }", 3},
+		{`var n = 0
+		for (x in [1, 2, 3]) {
+			attempt {
+				if (x == 1) { continue }
+				if (x == 3) { break }
+				n = n + x
+			} rescue { n = 100 }
+		}
+		attempt { 1 / 0 } rescue { n }`, 2},
+		{`var n = 0
+		for (x in [1, 2, 0, 4]) {
+			n = n + attempt { 12 / x } rescue { 100 }
+		}
+		n`, 121},
+	})
+
+	// Running out of instructions can't be rescued.
+	saved := object.SysMaxInstructionCount
+	object.SysMaxInstructionCount = 1000
+	defer func() { object.SysMaxInstructionCount = saved }()
+	program := parse("attempt { while (true) { } } rescue { 1 }")
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	if err == nil || !strings.Contains(err.Error(), "max instruction count exceeded") {
+		t.Fatalf("expected the instruction limit to stop the program, got %v", err)
+	}
+}
+
+func TestClasses(t *testing.T) {
+	point := `class Point {
+		def init(x, y) {
+			self.x = x
+			self.y = y
+		}
+		def add(other) { Point.new(self.x + other.x, self.y + other.y) }
+		def sum() { self.x + self.y }
+	}
+	`
+	runVmTests(t, []vmTestCase{
+		{point + "var p = Point.new(1, 2); p.x", 1},
+		{point + "Point.new(1, 2).add(Point.new(10, 20)).sum()", 33},
+		// Methods read from an instance stay bound to it.
+		{point + "var s = Point.new(3, 4).sum; s()", 7},
+		{point + "var p = Point.new(1, 2); p.y = 5; p.sum()", 6},
+		// Setting a field evaluates to null, so it prints nothing at the top level.
+		{point + "Point.new(1, 2).x = 3", Null},
+		{point + "Point.new(1, 2).z", Null},
+		{point + "var p = Point.new(1, 2); var q = type.clone(p); q.x = 5; p.x", 1},
+		// new returns the instance whatever init returns.
+		{"class A { def init() { self.n = 1; return 5 } }; A.new().n", 1},
+		{"class A { def get() { self } }; var a = A.new(); a.get() == a", true},
+		{`class Counter {
+			def init() { self.n = 0 }
+			def add(k) { self.n = self.n + k; self }
+		}
+		var f = def() {
+			var c = Counter.new()
+			var step = def() { c.add(2) }
+			step(); step()
+			c.add(1).n
+		}
+		f()`, 5},
+		// Fields can be set on hashes too.
+		{`var h = {"a": 1}; h.b = 2; h.a + h.b`, 3},
+		{point + "attempt { Point.new(1) } rescue (e) { e.message }", "Wrong number of arguments. Expected 2, got 1"},
+		{point + "attempt { Point.new(1, 2).add() } rescue (e) { e.message }", "Wrong number of arguments. Expected 1, got 0"},
+		{"class A { }; attempt { A.new(1) } rescue (e) { e.message }", "Wrong number of arguments. Expected 0, got 1"},
+		{"class A { }; attempt { A.x } rescue (e) { e.message }", "Class A has no field x; only A.new"},
+		{"class A { }; var a = type.freeze(A.new()); attempt { a.x = 1 } rescue (e) { e.message }", "Cannot modify a frozen INSTANCE"},
+		{"attempt { var n = 1; n.x = 1 } rescue (e) { e.message }", "Cannot set field x of INTEGER"},
+	})
+}